@@ -0,0 +1,506 @@
+// Command chaos measures what instance failures cost under each policy. For
+// every policy it starts a group of servers, runs load against them, kills
+// and restarts instances on a schedule (or at random), and counts the
+// requests that failed and how: refused, reset, cut off, timed out. Each
+// outage also reports the SYNs the kernel dropped while it lasted, by drop
+// reason, from a kfree_skb tracepoint. This is the hot-standby story
+// reuse-port-simple demonstrates, with numbers.
+//
+//	chaos -policy hot-standby,pickfirst,round-robin -instances 2 \
+//	      -schedule 5s:kill:0,10s:start:0 -duration 20s
+//	chaos -policy round-robin -instances 4 -random 4s -downtime 2s
+//
+// kill sends SIGKILL, as a crash would; stop sends SIGTERM and lets the
+// instance drain; start brings the slot back. It needs the privileges the
+// servers need, and leaves nothing pinned behind when every run ends with
+// its instances drained.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"go-http-server/launcher"
+	"go-http-server/reuseportlb"
+)
+
+// action is one scheduled change to the group.
+type action struct {
+	At   time.Duration
+	Op   string // kill, stop or start
+	Slot int
+}
+
+// parseSchedule reads "5s:kill:0,10s:start:0".
+func parseSchedule(s string) ([]action, error) {
+	var out []action
+	for _, item := range strings.Split(s, ",") {
+		parts := strings.Split(strings.TrimSpace(item), ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("schedule entry %q is not <time>:<kill|stop|start>:<slot>", item)
+		}
+		at, err := time.ParseDuration(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("schedule entry %q: %w", item, err)
+		}
+		switch parts[1] {
+		case "kill", "stop", "start":
+		default:
+			return nil, fmt.Errorf("schedule entry %q: unknown action %q", item, parts[1])
+		}
+		slot, err := strconv.Atoi(parts[2])
+		if err != nil || slot < 0 {
+			return nil, fmt.Errorf("schedule entry %q: invalid slot", item)
+		}
+		out = append(out, action{At: at, Op: parts[1], Slot: slot})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].At < out[j].At })
+	return out, nil
+}
+
+// outcome is how one request ended.
+type outcome struct {
+	at   time.Duration
+	kind string
+	slot int
+}
+
+// classify names a request failure.
+func classify(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "refused"
+	case errors.Is(err, syscall.ECONNRESET):
+		return "reset"
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "eof"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	}
+	return "other"
+}
+
+var failureKinds = []string{"refused", "reset", "eof", "timeout", "status", "other"}
+
+// outage is a slot being down, from the action taking it down until it was
+// serving again.
+type outage struct {
+	Op   string        `json:"op"`
+	Slot int           `json:"slot"`
+	From time.Duration `json:"from_ns"`
+	To   time.Duration `json:"to_ns"`
+	// Failures are the requests that failed meanwhile, by kind.
+	Failures map[string]int `json:"failures"`
+	// SynDrops are the SYNs the kernel dropped meanwhile, by drop reason.
+	SynDrops map[string]uint64 `json:"syn_drops,omitempty"`
+
+	dropsBefore map[string]uint64
+}
+
+// endDrops records the SYN drops since the outage began.
+func (o *outage) endDrops(now map[string]uint64) {
+	if o.dropsBefore != nil && now != nil {
+		o.SynDrops = reuseportlb.DropsSince(o.dropsBefore, now)
+	}
+}
+
+type result struct {
+	Policy   string            `json:"policy"`
+	Requests int               `json:"requests"`
+	OK       int               `json:"ok"`
+	Failures map[string]int    `json:"failures"`
+	SynDrops map[string]uint64 `json:"syn_drops,omitempty"`
+	Served   []int             `json:"served"`
+	Outages  []*outage         `json:"outages"`
+}
+
+type experiment struct {
+	opts      launcher.Options
+	instances int
+	duration  time.Duration
+	clients   int
+	schedule  []action
+	random    time.Duration
+	downtime  time.Duration
+	kill      bool
+	rng       *rand.Rand
+	timeout   time.Duration
+	// drops, if set, counts the SYNs the kernel dropped.
+	drops *reuseportlb.DropCounter
+
+	mu      sync.Mutex
+	start   time.Time
+	servers []*launcher.Server
+	outages []*outage
+	open    map[int]*outage
+}
+
+func main() {
+	if err := run(); err != nil {
+		reuseportlb.Fatal("chaos failed", "err", err)
+	}
+}
+
+// run runs the experiment per policy and prints the results. Failures are
+// returned rather than fatal, so the deferred calls detach the drop counter.
+func run() error {
+	var e experiment
+	flag.StringVar(&e.opts.Path, "server", filepath.Join("bin", runtime.GOARCH, "server_code"), "server binary")
+	policyList := flag.String("policy", "hot-standby,pickfirst,round-robin", "comma-separated policies to run one after another")
+	flag.StringVar(&e.opts.Addr, "addr", "127.0.0.1:8080", "address the servers share")
+	flag.IntVar(&e.instances, "instances", 2, "number of servers")
+	flag.DurationVar(&e.duration, "duration", 20*time.Second, "how long load runs per policy")
+	flag.IntVar(&e.clients, "clients", 8, "concurrent clients, each sending one request per connection")
+	schedule := flag.String("schedule", "5s:kill:0,10s:start:0", "comma-separated <time>:<kill|stop|start>:<slot> actions")
+	flag.DurationVar(&e.random, "random", 0, "instead of -schedule, take a random instance down this often")
+	flag.DurationVar(&e.downtime, "downtime", 3*time.Second, "with -random, how long an instance stays down")
+	signal := flag.String("signal", "kill", "with -random, how instances go down: kill (crash) or stop (drain)")
+	seed := flag.Int64("seed", 1, "seed for -random")
+	flag.DurationVar(&e.timeout, "ready-timeout", 30*time.Second, "how long a server may take to join the group")
+	verbose := flag.Bool("v", false, "pass the servers' logs through")
+	asJSON := flag.Bool("json", false, "print JSON instead of a table")
+	synDrops := flag.Bool("syn-drops", true, "count the SYNs the kernel drops, by reason (Linux 5.17+)")
+	flag.Parse()
+
+	if *verbose {
+		e.opts.Log = os.Stderr
+	}
+	if e.instances < 1 || e.clients < 1 {
+		return errors.New("-instances and -clients must be positive")
+	}
+	switch *signal {
+	case "kill", "stop":
+		e.kill = *signal == "kill"
+	default:
+		return fmt.Errorf("invalid -signal %q", *signal)
+	}
+	if e.random == 0 {
+		var err error
+		if e.schedule, err = parseSchedule(*schedule); err != nil {
+			return fmt.Errorf("invalid -schedule: %w", err)
+		}
+		for _, a := range e.schedule {
+			if a.Slot >= e.instances {
+				return fmt.Errorf("schedule names slot %d, beyond -instances", a.Slot)
+			}
+		}
+	}
+
+	if *synDrops {
+		_, portStr, err := net.SplitHostPort(e.opts.Addr)
+		if err != nil {
+			return fmt.Errorf("invalid -addr: %w", err)
+		}
+		port, err := strconv.ParseUint(portStr, 10, 16)
+		if err != nil {
+			return fmt.Errorf("invalid -addr port %q", portStr)
+		}
+		if e.drops, err = reuseportlb.StartDropCounter(uint16(port)); err != nil {
+			slog.Warn("counting kernel SYN drops failed, reporting client-side failures only", "err", err)
+		} else {
+			defer e.drops.Close()
+		}
+	}
+
+	var results []result
+	for _, policy := range strings.Split(*policyList, ",") {
+		e.opts.Policy = strings.TrimSpace(policy)
+		e.rng = rand.New(rand.NewSource(*seed))
+		r, err := e.run()
+		if err != nil {
+			return fmt.Errorf("experiment with policy %s: %w", e.opts.Policy, err)
+		}
+		results = append(results, r)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(results)
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "policy\trequests\tok\t%s\tfailed\tserved\n", strings.Join(failureKinds, "\t"))
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%d\t%d\t", r.Policy, r.Requests, r.OK)
+		for _, k := range failureKinds {
+			fmt.Fprintf(w, "%d\t", r.Failures[k])
+		}
+		fmt.Fprintf(w, "%.2f%%\t%v\n", 100*float64(r.Requests-r.OK)/float64(max(r.Requests, 1)), r.Served)
+	}
+	w.Flush()
+	for _, r := range results {
+		if r.SynDrops != nil {
+			fmt.Printf("%s: kernel dropped SYNs: %s\n", r.Policy, formatCounts(r.SynDrops))
+		}
+		for _, o := range r.Outages {
+			failed := 0
+			for _, n := range o.Failures {
+				failed += n
+			}
+			fmt.Printf("%s: %s slot %d at %s, back after %s: %d failed requests (%s)",
+				r.Policy, o.Op, o.Slot, o.From.Round(time.Millisecond), (o.To - o.From).Round(time.Millisecond),
+				failed, formatCounts(o.Failures))
+			if o.SynDrops != nil {
+				fmt.Printf(", kernel dropped SYNs: %s", formatCounts(o.SynDrops))
+			}
+			fmt.Println()
+		}
+	}
+	return nil
+}
+
+// formatCounts renders counts as "a=1 b=2", sorted by key, or "none".
+func formatCounts[V int | uint64](counts map[string]V) string {
+	keys := make([]string, 0, len(counts))
+	for k, n := range counts {
+		if n > 0 {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return "none"
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%s=%d", k, counts[k])
+	}
+	return strings.Join(parts, " ")
+}
+
+// dropCounts snapshots the kernel's SYN drop counts, or returns nil when
+// they are not being counted.
+func (e *experiment) dropCounts() map[string]uint64 {
+	if e.drops == nil {
+		return nil
+	}
+	counts, err := e.drops.Counts()
+	if err != nil {
+		slog.Warn("reading kernel SYN drops failed", "err", err)
+		return nil
+	}
+	return counts
+}
+
+// run runs load against a fresh group under the current policy.
+func (e *experiment) run() (result, error) {
+	e.servers = make([]*launcher.Server, e.instances)
+	e.outages, e.open = nil, make(map[int]*outage)
+	defer func() {
+		for _, s := range e.servers {
+			if s != nil {
+				s.Stop(15 * time.Second)
+			}
+		}
+	}()
+	// Server 0 loads the policy, so it goes first.
+	for slot := range e.servers {
+		if err := e.startSlot(slot); err != nil {
+			return result{}, err
+		}
+	}
+	slog.Info("group up, starting load", "policy", e.opts.Policy, "instances", e.instances)
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.duration)
+	defer cancel()
+	e.start = time.Now()
+	dropsBefore := e.dropCounts()
+
+	outcomes := make(chan outcome, 1024)
+	var clients sync.WaitGroup
+	for i := 0; i < e.clients; i++ {
+		clients.Add(1)
+		go func() {
+			defer clients.Done()
+			e.load(ctx, outcomes)
+		}()
+	}
+	go func() {
+		clients.Wait()
+		close(outcomes)
+	}()
+	go e.chaos(ctx)
+
+	r := result{Policy: e.opts.Policy, Failures: make(map[string]int), Served: make([]int, e.instances)}
+	var all []outcome
+	for o := range outcomes {
+		r.Requests++
+		if o.kind == "ok" {
+			r.OK++
+			if o.slot >= 0 && o.slot < len(r.Served) {
+				r.Served[o.slot]++
+			}
+		} else {
+			r.Failures[o.kind]++
+		}
+		all = append(all, o)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	end := time.Since(e.start)
+	dropsAfter := e.dropCounts()
+	for _, o := range e.open {
+		o.To = end
+		o.endDrops(dropsAfter)
+	}
+	if dropsBefore != nil && dropsAfter != nil {
+		r.SynDrops = reuseportlb.DropsSince(dropsBefore, dropsAfter)
+	}
+	for _, out := range e.outages {
+		out.Failures = make(map[string]int)
+		for _, o := range all {
+			if o.kind != "ok" && o.at >= out.From && o.at < out.To {
+				out.Failures[o.kind]++
+			}
+		}
+	}
+	r.Outages = e.outages
+	return r, nil
+}
+
+func (e *experiment) startSlot(slot int) error {
+	s, err := launcher.Start(e.opts, slot)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	e.servers[slot] = s
+	e.mu.Unlock()
+	return s.WaitReady(e.timeout)
+}
+
+// chaos carries out the schedule, or takes random instances down.
+func (e *experiment) chaos(ctx context.Context) {
+	if e.random > 0 {
+		ticker := time.NewTicker(e.random)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			slot := e.rng.Intn(e.instances)
+			op := "stop"
+			if e.kill {
+				op = "kill"
+			}
+			e.do(action{Op: op, Slot: slot})
+			go func() {
+				select {
+				case <-ctx.Done():
+				case <-time.After(e.downtime):
+					e.do(action{Op: "start", Slot: slot})
+				}
+			}()
+		}
+	}
+	for _, a := range e.schedule {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(e.start.Add(a.At))):
+		}
+		e.do(a)
+	}
+}
+
+func (e *experiment) do(a action) {
+	e.mu.Lock()
+	s := e.servers[a.Slot]
+	now := time.Since(e.start)
+	switch a.Op {
+	case "kill", "stop":
+		if s == nil {
+			e.mu.Unlock()
+			return
+		}
+		e.servers[a.Slot] = nil
+		o := &outage{Op: a.Op, Slot: a.Slot, From: now, dropsBefore: e.dropCounts()}
+		e.outages = append(e.outages, o)
+		e.open[a.Slot] = o
+	case "start":
+		if s != nil {
+			e.mu.Unlock()
+			return
+		}
+	}
+	e.mu.Unlock()
+
+	slog.Info("chaos", "op", a.Op, "slot", a.Slot, "at", now.Round(time.Millisecond))
+	switch a.Op {
+	case "kill":
+		s.Kill()
+	case "stop":
+		s.Stop(15 * time.Second)
+	case "start":
+		if err := e.startSlot(a.Slot); err != nil {
+			slog.Error("restarting instance failed", "slot", a.Slot, "err", err)
+			return
+		}
+		e.mu.Lock()
+		if o := e.open[a.Slot]; o != nil {
+			o.To = time.Since(e.start)
+			o.endDrops(e.dropCounts())
+			delete(e.open, a.Slot)
+		}
+		e.mu.Unlock()
+	}
+}
+
+// load sends requests, each on a new connection so every one goes through
+// the selector, until ctx is done.
+func (e *experiment) load(ctx context.Context, out chan<- outcome) {
+	client := &http.Client{
+		Timeout:   2 * time.Second,
+		Transport: &http.Transport{DisableKeepAlives: true},
+	}
+	for ctx.Err() == nil {
+		o := outcome{at: time.Since(e.start), slot: -1}
+		resp, err := client.Get("http://" + e.opts.Addr + "/whoami")
+		switch {
+		case err != nil:
+			o.kind = classify(err)
+		case resp.StatusCode != http.StatusOK:
+			o.kind = "status"
+		default:
+			var id struct {
+				Slot int `json:"slot"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&id); err != nil {
+				o.kind = classify(err)
+			} else {
+				o.kind, o.slot = "ok", id.Slot
+			}
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		if ctx.Err() != nil && o.kind != "ok" {
+			// Cut off by the end of the run, not by the group.
+			return
+		}
+		out <- o
+	}
+}
@@ -1,346 +1,124 @@
 package main
 
 import (
-	"bufio"
 	"context"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
-	"os/exec"
 	"os/signal"
-	"path/filepath"
-	"strconv"
-	"strings"
 	"syscall"
-	"time"
+	"text/tabwriter"
 
-	"github.com/cilium/ebpf"
+	"go-http-server/reuseportlb"
 )
 
-var (
-	updateInterval      = 50 * time.Millisecond
-	alpha               = 0.25
-	mapPath             = "/sys/fs/bpf/cpu_util_map"
-	acceptqStatsMapPath = "/sys/fs/bpf/acceptq_map"
-	acceptqSlotMapPath  = "/sys/fs/bpf/acceptq_slot_cookies"
-	acceptqProgObj      = "server_code/eBPF/acceptq_bpf.o"
-	acceptqProgPin      = "/sys/fs/bpf/acceptq_bpf"
-	maxCores            = 64
-)
-
-type CPUStat struct {
-	User, Nice, System, Idle, IOWait, IRQ, SoftIRQ, Steal, Guest, GuestNice uint64
-}
-
-type acceptqEntry struct {
-	Curr uint32
-	Max  uint32
-	Cpu  uint32
-}
-
-func readCPUStat() (map[int]CPUStat, error) {
-	f, err := os.Open("/proc/stat")
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
-
-	stats := make(map[int]CPUStat)
-	scanner := bufio.NewScanner(f)
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		if !strings.HasPrefix(line, "cpu") || line == "cpu " {
-			continue
+// flagSet reports whether the named flag was given on the command line.
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
 		}
-
-		var cpu int
-		var s CPUStat
-		_, err := fmt.Sscanf(line, "cpu%d %d %d %d %d %d %d %d %d %d %d",
-			&cpu, &s.User, &s.Nice, &s.System, &s.Idle,
-			&s.IOWait, &s.IRQ, &s.SoftIRQ, &s.Steal, &s.Guest, &s.GuestNice)
-		if err != nil {
-			continue
-		}
-		stats[cpu] = s
-	}
-
-	if err := scanner.Err(); err != nil {
-		return nil, err
-	}
-	return stats, nil
-}
-
-func calculateUtilization(prev, curr CPUStat) float64 {
-	prevIdle := prev.Idle + prev.IOWait
-	currIdle := curr.Idle + curr.IOWait
-	prevTotal := prev.User + prev.Nice + prev.System + prevIdle + prev.IRQ + prev.SoftIRQ + prev.Steal
-	currTotal := curr.User + curr.Nice + curr.System + currIdle + curr.IRQ + curr.SoftIRQ + curr.Steal
-
-	totald := float64(currTotal - prevTotal)
-	idled := float64(currIdle - prevIdle)
-
-	if totald == 0 {
-		return 0.0
-	}
-	return (1.0 - idled/totald) * 100.0
-}
-
-func loadOrCreateMap(path string) (*ebpf.Map, error) {
-	m, err := ebpf.LoadPinnedMap(path, nil)
-	if err == nil {
-		log.Printf("Found pinned map at %s", path)
-		return m, nil
-	}
-
-	log.Printf("Pinned map not found, creating new one at %s...", path)
-
-	spec := &ebpf.MapSpec{
-		Type:       ebpf.Array,
-		KeySize:    4,
-		ValueSize:  4,
-		MaxEntries: uint32(maxCores),
-		Name:       "cpu_util_map",
-	}
-
-	m, err = ebpf.NewMap(spec)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create new map: %v", err)
-	}
-
-	if err := m.Pin(path); err != nil {
-		return nil, fmt.Errorf("failed to pin map: %v", err)
-	}
-
-	log.Printf("Created and pinned map at %s", path)
-	return m, nil
-}
-
-func ensureAcceptqProgramLoaded() (func(), error) {
-	if _, err := os.Stat(acceptqProgPin); err == nil {
-		log.Printf("Accept queue program already pinned at %s, not reloading", acceptqProgPin)
-		return nil, nil
-	} else if !os.IsNotExist(err) {
-		return nil, fmt.Errorf("failed to stat %s: %w", acceptqProgPin, err)
-	}
-
-	objPath, err := filepath.Abs(acceptqProgObj)
-	if err != nil {
-		return nil, fmt.Errorf("failed to resolve path to %s: %w", acceptqProgObj, err)
-	}
-
-	cmd := exec.Command("sudo", "bpftool", "prog", "load",
-		objPath, acceptqProgPin, "type", "kprobe", "autoattach")
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return nil, fmt.Errorf("bpftool load failed: %v (output: %s)", err, strings.TrimSpace(string(output)))
-	}
-
-	log.Printf("Loaded accept queue BPF program from %s to %s", objPath, acceptqProgPin)
-
-	cleanup := func() {
-		cmd := exec.Command("sudo", "rm", "-f", acceptqProgPin)
-		output, err := cmd.CombinedOutput()
-		if err != nil {
-			log.Printf("failed to remove pinned accept queue program %s: %v (output: %s)", acceptqProgPin, err, strings.TrimSpace(string(output)))
-			return
-		}
-		log.Printf("Removed pinned accept queue program at %s", acceptqProgPin)
-	}
-
-	return cleanup, nil
+	})
+	return set
 }
 
 func main() {
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
-	cpuCoresStr := flag.String("cpus", "0 1 2 3", "space-separated list of CPU cores to monitor (e.g., \"0 1 2 3\")")
-	logDir := flag.String("logdir", "log", "directory where log files will be written")
-	logPeriod := flag.Duration("period", time.Second, "interval between log snapshots")
+	cfg := reuseportlb.DefaultCollectorConfig()
+	cpuCoresStr := flag.String("cpus", "", "CPU cores to monitor, as numbers and ranges (e.g. \"0 1 2 3\" or \"0-7,16-23\"); empty monitors every online core, following hotplug")
+	housekeepingStr := flag.String("housekeeping-cpus", "", "CPU cores left to the kernel and background work, as numbers and ranges, or auto for every online core not isolated with isolcpus= or nohz_full=; slot utilization then only counts the owners' other, application, cores")
+	maxCPUs := flag.Int("max-cpus", 0, "cores cpu_util_map has room for; 0 sizes it for every possible CPU of the host (at least "+fmt.Sprint(reuseportlb.DefaultMaxCPUs)+", at most 1024)")
+	flag.StringVar(&cfg.LogDir, "logdir", cfg.LogDir, "directory where log files will be written")
+	flag.DurationVar(&cfg.Period, "period", cfg.Period, "interval between log snapshots")
+	flag.StringVar(&cfg.AcceptqReduce, "acceptq-reduce", cfg.AcceptqReduce, "how per-CPU accept queue entries are aggregated: sum or max")
+	flag.StringVar(&cfg.AcceptqHook, "acceptq-hook", cfg.AcceptqHook, "how the accept queue tracker attaches: auto, fentry or kprobe")
+	logLevel := flag.String("log-level", "info", "minimum log level: debug, info, warn or error")
+	logFormat := flag.String("log-format", "text", "log output format: text or json")
+	adminAddr := flag.String("admin-addr", "", "address for the admin server (pprof, expvar); empty disables it")
+	flag.DurationVar(&cfg.Interval, "interval", cfg.Interval, "interval between CPU samples and map updates")
+	flag.Float64Var(&cfg.Alpha, "alpha", cfg.Alpha, "EWMA smoothing factor for CPU utilization (0 < alpha <= 1); higher reacts faster")
+	flag.BoolVar(&cfg.Adaptive, "adaptive", cfg.Adaptive, "adapt the smoothing factor per core to how noisy its utilization is, within [-alpha-min, -alpha-max]")
+	flag.Float64Var(&cfg.AlphaMin, "alpha-min", cfg.AlphaMin, "lower bound for the smoothing factor in -adaptive mode")
+	flag.Float64Var(&cfg.AlphaMax, "alpha-max", cfg.AlphaMax, "upper bound for the smoothing factor in -adaptive mode")
+	flag.BoolVar(&cfg.Events, "events", cfg.Events, "take accept queue depths from tracker notifications instead of polling, and sample CPUs every "+reuseportlb.EventDrivenInterval.String()+" unless -interval is given")
+	flag.BoolVar(&cfg.Latency, "latency", cfg.Latency, "attach accept-to-response latency probes and log per-slot quantiles")
+	flag.BoolVar(&cfg.ConnStats, "conn-stats", cfg.ConnStats, "track connection lifetimes, bytes and retransmits per slot with sock_ops and log them")
+	flag.BoolVar(&cfg.NetDistress, "net-distress", cfg.NetDistress, "count retransmits and ECN window reductions per slot, publish them in slot_net for the net health signal, and log them")
+	flag.BoolVar(&cfg.CgroupUtil, "cgroup-util", cfg.CgroupUtil, "derive slot utilization from the CPU time of each slot owner's cgroup (servers run with -cgroup) instead of from its cores")
+	flag.StringVar(&cfg.CPUSource, "cpu-source", cfg.CPUSource, "where CPU utilization is read from: stat (/proc/stat ticks), schedstat (/proc/schedstat run time) or psi (slot utilization from each owner's cgroup CPU pressure)")
+	deadband := flag.Uint("update-deadband", uint(cfg.UpdateDeadband), "hundredths of a percent a utilization may move before its map entry is written again (0 writes any change)")
+	flag.DurationVar(&cfg.UpdateRefresh, "update-refresh", cfg.UpdateRefresh, "how often unchanged utilization entries are rewritten anyway; 0 writes every entry every -interval")
+	flag.Float64Var(&cfg.MaxUpdateRate, "max-update-rate", cfg.MaxUpdateRate, "most utilization map entries written per second, biggest changes first; 0 for no limit")
+	flag.BoolVar(&cfg.Power, "power", cfg.Power, "read each CPU package's power draw from RAPL (powercap), log it and publish it as the package_power expvar")
+	compare := flag.Duration("compare-cpu-sources", 0, "instead of collecting, sample -cpus through every CPU source for this long and report how noisy each is")
+	groupName := flag.String("group", "", "reuseport group whose slot maps are maintained (default group if empty)")
 	flag.Parse()
 
-	cpuCores := []int{}
-	for _, s := range strings.Fields(*cpuCoresStr) {
-		core, err := strconv.Atoi(s)
-		if err != nil {
-			log.Fatalf("invalid CPU core number: %s", s)
-		}
-		cpuCores = append(cpuCores, core)
-	}
-	if len(cpuCores) == 0 {
-		log.Fatalf("no CPU cores specified")
+	logger, err := reuseportlb.NewLogger(os.Stderr, *logLevel, *logFormat)
+	if err != nil {
+		reuseportlb.Fatal("invalid logging flags", "err", err)
 	}
+	slog.SetDefault(logger)
 
-	if err := os.MkdirAll(*logDir, 0o755); err != nil {
-		log.Fatalf("failed to create log directory %s: %v", *logDir, err)
+	if *adminAddr != "" {
+		if _, err := reuseportlb.ServeAdmin(*adminAddr, reuseportlb.NewAdminMux()); err != nil {
+			reuseportlb.Fatal("unable to start admin server", "addr", *adminAddr, "err", err)
+		}
 	}
 
-	timestamp := time.Now().Format("20060102_150405")
-	cpuLogPath := filepath.Join(*logDir, fmt.Sprintf("cpu_stats_%s.log", timestamp))
-	acceptqLogPath := filepath.Join(*logDir, fmt.Sprintf("acceptq_stats_%s.log", timestamp))
-
-	cpuLogFile, err := os.OpenFile(cpuLogPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
-	if err != nil {
-		log.Fatalf("failed to open CPU log file: %v", err)
+	if cfg.Events && !flagSet("interval") {
+		cfg.Interval = reuseportlb.EventDrivenInterval
 	}
-	defer cpuLogFile.Close()
-	cpuLogger := log.New(cpuLogFile, "", log.LstdFlags)
-
-	acceptqLogFile, err := os.OpenFile(acceptqLogPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	cfg.Group, err = reuseportlb.ParseGroup(*groupName)
 	if err != nil {
-		log.Fatalf("failed to open accept queue log file: %v", err)
+		reuseportlb.Fatal("invalid -group", "err", err)
 	}
-	defer acceptqLogFile.Close()
-	acceptqLogger := log.New(acceptqLogFile, "", log.LstdFlags)
-
-	m, err := loadOrCreateMap(mapPath)
+	if *maxCPUs != 0 {
+		if err := reuseportlb.SetMaxCPUs(*maxCPUs); err != nil {
+			reuseportlb.Fatal("invalid -max-cpus", "err", err)
+		}
+	}
+	cfg.CPUs, err = reuseportlb.ParseCPUList(*cpuCoresStr)
 	if err != nil {
-		log.Fatalf("Error setting up cpu util map: %v", err)
+		reuseportlb.Fatal("invalid -cpus", "err", err)
 	}
-	defer m.Close()
-
-	acceptqCleanup, err := ensureAcceptqProgramLoaded()
+	cfg.HousekeepingCPUs, err = reuseportlb.ParseHousekeepingCPUs(*housekeepingStr)
 	if err != nil {
-		log.Fatalf("failed to ensure accept queue program is loaded: %v", err)
+		reuseportlb.Fatal("invalid -housekeeping-cpus", "err", err)
 	}
-	if acceptqCleanup != nil {
-		defer acceptqCleanup()
+	if *deadband > 10000 {
+		reuseportlb.Fatal("invalid -update-deadband: must be at most 10000 (100%)")
 	}
-
-	var acceptqStatsMap *ebpf.Map
-	var acceptqSlotMap *ebpf.Map
-	defer func() {
-		if acceptqStatsMap != nil {
-			acceptqStatsMap.Close()
-		}
-		if acceptqSlotMap != nil {
-			acceptqSlotMap.Close()
-		}
-	}()
-
-	log.Printf("Monitoring CPU cores %v", cpuCores)
-	log.Printf("Update interval: %v, smoothing alpha: %.2f", updateInterval, alpha)
-	log.Printf("CPU stats log path: %s", cpuLogPath)
-	log.Printf("Accept queue stats log path: %s", acceptqLogPath)
-
-	prevStats, err := readCPUStat()
-	if err != nil {
-		log.Fatalf("failed to read /proc/stat: %v", err)
+	cfg.UpdateDeadband = uint32(*deadband)
+	if err := cfg.Validate(); err != nil {
+		reuseportlb.Fatal("invalid collector settings", "err", err)
 	}
-
-	runningAvg := make(map[int]float64)
-	instUtilByCore := make(map[int]float64)
-	mapValueByCore := make(map[int]uint32)
-	acceptqEntryBySlot := make(map[uint32]acceptqEntry)
-	slotCookieBySlot := make(map[uint32]uint64)
-
-	updateTicker := time.NewTicker(updateInterval)
-	defer updateTicker.Stop()
-
-	ticker := time.NewTicker(*logPeriod)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ctx.Done():
-			log.Println("Received shutdown signal, exiting")
-			return
-		case <-updateTicker.C:
-		}
-
-		currStats, err := readCPUStat()
+	if *compare > 0 {
+		reports, err := reuseportlb.CompareCPUSources(ctx, cfg.CPUs, cfg.Interval, *compare)
 		if err != nil {
-			log.Printf("error reading /proc/stat: %v", err)
-			continue
+			reuseportlb.Fatal("comparing CPU sources failed", "err", err)
 		}
-
-		for _, coreID := range cpuCores {
-			prev, ok1 := prevStats[coreID]
-			curr, ok2 := currStats[coreID]
-			if !ok1 || !ok2 {
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(w, "source\tsamples\tmean %%\tstdev\tjitter\t\n")
+		for _, r := range reports {
+			if r.Err != "" {
+				fmt.Fprintf(w, "%s\t-\t-\t-\t-\tunavailable: %s\n", r.Source, r.Err)
 				continue
 			}
-
-			instUtil := calculateUtilization(prev, curr)
-			instUtilByCore[coreID] = instUtil
-
-			oldAvg := runningAvg[coreID]
-			newAvg := alpha*instUtil + (1-alpha)*oldAvg
-			runningAvg[coreID] = newAvg
-
-			var key uint32 = uint32(coreID)
-			value := uint32(newAvg * 100)
-			mapValueByCore[coreID] = value
-
-			if err := m.Update(&key, &value, ebpf.UpdateAny); err != nil {
-				log.Printf("failed to update CPU map for core %d: %v", coreID, err)
-			} else {
-				log.Printf("CPU %d: inst=%.1f%% avg=%.1f%% (key=%d map=%d)", coreID, instUtil, newAvg, key, value)
-			}
+			fmt.Fprintf(w, "%s\t%d\t%.1f\t%.2f\t%.2f\t\n", r.Source, r.Samples, r.Mean, r.Stdev, r.Jitter)
 		}
+		w.Flush()
+		return
+	}
+	if err := reuseportlb.Preflight("", cfg.Latency || cfg.ConnStats || cfg.NetDistress); err != nil {
+		reuseportlb.Fatal("missing privileges", "err", err)
+	}
 
-		prevStats = currStats
-
-		select {
-		case <-ctx.Done():
-			log.Println("Received shutdown signal, exiting")
-			return
-		case <-ticker.C:
-			ts := time.Now().Format(time.RFC3339)
-			for _, coreID := range cpuCores {
-				cpuLogger.Printf("ts=%s cpu=%d inst=%.2f avg=%.2f map=%d", ts, coreID, instUtilByCore[coreID], runningAvg[coreID], mapValueByCore[coreID])
-			}
-
-			if acceptqSlotMap == nil {
-				if m, err := ebpf.LoadPinnedMap(acceptqSlotMapPath, nil); err == nil {
-					acceptqSlotMap = m
-					log.Printf("Connected to accept queue slot map at %s", acceptqSlotMapPath)
-				} else {
-					acceptqLogger.Printf("ts=%s slot_map_unavailable err=%v", ts, err)
-					continue
-				}
-			}
-
-			if acceptqStatsMap == nil {
-				if m, err := ebpf.LoadPinnedMap(acceptqStatsMapPath, nil); err == nil {
-					acceptqStatsMap = m
-					log.Printf("Connected to accept queue stats map at %s", acceptqStatsMapPath)
-				} else {
-					acceptqLogger.Printf("ts=%s stats_map_unavailable err=%v", ts, err)
-					continue
-				}
-			}
-
-			for slot := range cpuCores {
-				var slotKey uint32 = uint32(slot)
-				var cookie uint64
-				if err := acceptqSlotMap.Lookup(&slotKey, &cookie); err != nil || cookie == 0 {
-					if err != nil {
-						acceptqLogger.Printf("ts=%s slot=%d cookie_lookup_err=%v", ts, slotKey, err)
-					} else {
-						acceptqLogger.Printf("ts=%s slot=%d cookie=0", ts, slotKey)
-					}
-					continue
-				}
-				slotCookieBySlot[slotKey] = cookie
-
-				var entry acceptqEntry
-				if err := acceptqStatsMap.Lookup(&cookie, &entry); err != nil {
-					acceptqLogger.Printf("ts=%s slot=%d cookie=0x%x stats_lookup_err=%v", ts, slotKey, cookie, err)
-					continue
-				}
-				acceptqEntryBySlot[slotKey] = entry
-
-				util := 0.0
-				if entry.Max > 0 {
-					util = float64(entry.Curr) / float64(entry.Max) * 100
-				}
-				acceptqLogger.Printf("ts=%s slot=%d cookie=0x%x curr=%d max=%d cpu=%d util=%.2f",
-					ts, slotKey, cookie, entry.Curr, entry.Max, entry.Cpu, util)
-			}
-		default:
-		}
+	if err := reuseportlb.RunCollector(ctx, cfg); err != nil {
+		reuseportlb.Fatal("collector failed", "err", err)
 	}
 }
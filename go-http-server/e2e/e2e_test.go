@@ -0,0 +1,216 @@
+//go:build linux
+
+// Package e2e is a smoke test of the core demo: in a fresh network and mount
+// namespace, with a bpffs of its own, it starts two servers under the
+// pickfirst policy and checks that every request is served by instance 0.
+// Along the way it checks that the library's failure paths return errors of
+// the documented kinds instead of exiting: an unknown policy, a group with
+// nothing pinned, a slot already held, and deregistering a stranger.
+//
+//	go test ./e2e [-args -server bin/amd64/server_code -requests 50]
+//
+// It needs root and is skipped without. Unless given -server it builds
+// server_code itself; with -v it passes the servers' logs through.
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"go-http-server/launcher"
+	"go-http-server/reuseportlb"
+)
+
+var (
+	serverPath = flag.String("server", "", "server binary to test (default: build server_code)")
+	requests   = flag.Int("requests", 50, "number of requests to send, each on a new connection")
+	addr       = flag.String("addr", "127.0.0.1:8080", "address the servers share inside the namespace")
+	timeout    = flag.Duration("timeout", 30*time.Second, "how long a server may take to register")
+)
+
+// namespaceEnv marks the copy of the test running inside the namespaces.
+const namespaceEnv = "E2E_IN_NAMESPACE"
+
+func TestPickfirst(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("e2e needs root for namespaces and eBPF")
+	}
+	if os.Getenv(namespaceEnv) == "" {
+		inNamespaces(t)
+		return
+	}
+	isolate(t)
+	// Stand in for collect_stats, which creates the maps servers register
+	// their sockets in.
+	for _, name := range []string{reuseportlb.SlotCookiesMap, reuseportlb.AcceptqMap} {
+		m, err := reuseportlb.OpenOrCreatePinnedMap(name)
+		if err != nil {
+			t.Fatalf("create %s: %v", name, err)
+		}
+		m.Close()
+	}
+
+	opts := launcher.Options{Path: *serverPath, Addr: *addr, Policy: "pickfirst"}
+	if testing.Verbose() {
+		opts.Log = os.Stderr
+	}
+	for slot := 0; slot < 2; slot++ {
+		srv, err := launcher.Start(opts, slot)
+		if err != nil {
+			t.Fatalf("start server %d: %v", slot, err)
+		}
+		t.Cleanup(func() { srv.Stop(15 * time.Second) })
+		if err := srv.WaitReady(*timeout); err != nil {
+			t.Fatalf("%v (rerun with -v for its log)", err)
+		}
+	}
+
+	failurePaths(t)
+
+	served, err := whoami(*addr, *requests)
+	if err != nil {
+		t.Fatalf("send requests (served so far per slot: %v): %v", served, err)
+	}
+	if served[0] != *requests {
+		t.Fatalf("requests served per slot: %v, want all %d by slot 0", served, *requests)
+	}
+}
+
+// inNamespaces runs t again in a copy of the test binary in new network
+// and mount namespaces, building server_code for it first if need be.
+func inNamespaces(t *testing.T) {
+	server := *serverPath
+	if server == "" {
+		server = filepath.Join(t.TempDir(), "server_code")
+		build := exec.Command("go", "build", "-o", server, "go-http-server/server_code")
+		if out, err := build.CombinedOutput(); err != nil {
+			t.Fatalf("build server_code: %v\n%s", err, out)
+		}
+	} else if abs, err := filepath.Abs(server); err == nil {
+		server = abs
+	}
+
+	// Later flags win, so the copy runs only t, against server.
+	args := append(os.Args[1:], "-test.run=^"+t.Name()+"$", "-server", server)
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), namespaceEnv+"=1")
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Cloneflags: unix.CLONE_NEWNET | unix.CLONE_NEWNS}
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("test in namespaces: %v", err)
+	}
+}
+
+// isolate keeps the test's mounts to itself, gives it an empty bpffs at
+// PinPath so it neither sees nor leaves pins on the host, and brings up
+// loopback in the new network namespace.
+func isolate(t *testing.T) {
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		t.Fatalf("make mounts private: %v", err)
+	}
+	if err := unix.Mount("bpffs", reuseportlb.PinPath, "bpf", 0, ""); err != nil {
+		t.Fatalf("mount bpffs at %s: %v", reuseportlb.PinPath, err)
+	}
+
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(fd)
+	ifr, err := unix.NewIfreq("lo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.IoctlIfreq(fd, unix.SIOCGIFFLAGS, ifr); err != nil {
+		t.Fatalf("read lo flags: %v", err)
+	}
+	ifr.SetUint16(ifr.Uint16() | unix.IFF_UP)
+	if err := unix.IoctlIfreq(fd, unix.SIOCSIFFLAGS, ifr); err != nil {
+		t.Fatalf("bring up lo: %v", err)
+	}
+}
+
+// failurePaths provokes the errors callers branch on, while the servers
+// hold slots 0 and 1 of the default group, and leaves the group as it was.
+func failurePaths(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	g := reuseportlb.DefaultGroup
+	if _, err := g.LoadPolicy("no-such-policy", false, reuseportlb.DefaultFeatures); !errors.Is(err, reuseportlb.ErrPolicyUnsupported) {
+		t.Fatalf("loading an unknown policy: got %v, want ErrPolicyUnsupported", err)
+	}
+	missing, err := reuseportlb.ParseGroup("e2e-missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, err := missing.OpenPinnedMap(reuseportlb.TargetsMap); !errors.Is(err, reuseportlb.ErrPinMissing) || !errors.Is(err, os.ErrNotExist) {
+		if err == nil {
+			m.Close()
+		}
+		t.Fatalf("opening a map of an empty group: got %v, want ErrPinMissing", err)
+	}
+
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(fd)
+	if err := unix.Bind(fd, &unix.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.Listen(fd, 16); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.RegisterSocket(ctx, 0, fd, os.Getpid()); !errors.Is(err, reuseportlb.ErrSlotTaken) {
+		t.Fatalf("registering on a held slot: got %v, want ErrSlotTaken", err)
+	}
+	cookie, err := reuseportlb.SocketCookie(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Deregistering a socket that holds no slot must leave slot 0 alone,
+	// which the requests that follow check.
+	for i := 0; i < 2; i++ {
+		if err := g.Deregister(ctx, 0, cookie); err != nil {
+			t.Fatalf("deregistering a socket that holds no slot: %v", err)
+		}
+	}
+}
+
+// whoami sends n requests, each on its own connection so the selector runs
+// for every one, and counts them by the slot that served them.
+func whoami(addr string, n int) (map[int]int, error) {
+	client := &http.Client{
+		Timeout:   5 * time.Second,
+		Transport: &http.Transport{DisableKeepAlives: true},
+	}
+	served := make(map[int]int)
+	for i := 0; i < n; i++ {
+		resp, err := client.Get("http://" + addr + "/whoami")
+		if err != nil {
+			return served, err
+		}
+		var id struct {
+			Slot int `json:"slot"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&id)
+		resp.Body.Close()
+		if err != nil {
+			return served, fmt.Errorf("decode /whoami: %w", err)
+		}
+		served[id.Slot]++
+	}
+	return served, nil
+}
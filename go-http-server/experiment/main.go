@@ -0,0 +1,782 @@
+// Command experiment runs a scenario file end to end: it starts a group of
+// servers under a policy, optionally a collector and a packet capture, runs
+// a weighted mix of requests against the group for the scenario's duration
+// while carrying out its fault schedule, and archives everything the run
+// produced in a results directory of its own:
+//
+//	results/<name>-<time>/
+//	    scenario.yaml   the scenario as given
+//	    results.json    requests, failures, latency quantiles per path,
+//	                    connections served per slot, a per-second timeline
+//	                    and the faults as carried out, the autoscaler's
+//	                    changes and how the CPU sources compared, when
+//	                    the scenario asks
+//	    server-<n>.log  each server's JSON log, across restarts
+//	    collector/      the collector's per-core logs, collector.log its own
+//	    capture.pcap    the group's packets, when the scenario has a capture
+//
+// A scenario looks like this (see experiment/scenarios for more):
+//
+//	name: failover
+//	policy: round-robin
+//	instances: 3
+//	args: [-migrate=false]
+//	duration: 30s
+//	warmup: 2s
+//	workload:
+//	  clients: 8
+//	  mix:
+//	    - {path: /hello, weight: 80}
+//	    - {path: /cpu, weight: 20}
+//	faults:
+//	  - {at: 10s, op: kill, slot: 1}
+//	  - {at: 15s, op: start, slot: 1}
+//	collector:
+//	  args: [-cpus, "0 1 2 3"]
+//	capture:
+//	  iface: lo
+//	cpu_sources:
+//	  cpus: [0, 1, 2, 3]
+//
+// With autoscale the group starts with min instances and a launcher.Autoscaler
+// adds servers, up to max, while the mean slot utilization is over up_pct
+// and removes them while it is under down_pct. It takes the utilization
+// from the collector, which the scenario then has to run, and cannot be
+// combined with faults:
+//
+//	autoscale:
+//	  min: 1
+//	  max: 4
+//	  up_pct: 70
+//	  down_pct: 20
+//	  cooldown: 5s
+//
+// With cpu_sources the run also samples the CPUs through every source the
+// collector can read utilization from (see reuseportlb.CPUSources) for the
+// whole load, and reports how much each one's readings jitter.
+//
+// Scenario files are YAML, read with gopkg.in/yaml.v3; a file ending in
+// .json is read as JSON. Unknown keys are errors.
+//
+//	experiment [-out results] [-v] scenario.yaml
+//
+// It needs the privileges the servers need. The capture is taken with an
+// AF_PACKET socket filtered to the group's port (see reuseportlb.Capture),
+// so it needs no tcpdump.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"go-http-server/launcher"
+	"go-http-server/reuseportlb"
+)
+
+// duration is a time.Duration written as "10s" in scenario files.
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration %s: want a string such as \"10s\"", b)
+	}
+	v, err := time.ParseDuration(s)
+	*d = duration(v)
+	return err
+}
+
+func (d *duration) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind != yaml.ScalarNode {
+		return fmt.Errorf("line %d: duration: want a string such as \"10s\"", n.Line)
+	}
+	v, err := time.ParseDuration(n.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", n.Line, err)
+	}
+	*d = duration(v)
+	return nil
+}
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// scenario is one experiment, as read from its file.
+type scenario struct {
+	Name string `json:"name" yaml:"name"`
+	// Server is the server_code binary.
+	Server string `json:"server" yaml:"server"`
+	Addr   string `json:"addr" yaml:"addr"`
+	Policy string `json:"policy" yaml:"policy"`
+	// Instances is the number of servers, slots 0 to Instances-1.
+	Instances int `json:"instances" yaml:"instances"`
+	// Args are extra flags for every server.
+	Args []string `json:"args" yaml:"args"`
+	// Duration is how long the load runs, including Warmup, whose requests
+	// are not counted.
+	Duration duration `json:"duration" yaml:"duration"`
+	Warmup   duration `json:"warmup" yaml:"warmup"`
+	// ReadyTimeout is how long a server may take to join the group.
+	ReadyTimeout duration       `json:"ready_timeout" yaml:"ready_timeout"`
+	Workload     workload       `json:"workload" yaml:"workload"`
+	Faults       []fault        `json:"faults" yaml:"faults"`
+	Collector    *collectorSpec `json:"collector" yaml:"collector"`
+	Capture      *captureSpec   `json:"capture" yaml:"capture"`
+	CPUSources   *cpuSourceSpec `json:"cpu_sources" yaml:"cpu_sources"`
+	Autoscale    *autoscaleSpec `json:"autoscale" yaml:"autoscale"`
+}
+
+// workload is the load the clients put on the group.
+type workload struct {
+	Clients int `json:"clients" yaml:"clients"`
+	// Rate is the requests per second each client sends; 0 sends them back
+	// to back.
+	Rate float64 `json:"rate" yaml:"rate"`
+	// KeepAlive reuses connections; without it every request opens one and
+	// so goes through the selector.
+	KeepAlive bool     `json:"keepalive" yaml:"keepalive"`
+	Timeout   duration `json:"timeout" yaml:"timeout"`
+	Mix       []mix    `json:"mix" yaml:"mix"`
+}
+
+// mix is one path of the workload and its share of the requests.
+type mix struct {
+	Path   string `json:"path" yaml:"path"`
+	Weight int    `json:"weight" yaml:"weight"`
+}
+
+// fault is one scheduled change to the group, At after the load starts.
+type fault struct {
+	At   duration `json:"at" yaml:"at"`
+	Op   string   `json:"op" yaml:"op"` // kill, stop or start
+	Slot int      `json:"slot" yaml:"slot"`
+}
+
+// collectorSpec runs collect_stats next to the servers.
+type collectorSpec struct {
+	Path string   `json:"path" yaml:"path"`
+	Args []string `json:"args" yaml:"args"`
+}
+
+// captureSpec captures the TCP traffic of the group's port.
+type captureSpec struct {
+	Iface string `json:"iface" yaml:"iface"`
+	// Snaplen is the bytes kept of each packet.
+	Snaplen int `json:"snaplen" yaml:"snaplen"`
+
+	port uint16
+}
+
+// cpuSourceSpec compares the collector's CPU sources during the load.
+type cpuSourceSpec struct {
+	// CPUs are the cores compared, every online core by default.
+	CPUs []int `json:"cpus" yaml:"cpus"`
+	// Interval is the time between samples, the collector's by default.
+	Interval duration `json:"interval" yaml:"interval"`
+}
+
+// autoscaleSpec lets a launcher.Autoscaler change the number of servers
+// during the load.
+type autoscaleSpec struct {
+	Min      int      `json:"min" yaml:"min"`
+	Max      int      `json:"max" yaml:"max"`
+	UpPct    float64  `json:"up_pct" yaml:"up_pct"`
+	DownPct  float64  `json:"down_pct" yaml:"down_pct"`
+	Interval duration `json:"interval" yaml:"interval"`
+	Cooldown duration `json:"cooldown" yaml:"cooldown"`
+}
+
+// loadScenario reads a scenario file and fills in the defaults.
+func loadScenario(path string, data []byte) (*scenario, error) {
+	s := &scenario{
+		Server:       filepath.Join("bin", runtime.GOARCH, "server_code"),
+		Addr:         "127.0.0.1:8080",
+		Instances:    2,
+		Duration:     duration(30 * time.Second),
+		ReadyTimeout: duration(30 * time.Second),
+	}
+	if filepath.Ext(path) == ".json" {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(s); err != nil {
+			return nil, err
+		}
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		// An empty file leaves everything to the defaults.
+		if err := dec.Decode(s); err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+	}
+	if s.Name == "" {
+		s.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if s.Policy == "" {
+		return nil, errors.New("no policy")
+	}
+	if s.Instances < 1 {
+		return nil, errors.New("instances must be positive")
+	}
+	if s.Warmup >= s.Duration {
+		return nil, errors.New("warmup must be shorter than duration")
+	}
+	w := &s.Workload
+	if w.Clients == 0 {
+		w.Clients = 8
+	}
+	if w.Timeout == 0 {
+		w.Timeout = duration(2 * time.Second)
+	}
+	if len(w.Mix) == 0 {
+		w.Mix = []mix{{Path: "/hello", Weight: 1}}
+	}
+	if w.Clients < 0 || w.Rate < 0 {
+		return nil, errors.New("workload clients and rate must not be negative")
+	}
+	for i, m := range w.Mix {
+		if !strings.HasPrefix(m.Path, "/") || m.Weight < 0 {
+			return nil, fmt.Errorf("workload mix %d: want an absolute path and a weight of 0 or more", i)
+		}
+		if m.Weight == 0 && len(w.Mix) == 1 {
+			w.Mix[i].Weight = 1
+		}
+	}
+	for _, f := range s.Faults {
+		switch f.Op {
+		case "kill", "stop", "start":
+		default:
+			return nil, fmt.Errorf("fault at %s: unknown op %q (want kill, stop or start)", time.Duration(f.At), f.Op)
+		}
+		if f.Slot < 0 || f.Slot >= s.Instances {
+			return nil, fmt.Errorf("fault at %s: slot %d is not one of the %d instances", time.Duration(f.At), f.Slot, s.Instances)
+		}
+	}
+	sort.SliceStable(s.Faults, func(i, j int) bool { return s.Faults[i].At < s.Faults[j].At })
+	if c := s.Collector; c != nil && c.Path == "" {
+		c.Path = filepath.Join("bin", runtime.GOARCH, "collect_stats")
+	}
+	if c := s.CPUSources; c != nil {
+		if c.Interval == 0 {
+			c.Interval = duration(reuseportlb.DefaultCollectorConfig().Interval)
+		}
+		if c.Interval < 0 {
+			return nil, errors.New("cpu_sources interval must be positive")
+		}
+	}
+	if a := s.Autoscale; a != nil {
+		if a.Min == 0 {
+			a.Min = s.Instances
+		}
+		if a.UpPct == 0 {
+			a.UpPct = 70
+		}
+		if a.DownPct == 0 {
+			a.DownPct = 20
+		}
+		if a.Interval == 0 {
+			a.Interval = duration(time.Second)
+		}
+		if a.Cooldown == 0 {
+			a.Cooldown = duration(5 * time.Second)
+		}
+		if err := a.config().Validate(); err != nil {
+			return nil, fmt.Errorf("autoscale: %w", err)
+		}
+		if s.Collector == nil {
+			return nil, errors.New("autoscale needs a collector to publish slot utilization")
+		}
+		if len(s.Faults) > 0 {
+			return nil, errors.New("autoscale cannot be combined with faults")
+		}
+		s.Instances = a.Min
+	}
+	if c := s.Capture; c != nil {
+		if c.Iface == "" {
+			c.Iface = "lo"
+		}
+		if c.Snaplen == 0 {
+			c.Snaplen = reuseportlb.DefaultCaptureSnaplen
+		}
+		_, port, err := net.SplitHostPort(s.Addr)
+		if err != nil {
+			return nil, fmt.Errorf("addr: %w", err)
+		}
+		p, err := strconv.ParseUint(port, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("addr: invalid port %q", port)
+		}
+		c.port = uint16(p)
+	}
+	// The clients learn the serving slot from X-Served-By.
+	if !slices.Contains(s.Args, "-served-by") {
+		s.Args = append(s.Args, "-served-by")
+	}
+	return s, nil
+}
+
+func (a *autoscaleSpec) config() launcher.ScaleConfig {
+	return launcher.ScaleConfig{
+		Min:      a.Min,
+		Max:      a.Max,
+		Up:       a.UpPct,
+		Down:     a.DownPct,
+		Interval: time.Duration(a.Interval),
+		Cooldown: time.Duration(a.Cooldown),
+	}
+}
+
+// slots returns how many slots the scenario may use.
+func (s *scenario) slots() int {
+	if s.Autoscale != nil {
+		return max(s.Instances, s.Autoscale.Max)
+	}
+	return s.Instances
+}
+
+func main() {
+	outDir := flag.String("out", "results", "directory the results directory is created in")
+	verbose := flag.Bool("v", false, "also pass the servers' logs through to stderr")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <scenario.yaml>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	data, err := os.ReadFile(flag.Arg(0))
+	if err != nil {
+		reuseportlb.Fatal("reading scenario failed", "err", err)
+	}
+	s, err := loadScenario(flag.Arg(0), data)
+	if err != nil {
+		reuseportlb.Fatal("invalid scenario", "file", flag.Arg(0), "err", err)
+	}
+	dir := filepath.Join(*outDir, s.Name+"-"+time.Now().Format("20060102-150405"))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		reuseportlb.Fatal("creating results directory failed", "err", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "scenario"+filepath.Ext(flag.Arg(0))), data, 0o644); err != nil {
+		reuseportlb.Fatal("archiving scenario failed", "err", err)
+	}
+
+	// Interrupting ends the load early; the run is still archived.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	r := &runner{s: s, dir: dir, verbose: *verbose}
+	res, err := r.run(ctx)
+	if res != nil {
+		if werr := writeJSON(filepath.Join(dir, "results.json"), res); werr != nil {
+			slog.Error("writing results failed", "err", werr)
+		}
+		res.print(os.Stdout)
+	}
+	if err != nil {
+		reuseportlb.Fatal("experiment failed", "scenario", s.Name, "err", err)
+	}
+	fmt.Println("results in", dir)
+}
+
+func writeJSON(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// runner carries out one scenario.
+type runner struct {
+	s       *scenario
+	dir     string
+	verbose bool
+
+	mu      sync.Mutex
+	start   time.Time
+	servers []*launcher.Server
+	logs    []*os.File
+	faults  []faultRecord
+}
+
+// faultRecord is a fault as carried out.
+type faultRecord struct {
+	At   duration `json:"at"`
+	Op   string   `json:"op"`
+	Slot int      `json:"slot"`
+	// Took is how long the op took: the drain of a stop, or a start until
+	// the server had joined the group.
+	Took duration `json:"took"`
+	Err  string   `json:"err,omitempty"`
+}
+
+func (r *runner) run(ctx context.Context) (*results, error) {
+	s := r.s
+	r.servers = make([]*launcher.Server, s.slots())
+	r.logs = make([]*os.File, s.slots())
+	defer func() {
+		for slot, srv := range r.servers {
+			if srv != nil {
+				srv.Stop(15 * time.Second)
+			}
+			if r.logs[slot] != nil {
+				r.logs[slot].Close()
+			}
+		}
+	}()
+
+	var stopCapture func() (reuseportlb.CaptureStats, error)
+	if s.Capture != nil {
+		var err error
+		if stopCapture, err = r.startCapture(); err != nil {
+			slog.Warn("packet capture failed to start, running without it", "err", err)
+		} else {
+			defer stopCapture()
+		}
+	}
+	// Server 0 loads the policy, so it goes first.
+	for slot := 0; slot < s.Instances; slot++ {
+		if err := r.startSlot(slot); err != nil {
+			return nil, err
+		}
+	}
+	if s.Collector != nil {
+		stopCollector, err := r.startCollector()
+		if err != nil {
+			return nil, fmt.Errorf("start collector: %w", err)
+		}
+		defer stopCollector()
+	}
+	slog.Info("group up, starting load", "scenario", s.Name, "policy", s.Policy, "instances", s.Instances)
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.Duration))
+	defer cancel()
+	r.start = time.Now()
+	outcomes := make(chan outcome, 1024)
+	var clients sync.WaitGroup
+	for i := 0; i < s.Workload.Clients; i++ {
+		clients.Add(1)
+		go func(seed int64) {
+			defer clients.Done()
+			r.load(ctx, rand.New(rand.NewSource(seed)), outcomes)
+		}(int64(i + 1))
+	}
+	go func() {
+		clients.Wait()
+		close(outcomes)
+	}()
+	var compared chan []reuseportlb.CPUSourceReport
+	if c := s.CPUSources; c != nil {
+		compared = make(chan []reuseportlb.CPUSourceReport, 1)
+		go func() {
+			reports, err := reuseportlb.CompareCPUSources(ctx, c.CPUs, time.Duration(c.Interval), time.Duration(s.Duration))
+			if err != nil {
+				slog.Warn("comparing CPU sources failed", "err", err)
+			}
+			compared <- reports
+		}()
+	}
+	injected := make(chan struct{})
+	go func() {
+		defer close(injected)
+		r.inject(ctx)
+	}()
+	var scaler *launcher.Autoscaler
+	scaled := make(chan struct{})
+	if a := s.Autoscale; a != nil {
+		scaler = &launcher.Autoscaler{
+			Config: a.config(),
+			Policy: s.Policy,
+			Start:  r.startSlot,
+			Stop:   r.stopSlot,
+		}
+		go func() {
+			defer close(scaled)
+			if err := scaler.Run(ctx); err != nil {
+				slog.Warn("autoscaler failed", "err", err)
+			}
+		}()
+	} else {
+		close(scaled)
+	}
+
+	res := newResults(s, r.start)
+	for o := range outcomes {
+		res.add(o, time.Duration(s.Warmup))
+	}
+	// A start under way finishes before the group is torn down.
+	<-injected
+	<-scaled
+	res.finish(time.Since(r.start), time.Duration(s.Warmup))
+	if stopCapture != nil {
+		st, err := stopCapture()
+		if err != nil {
+			slog.Warn("packet capture failed", "err", err)
+		}
+		res.Capture = &st
+	}
+	if compared != nil {
+		res.CPUSources = <-compared
+	}
+	r.mu.Lock()
+	res.Faults = r.faults
+	r.mu.Unlock()
+	if scaler != nil {
+		res.Scaling = scaler.Events()
+	}
+	return res, nil
+}
+
+// startSlot starts the server of slot, appending its log to server-<slot>.log.
+func (r *runner) startSlot(slot int) error {
+	r.mu.Lock()
+	f := r.logs[slot]
+	r.mu.Unlock()
+	if f == nil {
+		var err error
+		f, err = os.OpenFile(filepath.Join(r.dir, fmt.Sprintf("server-%d.log", slot)), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return err
+		}
+		r.mu.Lock()
+		r.logs[slot] = f
+		r.mu.Unlock()
+	}
+	opts := launcher.Options{Path: r.s.Server, Addr: r.s.Addr, Policy: r.s.Policy, Args: r.s.Args, Log: f}
+	if r.verbose {
+		opts.Log = io.MultiWriter(f, os.Stderr)
+	}
+	srv, err := launcher.Start(opts, slot)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.servers[slot] = srv
+	r.mu.Unlock()
+	return srv.WaitReady(time.Duration(r.s.ReadyTimeout))
+}
+
+// stopSlot drains the server of slot.
+func (r *runner) stopSlot(slot int) {
+	r.mu.Lock()
+	srv := r.servers[slot]
+	r.servers[slot] = nil
+	r.mu.Unlock()
+	if srv != nil {
+		srv.Stop(15 * time.Second)
+	}
+}
+
+// startCollector runs collect_stats with its logs in collector/.
+func (r *runner) startCollector() (stop func(), err error) {
+	c := r.s.Collector
+	out, err := os.Create(filepath.Join(r.dir, "collector.log"))
+	if err != nil {
+		return nil, err
+	}
+	args := append([]string{"-logdir", filepath.Join(r.dir, "collector")}, c.Args...)
+	cmd := exec.Command(c.Path, args...)
+	cmd.Stdout, cmd.Stderr = out, out
+	if err := cmd.Start(); err != nil {
+		out.Close()
+		return nil, err
+	}
+	return func() {
+		cmd.Process.Signal(syscall.SIGTERM)
+		cmd.Wait()
+		out.Close()
+	}, nil
+}
+
+// startCapture records the group's port to capture.pcap. stop ends the
+// capture and may be called again, to no effect.
+func (r *runner) startCapture() (stop func() (reuseportlb.CaptureStats, error), err error) {
+	c := r.s.Capture
+	f, err := os.Create(filepath.Join(r.dir, "capture.pcap"))
+	if err != nil {
+		return nil, err
+	}
+	capture, err := reuseportlb.StartCapture(c.Iface, c.port, c.Snaplen, f)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return func() (reuseportlb.CaptureStats, error) {
+		st, err := capture.Close()
+		if cerr := f.Close(); cerr != nil && !errors.Is(cerr, os.ErrClosed) {
+			err = errors.Join(err, cerr)
+		}
+		return st, err
+	}, nil
+}
+
+// inject carries out the fault schedule.
+func (r *runner) inject(ctx context.Context) {
+	for _, f := range r.s.Faults {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(r.start.Add(time.Duration(f.At)))):
+		}
+		rec := faultRecord{At: duration(time.Since(r.start)), Op: f.Op, Slot: f.Slot}
+		slog.Info("fault", "op", f.Op, "slot", f.Slot, "at", time.Duration(rec.At).Round(time.Millisecond))
+		began := time.Now()
+		if err := r.do(f); err != nil {
+			slog.Warn("fault failed", "op", f.Op, "slot", f.Slot, "err", err)
+			rec.Err = err.Error()
+		}
+		rec.Took = duration(time.Since(began))
+		r.mu.Lock()
+		r.faults = append(r.faults, rec)
+		r.mu.Unlock()
+	}
+}
+
+func (r *runner) do(f fault) error {
+	r.mu.Lock()
+	srv := r.servers[f.Slot]
+	if f.Op != "start" {
+		r.servers[f.Slot] = nil
+	}
+	r.mu.Unlock()
+	switch f.Op {
+	case "kill", "stop":
+		if srv == nil {
+			return fmt.Errorf("slot %d is not running", f.Slot)
+		}
+		if f.Op == "kill" {
+			srv.Kill()
+		} else {
+			srv.Stop(15 * time.Second)
+		}
+		return nil
+	}
+	if srv != nil {
+		return fmt.Errorf("slot %d is already running", f.Slot)
+	}
+	return r.startSlot(f.Slot)
+}
+
+// outcome is how one request ended.
+type outcome struct {
+	at      time.Duration
+	path    string
+	kind    string
+	slot    int
+	latency time.Duration
+}
+
+// classify names a request failure.
+func classify(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "refused"
+	case errors.Is(err, syscall.ECONNRESET):
+		return "reset"
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "eof"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	}
+	return "other"
+}
+
+// load sends requests drawn from the mix until ctx is done.
+func (r *runner) load(ctx context.Context, rng *rand.Rand, out chan<- outcome) {
+	w := r.s.Workload
+	client := &http.Client{
+		Timeout:   time.Duration(w.Timeout),
+		Transport: &http.Transport{DisableKeepAlives: !w.KeepAlive},
+	}
+	total := 0
+	for _, m := range w.Mix {
+		total += m.Weight
+	}
+	var tick <-chan time.Time
+	if w.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / w.Rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for ctx.Err() == nil {
+		if tick != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tick:
+			}
+		}
+		path := w.Mix[0].Path
+		for n, i := rng.Intn(total), 0; i < len(w.Mix); i++ {
+			if n < w.Mix[i].Weight {
+				path = w.Mix[i].Path
+				break
+			}
+			n -= w.Mix[i].Weight
+		}
+		began := time.Now()
+		o := outcome{at: began.Sub(r.start), path: path, slot: -1}
+		resp, err := client.Get("http://" + r.s.Addr + path)
+		if err == nil {
+			_, err = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		o.latency = time.Since(began)
+		switch {
+		case err != nil:
+			o.kind = classify(err)
+		case resp.StatusCode >= 400:
+			o.kind = "status"
+		default:
+			o.kind = "ok"
+		}
+		if resp != nil {
+			o.slot = servedBySlot(resp.Header.Get("X-Served-By"))
+		}
+		if ctx.Err() != nil && o.kind != "ok" {
+			// Cut off by the end of the run, not by the group.
+			return
+		}
+		out <- o
+	}
+}
+
+// servedBySlot reads the slot from an X-Served-By header, or returns -1.
+func servedBySlot(h string) int {
+	for _, field := range strings.Fields(h) {
+		if v, ok := strings.CutPrefix(field, "slot="); ok {
+			if n, err := strconv.Atoi(v); err == nil {
+				return n
+			}
+		}
+	}
+	return -1
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScenariosLoad(t *testing.T) {
+	paths, err := filepath.Glob("scenarios/*.yaml")
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no scenarios: %v", err)
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadScenario(path, data); err != nil {
+			t.Errorf("%s: %v", path, err)
+		}
+	}
+}
+
+func TestLoadScenario(t *testing.T) {
+	s, err := loadScenario("x.yaml", []byte(`
+# the example of the package comment
+name: failover
+policy: round-robin
+instances: 3
+args: [-migrate=false]
+duration: 30s
+warmup: 2s
+workload:
+  clients: 8
+  mix:
+    - {path: /hello, weight: 80}
+    - path: "/cpu"   # block form
+      weight: 20
+faults:
+  - {at: 15s, op: start, slot: 1}
+  - {at: 10s, op: kill, slot: 1}
+collector:
+  args: [-cpus, "0 1 2 3"]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Name != "failover" || s.Instances != 3 || time.Duration(s.Warmup) != 2*time.Second {
+		t.Errorf("got name %q, instances %d, warmup %s", s.Name, s.Instances, time.Duration(s.Warmup))
+	}
+	if want := []mix{{"/hello", 80}, {"/cpu", 20}}; !reflect.DeepEqual(s.Workload.Mix, want) {
+		t.Errorf("mix %v, want %v", s.Workload.Mix, want)
+	}
+	if s.Faults[0].Op != "kill" || time.Duration(s.Faults[1].At) != 15*time.Second {
+		t.Errorf("faults not sorted by time: %v", s.Faults)
+	}
+	if want := []string{"-cpus", "0 1 2 3"}; !reflect.DeepEqual(s.Collector.Args, want) {
+		t.Errorf("collector args %q, want %q", s.Collector.Args, want)
+	}
+	if want := []string{"-migrate=false", "-served-by"}; !reflect.DeepEqual(s.Args, want) {
+		t.Errorf("args %q, want %q", s.Args, want)
+	}
+
+	for _, tc := range []struct {
+		name, path, data, err string
+	}{
+		{"empty", "x.yaml", "", "no policy"},
+		{"unknown key", "x.yaml", "policy: jsq\ninstancez: 2\n", "field instancez not found"},
+		{"nested unknown key", "x.yaml", "policy: jsq\nworkload: {clientz: 2}\n", "field clientz not found"},
+		{"bad duration", "x.yaml", "policy: jsq\nduration: soon\n", "line 2"},
+		{"duration list", "x.yaml", "policy: jsq\nduration: [1s]\n", "want a string"},
+		{"wrong type", "x.yaml", "policy: jsq\ninstances: two\n", "cannot unmarshal"},
+		{"bad fault", "x.yaml", "policy: jsq\nfaults: [{at: 1s, op: pause}]\n", "unknown op"},
+		{"json", "x.json", `{"policy": "jsq", "instancez": 2}`, "unknown field"},
+	} {
+		_, err := loadScenario(tc.path, []byte(tc.data))
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: got %v, want an error containing %q", tc.name, err, tc.err)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"go-http-server/launcher"
+	"go-http-server/reuseportlb"
+	"go-http-server/stats"
+)
+
+var failureKinds = []string{"refused", "reset", "eof", "timeout", "status", "other"}
+
+// results is what results.json holds.
+type results struct {
+	Scenario  string    `json:"scenario"`
+	Policy    string    `json:"policy"`
+	Instances int       `json:"instances"`
+	Started   time.Time `json:"started"`
+	// Measured is the time the counted requests were sent in: the load
+	// minus the warmup.
+	Measured duration       `json:"measured"`
+	Requests int            `json:"requests"`
+	OK       int            `json:"ok"`
+	Failures map[string]int `json:"failures"`
+	// Served counts the requests each slot answered, by X-Served-By.
+	Served   []int                 `json:"served"`
+	Jain     float64               `json:"jain_index"`
+	MaxSkew  float64               `json:"max_skew"`
+	Paths    map[string]*pathStats `json:"paths"`
+	Timeline []second              `json:"timeline"`
+	Faults   []faultRecord         `json:"faults"`
+	// Capture counts the packets in capture.pcap.
+	Capture *reuseportlb.CaptureStats `json:"capture,omitempty"`
+	// CPUSources is how noisy each CPU utilization source was under the
+	// load.
+	CPUSources []reuseportlb.CPUSourceReport `json:"cpu_sources,omitempty"`
+	// Scaling is what the autoscaler did, when the scenario has one.
+	Scaling []launcher.ScaleEvent `json:"scaling,omitempty"`
+}
+
+// pathStats are the requests to one path of the mix.
+type pathStats struct {
+	Requests int `json:"requests"`
+	OK       int `json:"ok"`
+	// Latency quantiles of the successful requests, in milliseconds.
+	P50 float64 `json:"p50_ms"`
+	P90 float64 `json:"p90_ms"`
+	P99 float64 `json:"p99_ms"`
+	Max float64 `json:"max_ms"`
+
+	latencies []time.Duration
+}
+
+// second is one second of the load, warmup included, so faults can be lined
+// up with what the clients saw.
+type second struct {
+	OK     int `json:"ok"`
+	Failed int `json:"failed"`
+}
+
+func newResults(s *scenario, start time.Time) *results {
+	r := &results{
+		Scenario:  s.Name,
+		Policy:    s.Policy,
+		Instances: s.Instances,
+		Started:   start,
+		Failures:  make(map[string]int),
+		Served:    make([]int, s.slots()),
+		Paths:     make(map[string]*pathStats),
+	}
+	for _, m := range s.Workload.Mix {
+		r.Paths[m.Path] = &pathStats{}
+	}
+	return r
+}
+
+// add counts one request; those sent before warmup is over only go into
+// the timeline.
+func (r *results) add(o outcome, warmup time.Duration) {
+	sec := int(o.at / time.Second)
+	for len(r.Timeline) <= sec {
+		r.Timeline = append(r.Timeline, second{})
+	}
+	if o.kind == "ok" {
+		r.Timeline[sec].OK++
+	} else {
+		r.Timeline[sec].Failed++
+	}
+	if o.at < warmup {
+		return
+	}
+	r.Requests++
+	p := r.Paths[o.path]
+	p.Requests++
+	if o.kind != "ok" {
+		r.Failures[o.kind]++
+		return
+	}
+	r.OK++
+	p.OK++
+	p.latencies = append(p.latencies, o.latency)
+	if o.slot >= 0 && o.slot < len(r.Served) {
+		r.Served[o.slot]++
+	}
+}
+
+// finish computes the summaries once the load is over.
+func (r *results) finish(elapsed, warmup time.Duration) {
+	r.Measured = duration(max(elapsed-warmup, 0))
+	served := make([]float64, len(r.Served))
+	for i, n := range r.Served {
+		served[i] = float64(n)
+	}
+	r.Jain = stats.JainIndex(served)
+	r.MaxSkew = stats.MaxSkew(served)
+	for _, p := range r.Paths {
+		if len(p.latencies) == 0 {
+			continue
+		}
+		sort.Slice(p.latencies, func(i, j int) bool { return p.latencies[i] < p.latencies[j] })
+		q := func(f float64) float64 {
+			i := min(int(f*float64(len(p.latencies))), len(p.latencies)-1)
+			return float64(p.latencies[i]) / float64(time.Millisecond)
+		}
+		p.P50, p.P90, p.P99, p.Max = q(0.5), q(0.9), q(0.99), q(1)
+	}
+}
+
+// print writes a summary table.
+func (r *results) print(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "path\trequests\tok\tp50 ms\tp90 ms\tp99 ms\tmax ms\n")
+	paths := make([]string, 0, len(r.Paths))
+	for path := range r.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		p := r.Paths[path]
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.2f\t%.2f\t%.2f\t%.2f\n", path, p.Requests, p.OK, p.P50, p.P90, p.P99, p.Max)
+	}
+	tw.Flush()
+	var failures []string
+	for _, k := range failureKinds {
+		if n := r.Failures[k]; n > 0 {
+			failures = append(failures, fmt.Sprintf("%s=%d", k, n))
+		}
+	}
+	if len(failures) == 0 {
+		failures = []string{"none"}
+	}
+	fmt.Fprintf(w, "%s under %s: %d requests in %s, failures: %s\n",
+		r.Scenario, r.Policy, r.Requests, time.Duration(r.Measured).Round(time.Millisecond), strings.Join(failures, " "))
+	fmt.Fprintf(w, "served per slot: %v (Jain %.3f, max skew %.1f%%)\n", r.Served, r.Jain, 100*r.MaxSkew)
+	for _, f := range r.Faults {
+		fmt.Fprintf(w, "fault: %s slot %d at %s took %s", f.Op, f.Slot,
+			time.Duration(f.At).Round(time.Millisecond), time.Duration(f.Took).Round(time.Millisecond))
+		if f.Err != "" {
+			fmt.Fprintf(w, " (%s)", f.Err)
+		}
+		fmt.Fprintln(w)
+	}
+	for _, e := range r.Scaling {
+		fmt.Fprintf(w, "scaled %s: slot %d at %s, util %.1f%%, %d instances", e.Op, e.Slot,
+			e.At.Sub(r.Started).Round(time.Millisecond), e.Util, e.Instances)
+		if e.Err != "" {
+			fmt.Fprintf(w, " (%s)", e.Err)
+		}
+		fmt.Fprintln(w)
+	}
+	if c := r.Capture; c != nil {
+		fmt.Fprintf(w, "captured %d packets to capture.pcap, %d dropped\n", c.Packets, c.Drops)
+	}
+	if len(r.CPUSources) > 0 {
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(tw, "cpu source\tsamples\tmean %%\tstdev\tjitter\t\n")
+		for _, c := range r.CPUSources {
+			if c.Err != "" {
+				fmt.Fprintf(tw, "%s\t-\t-\t-\t-\tunavailable: %s\n", c.Source, c.Err)
+				continue
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%.1f\t%.2f\t%.2f\t\n", c.Source, c.Samples, c.Mean, c.Stdev, c.Jitter)
+		}
+		tw.Flush()
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/status"
+)
+
+const chatMethod = "/grpcdemo.Echo/Chat"
+
+type chatRequest struct {
+	Seq int `json:"seq"`
+}
+
+type chatReply struct {
+	Seq  int    `json:"seq"`
+	Slot uint32 `json:"slot"`
+	PID  int    `json:"pid"`
+}
+
+// jsonCodec carries the messages as JSON, content type
+// application/grpc+json, so the demo needs no generated code.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Name() string                       { return "json" }
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// echoServiceDesc is what protoc-gen-go-grpc would generate for
+//
+//	service Echo { rpc Chat(stream ChatRequest) returns (stream ChatReply); }
+var echoServiceDesc = grpc.ServiceDesc{
+	ServiceName: "grpcdemo.Echo",
+	HandlerType: (*echoServer)(nil),
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "Chat",
+		Handler:       func(srv any, stream grpc.ServerStream) error { return srv.(echoServer).Chat(stream) },
+		ServerStreams: true,
+		ClientStreams: true,
+	}},
+}
+
+type echoServer interface {
+	Chat(grpc.ServerStream) error
+}
+
+// echo answers each message with the instance that served it.
+type echo struct {
+	slot uint32
+	// Closed when the server drains; open streams end on it.
+	draining chan struct{}
+}
+
+// Chat answers each message of the stream until the client half-closes or
+// the server drains, which ends the stream with UNAVAILABLE.
+func (e *echo) Chat(stream grpc.ServerStream) error {
+	reqs := make(chan chatRequest)
+	errc := make(chan error, 1)
+	go func() {
+		for {
+			var req chatRequest
+			if err := stream.RecvMsg(&req); err != nil {
+				errc <- err
+				return
+			}
+			select {
+			case reqs <- req:
+			case <-stream.Context().Done():
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case req := <-reqs:
+			if err := stream.SendMsg(&chatReply{Seq: req.Seq, Slot: e.slot, PID: os.Getpid()}); err != nil {
+				return err
+			}
+		case err := <-errc:
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		case <-e.draining:
+			return status.Error(codes.Unavailable, "server draining")
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		}
+	}
+}
@@ -0,0 +1,337 @@
+// Command grpcdemo is a gRPC backend balanced by a reuseport group, and a
+// client that measures how long-lived HTTP/2 streams fare under it. Run a
+// few servers and the client:
+//
+//	grpcdemo -role server -slot 0 -policy round-robin
+//	grpcdemo -role server -slot 1 -policy round-robin
+//	grpcdemo -role client -conns 4 -streams 8 -instances 2
+//
+// Servers join the group with reuseportlb.WrapServer and hand the listener
+// Server.Listen returns to a grpc.Server, which serves a bidirectional
+// streaming method, /grpcdemo.Echo/Chat, with a JSON codec (content type
+// application/grpc+json), so any gRPC client with a JSON codec can call it.
+//
+// The selector runs once per connection, when the kernel places it on a
+// listener. Every stream gRPC multiplexes onto that connection, for as long
+// as it lives, goes to the same instance: balancing is by connection, not
+// by call, and a client holding one connection loads one instance however
+// many calls it makes. The client checks that this holds and exits 1 if a
+// connection's streams were answered by more than one instance. It then
+// prints per instance the connections, streams and messages it got and the
+// imbalance of messages, the busiest instance's share over the mean; with
+// -skew connection i carries i+1 times as many streams as the first, the
+// uneven load real clients produce, which the selector never sees. Spread
+// load by opening more connections than instances, or, on the server side,
+// by ending streams so clients reconnect.
+//
+// On SIGTERM a server leaves its slot, ends its open streams with
+// UNAVAILABLE and stops gracefully; GracefulStop alone would wait for
+// streams that never end, pinning their connections to the draining
+// instance. Clients that reconnect are placed anew.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	"go-http-server/reuseportlb"
+)
+
+func main() {
+	role := flag.String("role", "", "server or client")
+	addr := flag.String("addr", "127.0.0.1:8080", "address the servers share")
+	groupName := flag.String("group", "", "server: reuseport group to join (default group if empty)")
+	slot := flag.Uint("slot", 0, "server: slot to register as")
+	policy := flag.String("policy", "", "server: policy to load or attach, \"default\" for the kernel's hash, empty to attach lbd's selector")
+	registry := flag.String("registry", "", "server: register through lbd's registry socket at this path instead of bpffs")
+	conns := flag.Int("conns", 4, "client: HTTP/2 connections to open")
+	streams := flag.Int("streams", 8, "client: streams per connection")
+	messages := flag.Int("messages", 10, "client: messages per stream")
+	interval := flag.Duration("interval", 50*time.Millisecond, "client: pause between a stream's messages")
+	skew := flag.Bool("skew", false, "client: connection i carries i+1 times -streams streams")
+	instances := flag.Int("instances", 0, "client: instances behind -addr, counting those that get nothing (default: those that answered)")
+	logLevel := flag.String("log-level", "info", "minimum log level: debug, info, warn or error")
+	logFormat := flag.String("log-format", "text", "log output format: text or json")
+	flag.Parse()
+
+	logger, err := reuseportlb.NewLogger(os.Stderr, *logLevel, *logFormat)
+	if err != nil {
+		reuseportlb.Fatal("invalid logging flags", "err", err)
+	}
+	slog.SetDefault(logger)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	switch *role {
+	case "server":
+		g, err := reuseportlb.ParseGroup(*groupName)
+		if err != nil {
+			reuseportlb.Fatal("invalid -group", "err", err)
+		}
+		opts := []reuseportlb.ServerOption{reuseportlb.WithGroup(g), reuseportlb.WithSlot(uint32(*slot)), reuseportlb.WithPolicy(*policy)}
+		if *registry != "" {
+			opts = append(opts, reuseportlb.WithRegistry(*registry))
+		}
+		if err := runServer(ctx, *addr, uint32(*slot), opts); err != nil {
+			reuseportlb.Fatal("server failed", "err", err)
+		}
+	case "client":
+		if *conns < 1 || *streams < 1 || *messages < 1 {
+			reuseportlb.Fatal("-conns, -streams and -messages must be positive")
+		}
+		runClient(ctx, *addr, *conns, *streams, *messages, *interval, *skew, *instances)
+	default:
+		reuseportlb.Fatal("-role must be server or client", "role", *role)
+	}
+}
+
+// runServer serves until ctx is done. It returns its failures instead of
+// exiting, having left the group first.
+func runServer(ctx context.Context, addr string, slot uint32, opts []reuseportlb.ServerOption) error {
+	s, err := newServer(ctx, addr, slot, opts)
+	if err != nil {
+		return err
+	}
+	return s.serve(ctx)
+}
+
+// server is one instance: its place in the group and the gRPC server on
+// the listener it got there.
+type server struct {
+	lb   *reuseportlb.Server
+	ln   net.Listener
+	gs   *grpc.Server
+	echo *echo
+}
+
+// newServer joins the group at addr and registers the Echo service on a
+// grpc.Server that has yet to serve.
+func newServer(ctx context.Context, addr string, slot uint32, opts []reuseportlb.ServerOption) (*server, error) {
+	// The http.Server only carries the address; gRPC serves the listener.
+	lb := reuseportlb.WrapServer(&http.Server{Addr: addr}, opts...)
+	ln, err := lb.Listen(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("join the group: %w", err)
+	}
+	s := &server{lb: lb, ln: ln, gs: grpc.NewServer(), echo: &echo{slot: slot, draining: make(chan struct{})}}
+	s.gs.RegisterService(&echoServiceDesc, s.echo)
+	return s, nil
+}
+
+// serve serves until ctx is done, then drains.
+func (s *server) serve(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		<-ctx.Done()
+		// Leave the slot before ending the streams, so clients that
+		// reconnect are placed on the instances still listening.
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := s.lb.Shutdown(shutdownCtx); err != nil {
+			slog.Warn("shutdown incomplete", "err", err)
+		}
+		close(s.echo.draining)
+		s.gs.GracefulStop()
+	}()
+	slog.Info("serving", "addr", s.ln.Addr().String(), "slot", s.echo.slot)
+	if err := s.gs.Serve(s.ln); err != nil {
+		s.lb.Shutdown(context.Background())
+		s.gs.Stop()
+		return fmt.Errorf("serve: %w", err)
+	}
+	<-done
+	return nil
+}
+
+// streamResult is what one stream saw.
+type streamResult struct {
+	conn     int
+	slots    map[uint32]int // messages answered per slot
+	drained  bool
+	err      error
+	received int
+}
+
+func runClient(ctx context.Context, addr string, conns, streams, messages int, interval time.Duration, skew bool, instances int) {
+	results := make(chan streamResult)
+	var wg sync.WaitGroup
+	dials := make([]atomic.Int32, conns)
+	for c := 0; c < conns; c++ {
+		c := c
+		// A channel per connection: every stream of connection c shares
+		// its single TCP connection.
+		cc, err := dial(addr, &dials[c])
+		if err != nil {
+			reuseportlb.Fatal("invalid -addr", "err", err)
+		}
+		defer cc.Close()
+		n := streams
+		if skew {
+			n *= c + 1
+		}
+		for s := 0; s < n; s++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				res := stream(ctx, cc, messages, interval)
+				res.conn = c
+				results <- res
+			}()
+		}
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	type load struct{ conns, streams, messages int }
+	bySlot := make(map[uint32]*load)
+	connSlots := make([]map[uint32]bool, conns)
+	var failed, drained, total int
+	for res := range results {
+		total++
+		switch {
+		case res.err != nil:
+			failed++
+			slog.Warn("stream failed", "conn", res.conn, "received", res.received, "err", res.err)
+		case res.drained:
+			drained++
+		}
+		if connSlots[res.conn] == nil {
+			connSlots[res.conn] = make(map[uint32]bool)
+		}
+		for slot, n := range res.slots {
+			if bySlot[slot] == nil {
+				bySlot[slot] = &load{}
+			}
+			bySlot[slot].streams++
+			bySlot[slot].messages += n
+			connSlots[res.conn][slot] = true
+		}
+	}
+
+	// Streams of a connection that was redialled, after a drain say, may
+	// rightly have landed elsewhere.
+	var split []int
+	for c, slots := range connSlots {
+		for slot := range slots {
+			bySlot[slot].conns++
+		}
+		if len(slots) > 1 && dials[c].Load() == 1 {
+			split = append(split, c)
+		}
+	}
+
+	slots := make([]uint32, 0, len(bySlot))
+	var sum, busiest int
+	for slot, l := range bySlot {
+		slots = append(slots, slot)
+		sum += l.messages
+		busiest = max(busiest, l.messages)
+	}
+	sort.Slice(slots, func(i, j int) bool { return slots[i] < slots[j] })
+	for _, slot := range slots {
+		l := bySlot[slot]
+		fmt.Printf("slot %d: %d conns, %d streams, %d messages\n", slot, l.conns, l.streams, l.messages)
+	}
+	if instances < len(slots) {
+		instances = len(slots)
+	}
+	if sum > 0 {
+		// The busiest instance over the mean: 1 is even, instances means one
+		// instance took everything.
+		fmt.Printf("imbalance: %.2f over %d instances (%d streams, %d drained, %d failed)\n",
+			float64(busiest)*float64(instances)/float64(sum), instances, total, drained, failed)
+	}
+	if len(split) > 0 {
+		reuseportlb.Fatal("streams of one connection were answered by different instances", "conns", split)
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// dial opens a gRPC channel to addr, counting its connections in dials.
+// With the default pick_first balancer the channel holds a single
+// connection, which every stream on it shares.
+func dial(addr string, dials *atomic.Int32) (*grpc.ClientConn, error) {
+	return grpc.NewClient("passthrough:///"+addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.CallContentSubtype(jsonCodec{}.Name())),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			dials.Add(1)
+			var d net.Dialer
+			return d.DialContext(ctx, "tcp", addr)
+		}))
+}
+
+// stream opens a Chat stream on cc and sends messages on it, waiting for
+// each reply.
+func stream(ctx context.Context, cc *grpc.ClientConn, messages int, interval time.Duration) streamResult {
+	res := streamResult{slots: make(map[uint32]int)}
+	s, err := cc.NewStream(ctx, &echoServiceDesc.Streams[0], chatMethod)
+	if err != nil {
+		res.err = err
+		return res
+	}
+	for seq := 0; seq < messages && err == nil; seq++ {
+		if seq > 0 {
+			select {
+			case <-time.After(interval):
+			case <-ctx.Done():
+				res.err = ctx.Err()
+				return res
+			}
+		}
+		if err = s.SendMsg(&chatRequest{Seq: seq}); err != nil {
+			break
+		}
+		var reply chatReply
+		if err = s.RecvMsg(&reply); err == nil {
+			res.received++
+			res.slots[reply.Slot]++
+		}
+	}
+	switch {
+	case err == nil:
+		// Half-close; the server ends the stream with its status.
+		if err = s.CloseSend(); err == nil {
+			var extra chatReply
+			if err = s.RecvMsg(&extra); err == nil {
+				err = errors.New("reply without a request")
+			}
+		}
+	case errors.Is(err, io.EOF):
+		// SendMsg saw the server end the stream; RecvMsg has the status.
+		var extra chatReply
+		err = s.RecvMsg(&extra)
+	}
+	switch {
+	case errors.Is(err, io.EOF):
+	case status.Code(err) == codes.Unavailable:
+		res.drained = true
+	default:
+		res.err = err
+	}
+	return res
+}
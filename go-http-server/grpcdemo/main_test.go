@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go-http-server/reuseportlb"
+)
+
+// The default policy leaves balancing to the kernel, so this runs without
+// privileges; whichever instance a connection lands on, its streams must
+// all stay there.
+func TestStreamsStayOnConnectionSlot(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	addr := "127.0.0.1:0"
+	served := make(chan error, 2)
+	for slot := uint32(0); slot < 2; slot++ {
+		s, err := newServer(ctx, addr, slot, []reuseportlb.ServerOption{reuseportlb.WithSlot(slot), reuseportlb.WithPolicy("default")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr = s.ln.Addr().String()
+		go func() { served <- s.serve(ctx) }()
+	}
+
+	const conns, streams = 8, 16
+	for c := 0; c < conns; c++ {
+		var dials atomic.Int32
+		cc, err := dial(addr, &dials)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results := make([]streamResult, streams)
+		var wg sync.WaitGroup
+		for i := range results {
+			i := i
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				results[i] = stream(ctx, cc, 3, time.Millisecond)
+			}()
+		}
+		wg.Wait()
+		cc.Close()
+
+		slots := make(map[uint32]int)
+		for i, res := range results {
+			if res.err != nil || res.drained || res.received != 3 {
+				t.Fatalf("conn %d stream %d: received %d, drained %v, err %v", c, i, res.received, res.drained, res.err)
+			}
+			for slot, n := range res.slots {
+				slots[slot] += n
+			}
+		}
+		if n := dials.Load(); n != 1 {
+			t.Errorf("conn %d dialled %d times, want 1", c, n)
+		}
+		if len(slots) != 1 {
+			t.Errorf("conn %d: streams answered by slots %v, want one", c, slots)
+		}
+	}
+
+	cancel()
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-served:
+			if err != nil {
+				t.Errorf("serve: %v", err)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("servers did not drain")
+		}
+	}
+}
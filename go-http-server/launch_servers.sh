@@ -2,6 +2,8 @@
 
 # ---- Configurable parameters ----
 REPORT_INTERVAL=3  # seconds between CPU usage reports
+ADMIN_BASE_PORT=9090  # server i serves pprof/expvar on ADMIN_BASE_PORT+i, collect_stats on ADMIN_BASE_PORT-1
+USE_LBD=${USE_LBD:-0}  # 1: lbd loads the policy and runs the collectors (control API on ADMIN_BASE_PORT-1)
 # ---------------------------------
 
 if [[ $# -ne 2 ]]; then
@@ -22,7 +24,7 @@ if sudo bpftool prog show pinned /sys/fs/bpf/acceptq_prog >/dev/null 2>&1; then
     echo "Found existing pinned acceptq BPF program at /sys/fs/bpf/acceptq_prog"
 else
     echo "Loading acceptq BPF program"
-    sudo bpftool prog load server_code/eBPF/acceptq.bpf.o /sys/fs/bpf/acceptq_prog
+    sudo bpftool prog load reuseportlb/eBPF/acceptq.bpf.o /sys/fs/bpf/acceptq_prog
 fi
 
 # Get list of CPUs on NUMA node 0
@@ -50,6 +52,9 @@ cleanup() {
     if [[ -n "${COLLECT_STATS_PID:-}" ]] && kill -0 "$COLLECT_STATS_PID" 2>/dev/null; then
         kill "$COLLECT_STATS_PID"
     fi
+    if [[ -n "${LBD_PID:-}" ]] && kill -0 "$LBD_PID" 2>/dev/null; then
+        kill "$LBD_PID"
+    fi
 }
 trap cleanup SIGINT SIGTERM EXIT
 
@@ -89,6 +94,25 @@ monitor_cpu() {
 }
 # --------------------------------
 
+SERVER_ARGS=("$POLICY")
+if (( USE_LBD )); then
+    lbd_cpus=$(echo "$CPUS_NODE0" | head -n "$NUM_SERVERS" | paste -sd' ')
+    lbd_log="log/lbd.log"
+    echo "Starting lbd with policy '$POLICY' for CPUs: ${lbd_cpus} (logging to $lbd_log)"
+    (
+        export GOCACHE="$(pwd)/.gocache"
+        exec stdbuf -oL -eL go run ./lbd -cpus "${lbd_cpus}" -logdir log -period "${REPORT_INTERVAL}s" -control-addr "127.0.0.1:$((ADMIN_BASE_PORT - 1))" "$POLICY"
+    ) >>"$lbd_log" 2>&1 &
+    LBD_PID=$!
+
+    # Servers need the pinned selector before they can attach it.
+    for _ in $(seq 1 100); do
+        [[ -e /sys/fs/bpf/lb_prog ]] && break
+        sleep .2
+    done
+    SERVER_ARGS=()
+fi
+
 # Launch servers pinned to the first NUM_SERVERS CPUs on node 0
 i=0
 for cpu in $CPUS_NODE0; do
@@ -100,7 +124,7 @@ for cpu in $CPUS_NODE0; do
     echo "Starting server $i on CPU $cpu with policy '$POLICY' (logging to $logfile)"
     
     # Redirect stdout/stderr to log file
-    taskset -c "$cpu" go run ./server_code/ "$i" "$POLICY" >"$logfile" 2>&1 &
+    taskset -c "$cpu" go run ./server_code/ -admin-addr "127.0.0.1:$((ADMIN_BASE_PORT + i))" -conn-log "log/conn${i}.log" ${LBD_PID:+-lbd} "$i" "${SERVER_ARGS[@]}" >"$logfile" 2>&1 &
 
     pid=$!
     PIDS+=("$pid")
@@ -112,14 +136,14 @@ for cpu in $CPUS_NODE0; do
     ((i++))
 done
 
-# Launch collect_stats to populate BPF maps
-if (( ${#USED_CPUS[@]} > 0 )); then
+# Launch collect_stats to populate BPF maps (lbd already does)
+if (( ${#USED_CPUS[@]} > 0 )) && (( ! USE_LBD )); then
     cpu_arg=$(IFS=' '; echo "${USED_CPUS[*]}")
     collect_log="log/collect_stats.log"
     echo "Starting collect_stats for CPUs: ${cpu_arg} (logging to $collect_log)"
     (
         export GOCACHE="$(pwd)/.gocache"
-        exec stdbuf -oL -eL go run ./collect_stats.go -cpus "${cpu_arg}" -logdir log -period "${REPORT_INTERVAL}s"
+        exec stdbuf -oL -eL go run ./collect_stats.go -cpus "${cpu_arg}" -logdir log -period "${REPORT_INTERVAL}s" -admin-addr "127.0.0.1:$((ADMIN_BASE_PORT - 1))"
     ) >>"$collect_log" 2>&1 &
     COLLECT_STATS_PID=$!
 fi
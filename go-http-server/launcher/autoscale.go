@@ -0,0 +1,192 @@
+package launcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"go-http-server/reuseportlb"
+)
+
+// ScaleConfig bounds and paces an Autoscaler.
+type ScaleConfig struct {
+	// Min and Max bound the number of servers, which always run in slots 0
+	// to n-1. Min is at least 1: slot 0 loaded the policy.
+	Min, Max int
+	// Up is the mean utilization of the running slots, in percent, above
+	// which a server is added; Down the one below which the highest slot
+	// is stopped. Down must be under Up, and far enough under it that
+	// removing a server does not push the rest straight back over Up.
+	Up, Down float64
+	// Interval is how often the load is looked at.
+	Interval time.Duration
+	// Cooldown is how long after a change the next one has to wait, so
+	// the collector's smoothed utilization catches up with the new count.
+	Cooldown time.Duration
+}
+
+// Validate reports the first setting an Autoscaler cannot work with.
+func (c ScaleConfig) Validate() error {
+	if c.Min < 1 || c.Max < c.Min {
+		return fmt.Errorf("invalid instance bounds [%d, %d]: want 1 <= min <= max", c.Min, c.Max)
+	}
+	if c.Up <= 0 || c.Up > 100 || c.Down < 0 || c.Down >= c.Up {
+		return fmt.Errorf("invalid thresholds up %v%%, down %v%%: want 0 <= down < up <= 100", c.Up, c.Down)
+	}
+	if c.Interval <= 0 || c.Cooldown < 0 {
+		return errors.New("interval must be positive and cooldown must not be negative")
+	}
+	return nil
+}
+
+// ScaleEvent is one change an Autoscaler made.
+type ScaleEvent struct {
+	At time.Time `json:"at"`
+	// Op is "up" or "down".
+	Op   string `json:"op"`
+	Slot int    `json:"slot"`
+	// Util is the mean utilization that triggered it, in percent.
+	Util float64 `json:"util"`
+	// Instances is the number of servers running afterwards.
+	Instances int    `json:"instances"`
+	Err       string `json:"err,omitempty"`
+}
+
+// slotCountParams are the parameters that tell a policy how many slots to
+// spread connections over, from slot 0; the Autoscaler keeps them equal to
+// the number of servers running.
+var slotCountParams = map[string]string{
+	"round-robin": "group_size",
+	"acceptqueue": "slots",
+}
+
+// Autoscaler starts and stops servers of a group as its load crosses
+// thresholds, turning the supervisor into a local autoscaler. The load is
+// the mean of slot_util over the running slots, so a collector has to be
+// publishing it. Servers join and leave the balancing targets themselves
+// as they register and drain; for policies that spread over a fixed
+// number of slots the Autoscaler also moves that parameter along.
+type Autoscaler struct {
+	Config ScaleConfig
+	Group  reuseportlb.Group
+	Policy string
+	// Start starts the server of slot and waits until it has joined the
+	// group; Stop drains it. Both are called from Run only.
+	Start func(slot int) error
+	Stop  func(slot int)
+
+	mu      sync.Mutex
+	running int
+	events  []ScaleEvent
+}
+
+// Run scales the group until ctx is done. The caller has started slots 0
+// to Config.Min-1 already.
+func (a *Autoscaler) Run(ctx context.Context) error {
+	if err := a.Config.Validate(); err != nil {
+		return err
+	}
+	a.mu.Lock()
+	a.running = a.Config.Min
+	a.mu.Unlock()
+	a.setSlotCount(a.Config.Min)
+
+	ticker := time.NewTicker(a.Config.Interval)
+	defer ticker.Stop()
+	var changed time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		if time.Since(changed) < a.Config.Cooldown {
+			continue
+		}
+		n := a.Instances()
+		util, err := a.load(n)
+		if err != nil {
+			slog.Warn("reading group load failed", "group", a.Group.String(), "err", err)
+			continue
+		}
+		ev := ScaleEvent{Util: util}
+		switch {
+		case util > a.Config.Up && n < a.Config.Max:
+			ev.Op, ev.Slot = "up", n
+			if err := a.Start(n); err != nil {
+				ev.Err = err.Error()
+			} else {
+				n++
+			}
+		case util < a.Config.Down && n > a.Config.Min:
+			ev.Op, ev.Slot = "down", n-1
+			// Shrink the policy's slot count first, so it stops sending
+			// connections to the slot before the slot drains.
+			n--
+			a.setSlotCount(n)
+			a.Stop(n)
+		default:
+			continue
+		}
+		changed = time.Now()
+		ev.At, ev.Instances = changed, n
+		a.mu.Lock()
+		a.running = n
+		a.events = append(a.events, ev)
+		a.mu.Unlock()
+		if ev.Op == "up" && ev.Err == "" {
+			a.setSlotCount(n)
+		}
+		slog.Info("scaled group", "group", a.Group.String(), "op", ev.Op, "slot", ev.Slot,
+			"util", fmt.Sprintf("%.1f", util), "instances", n, "err", ev.Err)
+	}
+}
+
+// Instances returns the number of servers running.
+func (a *Autoscaler) Instances() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.running
+}
+
+// Events returns the changes made so far, oldest first.
+func (a *Autoscaler) Events() []ScaleEvent {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]ScaleEvent(nil), a.events...)
+}
+
+// load returns the mean utilization of slots 0 to n-1, in percent.
+func (a *Autoscaler) load(n int) (float64, error) {
+	slots, err := a.Group.Slots()
+	if err != nil {
+		return 0, err
+	}
+	var sum float64
+	var counted int
+	for _, s := range slots {
+		if int(s.Slot) < n && s.PID != 0 {
+			sum += float64(s.Util) / 100
+			counted++
+		}
+	}
+	if counted == 0 {
+		return 0, errors.New("no running slot has an owner recorded")
+	}
+	return sum / float64(counted), nil
+}
+
+// setSlotCount tells the policy to spread connections over n slots, if it
+// has a parameter for that.
+func (a *Autoscaler) setSlotCount(n int) {
+	name, ok := slotCountParams[a.Policy]
+	if !ok {
+		return
+	}
+	if err := a.Group.SetParam(a.Policy, name, uint64(n)); err != nil {
+		slog.Warn("updating the policy's slot count failed", "group", a.Group.String(), "param", name, "err", err)
+	}
+}
@@ -0,0 +1,133 @@
+// Package launcher runs server_code instances for the experiment drivers
+// (e2e, chaos): it starts them, tells when they have joined the group by
+// following their JSON logs, and stops or kills them. An Autoscaler can
+// also add and remove them as the group's load changes.
+package launcher
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Messages server_code logs once its listener is part of the group.
+var readyMsgs = map[string]bool{
+	"Registered socket in balancing targets": true,
+	"Registered socket via lbd":              true,
+}
+
+// listeningMsg is all a server under the default policy logs: it has no
+// maps to register in.
+const listeningMsg = "Listener socket cookie obtained"
+
+// Options describe the servers of one experiment.
+type Options struct {
+	// Path is the server_code binary.
+	Path string
+	// Addr is the address the servers share.
+	Addr string
+	// Policy is passed to every server; server 0 loads it.
+	Policy string
+	// Args are extra flags for every server.
+	Args []string
+	// Log, if set, gets the servers' logs.
+	Log io.Writer
+}
+
+// Server is a running server_code process.
+type Server struct {
+	Slot int
+	cmd  *exec.Cmd
+	// ready is closed once the server has joined the group.
+	ready chan struct{}
+	// exited is closed once the process's log has been read to the end.
+	exited chan struct{}
+}
+
+// Start starts the server for slot. Use Ready or WaitReady to learn when it
+// serves.
+func Start(opts Options, slot int) (*Server, error) {
+	args := append([]string{"-log-format", "json", "-addr", opts.Addr}, opts.Args...)
+	args = append(args, strconv.Itoa(slot), opts.Policy)
+	cmd := exec.Command(opts.Path, args...)
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	s := &Server{Slot: slot, cmd: cmd, ready: make(chan struct{}), exited: make(chan struct{})}
+
+	go func() {
+		defer close(s.exited)
+		out := opts.Log
+		if out == nil {
+			out = io.Discard
+		}
+		isReady := false
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			fmt.Fprintln(out, scanner.Text())
+			var rec struct {
+				Msg string `json:"msg"`
+			}
+			if isReady || json.Unmarshal(scanner.Bytes(), &rec) != nil {
+				continue
+			}
+			if readyMsgs[rec.Msg] || (opts.Policy == "default" && rec.Msg == listeningMsg) {
+				isReady = true
+				close(s.ready)
+			}
+		}
+	}()
+	return s, nil
+}
+
+// Ready is closed once the server has joined the group.
+func (s *Server) Ready() <-chan struct{} { return s.ready }
+
+// Exited is closed once the server has exited.
+func (s *Server) Exited() <-chan struct{} { return s.exited }
+
+// Pid is the server's process ID.
+func (s *Server) Pid() int { return s.cmd.Process.Pid }
+
+// WaitReady waits until the server has joined the group, failing if it
+// exits first or takes longer than timeout.
+func (s *Server) WaitReady(timeout time.Duration) error {
+	select {
+	case <-s.ready:
+		return nil
+	case <-s.exited:
+		return fmt.Errorf("server %d exited before joining the group", s.Slot)
+	case <-time.After(timeout):
+		return fmt.Errorf("server %d did not join the group within %s", s.Slot, timeout)
+	}
+}
+
+// Stop drains the server with SIGTERM, killing it if that takes longer
+// than grace.
+func (s *Server) Stop(grace time.Duration) {
+	s.cmd.Process.Signal(syscall.SIGTERM)
+	select {
+	case <-s.exited:
+	case <-time.After(grace):
+		s.cmd.Process.Kill()
+		<-s.exited
+	}
+	s.cmd.Wait()
+}
+
+// Kill kills the server without letting it drain, as a crash would.
+func (s *Server) Kill() {
+	s.cmd.Process.Signal(os.Kill)
+	<-s.exited
+	s.cmd.Wait()
+}
@@ -0,0 +1,464 @@
+// Command lbctl inspects and maintains the state the load balancer leaves
+// in bpffs.
+//
+//	lbctl gc [-dry-run] [-idle=false] [group...]
+//	lbctl history [-json] [group...]
+//	lbctl override [-group name] [-clear] [addr[=slot]...]
+//	lbctl shadow [-group name] [-duration 10s] [-json]
+//	lbctl export [-group name] [-o file]
+//	lbctl import [-group name] file
+//	lbctl audit [-log path] [-group name] [-n 100] [-json]
+//	lbctl steering [-iface lo] [-cpus "0 1"] [-mode auto|irq|rps] [-dry-run] [-save file | -revert file]
+//
+// gc finds pins left behind by crashed or incompatible runs (maps with the
+// wrong spec, pins that cannot be loaded, groups with neither a live socket
+// nor a live instance) and removes them. history prints which process
+// loaded, took over or attached which program when, followed by what is
+// pinned now. Without group arguments both look at every group that has
+// pins. override pins client addresses to slots (addr=slot), unpins them
+// (-clear addr) or, without arguments, lists the pins and how many
+// connections each placed. shadow watches a group whose server or lbd runs
+// a candidate policy with -shadow and reports how often the candidate would
+// have placed a connection elsewhere, and where. export writes every map
+// pinned for a group as JSON, and import writes such a snapshot back into
+// a group's maps, to back up the balancer's state before an experiment or
+// reproduce the state of a bug report. audit prints who changed which
+// pinned map when, from the log every command appends its changes to.
+// steering prints or sets how an interface's packets are spread over CPUs
+// (RPS, XPS and queue IRQ affinity), so that per-CPU policies see the CPUs
+// the experiment intends: on a NIC with a queue per CPU each queue IRQ is
+// pinned to one CPU, on loopback or a NIC with fewer queues RPS hashes
+// flows over the CPUs in software.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-http-server/reuseportlb"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s <command> [flags] [args]\n\ncommands:\n", os.Args[0])
+	fmt.Fprintln(os.Stderr, "  gc       remove stale pins")
+	fmt.Fprintln(os.Stderr, "  history  print the attachment timeline")
+	fmt.Fprintln(os.Stderr, "  override route a client address to a fixed slot")
+	fmt.Fprintln(os.Stderr, "  shadow   compare a shadow candidate policy with the active one")
+	fmt.Fprintln(os.Stderr, "  export   write a group's pinned maps as JSON")
+	fmt.Fprintln(os.Stderr, "  import   restore a group's pinned maps from an export")
+	fmt.Fprintln(os.Stderr, "  audit    print the changes made to the pinned maps")
+	fmt.Fprintln(os.Stderr, "  steering print or set RPS/XPS and IRQ affinity for an interface")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	switch cmd, args := os.Args[1], os.Args[2:]; cmd {
+	case "gc":
+		gc(args)
+	case "history":
+		history(args)
+	case "override":
+		override(args)
+	case "shadow":
+		shadow(args)
+	case "export":
+		export(args)
+	case "import":
+		importSnapshot(args)
+	case "audit":
+		auditLog(args)
+	case "steering":
+		steering(args)
+	case "-h", "-help", "--help", "help":
+		usage()
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", cmd)
+		usage()
+		os.Exit(2)
+	}
+}
+
+// parseGroups resolves group arguments, defaulting to every pinned group.
+func parseGroups(args []string) []reuseportlb.Group {
+	if len(args) == 0 {
+		groups, err := reuseportlb.Groups()
+		if err != nil {
+			reuseportlb.Fatal("listing groups failed", "err", err)
+		}
+		return groups
+	}
+	var groups []reuseportlb.Group
+	for _, a := range args {
+		g, err := reuseportlb.ParseGroup(a)
+		if err != nil {
+			reuseportlb.Fatal("invalid group", "err", err)
+		}
+		groups = append(groups, g)
+	}
+	return groups
+}
+
+func gc(args []string) {
+	fs := flag.NewFlagSet("gc", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "only report what would be removed")
+	idle := fs.Bool("idle", true, "also unpin groups with no live sockets or instances")
+	fs.Parse(args)
+
+	failed := false
+	for _, g := range parseGroups(fs.Args()) {
+		problems, err := g.GC(*idle, *dryRun)
+		for _, p := range problems {
+			action := "remove"
+			switch {
+			case *dryRun:
+				action = "stale"
+			case p.Idle && !*idle:
+				action = "keep"
+			}
+			fmt.Printf("%s\t%s\t%s\t%s\n", g, action, p.Path, p.Reason)
+		}
+		if err != nil {
+			slog.Error("collecting stale pins failed", "group", g.String(), "err", err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func history(args []string) {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print JSON instead of a table")
+	fs.Parse(args)
+
+	type groupHistory struct {
+		Group  string                     `json:"group"`
+		Events []reuseportlb.AttachEvent  `json:"events"`
+		Pinned []reuseportlb.PinnedObject `json:"pinned"`
+	}
+	var out []groupHistory
+	for _, g := range parseGroups(fs.Args()) {
+		events, err := g.History()
+		if err != nil {
+			reuseportlb.Fatal("reading journal failed", "group", g.String(), "err", err)
+		}
+		pinned, err := g.PinnedObjects()
+		if err != nil {
+			reuseportlb.Fatal("listing pinned objects failed", "group", g.String(), "err", err)
+		}
+		out = append(out, groupHistory{Group: g.String(), Events: events, Pinned: pinned})
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(out)
+		return
+	}
+	for _, h := range out {
+		fmt.Printf("group %s\n", h.Group)
+		for _, ev := range h.Events {
+			fmt.Printf("  %s  %-6s pid=%d comm=%s", ev.Time.Format("2006-01-02T15:04:05.000000"), ev.Kind, ev.PID, ev.Comm)
+			if ev.ProgID != 0 {
+				fmt.Printf(" prog=%d", ev.ProgID)
+			}
+			if ev.Policy != "" {
+				fmt.Printf(" policy=%s", ev.Policy)
+			}
+			if ev.Kind == reuseportlb.EventAttach {
+				fmt.Printf(" slot=%d cookie=0x%x", ev.Slot, ev.Cookie)
+			}
+			fmt.Println()
+		}
+		for _, p := range h.Pinned {
+			fmt.Printf("  pinned %s id=%d", p.Path, p.ID)
+			if p.ProgID != 0 {
+				fmt.Printf(" prog=%d", p.ProgID)
+			}
+			fmt.Println()
+		}
+	}
+}
+
+func override(args []string) {
+	fs := flag.NewFlagSet("override", flag.ExitOnError)
+	groupName := fs.String("group", "", "group whose selectors to override (default group if empty)")
+	clearAddrs := fs.Bool("clear", false, "remove the overrides of the given addresses")
+	fs.Parse(args)
+
+	g, err := reuseportlb.ParseGroup(*groupName)
+	if err != nil {
+		reuseportlb.Fatal("invalid group", "err", err)
+	}
+	for _, arg := range fs.Args() {
+		addrStr, slotStr, hasSlot := strings.Cut(arg, "=")
+		addr, err := netip.ParseAddr(addrStr)
+		if err != nil {
+			reuseportlb.Fatal("invalid client address", "arg", arg, "err", err)
+		}
+		if *clearAddrs {
+			if err := g.ClearOverride(addr); err != nil {
+				reuseportlb.Fatal("clearing override failed", "addr", addr, "err", err)
+			}
+			continue
+		}
+		if !hasSlot {
+			reuseportlb.Fatal("override needs addr=slot", "arg", arg)
+		}
+		slot, err := strconv.ParseUint(slotStr, 10, 32)
+		if err != nil {
+			reuseportlb.Fatal("invalid slot", "arg", arg, "err", err)
+		}
+		if err := g.SetOverride(addr, uint32(slot)); err != nil {
+			reuseportlb.Fatal("setting override failed", "addr", addr, "err", err)
+		}
+	}
+
+	overrides, err := g.Overrides()
+	if err != nil {
+		reuseportlb.Fatal("listing overrides failed", "group", g.String(), "err", err)
+	}
+	for _, o := range overrides {
+		fmt.Printf("%s\t%s\tslot=%d\thits=%d\n", g, o.Addr, o.Slot, o.Hits)
+	}
+}
+
+func shadow(args []string) {
+	fs := flag.NewFlagSet("shadow", flag.ExitOnError)
+	groupName := fs.String("group", "", "group running a shadow candidate (default group if empty)")
+	duration := fs.Duration("duration", 10*time.Second, "how long to watch new connections")
+	asJSON := fs.Bool("json", false, "print JSON instead of a table")
+	fs.Parse(args)
+
+	g, err := reuseportlb.ParseGroup(*groupName)
+	if err != nil {
+		reuseportlb.Fatal("invalid group", "err", err)
+	}
+	report, err := g.CompareShadow(*duration)
+	if err != nil {
+		reuseportlb.Fatal("reading shadow events failed", "group", g.String(), "err", err)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+		return
+	}
+	fmt.Printf("group %s: %d connections in %s\n", g, report.Connections, report.Duration)
+	if report.Connections == 0 {
+		fmt.Println("  no shadow events: is a candidate running (-shadow) and traffic arriving?")
+		return
+	}
+	fmt.Printf("  candidate differs on %d (%.1f%%), would not place %d at all\n",
+		report.Differ, report.DifferPct(), report.CandidateNone)
+	fmt.Printf("  mean slot utilization: active %.1f%%, candidate %.1f%%\n", report.ActiveUtil, report.CandidateUtil)
+	fmt.Printf("  %-6s %10s %10s\n", "slot", "active", "candidate")
+	for _, s := range report.Slots {
+		fmt.Printf("  %-6d %10d %10d\n", s.Slot, s.Active, s.Candidate)
+	}
+}
+
+func export(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	groupName := fs.String("group", "", "group to export (default group if empty)")
+	outPath := fs.String("o", "", "file to write instead of stdout")
+	fs.Parse(args)
+
+	g, err := reuseportlb.ParseGroup(*groupName)
+	if err != nil {
+		reuseportlb.Fatal("invalid group", "err", err)
+	}
+	snap, err := g.Snapshot()
+	if err != nil {
+		reuseportlb.Fatal("reading pinned maps failed", "group", g.String(), "err", err)
+	}
+
+	out := os.Stdout
+	if *outPath != "" {
+		if out, err = os.Create(*outPath); err != nil {
+			reuseportlb.Fatal("creating snapshot file failed", "err", err)
+		}
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(snap); err != nil {
+		reuseportlb.Fatal("writing snapshot failed", "err", err)
+	}
+	if err := out.Close(); err != nil {
+		reuseportlb.Fatal("writing snapshot failed", "err", err)
+	}
+}
+
+func importSnapshot(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	groupName := fs.String("group", "", "group to restore into (default: the group the snapshot was taken of)")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		reuseportlb.Fatal("import needs exactly one snapshot file")
+	}
+
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		reuseportlb.Fatal("reading snapshot failed", "err", err)
+	}
+	var snap reuseportlb.Snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		reuseportlb.Fatal("invalid snapshot", "path", fs.Arg(0), "err", err)
+	}
+	name := *groupName
+	if name == "" {
+		name = snap.Group
+	}
+	g, err := reuseportlb.ParseGroup(name)
+	if err != nil {
+		reuseportlb.Fatal("invalid group", "err", err)
+	}
+
+	skipped, err := g.Import(&snap)
+	for _, s := range skipped {
+		fmt.Printf("%s\tskip\t%s\t%s\n", g, s.Name, s.Reason)
+	}
+	if err != nil {
+		reuseportlb.Fatal("restoring snapshot failed", "group", g.String(), "err", err)
+	}
+	fmt.Printf("%s\trestored %d of %d maps from %s\n", g, len(snap.Maps)-len(skipped), len(snap.Maps), snap.Taken.Format(time.RFC3339))
+}
+
+func auditLog(args []string) {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	path := fs.String("log", reuseportlb.DefaultAuditLog, "audit log to read")
+	groupName := fs.String("group", "", "only print changes to this group's maps (all groups if empty)")
+	n := fs.Int("n", 100, "print the last n changes; 0 prints all")
+	asJSON := fs.Bool("json", false, "print JSON instead of a table")
+	fs.Parse(args)
+
+	group := ""
+	if *groupName != "" {
+		g, err := reuseportlb.ParseGroup(*groupName)
+		if err != nil {
+			reuseportlb.Fatal("invalid group", "err", err)
+		}
+		group = g.String()
+	}
+	entries, err := reuseportlb.ReadAudit(*path, group, *n)
+	if err != nil {
+		reuseportlb.Fatal("reading audit log failed", "path", *path, "err", err)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(entries)
+		return
+	}
+	for _, e := range entries {
+		fmt.Printf("%s  %s  pid=%d comm=%s  %s %s key=%s", e.Time.Format("2006-01-02T15:04:05.000000"), e.Group, e.PID, e.Comm, e.Op, e.Map, e.Key)
+		if e.Old != "" {
+			fmt.Printf(" old=%s", e.Old)
+		}
+		if e.New != "" {
+			fmt.Printf(" new=%s", e.New)
+		}
+		fmt.Println()
+	}
+}
+
+func steering(args []string) {
+	fs := flag.NewFlagSet("steering", flag.ExitOnError)
+	iface := fs.String("iface", "lo", "interface the experiment's traffic arrives on")
+	cpuList := fs.String("cpus", "", "CPU cores to steer packets to, as numbers and ranges (e.g. \"0 1 2 3\" or \"0-3,8-11\"); empty prints the current steering")
+	mode := fs.String("mode", reuseportlb.SteerAuto, "auto, irq (hardware queues, one IRQ per CPU) or rps (software)")
+	queues := fs.Int("queues", 0, "set the NIC's combined channel count with ethtool first (0 leaves it)")
+	dryRun := fs.Bool("dry-run", false, "print the changes without making them")
+	save := fs.String("save", "", "write the applied changes to this file, for -revert")
+	revert := fs.String("revert", "", "undo the changes saved in this file")
+	asJSON := fs.Bool("json", false, "print JSON instead of text")
+	fs.Parse(args)
+
+	if *revert != "" {
+		data, err := os.ReadFile(*revert)
+		if err != nil {
+			reuseportlb.Fatal("reading saved steering failed", "err", err)
+		}
+		var steps []reuseportlb.SteeringStep
+		if err := json.Unmarshal(data, &steps); err != nil {
+			reuseportlb.Fatal("invalid saved steering", "path", *revert, "err", err)
+		}
+		if err := reuseportlb.RevertSteering(steps); err != nil {
+			reuseportlb.Fatal("reverting steering failed", "err", err)
+		}
+		fmt.Printf("reverted %d settings\n", len(steps))
+		return
+	}
+
+	if *cpuList == "" {
+		st, err := reuseportlb.ReadSteering(*iface)
+		if err != nil {
+			reuseportlb.Fatal("reading steering failed", "iface", *iface, "err", err)
+		}
+		if *asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.Encode(st)
+			return
+		}
+		for _, q := range st.RX {
+			fmt.Printf("%s\t%s\trps_cpus=%s\n", st.Iface, q.Queue, q.CPUs)
+		}
+		for _, q := range st.TX {
+			fmt.Printf("%s\t%s\txps_cpus=%s\n", st.Iface, q.Queue, q.CPUs)
+		}
+		for _, irq := range st.IRQs {
+			fmt.Printf("%s\tirq %d\t%s\taffinity=%s\n", st.Iface, irq.IRQ, irq.Name, irq.CPUs)
+		}
+		return
+	}
+
+	cpus, err := reuseportlb.ParseCPUList(*cpuList)
+	if err != nil {
+		reuseportlb.Fatal("invalid CPU list", "err", err)
+	}
+	steps, err := reuseportlb.PlanSteering(reuseportlb.SteeringConfig{Iface: *iface, CPUs: cpus, Mode: *mode, Queues: *queues})
+	if err != nil {
+		reuseportlb.Fatal("planning steering failed", "err", err)
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(steps)
+	} else {
+		for _, s := range steps {
+			fmt.Println(s)
+		}
+		if len(steps) == 0 {
+			fmt.Printf("%s already steered to %v\n", *iface, cpus)
+		}
+	}
+	if *dryRun || len(steps) == 0 {
+		return
+	}
+	if reuseportlb.IRQBalanceRunning() {
+		slog.Warn("irqbalance is running and will move the interrupts again; stop it for the experiment")
+	}
+	if err := reuseportlb.ApplySteering(steps); err != nil {
+		reuseportlb.Fatal("applying steering failed", "err", err)
+	}
+	if *save != "" {
+		data, _ := json.MarshalIndent(steps, "", "  ")
+		if err := os.WriteFile(*save, append(data, '\n'), 0o644); err != nil {
+			reuseportlb.Fatal("saving steering failed", "err", err)
+		}
+	}
+}
@@ -0,0 +1,839 @@
+// Command lbd is the load balancer daemon. It loads the selected policy,
+// pins its selector for the servers to attach, owns the shared pinned maps,
+// runs the metric collectors that feed them and serves the control API.
+// Servers started with -lbd only attach the pinned selector and register
+// their sockets; servers started with -registry hand their listener to lbd
+// over a Unix socket and need no privileges at all.
+//
+// One lbd can manage several independent reuseport groups, given as
+// group=policy arguments; each gets its own selector, maps and collector.
+//
+// With -federation-addr, lbds on different hosts gossip a load summary of
+// their groups (listeners, new connections per second, accept queue fill)
+// with each other, so each one's /federation shows every host's load for a
+// cross-host agent to act on. With -dns-addr as well, the view is turned
+// into weights per host and published as SRV and A/AAAA records, for clients
+// that choose a host themselves; /dnsweights serves the same weights as
+// JSON for pushing to an external DNS provider.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/rlimit"
+
+	"go-http-server/reuseportlb"
+)
+
+// managedGroup is one reuseport group lbd runs a selector for.
+type managedGroup struct {
+	group           reuseportlb.Group
+	policy          string
+	selectOrMigrate bool
+	// shadow is the candidate policy running in shadow mode, if any.
+	shadow *reuseportlb.Shadow
+	// watchdog watches the selector's spread, if enabled.
+	watchdog *reuseportlb.Watchdog
+	// outliers quarantines slots far slower than the rest, if enabled.
+	outliers *reuseportlb.OutlierDetector
+}
+
+// params picks the values among all that the group's policy has a
+// parameter for.
+func (mg *managedGroup) params(all map[string]uint64) map[string]uint64 {
+	own := make(map[string]uint64)
+	for _, p := range reuseportlb.PolicyParams(mg.policy) {
+		if v, ok := all[p.Name]; ok {
+			own[p.Name] = v
+		}
+	}
+	return own
+}
+
+// flagSet reports whether the named flag was given on the command line.
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// daemon is the state the control API reports on.
+type daemon struct {
+	groups   []*managedGroup
+	started  time.Time
+	registry *reuseportlb.Registry
+	// federation gossips the groups' load with other hosts, if enabled.
+	federation *reuseportlb.Federation
+}
+
+// parseGroupArgs turns the positional arguments into groups: either a single
+// policy for the default group or one group=policy per named group.
+func parseGroupArgs(args []string) ([]*managedGroup, error) {
+	if len(args) == 0 {
+		return nil, errors.New("no policy given")
+	}
+	if len(args) == 1 && !strings.Contains(args[0], "=") {
+		args = []string{"default=" + args[0]}
+	}
+	seen := make(map[reuseportlb.Group]bool)
+	var groups []*managedGroup
+	for _, arg := range args {
+		name, policy, ok := strings.Cut(arg, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q: want group=policy", arg)
+		}
+		g, err := reuseportlb.ParseGroup(name)
+		if err != nil {
+			return nil, err
+		}
+		if policy == "" || policy == "default" {
+			return nil, fmt.Errorf("group %s: lbd needs an eBPF policy", g)
+		}
+		if seen[g] {
+			return nil, fmt.Errorf("group %s given twice", g)
+		}
+		seen[g] = true
+		groups = append(groups, &managedGroup{group: g, policy: policy})
+	}
+	return groups, nil
+}
+
+// parseShadows turns -shadow into a candidate policy per group: a bare
+// policy means the default group.
+func parseShadows(s string, groups []*managedGroup) (map[reuseportlb.Group]string, error) {
+	shadows := make(map[reuseportlb.Group]string)
+	if s == "" {
+		return shadows, nil
+	}
+	for _, arg := range strings.Split(s, ",") {
+		name, policy, ok := strings.Cut(strings.TrimSpace(arg), "=")
+		if !ok {
+			name, policy = "", name
+		}
+		g, err := reuseportlb.ParseGroup(name)
+		if err != nil {
+			return nil, err
+		}
+		managed := false
+		for _, mg := range groups {
+			managed = managed || mg.group == g
+		}
+		if !managed {
+			return nil, fmt.Errorf("group %s is not managed by this daemon", g)
+		}
+		shadows[g] = policy
+	}
+	return shadows, nil
+}
+
+// lookup returns the managed group named by the request's group parameter;
+// without one it means the default group.
+func (d *daemon) lookup(r *http.Request) (*managedGroup, error) {
+	g, err := reuseportlb.ParseGroup(r.URL.Query().Get("group"))
+	if err != nil {
+		return nil, err
+	}
+	for _, mg := range d.groups {
+		if mg.group == g {
+			return mg, nil
+		}
+	}
+	return nil, fmt.Errorf("group %s is not managed by this daemon", g)
+}
+
+func (mg *managedGroup) status() (map[string]any, error) {
+	slots, err := mg.group.Slots()
+	if err != nil {
+		return nil, err
+	}
+	st := map[string]any{
+		"group":             mg.group.String(),
+		"policy":            mg.policy,
+		"select_or_migrate": mg.selectOrMigrate,
+		"program_pin":       mg.group.ProgramPath(),
+		"slots":             slots,
+	}
+	if mg.shadow != nil {
+		st["shadow"] = mg.shadow.Policy()
+	}
+	if mg.watchdog != nil {
+		st["watchdog"] = mg.watchdog.Status()
+	}
+	if mg.outliers != nil {
+		st["outliers"] = mg.outliers.Status()
+	}
+	if mg.policy == "chain" {
+		if st["chain"], err = mg.group.Chain(); err != nil {
+			return nil, err
+		}
+	}
+	return st, nil
+}
+
+// handleStatus reports the loaded policy and the registered slots of the
+// group named by ?group=, or of every group when none is named.
+func (d *daemon) handleStatus(w http.ResponseWriter, r *http.Request) {
+	groups := d.groups
+	if r.URL.Query().Has("group") {
+		mg, err := d.lookup(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		groups = []*managedGroup{mg}
+	}
+	var statuses []map[string]any
+	for _, mg := range groups {
+		st, err := mg.status()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		statuses = append(statuses, st)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"uptime":           time.Since(d.started).Round(time.Second).String(),
+		"registry_clients": d.registry.Clients(),
+		"groups":           statuses,
+	})
+}
+
+// handleRateLimit serves the rate limiter state of the group named by
+// ?group=.
+func (d *daemon) handleRateLimit(w http.ResponseWriter, r *http.Request) {
+	mg, err := d.lookup(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	mg.group.ServeRateLimit(w, r)
+}
+
+// handleSlotLimits serves and adjusts the per-slot connection rate caps of
+// the group named by ?group=.
+func (d *daemon) handleSlotLimits(w http.ResponseWriter, r *http.Request) {
+	mg, err := d.lookup(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	mg.group.ServeSlotLimits(w, r)
+}
+
+// handleRebalance asks the server of a slot of the group named by ?group=
+// to shed a fraction of its connections: POST with slot and fraction.
+func (d *daemon) handleRebalance(w http.ResponseWriter, r *http.Request) {
+	mg, err := d.lookup(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	slot, err := strconv.ParseUint(r.FormValue("slot"), 10, 32)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid slot: %v", err), http.StatusBadRequest)
+		return
+	}
+	fraction, err := strconv.ParseFloat(r.FormValue("fraction"), 64)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid fraction: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := mg.group.RequestRebalance(uint32(slot), fraction); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	slog.Info("asked slot to shed connections", "group", mg.group.String(), "slot", slot, "fraction", fraction)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleParams serves and adjusts the policy parameters of the group named
+// by ?group=.
+func (d *daemon) handleParams(w http.ResponseWriter, r *http.Request) {
+	mg, err := d.lookup(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	mg.group.ParamsHandler(mg.policy)(w, r)
+}
+
+// handleAudit serves the map audit log of the group named by ?group=, or
+// of every group with group=all; follow=1 streams it.
+func (d *daemon) handleAudit(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("group") == "all" {
+		reuseportlb.DefaultGroup.ServeAudit(w, r)
+		return
+	}
+	mg, err := d.lookup(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	mg.group.ServeAudit(w, r)
+}
+
+// handleLatency renders the latency histograms of the group named by
+// ?group=.
+func (d *daemon) handleLatency(w http.ResponseWriter, r *http.Request) {
+	mg, err := d.lookup(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	mg.group.ServeLatency(w, r)
+}
+
+// handleConnStats reports the connection totals of the group named by
+// ?group=.
+func (d *daemon) handleConnStats(w http.ResponseWriter, r *http.Request) {
+	mg, err := d.lookup(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	mg.group.ServeConnStats(w, r)
+}
+
+// handleSplit serves and adjusts the canary split of the group named by
+// ?group=.
+func (d *daemon) handleSplit(w http.ResponseWriter, r *http.Request) {
+	mg, err := d.lookup(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if mg.policy != "splitter" {
+		http.Error(w, fmt.Sprintf("group %s runs %s, not splitter", mg.group, mg.policy), http.StatusConflict)
+		return
+	}
+	mg.group.ServeSplit(w, r)
+}
+
+// handleStandby serves and adjusts the hot-standby config of the group
+// named by ?group=.
+func (d *daemon) handleStandby(w http.ResponseWriter, r *http.Request) {
+	mg, err := d.lookup(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if mg.policy != "hot-standby" {
+		http.Error(w, fmt.Sprintf("group %s runs %s, not hot-standby", mg.group, mg.policy), http.StatusConflict)
+		return
+	}
+	mg.group.ServeStandby(w, r)
+}
+
+// handleJSQ serves the queue depths of the group named by ?group= and
+// adjusts its tie-break rule.
+func (d *daemon) handleJSQ(w http.ResponseWriter, r *http.Request) {
+	mg, err := d.lookup(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if mg.policy != "jsq" {
+		http.Error(w, fmt.Sprintf("group %s runs %s, not jsq", mg.group, mg.policy), http.StatusConflict)
+		return
+	}
+	mg.group.ServeJSQ(w, r)
+}
+
+func main() {
+	if err := run(); err != nil {
+		reuseportlb.Fatal("lbd failed", "err", err)
+	}
+}
+
+// run loads and serves the groups' selectors until SIGTERM or a collector
+// fails. Failures are returned rather than fatal, so the deferred calls
+// unpin what lbd pinned.
+func run() error {
+	cfg := reuseportlb.DefaultCollectorConfig()
+	cpuCoresStr := flag.String("cpus", "", "CPU cores to monitor, as numbers and ranges (e.g. \"0 1 2 3\" or \"0-7,16-23\"); empty monitors every online core, following hotplug")
+	housekeepingStr := flag.String("housekeeping-cpus", "", "CPU cores left to the kernel and background work, as numbers and ranges, or auto for every online core not isolated with isolcpus= or nohz_full=; slot utilization then only counts the owners' other, application, cores")
+	maxCPUs := flag.Int("max-cpus", 0, "cores cpu_util_map has room for; 0 sizes it for every possible CPU of the host (at least "+fmt.Sprint(reuseportlb.DefaultMaxCPUs)+", at most 1024)")
+	flag.StringVar(&cfg.LogDir, "logdir", cfg.LogDir, "directory where log files will be written")
+	flag.DurationVar(&cfg.Period, "period", cfg.Period, "interval between log snapshots")
+	flag.StringVar(&cfg.AcceptqReduce, "acceptq-reduce", cfg.AcceptqReduce, "how per-CPU accept queue entries are aggregated: sum or max")
+	flag.StringVar(&cfg.AcceptqHook, "acceptq-hook", cfg.AcceptqHook, "how the accept queue tracker attaches: auto, fentry or kprobe")
+	flag.DurationVar(&cfg.Interval, "interval", cfg.Interval, "interval between CPU samples and map updates")
+	flag.Float64Var(&cfg.Alpha, "alpha", cfg.Alpha, "EWMA smoothing factor for CPU utilization (0 < alpha <= 1); higher reacts faster")
+	flag.BoolVar(&cfg.Adaptive, "adaptive", cfg.Adaptive, "adapt the smoothing factor per core to how noisy its utilization is, within [-alpha-min, -alpha-max]")
+	flag.Float64Var(&cfg.AlphaMin, "alpha-min", cfg.AlphaMin, "lower bound for the smoothing factor in -adaptive mode")
+	flag.BoolVar(&cfg.Events, "events", cfg.Events, "take accept queue depths from tracker notifications instead of polling, and sample CPUs every "+reuseportlb.EventDrivenInterval.String()+" unless -interval is given")
+	flag.BoolVar(&cfg.Latency, "latency", cfg.Latency, "attach accept-to-response latency probes, log per-slot quantiles and serve /latency")
+	flag.BoolVar(&cfg.ConnStats, "conn-stats", cfg.ConnStats, "track connection lifetimes, bytes and retransmits per slot with sock_ops, log them and serve /connstats")
+	flag.BoolVar(&cfg.NetDistress, "net-distress", cfg.NetDistress, "count retransmits and ECN window reductions per slot, score them against the group's median in slot_net for the net health signal, and log them")
+	flag.BoolVar(&cfg.CgroupUtil, "cgroup-util", cfg.CgroupUtil, "derive slot utilization from the CPU time of each slot owner's cgroup (servers run with -cgroup) instead of from its cores")
+	flag.StringVar(&cfg.CPUSource, "cpu-source", cfg.CPUSource, "where CPU utilization is read from: stat (/proc/stat ticks), schedstat (/proc/schedstat run time) or psi (slot utilization from each owner's cgroup CPU pressure)")
+	deadband := flag.Uint("update-deadband", uint(cfg.UpdateDeadband), "hundredths of a percent a utilization may move before its map entry is written again (0 writes any change)")
+	flag.DurationVar(&cfg.UpdateRefresh, "update-refresh", cfg.UpdateRefresh, "how often unchanged utilization entries are rewritten anyway; 0 writes every entry every -interval")
+	flag.Float64Var(&cfg.MaxUpdateRate, "max-update-rate", cfg.MaxUpdateRate, "most utilization map entries written per second, biggest changes first; 0 for no limit")
+	flag.BoolVar(&cfg.Power, "power", cfg.Power, "read each CPU package's power draw from RAPL (powercap), log it and publish it as the package_power expvar")
+	flag.Float64Var(&cfg.AlphaMax, "alpha-max", cfg.AlphaMax, "upper bound for the smoothing factor in -adaptive mode")
+	logLevel := flag.String("log-level", "info", "minimum log level: debug, info, warn or error")
+	logFormat := flag.String("log-format", "text", "log output format: text or json")
+	auditLog := flag.String("audit-log", reuseportlb.DefaultAuditLog, "file every change lbd makes to the pinned maps is appended to, as JSON lines; served, with every other process's changes, at /audit; empty disables it")
+	controlAddr := flag.String("control-addr", "127.0.0.1:9089", "address for the control API (status, rate limit, pprof, expvar)")
+	registryPath := flag.String("registry", reuseportlb.DefaultRegistrySocket, "unix socket where unprivileged servers register their listeners; empty disables it")
+	keepPins := flag.Bool("keep-pins", false, "leave the groups' pinned maps and programs behind on exit even if no server still uses them")
+	registryMode := flag.String("registry-mode", "0660", "permissions of the registry socket; 0666 lets any local user register")
+	migrate := flag.Bool("migrate", true, "migrate queued connections off draining servers (tcp_migrate_req, plus a migration-aware selector where supported)")
+	chain := flag.String("chain", strings.Join(reuseportlb.DefaultChain, ","), "comma-separated stages run by groups with the chain policy: filters exclude-draining, exclude-overloaded, slow-syn, then a selector round-robin or first")
+	overloadPct := flag.Uint("chain-overload-pct", reuseportlb.DefaultOverloadPct, "accept queue fill, in percent, at which exclude-overloaded skips a slot; 0 disables it")
+	slowSYNPct := flag.Uint("chain-slow-syn-pct", 0, "percentage of SYNs slow-syn drops while every slot is past -chain-overload-pct, so clients retry later")
+	canarySlot := flag.Uint("canary-slot", 0, "slot that receives the canary share in groups with the splitter policy")
+	canaryPct := flag.Uint("canary-pct", 0, "percentage of new connections the splitter policy sends to -canary-slot; adjustable at runtime via /split")
+	primarySlot := flag.Uint("primary-slot", 0, "slot that gets every connection in groups with the hot-standby policy while it is healthy")
+	standbySlot := flag.Uint("standby-slot", 1, "slot that takes over in groups with the hot-standby policy; adjustable at runtime via /standby")
+	heartbeatTimeout := flag.Duration("heartbeat-timeout", reuseportlb.DefaultHeartbeatTimeout, "how stale a slot's heartbeat may get before hot-standby moves traffic off it; servers registered through the registry do not heartbeat and are judged by their listener alone")
+	priorities := flag.String("priorities", "", "hot-standby priority groups as slot=level pairs, level 1 highest (e.g. 0=1,1=1,2=2); replaces -primary-slot and -standby-slot")
+	spillPct := flag.Uint("spill-pct", 0, "with -priorities, percentage of connections that spill to the next level once the active level's accept queues reach -spill-threshold-pct")
+	spillThreshold := flag.Uint("spill-threshold-pct", reuseportlb.DefaultSpillThresholdPct, "accept queue fill, in percent, at which the spillover policy moves on to the next slot, and at which hot-standby -priorities start spilling -spill-pct (an average over the level)")
+	paramsStr := flag.String("params", "", "policy parameters as name=value pairs, each applied to the groups whose policy has it (e.g. group_size=8 for round-robin); adjustable at runtime via /params")
+	slotLimitsStr := flag.String("slot-limits", "", "per-slot connection rate caps applied to every group, as slot=rate[/burst][:redistribute|drop] (e.g. 3=5/10:drop); adjustable at runtime via /slotlimit")
+	tieBreak := flag.String("tie-break", "random", "how groups with the jsq policy choose among equally short queues: random or round-robin; adjustable at runtime via /jsq")
+	shadowStr := flag.String("shadow", "", "candidate policies to run in shadow mode, as [group=]policy pairs: they see every connection and their choices are recorded for lbctl shadow, but the group's policy places them")
+	steerPath := flag.String("steer-config", "", "JSON tenant table for groups with the steer policy")
+	featuresStr := flag.String("features", reuseportlb.DefaultFeatures.String(), "comma-separated parts of the selectors to load, all or none: override (lbctl override), source-limit (/ratelimit), slot-limits (/slotlimit), warmup (servers registering with a warm-up), shadow, counts (the watchdog and federation), trace (to trace_pipe); -ratelimit-max, -slot-limits, -shadow and -outlier-factor add what they need")
+	var wd reuseportlb.WatchdogConfig
+	flag.Float64Var(&wd.MaxSkew, "watchdog-skew", 0, "alert when a listening slot's share of new connections strays further than this fraction from an even share (0.5: half to one and a half times it) for -watchdog-for; 0 disables the watchdog")
+	flag.DurationVar(&wd.For, "watchdog-for", 10*time.Second, "how long the skew has to stay over -watchdog-skew before the watchdog alerts, and back under it before the alert resolves")
+	flag.DurationVar(&wd.Interval, "watchdog-interval", reuseportlb.DefaultWatchdogInterval, "how often the watchdog reads the selection counters")
+	flag.Uint64Var(&wd.MinConns, "watchdog-min-conns", 20, "fewest new connections an interval needs for the watchdog to judge it")
+	flag.StringVar(&wd.Webhook, "watchdog-webhook", "", "URL every watchdog alert and resolution is POSTed to as JSON")
+	var od reuseportlb.OutlierConfig
+	flag.Float64Var(&od.Factor, "outlier-factor", 0, "quarantine a slot whose mean latency reaches this many times the median of the group's slots for -outlier-consecutive intervals in a row (needs -latency); 0 disables outlier detection")
+	flag.DurationVar(&od.Interval, "outlier-interval", reuseportlb.DefaultOutlierInterval, "how often slots are judged for outlier detection")
+	flag.Float64Var(&od.ErrorPct, "outlier-error-pct", 0, "also count a slot as an outlier when its error rate is this many percentage points over the median of the group's slots (servers publish it with -error-interval); 0 judges latency alone")
+	flag.IntVar(&od.Consecutive, "outlier-consecutive", 3, "intervals in a row a slot has to be an outlier before it is quarantined")
+	flag.Uint64Var(&od.MinRequests, "outlier-min-requests", 20, "fewest requests a slot needs to serve in an interval to be judged in it")
+	flag.DurationVar(&od.Quarantine, "outlier-quarantine", 30*time.Second, "how long a slot is quarantined the first time; doubled each time it is again, up to -outlier-max-quarantine")
+	flag.DurationVar(&od.MaxQuarantine, "outlier-max-quarantine", 5*time.Minute, "longest quarantine; a slot that stays in rotation this long starts over")
+	flag.Float64Var(&od.MaxFraction, "outlier-max-fraction", 0.5, "largest share of a group's slots quarantined at once (one always may be)")
+	flag.DurationVar(&od.Rampup, "outlier-rampup", 10*time.Second, "slow-start window a released slot gets")
+	flag.StringVar(&od.Webhook, "outlier-webhook", "", "URL every quarantine and release is POSTed to as JSON")
+	var fed reuseportlb.FederationConfig
+	fedListen := flag.String("federation-addr", "", "address to gossip load summaries with other hosts' lbd on, serving only /federation; empty disables federation")
+	flag.StringVar(&fed.Addr, "federation-advertise", "", "host:port the other hosts reach -federation-addr at (default -federation-addr, with this host's name if it has no host)")
+	fedPeers := flag.String("federation-peers", "", "comma-separated host:port of other hosts' -federation-addr to start gossiping with; the rest are learned from them")
+	flag.StringVar(&fed.Host, "federation-host", "", "name this host gossips under (default the hostname)")
+	flag.DurationVar(&fed.Interval, "federation-interval", reuseportlb.DefaultFederationInterval, "how often load summaries are refreshed and exchanged")
+	var dnsCfg reuseportlb.DNSConfig
+	dnsAddr := flag.String("dns-addr", "", "UDP address to publish the federation's per-host weights on as DNS records; needs -federation-addr, and empty disables it")
+	flag.StringVar(&dnsCfg.Domain, "dns-domain", "lb.internal", "zone the DNS records are published in: <group>.<zone> (A/AAAA, one host drawn by weight) and _<service>._tcp.<group>.<zone> (SRV, every host with its weight)")
+	flag.StringVar(&dnsCfg.Service, "dns-service", "http", "service name of the SRV records")
+	dnsPort := flag.Uint("dns-port", 8080, "port the SRV records point clients at")
+	flag.DurationVar(&dnsCfg.TTL, "dns-ttl", reuseportlb.DefaultDNSTTL, "TTL of the DNS records")
+	rlMax := flag.Uint("ratelimit-max", 0, "max new connections per IPv4 source per -ratelimit-window; 0 disables the limiter")
+	rlWindow := flag.Duration("ratelimit-window", time.Second, "rate limit window")
+	rlAction := flag.String("ratelimit-action", "drop", "what to do with connections over the limit: drop or deprioritize")
+	rlPenaltySlot := flag.Uint("ratelimit-penalty-slot", 0, "slot that receives deprioritized connections")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <policy> | <group>=<policy>...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	reuseportlb.SetAuditLog(*auditLog)
+	logger, err := reuseportlb.NewLogger(os.Stderr, *logLevel, *logFormat)
+	if err != nil {
+		return fmt.Errorf("invalid logging flags: %w", err)
+	}
+	slog.SetDefault(logger)
+
+	groups, err := parseGroupArgs(flag.Args())
+	if err != nil {
+		fmt.Fprintln(flag.CommandLine.Output(), err)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if cfg.Events && !flagSet("interval") {
+		cfg.Interval = reuseportlb.EventDrivenInterval
+	}
+	if *maxCPUs != 0 {
+		if err := reuseportlb.SetMaxCPUs(*maxCPUs); err != nil {
+			return fmt.Errorf("invalid -max-cpus: %w", err)
+		}
+	}
+	cfg.CPUs, err = reuseportlb.ParseCPUList(*cpuCoresStr)
+	if err != nil {
+		return fmt.Errorf("invalid -cpus: %w", err)
+	}
+	cfg.HousekeepingCPUs, err = reuseportlb.ParseHousekeepingCPUs(*housekeepingStr)
+	if err != nil {
+		return fmt.Errorf("invalid -housekeeping-cpus: %w", err)
+	}
+	if *deadband > 10000 {
+		return errors.New("invalid -update-deadband: must be at most 10000 (100%)")
+	}
+	cfg.UpdateDeadband = uint32(*deadband)
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid collector settings: %w", err)
+	}
+	if wd.MaxSkew < 0 || wd.Interval <= 0 {
+		return errors.New("invalid watchdog flags: -watchdog-skew must not be negative and -watchdog-interval must be positive")
+	}
+	if od.Factor != 0 {
+		if err := od.Validate(); err != nil {
+			return fmt.Errorf("invalid outlier detection flags: %w", err)
+		}
+		if !cfg.Latency {
+			return errors.New("-outlier-factor needs -latency: slots are judged by their latency")
+		}
+	}
+	rlAct, err := reuseportlb.ParseRateLimitAction(*rlAction)
+	if err != nil {
+		return fmt.Errorf("invalid rate limit flags: %w", err)
+	}
+	chainStages, err := reuseportlb.ParseChain(*chain)
+	if err != nil {
+		return fmt.Errorf("invalid -chain: %w", err)
+	}
+	prioGroups, err := reuseportlb.ParsePriorities(*priorities)
+	if err != nil {
+		return fmt.Errorf("invalid -priorities: %w", err)
+	}
+	jsqTieBreak, err := reuseportlb.ParseTieBreak(*tieBreak)
+	if err != nil {
+		return fmt.Errorf("invalid -tie-break: %w", err)
+	}
+	params, err := reuseportlb.ParseParams(*paramsStr)
+	if err != nil {
+		return fmt.Errorf("invalid -params: %w", err)
+	}
+	for name := range params {
+		used := false
+		for _, mg := range groups {
+			_, ok := mg.params(params)[name]
+			used = used || ok
+		}
+		if !used {
+			return fmt.Errorf("invalid -params: no group's policy has the parameter %s", name)
+		}
+	}
+	slotLimits, err := reuseportlb.ParseSlotLimits(*slotLimitsStr)
+	if err != nil {
+		return fmt.Errorf("invalid -slot-limits: %w", err)
+	}
+	shadows, err := parseShadows(*shadowStr, groups)
+	if err != nil {
+		return fmt.Errorf("invalid -shadow: %w", err)
+	}
+	features, err := reuseportlb.ParseFeatures(*featuresStr)
+	if err != nil {
+		return fmt.Errorf("invalid -features: %w", err)
+	}
+	if *rlMax > 0 {
+		features |= reuseportlb.FeatureSourceLimit
+	}
+	if len(slotLimits) > 0 {
+		features |= reuseportlb.FeatureSlotLimits
+	}
+	if od.Factor != 0 {
+		features |= reuseportlb.FeatureSlotLimits | reuseportlb.FeatureWarmup
+	}
+	var steer reuseportlb.SteerConfig
+	if *steerPath != "" {
+		if steer, err = reuseportlb.LoadSteerConfig(*steerPath); err != nil {
+			return fmt.Errorf("invalid -steer-config: %w", err)
+		}
+	}
+	mode, err := strconv.ParseUint(*registryMode, 8, 32)
+	if err != nil {
+		return fmt.Errorf("invalid -registry-mode %s: %w", *registryMode, err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	for _, mg := range groups {
+		if err := reuseportlb.Preflight(mg.policy, cfg.Latency || cfg.ConnStats || cfg.NetDistress); err != nil {
+			return fmt.Errorf("missing privileges for group %s: %w", mg.group, err)
+		}
+	}
+	if err := reuseportlb.EnsureBpffs(); err != nil {
+		return fmt.Errorf("set up bpffs: %w", err)
+	}
+	if err := reuseportlb.EnsureLayout(); err != nil {
+		return fmt.Errorf("check map layout: %w", err)
+	}
+	if *migrate {
+		if err := reuseportlb.EnableRequestMigration(); err != nil {
+			slog.Warn("accept queue migration unavailable, queued connections are reset on drain", "err", err)
+		}
+	}
+	if err := rlimit.RemoveMemlock(); err != nil {
+		slog.Warn("removing memlock failed", "err", err)
+	}
+
+	rl := reuseportlb.RateLimitConfig{
+		Enabled:     *rlMax > 0,
+		MaxConns:    uint32(*rlMax),
+		Window:      *rlWindow,
+		Action:      rlAct,
+		PenaltySlot: uint32(*rlPenaltySlot),
+	}
+	reg := &reuseportlb.Registry{Programs: make(map[reuseportlb.Group]*ebpf.Program)}
+	for _, mg := range groups {
+		log := slog.With("group", mg.group.String(), "policy", mg.policy)
+		// A selector pinned by a previous lbd is taken over while servers
+		// still use the group; otherwise stale pins are replaced.
+		groupFeatures := features
+		if _, ok := shadows[mg.group]; ok {
+			groupFeatures |= reuseportlb.FeatureShadow
+		}
+		objs, err := mg.group.LoadSharedPolicy(mg.policy, *migrate, groupFeatures)
+		switch {
+		case errors.Is(err, reuseportlb.ErrPolicyUnsupported):
+			return fmt.Errorf("invalid policy %q for group %s, valid: %s", mg.policy, mg.group, strings.Join(reuseportlb.Policies, ", "))
+		case errors.Is(err, reuseportlb.ErrKernelFeatureMissing):
+			return fmt.Errorf("kernel too old for policy %s of group %s: %w", mg.policy, mg.group, err)
+		case err != nil:
+			return fmt.Errorf("load eBPF policy for group %s: %w", mg.group, err)
+		}
+		defer objs.Close()
+		mg.selectOrMigrate = objs.SelectOrMigrate
+		log.Info("loaded and pinned selector", "path", mg.group.ProgramPath(), "select_or_migrate", objs.SelectOrMigrate, "features", groupFeatures.String())
+		if !*keepPins {
+			leave, err := mg.group.Join()
+			if err != nil {
+				return fmt.Errorf("record instance for group %s: %w", mg.group, err)
+			}
+			defer func() {
+				if err := leave(); err != nil {
+					log.Error("unpinning group failed", "err", err)
+				}
+			}()
+		}
+
+		if err := mg.group.SetRateLimit(rl); err != nil {
+			return fmt.Errorf("configure rate limit for group %s: %w", mg.group, err)
+		}
+		if err := mg.group.SetParams(mg.policy, mg.params(params)); err != nil {
+			return fmt.Errorf("configure policy parameters for group %s: %w", mg.group, err)
+		}
+		for slot, l := range slotLimits {
+			if err := mg.group.SetSlotLimit(slot, l); err != nil {
+				return fmt.Errorf("configure slot limit of slot %d for group %s: %w", slot, mg.group, err)
+			}
+			log.Info("capped slot connection rate", "slot", slot, "rate", l.Rate, "burst", l.Burst, "action", l.Action)
+		}
+		if mg.policy == "chain" {
+			if err := mg.group.SetOverloadThreshold(uint32(*overloadPct)); err != nil {
+				return fmt.Errorf("configure chain policy for group %s: %w", mg.group, err)
+			}
+			if err := mg.group.SetSlowSYN(uint32(*slowSYNPct)); err != nil {
+				return fmt.Errorf("configure chain policy for group %s: %w", mg.group, err)
+			}
+			if err := mg.group.SetChain(chainStages); err != nil {
+				return fmt.Errorf("configure chain policy for group %s: %w", mg.group, err)
+			}
+			log.Info("installed policy chain", "stages", chainStages, "overload_pct", *overloadPct, "slow_syn_pct", *slowSYNPct)
+		}
+		if mg.policy == "steer" {
+			if err := mg.group.ApplySteerConfig(steer); err != nil {
+				return fmt.Errorf("configure steering for group %s: %w", mg.group, err)
+			}
+			log.Info("configured steering tenants", "tenants", len(steer.Tenants))
+		}
+		if mg.policy == "splitter" {
+			split := reuseportlb.SplitConfig{CanarySlot: uint32(*canarySlot), Percent: uint32(*canaryPct)}
+			if err := mg.group.SetSplit(split); err != nil {
+				return fmt.Errorf("configure splitter for group %s: %w", mg.group, err)
+			}
+			log.Info("configured canary split", "canary_slot", split.CanarySlot, "percent", split.Percent)
+		}
+		if mg.policy == "spillover" {
+			if err := mg.group.SetSpillThreshold(uint32(*spillThreshold)); err != nil {
+				return fmt.Errorf("configure spillover for group %s: %w", mg.group, err)
+			}
+			log.Info("configured spillover", "threshold_pct", *spillThreshold)
+		}
+		if mg.policy == "jsq" {
+			if err := mg.group.SetTieBreak(jsqTieBreak); err != nil {
+				return fmt.Errorf("configure jsq for group %s: %w", mg.group, err)
+			}
+			log.Info("configured shortest queue tie-break", "tie_break", jsqTieBreak)
+		}
+		if mg.policy == "hot-standby" {
+			standby := reuseportlb.StandbyConfig{
+				Primary:           uint32(*primarySlot),
+				Standby:           uint32(*standbySlot),
+				HeartbeatTimeout:  *heartbeatTimeout,
+				Priorities:        prioGroups,
+				SpillPct:          uint32(*spillPct),
+				SpillThresholdPct: uint32(*spillThreshold),
+			}
+			if err := mg.group.SetStandby(standby); err != nil {
+				return fmt.Errorf("configure hot standby for group %s: %w", mg.group, err)
+			}
+			log.Info("configured hot standby", "primary", standby.Primary, "standby", standby.Standby, "heartbeat_timeout", standby.HeartbeatTimeout,
+				"priorities", reuseportlb.FormatPriorities(standby.Priorities), "spill_pct", standby.SpillPct)
+		}
+		if candidate, ok := shadows[mg.group]; ok {
+			if mg.shadow, err = mg.group.StartShadow(mg.policy, candidate, objs); err != nil {
+				return fmt.Errorf("start shadow policy for group %s: %w", mg.group, err)
+			}
+			defer mg.shadow.Stop()
+			log.Info("running candidate policy in shadow mode", "candidate", candidate)
+		}
+		if wd.MaxSkew > 0 {
+			mg.watchdog = mg.group.NewWatchdog(mg.policy, wd)
+			log.Info("watching selection skew", "max_skew", wd.MaxSkew, "for", wd.For)
+		}
+		if od.Factor != 0 {
+			mg.outliers = mg.group.NewOutlierDetector(od)
+			log.Info("quarantining outlier slots", "factor", od.Factor, "consecutive", od.Consecutive, "quarantine", od.Quarantine)
+		}
+		reg.Programs[mg.group] = objs.Program
+	}
+
+	d := &daemon{groups: groups, started: time.Now(), registry: reg}
+	mux := reuseportlb.NewAdminMux()
+	if *fedListen != "" {
+		if fed.Addr == "" {
+			fed.Addr = *fedListen
+			if host, port, err := net.SplitHostPort(fed.Addr); err == nil && (host == "" || host == "0.0.0.0" || host == "::") {
+				name, err := os.Hostname()
+				if err != nil {
+					return fmt.Errorf("name this host, set -federation-advertise: %w", err)
+				}
+				fed.Addr = net.JoinHostPort(name, port)
+			}
+		}
+		fed.Peers = reuseportlb.ParsePeers(*fedPeers)
+		for _, mg := range groups {
+			fed.Groups = append(fed.Groups, reuseportlb.FederatedGroup{Group: mg.group, Policy: mg.policy})
+		}
+		if d.federation, err = reuseportlb.NewFederation(fed); err != nil {
+			return fmt.Errorf("invalid federation flags: %w", err)
+		}
+		fedMux := http.NewServeMux()
+		fedMux.Handle("/federation", d.federation)
+		fedSrv, err := reuseportlb.ServeAdmin(*fedListen, fedMux)
+		if err != nil {
+			return fmt.Errorf("start federation endpoint on %s: %w", *fedListen, err)
+		}
+		defer fedSrv.Close()
+		mux.Handle("/federation", d.federation)
+		slog.Info("gossiping load summaries", "advertise", fed.Addr, "peers", fed.Peers, "interval", fed.Interval)
+	}
+	if *dnsAddr != "" {
+		if d.federation == nil {
+			return errors.New("-dns-addr needs -federation-addr: the weights come from the federation's view, a federation of one host without peers will do")
+		}
+		if *dnsPort == 0 || *dnsPort > 65535 {
+			return fmt.Errorf("invalid -dns-port %d", *dnsPort)
+		}
+		dnsCfg.Port = uint16(*dnsPort)
+		dnsCfg.Weights = func() map[string][]reuseportlb.WeightedTarget {
+			return reuseportlb.HostWeights(d.federation.Hosts())
+		}
+		dnsSrv, err := reuseportlb.ServeDNS(*dnsAddr, dnsCfg)
+		if err != nil {
+			return fmt.Errorf("publish weights over DNS on %s: %w", *dnsAddr, err)
+		}
+		defer dnsSrv.Close()
+		mux.HandleFunc("/dnsweights", dnsSrv.ServeWeights)
+	}
+	mux.HandleFunc("/status", d.handleStatus)
+	mux.HandleFunc("/ratelimit", d.handleRateLimit)
+	mux.HandleFunc("/split", d.handleSplit)
+	mux.HandleFunc("/standby", d.handleStandby)
+	mux.HandleFunc("/jsq", d.handleJSQ)
+	mux.HandleFunc("/slotlimit", d.handleSlotLimits)
+	mux.HandleFunc("/params", d.handleParams)
+	mux.HandleFunc("/rebalance", d.handleRebalance)
+	mux.HandleFunc("/latency", d.handleLatency)
+	mux.HandleFunc("/connstats", d.handleConnStats)
+	mux.HandleFunc("/audit", d.handleAudit)
+	control, err := reuseportlb.ServeAdmin(*controlAddr, mux)
+	if err != nil {
+		return fmt.Errorf("start control API on %s: %w", *controlAddr, err)
+	}
+	defer control.Close()
+
+	if *registryPath != "" {
+		ln, err := reuseportlb.ListenRegistry(*registryPath, os.FileMode(mode))
+		if err != nil {
+			return fmt.Errorf("listen for registrations on %s: %w", *registryPath, err)
+		}
+		go func() {
+			if err := reg.Serve(ctx, ln); err != nil {
+				slog.Error("registry stopped", "err", err)
+			}
+		}()
+		slog.Info("accepting registrations", "path", *registryPath, "mode", fmt.Sprintf("%#o", mode))
+	}
+
+	// One collector per group; if any of them fails, stop the rest.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	var failed sync.Once
+	var collectErr error
+	if f := d.federation; f != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f.Run(ctx)
+		}()
+	}
+	for _, mg := range groups {
+		if w := mg.watchdog; w != nil {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				w.Run(ctx)
+			}()
+		}
+		if o := mg.outliers; o != nil {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				o.Run(ctx)
+			}()
+		}
+		gcfg := cfg
+		gcfg.Group = mg.group
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := reuseportlb.RunCollector(ctx, gcfg); err != nil {
+				failed.Do(func() { collectErr = fmt.Errorf("collector for group %s: %w", gcfg.Group, err) })
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+	slog.Info("shutting down; servers keep their attached selector until they exit")
+	return collectErr
+}
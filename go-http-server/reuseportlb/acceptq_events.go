@@ -0,0 +1,120 @@
+package reuseportlb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"log/slog"
+	"os"
+	"sync"
+
+	"github.com/cilium/ebpf/ringbuf"
+)
+
+// acceptqEvent mirrors struct acceptq_event in eBPF/acceptq_bpf.c.
+type acceptqEvent struct {
+	Cookie uint64
+	Curr   uint32
+	Max    uint32
+	Cpu    uint32
+	_      uint32
+}
+
+// acceptqCache holds the latest accept queue entry per listener cookie, as
+// reported by acceptq_events.
+type acceptqCache struct {
+	mu      sync.Mutex
+	entries map[uint64]AcceptqEntry
+	events  uint64
+}
+
+func (c *acceptqCache) get(cookie uint64) (AcceptqEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[cookie]
+	return e, ok
+}
+
+// Events returns how many change notifications have been consumed.
+func (c *acceptqCache) Events() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.events
+}
+
+// acceptqWatch is the process's single consumer of acceptq_events. A ring
+// buffer hands each record to one reader only, so collectors for different
+// groups share it instead of reading it themselves. Only one process per
+// host should run event-driven collectors.
+var acceptqWatch struct {
+	sync.Mutex
+	refs  int
+	cache *acceptqCache
+	stop  func()
+}
+
+// watchAcceptq starts consuming acceptq_events, or joins the consumer that
+// is already running, and returns the cache it fills. The returned func
+// releases the caller's reference.
+func watchAcceptq() (*acceptqCache, func(), error) {
+	acceptqWatch.Lock()
+	defer acceptqWatch.Unlock()
+	if acceptqWatch.refs == 0 {
+		cache, stop, err := startAcceptqWatch()
+		if err != nil {
+			return nil, nil, err
+		}
+		acceptqWatch.cache, acceptqWatch.stop = cache, stop
+	}
+	acceptqWatch.refs++
+
+	var once sync.Once
+	return acceptqWatch.cache, func() {
+		once.Do(func() {
+			acceptqWatch.Lock()
+			defer acceptqWatch.Unlock()
+			if acceptqWatch.refs--; acceptqWatch.refs == 0 {
+				acceptqWatch.stop()
+			}
+		})
+	}, nil
+}
+
+func startAcceptqWatch() (*acceptqCache, func(), error) {
+	m, err := OpenPinnedMap(AcceptqEventsMap)
+	if err != nil {
+		return nil, nil, err
+	}
+	rd, err := ringbuf.NewReader(m)
+	m.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cache := &acceptqCache{entries: make(map[uint64]AcceptqEntry)}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var ev acceptqEvent
+		for {
+			rec, err := rd.Read()
+			if err != nil {
+				if !errors.Is(err, os.ErrClosed) {
+					slog.Error("Reading accept queue events failed", "err", err)
+				}
+				return
+			}
+			if err := binary.Read(bytes.NewReader(rec.RawSample), binary.NativeEndian, &ev); err != nil {
+				continue
+			}
+			cache.mu.Lock()
+			cache.entries[ev.Cookie] = AcceptqEntry{Curr: ev.Curr, Max: ev.Max, Cpu: ev.Cpu}
+			cache.events++
+			cache.mu.Unlock()
+		}
+	}()
+	return cache, func() {
+		rd.Close()
+		<-done
+	}, nil
+}
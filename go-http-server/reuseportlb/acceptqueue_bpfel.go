@@ -1,7 +1,7 @@
 // Code generated by bpf2go; DO NOT EDIT.
 //go:build 386 || amd64 || arm || arm64 || loong64 || mips64le || mipsle || ppc64le || riscv64
 
-package main
+package reuseportlb
 
 import (
 	"bytes"
@@ -18,6 +18,45 @@ type acceptqueueAcceptq struct {
 	Cpu  uint32
 }
 
+type acceptqueueRatelimitCfg struct {
+	Enabled     uint32
+	Action      uint32
+	PenaltySlot uint32
+	MaxConns    uint32
+	WindowNs    uint64
+}
+
+type acceptqueueShadowState struct {
+	Phase     uint32
+	Candidate uint32
+}
+
+type acceptqueueSlotBucket struct {
+	Rate      uint64
+	Burst     uint64
+	Action    uint32
+	Pad       uint32
+	Tokens    uint64
+	LastNs    uint64
+	Throttled uint64
+}
+
+type acceptqueueSlotOverride struct {
+	Slot uint32
+	Hits uint32
+}
+
+type acceptqueueSlotWarmup struct {
+	StartNs  uint64
+	WindowNs uint64
+}
+
+type acceptqueueSrcRate struct {
+	WindowStart uint64
+	Count       uint32
+	Limited     uint32
+}
+
 // loadAcceptqueue returns the embedded CollectionSpec for acceptqueue.
 func loadAcceptqueue() (*ebpf.CollectionSpec, error) {
 	reader := bytes.NewReader(_AcceptqueueBytes)
@@ -68,6 +107,17 @@ type acceptqueueProgramSpecs struct {
 type acceptqueueMapSpecs struct {
 	AcceptqMap          *ebpf.MapSpec `ebpf:"acceptq_map"`
 	AcceptqSlotCookies  *ebpf.MapSpec `ebpf:"acceptq_slot_cookies"`
+	PolicyCfg           *ebpf.MapSpec `ebpf:"policy_cfg"`
+	RatelimitCfg        *ebpf.MapSpec `ebpf:"ratelimit_cfg"`
+	ShadowEvents        *ebpf.MapSpec `ebpf:"shadow_events"`
+	ShadowProgs         *ebpf.MapSpec `ebpf:"shadow_progs"`
+	ShadowScratch       *ebpf.MapSpec `ebpf:"shadow_scratch"`
+	SlotBucket          *ebpf.MapSpec `ebpf:"slot_bucket"`
+	SlotDropping        *ebpf.MapSpec `ebpf:"slot_dropping"`
+	SlotOverride        *ebpf.MapSpec `ebpf:"slot_override"`
+	SlotSelected        *ebpf.MapSpec `ebpf:"slot_selected"`
+	SlotWarmup          *ebpf.MapSpec `ebpf:"slot_warmup"`
+	SrcRate             *ebpf.MapSpec `ebpf:"src_rate"`
 	TcpBalancingTargets *ebpf.MapSpec `ebpf:"tcp_balancing_targets"`
 }
 
@@ -92,6 +142,17 @@ func (o *acceptqueueObjects) Close() error {
 type acceptqueueMaps struct {
 	AcceptqMap          *ebpf.Map `ebpf:"acceptq_map"`
 	AcceptqSlotCookies  *ebpf.Map `ebpf:"acceptq_slot_cookies"`
+	PolicyCfg           *ebpf.Map `ebpf:"policy_cfg"`
+	RatelimitCfg        *ebpf.Map `ebpf:"ratelimit_cfg"`
+	ShadowEvents        *ebpf.Map `ebpf:"shadow_events"`
+	ShadowProgs         *ebpf.Map `ebpf:"shadow_progs"`
+	ShadowScratch       *ebpf.Map `ebpf:"shadow_scratch"`
+	SlotBucket          *ebpf.Map `ebpf:"slot_bucket"`
+	SlotDropping        *ebpf.Map `ebpf:"slot_dropping"`
+	SlotOverride        *ebpf.Map `ebpf:"slot_override"`
+	SlotSelected        *ebpf.Map `ebpf:"slot_selected"`
+	SlotWarmup          *ebpf.Map `ebpf:"slot_warmup"`
+	SrcRate             *ebpf.Map `ebpf:"src_rate"`
 	TcpBalancingTargets *ebpf.Map `ebpf:"tcp_balancing_targets"`
 }
 
@@ -99,6 +160,17 @@ func (m *acceptqueueMaps) Close() error {
 	return _AcceptqueueClose(
 		m.AcceptqMap,
 		m.AcceptqSlotCookies,
+		m.PolicyCfg,
+		m.RatelimitCfg,
+		m.ShadowEvents,
+		m.ShadowProgs,
+		m.ShadowScratch,
+		m.SlotBucket,
+		m.SlotDropping,
+		m.SlotOverride,
+		m.SlotSelected,
+		m.SlotWarmup,
+		m.SrcRate,
 		m.TcpBalancingTargets,
 	)
 }
@@ -0,0 +1,80 @@
+package reuseportlb
+
+import (
+	"errors"
+	"expvar"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime/metrics"
+	"sync"
+)
+
+var publishRuntimeOnce sync.Once
+
+// NewAdminMux returns a mux with the endpoints every binary exposes on its
+// admin port:
+//
+//	/debug/pprof/  net/http/pprof profiles
+//	/debug/vars    expvar, including a runtime_metrics snapshot
+//	/healthz       liveness, 200 while the process serves HTTP
+//
+// The admin port is separate from the balanced port so that requests for it
+// always reach the same process.
+func NewAdminMux() *http.ServeMux {
+	publishRuntimeOnce.Do(func() {
+		expvar.Publish("runtime_metrics", expvar.Func(readRuntimeMetrics))
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/healthz", ServeHealthz)
+	return mux
+}
+
+// ServeAdmin binds addr and serves mux on it in the background. Bind errors
+// are returned directly; later serve errors are logged.
+func ServeAdmin(addr string, mux *http.ServeMux) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	srv := &http.Server{Addr: ln.Addr().String(), Handler: mux}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Admin server stopped", "addr", srv.Addr, "err", err)
+		}
+	}()
+	slog.Info("Admin server listening", "addr", srv.Addr)
+	return srv, nil
+}
+
+// readRuntimeMetrics samples every scalar metric from runtime/metrics.
+// Histograms are skipped; their quantiles are better read from pprof.
+func readRuntimeMetrics() any {
+	descs := metrics.All()
+	samples := make([]metrics.Sample, 0, len(descs))
+	for _, d := range descs {
+		if d.Kind == metrics.KindUint64 || d.Kind == metrics.KindFloat64 {
+			samples = append(samples, metrics.Sample{Name: d.Name})
+		}
+	}
+	metrics.Read(samples)
+
+	out := make(map[string]any, len(samples))
+	for _, s := range samples {
+		switch s.Value.Kind() {
+		case metrics.KindUint64:
+			out[s.Name] = s.Value.Uint64()
+		case metrics.KindFloat64:
+			out[s.Name] = s.Value.Float64()
+		}
+	}
+	return out
+}
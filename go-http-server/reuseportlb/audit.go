@@ -0,0 +1,332 @@
+package reuseportlb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cilium/ebpf"
+)
+
+// DefaultAuditLog is where map mutations are journaled unless SetAuditLog
+// says otherwise.
+const DefaultAuditLog = "/var/log/reuseportlb-audit.jsonl"
+
+// AuditEntry is one change a process made to a pinned map. Keys and values
+// are hex encoded as the eBPF programs see them; Old is empty when the key
+// did not exist and New is empty for a delete.
+type AuditEntry struct {
+	Time  time.Time `json:"ts"`
+	PID   int       `json:"pid"`
+	Comm  string    `json:"comm"`
+	Group string    `json:"group"`
+	Map   string    `json:"map"`
+	Op    string    `json:"op"`
+	Key   string    `json:"key"`
+	Old   string    `json:"old,omitempty"`
+	New   string    `json:"new,omitempty"`
+}
+
+// Audit operations.
+const (
+	AuditUpdate = "update"
+	AuditDelete = "delete"
+)
+
+// audit is the process's journal of map mutations, shared by every
+// process on the host: each entry is a single O_APPEND write, so lines from
+// different processes do not interleave.
+var audit struct {
+	sync.Mutex
+	path   string
+	file   *os.File
+	failed bool
+	comm   string
+}
+
+func init() {
+	audit.path = DefaultAuditLog
+	if comm, err := os.ReadFile("/proc/self/comm"); err == nil {
+		audit.comm = strings.TrimSpace(string(comm))
+	}
+}
+
+// SetAuditLog journals map mutations to path from now on; "" stops
+// journaling.
+func SetAuditLog(path string) {
+	audit.Lock()
+	defer audit.Unlock()
+	if audit.file != nil {
+		audit.file.Close()
+		audit.file = nil
+	}
+	audit.path, audit.failed = path, false
+}
+
+// AuditLog returns the path map mutations are journaled to, or "".
+func AuditLog() string {
+	audit.Lock()
+	defer audit.Unlock()
+	return audit.path
+}
+
+// appendAudit writes e to the journal. The journal is a diagnostic aid:
+// failing to open it is logged once, and the mutation goes ahead anyway.
+func appendAudit(e AuditEntry) {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+
+	audit.Lock()
+	defer audit.Unlock()
+	if audit.path == "" || audit.failed {
+		return
+	}
+	if audit.file == nil {
+		f, err := os.OpenFile(audit.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			slog.Warn("Opening map audit log failed, map changes are not journaled", "path", audit.path, "err", err)
+			audit.failed = true
+			return
+		}
+		audit.file = f
+	}
+	if _, err := audit.file.Write(line); err != nil {
+		slog.Warn("Writing map audit log failed", "path", audit.path, "err", err)
+	}
+}
+
+// auditedMap is a pinned map whose updates and deletes are journaled. It
+// is what the setters in this package write through; the collector's
+// periodic samples (cpu_util_map, slot_util, accept queue refreshes) and
+// standby heartbeats are telemetry rather than changes, and skip it.
+type auditedMap struct {
+	*ebpf.Map
+	group Group
+	name  string
+}
+
+// openAudited is OpenPinnedMap for maps the caller is about to change.
+func (g Group) openAudited(name string) (*auditedMap, error) {
+	m, err := g.OpenPinnedMap(name)
+	if err != nil {
+		return nil, err
+	}
+	return &auditedMap{Map: m, group: g, name: name}, nil
+}
+
+// openOrCreateAudited is OpenOrCreatePinnedMap for maps the caller is about
+// to change.
+func (g Group) openOrCreateAudited(name string) (*auditedMap, error) {
+	m, err := g.OpenOrCreatePinnedMap(name)
+	if err != nil {
+		return nil, err
+	}
+	return &auditedMap{Map: m, group: g, name: name}, nil
+}
+
+func (m *auditedMap) Update(key, value any, flags ebpf.MapUpdateFlags) error {
+	old, _ := m.Map.LookupBytes(key)
+	if err := m.Map.Update(key, value, flags); err != nil {
+		return err
+	}
+	m.record(AuditUpdate, key, old, value)
+	return nil
+}
+
+func (m *auditedMap) Delete(key any) error {
+	old, _ := m.Map.LookupBytes(key)
+	if err := m.Map.Delete(key); err != nil {
+		return err
+	}
+	m.record(AuditDelete, key, old, nil)
+	return nil
+}
+
+func (m *auditedMap) record(op string, key any, old []byte, value any) {
+	e := AuditEntry{
+		Time:  time.Now(),
+		PID:   os.Getpid(),
+		Comm:  audit.comm,
+		Group: m.group.String(),
+		Map:   m.name,
+		Op:    op,
+		Key:   auditBytes(key),
+		Old:   hex.EncodeToString(old),
+	}
+	if value != nil {
+		e.New = auditBytes(value)
+	}
+	appendAudit(e)
+}
+
+// auditBytes renders a key or value as the bytes the kernel gets. A program
+// stored in a program array is recorded by its ID.
+func auditBytes(v any) string {
+	switch v := v.(type) {
+	case []byte:
+		return hex.EncodeToString(v)
+	case [][]byte:
+		return hex.EncodeToString(bytes.Join(v, nil))
+	case *ebpf.Program:
+		return fmt.Sprintf("prog:%d", progID(v))
+	}
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.NativeEndian, v); err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return hex.EncodeToString(buf.Bytes())
+}
+
+// ReadAudit returns the last n entries of the journal at path, oldest
+// first, keeping those of group only unless group is "". n <= 0 means all.
+func ReadAudit(path, group string, n int) ([]AuditEntry, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var out []AuditEntry
+	_, err = scanAudit(f, group, func(e AuditEntry) {
+		out = append(out, e)
+		if n > 0 && len(out) > 2*n {
+			out = append(out[:0], out[len(out)-n:]...)
+		}
+	})
+	if n > 0 && len(out) > n {
+		out = out[len(out)-n:]
+	}
+	return out, err
+}
+
+// scanAudit feeds the complete lines of r to fn and returns how many bytes
+// they took, so a follower can resume after them.
+func scanAudit(r io.Reader, group string, fn func(AuditEntry)) (int64, error) {
+	br := bufio.NewReader(r)
+	var consumed int64
+	for {
+		line, err := br.ReadBytes('\n')
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return consumed, nil // a partial line is left for next time
+			}
+			return consumed, err
+		}
+		consumed += int64(len(line))
+		var e AuditEntry
+		if json.Unmarshal(line, &e) != nil {
+			continue
+		}
+		if group == "" || e.Group == group {
+			fn(e)
+		}
+	}
+}
+
+// auditPoll is how often a followed audit stream checks for new entries.
+const auditPoll = 500 * time.Millisecond
+
+// ServeAudit is an admin handler for the map audit log. GET returns the
+// last n (default 100) entries of the group as JSON; with follow=1 it
+// instead streams every new entry as a JSON line until the client goes
+// away. group=all covers every group.
+func (g Group) ServeAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	path := AuditLog()
+	if path == "" {
+		http.Error(w, "map audit log is disabled", http.StatusNotFound)
+		return
+	}
+	group := g.String()
+	if r.FormValue("group") == "all" {
+		group = ""
+	}
+
+	if r.FormValue("follow") == "" {
+		n := 100
+		if s := r.FormValue("n"); s != "" {
+			var err error
+			if n, err = strconv.Atoi(s); err != nil {
+				http.Error(w, fmt.Sprintf("invalid n: %v", err), http.StatusBadRequest)
+				return
+			}
+		}
+		entries, err := ReadAudit(path, group, n)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		if entries == nil {
+			entries = []AuditEntry{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+		return
+	}
+
+	var offset int64
+	if fi, err := os.Stat(path); err == nil {
+		offset = fi.Size()
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	enc := json.NewEncoder(w)
+	ticker := time.NewTicker(auditPoll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		if fi, err := f.Stat(); err == nil && fi.Size() < offset {
+			offset = 0 // rotated or truncated
+		}
+		var werr error
+		if _, err := f.Seek(offset, io.SeekStart); err == nil {
+			n, _ := scanAudit(f, group, func(e AuditEntry) {
+				if werr == nil {
+					werr = enc.Encode(e)
+				}
+			})
+			offset += n
+		}
+		f.Close()
+		if werr != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package reuseportlb
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+
+	"github.com/cilium/ebpf"
+)
+
+// noBatch is set once the kernel has refused a batch map operation
+// (BPF_MAP_*_BATCH needs Linux 5.6, and not every map type implements them).
+// After that the helpers below go straight to one syscall per key.
+var noBatch atomic.Bool
+
+// batchFailed reports whether err means batching is unavailable rather
+// than that the operation itself went wrong, and remembers it if so.
+func batchFailed(err error) bool {
+	if !errors.Is(err, ebpf.ErrNotSupported) {
+		return false
+	}
+	if !noBatch.Swap(true) {
+		slog.Info("Batch map operations are not supported, falling back to per-key syscalls", "err", err)
+	}
+	return true
+}
+
+// updateUint32s sets keys[i] to values[i] in m, in a single syscall where
+// the kernel allows it.
+func updateUint32s(m *ebpf.Map, keys, values []uint32) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if !noBatch.Load() {
+		_, err := m.BatchUpdate(keys, values, &ebpf.BatchOptions{ElemFlags: uint64(ebpf.UpdateAny)})
+		if err == nil || !batchFailed(err) {
+			return err
+		}
+	}
+	for i := range keys {
+		if err := m.Update(&keys[i], &values[i], ebpf.UpdateAny); err != nil {
+			return fmt.Errorf("key %d: %w", keys[i], err)
+		}
+	}
+	return nil
+}
+
+// lookupSlotCookies returns the listener cookie of every slot in
+// acceptq_slot_cookies. Empty slots read as 0.
+func lookupSlotCookies(m *ebpf.Map) (map[uint32]uint64, error) {
+	out := make(map[uint32]uint64, m.MaxEntries())
+	if !noBatch.Load() {
+		keys := make([]uint32, m.MaxEntries())
+		cookies := make([]uint64, m.MaxEntries())
+		var cursor ebpf.MapBatchCursor
+		n, err := m.BatchLookup(&cursor, keys, cookies, nil)
+		if err == nil || errors.Is(err, ebpf.ErrKeyNotExist) {
+			for i := 0; i < n; i++ {
+				out[keys[i]] = cookies[i]
+			}
+			return out, nil
+		}
+		if !batchFailed(err) {
+			return nil, fmt.Errorf("batch lookup %s: %w", SlotCookiesMap, err)
+		}
+	}
+
+	var slot uint32
+	var cookie uint64
+	iter := m.Iterate()
+	for iter.Next(&slot, &cookie) {
+		out[slot] = cookie
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("iterate %s: %w", SlotCookiesMap, err)
+	}
+	return out, nil
+}
+
+// lookupAcceptqAll reads every entry of acceptq_map in one batch, folding
+// per-CPU values as lookupAcceptq does. It returns nil, without an error,
+// when batching is unavailable; callers then look cookies up one by one.
+func lookupAcceptqAll(m *ebpf.Map, reduce string) (map[uint64]AcceptqEntry, error) {
+	if noBatch.Load() {
+		return nil, nil
+	}
+	count := int(m.MaxEntries())
+	ncpu := 1
+	if IsPerCPU(m) {
+		possible, err := ebpf.PossibleCPU()
+		if err != nil {
+			return nil, err
+		}
+		ncpu = possible
+	}
+	keys := make([]uint64, count)
+	values := make([]AcceptqEntry, count*ncpu)
+	var cursor ebpf.MapBatchCursor
+	n, err := m.BatchLookup(&cursor, keys, values, nil)
+	if err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+		if batchFailed(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("batch lookup %s: %w", AcceptqMap, err)
+	}
+
+	out := make(map[uint64]AcceptqEntry, n)
+	for i := 0; i < n; i++ {
+		if ncpu == 1 {
+			out[keys[i]] = values[i]
+			continue
+		}
+		out[keys[i]] = reduceAcceptq(values[i*ncpu:(i+1)*ncpu], reduce)
+	}
+	return out, nil
+}
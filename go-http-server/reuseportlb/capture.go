@@ -0,0 +1,148 @@
+package reuseportlb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// DefaultCaptureSnaplen is how much of each packet a Capture keeps: enough
+// for the IP and TCP headers with options, and the start of the payload.
+const DefaultCaptureSnaplen = 128
+
+// CaptureStats are a Capture's counts once it is closed.
+type CaptureStats struct {
+	// Packets were written to the pcap.
+	Packets uint64 `json:"packets"`
+	// Drops were lost because the capture could not keep up.
+	Drops uint64 `json:"drops"`
+}
+
+// Capture records the TCP traffic of one port on an interface to a pcap
+// file, without tcpdump: an AF_PACKET socket with a classic BPF filter for
+// the port, so the kernel only hands over the packets that matter. Packets
+// are written from the IP header on (LINKTYPE_RAW) with nanosecond kernel
+// timestamps, and open in Wireshark or tcpdump -r as they are.
+type Capture struct {
+	src packetSource
+	w   *pcapWriter
+	// stopping is closed by Close; run checks it between packets.
+	stopping chan struct{}
+	done     chan struct{}
+	once     sync.Once
+	err      error
+	stats    CaptureStats
+}
+
+// packetSource is the platform's capture socket.
+type packetSource interface {
+	// read returns the next packet, truncated to the snaplen, with its
+	// length on the wire. It returns a nil packet when nothing arrived in a
+	// while, so the reader can check whether it should stop.
+	read(buf []byte) (pkt []byte, wireLen int, ts time.Time, err error)
+	drops() uint64
+	close() error
+}
+
+// StartCapture captures the TCP segments to or from port on iface ("lo" for
+// experiments on one host), keeping snaplen bytes of each, into w until
+// Close. It needs CAP_NET_RAW.
+func StartCapture(iface string, port uint16, snaplen int, w io.Writer) (*Capture, error) {
+	if snaplen <= 0 {
+		snaplen = DefaultCaptureSnaplen
+	}
+	src, err := openPacketSource(iface, port)
+	if err != nil {
+		return nil, err
+	}
+	c := &Capture{src: src, w: newPcapWriter(w, snaplen), stopping: make(chan struct{}), done: make(chan struct{})}
+	if err := c.w.header(); err != nil {
+		src.close()
+		return nil, err
+	}
+	go c.run(snaplen)
+	return c, nil
+}
+
+func (c *Capture) run(snaplen int) {
+	defer close(c.done)
+	buf := make([]byte, snaplen)
+	for {
+		select {
+		case <-c.stopping:
+			c.err = c.w.flush()
+			return
+		default:
+		}
+		pkt, wireLen, ts, err := c.src.read(buf)
+		if err != nil {
+			c.err = errors.Join(err, c.w.flush())
+			return
+		}
+		if pkt == nil {
+			continue
+		}
+		if err := c.w.record(ts, pkt, wireLen); err != nil {
+			c.err = err
+			return
+		}
+		c.stats.Packets++
+	}
+}
+
+// Close stops the capture, flushes the pcap and returns what was captured.
+// It does not close the writer.
+func (c *Capture) Close() (CaptureStats, error) {
+	c.once.Do(func() {
+		close(c.stopping)
+		<-c.done
+		c.stats.Drops = c.src.drops()
+		c.err = errors.Join(c.err, c.src.close())
+	})
+	return c.stats, c.err
+}
+
+// pcapWriter writes the classic pcap format with nanosecond timestamps.
+type pcapWriter struct {
+	w       *bufio.Writer
+	snaplen int
+}
+
+const (
+	pcapMagicNanos = 0xa1b23c4d
+	// linktypeRaw is raw IPv4 or IPv6, told apart by the version nibble.
+	linktypeRaw = 101
+)
+
+func newPcapWriter(w io.Writer, snaplen int) *pcapWriter {
+	return &pcapWriter{w: bufio.NewWriter(w), snaplen: snaplen}
+}
+
+func (p *pcapWriter) header() error {
+	var h [24]byte
+	binary.LittleEndian.PutUint32(h[0:], pcapMagicNanos)
+	binary.LittleEndian.PutUint16(h[4:], 2)
+	binary.LittleEndian.PutUint16(h[6:], 4)
+	binary.LittleEndian.PutUint32(h[16:], uint32(p.snaplen))
+	binary.LittleEndian.PutUint32(h[20:], linktypeRaw)
+	_, err := p.w.Write(h[:])
+	return err
+}
+
+func (p *pcapWriter) record(ts time.Time, pkt []byte, wireLen int) error {
+	var h [16]byte
+	binary.LittleEndian.PutUint32(h[0:], uint32(ts.Unix()))
+	binary.LittleEndian.PutUint32(h[4:], uint32(ts.Nanosecond()))
+	binary.LittleEndian.PutUint32(h[8:], uint32(len(pkt)))
+	binary.LittleEndian.PutUint32(h[12:], uint32(wireLen))
+	if _, err := p.w.Write(h[:]); err != nil {
+		return err
+	}
+	_, err := p.w.Write(pkt)
+	return err
+}
+
+func (p *pcapWriter) flush() error { return p.w.Flush() }
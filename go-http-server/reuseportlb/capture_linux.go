@@ -0,0 +1,134 @@
+package reuseportlb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+// afPacket is an AF_PACKET socket in cooked (SOCK_DGRAM) mode: packets
+// arrive without their link-layer header, starting at the IP header.
+type afPacket struct {
+	fd       int
+	loopback bool
+	oob      []byte
+	dropped  uint64
+}
+
+func openPacketSource(iface string, port uint16) (packetSource, error) {
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := captureFilter(port)
+	if err != nil {
+		return nil, fmt.Errorf("assemble capture filter: %w", err)
+	}
+	// Protocol 0 receives nothing until bind, so no packet gets past
+	// before the filter is in place.
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("open packet socket: %w", err)
+	}
+	s := &afPacket{fd: fd, loopback: ifi.Flags&net.FlagLoopback != 0, oob: make([]byte, unix.CmsgSpace(16))}
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	tv := unix.NsecToTimeval((200 * time.Millisecond).Nanoseconds())
+	for _, err := range []error{
+		unix.SetsockoptSockFprog(fd, unix.SOL_SOCKET, unix.SO_ATTACH_FILTER, &prog),
+		unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_TIMESTAMPNS, 1),
+		unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv),
+		unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: htons(unix.ETH_P_ALL), Ifindex: ifi.Index}),
+	} {
+		if err != nil {
+			unix.Close(fd)
+			return nil, fmt.Errorf("set up packet socket on %s: %w", iface, err)
+		}
+	}
+	return s, nil
+}
+
+// captureFilter is "tcp port <port>" for cooked IPv4 and IPv6 packets. It
+// accepts whole packets so that MSG_TRUNC reports their length on the wire;
+// the read buffer does the truncating.
+func captureFilter(port uint16) ([]unix.SockFilter, error) {
+	const accept, drop = 17, 18
+	p := uint32(port)
+	raw, err := bpf.Assemble([]bpf.Instruction{
+		/* 0 */ bpf.LoadAbsolute{Off: 0, Size: 1},
+		/* 1 */ bpf.ALUOpConstant{Op: bpf.ALUOpAnd, Val: 0xf0},
+		/* 2 */ bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0x60, SkipFalse: 7 - 2 - 1},
+		// IPv6: TCP right after the fixed header.
+		/* 3 */ bpf.LoadAbsolute{Off: 6, Size: 1},
+		/* 4 */ bpf.JumpIf{Cond: bpf.JumpEqual, Val: unix.IPPROTO_TCP, SkipFalse: drop - 4 - 1},
+		/* 5 */ bpf.LoadConstant{Dst: bpf.RegX, Val: 40},
+		/* 6 */ bpf.Jump{Skip: 13 - 6 - 1},
+		// IPv4: TCP, and the first fragment, which has the ports.
+		/* 7 */ bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0x40, SkipFalse: drop - 7 - 1},
+		/* 8 */ bpf.LoadAbsolute{Off: 9, Size: 1},
+		/* 9 */ bpf.JumpIf{Cond: bpf.JumpEqual, Val: unix.IPPROTO_TCP, SkipFalse: drop - 9 - 1},
+		/* 10 */ bpf.LoadAbsolute{Off: 6, Size: 2},
+		/* 11 */ bpf.JumpIf{Cond: bpf.JumpBitsSet, Val: 0x1fff, SkipTrue: drop - 11 - 1},
+		/* 12 */ bpf.LoadMemShift{Off: 0},
+		// X is the TCP header offset: source port, then destination port.
+		/* 13 */ bpf.LoadIndirect{Off: 0, Size: 2},
+		/* 14 */ bpf.JumpIf{Cond: bpf.JumpEqual, Val: p, SkipTrue: accept - 14 - 1},
+		/* 15 */ bpf.LoadIndirect{Off: 2, Size: 2},
+		/* 16 */ bpf.JumpIf{Cond: bpf.JumpEqual, Val: p, SkipTrue: accept - 16 - 1, SkipFalse: drop - 16 - 1},
+		/* 17 */ bpf.RetConstant{Val: 1 << 18},
+		/* 18 */ bpf.RetConstant{Val: 0},
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := make([]unix.SockFilter, len(raw))
+	for i, ins := range raw {
+		out[i] = unix.SockFilter{Code: ins.Op, Jt: ins.Jt, Jf: ins.Jf, K: ins.K}
+	}
+	return out, nil
+}
+
+func (s *afPacket) read(buf []byte) ([]byte, int, time.Time, error) {
+	n, oobn, _, from, err := unix.Recvmsg(s.fd, buf, s.oob, unix.MSG_TRUNC)
+	switch {
+	case errors.Is(err, unix.EAGAIN), errors.Is(err, unix.EINTR):
+		return nil, 0, time.Time{}, nil
+	case err != nil:
+		return nil, 0, time.Time{}, err
+	}
+	// On loopback every packet passes twice, going out and coming in;
+	// keep one copy, as tcpdump does.
+	if ll, ok := from.(*unix.SockaddrLinklayer); ok && s.loopback && ll.Pkttype == unix.PACKET_OUTGOING {
+		return nil, 0, time.Time{}, nil
+	}
+	ts := time.Now()
+	if msgs, err := unix.ParseSocketControlMessage(s.oob[:oobn]); err == nil {
+		for _, m := range msgs {
+			if m.Header.Level == unix.SOL_SOCKET && m.Header.Type == unix.SO_TIMESTAMPNS && len(m.Data) >= 16 {
+				ts = time.Unix(int64(binary.NativeEndian.Uint64(m.Data)), int64(binary.NativeEndian.Uint64(m.Data[8:])))
+			}
+		}
+	}
+	return buf[:min(n, len(buf))], n, ts, nil
+}
+
+func (s *afPacket) drops() uint64 {
+	// Reading the statistics resets them.
+	if st, err := unix.GetsockoptTpacketStats(s.fd, unix.SOL_PACKET, unix.PACKET_STATISTICS); err == nil {
+		s.dropped += uint64(st.Drops)
+	}
+	return s.dropped
+}
+
+func (s *afPacket) close() error { return unix.Close(s.fd) }
+
+// htons converts a 16-bit value to network byte order.
+func htons(v uint16) uint16 {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	return binary.NativeEndian.Uint16(b[:])
+}
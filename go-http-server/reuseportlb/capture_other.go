@@ -0,0 +1,7 @@
+//go:build !linux
+
+package reuseportlb
+
+func openPacketSource(iface string, port uint16) (packetSource, error) {
+	return nil, errNotLinux
+}
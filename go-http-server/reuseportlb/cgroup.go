@@ -0,0 +1,199 @@
+package reuseportlb
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cgroupParent is the directory under cgroupRoot holding the instance
+// cgroups, one subdirectory per group.
+const cgroupParent = "reuseportlb"
+
+// InstanceCgroup is the cgroup JoinCgroup puts the server of slot in.
+func (g Group) InstanceCgroup(slot uint32) string {
+	name := "default"
+	if g != DefaultGroup {
+		name = string(g)
+	}
+	return filepath.Join(cgroupRoot, cgroupParent, name, fmt.Sprintf("slot-%d", slot))
+}
+
+// JoinCgroup moves the calling process into the instance cgroup of slot,
+// creating it and enabling the cpu and memory controllers on the way down
+// from cgroupRoot, and returns its path. With every instance in a cgroup of
+// its own, a collector with CgroupUtil set measures the instances alone
+// rather than the cores they share with everything else on the host. The
+// cgroup outlives the process and is reused by the slot's next server.
+func (g Group) JoinCgroup(slot uint32) (string, error) {
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		return "", fmt.Errorf("no cgroup v2 hierarchy at %s: %w", cgroupRoot, err)
+	}
+	path := g.InstanceCgroup(slot)
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return "", fmt.Errorf("create cgroup: %w", err)
+	}
+	// Controllers only reach a cgroup if every ancestor delegates them.
+	// Failing to enable one (it may be delegated already, or the root may
+	// refuse) only costs the stats that need it.
+	rel, _ := filepath.Rel(cgroupRoot, path)
+	dir := cgroupRoot
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		for _, c := range []string{"+cpu", "+memory"} {
+			writeCgroupFile(filepath.Join(dir, "cgroup.subtree_control"), c)
+		}
+		dir = filepath.Join(dir, part)
+	}
+	if err := writeCgroupFile(filepath.Join(path, "cgroup.procs"), strconv.Itoa(os.Getpid())); err != nil {
+		return "", fmt.Errorf("join cgroup %s: %w", path, err)
+	}
+	return path, nil
+}
+
+// writeCgroupFile writes one value to an existing cgroup interface file.
+func writeCgroupFile(path, value string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(value)
+	return errors.Join(err, f.Close())
+}
+
+// ProcessCgroup returns the cgroup v2 directory pid belongs to.
+func ProcessCgroup(pid int) (string, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if rel, ok := strings.CutPrefix(sc.Text(), "0::"); ok {
+			return filepath.Join(cgroupRoot, rel), nil
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("process %d is in no cgroup v2 hierarchy", pid)
+}
+
+// CgroupStats is what the collector reads from an instance cgroup.
+type CgroupStats struct {
+	// CPUUsage is the CPU time the cgroup used, from cpu.stat.
+	CPUUsage time.Duration
+	// Throttled is the time the cpu controller held it back, if enabled.
+	Throttled time.Duration
+	// Memory is memory.current, 0 without the memory controller.
+	Memory uint64
+}
+
+// ReadCgroupStats reads the cgroup at path. cpu.stat is always there;
+// memory.current only with the memory controller.
+func ReadCgroupStats(path string) (CgroupStats, error) {
+	var s CgroupStats
+	data, err := os.ReadFile(filepath.Join(path, "cpu.stat"))
+	if err != nil {
+		return s, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		key, val, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseUint(val, 10, 64)
+		if err != nil {
+			continue
+		}
+		switch key {
+		case "usage_usec":
+			s.CPUUsage = time.Duration(n) * time.Microsecond
+		case "throttled_usec":
+			s.Throttled = time.Duration(n) * time.Microsecond
+		}
+	}
+	s.Memory, _ = strconv.ParseUint(readTrim(filepath.Join(path, "memory.current")), 10, 64)
+	return s, nil
+}
+
+// cgroupSampler turns successive cgroup readings of the slot owners into
+// smoothed utilization of the CPUs each owner may run on.
+type cgroupSampler struct {
+	cfg  CollectorConfig
+	prev map[uint32]cgroupSample
+	avg  map[uint32]*EWMA
+	// last is the latest reading per slot, for the logs.
+	last map[uint32]CgroupStats
+	path map[uint32]string
+}
+
+type cgroupSample struct {
+	pid   uint32
+	usage time.Duration
+	at    time.Time
+}
+
+func newCgroupSampler(cfg CollectorConfig) *cgroupSampler {
+	return &cgroupSampler{
+		cfg:  cfg,
+		prev: make(map[uint32]cgroupSample),
+		avg:  make(map[uint32]*EWMA),
+		last: make(map[uint32]CgroupStats),
+		path: make(map[uint32]string),
+	}
+}
+
+// util samples the owner of slot's cgroup and returns its smoothed
+// utilization in percent of its CPUs. ok is false until two samples of the
+// same owner are in, and for owners sharing the root cgroup, which would
+// measure the whole host.
+func (s *cgroupSampler) util(slot uint32, owner SlotOwner) (util float64, ok bool) {
+	prev, seen := s.prev[slot]
+	if !seen || prev.pid != owner.Pid {
+		delete(s.avg, slot)
+		path, err := ProcessCgroup(int(owner.Pid))
+		if err != nil || path == cgroupRoot {
+			delete(s.prev, slot)
+			return 0, false
+		}
+		s.path[slot] = path
+	}
+	stats, err := ReadCgroupStats(s.path[slot])
+	if err != nil {
+		delete(s.prev, slot)
+		return 0, false
+	}
+	now := time.Now()
+	s.prev[slot] = cgroupSample{pid: owner.Pid, usage: stats.CPUUsage, at: now}
+	s.last[slot] = stats
+	if !seen || prev.pid != owner.Pid || owner.Ncpus == 0 {
+		return 0, false
+	}
+	elapsed := now.Sub(prev.at) * time.Duration(owner.Ncpus)
+	if elapsed <= 0 {
+		return 0, false
+	}
+	inst := min(float64(stats.CPUUsage-prev.usage)/float64(elapsed)*100, 100)
+
+	avg, found := s.avg[slot]
+	if !found {
+		avg = &EWMA{Alpha: s.cfg.Alpha, Adaptive: s.cfg.Adaptive, MinAlpha: s.cfg.AlphaMin, MaxAlpha: s.cfg.AlphaMax}
+		s.avg[slot] = avg
+	}
+	return avg.Update(inst), true
+}
+
+// stats returns the latest reading of slot's cgroup. A nil sampler has none.
+func (s *cgroupSampler) stats(slot uint32) (CgroupStats, bool) {
+	if s == nil {
+		return CgroupStats{}, false
+	}
+	st, ok := s.last[slot]
+	return st, ok && s.prev[slot].pid != 0
+}
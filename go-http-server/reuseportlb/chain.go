@@ -0,0 +1,220 @@
+package reuseportlb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cilium/ebpf"
+)
+
+// Stages of the chain policy (see eBPF/chain.c). Filters narrow the set of
+// candidate slots; selectors pick one of the remaining sockets and so may
+// only end a chain.
+const (
+	StageExcludeDraining   = "exclude-draining"
+	StageExcludeOverloaded = "exclude-overloaded"
+	StageSlowSYN           = "slow-syn"
+	StageRoundRobin        = "round-robin"
+	StageFirst             = "first"
+)
+
+// chainStages maps each stage to whether it is a selector.
+var chainStages = map[string]bool{
+	StageExcludeDraining:   false,
+	StageExcludeOverloaded: false,
+	StageSlowSYN:           false,
+	StageRoundRobin:        true,
+	StageFirst:             true,
+}
+
+// maxChainStages is the size of chain_progs (CHAIN_MAX_STAGES).
+const maxChainStages = 8
+
+// DefaultChain is installed whenever the chain policy is loaded.
+var DefaultChain = []string{StageExcludeDraining, StageExcludeOverloaded, StageRoundRobin}
+
+// DefaultOverloadPct is the accept queue fill, in percent, at which
+// exclude-overloaded skips a slot.
+const DefaultOverloadPct = 80
+
+// ParseChain parses a comma-separated list of stages, as taken by the
+// -chain flags.
+func ParseChain(s string) ([]string, error) {
+	var stages []string
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			stages = append(stages, f)
+		}
+	}
+	if err := validateChain(stages); err != nil {
+		return nil, err
+	}
+	return stages, nil
+}
+
+func validateChain(stages []string) error {
+	if len(stages) == 0 {
+		return errors.New("empty chain")
+	}
+	if len(stages) > maxChainStages {
+		return fmt.Errorf("chain has %d stages, at most %d are supported", len(stages), maxChainStages)
+	}
+	for i, s := range stages {
+		selector, ok := chainStages[s]
+		if !ok {
+			return fmt.Errorf("unknown chain stage %q", s)
+		}
+		if selector && i != len(stages)-1 {
+			return fmt.Errorf("selector %q must be the last stage", s)
+		}
+	}
+	return nil
+}
+
+// stagePath is where the group's program for stage is pinned, so that any
+// process can rebuild the chain.
+func (g Group) stagePath(stage string) string {
+	return filepath.Join(g.PinDir(), "chain_"+strings.ReplaceAll(stage, "-", "_"))
+}
+
+// stagePaths returns where every chain stage would be pinned.
+func (g Group) stagePaths() []string {
+	var paths []string
+	for stage := range chainStages {
+		paths = append(paths, g.stagePath(stage))
+	}
+	return paths
+}
+
+// installChain pins freshly loaded stage programs, replacing those of an
+// earlier load, and configures the default chain.
+func (g Group) installChain(stages map[string]*ebpf.Program) error {
+	for name, prog := range stages {
+		path := g.stagePath(name)
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove stale chain stage pin: %w", err)
+		}
+		if err := prog.Pin(path); err != nil {
+			return fmt.Errorf("pin chain stage %s: %w", name, err)
+		}
+	}
+	if err := g.SetOverloadThreshold(DefaultOverloadPct); err != nil {
+		return err
+	}
+	return g.SetChain(DefaultChain)
+}
+
+// SetChain makes the group's chain policy run stages, in order, for every
+// new connection. The chain policy must have been loaded for the group.
+func (g Group) SetChain(stages []string) error {
+	if err := validateChain(stages); err != nil {
+		return err
+	}
+	m, err := g.openAudited(ChainProgsMap)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	for i, name := range stages {
+		prog, err := ebpf.LoadPinnedProgram(g.stagePath(name), nil)
+		if err != nil {
+			return fmt.Errorf("load chain stage %s: %w", name, pinMissing(err, g.stagePath(name)))
+		}
+		err = m.Update(uint32(i), prog, ebpf.UpdateAny)
+		prog.Close()
+		if err != nil {
+			return fmt.Errorf("install chain stage %d (%s): %w", i, name, err)
+		}
+	}
+	for i := len(stages); i < maxChainStages; i++ {
+		if err := m.Delete(uint32(i)); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			return fmt.Errorf("clear chain stage %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// Chain returns the stages currently installed for the group.
+func (g Group) Chain() ([]string, error) {
+	m, err := g.openAudited(ChainProgsMap)
+	if err != nil {
+		return nil, err
+	}
+	defer m.Close()
+
+	// chain_progs reads back program IDs; match them to the pinned stages.
+	names := make(map[ebpf.ProgramID]string)
+	for name := range chainStages {
+		prog, err := ebpf.LoadPinnedProgram(g.stagePath(name), nil)
+		if err != nil {
+			continue
+		}
+		if info, err := prog.Info(); err == nil {
+			if id, ok := info.ID(); ok {
+				names[id] = name
+			}
+		}
+		prog.Close()
+	}
+
+	var stages []string
+	for i := uint32(0); i < maxChainStages; i++ {
+		var id uint32
+		if err := m.Lookup(i, &id); err != nil {
+			if errors.Is(err, ebpf.ErrKeyNotExist) {
+				break
+			}
+			return nil, fmt.Errorf("read chain stage %d: %w", i, err)
+		}
+		name, ok := names[ebpf.ProgramID(id)]
+		if !ok {
+			name = fmt.Sprintf("prog#%d", id)
+		}
+		stages = append(stages, name)
+	}
+	return stages, nil
+}
+
+// SetOverloadThreshold sets the accept queue fill, in percent, at which
+// exclude-overloaded skips a slot and slow-syn considers the group
+// overloaded; 0 turns both filters into no-ops.
+func (g Group) SetOverloadThreshold(pct uint32) error {
+	if pct > 100 {
+		return fmt.Errorf("overload threshold %d%% out of range", pct)
+	}
+	return g.updateChainCfg(func(c *chainChainCfg) { c.OverloadPct = pct })
+}
+
+// SetSlowSYN sets the percentage of SYNs slow-syn drops while every slot is
+// past the overload threshold. Clients retransmit them after their RTO, so
+// an overloaded group answers new connections late instead of queueing them
+// where they cannot be served; 0 turns the filter into a no-op.
+func (g Group) SetSlowSYN(pct uint32) error {
+	if pct > 100 {
+		return fmt.Errorf("slow SYN share %d%% out of range", pct)
+	}
+	return g.updateChainCfg(func(c *chainChainCfg) { c.SlowSynPct = pct })
+}
+
+// updateChainCfg changes one setting of chain_cfg, keeping the others.
+func (g Group) updateChainCfg(set func(*chainChainCfg)) error {
+	m, err := g.openAudited(ChainCfgMap)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	k := uint32(0)
+	var cfg chainChainCfg
+	if err := m.Lookup(&k, &cfg); err != nil {
+		return fmt.Errorf("read %s: %w", ChainCfgMap, err)
+	}
+	set(&cfg)
+	if err := m.Update(&k, &cfg, ebpf.UpdateAny); err != nil {
+		return fmt.Errorf("update %s: %w", ChainCfgMap, err)
+	}
+	return nil
+}
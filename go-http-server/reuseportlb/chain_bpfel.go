@@ -0,0 +1,240 @@
+// Code generated by bpf2go; DO NOT EDIT.
+//go:build 386 || amd64 || arm || arm64 || loong64 || mips64le || mipsle || ppc64le || riscv64
+
+package reuseportlb
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+
+	"github.com/cilium/ebpf"
+)
+
+type chainAcceptq struct {
+	Curr uint32
+	Max  uint32
+	Cpu  uint32
+}
+
+type chainChainCfg struct {
+	OverloadPct uint32
+	SlowSynPct  uint32
+}
+
+type chainChainScratch struct {
+	Candidates uint64
+	Stage      uint32
+	Pad        uint32
+}
+
+type chainRatelimitCfg struct {
+	Enabled     uint32
+	Action      uint32
+	PenaltySlot uint32
+	MaxConns    uint32
+	WindowNs    uint64
+}
+
+type chainRrState struct{ Counter uint64 }
+
+type chainShadowState struct {
+	Phase     uint32
+	Candidate uint32
+}
+
+type chainSlotBucket struct {
+	Rate      uint64
+	Burst     uint64
+	Action    uint32
+	Pad       uint32
+	Tokens    uint64
+	LastNs    uint64
+	Throttled uint64
+}
+
+type chainSlotOverride struct {
+	Slot uint32
+	Hits uint32
+}
+
+type chainSlotWarmup struct {
+	StartNs  uint64
+	WindowNs uint64
+}
+
+type chainSrcRate struct {
+	WindowStart uint64
+	Count       uint32
+	Limited     uint32
+}
+
+// loadChain returns the embedded CollectionSpec for chain.
+func loadChain() (*ebpf.CollectionSpec, error) {
+	reader := bytes.NewReader(_ChainBytes)
+	spec, err := ebpf.LoadCollectionSpecFromReader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("can't load chain: %w", err)
+	}
+
+	return spec, err
+}
+
+// loadChainObjects loads chain and converts it into a struct.
+//
+// The following types are suitable as obj argument:
+//
+//	*chainObjects
+//	*chainPrograms
+//	*chainMaps
+//
+// See ebpf.CollectionSpec.LoadAndAssign documentation for details.
+func loadChainObjects(obj interface{}, opts *ebpf.CollectionOptions) error {
+	spec, err := loadChain()
+	if err != nil {
+		return err
+	}
+
+	return spec.LoadAndAssign(obj, opts)
+}
+
+// chainSpecs contains maps and programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type chainSpecs struct {
+	chainProgramSpecs
+	chainMapSpecs
+}
+
+// chainSpecs contains programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type chainProgramSpecs struct {
+	ChainEntry             *ebpf.ProgramSpec `ebpf:"chain_entry"`
+	ChainExcludeDraining   *ebpf.ProgramSpec `ebpf:"chain_exclude_draining"`
+	ChainExcludeOverloaded *ebpf.ProgramSpec `ebpf:"chain_exclude_overloaded"`
+	ChainFirst             *ebpf.ProgramSpec `ebpf:"chain_first"`
+	ChainRoundRobin        *ebpf.ProgramSpec `ebpf:"chain_round_robin"`
+	ChainSlowSyn           *ebpf.ProgramSpec `ebpf:"chain_slow_syn"`
+}
+
+// chainMapSpecs contains maps before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type chainMapSpecs struct {
+	AcceptqMap          *ebpf.MapSpec `ebpf:"acceptq_map"`
+	AcceptqSlotCookies  *ebpf.MapSpec `ebpf:"acceptq_slot_cookies"`
+	ChainCfg            *ebpf.MapSpec `ebpf:"chain_cfg"`
+	ChainProgs          *ebpf.MapSpec `ebpf:"chain_progs"`
+	ChainScratch        *ebpf.MapSpec `ebpf:"chain_scratch"`
+	RatelimitCfg        *ebpf.MapSpec `ebpf:"ratelimit_cfg"`
+	Rr                  *ebpf.MapSpec `ebpf:"rr"`
+	ShadowEvents        *ebpf.MapSpec `ebpf:"shadow_events"`
+	ShadowProgs         *ebpf.MapSpec `ebpf:"shadow_progs"`
+	ShadowScratch       *ebpf.MapSpec `ebpf:"shadow_scratch"`
+	SlotBucket          *ebpf.MapSpec `ebpf:"slot_bucket"`
+	SlotDropping        *ebpf.MapSpec `ebpf:"slot_dropping"`
+	SlotOverride        *ebpf.MapSpec `ebpf:"slot_override"`
+	SlotSelected        *ebpf.MapSpec `ebpf:"slot_selected"`
+	SlotWarmup          *ebpf.MapSpec `ebpf:"slot_warmup"`
+	SrcRate             *ebpf.MapSpec `ebpf:"src_rate"`
+	TcpBalancingTargets *ebpf.MapSpec `ebpf:"tcp_balancing_targets"`
+}
+
+// chainObjects contains all objects after they have been loaded into the kernel.
+//
+// It can be passed to loadChainObjects or ebpf.CollectionSpec.LoadAndAssign.
+type chainObjects struct {
+	chainPrograms
+	chainMaps
+}
+
+func (o *chainObjects) Close() error {
+	return _ChainClose(
+		&o.chainPrograms,
+		&o.chainMaps,
+	)
+}
+
+// chainMaps contains all maps after they have been loaded into the kernel.
+//
+// It can be passed to loadChainObjects or ebpf.CollectionSpec.LoadAndAssign.
+type chainMaps struct {
+	AcceptqMap          *ebpf.Map `ebpf:"acceptq_map"`
+	AcceptqSlotCookies  *ebpf.Map `ebpf:"acceptq_slot_cookies"`
+	ChainCfg            *ebpf.Map `ebpf:"chain_cfg"`
+	ChainProgs          *ebpf.Map `ebpf:"chain_progs"`
+	ChainScratch        *ebpf.Map `ebpf:"chain_scratch"`
+	RatelimitCfg        *ebpf.Map `ebpf:"ratelimit_cfg"`
+	Rr                  *ebpf.Map `ebpf:"rr"`
+	ShadowEvents        *ebpf.Map `ebpf:"shadow_events"`
+	ShadowProgs         *ebpf.Map `ebpf:"shadow_progs"`
+	ShadowScratch       *ebpf.Map `ebpf:"shadow_scratch"`
+	SlotBucket          *ebpf.Map `ebpf:"slot_bucket"`
+	SlotDropping        *ebpf.Map `ebpf:"slot_dropping"`
+	SlotOverride        *ebpf.Map `ebpf:"slot_override"`
+	SlotSelected        *ebpf.Map `ebpf:"slot_selected"`
+	SlotWarmup          *ebpf.Map `ebpf:"slot_warmup"`
+	SrcRate             *ebpf.Map `ebpf:"src_rate"`
+	TcpBalancingTargets *ebpf.Map `ebpf:"tcp_balancing_targets"`
+}
+
+func (m *chainMaps) Close() error {
+	return _ChainClose(
+		m.AcceptqMap,
+		m.AcceptqSlotCookies,
+		m.ChainCfg,
+		m.ChainProgs,
+		m.ChainScratch,
+		m.RatelimitCfg,
+		m.Rr,
+		m.ShadowEvents,
+		m.ShadowProgs,
+		m.ShadowScratch,
+		m.SlotBucket,
+		m.SlotDropping,
+		m.SlotOverride,
+		m.SlotSelected,
+		m.SlotWarmup,
+		m.SrcRate,
+		m.TcpBalancingTargets,
+	)
+}
+
+// chainPrograms contains all programs after they have been loaded into the kernel.
+//
+// It can be passed to loadChainObjects or ebpf.CollectionSpec.LoadAndAssign.
+type chainPrograms struct {
+	ChainEntry             *ebpf.Program `ebpf:"chain_entry"`
+	ChainExcludeDraining   *ebpf.Program `ebpf:"chain_exclude_draining"`
+	ChainExcludeOverloaded *ebpf.Program `ebpf:"chain_exclude_overloaded"`
+	ChainFirst             *ebpf.Program `ebpf:"chain_first"`
+	ChainRoundRobin        *ebpf.Program `ebpf:"chain_round_robin"`
+	ChainSlowSyn           *ebpf.Program `ebpf:"chain_slow_syn"`
+}
+
+func (p *chainPrograms) Close() error {
+	return _ChainClose(
+		p.ChainEntry,
+		p.ChainExcludeDraining,
+		p.ChainExcludeOverloaded,
+		p.ChainFirst,
+		p.ChainRoundRobin,
+		p.ChainSlowSyn,
+	)
+}
+
+func _ChainClose(closers ...io.Closer) error {
+	for _, closer := range closers {
+		if err := closer.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Do not access this directly.
+//
+//go:embed chain_bpfel.o
+var _ChainBytes []byte
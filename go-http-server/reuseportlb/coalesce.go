@@ -0,0 +1,207 @@
+package reuseportlb
+
+import (
+	"expvar"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cilium/ebpf"
+)
+
+// DefaultUpdateRefresh is how often the collector rewrites map entries whose
+// values have not changed.
+const DefaultUpdateRefresh = time.Second
+
+// UpdateStats counts a collector's writes to one map.
+type UpdateStats struct {
+	// Written is the entries written; Skipped those left alone because
+	// their value had not moved past the deadband; Deferred those held back
+	// a sample by the update rate limit.
+	Written  uint64 `json:"written"`
+	Skipped  uint64 `json:"skipped"`
+	Deferred uint64 `json:"deferred"`
+	// Syscalls is the update syscalls made: one per batch, or one per entry
+	// where the kernel cannot batch.
+	Syscalls uint64 `json:"syscalls"`
+	// Rate is the entries written per second over the last log period.
+	Rate float64 `json:"rate"`
+}
+
+// collectorUpdates holds every running collector's UpdateStats by group and
+// map name, published as the collector_updates expvar.
+var collectorUpdates = struct {
+	sync.Mutex
+	once   sync.Once
+	groups map[Group]map[string]*UpdateStats
+}{groups: make(map[Group]map[string]*UpdateStats)}
+
+// CollectorUpdates returns the update counters of the collectors running in
+// this process, by group and map name.
+func CollectorUpdates() map[string]map[string]UpdateStats {
+	collectorUpdates.Lock()
+	defer collectorUpdates.Unlock()
+	out := make(map[string]map[string]UpdateStats, len(collectorUpdates.groups))
+	for g, maps := range collectorUpdates.groups {
+		m := make(map[string]UpdateStats, len(maps))
+		for name, st := range maps {
+			m[name] = *st
+		}
+		out[g.String()] = m
+	}
+	return out
+}
+
+// updateLimiter is a token bucket shared by a collector's mapWriters,
+// capping the entries they write per second together.
+type updateLimiter struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// take returns how many of n entries may be written at now.
+func (l *updateLimiter) take(n int, now time.Time) int {
+	if l == nil || l.rate <= 0 {
+		return n
+	}
+	if !l.last.IsZero() {
+		// A second's worth of updates at most, so a quiet spell does not
+		// save up for a burst.
+		l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.rate, l.rate)
+	} else {
+		l.tokens = l.rate
+	}
+	l.last = now
+	allowed := min(n, int(l.tokens))
+	l.tokens -= float64(allowed)
+	return allowed
+}
+
+// mapWriter coalesces a collector's writes to one uint32 map: of each
+// sample it writes only the entries that moved by more than the deadband
+// since they were last written, in one batch, and every refresh interval it
+// rewrites them all, so that an entry reset behind its back does not stay
+// wrong for long. Entries a rate limit holds back are written with a later
+// sample, biggest change first.
+type mapWriter struct {
+	m        *ebpf.Map
+	name     string
+	deadband uint32
+	refresh  time.Duration
+	limit    *updateLimiter
+
+	last      map[uint32]uint32
+	refreshed time.Time
+	stats     *UpdateStats
+	period    uint64 // Written at the start of the log period
+	periodAt  time.Time
+}
+
+func (cfg CollectorConfig) newMapWriter(m *ebpf.Map, name string, limit *updateLimiter) *mapWriter {
+	st := &UpdateStats{}
+	collectorUpdates.once.Do(func() {
+		expvar.Publish("collector_updates", expvar.Func(func() any { return CollectorUpdates() }))
+	})
+	collectorUpdates.Lock()
+	if collectorUpdates.groups[cfg.Group] == nil {
+		collectorUpdates.groups[cfg.Group] = make(map[string]*UpdateStats)
+	}
+	collectorUpdates.groups[cfg.Group][name] = st
+	collectorUpdates.Unlock()
+	return &mapWriter{
+		m:        m,
+		name:     name,
+		deadband: cfg.UpdateDeadband,
+		refresh:  cfg.UpdateRefresh,
+		limit:    limit,
+		last:     make(map[uint32]uint32),
+		stats:    st,
+		periodAt: time.Now(),
+	}
+}
+
+// write sets keys[i] to values[i] where that is a change worth writing.
+func (w *mapWriter) write(keys, values []uint32, now time.Time) error {
+	refresh := w.refresh <= 0 || now.Sub(w.refreshed) >= w.refresh
+	type change struct {
+		key, value, delta uint32
+	}
+	var changes []change
+	var skipped uint64
+	for i, k := range keys {
+		last, ok := w.last[k]
+		delta := max(last, values[i]) - min(last, values[i])
+		if !ok {
+			delta = ^uint32(0)
+		}
+		if !refresh && ok && delta <= w.deadband {
+			skipped++
+			continue
+		}
+		changes = append(changes, change{k, values[i], delta})
+	}
+
+	// A refresh is not rate limited: it is what bounds how stale an entry
+	// can get.
+	var deferred uint64
+	if !refresh {
+		if n := w.limit.take(len(changes), now); n < len(changes) {
+			sort.Slice(changes, func(i, j int) bool { return changes[i].delta > changes[j].delta })
+			deferred = uint64(len(changes) - n)
+			changes = changes[:n]
+		}
+	}
+
+	var err error
+	var syscalls uint64
+	if len(changes) > 0 {
+		ks := make([]uint32, len(changes))
+		vs := make([]uint32, len(changes))
+		for i, c := range changes {
+			ks[i], vs[i] = c.key, c.value
+		}
+		err = updateUint32s(w.m, ks, vs)
+		syscalls = 1
+		if noBatch.Load() {
+			syscalls = uint64(len(ks))
+		}
+		if err == nil {
+			for _, c := range changes {
+				w.last[c.key] = c.value
+			}
+		}
+	}
+	if refresh && err == nil {
+		w.refreshed = now
+	}
+
+	collectorUpdates.Lock()
+	if err == nil {
+		w.stats.Written += uint64(len(changes))
+	}
+	w.stats.Skipped += skipped
+	w.stats.Deferred += deferred
+	w.stats.Syscalls += syscalls
+	collectorUpdates.Unlock()
+	return err
+}
+
+// endPeriod works out the write rate over the log period ending at now and
+// returns the counters.
+func (w *mapWriter) endPeriod(now time.Time) UpdateStats {
+	collectorUpdates.Lock()
+	defer collectorUpdates.Unlock()
+	if elapsed := now.Sub(w.periodAt).Seconds(); elapsed > 0 {
+		w.stats.Rate = float64(w.stats.Written-w.period) / elapsed
+	}
+	w.period, w.periodAt = w.stats.Written, now
+	return *w.stats
+}
+
+// forgetUpdates drops the collector's counters from CollectorUpdates.
+func (cfg CollectorConfig) forgetUpdates() {
+	collectorUpdates.Lock()
+	delete(collectorUpdates.groups, cfg.Group)
+	collectorUpdates.Unlock()
+}
@@ -0,0 +1,941 @@
+package reuseportlb
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cilium/ebpf"
+)
+
+// acceptqProgPin is where the accept queue tracker is pinned.
+const acceptqProgPin = "/sys/fs/bpf/acceptq_bpf"
+
+// CollectorConfig configures RunCollector.
+type CollectorConfig struct {
+	// Group selects whose slot maps are maintained and logged.
+	Group Group
+	// CPUs are the cores whose utilization is published in cpu_util_map.
+	// Empty publishes every online core, following CPUs as they are
+	// hotplugged.
+	CPUs []int
+	// HousekeepingCPUs are the cores left to the kernel and background
+	// work on machines that isolate the rest for the servers (isolcpus=,
+	// nohz_full=). They are still sampled and logged, but a slot's
+	// utilization, which the policies steer by, only averages the
+	// application CPUs its owner may run on: housekeeping noise on a core
+	// the owner could use but in practice does not stays out of it. See
+	// ParseHousekeepingCPUs.
+	HousekeepingCPUs []int
+	// LogDir receives the cpu_stats_* and acceptq_stats_* logs.
+	LogDir string
+	// Period is the interval between log snapshots.
+	Period time.Duration
+	// Interval is the interval between CPU samples and map updates.
+	Interval time.Duration
+	// Alpha is the EWMA smoothing factor; with Adaptive it varies per core
+	// within [AlphaMin, AlphaMax] (see EWMA).
+	Alpha, AlphaMin, AlphaMax float64
+	Adaptive                  bool
+	// AcceptqReduce folds per-CPU accept queue entries: "sum" or "max".
+	AcceptqReduce string
+	// AcceptqProgObj is the accept queue kprobe object loaded with bpftool.
+	AcceptqProgObj string
+	// AcceptqFentryObj is the fentry flavor of AcceptqProgObj.
+	AcceptqFentryObj string
+	// AcceptqHook picks how the accept queue tracker attaches: "kprobe",
+	// "fentry", or "auto" for fentry when the kernel exposes BTF, falling
+	// back to the kprobe if that cannot be loaded.
+	AcceptqHook string
+	// Latency attaches the accept-to-response latency probes and logs
+	// per-slot quantiles to latency_stats_* every Period.
+	Latency bool
+	// ConnStats attaches the connection tracker and logs each slot's
+	// connection lifetimes and traffic to conn_stats_* every Period.
+	ConnStats bool
+	// NetDistress attaches the network distress tracker, publishes each
+	// slot's retransmit and ECN rates with a score against the group's
+	// median in slot_net for the "net" health signal, and logs them to
+	// net_stats_* every Period.
+	NetDistress bool
+	// Events takes accept queue depths from the tracker's acceptq_events
+	// notifications instead of looking every slot up in acceptq_map each
+	// Period. Collectors that use it should also sample CPUs less often
+	// (see EventDrivenInterval).
+	Events bool
+	// CgroupUtil derives each slot's utilization from the CPU time its
+	// owner's cgroup used, over the CPUs the owner may run on, instead of
+	// from how busy those CPUs were: load from other processes sharing the
+	// cores stays out of the signal. It needs the servers in cgroups of
+	// their own (Group.JoinCgroup); owners in the root cgroup fall back to
+	// the per-core figure.
+	CgroupUtil bool
+	// CPUSource is where per-core utilization is read from: CPUSourceStat
+	// (the default), CPUSourceSchedstat or CPUSourcePSI, which also takes
+	// slot utilization from the owners' cgroup CPU pressure.
+	CPUSource string
+	// UpdateDeadband is how far, in the maps' hundredths of a percent, a
+	// utilization may move before cpu_util_map and slot_util are written
+	// again; 0 writes every change and skips only unchanged values.
+	UpdateDeadband uint32
+	// UpdateRefresh is how often every entry is rewritten whether it moved
+	// or not; 0 writes every entry every Interval, as before coalescing.
+	UpdateRefresh time.Duration
+	// MaxUpdateRate caps the utilization entries written per second across
+	// both maps, the biggest changes going first; 0 leaves it uncapped.
+	MaxUpdateRate float64
+	// Power reads how much power each CPU package draws from RAPL every
+	// Period, logs it to cpu_stats_* and publishes it as the package_power
+	// expvar (see PackagePowerDraw).
+	Power bool
+}
+
+// EventDrivenInterval is the CPU sampling interval used with Events when
+// none is given: accept queue changes no longer depend on the sampling
+// loop, so it only has to keep up with utilization.
+const EventDrivenInterval = 250 * time.Millisecond
+
+// DefaultCollectorConfig returns the settings collect_stats has always used.
+func DefaultCollectorConfig() CollectorConfig {
+	return CollectorConfig{
+		LogDir:           "log",
+		Period:           time.Second,
+		Interval:         50 * time.Millisecond,
+		Alpha:            0.25,
+		AlphaMin:         0.05,
+		AlphaMax:         0.8,
+		AcceptqReduce:    "sum",
+		AcceptqProgObj:   "reuseportlb/eBPF/acceptq_bpf.o",
+		AcceptqFentryObj: "reuseportlb/eBPF/acceptq_fentry.o",
+		AcceptqHook:      "auto",
+		CPUSource:        CPUSourceStat,
+		UpdateRefresh:    DefaultUpdateRefresh,
+	}
+}
+
+// Validate reports the first setting RunCollector cannot work with.
+func (cfg CollectorConfig) Validate() error {
+	if cfg.AcceptqReduce != "sum" && cfg.AcceptqReduce != "max" {
+		return fmt.Errorf("invalid accept queue reduction %q: must be sum or max", cfg.AcceptqReduce)
+	}
+	switch cfg.AcceptqHook {
+	case "auto", "fentry", "kprobe":
+	default:
+		return fmt.Errorf("invalid accept queue hook %q: must be auto, fentry or kprobe", cfg.AcceptqHook)
+	}
+	if cfg.Interval <= 0 || cfg.Period <= 0 {
+		return errors.New("update interval and log period must be positive")
+	}
+	for _, v := range []float64{cfg.Alpha, cfg.AlphaMin, cfg.AlphaMax} {
+		if v <= 0 || v > 1 {
+			return fmt.Errorf("invalid smoothing factor %v: must be in (0, 1]", v)
+		}
+	}
+	if cfg.AlphaMin > cfg.AlphaMax {
+		return fmt.Errorf("smoothing bounds inverted: min %v exceeds max %v", cfg.AlphaMin, cfg.AlphaMax)
+	}
+	for _, core := range cfg.CPUs {
+		if core >= MaxCPUs() {
+			return fmt.Errorf("CPU core %d does not fit %s: it has room for cores 0-%d", core, CPUUtilMap, MaxCPUs()-1)
+		}
+	}
+	if cfg.UpdateRefresh < 0 || cfg.MaxUpdateRate < 0 {
+		return errors.New("update refresh and rate limit must not be negative")
+	}
+	if cfg.UpdateDeadband > 10000 {
+		return fmt.Errorf("update deadband %d exceeds 10000 (100%%)", cfg.UpdateDeadband)
+	}
+	switch cfg.CPUSource {
+	case "", CPUSourceStat, CPUSourceSchedstat:
+	case CPUSourcePSI:
+		if cfg.CgroupUtil {
+			return errors.New("cgroup utilization and the psi CPU source both set slot utilization: pick one")
+		}
+	default:
+		return fmt.Errorf("invalid CPU source %q: must be one of %s", cfg.CPUSource, strings.Join(CPUSources, ", "))
+	}
+	return nil
+}
+
+// RunCollector samples CPU utilization and accept queue depths until ctx is
+// done. It smooths per-core utilization into cpu_util_map, derives per-slot
+// utilization into slot_util from the slot owners' affinity, publishes the
+// owners' memory use and pressure in slot_mem and their CPU pressure in
+// slot_psi, and writes periodic snapshots to log files under cfg.LogDir.
+func RunCollector(ctx context.Context, cfg CollectorConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(cfg.LogDir, 0o755); err != nil {
+		return fmt.Errorf("create log directory: %w", err)
+	}
+
+	// Named groups get their own logs so concurrent collectors don't
+	// interleave.
+	timestamp := time.Now().Format("20060102_150405")
+	if cfg.Group != DefaultGroup {
+		timestamp = string(cfg.Group) + "_" + timestamp
+	}
+	cpuLogPath := filepath.Join(cfg.LogDir, fmt.Sprintf("cpu_stats_%s.log", timestamp))
+	acceptqLogPath := filepath.Join(cfg.LogDir, fmt.Sprintf("acceptq_stats_%s.log", timestamp))
+
+	cpuLogFile, err := os.OpenFile(cpuLogPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("open CPU log file: %w", err)
+	}
+	defer cpuLogFile.Close()
+	cpuLogger := log.New(cpuLogFile, "", log.LstdFlags)
+
+	acceptqLogFile, err := os.OpenFile(acceptqLogPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("open accept queue log file: %w", err)
+	}
+	defer acceptqLogFile.Close()
+	acceptqLogger := log.New(acceptqLogFile, "", log.LstdFlags)
+
+	if err := EnsureLayout(); err != nil {
+		return err
+	}
+
+	g := cfg.Group
+	m, err := g.OpenOrCreatePinnedMap(CPUUtilMap)
+	if err != nil {
+		return fmt.Errorf("set up cpu util map: %w", err)
+	}
+	defer m.Close()
+
+	slotOwnerMap, err := g.OpenOrCreatePinnedMap(SlotOwnerMap)
+	if err != nil {
+		return fmt.Errorf("set up slot owner map: %w", err)
+	}
+	defer slotOwnerMap.Close()
+
+	slotUtilMap, err := g.OpenOrCreatePinnedMap(SlotUtilMap)
+	if err != nil {
+		return fmt.Errorf("set up slot util map: %w", err)
+	}
+	defer slotUtilMap.Close()
+
+	slotMemMap, err := g.OpenOrCreatePinnedMap(SlotMemMap)
+	if err != nil {
+		return fmt.Errorf("set up slot memory map: %w", err)
+	}
+	defer slotMemMap.Close()
+
+	slotPSIMap, err := g.OpenOrCreatePinnedMap(SlotPSIMap)
+	if err != nil {
+		return fmt.Errorf("set up slot pressure map: %w", err)
+	}
+	defer slotPSIMap.Close()
+
+	acceptqCleanup, err := ensureAcceptqProgramLoaded(cfg)
+	if err != nil {
+		return fmt.Errorf("load accept queue program: %w", err)
+	}
+	if acceptqCleanup != nil {
+		defer acceptqCleanup()
+	}
+
+	var latencyLogger *log.Logger
+	if cfg.Latency {
+		stopProbes, err := StartLatencyProbes()
+		if err != nil {
+			return err
+		}
+		defer stopProbes()
+		latencyLogPath := filepath.Join(cfg.LogDir, fmt.Sprintf("latency_stats_%s.log", timestamp))
+		latencyLogFile, err := os.OpenFile(latencyLogPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("open latency log file: %w", err)
+		}
+		defer latencyLogFile.Close()
+		latencyLogger = log.New(latencyLogFile, "", log.LstdFlags)
+		slog.Info("Logging accept-to-response latency", "path", latencyLogPath)
+	}
+
+	var connLogger *log.Logger
+	if cfg.ConnStats {
+		stopTracker, err := StartConnStats()
+		if err != nil {
+			return err
+		}
+		defer stopTracker()
+		connLogPath := filepath.Join(cfg.LogDir, fmt.Sprintf("conn_stats_%s.log", timestamp))
+		connLogFile, err := os.OpenFile(connLogPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("open connection log file: %w", err)
+		}
+		defer connLogFile.Close()
+		connLogger = log.New(connLogFile, "", log.LstdFlags)
+		slog.Info("Logging connection lifetimes and traffic", "path", connLogPath)
+	}
+
+	var netLogger *log.Logger
+	var netMap *ebpf.Map
+	var distress *netSampler
+	netBySlot := make(map[uint32]SlotNet)
+	if cfg.NetDistress {
+		stopTracker, err := StartNetDistress()
+		if err != nil {
+			return err
+		}
+		defer stopTracker()
+		if netMap, err = g.OpenOrCreatePinnedMap(SlotNetMap); err != nil {
+			return err
+		}
+		defer netMap.Close()
+		distress = newNetSampler(cfg)
+		netLogPath := filepath.Join(cfg.LogDir, fmt.Sprintf("net_stats_%s.log", timestamp))
+		netLogFile, err := os.OpenFile(netLogPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("open network distress log file: %w", err)
+		}
+		defer netLogFile.Close()
+		netLogger = log.New(netLogFile, "", log.LstdFlags)
+		slog.Info("Logging retransmits and ECN reductions", "path", netLogPath)
+	}
+
+	var acceptqEvents *acceptqCache
+	if cfg.Events {
+		cache, release, err := watchAcceptq()
+		if err != nil {
+			return fmt.Errorf("watch accept queue events: %w", err)
+		}
+		defer release()
+		acceptqEvents = cache
+		slog.Info("Taking accept queue depths from events", "path", g.PinnedMapPath(AcceptqEventsMap))
+	}
+
+	var acceptqStatsMap *ebpf.Map
+	var acceptqSlotMap *ebpf.Map
+	defer func() {
+		if acceptqStatsMap != nil {
+			acceptqStatsMap.Close()
+		}
+		if acceptqSlotMap != nil {
+			acceptqSlotMap.Close()
+		}
+	}()
+
+	cpuCores, err := monitoredCPUs(cfg.CPUs)
+	if err != nil {
+		return err
+	}
+	slog.Info("Monitoring CPU cores", "group", cfg.Group.String(), "cpus", cpuCores,
+		"auto", len(cfg.CPUs) == 0, "max_cpus", MaxCPUs())
+	slog.Info("Collector settings", "update_interval", cfg.Interval, "alpha", cfg.Alpha,
+		"adaptive", cfg.Adaptive, "alpha_min", cfg.AlphaMin, "alpha_max", cfg.AlphaMax)
+	slog.Info("Stats log paths", "cpu_log", cpuLogPath, "acceptq_log", acceptqLogPath)
+
+	if cfg.CPUSource == "" {
+		cfg.CPUSource = CPUSourceStat
+	}
+	cpus, err := newCPUSampler(cfg.CPUSource)
+	if err != nil {
+		return err
+	}
+	if _, err := cpus.sample(); err != nil {
+		return err
+	}
+	slog.Info("Reading CPU utilization", "source", cfg.CPUSource)
+
+	monitored := make(map[int]bool, len(cpuCores))
+	for _, coreID := range cpuCores {
+		monitored[coreID] = true
+	}
+	offline := make(map[int]bool)
+	housekeeping := make(map[int]bool, len(cfg.HousekeepingCPUs))
+	for _, coreID := range cfg.HousekeepingCPUs {
+		housekeeping[coreID] = true
+	}
+	if len(housekeeping) > 0 {
+		slog.Info("Leaving housekeeping CPUs out of slot utilization", "housekeeping_cpus", cfg.HousekeepingCPUs)
+	}
+	avgByCore := make(map[int]*EWMA)
+	instUtilByCore := make(map[int]float64)
+	mapValueByCore := make(map[int]uint32)
+	owners := make(map[uint32]SlotOwner)
+	slotUtilBySlot := make(map[uint32]uint32)
+	memBySlot := make(map[uint32]SlotMem)
+	acceptqEntryBySlot := make(map[uint32]AcceptqEntry)
+	slotCookieBySlot := make(map[uint32]uint64)
+	var cgroups *cgroupSampler
+	if cfg.CgroupUtil {
+		cgroups = newCgroupSampler(cfg)
+		slog.Info("Taking slot utilization from the owners' cgroups")
+	}
+	defer cfg.forgetUpdates()
+	limit := &updateLimiter{rate: cfg.MaxUpdateRate}
+	cpuWriter := cfg.newMapWriter(m, CPUUtilMap, limit)
+	slotUtilWriter := cfg.newMapWriter(slotUtilMap, SlotUtilMap, limit)
+	if cfg.UpdateRefresh > 0 {
+		slog.Info("Coalescing utilization map updates", "deadband", cfg.UpdateDeadband,
+			"refresh", cfg.UpdateRefresh, "max_update_rate", cfg.MaxUpdateRate)
+	}
+
+	var power *powerSampler
+	if cfg.Power {
+		if power, err = newPowerSampler(); err != nil {
+			return fmt.Errorf("read package power: %w", err)
+		}
+		slog.Info("Reading package power from RAPL", "packages", len(power.zones))
+	}
+
+	pressure := newPressureSampler(cfg)
+	psiBySlot := make(map[uint32]SlotPSI)
+	if cfg.CPUSource == CPUSourcePSI {
+		slog.Info("Taking slot utilization from the owners' cgroup CPU pressure")
+	}
+
+	updateTicker := time.NewTicker(cfg.Interval)
+	defer updateTicker.Stop()
+
+	ticker := time.NewTicker(cfg.Period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Info("Received shutdown signal, exiting")
+			return nil
+		case <-updateTicker.C:
+		}
+
+		utilByCore, err := cpus.sample()
+		if err != nil {
+			slog.Error("error sampling CPU utilization", "source", cfg.CPUSource, "err", err)
+			continue
+		}
+
+		if o, err := SlotOwners(slotOwnerMap); err != nil {
+			slog.Error("failed to read slot owners", "err", err)
+		} else {
+			owners = o
+		}
+
+		var cpuKeys, cpuValues []uint32
+		for _, coreID := range trackedCores(cpuCores, owners) {
+			instUtil, ok := utilByCore[coreID]
+			if !ok {
+				// The core went offline: /proc/stat and schedstat stop
+				// listing it. Zero its entry rather than leave the last
+				// reading standing, and start afresh if it comes back.
+				if _, tracked := avgByCore[coreID]; tracked && monitored[coreID] {
+					slog.Info("CPU went offline", "cpu", coreID)
+					offline[coreID] = true
+					mapValueByCore[coreID] = 0
+					cpuKeys = append(cpuKeys, uint32(coreID))
+					cpuValues = append(cpuValues, 0)
+				}
+				delete(avgByCore, coreID)
+				delete(instUtilByCore, coreID)
+				continue
+			}
+			if offline[coreID] {
+				slog.Info("CPU came back online", "cpu", coreID)
+				delete(offline, coreID)
+			}
+			instUtilByCore[coreID] = instUtil
+
+			avg, ok := avgByCore[coreID]
+			if !ok {
+				avg = &EWMA{Alpha: cfg.Alpha, Adaptive: cfg.Adaptive, MinAlpha: cfg.AlphaMin, MaxAlpha: cfg.AlphaMax}
+				avgByCore[coreID] = avg
+			}
+			newAvg := avg.Update(instUtil)
+			if !monitored[coreID] {
+				continue
+			}
+
+			var key uint32 = uint32(coreID)
+			value := uint32(newAvg * 100)
+			mapValueByCore[coreID] = value
+			cpuKeys = append(cpuKeys, key)
+			cpuValues = append(cpuValues, value)
+			slog.Debug("CPU utilization", "cpu", coreID, "inst", instUtil, "avg", newAvg, "alpha", avgByCore[coreID].CurrentAlpha(), "map", value)
+		}
+		now := time.Now()
+		if err := cpuWriter.write(cpuKeys, cpuValues, now); err != nil {
+			slog.Error("failed to update CPU map", "err", err)
+		}
+
+		// CPU pressure per slot, for the psi policy and, with the psi
+		// source, for slot_util.
+		pressure.sampleHost()
+		clear(psiBySlot)
+		for slot, owner := range owners {
+			if v, ok := pressure.pressure(slot, owner); ok {
+				psiBySlot[slot] = v
+			}
+		}
+		if err := publishPressure(slotPSIMap, psiBySlot); err != nil {
+			slog.Error("failed to update slot pressure map", "err", err)
+		}
+
+		// Network distress per slot, for the "net" health signal.
+		if distress != nil {
+			if v, err := distress.sample(g); err != nil {
+				slog.Debug("network distress unavailable", "err", err)
+			} else {
+				netBySlot = v
+				if err := publishNet(netMap, netBySlot); err != nil {
+					slog.Error("failed to update slot network map", "err", err)
+				}
+			}
+		}
+
+		// A slot's utilization is the mean smoothed utilization of the
+		// application CPUs its owner may run on, the share of its CPUs its
+		// cgroup used, or the share of the time its cgroup stalled waiting
+		// for one.
+		var slotKeys, slotValues []uint32
+		for slot, owner := range owners {
+			if v, ok := psiBySlot[slot]; ok && v.Host == 0 && cfg.CPUSource == CPUSourcePSI {
+				slotUtilBySlot[slot] = v.Some
+				slotKeys = append(slotKeys, slot)
+				slotValues = append(slotValues, v.Some)
+				continue
+			}
+			if cgroups != nil {
+				if util, ok := cgroups.util(slot, owner); ok {
+					value := uint32(util * 100)
+					slotUtilBySlot[slot] = value
+					slotKeys = append(slotKeys, slot)
+					slotValues = append(slotValues, value)
+					continue
+				}
+			}
+			var sum float64
+			var n int
+			for _, coreID := range applicationCPUs(owner.CPUs(), housekeeping) {
+				if avg, ok := avgByCore[coreID]; ok {
+					sum += avg.Value()
+					n++
+				}
+			}
+			if n == 0 {
+				continue
+			}
+			value := uint32(sum / float64(n) * 100)
+			slotUtilBySlot[slot] = value
+			slotKeys = append(slotKeys, slot)
+			slotValues = append(slotValues, value)
+		}
+		if err := slotUtilWriter.write(slotKeys, slotValues, now); err != nil {
+			slog.Error("failed to update slot util map", "err", err)
+		}
+
+		// Memory use and pressure per slot, for the memguard policy.
+		for slot, owner := range owners {
+			mem, err := readSlotMem(int(owner.Pid))
+			if err != nil {
+				slog.Debug("reading slot memory failed", "slot", slot, "pid", owner.Pid, "err", err)
+				continue
+			}
+			memBySlot[slot] = mem
+			if err := slotMemMap.Update(slot, mem, ebpf.UpdateAny); err != nil {
+				slog.Error("failed to update slot memory map", "err", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			slog.Info("Received shutdown signal, exiting")
+			return nil
+		case <-ticker.C:
+			ts := time.Now().Format(time.RFC3339)
+			for _, w := range []*mapWriter{cpuWriter, slotUtilWriter} {
+				st := w.endPeriod(time.Now())
+				cpuLogger.Printf("ts=%s updates map=%s written=%d skipped=%d deferred=%d syscalls=%d rate=%.1f",
+					ts, w.name, st.Written, st.Skipped, st.Deferred, st.Syscalls, st.Rate)
+			}
+			for _, coreID := range cpuCores {
+				avg, ok := avgByCore[coreID]
+				if !ok {
+					cpuLogger.Printf("ts=%s cpu=%d offline", ts, coreID)
+					continue
+				}
+				var role string
+				if housekeeping[coreID] {
+					role = " housekeeping=true"
+				}
+				cpuLogger.Printf("ts=%s cpu=%d inst=%.2f avg=%.2f alpha=%.3f map=%d%s", ts, coreID, instUtilByCore[coreID], avg.Value(), avg.CurrentAlpha(), mapValueByCore[coreID], role)
+			}
+			if power != nil {
+				if readings, err := power.sample(); err != nil {
+					slog.Error("failed to read package power", "err", err)
+				} else {
+					for _, p := range readings {
+						cpuLogger.Printf("ts=%s package=%s zone=%s watts=%.2f", ts, p.Name, p.Zone, p.Watts)
+					}
+					publishPackagePower(readings)
+				}
+			}
+			if len(cfg.CPUs) == 0 {
+				if online, err := monitoredCPUs(nil); err != nil {
+					slog.Error("failed to read online CPUs", "err", err)
+				} else if !slices.Equal(online, cpuCores) {
+					slog.Info("Online CPUs changed", "from", cpuCores, "to", online)
+					var gone, zeros []uint32
+					for _, coreID := range cpuCores {
+						if !slices.Contains(online, coreID) {
+							gone = append(gone, uint32(coreID))
+							zeros = append(zeros, 0)
+							delete(avgByCore, coreID)
+							delete(offline, coreID)
+						}
+					}
+					if err := cpuWriter.write(gone, zeros, time.Now()); err != nil {
+						slog.Error("failed to update CPU map", "err", err)
+					}
+					cpuCores = online
+					clear(monitored)
+					for _, coreID := range cpuCores {
+						monitored[coreID] = true
+					}
+				}
+			}
+
+			slots := make([]uint32, 0, len(owners))
+			for slot := range owners {
+				slots = append(slots, slot)
+			}
+			sort.Slice(slots, func(i, j int) bool { return slots[i] < slots[j] })
+			for _, slot := range slots {
+				owner := owners[slot]
+				cpus := strings.Trim(strings.Join(strings.Fields(fmt.Sprint(owner.CPUs())), ","), "[]")
+				mem := memBySlot[slot]
+				var psi string
+				if v, ok := psiBySlot[slot]; ok {
+					psi = fmt.Sprintf(" cpu_pressure=%.2f host_pressure=%t", float64(v.Some)/100, v.Host != 0)
+				}
+				if cg, ok := cgroups.stats(slot); ok {
+					cpuLogger.Printf("ts=%s slot=%d pid=%d cpus=%s map=%d cgroup_usage_us=%d throttled_us=%d mem=%d mem_pressure=%.2f%s",
+						ts, slot, owner.Pid, cpus, slotUtilBySlot[slot], cg.CPUUsage.Microseconds(), cg.Throttled.Microseconds(), mem.Bytes, float64(mem.Pressure)/100, psi)
+					continue
+				}
+				cpuLogger.Printf("ts=%s slot=%d pid=%d cpus=%s map=%d mem=%d%s", ts, slot, owner.Pid, cpus, slotUtilBySlot[slot], mem.Bytes, psi)
+			}
+
+			// Only present when the round-robin policy is loaded.
+			if pos, err := g.RoundRobinPosition(); err == nil {
+				slog.Info("Round robin position", "position", pos)
+			}
+
+			if latencyLogger != nil {
+				logLatency(latencyLogger, ts, g)
+			}
+			if connLogger != nil {
+				logConnStats(connLogger, ts, g)
+			}
+			if netLogger != nil {
+				logNet(netLogger, ts, netBySlot)
+			}
+
+			if acceptqSlotMap == nil {
+				if m, err := g.OpenPinnedMap(SlotCookiesMap); err == nil {
+					acceptqSlotMap = m
+					slog.Info("Connected to accept queue slot map", "path", g.PinnedMapPath(SlotCookiesMap))
+				} else {
+					acceptqLogger.Printf("ts=%s slot_map_unavailable err=%v", ts, err)
+					continue
+				}
+			}
+
+			if acceptqStatsMap == nil {
+				if m, err := g.OpenPinnedMap(AcceptqMap); err == nil {
+					acceptqStatsMap = m
+					slog.Info("Connected to accept queue stats map", "path", g.PinnedMapPath(AcceptqMap))
+					if IsPerCPU(m) {
+						slog.Info("Accept queue stats map is per-CPU", "reduce", cfg.AcceptqReduce)
+					}
+				} else {
+					acceptqLogger.Printf("ts=%s stats_map_unavailable err=%v", ts, err)
+					continue
+				}
+			}
+
+			// One batch read per map instead of a lookup per slot.
+			cookies, err := lookupSlotCookies(acceptqSlotMap)
+			if err != nil {
+				acceptqLogger.Printf("ts=%s cookie_lookup_err=%v", ts, err)
+				continue
+			}
+			var acceptqAll map[uint64]AcceptqEntry
+			if acceptqEvents == nil {
+				if acceptqAll, err = lookupAcceptqAll(acceptqStatsMap, cfg.AcceptqReduce); err != nil {
+					slog.Debug("batch accept queue read failed", "err", err)
+				}
+			}
+
+			for slot := range cpuCores {
+				var slotKey uint32 = uint32(slot)
+				cookie := cookies[slotKey]
+				if cookie == 0 {
+					acceptqLogger.Printf("ts=%s slot=%d cookie=0", ts, slotKey)
+					continue
+				}
+				slotCookieBySlot[slotKey] = cookie
+
+				// Events only arrive once a listener's backlog moves, so
+				// fall back to the map until the first one.
+				entry, ok := AcceptqEntry{}, false
+				if acceptqEvents != nil {
+					entry, ok = acceptqEvents.get(cookie)
+				} else if acceptqAll != nil {
+					entry, ok = acceptqAll[cookie]
+				}
+				if !ok {
+					entry, err = lookupAcceptq(acceptqStatsMap, cookie, cfg.AcceptqReduce)
+					if err != nil {
+						acceptqLogger.Printf("ts=%s slot=%d cookie=0x%x stats_lookup_err=%v", ts, slotKey, cookie, err)
+						continue
+					}
+				}
+				acceptqEntryBySlot[slotKey] = entry
+
+				util := 0.0
+				if entry.Max > 0 {
+					util = float64(entry.Curr) / float64(entry.Max) * 100
+				}
+				acceptqLogger.Printf("ts=%s slot=%d cookie=0x%x curr=%d max=%d cpu=%d util=%.2f",
+					ts, slotKey, cookie, entry.Curr, entry.Max, entry.Cpu, util)
+			}
+		default:
+		}
+	}
+}
+
+// CPUStat is one cpuN line of /proc/stat, in clock ticks.
+type CPUStat struct {
+	User, Nice, System, Idle, IOWait, IRQ, SoftIRQ, Steal, Guest, GuestNice uint64
+}
+
+// lookupAcceptq reads the accept queue entry for cookie. Per-CPU flavors of
+// the map hold one entry per possible CPU; those are folded into one with the
+// given reduction ("sum" or "max").
+func lookupAcceptq(m *ebpf.Map, cookie uint64, reduce string) (AcceptqEntry, error) {
+	if !IsPerCPU(m) {
+		var entry AcceptqEntry
+		err := m.Lookup(&cookie, &entry)
+		return entry, err
+	}
+
+	var perCPU []AcceptqEntry
+	if err := m.Lookup(&cookie, &perCPU); err != nil {
+		return AcceptqEntry{}, err
+	}
+	return reduceAcceptq(perCPU, reduce), nil
+}
+
+// reduceAcceptq folds per-CPU accept queue entries into one. "max" keeps the
+// entry with the deepest queue; "sum" adds up the queue depths. Max is the
+// listener's backlog limit, not a per-CPU quantity, so it is never summed.
+// Cpu always reports the CPU that saw the deepest queue.
+func reduceAcceptq(perCPU []AcceptqEntry, reduce string) AcceptqEntry {
+	var out AcceptqEntry
+	var sum uint32
+	for i, e := range perCPU {
+		sum += e.Curr
+		if i == 0 || e.Curr > out.Curr {
+			out.Curr = e.Curr
+			out.Cpu = e.Cpu
+		}
+		if e.Max > out.Max {
+			out.Max = e.Max
+		}
+	}
+	if reduce == "sum" {
+		out.Curr = sum
+	}
+	return out
+}
+
+func readCPUStat() (map[int]CPUStat, error) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stats := make(map[int]CPUStat)
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "cpu") || line == "cpu " {
+			continue
+		}
+
+		var cpu int
+		var s CPUStat
+		_, err := fmt.Sscanf(line, "cpu%d %d %d %d %d %d %d %d %d %d %d",
+			&cpu, &s.User, &s.Nice, &s.System, &s.Idle,
+			&s.IOWait, &s.IRQ, &s.SoftIRQ, &s.Steal, &s.Guest, &s.GuestNice)
+		if err != nil {
+			continue
+		}
+		stats[cpu] = s
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+func calculateUtilization(prev, curr CPUStat) float64 {
+	prevIdle := prev.Idle + prev.IOWait
+	currIdle := curr.Idle + curr.IOWait
+	prevTotal := prev.User + prev.Nice + prev.System + prevIdle + prev.IRQ + prev.SoftIRQ + prev.Steal
+	currTotal := curr.User + curr.Nice + curr.System + currIdle + curr.IRQ + curr.SoftIRQ + curr.Steal
+
+	totald := float64(currTotal - prevTotal)
+	idled := float64(currIdle - prevIdle)
+
+	if totald == 0 {
+		return 0.0
+	}
+	return (1.0 - idled/totald) * 100.0
+}
+
+// trackedCores returns the monitored cores followed by any other CPU a slot
+// owner may run on, so per-slot utilization covers every CPU it depends on.
+func trackedCores(cpuCores []int, owners map[uint32]SlotOwner) []int {
+	seen := make(map[int]bool, len(cpuCores))
+	out := append([]int(nil), cpuCores...)
+	for _, c := range cpuCores {
+		seen[c] = true
+	}
+	var extra []int
+	for _, owner := range owners {
+		for _, c := range owner.CPUs() {
+			if !seen[c] {
+				seen[c] = true
+				extra = append(extra, c)
+			}
+		}
+	}
+	sort.Ints(extra)
+	return append(out, extra...)
+}
+
+// acceptqLoadMu keeps collectors for different groups in one process from
+// racing to load the shared accept queue kprobe.
+var acceptqLoadMu sync.Mutex
+
+// logLatency writes one line of latency quantiles per slot, in microseconds.
+func logLatency(logger *log.Logger, ts string, g Group) {
+	hists, err := g.LatencyHistograms()
+	if err != nil {
+		logger.Printf("ts=%s latency_unavailable err=%v", ts, err)
+		return
+	}
+	slots := make([]uint32, 0, len(hists))
+	for slot := range hists {
+		slots = append(slots, slot)
+	}
+	sort.Slice(slots, func(i, j int) bool { return slots[i] < slots[j] })
+	us := func(d time.Duration) int64 { return d.Microseconds() }
+	for _, slot := range slots {
+		h := hists[slot]
+		logger.Printf("ts=%s slot=%d count=%d mean_us=%d p50_us=%d p90_us=%d p99_us=%d",
+			ts, slot, h.Count, us(h.Mean()), us(h.Quantile(0.5)), us(h.Quantile(0.9)), us(h.Quantile(0.99)))
+	}
+}
+
+// logConnStats writes one line of connection totals per slot.
+func logConnStats(logger *log.Logger, ts string, g Group) {
+	all, err := g.ConnectionStats()
+	if err != nil {
+		logger.Printf("ts=%s conn_stats_unavailable err=%v", ts, err)
+		return
+	}
+	slots := make([]uint32, 0, len(all))
+	for slot := range all {
+		slots = append(slots, slot)
+	}
+	sort.Slice(slots, func(i, j int) bool { return slots[i] < slots[j] })
+	for _, slot := range slots {
+		s := all[slot]
+		logger.Printf("ts=%s slot=%d open=%d closed=%d mean_life_ms=%d bytes_in=%d bytes_out=%d retrans=%d",
+			ts, slot, s.Open, s.Closed, s.MeanLifetime().Milliseconds(), s.BytesIn, s.BytesOut, s.Retrans)
+	}
+}
+
+// ensureAcceptqProgramLoaded loads and auto-attaches the accept queue
+// tracker via bpftool, in the flavor cfg.AcceptqHook asks for, unless it is
+// already pinned. The returned cleanup unpins it again; it is nil when the
+// program was already there.
+func ensureAcceptqProgramLoaded(cfg CollectorConfig) (func(), error) {
+	acceptqLoadMu.Lock()
+	defer acceptqLoadMu.Unlock()
+	if _, err := os.Stat(acceptqProgPin); err == nil {
+		slog.Info("Accept queue program already pinned, not reloading", "path", acceptqProgPin)
+		return nil, nil
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to stat %s: %w", acceptqProgPin, err)
+	}
+
+	hook := cfg.AcceptqHook
+	if hook == "auto" {
+		hook = "kprobe"
+		if _, err := os.Stat("/sys/kernel/btf/vmlinux"); err == nil {
+			hook = "fentry"
+		}
+	}
+	var err error
+	if hook == "fentry" {
+		err = loadAcceptqObject(cfg.AcceptqFentryObj, "fentry")
+		if err != nil && cfg.AcceptqHook == "auto" {
+			slog.Warn("Loading fentry accept queue program failed, falling back to kprobe", "err", err)
+			hook = "kprobe"
+		}
+	}
+	if hook == "kprobe" {
+		err = loadAcceptqObject(cfg.AcceptqProgObj, "kprobe")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cleanup := func() {
+		cmd := exec.Command("sudo", "rm", "-f", acceptqProgPin)
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			slog.Error("Failed to remove pinned accept queue program", "path", acceptqProgPin, "err", err, "output", strings.TrimSpace(string(output)))
+			return
+		}
+		slog.Info("Removed pinned accept queue program", "path", acceptqProgPin)
+	}
+
+	return cleanup, nil
+}
+
+func loadAcceptqObject(objPath, progType string) error {
+	objPath, err := filepath.Abs(objPath)
+	if err != nil {
+		return fmt.Errorf("failed to resolve path to %s: %w", objPath, err)
+	}
+
+	cmd := exec.Command("sudo", "bpftool", "prog", "load",
+		objPath, acceptqProgPin, "type", progType, "autoattach")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("bpftool load failed: %v (output: %s)", err, strings.TrimSpace(string(output)))
+	}
+
+	slog.Info("Loaded accept queue BPF program", "object", objPath, "hook", progType, "path", acceptqProgPin)
+	return nil
+}
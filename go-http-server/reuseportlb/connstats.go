@@ -0,0 +1,175 @@
+package reuseportlb
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+
+	"go-http-server/stats"
+)
+
+// ConnStats are the connections one listener accepted, from conn_stats.
+// Traffic is added when a connection closes, so it covers Closed only.
+type ConnStats struct {
+	// Open counts the connections accepted and not closed yet.
+	Open   uint64
+	Closed uint64
+	// Lifetime is the summed lifetime of the closed connections.
+	Lifetime time.Duration
+	// BytesIn counts the bytes received, BytesOut those sent and
+	// acknowledged by the client.
+	BytesIn  uint64
+	BytesOut uint64
+	// Retrans counts retransmitted segments.
+	Retrans uint64
+}
+
+// MeanLifetime returns how long the closed connections lasted on average.
+func (s ConnStats) MeanLifetime() time.Duration {
+	if s.Closed == 0 {
+		return 0
+	}
+	return s.Lifetime / time.Duration(s.Closed)
+}
+
+// ConnectionStats returns the connection totals of every registered slot
+// in the group that has accepted a connection since the tracker started.
+func (g Group) ConnectionStats() (map[uint32]ConnStats, error) {
+	cookies, err := g.OpenPinnedMap(SlotCookiesMap)
+	if err != nil {
+		return nil, err
+	}
+	defer cookies.Close()
+	m, err := g.OpenPinnedMap(ConnStatsMap)
+	if err != nil {
+		return nil, err
+	}
+	defer m.Close()
+
+	out := make(map[uint32]ConnStats)
+	var slot uint32
+	var cookie uint64
+	iter := cookies.Iterate()
+	for iter.Next(&slot, &cookie) {
+		if cookie == 0 {
+			continue
+		}
+		var v connstatsConnStats
+		if err := m.Lookup(&cookie, &v); err != nil {
+			continue
+		}
+		out[slot] = ConnStats{Open: v.Open, Closed: v.Closed, Lifetime: time.Duration(v.LifeNs),
+			BytesIn: v.BytesIn, BytesOut: v.BytesOut, Retrans: v.Retrans}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("iterate %s: %w", SlotCookiesMap, err)
+	}
+	return out, nil
+}
+
+// ServeConnStats is an admin handler reporting the group's per-slot
+// connection totals as JSON, with how evenly connections and bytes are
+// spread over the slots (Jain's index, 1 for perfectly even): a policy
+// can balance connections and still leave one slot moving most bytes.
+func (g Group) ServeConnStats(w http.ResponseWriter, r *http.Request) {
+	all, err := g.ConnectionStats()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	type slotStats struct {
+		Slot         uint32 `json:"slot"`
+		Open         uint64 `json:"open"`
+		Closed       uint64 `json:"closed"`
+		MeanLifetime string `json:"mean_lifetime"`
+		BytesIn      uint64 `json:"bytes_in"`
+		BytesOut     uint64 `json:"bytes_out"`
+		Retrans      uint64 `json:"retrans"`
+	}
+	resp := struct {
+		Slots     []slotStats `json:"slots"`
+		ConnsJain float64     `json:"conns_jain"`
+		BytesJain float64     `json:"bytes_jain"`
+	}{Slots: []slotStats{}}
+	var conns, bytes []float64
+	for slot, s := range all {
+		resp.Slots = append(resp.Slots, slotStats{slot, s.Open, s.Closed, s.MeanLifetime().String(), s.BytesIn, s.BytesOut, s.Retrans})
+		conns = append(conns, float64(s.Open+s.Closed))
+		bytes = append(bytes, float64(s.BytesIn+s.BytesOut))
+	}
+	sort.Slice(resp.Slots, func(i, j int) bool { return resp.Slots[i].Slot < resp.Slots[j].Slot })
+	if len(all) > 0 {
+		resp.ConnsJain, resp.BytesJain = stats.JainIndex(conns), stats.JainIndex(bytes)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// connTracker is shared by everything in the process that wants connection
+// totals; the programs stay attached while anyone holds a reference.
+var connTracker struct {
+	sync.Mutex
+	refs  int
+	close func()
+}
+
+// StartConnStats attaches the connection tracker (the sock_ops program to
+// the root cgroup, and an inet_csk_accept probe) and returns a func that
+// detaches it again. It needs the same kernel as the latency probes.
+func StartConnStats() (func(), error) {
+	connTracker.Lock()
+	defer connTracker.Unlock()
+	if connTracker.refs == 0 {
+		closeTracker, err := attachConnStats()
+		if err != nil {
+			return nil, err
+		}
+		connTracker.close = closeTracker
+	}
+	connTracker.refs++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			connTracker.Lock()
+			defer connTracker.Unlock()
+			if connTracker.refs--; connTracker.refs == 0 {
+				connTracker.close()
+			}
+		})
+	}, nil
+}
+
+func attachConnStats() (func(), error) {
+	var objs connstatsObjects
+	opts := &ebpf.CollectionOptions{Maps: ebpf.MapOptions{PinPath: PinPath}}
+	if err := loadConnstatsObjects(&objs, opts); err != nil {
+		return nil, kernelFeature(fmt.Errorf("load connection tracker: %w", err))
+	}
+	var links []link.Link
+	closeAll := func() {
+		for _, l := range links {
+			l.Close()
+		}
+		objs.Close()
+	}
+	l, err := link.AttachTracing(link.TracingOptions{Program: objs.ConnAccept})
+	if err != nil {
+		closeAll()
+		return nil, kernelFeature(fmt.Errorf("attach connection tracker accept probe: %w", err))
+	}
+	links = append(links, l)
+	l, err = link.AttachCgroup(link.CgroupOptions{Path: cgroupRoot, Attach: ebpf.AttachCGroupSockOps, Program: objs.ConnSockops})
+	if err != nil {
+		closeAll()
+		return nil, fmt.Errorf("attach connection tracker sock_ops to %s: %w", cgroupRoot, err)
+	}
+	links = append(links, l)
+	return closeAll, nil
+}
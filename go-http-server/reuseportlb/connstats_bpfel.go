@@ -0,0 +1,139 @@
+// Code generated by bpf2go; DO NOT EDIT.
+//go:build 386 || amd64 || arm || arm64 || loong64 || mips64le || mipsle || ppc64le || riscv64
+
+package reuseportlb
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+
+	"github.com/cilium/ebpf"
+)
+
+type connstatsConnOwner struct {
+	Listener uint64
+	StartNs  uint64
+}
+
+type connstatsConnStats struct {
+	Open     uint64
+	Closed   uint64
+	LifeNs   uint64
+	BytesIn  uint64
+	BytesOut uint64
+	Retrans  uint64
+}
+
+// loadConnstats returns the embedded CollectionSpec for connstats.
+func loadConnstats() (*ebpf.CollectionSpec, error) {
+	reader := bytes.NewReader(_ConnstatsBytes)
+	spec, err := ebpf.LoadCollectionSpecFromReader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("can't load connstats: %w", err)
+	}
+
+	return spec, err
+}
+
+// loadConnstatsObjects loads connstats and converts it into a struct.
+//
+// The following types are suitable as obj argument:
+//
+//	*connstatsObjects
+//	*connstatsPrograms
+//	*connstatsMaps
+//
+// See ebpf.CollectionSpec.LoadAndAssign documentation for details.
+func loadConnstatsObjects(obj interface{}, opts *ebpf.CollectionOptions) error {
+	spec, err := loadConnstats()
+	if err != nil {
+		return err
+	}
+
+	return spec.LoadAndAssign(obj, opts)
+}
+
+// connstatsSpecs contains maps and programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type connstatsSpecs struct {
+	connstatsProgramSpecs
+	connstatsMapSpecs
+}
+
+// connstatsSpecs contains programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type connstatsProgramSpecs struct {
+	ConnAccept  *ebpf.ProgramSpec `ebpf:"conn_accept"`
+	ConnSockops *ebpf.ProgramSpec `ebpf:"conn_sockops"`
+}
+
+// connstatsMapSpecs contains maps before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type connstatsMapSpecs struct {
+	ConnOwner *ebpf.MapSpec `ebpf:"conn_owner"`
+	ConnStats *ebpf.MapSpec `ebpf:"conn_stats"`
+}
+
+// connstatsObjects contains all objects after they have been loaded into the kernel.
+//
+// It can be passed to loadConnstatsObjects or ebpf.CollectionSpec.LoadAndAssign.
+type connstatsObjects struct {
+	connstatsPrograms
+	connstatsMaps
+}
+
+func (o *connstatsObjects) Close() error {
+	return _ConnstatsClose(
+		&o.connstatsPrograms,
+		&o.connstatsMaps,
+	)
+}
+
+// connstatsMaps contains all maps after they have been loaded into the kernel.
+//
+// It can be passed to loadConnstatsObjects or ebpf.CollectionSpec.LoadAndAssign.
+type connstatsMaps struct {
+	ConnOwner *ebpf.Map `ebpf:"conn_owner"`
+	ConnStats *ebpf.Map `ebpf:"conn_stats"`
+}
+
+func (m *connstatsMaps) Close() error {
+	return _ConnstatsClose(
+		m.ConnOwner,
+		m.ConnStats,
+	)
+}
+
+// connstatsPrograms contains all programs after they have been loaded into the kernel.
+//
+// It can be passed to loadConnstatsObjects or ebpf.CollectionSpec.LoadAndAssign.
+type connstatsPrograms struct {
+	ConnAccept  *ebpf.Program `ebpf:"conn_accept"`
+	ConnSockops *ebpf.Program `ebpf:"conn_sockops"`
+}
+
+func (p *connstatsPrograms) Close() error {
+	return _ConnstatsClose(
+		p.ConnAccept,
+		p.ConnSockops,
+	)
+}
+
+func _ConnstatsClose(closers ...io.Closer) error {
+	for _, closer := range closers {
+		if err := closer.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Do not access this directly.
+//
+//go:embed connstats_bpfel.o
+var _ConnstatsBytes []byte
@@ -0,0 +1,214 @@
+package reuseportlb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/cilium/ebpf"
+)
+
+// DefaultMaxCPUs is the fewest entries cpu_util_map is created with, the
+// size it had before it was sized from the host, so that pins made by
+// earlier builds stay compatible on hosts with no more CPUs than that.
+const DefaultMaxCPUs = 64
+
+// maxOwnerCPUs is how many CPUs slot_owner records a slot owner's affinity
+// for, OWNER_CPUS in cpuutil.c: as many as sched_getaffinity reports
+// through unix.CPUSet. Owners are judged by their CPUs below it only, so
+// cpu_util_map is never sized past it.
+const maxOwnerCPUs = 1024
+
+// maxCPUNumber bounds the core numbers ParseCPUList accepts: it is the most
+// CPUs a kernel can be built for (NR_CPUS with MAXSMP), and keeps a range
+// such as "0-4294967295" from expanding into billions of cores.
+const maxCPUNumber = 8192
+
+// onlineCPUsPath lists the CPUs currently online, as a range list.
+const onlineCPUsPath = "/sys/devices/system/cpu/online"
+
+// isolatedCPUsPaths list the CPUs taken out of general scheduling with
+// isolcpus= and those running tickless with nohz_full=. nohz_full is
+// missing on kernels booted without it.
+var isolatedCPUsPaths = []string{"/sys/devices/system/cpu/isolated", "/sys/devices/system/cpu/nohz_full"}
+
+// HousekeepingAuto, given for the housekeeping CPUs, takes them from the
+// kernel command line: every online CPU not isolated with isolcpus= or
+// nohz_full=.
+const HousekeepingAuto = "auto"
+
+func init() {
+	if n, err := ebpf.PossibleCPU(); err == nil && n > DefaultMaxCPUs {
+		setCPUUtilEntries(uint32(min(n, maxOwnerCPUs)))
+	}
+}
+
+// MaxCPUs returns how many cores cpu_util_map has room for: cores are
+// indexed by number, so this is one more than the highest core that can be
+// published. By default it covers every possible CPU of the host, up to
+// the 1024 whose slot owners slot_owner can tell apart.
+func MaxCPUs() int {
+	return int(mapLayouts[CPUUtilMap].MaxEntries)
+}
+
+// SetMaxCPUs sizes cpu_util_map for n cores instead of the host's possible
+// CPUs. It must be called before any map is opened, and every process
+// sharing the pins has to agree on n: a pinned map of another size is
+// reported as a layout mismatch. slot_owner is a bitmap of 1024 CPUs, so n
+// cannot be more than that: utilization of a core past it could not be
+// attributed to any slot.
+func SetMaxCPUs(n int) error {
+	if n <= 0 {
+		return fmt.Errorf("invalid CPU map size %d: must be positive", n)
+	}
+	if n > maxOwnerCPUs {
+		return fmt.Errorf("invalid CPU map size %d: slot owners' affinity is recorded for at most %d CPUs", n, maxOwnerCPUs)
+	}
+	setCPUUtilEntries(uint32(n))
+	return nil
+}
+
+func setCPUUtilEntries(n uint32) {
+	spec := mapLayouts[CPUUtilMap]
+	spec.MaxEntries = n
+	mapLayouts[CPUUtilMap] = spec
+}
+
+// ParseCPUList parses a list of CPU numbers and ranges, separated by spaces
+// or commas, as taken by the -cpus flags: "0 1 2 3", "0-7,16-23" and the
+// kernel's own /sys/devices/system/cpu/online format all work. Cores are
+// returned in the order given, each once.
+func ParseCPUList(s string) ([]int, error) {
+	cpus := []int{}
+	seen := make(map[int]bool)
+	add := func(core int) {
+		if !seen[core] {
+			seen[core] = true
+			cpus = append(cpus, core)
+		}
+	}
+	fields := strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' || r == '\n' })
+	for _, f := range fields {
+		lo, hi, isRange := strings.Cut(f, "-")
+		first, err := strconv.Atoi(lo)
+		if err != nil || first < 0 || first >= maxCPUNumber {
+			return nil, fmt.Errorf("invalid CPU core number %q", f)
+		}
+		if !isRange {
+			add(first)
+			continue
+		}
+		last, err := strconv.Atoi(hi)
+		if err != nil || last < first || last >= maxCPUNumber {
+			return nil, fmt.Errorf("invalid CPU range %q", f)
+		}
+		for core := first; core <= last; core++ {
+			add(core)
+		}
+	}
+	return cpus, nil
+}
+
+// OnlineCPUs returns the CPUs that are currently online, in ascending order.
+func OnlineCPUs() ([]int, error) {
+	data, err := os.ReadFile(onlineCPUsPath)
+	if err != nil {
+		return nil, fmt.Errorf("read online CPUs: %w", err)
+	}
+	cpus, err := ParseCPUList(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", onlineCPUsPath, err)
+	}
+	return cpus, nil
+}
+
+// monitoredCPUs returns the cores a collector configured with cpus should
+// publish: cpus itself, or every online core that fits cpu_util_map when
+// cpus is empty.
+func monitoredCPUs(cpus []int) ([]int, error) {
+	if len(cpus) > 0 {
+		return cpus, nil
+	}
+	online, err := OnlineCPUs()
+	if err != nil {
+		return nil, err
+	}
+	out := online[:0]
+	for _, core := range online {
+		if core < MaxCPUs() {
+			out = append(out, core)
+		}
+	}
+	return out, nil
+}
+
+// IsolatedCPUs returns the CPUs the kernel was told to keep for
+// applications, with isolcpus= or nohz_full=, in ascending order.
+func IsolatedCPUs() ([]int, error) {
+	var out []int
+	for _, path := range isolatedCPUsPaths {
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read isolated CPUs: %w", err)
+		}
+		cpus, err := ParseCPUList(strings.TrimSpace(string(data)))
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+		out = append(out, cpus...)
+	}
+	slices.Sort(out)
+	return slices.Compact(out), nil
+}
+
+// ParseHousekeepingCPUs parses the housekeeping CPUs as taken by the
+// -housekeeping-cpus flags: a CPU list, or HousekeepingAuto to read them
+// off the kernel command line. Empty means there is no split.
+func ParseHousekeepingCPUs(s string) ([]int, error) {
+	if strings.TrimSpace(s) != HousekeepingAuto {
+		return ParseCPUList(s)
+	}
+	isolated, err := IsolatedCPUs()
+	if err != nil {
+		return nil, err
+	}
+	if len(isolated) == 0 {
+		return nil, errors.New("the kernel isolates no CPUs (no isolcpus= or nohz_full=): give the housekeeping CPUs explicitly")
+	}
+	online, err := OnlineCPUs()
+	if err != nil {
+		return nil, err
+	}
+	var out []int
+	for _, core := range online {
+		if !slices.Contains(isolated, core) {
+			out = append(out, core)
+		}
+	}
+	return out, nil
+}
+
+// applicationCPUs returns the CPUs of cpus that are not housekeeping ones,
+// or cpus itself if that leaves none: an owner confined to housekeeping
+// CPUs is still judged by them rather than not at all.
+func applicationCPUs(cpus []int, housekeeping map[int]bool) []int {
+	if len(housekeeping) == 0 {
+		return cpus
+	}
+	var out []int
+	for _, core := range cpus {
+		if !housekeeping[core] {
+			out = append(out, core)
+		}
+	}
+	if len(out) == 0 {
+		return cpus
+	}
+	return out
+}
@@ -0,0 +1,276 @@
+package reuseportlb
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Where the collector takes CPU utilization from.
+const (
+	// CPUSourceStat is /proc/stat: cheap and always there, but counted in
+	// scheduler ticks (4-10ms), so a 50ms sample is only 5 to 12 ticks
+	// and flickers by a tick's worth from one sample to the next.
+	CPUSourceStat = "stat"
+	// CPUSourceSchedstat is the run time /proc/schedstat keeps per CPU in
+	// nanoseconds, which has no tick granularity to jitter by. It needs a
+	// kernel built with CONFIG_SCHEDSTATS.
+	CPUSourceSchedstat = "schedstat"
+	// CPUSourcePSI takes each slot's utilization from the CPU pressure of
+	// its owner's cgroup (cpu.pressure, "some"): the share of the time the
+	// instance had a runnable task waiting for a CPU, which rises only once
+	// its CPUs are contended rather than whenever they are busy. Per-core
+	// utilization comes from schedstat where the kernel has it and from
+	// /proc/stat otherwise. It needs cgroup v2 with PSI and the servers in
+	// cgroups of their own (Group.JoinCgroup).
+	CPUSourcePSI = "psi"
+)
+
+// CPUSources are the valid CPUSource settings.
+var CPUSources = []string{CPUSourceStat, CPUSourceSchedstat, CPUSourcePSI}
+
+// cpuSampler reports how busy each CPU was, in percent, since it was last
+// asked. The first call only takes the baseline and returns nil.
+type cpuSampler interface {
+	sample() (map[int]float64, error)
+}
+
+// newCPUSampler returns the per-core sampler for source.
+func newCPUSampler(source string) (cpuSampler, error) {
+	switch source {
+	case CPUSourceStat:
+		return &statSampler{}, nil
+	case CPUSourceSchedstat:
+		if _, err := readSchedstat(); err != nil {
+			return nil, fmt.Errorf("read /proc/schedstat (needs CONFIG_SCHEDSTATS): %w", err)
+		}
+		return &schedstatSampler{}, nil
+	case CPUSourcePSI:
+		if _, err := readSchedstat(); err == nil {
+			return &schedstatSampler{}, nil
+		}
+		return &statSampler{}, nil
+	}
+	return nil, fmt.Errorf("invalid CPU source %q: must be one of %s", source, strings.Join(CPUSources, ", "))
+}
+
+// statSampler reads /proc/stat.
+type statSampler struct {
+	prev map[int]CPUStat
+}
+
+func (s *statSampler) sample() (map[int]float64, error) {
+	curr, err := readCPUStat()
+	if err != nil {
+		return nil, fmt.Errorf("read /proc/stat: %w", err)
+	}
+	prev := s.prev
+	s.prev = curr
+	if prev == nil {
+		return nil, nil
+	}
+	out := make(map[int]float64, len(curr))
+	for cpu, c := range curr {
+		if p, ok := prev[cpu]; ok {
+			out[cpu] = calculateUtilization(p, c)
+		}
+	}
+	return out, nil
+}
+
+// schedstatSampler reads /proc/schedstat.
+type schedstatSampler struct {
+	prev   map[int]time.Duration
+	prevAt time.Time
+}
+
+func (s *schedstatSampler) sample() (map[int]float64, error) {
+	curr, err := readSchedstat()
+	if err != nil {
+		return nil, fmt.Errorf("read /proc/schedstat: %w", err)
+	}
+	now := time.Now()
+	prev, elapsed := s.prev, now.Sub(s.prevAt)
+	s.prev, s.prevAt = curr, now
+	if prev == nil || elapsed <= 0 {
+		return nil, nil
+	}
+	out := make(map[int]float64, len(curr))
+	for cpu, run := range curr {
+		if p, ok := prev[cpu]; ok && run >= p {
+			out[cpu] = min(float64(run-p)/float64(elapsed)*100, 100)
+		}
+	}
+	return out, nil
+}
+
+// readSchedstat returns the time tasks have spent running on each CPU, the
+// seventh field of the cpuN lines of /proc/schedstat.
+func readSchedstat() (map[int]time.Duration, error) {
+	f, err := os.Open("/proc/schedstat")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	out := make(map[int]time.Duration)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 8 || !strings.HasPrefix(fields[0], "cpu") {
+			continue
+		}
+		cpu, err := strconv.Atoi(fields[0][3:])
+		if err != nil {
+			continue
+		}
+		run, err := strconv.ParseUint(fields[7], 10, 64)
+		if err != nil {
+			continue
+		}
+		out[cpu] = time.Duration(run)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(out) == 0 {
+		return nil, errors.New("no per-CPU lines")
+	}
+	return out, nil
+}
+
+// CPUSourceReport is how one source's readings behaved over a comparison.
+type CPUSourceReport struct {
+	Source string `json:"source"`
+	// Samples is the number of readings per CPU.
+	Samples int `json:"samples"`
+	// Mean is the mean utilization, in percent, over the CPUs compared.
+	Mean float64 `json:"mean"`
+	// Stdev is the standard deviation of the readings around each CPU's
+	// mean, averaged over the CPUs.
+	Stdev float64 `json:"stdev"`
+	// Jitter is the mean absolute change from one reading to the next:
+	// what a reading moves by when the load does not.
+	Jitter float64 `json:"jitter"`
+	// Err is why the source could not be read, if it could not.
+	Err string `json:"err,omitempty"`
+}
+
+// CompareCPUSources samples every per-core source on cpus (every online
+// core if empty) every interval for d, side by side, and reports how noisy
+// each one was. PSI has no per-core reading, so its row is the host's CPU
+// pressure (/proc/pressure/cpu).
+func CompareCPUSources(ctx context.Context, cpus []int, interval, d time.Duration) ([]CPUSourceReport, error) {
+	cpus, err := monitoredCPUs(cpus)
+	if err != nil {
+		return nil, err
+	}
+	sources := []string{CPUSourceStat, CPUSourceSchedstat}
+	samplers := make(map[string]cpuSampler)
+	readings := make(map[string]map[int][]float64)
+	reports := make(map[string]*CPUSourceReport)
+	for _, src := range sources {
+		reports[src] = &CPUSourceReport{Source: src}
+		s, err := newCPUSampler(src)
+		if err != nil {
+			reports[src].Err = err.Error()
+			continue
+		}
+		samplers[src] = s
+		readings[src] = make(map[int][]float64)
+	}
+	psi := &CPUSourceReport{Source: CPUSourcePSI}
+	var psiReadings []float64
+	host := stallMeter{path: HostCPUPressure}
+	if _, err := ReadPSI(HostCPUPressure); err != nil {
+		psi.Err = err.Error()
+	}
+	host.read()
+
+	for _, s := range samplers {
+		if _, err := s.sample(); err != nil {
+			return nil, err
+		}
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	deadline := time.After(d)
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-deadline:
+			break loop
+		case <-ticker.C:
+		}
+		for src, s := range samplers {
+			util, err := s.sample()
+			if err != nil {
+				return nil, err
+			}
+			for _, cpu := range cpus {
+				if u, ok := util[cpu]; ok {
+					readings[src][cpu] = append(readings[src][cpu], u)
+				}
+			}
+		}
+		if pct, ok := host.read(); ok {
+			psiReadings = append(psiReadings, pct)
+		}
+	}
+
+	var out []CPUSourceReport
+	for _, src := range sources {
+		r := reports[src]
+		var series [][]float64
+		for _, xs := range readings[src] {
+			series = append(series, xs)
+		}
+		summarizeReadings(r, series)
+		out = append(out, *r)
+	}
+	if psi.Err == "" {
+		summarizeReadings(psi, [][]float64{psiReadings})
+	}
+	out = append(out, *psi)
+	return out, nil
+}
+
+// summarizeReadings fills in r from one series of readings per CPU.
+func summarizeReadings(r *CPUSourceReport, series [][]float64) {
+	sort.Slice(series, func(i, j int) bool { return len(series[i]) > len(series[j]) })
+	var n int
+	for _, xs := range series {
+		if len(xs) < 2 {
+			continue
+		}
+		var mean, sq, jitter float64
+		for i, x := range xs {
+			mean += x
+			if i > 0 {
+				jitter += math.Abs(x - xs[i-1])
+			}
+		}
+		mean /= float64(len(xs))
+		for _, x := range xs {
+			sq += (x - mean) * (x - mean)
+		}
+		r.Mean += mean
+		r.Stdev += math.Sqrt(sq / float64(len(xs)))
+		r.Jitter += jitter / float64(len(xs)-1)
+		r.Samples = max(r.Samples, len(xs))
+		n++
+	}
+	if n > 0 {
+		r.Mean /= float64(n)
+		r.Stdev /= float64(n)
+		r.Jitter /= float64(n)
+	}
+}
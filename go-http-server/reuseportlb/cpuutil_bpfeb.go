@@ -1,7 +1,7 @@
 // Code generated by bpf2go; DO NOT EDIT.
 //go:build mips || mips64 || ppc64 || s390x
 
-package main
+package reuseportlb
 
 import (
 	"bytes"
@@ -12,6 +12,51 @@ import (
 	"github.com/cilium/ebpf"
 )
 
+type cpuutilRatelimitCfg struct {
+	Enabled     uint32
+	Action      uint32
+	PenaltySlot uint32
+	MaxConns    uint32
+	WindowNs    uint64
+}
+
+type cpuutilShadowState struct {
+	Phase     uint32
+	Candidate uint32
+}
+
+type cpuutilSlotBucket struct {
+	Rate      uint64
+	Burst     uint64
+	Action    uint32
+	Pad       uint32
+	Tokens    uint64
+	LastNs    uint64
+	Throttled uint64
+}
+
+type cpuutilSlotOverride struct {
+	Slot uint32
+	Hits uint32
+}
+
+type cpuutilSlotOwner struct {
+	Pid   uint32
+	Ncpus uint32
+	Cpus  [16]uint64
+}
+
+type cpuutilSlotWarmup struct {
+	StartNs  uint64
+	WindowNs uint64
+}
+
+type cpuutilSrcRate struct {
+	WindowStart uint64
+	Count       uint32
+	Limited     uint32
+}
+
 // loadCpuutil returns the embedded CollectionSpec for cpuutil.
 func loadCpuutil() (*ebpf.CollectionSpec, error) {
 	reader := bytes.NewReader(_CpuutilBytes)
@@ -60,7 +105,18 @@ type cpuutilProgramSpecs struct {
 //
 // It can be passed ebpf.CollectionSpec.Assign.
 type cpuutilMapSpecs struct {
-	CpuUtilMap          *ebpf.MapSpec `ebpf:"cpu_util_map"`
+	RatelimitCfg        *ebpf.MapSpec `ebpf:"ratelimit_cfg"`
+	ShadowEvents        *ebpf.MapSpec `ebpf:"shadow_events"`
+	ShadowProgs         *ebpf.MapSpec `ebpf:"shadow_progs"`
+	ShadowScratch       *ebpf.MapSpec `ebpf:"shadow_scratch"`
+	SlotBucket          *ebpf.MapSpec `ebpf:"slot_bucket"`
+	SlotDropping        *ebpf.MapSpec `ebpf:"slot_dropping"`
+	SlotOverride        *ebpf.MapSpec `ebpf:"slot_override"`
+	SlotOwner           *ebpf.MapSpec `ebpf:"slot_owner"`
+	SlotSelected        *ebpf.MapSpec `ebpf:"slot_selected"`
+	SlotUtil            *ebpf.MapSpec `ebpf:"slot_util"`
+	SlotWarmup          *ebpf.MapSpec `ebpf:"slot_warmup"`
+	SrcRate             *ebpf.MapSpec `ebpf:"src_rate"`
 	TcpBalancingTargets *ebpf.MapSpec `ebpf:"tcp_balancing_targets"`
 }
 
@@ -83,13 +139,35 @@ func (o *cpuutilObjects) Close() error {
 //
 // It can be passed to loadCpuutilObjects or ebpf.CollectionSpec.LoadAndAssign.
 type cpuutilMaps struct {
-	CpuUtilMap          *ebpf.Map `ebpf:"cpu_util_map"`
+	RatelimitCfg        *ebpf.Map `ebpf:"ratelimit_cfg"`
+	ShadowEvents        *ebpf.Map `ebpf:"shadow_events"`
+	ShadowProgs         *ebpf.Map `ebpf:"shadow_progs"`
+	ShadowScratch       *ebpf.Map `ebpf:"shadow_scratch"`
+	SlotBucket          *ebpf.Map `ebpf:"slot_bucket"`
+	SlotDropping        *ebpf.Map `ebpf:"slot_dropping"`
+	SlotOverride        *ebpf.Map `ebpf:"slot_override"`
+	SlotOwner           *ebpf.Map `ebpf:"slot_owner"`
+	SlotSelected        *ebpf.Map `ebpf:"slot_selected"`
+	SlotUtil            *ebpf.Map `ebpf:"slot_util"`
+	SlotWarmup          *ebpf.Map `ebpf:"slot_warmup"`
+	SrcRate             *ebpf.Map `ebpf:"src_rate"`
 	TcpBalancingTargets *ebpf.Map `ebpf:"tcp_balancing_targets"`
 }
 
 func (m *cpuutilMaps) Close() error {
 	return _CpuutilClose(
-		m.CpuUtilMap,
+		m.RatelimitCfg,
+		m.ShadowEvents,
+		m.ShadowProgs,
+		m.ShadowScratch,
+		m.SlotBucket,
+		m.SlotDropping,
+		m.SlotOverride,
+		m.SlotOwner,
+		m.SlotSelected,
+		m.SlotUtil,
+		m.SlotWarmup,
+		m.SrcRate,
 		m.TcpBalancingTargets,
 	)
 }
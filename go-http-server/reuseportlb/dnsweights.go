@@ -0,0 +1,299 @@
+package reuseportlb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DefaultDNSTTL is the TTL of the records a DNSServer answers with: short,
+// so clients follow the weights as the load moves.
+const DefaultDNSTTL = 5 * time.Second
+
+// WeightedTarget is one host serving a group, with the share of new
+// clients DNS should send it.
+type WeightedTarget struct {
+	// Host is the name the host gossips under.
+	Host string `json:"host"`
+	// Addr is the host's address, if it advertises one; a host advertising
+	// a DNS name instead has it in Name, and is left out of A and AAAA
+	// answers.
+	Addr   netip.Addr `json:"addr"`
+	Name   string     `json:"name,omitempty"`
+	Weight uint16     `json:"weight"`
+}
+
+// HostWeights turns the federation's view into weights per group: each
+// host gets its listener count times the room left in their accept queues,
+// scaled by 100, so a host with twice the instances takes twice the
+// clients and a host whose queues fill up gets fewer. Hosts without a
+// listener in the group get none; a host that has one always gets at
+// least weight 1, so it is never dropped from the answers altogether.
+func HostWeights(hosts []HostLoad) map[string][]WeightedTarget {
+	out := make(map[string][]WeightedTarget)
+	for _, h := range hosts {
+		name := h.Addr
+		if host, _, err := net.SplitHostPort(h.Addr); err == nil {
+			name = host
+		}
+		addr, err := netip.ParseAddr(name)
+		if err == nil {
+			name = ""
+		}
+		for _, g := range h.Groups {
+			if g.Listeners == 0 {
+				continue
+			}
+			w := math.Round(float64(g.Listeners) * (1 - min(g.QueueFill, 1)) * 100)
+			out[g.Group] = append(out[g.Group], WeightedTarget{
+				Host:   h.Host,
+				Name:   name,
+				Addr:   addr.Unmap(),
+				Weight: uint16(max(1, min(w, math.MaxUint16))),
+			})
+		}
+	}
+	for _, targets := range out {
+		sort.Slice(targets, func(i, j int) bool { return targets[i].Host < targets[j].Host })
+	}
+	return out
+}
+
+// DNSConfig sets up a DNSServer.
+type DNSConfig struct {
+	// Domain is the zone answered for. Group g is g.<domain> for A and
+	// AAAA, and _<service>._tcp.g.<domain> for SRV; SRV answers point at a
+	// host advertising an address as <host>.hosts.<domain>.
+	Domain  string
+	Service string
+	// Port is the port SRV answers point clients at.
+	Port uint16
+	TTL  time.Duration
+	// Weights returns the current weights per group name.
+	Weights func() map[string][]WeightedTarget
+}
+
+// DNSServer publishes the weights the balancer works out to clients that
+// pick a host themselves: an SRV query gets every host with its weight,
+// for clients that honour SRV weights, and an A or AAAA query gets one
+// host's address, drawn at random in proportion to the weights, so that
+// plain resolvers spread their clients the same way over many queries.
+// It answers over UDP only, for the zone it is given and nothing else.
+type DNSServer struct {
+	cfg  DNSConfig
+	conn net.PacketConn
+	done chan struct{}
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+// ServeDNS answers queries on the UDP address addr until Close.
+func ServeDNS(addr string, cfg DNSConfig) (*DNSServer, error) {
+	if cfg.Domain == "" || cfg.Weights == nil {
+		return nil, errors.New("DNS publication needs a domain and a source of weights")
+	}
+	if cfg.Service == "" {
+		cfg.Service = "http"
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = DefaultDNSTTL
+	}
+	cfg.Domain = strings.ToLower(strings.TrimSuffix(cfg.Domain, ".")) + "."
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &DNSServer{cfg: cfg, conn: conn, done: make(chan struct{}), rng: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	go s.serve()
+	slog.Info("Publishing weights over DNS", "addr", conn.LocalAddr().String(), "domain", cfg.Domain)
+	return s, nil
+}
+
+// Close stops answering.
+func (s *DNSServer) Close() error {
+	err := s.conn.Close()
+	<-s.done
+	return err
+}
+
+func (s *DNSServer) serve() {
+	defer close(s.done)
+	buf := make([]byte, 512)
+	for {
+		n, peer, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				slog.Error("DNS server stopped", "err", err)
+			}
+			return
+		}
+		resp, err := s.answer(buf[:n])
+		if err != nil {
+			slog.Debug("Ignoring DNS query", "peer", peer.String(), "err", err)
+			continue
+		}
+		s.conn.WriteTo(resp, peer)
+	}
+}
+
+// answer builds the response to one query message.
+func (s *DNSServer) answer(query []byte) ([]byte, error) {
+	var p dnsmessage.Parser
+	hdr, err := p.Start(query)
+	if err != nil {
+		return nil, err
+	}
+	if hdr.Response {
+		return nil, errors.New("not a query")
+	}
+	q, err := p.Question()
+	if err != nil {
+		return nil, err
+	}
+
+	rh := dnsmessage.Header{ID: hdr.ID, Response: true, Authoritative: true, RecursionDesired: hdr.RecursionDesired}
+	targets, kind, ok := s.lookup(q.Name.String())
+	switch {
+	case hdr.OpCode != 0:
+		rh.RCode = dnsmessage.RCodeNotImplemented
+	case q.Class != dnsmessage.ClassINET || !ok:
+		rh.RCode = dnsmessage.RCodeNameError
+	}
+
+	b := dnsmessage.NewBuilder(make([]byte, 0, 512), rh)
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(q); err != nil {
+		return nil, err
+	}
+	if err := b.StartAnswers(); err != nil {
+		return nil, err
+	}
+	if rh.RCode == dnsmessage.RCodeSuccess {
+		if err := s.records(&b, q, kind, targets); err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish()
+}
+
+// Kinds of names a DNSServer answers for.
+const (
+	nameGroup = iota // <group>.<domain>: one host, drawn by weight
+	nameSRV          // _<service>._tcp.<group>.<domain>: every host
+	nameHost         // <host>.hosts.<domain>: that host's address
+)
+
+// lookup returns the targets a queried name stands for, and its kind.
+func (s *DNSServer) lookup(name string) ([]WeightedTarget, int, bool) {
+	rest, found := strings.CutSuffix(strings.ToLower(name), "."+s.cfg.Domain)
+	if !found || rest == "" {
+		return nil, 0, false
+	}
+	weights := s.cfg.Weights()
+	if host, found := strings.CutSuffix(rest, ".hosts"); found {
+		for _, targets := range weights {
+			for _, t := range targets {
+				if t.Addr.IsValid() && hostLabel(t.Host) == host {
+					return []WeightedTarget{t}, nameHost, true
+				}
+			}
+		}
+		return nil, 0, false
+	}
+	kind := nameGroup
+	if g, found := strings.CutPrefix(rest, "_"+s.cfg.Service+"._tcp."); found {
+		rest, kind = g, nameSRV
+	}
+	targets, ok := weights[rest]
+	return targets, kind, ok
+}
+
+// hostLabel makes a host's name a single DNS label.
+func hostLabel(host string) string {
+	return strings.ToLower(strings.ReplaceAll(host, ".", "-"))
+}
+
+func (s *DNSServer) records(b *dnsmessage.Builder, q dnsmessage.Question, kind int, targets []WeightedTarget) error {
+	ttl := uint32(s.cfg.TTL / time.Second)
+	rh := dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: ttl}
+	if kind == nameSRV {
+		if q.Type != dnsmessage.TypeSRV {
+			return nil
+		}
+		for _, t := range targets {
+			name := strings.TrimSuffix(t.Name, ".") + "."
+			if t.Addr.IsValid() {
+				name = hostLabel(t.Host) + ".hosts." + s.cfg.Domain
+			}
+			target, err := dnsmessage.NewName(name)
+			if err != nil {
+				return err
+			}
+			if err := b.SRVResource(rh, dnsmessage.SRVResource{Weight: t.Weight, Port: s.cfg.Port, Target: target}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var candidates []WeightedTarget
+	for _, t := range targets {
+		if (q.Type == dnsmessage.TypeA && t.Addr.Is4()) || (q.Type == dnsmessage.TypeAAAA && t.Addr.Is6()) {
+			candidates = append(candidates, t)
+		}
+	}
+	t, ok := s.draw(candidates)
+	if !ok {
+		return nil
+	}
+	if q.Type == dnsmessage.TypeA {
+		return b.AResource(rh, dnsmessage.AResource{A: t.Addr.As4()})
+	}
+	return b.AAAAResource(rh, dnsmessage.AAAAResource{AAAA: t.Addr.As16()})
+}
+
+// draw picks one target in proportion to its weight.
+func (s *DNSServer) draw(targets []WeightedTarget) (WeightedTarget, bool) {
+	var total int
+	for _, t := range targets {
+		total += int(t.Weight)
+	}
+	if total == 0 {
+		return WeightedTarget{}, false
+	}
+	s.mu.Lock()
+	n := s.rng.Intn(total)
+	s.mu.Unlock()
+	for _, t := range targets {
+		if n < int(t.Weight) {
+			return t, true
+		}
+		n -= int(t.Weight)
+	}
+	return targets[len(targets)-1], true
+}
+
+// ServeWeights serves the weights per group as JSON, for scripts that push
+// them to an external DNS provider instead.
+func (s *DNSServer) ServeWeights(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.cfg.Weights()); err != nil {
+		http.Error(w, fmt.Sprintf("encode weights: %v", err), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,110 @@
+package reuseportlb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/cilium/ebpf/btf"
+	"github.com/cilium/ebpf/link"
+)
+
+// DropCounter counts the SYNs for one port that the kernel dropped, by drop
+// reason. A connection the group refused (no slot to select, or the selected
+// listener gone) shows up as NO_SOCKET; the client got a RST for it.
+type DropCounter struct {
+	objs  dropsObjects
+	link  link.Link
+	names map[uint32]string
+}
+
+// StartDropCounter attaches the skb:kfree_skb tracepoint counting dropped
+// SYNs to port. It needs drop reasons in the tracepoint (Linux 5.17); Close
+// detaches it.
+func StartDropCounter(port uint16) (*DropCounter, error) {
+	spec, err := loadDrops()
+	if err != nil {
+		return nil, err
+	}
+	// The program compares against the TCP header as it is on the wire.
+	var wire [2]byte
+	binary.BigEndian.PutUint16(wire[:], port)
+	if err := spec.RewriteConstants(map[string]interface{}{"drops_port": binary.NativeEndian.Uint16(wire[:])}); err != nil {
+		return nil, fmt.Errorf("set drop counter port: %w", err)
+	}
+	c := &DropCounter{names: dropReasonNames()}
+	if err := spec.LoadAndAssign(&c.objs, nil); err != nil {
+		return nil, kernelFeature(fmt.Errorf("load drop counter: %w", err))
+	}
+	c.link, err = link.AttachTracing(link.TracingOptions{Program: c.objs.DropsKfreeSkb})
+	if err != nil {
+		c.objs.Close()
+		return nil, kernelFeature(fmt.Errorf("attach drop counter: %w", err))
+	}
+	return c, nil
+}
+
+// Counts returns the SYNs dropped so far by reason, named as in the kernel
+// without the SKB_DROP_REASON_ prefix (NO_SOCKET, TCP_LISTEN_OVERFLOW, ...).
+// Reasons with no drops are left out.
+func (c *DropCounter) Counts() (map[string]uint64, error) {
+	out := make(map[string]uint64)
+	var reason uint32
+	var perCPU []uint64
+	iter := c.objs.SynDrops.Iterate()
+	for iter.Next(&reason, &perCPU) {
+		var n uint64
+		for _, v := range perCPU {
+			n += v
+		}
+		if n == 0 {
+			continue
+		}
+		name, ok := c.names[reason]
+		if !ok {
+			name = fmt.Sprintf("reason_%d", reason)
+		}
+		out[name] += n
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("iterate syn_drops: %w", err)
+	}
+	return out, nil
+}
+
+// Close detaches the tracepoint.
+func (c *DropCounter) Close() error {
+	return errors.Join(c.link.Close(), c.objs.Close())
+}
+
+// DropsSince returns how many more drops of each reason now has than
+// before, leaving out reasons that did not grow.
+func DropsSince(before, now map[string]uint64) map[string]uint64 {
+	out := make(map[string]uint64)
+	for reason, n := range now {
+		if d := n - before[reason]; d > 0 {
+			out[reason] = d
+		}
+	}
+	return out
+}
+
+// dropReasonNames reads enum skb_drop_reason from the kernel's BTF, since
+// its values differ between kernels. Without BTF reasons are reported by
+// number.
+func dropReasonNames() map[uint32]string {
+	names := make(map[uint32]string)
+	spec, err := btf.LoadKernelSpec()
+	if err != nil {
+		return names
+	}
+	var enum *btf.Enum
+	if err := spec.TypeByName("skb_drop_reason", &enum); err != nil {
+		return names
+	}
+	for _, v := range enum.Values {
+		names[uint32(v.Value)] = strings.TrimPrefix(strings.TrimPrefix(v.Name, "SKB_DROP_REASON_"), "SKB_")
+	}
+	return names
+}
@@ -0,0 +1,119 @@
+// Code generated by bpf2go; DO NOT EDIT.
+//go:build 386 || amd64 || arm || arm64 || loong64 || mips64le || mipsle || ppc64le || riscv64
+
+package reuseportlb
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+
+	"github.com/cilium/ebpf"
+)
+
+// loadDrops returns the embedded CollectionSpec for drops.
+func loadDrops() (*ebpf.CollectionSpec, error) {
+	reader := bytes.NewReader(_DropsBytes)
+	spec, err := ebpf.LoadCollectionSpecFromReader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("can't load drops: %w", err)
+	}
+
+	return spec, err
+}
+
+// loadDropsObjects loads drops and converts it into a struct.
+//
+// The following types are suitable as obj argument:
+//
+//	*dropsObjects
+//	*dropsPrograms
+//	*dropsMaps
+//
+// See ebpf.CollectionSpec.LoadAndAssign documentation for details.
+func loadDropsObjects(obj interface{}, opts *ebpf.CollectionOptions) error {
+	spec, err := loadDrops()
+	if err != nil {
+		return err
+	}
+
+	return spec.LoadAndAssign(obj, opts)
+}
+
+// dropsSpecs contains maps and programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type dropsSpecs struct {
+	dropsProgramSpecs
+	dropsMapSpecs
+}
+
+// dropsSpecs contains programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type dropsProgramSpecs struct {
+	DropsKfreeSkb *ebpf.ProgramSpec `ebpf:"drops_kfree_skb"`
+}
+
+// dropsMapSpecs contains maps before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type dropsMapSpecs struct {
+	SynDrops *ebpf.MapSpec `ebpf:"syn_drops"`
+}
+
+// dropsObjects contains all objects after they have been loaded into the kernel.
+//
+// It can be passed to loadDropsObjects or ebpf.CollectionSpec.LoadAndAssign.
+type dropsObjects struct {
+	dropsPrograms
+	dropsMaps
+}
+
+func (o *dropsObjects) Close() error {
+	return _DropsClose(
+		&o.dropsPrograms,
+		&o.dropsMaps,
+	)
+}
+
+// dropsMaps contains all maps after they have been loaded into the kernel.
+//
+// It can be passed to loadDropsObjects or ebpf.CollectionSpec.LoadAndAssign.
+type dropsMaps struct {
+	SynDrops *ebpf.Map `ebpf:"syn_drops"`
+}
+
+func (m *dropsMaps) Close() error {
+	return _DropsClose(
+		m.SynDrops,
+	)
+}
+
+// dropsPrograms contains all programs after they have been loaded into the kernel.
+//
+// It can be passed to loadDropsObjects or ebpf.CollectionSpec.LoadAndAssign.
+type dropsPrograms struct {
+	DropsKfreeSkb *ebpf.Program `ebpf:"drops_kfree_skb"`
+}
+
+func (p *dropsPrograms) Close() error {
+	return _DropsClose(
+		p.DropsKfreeSkb,
+	)
+}
+
+func _DropsClose(closers ...io.Closer) error {
+	for _, closer := range closers {
+		if err := closer.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Do not access this directly.
+//
+//go:embed drops_bpfel.o
+var _DropsBytes []byte
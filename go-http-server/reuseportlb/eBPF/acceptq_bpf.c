@@ -0,0 +1,16 @@
+// SPDX-License-Identifier: GPL-2.0
+// +build ignore
+#include "vmlinux.h"
+#include "arch.h"
+#include <bpf/bpf_helpers.h>
+#include <bpf/bpf_core_read.h>
+#include <bpf/bpf_tracing.h>
+#include "acceptq_common.h"
+
+char LICENSE[] SEC("license") = "GPL";
+
+SEC("kprobe/tcp_v4_syn_recv_sock")
+int BPF_KPROBE(on_syn_recv, struct sock *sk)
+{
+    return acceptq_record(sk);
+}
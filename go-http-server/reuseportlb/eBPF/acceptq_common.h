@@ -0,0 +1,87 @@
+/* Shared by the kprobe (acceptq_bpf.c) and fentry (acceptq_fentry.c)
+ * flavors of the accept queue tracker; only the hook differs. */
+#ifndef __ACCEPTQ_COMMON_H
+#define __ACCEPTQ_COMMON_H
+
+struct acceptq {
+    __u32 curr;
+    __u32 max;
+    __u32 cpu;
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 1024);
+    __type(key, __u64);
+    __type(value, struct acceptq);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} acceptq_map SEC(".maps");
+
+/*
+ * Change notifications for event-driven collectors: an acceptq_event is
+ * published whenever a listener's backlog differs from what acceptq_map
+ * held, so readers need not poll the map.
+ */
+struct acceptq_event {
+    __u64 cookie;
+    __u32 curr;
+    __u32 max;
+    __u32 cpu;
+    __u32 pad;
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_RINGBUF);
+    __uint(max_entries, 1 << 18);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} acceptq_events SEC(".maps");
+
+/* Record the backlog of listener sk, which is completing a handshake. */
+static __always_inline int acceptq_record(const struct sock *sk)
+{
+    if (!sk)
+        return 0;
+
+    unsigned int pid;
+    unsigned int sk_ack_backlog = 0;
+    unsigned int sk_max_ack_backlog = 0;
+    __u64 sk_cookie = 0;
+    __u32 cpu = 0;
+
+    pid = bpf_get_current_pid_tgid() >> 32;
+    sk_ack_backlog = BPF_CORE_READ(sk, sk_ack_backlog);
+    sk_max_ack_backlog = BPF_CORE_READ(sk, sk_max_ack_backlog);
+    sk_cookie = BPF_CORE_READ(sk, __sk_common.skc_cookie.counter);
+    cpu = bpf_get_smp_processor_id();
+
+    if (sk_cookie == 0)
+        return 0;
+
+    struct acceptq *prev = bpf_map_lookup_elem(&acceptq_map, &sk_cookie);
+    int changed = !prev || prev->curr != sk_ack_backlog || prev->max != sk_max_ack_backlog;
+
+    struct acceptq q = {};
+    q.curr = sk_ack_backlog;
+    q.max = sk_max_ack_backlog;
+    q.cpu = cpu;
+    bpf_map_update_elem(&acceptq_map, &sk_cookie, &q, BPF_ANY);
+
+    if (changed) {
+        struct acceptq_event *e = bpf_ringbuf_reserve(&acceptq_events, sizeof(*e), 0);
+        if (e) {
+            e->cookie = sk_cookie;
+            e->curr = sk_ack_backlog;
+            e->max = sk_max_ack_backlog;
+            e->cpu = cpu;
+            e->pad = 0;
+            bpf_ringbuf_submit(e, 0);
+        }
+    }
+
+    bpf_printk("PID: %d, Backlog: %d/%d, CPU: %d, Cookie: 0x%llx",
+               pid, sk_ack_backlog, sk_max_ack_backlog, cpu, sk_cookie);
+
+    return 0;
+}
+
+#endif /* __ACCEPTQ_COMMON_H */
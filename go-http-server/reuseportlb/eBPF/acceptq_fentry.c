@@ -0,0 +1,20 @@
+// SPDX-License-Identifier: GPL-2.0
+// +build ignore
+#include "vmlinux.h"
+#include <bpf/bpf_helpers.h>
+#include <bpf/bpf_core_read.h>
+#include <bpf/bpf_tracing.h>
+#include "acceptq_common.h"
+
+/*
+ * fentry flavor of acceptq_bpf.c. It needs kernel BTF (5.5+) but costs a
+ * direct trampoline call instead of a breakpoint trap, and its argument is
+ * typed, so it does not depend on the architecture's calling convention.
+ */
+char LICENSE[] SEC("license") = "GPL";
+
+SEC("fentry/tcp_v4_syn_recv_sock")
+int BPF_PROG(on_syn_recv_fentry, const struct sock *sk)
+{
+    return acceptq_record(sk);
+}
@@ -2,6 +2,14 @@
 
 #include "vmlinux.h"
 #include <bpf/bpf_helpers.h>
+#include "ratelimit.h"
+#include "policycfg.h"
+
+#define ACCEPTQ_MAX_SLOTS 128
+
+enum acceptq_param {
+    ACCEPTQ_PARAM_SLOTS = 0, /* slots compared, from slot 0 */
+};
 
 struct acceptq {
     __u32 curr;
@@ -26,32 +34,32 @@ struct {
     __uint(pinning, LIBBPF_PIN_BY_NAME);
 } acceptq_slot_cookies SEC(".maps");
 
-struct {
-    __uint(type, BPF_MAP_TYPE_REUSEPORT_SOCKARRAY);
-    __uint(max_entries, 128);
-    __type(key, __u32);
-    __type(value, __u64); // userspace still writes an int fd
-    __uint(pinning, LIBBPF_PIN_BY_NAME);
-} tcp_balancing_targets SEC(".maps");
-
 SEC("sk_reuseport/selector")
 enum sk_action acceptq_selector(struct sk_reuseport_md *reuse)
 {
+    enum sk_action verdict;
+    if (ratelimit_apply(reuse, &verdict))
+        return verdict;
+
     /* Find slot with lowest accept queue utilization */
     __u32 best_slot = 0;
     __u32 lowest_util = 0xFFFFFFFF;
+    __u32 n = policy_param(ACCEPTQ_PARAM_SLOTS, 4);
 
-	for (__u32 i = 0; i < 4; i++) {
-		__u64 *cookie = bpf_map_lookup_elem(&acceptq_slot_cookies, &i);
+	for (__u32 i = 0; i < ACCEPTQ_MAX_SLOTS && i < n; i++) {
+		/* A copy as the key: with i on the stack the verifier loses
+		 * track of it and cannot bound the loop. */
+		__u32 slot = i;
+		__u64 *cookie = bpf_map_lookup_elem(&acceptq_slot_cookies, &slot);
 		if (!cookie || *cookie == 0) {
-			bpf_printk("slot=%u no_cookie", i);
+			sl_trace("slot=%u no_cookie", i);
 			continue;
 		}
 
 		struct acceptq *aq = bpf_map_lookup_elem(&acceptq_map, cookie);
 
 		if (!aq) {
-			bpf_printk("slot=%u cookie=0x%llx missing acceptq entry", i, *cookie);
+			sl_trace("slot=%u cookie=0x%llx missing acceptq entry", i, *cookie);
 			continue;
 		}
 
@@ -59,7 +67,7 @@ enum sk_action acceptq_selector(struct sk_reuseport_md *reuse)
 			aq->max = 1;
 		// Calculate utilization as percentage: (curr / max) * 100
 		__u32 util = aq->curr;
-		bpf_printk("slot=%u cookie=0x%llx curr=%u max=%u util=%u",
+		sl_trace("slot=%u cookie=0x%llx curr=%u max=%u util=%u",
 			   i, *cookie, aq->curr, aq->max, util);
 
 		if (util < lowest_util) {
@@ -68,15 +76,15 @@ enum sk_action acceptq_selector(struct sk_reuseport_md *reuse)
         }
     }
 
-    bpf_printk("acceptq: selected slot=%u util=%u", best_slot, lowest_util);
+    sl_trace("acceptq: selected slot=%u util=%u", best_slot, lowest_util);
 
-    long ret = bpf_sk_select_reuseport(reuse, &tcp_balancing_targets, &best_slot, 0);
+    long ret = slot_select(reuse, &best_slot);
     if (ret == 0) {
         return SK_PASS;
     }
 
-    bpf_printk("acceptq: selection failed\n");
-    return SK_DROP;
+    sl_trace("acceptq: selection failed\n");
+    return shadow_verdict(reuse, SK_DROP);
 }
 
 char _license[] SEC("license") = "GPL";
@@ -0,0 +1,22 @@
+/* vmlinux.h is generated on x86_64. CO-RE relocates the kernel structs it
+ * declares on any architecture, but kprobes read their arguments from the
+ * register file, and bpf_tracing.h looks at arm64's through struct
+ * user_pt_regs, which only an arm64 vmlinux.h declares. Its layout is UAPI
+ * (asm/ptrace.h), so it is safe to declare here.
+ *
+ * Define VMLINUX_HAS_USER_PT_REGS when building against a vmlinux.h
+ * generated on arm64. Build kprobes with -D__TARGET_ARCH_<arch>, see the
+ * Makefile. */
+#ifndef __LB_ARCH_H
+#define __LB_ARCH_H
+
+#if defined(__TARGET_ARCH_arm64) && !defined(VMLINUX_HAS_USER_PT_REGS)
+struct user_pt_regs {
+    __u64 regs[31];
+    __u64 sp;
+    __u64 pc;
+    __u64 pstate;
+};
+#endif
+
+#endif
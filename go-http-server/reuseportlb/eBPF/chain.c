@@ -0,0 +1,314 @@
+//go:build ignore
+
+#include "vmlinux.h"
+#include <bpf/bpf_helpers.h>
+#include "ratelimit.h"
+
+/*
+ * Composable selection. chain_entry starts every connection with all slots as
+ * candidates and tail-calls the stages in chain_progs in order: filters narrow
+ * the candidate set, and a selector (normally the last stage) picks a socket
+ * among what is left. Userspace builds the chain by filling chain_progs, e.g.
+ *
+ *   exclude-draining -> exclude-overloaded -> round-robin
+ *
+ * A filter may also end the connection there and then: slow-syn drops SYNs
+ * while the whole group is overloaded, which the client sees as a late
+ * SYN-ACK once its SYN is retransmitted.
+ *
+ * Candidates are a bitmask, so only the first CHAIN_MAX_SLOTS slots take part.
+ */
+#define CHAIN_MAX_STAGES 8
+#define CHAIN_MAX_SLOTS 64
+
+/* Per-connection state carried between stages; one SYN runs on one CPU. */
+struct chain_scratch {
+    __u64 candidates; /* bit i set: slot i may still be chosen */
+    __u32 stage;      /* index in chain_progs of the next stage */
+    __u32 pad;
+};
+
+struct chain_cfg {
+    __u32 overload_pct; /* exclude-overloaded: accept queue fill, in percent */
+    __u32 slow_syn_pct; /* slow-syn: SYNs dropped while all slots are overloaded */
+};
+
+struct acceptq {
+    __u32 curr;
+    __u32 max;
+    __u32 cpu;
+};
+
+struct rr_state {
+    __u64 counter;
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_PROG_ARRAY);
+    __uint(max_entries, CHAIN_MAX_STAGES);
+    __type(key, __u32);
+    __type(value, __u32);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} chain_progs SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, struct chain_scratch);
+} chain_scratch SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, struct chain_cfg);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} chain_cfg SEC(".maps");
+
+/* External maps shared with other programs */
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 1024);
+    __type(key, __u64);
+    __type(value, struct acceptq);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} acceptq_map SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 128);
+    __type(key, __u32);
+    __type(value, __u64);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} acceptq_slot_cookies SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, struct rr_state);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} rr SEC(".maps");
+
+static __always_inline struct chain_scratch *chain_state(void)
+{
+    __u32 k0 = 0;
+    return bpf_map_lookup_elem(&chain_scratch, &k0);
+}
+
+/* Cookie of the socket in slot, 0 if it has none. A global function, so
+ * that the filters' loop counters stay in registers for the verifier to
+ * bound rather than on the stack for the lookup's key. */
+__noinline __u64 chain_cookie(__u32 slot)
+{
+    __u64 *cookie = bpf_map_lookup_elem(&acceptq_slot_cookies, &slot);
+    return cookie ? *cookie : 0;
+}
+
+/*
+ * Narrow the candidates to keep. A filter that would exclude every slot is
+ * ignored: a busy socket is still better than refusing the connection.
+ * A global function, so that testing keep does not have the verifier tell
+ * apart every set of slots the filter's loop could build.
+ */
+__noinline int chain_narrow(struct chain_scratch *s, __u64 keep)
+{
+    if (s && keep)
+        s->candidates = keep;
+    return 0;
+}
+
+/* Lowest candidate slot that takes the connection. */
+static __always_inline enum sk_action chain_pick_first(struct sk_reuseport_md *reuse, __u64 candidates)
+{
+    for (__u32 i = 0; i < CHAIN_MAX_SLOTS; i++) {
+        /* A copy: the loop counter's address must not reach the helper. */
+        __u32 slot = i;
+        if (!(candidates & (1ULL << slot)))
+            continue;
+        if (slot_select(reuse, &slot) == 0)
+            return SK_PASS;
+    }
+    sl_trace("chain: no candidate slot took the connection\n");
+    return shadow_verdict(reuse, SK_DROP);
+}
+
+/*
+ * Run the next stage. When the chain ends without a selector, fall back to
+ * the first remaining candidate.
+ */
+static __always_inline enum sk_action chain_next(struct sk_reuseport_md *reuse, struct chain_scratch *s)
+{
+    __u32 stage = s->stage++;
+    if (stage < CHAIN_MAX_STAGES)
+        bpf_tail_call(reuse, &chain_progs, stage);
+    return chain_pick_first(reuse, s->candidates);
+}
+
+SEC("sk_reuseport/selector")
+enum sk_action chain_entry(struct sk_reuseport_md *reuse)
+{
+    enum sk_action verdict;
+    if (ratelimit_apply(reuse, &verdict))
+        return verdict;
+
+    struct chain_scratch *s = chain_state();
+    if (!s)
+        return shadow_verdict(reuse, SK_DROP);
+    s->candidates = ~0ULL;
+    s->stage = 0;
+    return chain_next(reuse, s);
+}
+
+/* Filter: drop slots without a registered socket, including drained ones. */
+SEC("sk_reuseport/selector")
+enum sk_action chain_exclude_draining(struct sk_reuseport_md *reuse)
+{
+    struct chain_scratch *s = chain_state();
+    if (!s)
+        return shadow_verdict(reuse, SK_DROP);
+
+    __u64 keep = 0;
+    for (__u32 slot = 0; slot < CHAIN_MAX_SLOTS; slot++) {
+        if (!(s->candidates & (1ULL << slot)))
+            continue;
+        if (chain_cookie(slot) != 0)
+            keep |= 1ULL << slot;
+    }
+    chain_narrow(s, keep);
+    return chain_next(reuse, s);
+}
+
+/* Filter: drop slots whose accept queue is at least overload_pct full. */
+SEC("sk_reuseport/selector")
+enum sk_action chain_exclude_overloaded(struct sk_reuseport_md *reuse)
+{
+    struct chain_scratch *s = chain_state();
+    if (!s)
+        return shadow_verdict(reuse, SK_DROP);
+
+    __u32 k0 = 0;
+    struct chain_cfg *cfg = bpf_map_lookup_elem(&chain_cfg, &k0);
+    if (!cfg || cfg->overload_pct == 0)
+        return chain_next(reuse, s);
+
+    __u64 keep = 0;
+    for (__u32 slot = 0; slot < CHAIN_MAX_SLOTS; slot++) {
+        if (!(s->candidates & (1ULL << slot)))
+            continue;
+        keep |= 1ULL << slot;
+
+        __u64 cookie = chain_cookie(slot);
+        if (cookie == 0)
+            continue;
+        struct acceptq *aq = bpf_map_lookup_elem(&acceptq_map, &cookie);
+        if (!aq || aq->max == 0)
+            continue;
+        if ((__u64)aq->curr * 100 >= (__u64)aq->max * cfg->overload_pct) {
+            sl_trace("chain: slot=%u overloaded curr=%u max=%u", slot, aq->curr, aq->max);
+            keep &= ~(1ULL << slot);
+        }
+    }
+    chain_narrow(s, keep);
+    return chain_next(reuse, s);
+}
+
+/*
+ * Filter: push back on clients while every candidate slot with a listener
+ * is at least overload_pct full, by dropping slow_syn_pct percent of their
+ * SYNs. A dropped SYN is retransmitted after the client's initial RTO (1s
+ * on Linux, doubling each time), so the backpressure travels upstream as
+ * connect latency rather than as a connection piling onto a full queue.
+ */
+SEC("sk_reuseport/selector")
+enum sk_action chain_slow_syn(struct sk_reuseport_md *reuse)
+{
+    struct chain_scratch *s = chain_state();
+    if (!s)
+        return shadow_verdict(reuse, SK_DROP);
+
+    __u32 k0 = 0;
+    struct chain_cfg *cfg = bpf_map_lookup_elem(&chain_cfg, &k0);
+    if (!cfg || cfg->overload_pct == 0 || cfg->slow_syn_pct == 0)
+        return chain_next(reuse, s);
+
+    /* Flags rather than a count, and one way out of the loop, so that the
+     * verifier need not follow chain_next() from every iteration. */
+    int measured = 0, room = 0;
+    for (__u32 slot = 0; slot < CHAIN_MAX_SLOTS; slot++) {
+        if (!(s->candidates & (1ULL << slot)))
+            continue;
+        __u64 cookie = chain_cookie(slot);
+        if (cookie == 0)
+            continue;
+        struct acceptq *aq = bpf_map_lookup_elem(&acceptq_map, &cookie);
+        if (!aq || aq->max == 0)
+            continue;
+        /* One slot with room left is enough to take the connection. */
+        if ((__u64)aq->curr * 100 < (__u64)aq->max * cfg->overload_pct) {
+            room = 1;
+            break;
+        }
+        measured = 1;
+    }
+    if (room || !measured || bpf_get_prandom_u32() % 100 >= cfg->slow_syn_pct)
+        return chain_next(reuse, s);
+    sl_trace("chain: group overloaded, slowing SYN\n");
+    return shadow_verdict(reuse, SK_DROP);
+}
+
+/* Selector: rotate over the remaining candidates. */
+SEC("sk_reuseport/selector")
+enum sk_action chain_round_robin(struct sk_reuseport_md *reuse)
+{
+    struct chain_scratch *s = chain_state();
+    if (!s)
+        return shadow_verdict(reuse, SK_DROP);
+
+    __u32 k0 = 0;
+    struct rr_state *st = bpf_map_lookup_elem(&rr, &k0);
+    if (!st)
+        return chain_pick_first(reuse, s->candidates);
+
+    __u32 n = 0;
+    for (__u32 slot = 0; slot < CHAIN_MAX_SLOTS; slot++) {
+        if (s->candidates & (1ULL << slot))
+            n++;
+    }
+    if (n == 0)
+        return shadow_verdict(reuse, SK_DROP);
+
+    /* Start at the k-th candidate and probe the rest in order, wrapping. */
+    __u32 k = __sync_fetch_and_add(&st->counter, 1) % n;
+    __u32 seen = 0, start = 0;
+    for (__u32 i = 0; i < CHAIN_MAX_SLOTS; i++) {
+        if (!(s->candidates & (1ULL << i)))
+            continue;
+        if (seen++ == k) {
+            start = i;
+            break;
+        }
+    }
+    for (__u32 i = 0; i < CHAIN_MAX_SLOTS; i++) {
+        __u32 slot = (start + i) & (CHAIN_MAX_SLOTS - 1);
+        if (!(s->candidates & (1ULL << slot)))
+            continue;
+        if (slot_select(reuse, &slot) == 0)
+            return SK_PASS;
+    }
+    sl_trace("chain: round robin found no socket among %u candidates\n", n);
+    return shadow_verdict(reuse, SK_DROP);
+}
+
+/* Selector: the lowest remaining candidate. */
+SEC("sk_reuseport/selector")
+enum sk_action chain_first(struct sk_reuseport_md *reuse)
+{
+    struct chain_scratch *s = chain_state();
+    if (!s)
+        return shadow_verdict(reuse, SK_DROP);
+    return chain_pick_first(reuse, s->candidates);
+}
+
+char _license[] SEC("license") = "GPL";
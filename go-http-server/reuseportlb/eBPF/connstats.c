@@ -0,0 +1,122 @@
+//go:build ignore
+
+#include "vmlinux.h"
+#include <bpf/bpf_helpers.h>
+#include <bpf/bpf_core_read.h>
+
+/*
+ * Per-listener connection lifetime and traffic, aggregated in the kernel.
+ * A sock_ops program attached to the root cgroup stamps every passively
+ * opened connection with the time its handshake completed and asks for
+ * its state changes; inet_csk_accept records which listener it was
+ * accepted from. When the connection closes, its lifetime, the bytes it
+ * received and had acknowledged, and its retransmits are added to the
+ * listener's totals. Userspace maps listener cookies to slots, as with
+ * lat_hist.
+ *
+ * Traffic is counted when a connection closes, so long-lived connections
+ * show up late; open counts those accepted and not closed yet.
+ */
+
+struct conn_owner {
+    __u64 listener; /* cookie of the listener it was accepted from, 0 until then */
+    __u64 start_ns; /* when the handshake completed */
+};
+
+struct conn_stats {
+    __u64 open;      /* connections accepted and not closed yet */
+    __u64 closed;    /* connections accepted and closed since */
+    __u64 life_ns;   /* summed lifetime of the closed ones */
+    __u64 bytes_in;  /* bytes received */
+    __u64 bytes_out; /* bytes sent and acknowledged */
+    __u64 retrans;   /* segments retransmitted */
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_SK_STORAGE);
+    __uint(map_flags, BPF_F_NO_PREALLOC);
+    __type(key, int);
+    __type(value, struct conn_owner);
+} conn_owner SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 1024);
+    __type(key, __u64); /* listener cookie */
+    __type(value, struct conn_stats);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} conn_stats SEC(".maps");
+
+static __always_inline struct conn_stats *conn_stats_of(__u64 listener)
+{
+    struct conn_stats *s = bpf_map_lookup_elem(&conn_stats, &listener);
+    if (s)
+        return s;
+    struct conn_stats zero = {};
+    bpf_map_update_elem(&conn_stats, &listener, &zero, BPF_NOEXIST);
+    return bpf_map_lookup_elem(&conn_stats, &listener);
+}
+
+SEC("fexit/inet_csk_accept")
+int conn_accept(__u64 *ctx)
+{
+    __u64 ret;
+    if (bpf_get_func_ret(ctx, &ret) || ret == 0)
+        return 0;
+
+    struct sock *listener = (struct sock *)ctx[0];
+    __u64 cookie = BPF_CORE_READ(listener, __sk_common.skc_cookie.counter);
+    if (cookie == 0)
+        return 0;
+
+    struct conn_owner *o = bpf_sk_storage_get(&conn_owner, (struct sock *)ret, 0, 0);
+    if (!o || o->listener != 0)
+        return 0;
+    o->listener = cookie;
+    struct conn_stats *s = conn_stats_of(cookie);
+    if (s)
+        __sync_fetch_and_add(&s->open, 1);
+    return 0;
+}
+
+SEC("sockops")
+int conn_sockops(struct bpf_sock_ops *skops)
+{
+    /* Checked once: the verifier takes every read of skops->sk for a
+     * pointer that may be NULL. */
+    struct bpf_sock *sk = skops->sk;
+    if (!sk)
+        return 1;
+    switch (skops->op) {
+    case BPF_SOCK_OPS_PASSIVE_ESTABLISHED_CB: {
+        struct conn_owner *o = bpf_sk_storage_get(&conn_owner, sk, 0, BPF_SK_STORAGE_GET_F_CREATE);
+        if (!o)
+            return 1;
+        o->start_ns = bpf_ktime_get_ns();
+        bpf_sock_ops_cb_flags_set(skops, skops->bpf_sock_ops_cb_flags | BPF_SOCK_OPS_STATE_CB_FLAG);
+        break;
+    }
+    case BPF_SOCK_OPS_STATE_CB: {
+        if (skops->args[1] != BPF_TCP_CLOSE)
+            return 1;
+        struct conn_owner *o = bpf_sk_storage_get(&conn_owner, sk, 0, 0);
+        if (!o || o->listener == 0)
+            return 1;
+        struct conn_stats *s = conn_stats_of(o->listener);
+        o->listener = 0;
+        if (!s)
+            return 1;
+        if (s->open > 0)
+            __sync_fetch_and_add(&s->open, -1);
+        __sync_fetch_and_add(&s->closed, 1);
+        __sync_fetch_and_add(&s->life_ns, bpf_ktime_get_ns() - o->start_ns);
+        __sync_fetch_and_add(&s->bytes_in, skops->bytes_received);
+        __sync_fetch_and_add(&s->bytes_out, skops->bytes_acked);
+        __sync_fetch_and_add(&s->retrans, skops->total_retrans);
+        break;
+    }
+    }
+    return 1;
+}
+
+char _license[] SEC("license") = "GPL";
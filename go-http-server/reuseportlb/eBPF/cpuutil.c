@@ -0,0 +1,79 @@
+//go:build ignore
+
+#include "vmlinux.h"
+#include <bpf/bpf_helpers.h>
+#include "ratelimit.h"
+
+#define MAX_SLOTS 128
+/* CPUs slot_owner records the affinity of, as many as sched_getaffinity
+ * reports to the Go side (unix.CPUSet). */
+#define OWNER_CPUS 1024
+
+/* Which process owns each slot and where it may run, written at registration. */
+struct slot_owner {
+    __u32 pid;   /* 0 while the slot is free */
+    __u32 ncpus; /* number of CPUs set in cpus */
+    __u64 cpus[OWNER_CPUS / 64]; /* affinity of the owning process, CPU n in bit n%64 of word n/64 */
+};
+
+/* External maps shared with other programs */
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, MAX_SLOTS);
+    __type(key, __u32);
+    __type(value, struct slot_owner);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} slot_owner SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, MAX_SLOTS);
+    __type(key, __u32);
+    __type(value, __u32); // utilization of the slot's CPUs * 100, from collect_stats
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} slot_util SEC(".maps");
+
+SEC("sk_reuseport/selector")
+enum sk_action cpuutil_selector(struct sk_reuseport_md *reuse)
+{
+    enum sk_action verdict;
+    if (ratelimit_apply(reuse, &verdict))
+        return verdict;
+
+    /* Find the registered slot with the lowest utilization */
+    __u32 best_slot = 0;
+    __u32 lowest_util = 0xFFFFFFFF;
+
+    for (__u32 i = 0; i < MAX_SLOTS; i++) {
+        __u32 slot = i;
+
+        struct slot_owner *owner = bpf_map_lookup_elem(&slot_owner, &slot);
+        if (!owner || owner->pid == 0)
+            continue;
+
+        __u32 *util_p = bpf_map_lookup_elem(&slot_util, &slot);
+        __u32 util = util_p ? *util_p : 0;
+
+        if (util < lowest_util) {
+            lowest_util = util;
+            best_slot = slot;
+        }
+    }
+
+    if (lowest_util == 0xFFFFFFFF) {
+        /* Nobody registered yet: let the kernel hash as usual. */
+        return shadow_verdict(reuse, SK_PASS);
+    }
+
+    sl_trace("cpuutil: selected slot=%u util=%u", best_slot, lowest_util);
+
+    long ret = slot_select(reuse, &best_slot);
+    if (ret == 0) {
+        return SK_PASS;
+    }
+
+    sl_trace("cpuutil: selection failed\n");
+    return shadow_verdict(reuse, SK_DROP);
+}
+
+char _license[] SEC("license") = "GPL";
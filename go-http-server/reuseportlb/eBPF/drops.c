@@ -0,0 +1,83 @@
+//go:build ignore
+
+#include "vmlinux.h"
+#include <bpf/bpf_helpers.h>
+#include <bpf/bpf_core_read.h>
+#include <bpf/bpf_tracing.h>
+#include <bpf/bpf_endian.h>
+
+/*
+ * Connection attempts the kernel threw away, by drop reason. The skb:kfree_skb
+ * tracepoint fires for every dropped packet; this counts the SYNs among them
+ * that were headed for the group's port. A SYN no listener would take (the
+ * selector returned SK_DROP, or the slot it picked was gone) is dropped with
+ * NO_SOCKET and answered with a RST, which the client sees as ECONNREFUSED;
+ * a full accept queue shows up as a drop too, without the RST.
+ *
+ * It is a tp_btf program so the reason comes typed; reason names are read
+ * from the kernel's BTF in userspace, since their values move between
+ * kernels. Drop reasons need 5.17.
+ */
+#define DROP_REASONS 256
+
+#define ETH_P_IP   0x0800
+#define ETH_P_IPV6 0x86DD
+
+/* Set by userspace before loading, in network byte order. */
+volatile const __u16 drops_port = 0;
+
+struct {
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+    __uint(max_entries, DROP_REASONS);
+    __type(key, __u32); /* enum skb_drop_reason */
+    __type(value, __u64);
+} syn_drops SEC(".maps");
+
+SEC("tp_btf/kfree_skb")
+int BPF_PROG(drops_kfree_skb, struct sk_buff *skb, void *location, enum skb_drop_reason reason)
+{
+    __u16 transport = BPF_CORE_READ(skb, transport_header);
+    __u16 network = BPF_CORE_READ(skb, network_header);
+    if (transport == (__u16)~0 || network == (__u16)~0)
+        return 0;
+    unsigned char *head = BPF_CORE_READ(skb, head);
+
+    __u8 proto = 0;
+    switch (bpf_ntohs(BPF_CORE_READ(skb, protocol))) {
+    case ETH_P_IP: {
+        struct iphdr ip;
+        if (bpf_probe_read_kernel(&ip, sizeof(ip), head + network))
+            return 0;
+        proto = ip.protocol;
+        break;
+    }
+    case ETH_P_IPV6: {
+        /* Extension headers are rare enough on a SYN not to chase. */
+        struct ipv6hdr ip6;
+        if (bpf_probe_read_kernel(&ip6, sizeof(ip6), head + network))
+            return 0;
+        proto = ip6.nexthdr;
+        break;
+    }
+    default:
+        return 0;
+    }
+    if (proto != IPPROTO_TCP)
+        return 0;
+
+    struct tcphdr th;
+    if (bpf_probe_read_kernel(&th, sizeof(th), head + transport))
+        return 0;
+    if (th.dest != drops_port || !th.syn || th.ack)
+        return 0;
+
+    __u32 key = reason;
+    if (key >= DROP_REASONS)
+        key = DROP_REASONS - 1;
+    __u64 *n = bpf_map_lookup_elem(&syn_drops, &key);
+    if (n)
+        *n += 1;
+    return 0;
+}
+
+char _license[] SEC("license") = "GPL";
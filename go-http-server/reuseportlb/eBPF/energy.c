@@ -0,0 +1,94 @@
+//go:build ignore
+
+#include "vmlinux.h"
+#include <bpf/bpf_helpers.h>
+#include "ratelimit.h"
+#include "policycfg.h"
+
+/*
+ * Trade a little latency for energy: instead of spreading connections
+ * evenly, pack them onto as few instances as will carry the load, so the
+ * cores of the idle ones can stay in deep C-states. Slots are filled in
+ * order: the lowest listening slot whose utilization (slot_util, from the
+ * collector) is under pack_pct takes the connection. Once every slot is at
+ * pack_pct or above the load is no longer low, and the least utilized
+ * slot gets it, as with cpuutil. Slots the collector publishes nothing for
+ * count as idle. Only the first ENERGY_MAX_SLOTS slots take part.
+ */
+#define ENERGY_MAX_SLOTS 64
+
+enum energy_param {
+    ENERGY_PARAM_PACK_PCT = 0, /* utilization at which a slot counts as full */
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 128);
+    __type(key, __u32);
+    __type(value, __u32); // utilization of the slot's CPUs * 100, from collect_stats
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} slot_util SEC(".maps");
+
+/* External maps shared with other programs */
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 128);
+    __type(key, __u32);
+    __type(value, __u64);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} acceptq_slot_cookies SEC(".maps");
+
+#define ENERGY_ABSENT ~0ULL      /* nothing listens on the slot */
+#define ENERGY_FULL   (1ULL << 32) /* at pack_pct or above */
+
+/*
+ * The utilization of slot, with ENERGY_FULL set if it is full, or
+ * ENERGY_ABSENT. A global function, so that the verifier checks it once
+ * instead of in every iteration of the selector's loop.
+ */
+__noinline __u64 energy_slot(__u32 slot, __u64 full)
+{
+    __u64 *cookie = bpf_map_lookup_elem(&acceptq_slot_cookies, &slot);
+    if (!cookie || *cookie == 0)
+        return ENERGY_ABSENT;
+    __u32 *u = bpf_map_lookup_elem(&slot_util, &slot);
+    __u64 util = u ? *u : 0;
+    return util >= full ? util | ENERGY_FULL : util;
+}
+
+SEC("sk_reuseport/selector")
+enum sk_action energy_selector(struct sk_reuseport_md *reuse)
+{
+    enum sk_action verdict;
+    if (ratelimit_apply(reuse, &verdict))
+        return verdict;
+
+    __u64 full = policy_param(ENERGY_PARAM_PACK_PCT, 60) * 100;
+
+    __u32 least = 0;
+    __u64 least_util = ENERGY_ABSENT;
+    for (__u32 i = 0; i < ENERGY_MAX_SLOTS; i++) {
+        __u32 slot = i;
+        __u64 util = energy_slot(slot, full);
+        if (util == ENERGY_ABSENT)
+            continue;
+        if (!(util & ENERGY_FULL)) {
+            if (slot_select(reuse, &slot) == 0)
+                return SK_PASS;
+            continue; /* gone since */
+        }
+        if (util < least_util) {
+            least = slot;
+            least_util = util;
+        }
+    }
+
+    /* Every slot is full, or the ones that are not are gone. */
+    if (least_util != ENERGY_ABSENT && slot_select(reuse, &least) == 0)
+        return SK_PASS;
+
+    sl_trace("energy: no slot is listening\n");
+    return shadow_verdict(reuse, SK_DROP);
+}
+
+char _license[] SEC("license") = "GPL";
@@ -0,0 +1,113 @@
+//go:build ignore
+
+#include "vmlinux.h"
+#include <bpf/bpf_helpers.h>
+#include "ratelimit.h"
+#include "policycfg.h"
+
+/*
+ * Keep new connections off Go instances that are collecting garbage or about
+ * to. Every server publishes its runtime/metrics readings in slot_runtime
+ * (see runtimemetrics.go): how close its heap is to the GC goal, and the recent p99
+ * of its scheduling latency. A slot whose heap is at heap_pct of the goal or
+ * more is in or near a GC cycle, with mark assists and a stop-the-world
+ * ahead; one whose scheduling latency p99 is at sched_p99_us or more is
+ * already slow to pick up work. Such slots are skipped while any other slot
+ * is fine, and connections spread over the fine ones by hash. When every
+ * slot is busy the one with the smallest heap fraction gets the connection.
+ * Readings older than RT_STALE_NS count as fine, so a server that stops
+ * publishing is not starved. Only the first RT_MAX_SLOTS slots take part.
+ */
+#define RT_MAX_SLOTS 64
+#define RT_STALE_NS  1000000000ULL
+
+enum gcaware_param {
+    GC_PARAM_HEAP_PCT = 0,     /* heap fraction of the GC goal, in percent */
+    GC_PARAM_SCHED_P99_US = 1, /* scheduling latency p99 */
+};
+
+struct slot_runtime {
+    __u64 updated_ns;   /* bpf_ktime_get_ns clock */
+    __u32 heap_pct;     /* live and unswept heap over the GC goal, percent */
+    __u32 goroutines;
+    __u32 gc_cycles;    /* completed since the server started */
+    __u32 sched_p99_us; /* since the previous reading */
+    __u32 pause_p99_us; /* GC pauses since the previous reading */
+    __u32 pad;
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 128);
+    __type(key, __u32);
+    __type(value, struct slot_runtime);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} slot_runtime SEC(".maps");
+
+/* External maps shared with other programs */
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 128);
+    __type(key, __u32);
+    __type(value, __u64);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} acceptq_slot_cookies SEC(".maps");
+
+#define RT_ABSENT ~0ULL      /* nothing listens on the slot */
+#define RT_BUSY   (1ULL << 32) /* over heap_pct or sched_p99_us */
+
+/*
+ * The heap fraction of slot's owner, with RT_BUSY set if it is busy, or
+ * RT_ABSENT. A global function, so that the verifier checks it once instead
+ * of in every iteration of the selector's loop.
+ */
+__noinline __u64 gcaware_slot(__u32 slot, __u64 heap_pct, __u64 sched_us, __u64 now)
+{
+    __u64 *cookie = bpf_map_lookup_elem(&acceptq_slot_cookies, &slot);
+    if (!cookie || *cookie == 0)
+        return RT_ABSENT;
+    struct slot_runtime *rt = bpf_map_lookup_elem(&slot_runtime, &slot);
+    if (!rt || !rt->updated_ns || now - rt->updated_ns >= RT_STALE_NS)
+        return 0;
+    __u64 pct = rt->heap_pct;
+    if (pct >= heap_pct || rt->sched_p99_us >= sched_us)
+        return pct | RT_BUSY;
+    return pct;
+}
+
+SEC("sk_reuseport/selector")
+enum sk_action gcaware_selector(struct sk_reuseport_md *reuse)
+{
+    enum sk_action verdict;
+    if (ratelimit_apply(reuse, &verdict))
+        return verdict;
+
+    __u64 heap_pct = policy_param(GC_PARAM_HEAP_PCT, 90);
+    __u64 sched_us = policy_param(GC_PARAM_SCHED_P99_US, 10000);
+    __u64 now = bpf_ktime_get_ns();
+
+    __u32 start = reuse->hash % RT_MAX_SLOTS;
+    __u32 least = 0, least_pct = 0xFFFFFFFF;
+    for (__u32 i = 0; i < RT_MAX_SLOTS; i++) {
+        __u32 slot = (start + i) % RT_MAX_SLOTS;
+        __u64 rt = gcaware_slot(slot, heap_pct, sched_us, now);
+        if (rt == RT_ABSENT)
+            continue;
+        if (!(rt & RT_BUSY) && slot_select(reuse, &slot) == 0)
+            return SK_PASS;
+        __u32 pct = rt;
+        if (pct < least_pct) {
+            least = slot;
+            least_pct = pct;
+        }
+    }
+
+    /* Every slot is busy, or the ones that are not are gone. */
+    if (least_pct != 0xFFFFFFFF && slot_select(reuse, &least) == 0)
+        return SK_PASS;
+
+    sl_trace("gcaware: no slot is listening\n");
+    return shadow_verdict(reuse, SK_DROP);
+}
+
+char _license[] SEC("license") = "GPL";
@@ -0,0 +1,116 @@
+//go:build ignore
+
+#include "vmlinux.h"
+#include <bpf/bpf_helpers.h>
+#include "ratelimit.h"
+#include "policycfg.h"
+
+/*
+ * Pick slots in proportion to a health score their servers publish. Each
+ * server folds whatever it knows about itself (CPU, accept queue, GC, its
+ * own application checks; see healthscore.go) into one number from 0 to
+ * 100 in slot_health, so a new signal needs no new program. A connection
+ * goes to slot i with probability score_i / sum(scores), drawn from the
+ * connection's hash so that retransmitted SYNs land on the same slot. Slots
+ * without a fresh score count as unknown_score; if every score is 0, the
+ * first listening slot gets the connection. Only the first HS_MAX_SLOTS
+ * slots take part.
+ */
+#define HS_MAX_SLOTS 64
+#define HS_STALE_NS  1000000000ULL
+
+enum healthscore_param {
+    HS_PARAM_UNKNOWN_SCORE = 0, /* score of slots that publish none */
+};
+
+struct slot_health {
+    __u64 updated_ns; /* bpf_ktime_get_ns clock */
+    __u32 score;      /* 0 (send nothing) to 100 */
+    __u32 pad;
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 128);
+    __type(key, __u32);
+    __type(value, struct slot_health);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} slot_health SEC(".maps");
+
+/* External maps shared with other programs */
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 128);
+    __type(key, __u32);
+    __type(value, __u64);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} acceptq_slot_cookies SEC(".maps");
+
+#define HS_ABSENT ~0U /* nothing listens on the slot */
+
+/*
+ * The score of slot, or HS_ABSENT. A global function, so that the verifier
+ * checks it once instead of in every iteration of the selector's loop.
+ */
+__noinline __u32 hs_score(__u32 slot, __u64 now, __u32 unknown)
+{
+    __u64 *cookie = bpf_map_lookup_elem(&acceptq_slot_cookies, &slot);
+    if (!cookie || *cookie == 0)
+        return HS_ABSENT;
+    struct slot_health *h = bpf_map_lookup_elem(&slot_health, &slot);
+    if (!h || h->updated_ns == 0 || now - h->updated_ns >= HS_STALE_NS)
+        return unknown;
+    return h->score > 100 ? 100 : h->score;
+}
+
+SEC("sk_reuseport/selector")
+enum sk_action healthscore_selector(struct sk_reuseport_md *reuse)
+{
+    enum sk_action verdict;
+    if (ratelimit_apply(reuse, &verdict))
+        return verdict;
+
+    __u32 unknown = policy_param(HS_PARAM_UNKNOWN_SCORE, 50);
+    __u64 now = bpf_ktime_get_ns();
+
+    /* Every slot's score, HS_ABSENT included: nothing is learnt about
+     * them but what hs_score() returned, which keeps the verifier from
+     * telling apart every combination of listening slots. The loop
+     * counters index scores, so slot_select() gets copies. */
+    __u32 scores[HS_MAX_SLOTS];
+    __u32 sum = 0;
+    for (__u32 i = 0; i < HS_MAX_SLOTS; i++) {
+        __u32 score = hs_score(i, now, unknown);
+        scores[i] = score;
+        if (score != HS_ABSENT)
+            sum += score;
+    }
+
+    if (sum > 0) {
+        __u32 r = reuse->hash % sum;
+        for (__u32 i = 0; i < HS_MAX_SLOTS; i++) {
+            if (scores[i] == HS_ABSENT)
+                continue;
+            if (r < scores[i]) {
+                __u32 slot = i;
+                if (slot_select(reuse, &slot) == 0)
+                    return SK_PASS;
+                break;
+            }
+            r -= scores[i];
+        }
+    }
+
+    /* Every score is 0, or the drawn slot stopped listening. */
+    for (__u32 i = 0; i < HS_MAX_SLOTS; i++) {
+        __u32 slot = i;
+        if (scores[i] != HS_ABSENT &&
+            slot_select(reuse, &slot) == 0)
+            return SK_PASS;
+    }
+
+    sl_trace("healthscore: no slot is listening\n");
+    return shadow_verdict(reuse, SK_DROP);
+}
+
+char _license[] SEC("license") = "GPL";
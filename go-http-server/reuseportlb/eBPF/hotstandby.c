@@ -0,0 +1,270 @@
+//go:build ignore
+
+#include "vmlinux.h"
+#include <bpf/bpf_helpers.h>
+#include "ratelimit.h"
+
+/*
+ * Hot standby, reuseportlb.c grown into a policy: every new connection goes
+ * to the primary slot while it is listening and healthy, and to the standby
+ * slot otherwise. Listening is the sockarray's business; a slot that closed
+ * its listener is simply not selectable. Health is a heartbeat: instances
+ * write bpf_ktime_get_ns() into standby_heartbeat while their own check
+ * passes, and a slot whose heartbeat is older than timeout_ns is passed
+ * over. A slot that never wrote one is judged by liveness alone, and when
+ * neither slot is healthy the live one still gets the connection: a stale
+ * heartbeat moves traffic, it never drops it.
+ *
+ * With priorities set, primary and standby give way to priority groups:
+ * standby_priority puts slots in levels 1 (highest) to PRIO_MAX_LEVELS, and
+ * connections go to a random member of the highest level with a healthy,
+ * listening member. If that level's accept queues are on average at least
+ * spill_threshold_pct full, spill_pct percent of connections spill over to
+ * the next level down. Only the first PRIO_MAX_SLOTS slots can have a
+ * priority.
+ *
+ * Every switch of the active slot (of the active level, with priorities) is
+ * recorded in standby_state with the heartbeat the previous slot last
+ * wrote, so userspace can log how long traffic kept going to a failed
+ * instance. Spilled connections are not switches.
+ */
+#define PRIO_MAX_SLOTS 64
+#define PRIO_MAX_LEVELS 8
+
+struct standby_cfg {
+    __u32 primary;
+    __u32 standby;
+    __u64 timeout_ns; /* 0 disables health checks */
+    __u32 spill_threshold_pct;
+    __u32 spill_pct;  /* 0 disables spillover */
+    __u32 priorities; /* slots with a priority; 0 means primary/standby */
+    __u32 pad;
+};
+
+struct standby_state {
+    __u32 active;
+    __u32 previous;
+    __u32 level; /* level of the active slot with priorities, else 0 */
+    __u32 previous_level;
+    __u64 switched_ns;       /* when the active slot last changed */
+    __u64 last_heartbeat_ns; /* the previous slot's heartbeat then */
+    __u64 switches;
+};
+
+struct acceptq {
+    __u32 curr;
+    __u32 max;
+    __u32 cpu;
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, struct standby_cfg);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} standby_cfg SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, struct standby_state);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} standby_state SEC(".maps");
+
+/* Last heartbeat of each slot, bpf_ktime_get_ns() clock. */
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 128);
+    __type(key, __u32);
+    __type(value, __u64);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} standby_heartbeat SEC(".maps");
+
+/* Priority level of each slot, 1 highest; 0 means no priority. */
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 128);
+    __type(key, __u32);
+    __type(value, __u32);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} standby_priority SEC(".maps");
+
+/* External maps shared with other programs */
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 1024);
+    __type(key, __u64);
+    __type(value, struct acceptq);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} acceptq_map SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 128);
+    __type(key, __u32);
+    __type(value, __u64);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} acceptq_slot_cookies SEC(".maps");
+
+static __always_inline __u64 heartbeat(__u32 slot)
+{
+    __u64 *hb = bpf_map_lookup_elem(&standby_heartbeat, &slot);
+    return hb ? *hb : 0;
+}
+
+static __always_inline int healthy(__u32 slot, __u64 timeout, __u64 now)
+{
+    __u64 hb = heartbeat(slot);
+    return timeout == 0 || hb == 0 || now - hb <= timeout;
+}
+
+static __always_inline enum sk_action standby_pick(__u32 slot, __u32 level, __u64 now)
+{
+    __u32 k0 = 0;
+    struct standby_state *st = bpf_map_lookup_elem(&standby_state, &k0);
+    if (st && (level ? st->level != level : st->active != slot)) {
+        st->last_heartbeat_ns = heartbeat(st->active);
+        st->previous = st->active;
+        st->previous_level = st->level;
+        st->active = slot;
+        st->level = level;
+        st->switched_ns = now;
+        __sync_fetch_and_add(&st->switches, 1);
+    }
+    return SK_PASS;
+}
+
+/* Average accept queue fill of the slots in mask, in percent. prio_fill()
+ * and prio_try() are global functions: the verifier checks each once, with
+ * nothing known of mask, instead of for every set of slots and level
+ * priority_select() could hand them. */
+__noinline __u32 prio_fill(__u64 mask)
+{
+    __u64 curr = 0, max = 0;
+    for (__u32 i = 0; i < PRIO_MAX_SLOTS; i++) {
+        /* A copy as the key, so that i stays bounded. */
+        __u32 slot = i;
+        if (!(mask & (1ULL << slot)))
+            continue;
+        __u64 *cookie = bpf_map_lookup_elem(&acceptq_slot_cookies, &slot);
+        if (!cookie || *cookie == 0)
+            continue;
+        struct acceptq *aq = bpf_map_lookup_elem(&acceptq_map, cookie);
+        if (!aq)
+            continue;
+        curr += aq->curr;
+        max += aq->max;
+    }
+    return max ? curr * 100 / max : 0;
+}
+
+/* Select a random member of mask, trying the others if it has no listener.
+ * Returns the slot taken, or -1. */
+__noinline int prio_try(struct sk_reuseport_md *reuse, __u64 mask)
+{
+    if (!mask)
+        return -1;
+    __u32 n = 0;
+    for (__u32 i = 0; i < PRIO_MAX_SLOTS; i++) {
+        if (mask & (1ULL << i))
+            n++;
+    }
+
+    __u32 k = bpf_get_prandom_u32() % n;
+    __u32 seen = 0, start = 0;
+    for (__u32 i = 0; i < PRIO_MAX_SLOTS; i++) {
+        if (!(mask & (1ULL << i)))
+            continue;
+        if (seen++ == k) {
+            start = i;
+            break;
+        }
+    }
+    for (__u32 i = 0; i < PRIO_MAX_SLOTS; i++) {
+        __u32 slot = (start + i) & (PRIO_MAX_SLOTS - 1);
+        if (!(mask & (1ULL << slot)))
+            continue;
+        if (slot_select(reuse, &slot) == 0)
+            return slot;
+    }
+    return -1;
+}
+
+static __always_inline enum sk_action priority_select(struct sk_reuseport_md *reuse,
+                                                      struct standby_cfg *cfg, __u64 now)
+{
+    /* Members of each level: healthy ones, and all of them. */
+    __u64 healthy_mask[PRIO_MAX_LEVELS] = {};
+    __u64 all_mask[PRIO_MAX_LEVELS] = {};
+    for (__u32 i = 0; i < PRIO_MAX_SLOTS; i++) {
+        __u32 slot = i;
+        __u32 *prio = bpf_map_lookup_elem(&standby_priority, &slot);
+        if (!prio || *prio == 0 || *prio > PRIO_MAX_LEVELS)
+            continue;
+        __u32 level = *prio - 1;
+        all_mask[level & (PRIO_MAX_LEVELS - 1)] |= 1ULL << slot;
+        if (healthy(slot, cfg->timeout_ns, now))
+            healthy_mask[level & (PRIO_MAX_LEVELS - 1)] |= 1ULL << slot;
+    }
+
+    for (int pass = 0; pass < 2; pass++) {
+        __u64 *masks = pass ? all_mask : healthy_mask;
+        for (__u32 level = 0; level < PRIO_MAX_LEVELS; level++) {
+            __u64 mask = masks[level];
+            if (!mask)
+                continue;
+
+            if (cfg->spill_pct > 0 && prio_fill(mask) >= cfg->spill_threshold_pct &&
+                bpf_get_prandom_u32() % 100 < cfg->spill_pct) {
+                for (__u32 next = level + 1; next < PRIO_MAX_LEVELS; next++) {
+                    if (masks[next] && prio_try(reuse, masks[next]) >= 0)
+                        return SK_PASS;
+                }
+            }
+
+            int slot = prio_try(reuse, mask);
+            if (slot >= 0)
+                return standby_pick(slot, level + 1, now);
+        }
+    }
+
+    sl_trace("hot-standby: no slot with a priority is listening\n");
+    return shadow_verdict(reuse, SK_DROP);
+}
+
+SEC("sk_reuseport/selector")
+enum sk_action hot_standby(struct sk_reuseport_md *reuse)
+{
+    enum sk_action verdict;
+    if (ratelimit_apply(reuse, &verdict))
+        return verdict;
+
+    __u32 k0 = 0;
+    struct standby_cfg *cfg = bpf_map_lookup_elem(&standby_cfg, &k0);
+    if (!cfg)
+        return shadow_verdict(reuse, SK_DROP);
+    __u64 now = bpf_ktime_get_ns();
+    if (cfg->priorities)
+        return priority_select(reuse, cfg, now);
+
+    __u32 primary = cfg->primary;
+    __u32 standby = cfg->standby;
+    __u64 timeout = cfg->timeout_ns;
+
+    /* Healthy slots first, then whichever is still listening. */
+    for (int pass = 0; pass < 2; pass++) {
+        if ((pass || healthy(primary, timeout, now)) &&
+            slot_select(reuse, &primary) == 0)
+            return standby_pick(primary, 0, now);
+        if ((pass || healthy(standby, timeout, now)) &&
+            slot_select(reuse, &standby) == 0)
+            return standby_pick(standby, 0, now);
+    }
+
+    sl_trace("hot-standby: neither slot %u nor slot %u is listening\n", primary, standby);
+    return shadow_verdict(reuse, SK_DROP);
+}
+
+char _license[] SEC("license") = "GPL";
@@ -0,0 +1,259 @@
+//go:build ignore
+
+#include "vmlinux.h"
+#include <bpf/bpf_helpers.h>
+#include <bpf/bpf_endian.h>
+#include "ratelimit.h"
+
+/*
+ * Join the shortest queue. acceptqueue.c compares the backlog the accept
+ * queue tracker last saw for each listener, a sample taken on whatever CPU
+ * completed the previous handshake. Here each listening slot's queue is
+ * counted exactly instead, by a sock_ops program attached to the root
+ * cgroup: a connection joins its slot's queue when its handshake completes
+ * and leaves it when it closes. sock_ops sees no accept(), so the depth is
+ * connections waiting to be accepted plus those being served, which is the
+ * queue JSQ wants anyway.
+ *
+ * The selector strictly picks a slot with the fewest open connections. Ties
+ * go to a random one of them or, with tie_break set to JSQ_TIE_ROUND_ROBIN,
+ * rotate through them. sock_ops only sees the child socket, so the selector
+ * leaves its choice in jsq_pending under the client's address and port for
+ * the handshake to find. Only IPv4 clients are counted; IPv6 connections are
+ * placed by the same rule but never join a queue. Only the first
+ * JSQ_MAX_SLOTS slots take part.
+ */
+#define JSQ_MAX_SLOTS 64
+
+enum jsq_tie_break {
+    JSQ_TIE_RANDOM = 0,
+    JSQ_TIE_ROUND_ROBIN = 1,
+};
+
+struct jsq_cfg {
+    __u32 tie_break;
+};
+
+struct jsq_flow {
+    __u32 saddr; /* network byte order */
+    __u16 sport; /* network byte order */
+    __u16 pad;
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, struct jsq_cfg);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} jsq_cfg SEC(".maps");
+
+/* Open connections of each slot. */
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 128);
+    __type(key, __u32);
+    __type(value, __u64);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} jsq_depth SEC(".maps");
+
+/* Round-robin position among tied slots. */
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, __u64);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} jsq_rr SEC(".maps");
+
+/* Slot picked for a handshake in progress. */
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __uint(max_entries, 4096);
+    __type(key, struct jsq_flow);
+    __type(value, __u32);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} jsq_pending SEC(".maps");
+
+/* Slot + 1 of each counted connection, so closing it leaves the right queue. */
+struct {
+    __uint(type, BPF_MAP_TYPE_SK_STORAGE);
+    __uint(map_flags, BPF_F_NO_PREALLOC);
+    __type(key, int);
+    __type(value, __u32);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} jsq_conn SEC(".maps");
+
+/* External maps shared with other programs */
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 128);
+    __type(key, __u32);
+    __type(value, __u64);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} acceptq_slot_cookies SEC(".maps");
+
+#define JSQ_ABSENT ~0ULL
+
+/* Open connections of slot, or JSQ_ABSENT if it has no listener. A global
+ * function, so that the verifier checks it once instead of in every
+ * iteration of the selector's loop. */
+__noinline __u64 jsq_slot(__u32 slot)
+{
+    __u64 *cookie = bpf_map_lookup_elem(&acceptq_slot_cookies, &slot);
+    if (!cookie || *cookie == 0)
+        return JSQ_ABSENT;
+    __u64 *d = bpf_map_lookup_elem(&jsq_depth, &slot);
+    return d ? *d : 0;
+}
+
+/* Remember slot for the handshake of the connection being placed. */
+static __always_inline void jsq_remember(struct sk_reuseport_md *reuse, __u32 slot)
+{
+    if (reuse->eth_protocol != bpf_htons(RL_ETH_P_IP))
+        return;
+    struct jsq_flow flow = {};
+    if (bpf_skb_load_bytes_relative(reuse, RL_IPV4_SADDR_OFF, &flow.saddr, sizeof(flow.saddr), BPF_HDR_START_NET))
+        return;
+    /* The lookup runs with skb->data at the TCP header: source port first. */
+    if (bpf_skb_load_bytes(reuse, 0, &flow.sport, sizeof(flow.sport)))
+        return;
+    bpf_map_update_elem(&jsq_pending, &flow, &slot, BPF_ANY);
+}
+
+/* Select the tie_break'th of the n members of mask, trying the others if
+ * it has no listener. Returns the slot taken, or -1. A global function:
+ * the verifier knows nothing of mask here, where inlined it would tell
+ * apart every set of slots the selector's loop could build. */
+__noinline int jsq_try(struct sk_reuseport_md *reuse, __u64 mask, __u32 n, __u32 tie_break)
+{
+    if (n == 0)
+        return -1;
+    __u32 k;
+    if (tie_break == JSQ_TIE_ROUND_ROBIN) {
+        __u32 k0 = 0;
+        __u64 *rr = bpf_map_lookup_elem(&jsq_rr, &k0);
+        k = rr ? __sync_fetch_and_add(rr, 1) % n : 0;
+    } else {
+        k = bpf_get_prandom_u32() % n;
+    }
+
+    __u32 seen = 0, start = 0;
+    for (__u32 i = 0; i < JSQ_MAX_SLOTS; i++) {
+        if (!(mask & (1ULL << i)))
+            continue;
+        if (seen++ == k) {
+            start = i;
+            break;
+        }
+    }
+    for (__u32 i = 0; i < JSQ_MAX_SLOTS; i++) {
+        __u32 slot = (start + i) & (JSQ_MAX_SLOTS - 1);
+        if (!(mask & (1ULL << slot)))
+            continue;
+        if (slot_select(reuse, &slot) == 0)
+            return slot;
+    }
+    return -1;
+}
+
+SEC("sk_reuseport/selector")
+enum sk_action jsq_selector(struct sk_reuseport_md *reuse)
+{
+    enum sk_action verdict;
+    if (ratelimit_apply(reuse, &verdict))
+        return verdict;
+
+    __u32 k0 = 0;
+    struct jsq_cfg *cfg = bpf_map_lookup_elem(&jsq_cfg, &k0);
+    __u32 tie_break = cfg ? cfg->tie_break : JSQ_TIE_RANDOM;
+
+    __u64 shortest = ~0ULL, tied = 0, live = 0;
+    __u32 n = 0, nlive = 0;
+    for (__u32 slot = 0; slot < JSQ_MAX_SLOTS; slot++) {
+        __u64 depth = jsq_slot(slot);
+        if (depth == JSQ_ABSENT)
+            continue;
+        live |= 1ULL << slot;
+        nlive++;
+        if (depth < shortest) {
+            shortest = depth;
+            tied = 0;
+            n = 0;
+        }
+        if (depth == shortest) {
+            tied |= 1ULL << slot;
+            n++;
+        }
+    }
+
+    /* A tied slot that stopped listening hands over to the rest. */
+    int slot = jsq_try(reuse, tied, n, tie_break);
+    if (slot < 0)
+        slot = jsq_try(reuse, live, nlive, JSQ_TIE_RANDOM);
+    if (slot >= 0) {
+        jsq_remember(reuse, slot);
+        return SK_PASS;
+    }
+
+    sl_trace("jsq: no slot is listening\n");
+    return shadow_verdict(reuse, SK_DROP);
+}
+
+/* remote_port is the port in network byte order, shifted into the upper
+ * half on kernels before 5.10. A port is never 0, so either half tells. */
+static __always_inline __u16 jsq_remote_port(struct bpf_sock_ops *skops)
+{
+    __u32 p = skops->remote_port;
+    return p > 0xFFFF ? p >> 16 : p;
+}
+
+SEC("sockops")
+int jsq_sockops(struct bpf_sock_ops *skops)
+{
+    /* Checked once: the verifier takes every read of skops->sk for a
+     * pointer that may be NULL. */
+    struct bpf_sock *sk = skops->sk;
+    if (!sk)
+        return 1;
+    switch (skops->op) {
+    case BPF_SOCK_OPS_PASSIVE_ESTABLISHED_CB: {
+        if (skops->family != 2 /* AF_INET */)
+            return 1;
+        struct jsq_flow flow = {
+            .saddr = skops->remote_ip4,
+            .sport = jsq_remote_port(skops),
+        };
+        __u32 *slot = bpf_map_lookup_elem(&jsq_pending, &flow);
+        if (!slot)
+            return 1; /* not placed by this group's selector */
+        __u32 s = *slot;
+        bpf_map_delete_elem(&jsq_pending, &flow);
+
+        __u32 *conn = bpf_sk_storage_get(&jsq_conn, sk, 0, BPF_SK_STORAGE_GET_F_CREATE);
+        __u64 *depth = bpf_map_lookup_elem(&jsq_depth, &s);
+        if (!conn || !depth)
+            return 1;
+        *conn = s + 1;
+        __sync_fetch_and_add(depth, 1);
+        bpf_sock_ops_cb_flags_set(skops, BPF_SOCK_OPS_STATE_CB_FLAG);
+        break;
+    }
+    case BPF_SOCK_OPS_STATE_CB: {
+        if (skops->args[1] != BPF_TCP_CLOSE)
+            return 1;
+        __u32 *conn = bpf_sk_storage_get(&jsq_conn, sk, 0, 0);
+        if (!conn || *conn == 0)
+            return 1;
+        __u32 s = *conn - 1;
+        *conn = 0;
+        __u64 *depth = bpf_map_lookup_elem(&jsq_depth, &s);
+        if (depth && *depth > 0)
+            __sync_fetch_and_add(depth, -1);
+        break;
+    }
+    }
+    return 1;
+}
+
+char _license[] SEC("license") = "GPL";
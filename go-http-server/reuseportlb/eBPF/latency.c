@@ -0,0 +1,108 @@
+//go:build ignore
+
+#include "vmlinux.h"
+#include <bpf/bpf_helpers.h>
+#include <bpf/bpf_core_read.h>
+#include <bpf/bpf_tracing.h>
+
+/*
+ * Accept-to-response latency, aggregated in the kernel. inet_csk_accept
+ * stamps every accepted socket with the time and the cookie of the listener
+ * it came from; the socket's first tcp_sendmsg closes the interval and bumps
+ * a log2 bucket in the listener's histogram. Userspace maps listener cookies
+ * to slots, so nothing is reported per event.
+ *
+ * The hooks are fentry/fexit rather than kprobes so the object needs no
+ * per-architecture register access. inet_csk_accept changed its arguments
+ * in 6.10, so its return value is read with bpf_get_func_ret (5.17+).
+ */
+#define LAT_BUCKETS 32
+
+struct lat_start {
+    __u64 ts;
+    __u64 listener; /* cookie of the listener the socket was accepted from */
+};
+
+/* Bucket i counts latencies in [2^i, 2^(i+1)) microseconds; bucket 0 also
+ * counts everything below 1us. */
+struct lat_hist {
+    __u64 buckets[LAT_BUCKETS];
+    __u64 count;
+    __u64 sum_ns;
+};
+
+/* Accepted sockets that have not responded yet, by struct sock address. */
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __uint(max_entries, 65536);
+    __type(key, __u64);
+    __type(value, struct lat_start);
+} lat_start SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 1024);
+    __type(key, __u64); /* listener cookie */
+    __type(value, struct lat_hist);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} lat_hist SEC(".maps");
+
+static __always_inline __u32 log2_u64(__u64 v)
+{
+    __u32 r = 0;
+    for (int i = 0; i < 63; i++) {
+        if (v <= 1)
+            break;
+        v >>= 1;
+        r++;
+    }
+    return r;
+}
+
+SEC("fexit/inet_csk_accept")
+int lat_accept(__u64 *ctx)
+{
+    __u64 ret;
+    if (bpf_get_func_ret(ctx, &ret) || ret == 0)
+        return 0;
+
+    struct sock *listener = (struct sock *)ctx[0];
+    __u64 cookie = BPF_CORE_READ(listener, __sk_common.skc_cookie.counter);
+    if (cookie == 0)
+        return 0;
+
+    struct lat_start s = { .ts = bpf_ktime_get_ns(), .listener = cookie };
+    bpf_map_update_elem(&lat_start, &ret, &s, BPF_ANY);
+    return 0;
+}
+
+SEC("fentry/tcp_sendmsg")
+int BPF_PROG(lat_first_send, struct sock *sk)
+{
+    __u64 key = (__u64)sk;
+    struct lat_start *s = bpf_map_lookup_elem(&lat_start, &key);
+    if (!s)
+        return 0;
+
+    __u64 delta = bpf_ktime_get_ns() - s->ts;
+    __u64 listener = s->listener;
+    bpf_map_delete_elem(&lat_start, &key);
+
+    struct lat_hist *h = bpf_map_lookup_elem(&lat_hist, &listener);
+    if (!h) {
+        struct lat_hist zero = {};
+        bpf_map_update_elem(&lat_hist, &listener, &zero, BPF_NOEXIST);
+        h = bpf_map_lookup_elem(&lat_hist, &listener);
+        if (!h)
+            return 0;
+    }
+    __u32 b = log2_u64(delta / 1000);
+    if (b >= LAT_BUCKETS)
+        b = LAT_BUCKETS - 1;
+    __sync_fetch_and_add(&h->buckets[b], 1);
+    __sync_fetch_and_add(&h->count, 1);
+    __sync_fetch_and_add(&h->sum_ns, delta);
+    return 0;
+}
+
+char _license[] SEC("license") = "GPL";
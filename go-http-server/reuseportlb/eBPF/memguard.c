@@ -0,0 +1,108 @@
+//go:build ignore
+
+#include "vmlinux.h"
+#include <bpf/bpf_helpers.h>
+#include "ratelimit.h"
+#include "policycfg.h"
+
+/*
+ * Keep new connections off instances short of memory. The collector
+ * publishes each slot owner's memory use and memory pressure in slot_mem; a
+ * slot over mem_limit_mb, or whose memory.pressure "some avg10" is at
+ * pressure_pct or more, is skipped while any other slot is under both, so an
+ * instance heading for reclaim or the OOM killer stops taking on work.
+ * Among the slots that are fine, connections spread by their hash the way
+ * the kernel would spread them. When every slot is over, the one using the
+ * least memory gets the connection. Slots the collector has no entry for
+ * count as fine. Only the first MEM_MAX_SLOTS slots take part.
+ */
+#define MEM_MAX_SLOTS 64
+
+enum memguard_param {
+    MEM_PARAM_LIMIT_MB = 0,     /* memory use at which a slot is skipped */
+    MEM_PARAM_PRESSURE_PCT = 1, /* memory.pressure some avg10 at which it is */
+};
+
+struct slot_mem {
+    __u64 bytes;    /* cgroup memory.current, or the owner's RSS */
+    __u32 pressure; /* some avg10 in hundredths of a percent, 0 if unknown */
+    __u32 pad;
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 128);
+    __type(key, __u32);
+    __type(value, struct slot_mem);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} slot_mem SEC(".maps");
+
+/* External maps shared with other programs */
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 128);
+    __type(key, __u32);
+    __type(value, __u64);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} acceptq_slot_cookies SEC(".maps");
+
+#define MEM_ABSENT ~0ULL     /* nothing listens on the slot */
+#define MEM_OVER   (1ULL << 63) /* over mem_limit_mb or pressure_pct */
+
+/*
+ * The memory use of slot's owner, with MEM_OVER set if it is short of
+ * memory, or MEM_ABSENT. A global function, so that the verifier checks it
+ * once instead of in every iteration of the selector's loop.
+ */
+__noinline __u64 memguard_slot(__u32 slot, __u64 limit, __u64 pressure)
+{
+    __u64 *cookie = bpf_map_lookup_elem(&acceptq_slot_cookies, &slot);
+    if (!cookie || *cookie == 0)
+        return MEM_ABSENT;
+    struct slot_mem *m = bpf_map_lookup_elem(&slot_mem, &slot);
+    if (!m)
+        return 0;
+    __u64 bytes = m->bytes & ~MEM_OVER;
+    if (bytes >= limit || m->pressure >= pressure)
+        return bytes | MEM_OVER;
+    return bytes;
+}
+
+SEC("sk_reuseport/selector")
+enum sk_action memguard_selector(struct sk_reuseport_md *reuse)
+{
+    enum sk_action verdict;
+    if (ratelimit_apply(reuse, &verdict))
+        return verdict;
+
+    __u64 limit = policy_param(MEM_PARAM_LIMIT_MB, 1024) << 20;
+    __u64 pressure = policy_param(MEM_PARAM_PRESSURE_PCT, 10) * 100;
+
+    __u32 start = reuse->hash % MEM_MAX_SLOTS;
+    __u32 least = 0;
+    __u64 least_mem = MEM_ABSENT;
+    for (__u32 i = 0; i < MEM_MAX_SLOTS; i++) {
+        __u32 slot = (start + i) % MEM_MAX_SLOTS;
+        __u64 mem = memguard_slot(slot, limit, pressure);
+        if (mem == MEM_ABSENT)
+            continue;
+        if (!(mem & MEM_OVER)) {
+            if (slot_select(reuse, &slot) == 0)
+                return SK_PASS;
+            continue; /* gone since */
+        }
+        if (mem < least_mem) {
+            least = slot;
+            least_mem = mem;
+        }
+    }
+
+    /* Every slot is short of memory, or the ones that are not are gone. */
+    if (least_mem != MEM_ABSENT && slot_select(reuse, &least) == 0)
+        return SK_PASS;
+
+    sl_trace("memguard: no slot is listening\n");
+    return shadow_verdict(reuse, SK_DROP);
+}
+
+char _license[] SEC("license") = "GPL";
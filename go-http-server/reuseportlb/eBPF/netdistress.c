@@ -0,0 +1,94 @@
+//go:build ignore
+
+#include "vmlinux.h"
+#include <bpf/bpf_helpers.h>
+#include <bpf/bpf_core_read.h>
+#include <bpf/bpf_tracing.h>
+
+/*
+ * Network distress per listener: how often the connections accepted from
+ * it retransmit, and how often they cut their congestion window because
+ * the path marked them with ECN (or the local qdisc pushed back). Either
+ * means packets are not getting through, which an instance's CPU and queue
+ * signals do not show: a NUMA node whose memory bus is saturated drops in
+ * softirq while its cores look idle.
+ *
+ * inet_csk_accept tags every accepted socket with its listener's cookie;
+ * the tcp_retransmit_skb tracepoint and tcp_enter_cwr then count against
+ * it. Userspace maps listener cookies to slots, as with lat_hist.
+ */
+
+struct nd_owner {
+    __u64 listener; /* cookie of the listener it was accepted from */
+};
+
+struct net_distress {
+    __u64 retrans; /* segments retransmitted */
+    __u64 ecn;     /* congestion window reductions */
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_SK_STORAGE);
+    __uint(map_flags, BPF_F_NO_PREALLOC);
+    __type(key, int);
+    __type(value, struct nd_owner);
+} nd_owner SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 1024);
+    __type(key, __u64); /* listener cookie */
+    __type(value, struct net_distress);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} net_distress SEC(".maps");
+
+static __always_inline struct net_distress *distress_of(struct sock *sk)
+{
+    struct nd_owner *o = bpf_sk_storage_get(&nd_owner, sk, 0, 0);
+    if (!o || o->listener == 0)
+        return 0;
+    return bpf_map_lookup_elem(&net_distress, &o->listener);
+}
+
+SEC("fexit/inet_csk_accept")
+int nd_accept(__u64 *ctx)
+{
+    __u64 ret;
+    if (bpf_get_func_ret(ctx, &ret) || ret == 0)
+        return 0;
+
+    struct sock *listener = (struct sock *)ctx[0];
+    __u64 cookie = BPF_CORE_READ(listener, __sk_common.skc_cookie.counter);
+    if (cookie == 0)
+        return 0;
+
+    struct nd_owner *o = bpf_sk_storage_get(&nd_owner, (struct sock *)ret, 0, BPF_SK_STORAGE_GET_F_CREATE);
+    if (!o)
+        return 0;
+    o->listener = cookie;
+    if (!bpf_map_lookup_elem(&net_distress, &cookie)) {
+        struct net_distress zero = {};
+        bpf_map_update_elem(&net_distress, &cookie, &zero, BPF_NOEXIST);
+    }
+    return 0;
+}
+
+SEC("tp_btf/tcp_retransmit_skb")
+int BPF_PROG(nd_retransmit, struct sock *sk, struct sk_buff *skb)
+{
+    struct net_distress *d = distress_of(sk);
+    if (d)
+        __sync_fetch_and_add(&d->retrans, 1);
+    return 0;
+}
+
+SEC("fentry/tcp_enter_cwr")
+int BPF_PROG(nd_cwr, struct sock *sk)
+{
+    struct net_distress *d = distress_of(sk);
+    if (d)
+        __sync_fetch_and_add(&d->ecn, 1);
+    return 0;
+}
+
+char _license[] SEC("license") = "GPL";
@@ -2,14 +2,7 @@
 
 #include "vmlinux.h"
 #include <bpf/bpf_helpers.h>
-
-struct {
-    __uint(type, BPF_MAP_TYPE_REUSEPORT_SOCKARRAY);
-    __uint(max_entries, 128);
-    __type(key, __u32);
-    __type(value, __u64); // socket FD
-    __uint(pinning, LIBBPF_PIN_BY_NAME);
-} tcp_balancing_targets SEC(".maps");
+#include "ratelimit.h"
 
 /*
  * Always choose key 0 in the reuseport sockarray.
@@ -18,15 +11,19 @@ struct {
 SEC("sk_reuseport/selector")
 enum sk_action pickfirst(struct sk_reuseport_md *reuse)
 {
-    __u32 key0 = 2;
+    enum sk_action verdict;
+    if (ratelimit_apply(reuse, &verdict))
+        return verdict;
+
+    __u32 key0 = 0;
 
-    if (bpf_sk_select_reuseport(reuse, &tcp_balancing_targets, &key0, 0) == 0) {
+    if (slot_select(reuse, &key0) == 0) {
         // Successfully selected socket at index 0
         return SK_PASS;
     }
 
     // Could not select key 0 (not present or doesn't match tuple) -> drop.
-    return SK_DROP;
+    return shadow_verdict(reuse, SK_DROP);
 }
 
 char _license[] SEC("license") = "GPL";
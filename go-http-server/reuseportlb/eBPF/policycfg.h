@@ -0,0 +1,28 @@
+/*
+ * Tunables of a selector that used to be compiled in. policy_cfg is a plain
+ * array of numbers indexed by the policy's own parameter enum; userspace
+ * fills it when the policy is loaded and may change it at any time (see
+ * params.go, which must agree on the indices). An entry left at 0 means the
+ * policy's default, so a program whose config was never written behaves as
+ * it always did.
+ */
+#ifndef __POLICYCFG_H
+#define __POLICYCFG_H
+
+#define POLICY_CFG_MAX 16
+
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, POLICY_CFG_MAX);
+    __type(key, __u32);
+    __type(value, __u64);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} policy_cfg SEC(".maps");
+
+static __always_inline __u64 policy_param(__u32 idx, __u64 def)
+{
+    __u64 *v = bpf_map_lookup_elem(&policy_cfg, &idx);
+    return v && *v ? *v : def;
+}
+
+#endif /* __POLICYCFG_H */
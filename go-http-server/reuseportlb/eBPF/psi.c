@@ -0,0 +1,108 @@
+//go:build ignore
+
+#include "vmlinux.h"
+#include <bpf/bpf_helpers.h>
+#include "ratelimit.h"
+#include "policycfg.h"
+
+/*
+ * Keep new connections off instances that are stalled on CPU. High
+ * utilization alone says little: a server at 90% that gets the CPU as soon
+ * as it wants it is fine. Pressure stall information says whether it does.
+ * The collector publishes in slot_psi the share of the last interval each
+ * slot owner's cgroup had a runnable task waiting for a CPU (cpu.pressure
+ * "some"), or the host's share (/proc/pressure/cpu) for owners without a
+ * cgroup of their own. A slot at stall_pct or more is skipped while any
+ * other slot is under it; among those, connections spread by their hash
+ * the way the kernel would spread them. When every slot is stalled, the
+ * least stalled one gets the connection. Slots without a fresh entry count
+ * as not stalled. Only the first PSI_MAX_SLOTS slots take part.
+ */
+#define PSI_MAX_SLOTS 64
+#define PSI_STALE_NS  1000000000ULL
+
+enum psi_param {
+    PSI_PARAM_STALL_PCT = 0, /* CPU pressure at which a slot is skipped */
+};
+
+struct slot_psi {
+    __u64 updated_ns; /* bpf_ktime_get_ns clock */
+    __u32 some;       /* stalled share, in hundredths of a percent */
+    __u32 host;       /* 1 if some is the host's, the owner having no cgroup */
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 128);
+    __type(key, __u32);
+    __type(value, struct slot_psi);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} slot_psi SEC(".maps");
+
+/* External maps shared with other programs */
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 128);
+    __type(key, __u32);
+    __type(value, __u64);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} acceptq_slot_cookies SEC(".maps");
+
+#define PSI_ABSENT  ~0ULL      /* nothing listens on the slot */
+#define PSI_STALLED (1ULL << 32) /* at stall_pct or more */
+
+/*
+ * The CPU pressure of slot's owner, with PSI_STALLED set if it is stalled,
+ * or PSI_ABSENT. A global function, so that the verifier checks it once
+ * instead of in every iteration of the selector's loop.
+ */
+__noinline __u64 psi_slot(__u32 slot, __u64 stall, __u64 now)
+{
+    __u64 *cookie = bpf_map_lookup_elem(&acceptq_slot_cookies, &slot);
+    if (!cookie || *cookie == 0)
+        return PSI_ABSENT;
+    struct slot_psi *p = bpf_map_lookup_elem(&slot_psi, &slot);
+    if (!p || p->updated_ns == 0 || now - p->updated_ns >= PSI_STALE_NS)
+        return 0;
+    __u64 some = p->some;
+    return some >= stall ? some | PSI_STALLED : some;
+}
+
+SEC("sk_reuseport/selector")
+enum sk_action psi_selector(struct sk_reuseport_md *reuse)
+{
+    enum sk_action verdict;
+    if (ratelimit_apply(reuse, &verdict))
+        return verdict;
+
+    __u64 stall = policy_param(PSI_PARAM_STALL_PCT, 20) * 100;
+    __u64 now = bpf_ktime_get_ns();
+
+    __u32 start = reuse->hash % PSI_MAX_SLOTS;
+    __u32 least = 0;
+    __u64 least_psi = PSI_ABSENT;
+    for (__u32 i = 0; i < PSI_MAX_SLOTS; i++) {
+        __u32 slot = (start + i) % PSI_MAX_SLOTS;
+        __u64 psi = psi_slot(slot, stall, now);
+        if (psi == PSI_ABSENT)
+            continue;
+        if (!(psi & PSI_STALLED)) {
+            if (slot_select(reuse, &slot) == 0)
+                return SK_PASS;
+            continue; /* gone since */
+        }
+        if (psi < least_psi) {
+            least = slot;
+            least_psi = psi;
+        }
+    }
+
+    /* Every slot is stalled, or the ones that are not are gone. */
+    if (least_psi != PSI_ABSENT && slot_select(reuse, &least) == 0)
+        return SK_PASS;
+
+    sl_trace("psi: no slot is listening\n");
+    return shadow_verdict(reuse, SK_DROP);
+}
+
+char _license[] SEC("license") = "GPL";
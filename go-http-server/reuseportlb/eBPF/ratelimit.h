@@ -0,0 +1,421 @@
+/*
+ * Per-source connection rate limiting shared by all selectors.
+ *
+ * Every selector calls ratelimit_apply() before running its own policy. Each
+ * IPv4 source gets a fixed-window connection counter in an LRU map; once a
+ * source exceeds max_conns within window_ns, its new connections are either
+ * dropped or steered to penalty_slot, depending on the configured action
+ * (falling back to normal selection when penalty_slot is empty).
+ * The limiter is off until userspace writes an enabled config.
+ *
+ * Independently, each slot can have a token bucket capping the rate of new
+ * connections it receives, whatever the policy. Selectors place connections
+ * with slot_select() instead of bpf_sk_select_reuseport(); a slot whose
+ * bucket is empty either passes the connection on to the next slot with
+ * tokens left (SL_ACTION_REDISTRIBUTE) or has it dropped (SL_ACTION_DROP).
+ * A slot with rate 0 is unlimited.
+ *
+ * A slot can also be warming up: for window_ns after userspace starts its
+ * warm-up (when a new instance registers), the slot takes only the share of
+ * the connections placed on it that the elapsed fraction of the window
+ * allows, ramping from none to all, and passes the rest on as a throttled
+ * slot would. With nowhere else to go they stay on the warming slot.
+ *
+ * Before either, slot_override can pin a client address to a slot, so that
+ * tests and debugging sessions know where a client's connections land. An
+ * override beats the limiter and the buckets; it only lets go while its
+ * slot has no listener.
+ *
+ * Each of these, like shadow mode, the slot_selected counts and tracing,
+ * only runs in a selector loaded with its bit in sl_features: the loader
+ * sets it before the program is verified, so the verifier prunes the code
+ * of the features left out and a SYN only pays for those its group uses.
+ */
+#ifndef __RATELIMIT_H
+#define __RATELIMIT_H
+
+#include <bpf/bpf_endian.h>
+
+#define SL_FEAT_OVERRIDE     (1 << 0) /* slot_override */
+#define SL_FEAT_SOURCE_LIMIT (1 << 1) /* ratelimit_cfg and src_rate */
+#define SL_FEAT_SLOT_LIMITS  (1 << 2) /* slot_bucket */
+#define SL_FEAT_WARMUP       (1 << 3) /* slot_warmup */
+#define SL_FEAT_SHADOW       (1 << 4) /* shadow.h */
+#define SL_FEAT_COUNTS       (1 << 5) /* slot_selected */
+#define SL_FEAT_TRACE        (1 << 6) /* sl_trace() */
+
+/* Set by userspace before loading (Features in features.go). */
+volatile const __u32 sl_features = 0;
+
+/* bpf_printk() to trace_pipe, for selectors loaded with SL_FEAT_TRACE only:
+ * a trace line per SYN costs more than the selection itself. */
+#define sl_trace(fmt, args...)           \
+    ({                                   \
+        if (sl_features & SL_FEAT_TRACE) \
+            bpf_printk(fmt, ##args);     \
+    })
+
+#include "shadow.h"
+
+#define RL_ETH_P_IP 0x0800
+#define RL_IPV4_SADDR_OFF 12
+#define RL_ETH_P_IPV6 0x86DD
+#define RL_IPV6_SADDR_OFF 8
+#define SL_MAX_SLOTS 128 /* entries in tcp_balancing_targets and the slot maps */
+#define SL_NSEC 1000000000ULL
+#define SL_WARMUP_STEPS 1024
+
+enum ratelimit_action {
+    RL_ACTION_DROP = 0,
+    RL_ACTION_DEPRIORITIZE = 1,
+};
+
+enum slot_limit_action {
+    SL_ACTION_REDISTRIBUTE = 0,
+    SL_ACTION_DROP = 1,
+};
+
+/* The sockarray every selector places connections in, by slot. */
+struct {
+    __uint(type, BPF_MAP_TYPE_REUSEPORT_SOCKARRAY);
+    __uint(max_entries, SL_MAX_SLOTS);
+    __type(key, __u32);
+    __type(value, __u64); // userspace still writes an int fd
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} tcp_balancing_targets SEC(".maps");
+
+struct ratelimit_cfg {
+    __u32 enabled;
+    __u32 action;
+    __u32 penalty_slot;
+    __u32 max_conns;
+    __u64 window_ns;
+};
+
+struct src_rate {
+    __u64 window_start;
+    __u32 count;   /* connections seen in the current window */
+    __u32 limited; /* connections dropped or deprioritized, ever */
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, struct ratelimit_cfg);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} ratelimit_cfg SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __uint(max_entries, 4096);
+    __type(key, __u32); /* IPv4 source address, network byte order */
+    __type(value, struct src_rate);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} src_rate SEC(".maps");
+
+struct slot_override {
+    __u32 slot;
+    __u32 hits; /* connections it placed */
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 1024);
+    __type(key, __u8[16]); /* client address, IPv4 mapped into IPv6 */
+    __type(value, struct slot_override);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} slot_override SEC(".maps");
+
+struct slot_bucket {
+    __u64 rate;  /* connections per second; 0 means unlimited */
+    __u64 burst; /* bucket size in connections, at least 1 */
+    __u32 action;
+    __u32 pad;
+    __u64 tokens;    /* in billionths of a connection */
+    __u64 last_ns;   /* when tokens was last refilled */
+    __u64 throttled; /* connections the bucket turned away, ever */
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, SL_MAX_SLOTS);
+    __type(key, __u32);
+    __type(value, struct slot_bucket);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} slot_bucket SEC(".maps");
+
+struct slot_warmup {
+    __u64 start_ns;  /* when the slot started warming up, 0 if it never did */
+    __u64 window_ns; /* how long it ramps up for; 0 means no ramp */
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, SL_MAX_SLOTS);
+    __type(key, __u32);
+    __type(value, struct slot_warmup);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} slot_warmup SEC(".maps");
+
+/* Connections placed on each slot, per CPU, by whatever placed them: the
+ * policy, a redistribution, an override or the limiter's penalty slot. The
+ * daemon's watchdog sums the CPUs to spot a selector gone lopsided. */
+struct {
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+    __uint(max_entries, SL_MAX_SLOTS);
+    __type(key, __u32);
+    __type(value, __u64);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} slot_selected SEC(".maps");
+
+/* Set once a bucket has condemned the connection being placed, so that the
+ * selector's fallbacks fail too. Selectors run with migration disabled, and
+ * ratelimit_apply() clears it for each connection. */
+struct {
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, __u32);
+} slot_dropping SEC(".maps");
+
+/* Count a connection placed on slot in slot_selected. */
+static __always_inline void slot_count(__u32 slot)
+{
+    if (!(sl_features & SL_FEAT_COUNTS))
+        return;
+    __u64 *n = bpf_map_lookup_elem(&slot_selected, &slot);
+    if (n)
+        (*n)++;
+}
+
+/* Refill b and report whether it holds a whole connection's worth. */
+static __always_inline int slot_has_token(struct slot_bucket *b, __u64 now)
+{
+    __u64 cap = (b->burst ? b->burst : 1) * SL_NSEC;
+    __u64 elapsed = now - b->last_ns;
+    b->last_ns = now;
+    if (elapsed >= cap / b->rate)
+        b->tokens = cap;
+    else if (b->tokens + elapsed * b->rate > cap)
+        b->tokens = cap;
+    else
+        b->tokens += elapsed * b->rate;
+    return b->tokens >= SL_NSEC;
+}
+
+/* Report whether slot, while warming up, turns this connection away. */
+static __always_inline int slot_warming(__u32 slot, __u64 now)
+{
+    if (!(sl_features & SL_FEAT_WARMUP))
+        return 0;
+    struct slot_warmup *w = bpf_map_lookup_elem(&slot_warmup, &slot);
+    if (!w || w->window_ns == 0 || w->start_ns == 0)
+        return 0;
+    __u64 elapsed = now - w->start_ns;
+    if (elapsed >= w->window_ns)
+        return 0;
+    __u64 share = elapsed * SL_WARMUP_STEPS / w->window_ns;
+    return (bpf_get_prandom_u32() % SL_WARMUP_STEPS) >= share;
+}
+
+/* The slot's token bucket, if it has a limit. */
+static __always_inline struct slot_bucket *slot_limit(__u32 slot)
+{
+    if (!(sl_features & SL_FEAT_SLOT_LIMITS))
+        return NULL;
+    struct slot_bucket *b = bpf_map_lookup_elem(&slot_bucket, &slot);
+    return b && b->rate ? b : NULL;
+}
+
+/*
+ * Hand the connection to the first slot after slot with a token to spare
+ * that is not warming up. Returns that slot, or -1.
+ *
+ * This and slot_place() are global functions so that the verifier checks
+ * them once, not once per call site and per loop iteration of every
+ * selector; hence they take and return plain scalars.
+ */
+__noinline int slot_redistribute(struct sk_reuseport_md *reuse, __u32 slot, __u64 now)
+{
+    for (__u32 i = 1; i < SL_MAX_SLOTS; i++) {
+        __u32 next = (slot + i) & (SL_MAX_SLOTS - 1);
+        if (slot_warming(next, now))
+            continue;
+        struct slot_bucket *b = slot_limit(next);
+        if (b && !slot_has_token(b, now))
+            continue;
+        if (bpf_sk_select_reuseport(reuse, &tcp_balancing_targets, &next, 0) != 0)
+            continue;
+        if (b)
+            b->tokens -= SL_NSEC;
+        return next;
+    }
+    return -1;
+}
+
+/*
+ * bpf_sk_select_reuseport() subject to the slot's warm-up and token bucket.
+ * Returns the slot that got the connection, or -1.
+ */
+__noinline int slot_place(struct sk_reuseport_md *reuse, __u32 slot)
+{
+    __u32 k0 = 0;
+    __u32 *dropping = NULL;
+    if (sl_features & SL_FEAT_SLOT_LIMITS) {
+        dropping = bpf_map_lookup_elem(&slot_dropping, &k0);
+        if (dropping && *dropping)
+            return -1;
+    }
+
+    __u64 now = 0;
+    if (sl_features & (SL_FEAT_SLOT_LIMITS | SL_FEAT_WARMUP))
+        now = bpf_ktime_get_ns();
+    if (slot_warming(slot, now)) {
+        int next = slot_redistribute(reuse, slot, now);
+        if (next >= 0) {
+            slot_count(next);
+            return next;
+        }
+    }
+
+    struct slot_bucket *b = slot_limit(slot);
+    if (!b || slot_has_token(b, now)) {
+        if (bpf_sk_select_reuseport(reuse, &tcp_balancing_targets, &slot, 0) != 0)
+            return -1;
+        if (b)
+            b->tokens -= SL_NSEC;
+        slot_count(slot);
+        return slot;
+    }
+
+    __sync_fetch_and_add(&b->throttled, 1);
+    if (b->action == SL_ACTION_REDISTRIBUTE) {
+        int next = slot_redistribute(reuse, slot, now);
+        if (next >= 0) {
+            slot_count(next);
+            return next;
+        }
+    }
+    if (dropping)
+        *dropping = 1;
+    return -1;
+}
+
+/*
+ * bpf_sk_select_reuseport() on tcp_balancing_targets, through slot_place().
+ * Returns 0 once some slot has the connection, nonzero otherwise, like the
+ * helper. A redistributed connection's slot is written back to *slot.
+ */
+static __always_inline long slot_select(struct sk_reuseport_md *reuse, __u32 *slot)
+{
+    if (shadow_candidate()) {
+        long ret = bpf_sk_select_reuseport(reuse, &tcp_balancing_targets, slot, 0);
+        if (ret == 0)
+            shadow_decided(reuse, *slot);
+        return ret;
+    }
+
+    int placed = slot_place(reuse, *slot);
+    if (placed < 0)
+        return -1;
+    *slot = placed;
+    shadow_placed(reuse, *slot);
+    return 0;
+}
+
+/* Place the connection on the slot its client is pinned to, if any. */
+static __always_inline int override_apply(struct sk_reuseport_md *reuse)
+{
+    if (!(sl_features & SL_FEAT_OVERRIDE))
+        return 0;
+    __u8 addr[16] = {};
+    if (reuse->eth_protocol == bpf_htons(RL_ETH_P_IP)) {
+        addr[10] = 0xff;
+        addr[11] = 0xff;
+        if (bpf_skb_load_bytes_relative(reuse, RL_IPV4_SADDR_OFF, &addr[12], 4, BPF_HDR_START_NET))
+            return 0;
+    } else if (reuse->eth_protocol == bpf_htons(RL_ETH_P_IPV6)) {
+        if (bpf_skb_load_bytes_relative(reuse, RL_IPV6_SADDR_OFF, addr, sizeof(addr), BPF_HDR_START_NET))
+            return 0;
+    } else {
+        return 0;
+    }
+
+    struct slot_override *ov = bpf_map_lookup_elem(&slot_override, addr);
+    if (!ov)
+        return 0;
+    __u32 slot = ov->slot;
+    if (bpf_sk_select_reuseport(reuse, &tcp_balancing_targets, &slot, 0) != 0)
+        return 0;
+    __sync_fetch_and_add(&ov->hits, 1);
+    slot_count(slot);
+    shadow_placed(reuse, slot);
+    return 1;
+}
+
+/*
+ * Returns 1 when an override or the limiter has decided the connection's
+ * fate (stored in *action), 0 when the caller should run its normal
+ * selection.
+ */
+static __always_inline int ratelimit_apply(struct sk_reuseport_md *reuse, enum sk_action *action)
+{
+    __u32 k0 = 0;
+    if (sl_features & SL_FEAT_SLOT_LIMITS) {
+        __u32 *dropping = bpf_map_lookup_elem(&slot_dropping, &k0);
+        if (dropping)
+            *dropping = 0;
+    }
+
+    if (shadow_enter(reuse))
+        return 0;
+    if (override_apply(reuse)) {
+        *action = SK_PASS;
+        return 1;
+    }
+
+    if (!(sl_features & SL_FEAT_SOURCE_LIMIT))
+        return 0;
+    struct ratelimit_cfg *cfg = bpf_map_lookup_elem(&ratelimit_cfg, &k0);
+    if (!cfg || !cfg->enabled || cfg->max_conns == 0)
+        return 0;
+    if (reuse->eth_protocol != bpf_htons(RL_ETH_P_IP))
+        return 0;
+
+    __u32 saddr;
+    if (bpf_skb_load_bytes_relative(reuse, RL_IPV4_SADDR_OFF, &saddr, sizeof(saddr), BPF_HDR_START_NET))
+        return 0;
+
+    __u64 now = bpf_ktime_get_ns();
+    struct src_rate *r = bpf_map_lookup_elem(&src_rate, &saddr);
+    if (!r) {
+        struct src_rate fresh = { .window_start = now, .count = 1 };
+        bpf_map_update_elem(&src_rate, &saddr, &fresh, BPF_ANY);
+        return 0;
+    }
+
+    if (now - r->window_start > cfg->window_ns) {
+        r->window_start = now;
+        r->count = 0;
+    }
+    __sync_fetch_and_add(&r->count, 1);
+    if (r->count <= cfg->max_conns)
+        return 0;
+
+    __sync_fetch_and_add(&r->limited, 1);
+    if (cfg->action == RL_ACTION_DEPRIORITIZE) {
+        __u32 slot = cfg->penalty_slot;
+        if (slot_select(reuse, &slot) == 0) {
+            *action = SK_PASS;
+            return 1;
+        }
+        /* No penalty socket to park the source on; serve it normally. */
+        return 0;
+    }
+    sl_trace("ratelimit: dropping connection from 0x%x", saddr);
+    *action = SK_DROP;
+    return 1;
+}
+
+#endif /* __RATELIMIT_H */
@@ -0,0 +1,50 @@
+//go:build ignore
+
+#include "vmlinux.h"
+#include <bpf/bpf_helpers.h>
+#include <bpf/bpf_tracing.h>
+
+/*
+ * CPU time of individual requests. A server tracing request CPU runs each
+ * request on a goroutine locked to its OS thread and lists the thread in
+ * reqcpu_tasks for the request's duration, with the CLOCK_MONOTONIC time it
+ * was listed at (the thread is on CPU then). Every context switch of a
+ * listed thread closes or opens one of its on-CPU intervals; when the
+ * request ends, the server adds the interval still open and removes the
+ * thread. Threads that are not listed, including every other process's,
+ * cost one hash lookup per switch.
+ *
+ * tp_btf/sched_switch needs Linux 5.5.
+ */
+struct reqcpu_task {
+    __u64 cpu_ns; /* on-CPU time of the closed intervals */
+    __u64 since;  /* start of the open interval; 0 while off CPU */
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 16384);
+    __type(key, __u32); /* thread ID */
+    __type(value, struct reqcpu_task);
+} reqcpu_tasks SEC(".maps");
+
+SEC("tp_btf/sched_switch")
+int BPF_PROG(reqcpu_switch, bool preempt, struct task_struct *prev, struct task_struct *next)
+{
+    __u64 now = bpf_ktime_get_ns();
+
+    __u32 tid = prev->pid;
+    struct reqcpu_task *t = bpf_map_lookup_elem(&reqcpu_tasks, &tid);
+    if (t && t->since) {
+        t->cpu_ns += now - t->since;
+        t->since = 0;
+    }
+
+    tid = next->pid;
+    t = bpf_map_lookup_elem(&reqcpu_tasks, &tid);
+    if (t)
+        t->since = now;
+    return 0;
+}
+
+char _license[] SEC("license") = "GPL";
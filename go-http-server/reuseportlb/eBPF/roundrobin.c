@@ -0,0 +1,100 @@
+//go:build ignore
+
+#include "vmlinux.h"
+#include <bpf/bpf_helpers.h>
+#include "ratelimit.h"
+#include "policycfg.h"
+
+#define RR_MAX_SLOTS 128
+
+enum rr_param {
+    RR_PARAM_GROUP_SIZE = 0, /* slots rotated through, from slot 0 */
+    RR_PARAM_PER_CPU = 1,    /* nonzero: a counter per CPU instead of rr */
+};
+
+/*
+ * Round-robin state. counter is the total number of selections made so far;
+ * it is bumped with an atomic fetch-add (BPF_FETCH, needs -mcpu=v3 and a
+ * 5.12+ kernel) so concurrent SYNs on different CPUs never see the same value.
+ */
+struct rr_state {
+    __u64 counter;
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, struct rr_state);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} rr SEC(".maps");
+
+/*
+ * Per-CPU rotation. The shared counter is exact, but every SYN on every CPU
+ * bounces its cache line; with per_cpu set each CPU rotates a counter of
+ * its own instead, starting from a slot offset by its id so that CPUs
+ * taking SYNs at the same moment start on different slots. Each CPU's
+ * share is exact; the group's is off by at most one connection per CPU.
+ */
+struct {
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, __u64);
+} rr_percpu SEC(".maps");
+
+static __always_inline __u64 rr_fetch_inc(struct rr_state *s)
+{
+    return __sync_fetch_and_add(&s->counter, 1);
+}
+
+SEC("sk_reuseport/selector")
+enum sk_action rr_selector(struct sk_reuseport_md *reuse)
+{
+    enum sk_action verdict;
+    if (ratelimit_apply(reuse, &verdict))
+        return verdict;
+
+    __u32 k0 = 0;
+    struct rr_state *st = bpf_map_lookup_elem(&rr, &k0);
+    __u32 n = policy_param(RR_PARAM_GROUP_SIZE, 4);
+    if (n > RR_MAX_SLOTS)
+        n = RR_MAX_SLOTS;
+    if (!st) {
+        sl_trace("rr: no state\n");
+        return shadow_verdict(reuse, SK_DROP);
+    }
+
+    __u32 h = reuse->hash;
+    sl_trace("reuseport: hash=%u\n", h);
+
+    __u64 pos;
+    if (policy_param(RR_PARAM_PER_CPU, 0)) {
+        __u64 *c = bpf_map_lookup_elem(&rr_percpu, &k0);
+        if (!c)
+            return shadow_verdict(reuse, SK_DROP);
+        /* Nothing else touches this CPU's copy while the selector runs. */
+        pos = (*c)++ + bpf_get_smp_processor_id();
+    } else {
+        pos = rr_fetch_inc(st);
+    }
+    __u32 start = pos % n;
+
+    /* Probe up to n entries starting at 'start' */
+    for (__u32 i = 0; i < RR_MAX_SLOTS && i < n; i++) {
+        __u32 slot = start + i;
+        if (slot >= n)
+            slot -= n;
+
+        long ret = slot_select(reuse, &slot);
+        if (ret == 0) {
+            sl_trace("rr: passing on slot = %u\n", slot);
+            return SK_PASS;
+        }
+    }
+
+    sl_trace("rr: all %u slots failed to match\n", n);
+    return shadow_verdict(reuse, SK_DROP);
+}
+
+char _license[] SEC("license") = "GPL";
@@ -0,0 +1,154 @@
+/*
+ * Shadow mode, shared by all selectors through ratelimit.h. A candidate
+ * policy runs ahead of the active one for every connection: the active
+ * selector's prelude tail-calls shadow_progs[SHADOW_PROG_CANDIDATE], the
+ * candidate picks a slot as usual, and as soon as it has decided (a
+ * successful slot_select(), or a verdict without one) it tail-calls
+ * shadow_progs[SHADOW_PROG_ACTIVE], which selects again from scratch and so
+ * overrides the candidate's choice. Once the active selector has placed the
+ * connection, both choices go to shadow_events. Neither the override nor the
+ * limiters apply to the candidate; its own state (counters and the like)
+ * does advance.
+ *
+ * The phase of the connection being placed is kept per CPU, which is safe
+ * because selectors run with migration disabled. With shadow_progs empty,
+ * or without SL_FEAT_SHADOW, every selector runs as if this file did not
+ * exist; both the active selector and the candidate need the feature.
+ */
+#ifndef __SHADOW_H
+#define __SHADOW_H
+
+#define SHADOW_NONE 0xFFFFFFFF /* the candidate placed nothing */
+
+enum shadow_prog {
+    SHADOW_PROG_CANDIDATE = 0,
+    SHADOW_PROG_ACTIVE = 1,
+};
+
+enum shadow_phase {
+    SHADOW_OFF = 0,
+    SHADOW_CANDIDATE = 1, /* the candidate is choosing */
+    SHADOW_RETURNING = 2, /* it chose; the active selector is starting */
+    SHADOW_ACTIVE = 3,    /* the active selector is choosing */
+};
+
+struct shadow_state {
+    __u32 phase;
+    __u32 candidate; /* the candidate's slot, or SHADOW_NONE */
+};
+
+struct shadow_event {
+    __u64 ts_ns;
+    __u32 candidate; /* slot, or SHADOW_NONE */
+    __u32 active;
+    __u32 hash;
+    __u32 pad;
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_PROG_ARRAY);
+    __uint(max_entries, 2);
+    __type(key, __u32);
+    __type(value, __u32);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} shadow_progs SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_RINGBUF);
+    __uint(max_entries, 1 << 16);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} shadow_events SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, struct shadow_state);
+} shadow_scratch SEC(".maps");
+
+/* The phase of this CPU's connection; NULL unless loaded with SL_FEAT_SHADOW. */
+static __always_inline struct shadow_state *shadow_state(void)
+{
+    if (!(sl_features & SL_FEAT_SHADOW))
+        return NULL;
+    __u32 k0 = 0;
+    return bpf_map_lookup_elem(&shadow_scratch, &k0);
+}
+
+/* Whether the candidate is the one running. */
+static __always_inline int shadow_candidate(void)
+{
+    struct shadow_state *st = shadow_state();
+    return st && st->phase == SHADOW_CANDIDATE;
+}
+
+/*
+ * Called first thing by every selector. Runs the candidate, if one is
+ * installed, and does not return when it does. Returns 1 when this run is
+ * the candidate itself.
+ */
+static __always_inline int shadow_enter(struct sk_reuseport_md *reuse)
+{
+    struct shadow_state *st = shadow_state();
+    if (!st)
+        return 0;
+    if (st->phase == SHADOW_CANDIDATE)
+        return 1;
+    if (st->phase == SHADOW_RETURNING) {
+        st->phase = SHADOW_ACTIVE;
+        return 0;
+    }
+
+    st->phase = SHADOW_CANDIDATE;
+    st->candidate = SHADOW_NONE;
+    bpf_tail_call(reuse, &shadow_progs, SHADOW_PROG_CANDIDATE);
+    /* No candidate installed. */
+    st->phase = SHADOW_OFF;
+    return 0;
+}
+
+/*
+ * The candidate settled on slot (or SHADOW_NONE): hand over to the active
+ * selector. Returns only when not running as the candidate, or when the
+ * active selector is gone, in which case the candidate's choice stands.
+ */
+static __always_inline void shadow_decided(struct sk_reuseport_md *reuse, __u32 slot)
+{
+    struct shadow_state *st = shadow_state();
+    if (!st || st->phase != SHADOW_CANDIDATE)
+        return;
+    st->candidate = slot;
+    st->phase = SHADOW_RETURNING;
+    bpf_tail_call(reuse, &shadow_progs, SHADOW_PROG_ACTIVE);
+    st->phase = SHADOW_OFF;
+}
+
+/* The active selector placed the connection on slot. A global function,
+ * like slot_place(), as selectors place connections from their loops. */
+__noinline int shadow_placed(struct sk_reuseport_md *reuse, __u32 slot)
+{
+    struct shadow_state *st = shadow_state();
+    if (!st || st->phase != SHADOW_ACTIVE)
+        return 0;
+    st->phase = SHADOW_OFF;
+
+    struct shadow_event *ev = bpf_ringbuf_reserve(&shadow_events, sizeof(*ev), 0);
+    if (!ev)
+        return 0;
+    ev->ts_ns = bpf_ktime_get_ns();
+    ev->candidate = st->candidate;
+    ev->active = slot;
+    ev->hash = reuse->hash;
+    ev->pad = 0;
+    bpf_ringbuf_submit(ev, 0);
+    return 0;
+}
+
+/* Return verdict from a selector that placed nothing itself. */
+static __always_inline enum sk_action shadow_verdict(struct sk_reuseport_md *reuse, enum sk_action verdict)
+{
+    shadow_decided(reuse, SHADOW_NONE);
+    return verdict;
+}
+
+#endif /* __SHADOW_H */
@@ -0,0 +1,236 @@
+//go:build ignore
+
+#include "vmlinux.h"
+#include <bpf/bpf_helpers.h>
+#include <bpf/bpf_core_read.h>
+#include <bpf/bpf_endian.h>
+
+/*
+ * Tags the packets a group's servers send with the slot that serves the
+ * connection, so a capture taken anywhere on the path attributes them to an
+ * instance without the application's help.
+ *
+ * inet_csk_accept records each accepted connection's flow with the slot of
+ * the listener it came from, looked up by cookie in acceptq_slot_cookies.
+ * In TOS mode a tc egress program then writes slot + 1 into the DSCP bits
+ * of IPv4 packets (slots 0-62) or the flow label of IPv6 ones; 0 stays
+ * "untagged". In TCP option mode a sock_ops program adds an experimental
+ * option (RFC 6994, kind 253) carrying the slot to every segment instead,
+ * which survives NAT and DSCP rewriting but costs 6 bytes a segment.
+ *
+ * Only segments sent after accept() are tagged: the SYN-ACK and anything
+ * sent before the server picked the connection up are not.
+ */
+#define SLOTTAG_SLOTS 128
+
+#define ETH_P_IP   0x0800
+#define ETH_P_IPV6 0x86DD
+#define AF_INET    2
+#define AF_INET6   10
+
+#define TC_ACT_OK 0
+
+#define SLOTTAG_OPT_KIND 253
+#define SLOTTAG_OPT_LEN  6
+#define SLOTTAG_EXID     0x5354 /* "ST" */
+
+#define IP_TOS_OFF   (sizeof(struct ethhdr) + __builtin_offsetof(struct iphdr, tos))
+#define IP_CSUM_OFF  (sizeof(struct ethhdr) + __builtin_offsetof(struct iphdr, check))
+
+/* Set by userspace before loading, in network byte order. */
+volatile const __u16 slottag_port = 0;
+
+/* The remote end of an accepted connection and its local port, all in
+ * network byte order. IPv4 addresses take raddr[0] only. */
+struct slottag_flow {
+    __u32 raddr[4];
+    __u16 rport;
+    __u16 lport;
+};
+
+/* An IPv4 client of a dual-stack listener shows up as a v4-mapped IPv6
+ * address on the socket, but sends IPv4 packets. */
+static __always_inline void slottag_unmap(struct slottag_flow *flow)
+{
+    if (flow->raddr[0] == 0 && flow->raddr[1] == 0 && flow->raddr[2] == bpf_htonl(0xFFFF)) {
+        flow->raddr[0] = flow->raddr[3];
+        flow->raddr[2] = 0;
+        flow->raddr[3] = 0;
+    }
+}
+
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, SLOTTAG_SLOTS);
+    __type(key, __u32);
+    __type(value, __u64);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} acceptq_slot_cookies SEC(".maps");
+
+/* Accepted connections by flow, to their slot. Closed connections age out. */
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __uint(max_entries, 65536);
+    __type(key, struct slottag_flow);
+    __type(value, __u32);
+} slottag_flows SEC(".maps");
+
+struct slottag_opt {
+    __u8 kind;
+    __u8 len;
+    __u16 exid;
+    __u16 slot;
+} __attribute__((packed));
+
+SEC("fexit/inet_csk_accept")
+int slottag_accept(__u64 *ctx)
+{
+    __u64 ret;
+    if (bpf_get_func_ret(ctx, &ret) || ret == 0)
+        return 0;
+
+    struct sock *listener = (struct sock *)ctx[0];
+    if (bpf_htons(BPF_CORE_READ(listener, __sk_common.skc_num)) != slottag_port)
+        return 0;
+    __u64 cookie = BPF_CORE_READ(listener, __sk_common.skc_cookie.counter);
+    if (cookie == 0)
+        return 0;
+
+    __u32 slot = SLOTTAG_SLOTS;
+    for (__u32 i = 0; i < SLOTTAG_SLOTS; i++) {
+        __u32 k = i;
+        __u64 *c = bpf_map_lookup_elem(&acceptq_slot_cookies, &k);
+        if (c && *c == cookie) {
+            slot = i;
+            break;
+        }
+    }
+    if (slot == SLOTTAG_SLOTS)
+        return 0;
+
+    struct sock *sk = (struct sock *)ret;
+    struct slottag_flow flow = {
+        .rport = BPF_CORE_READ(sk, __sk_common.skc_dport),
+        .lport = slottag_port,
+    };
+    if (BPF_CORE_READ(sk, __sk_common.skc_family) == AF_INET6) {
+        BPF_CORE_READ_INTO(&flow.raddr, sk, __sk_common.skc_v6_daddr.in6_u.u6_addr32);
+        slottag_unmap(&flow);
+    } else
+        flow.raddr[0] = BPF_CORE_READ(sk, __sk_common.skc_daddr);
+    bpf_map_update_elem(&slottag_flows, &flow, &slot, BPF_ANY);
+    return 0;
+}
+
+SEC("tc")
+int slottag_egress(struct __sk_buff *skb)
+{
+    void *data = (void *)(long)skb->data;
+    void *data_end = (void *)(long)skb->data_end;
+    struct ethhdr *eth = data;
+    if ((void *)(eth + 1) > data_end)
+        return TC_ACT_OK;
+
+    struct slottag_flow flow = {.lport = slottag_port};
+    if (eth->h_proto == bpf_htons(ETH_P_IP)) {
+        struct iphdr *iph = (void *)(eth + 1);
+        if ((void *)(iph + 1) > data_end || iph->protocol != IPPROTO_TCP)
+            return TC_ACT_OK;
+        struct tcphdr *th = (void *)iph + iph->ihl * 4;
+        if ((void *)(th + 1) > data_end || th->source != slottag_port)
+            return TC_ACT_OK;
+        flow.raddr[0] = iph->daddr;
+        flow.rport = th->dest;
+        __u32 *slot = bpf_map_lookup_elem(&slottag_flows, &flow);
+        if (!slot || *slot >= 63)
+            return TC_ACT_OK;
+
+        /* DSCP is the upper six bits of the TOS byte; ECN keeps its two. */
+        __u8 old = iph->tos;
+        __u8 tos = ((*slot + 1) << 2) | (old & 0x3);
+        if (tos == old)
+            return TC_ACT_OK;
+        bpf_l3_csum_replace(skb, IP_CSUM_OFF, bpf_htons(old), bpf_htons(tos), 2);
+        bpf_skb_store_bytes(skb, IP_TOS_OFF, &tos, sizeof(tos), 0);
+        return TC_ACT_OK;
+    }
+    if (eth->h_proto == bpf_htons(ETH_P_IPV6)) {
+        struct ipv6hdr *ip6 = (void *)(eth + 1);
+        /* Extension headers are rare enough on a response not to chase. */
+        if ((void *)(ip6 + 1) > data_end || ip6->nexthdr != IPPROTO_TCP)
+            return TC_ACT_OK;
+        struct tcphdr *th = (void *)(ip6 + 1);
+        if ((void *)(th + 1) > data_end || th->source != slottag_port)
+            return TC_ACT_OK;
+        __builtin_memcpy(flow.raddr, ip6->daddr.in6_u.u6_addr32, sizeof(flow.raddr));
+        flow.rport = th->dest;
+        __u32 *slot = bpf_map_lookup_elem(&slottag_flows, &flow);
+        if (!slot)
+            return TC_ACT_OK;
+
+        /* The flow label is the low 20 bits of the first word, which no
+         * checksum covers. */
+        __u32 *word = (__u32 *)ip6;
+        __u32 label = (*slot + 1) & 0xFFFFF;
+        *word = (*word & bpf_htonl(0xFFF00000)) | bpf_htonl(label);
+        return TC_ACT_OK;
+    }
+    return TC_ACT_OK;
+}
+
+/* The remote port is in network byte order in the upper half on kernels
+ * before 5.10 and in the lower half since; a port is never 0. */
+static __always_inline __u16 slottag_remote_port(struct bpf_sock_ops *skops)
+{
+    __u32 p = skops->remote_port;
+    return p > 0xFFFF ? p >> 16 : p;
+}
+
+static __always_inline __u32 *slottag_lookup(struct bpf_sock_ops *skops)
+{
+    struct slottag_flow flow = {
+        .rport = slottag_remote_port(skops),
+        .lport = slottag_port,
+    };
+    if (skops->family == AF_INET6) {
+        flow.raddr[0] = skops->remote_ip6[0];
+        flow.raddr[1] = skops->remote_ip6[1];
+        flow.raddr[2] = skops->remote_ip6[2];
+        flow.raddr[3] = skops->remote_ip6[3];
+        slottag_unmap(&flow);
+    } else {
+        flow.raddr[0] = skops->remote_ip4;
+    }
+    return bpf_map_lookup_elem(&slottag_flows, &flow);
+}
+
+SEC("sockops")
+int slottag_sockops(struct bpf_sock_ops *skops)
+{
+    switch (skops->op) {
+    case BPF_SOCK_OPS_PASSIVE_ESTABLISHED_CB:
+        if (bpf_htons(skops->local_port) == slottag_port)
+            bpf_sock_ops_cb_flags_set(skops, skops->bpf_sock_ops_cb_flags | BPF_SOCK_OPS_WRITE_HDR_OPT_CB_FLAG);
+        break;
+    case BPF_SOCK_OPS_HDR_OPT_LEN_CB:
+        if (slottag_lookup(skops))
+            bpf_reserve_hdr_opt(skops, SLOTTAG_OPT_LEN, 0);
+        break;
+    case BPF_SOCK_OPS_WRITE_HDR_OPT_CB: {
+        __u32 *slot = slottag_lookup(skops);
+        if (!slot)
+            break;
+        struct slottag_opt opt = {
+            .kind = SLOTTAG_OPT_KIND,
+            .len = SLOTTAG_OPT_LEN,
+            .exid = bpf_htons(SLOTTAG_EXID),
+            .slot = bpf_htons(*slot),
+        };
+        bpf_store_hdr_opt(skops, &opt, sizeof(opt), 0);
+        break;
+    }
+    }
+    return 1;
+}
+
+char _license[] SEC("license") = "GPL";
@@ -0,0 +1,120 @@
+//go:build ignore
+
+#include "vmlinux.h"
+#include <bpf/bpf_helpers.h>
+#include "ratelimit.h"
+
+/*
+ * Pack then spill. Slots are tried in order: a connection goes to the
+ * lowest-numbered listening slot whose accept queue is below threshold_pct
+ * full, so slot 0 takes everything until it backs up, then slot 1 takes the
+ * overflow, and so on. Fewer instances stay warm and the rest idle, at the
+ * price of queueing up to the threshold. When every slot is over it, the
+ * least full one gets the connection. Queue depths come from the accept
+ * queue tracker (acceptq_map), so it has to be running; slots it has no
+ * entry for count as empty. Only the first SPILL_MAX_SLOTS slots take part.
+ */
+#define SPILL_MAX_SLOTS 64
+
+struct spill_cfg {
+    __u32 threshold_pct; /* 0 packs nothing: every slot counts as full */
+};
+
+struct acceptq {
+    __u32 curr;
+    __u32 max;
+    __u32 cpu;
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, struct spill_cfg);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} spill_cfg SEC(".maps");
+
+/* External maps shared with other programs */
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 1024);
+    __type(key, __u64);
+    __type(value, struct acceptq);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} acceptq_map SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 128);
+    __type(key, __u32);
+    __type(value, __u64);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} acceptq_slot_cookies SEC(".maps");
+
+#define SPILL_ABSENT ~0ULL
+#define SPILL_FULL (1ULL << 32)
+
+/* Accept queue fill of slot in percent, 0 if unknown, with SPILL_FULL set
+ * from threshold up; SPILL_ABSENT if the slot has no listener. A global
+ * function, so that the verifier checks it once instead of in every
+ * iteration of the selector's loops. */
+__noinline __u64 spill_slot(__u32 slot, __u32 threshold)
+{
+    __u64 *cookie = bpf_map_lookup_elem(&acceptq_slot_cookies, &slot);
+    if (!cookie || *cookie == 0)
+        return SPILL_ABSENT;
+    struct acceptq *aq = bpf_map_lookup_elem(&acceptq_map, cookie);
+    __u64 fill = 0;
+    if (aq && aq->max != 0)
+        fill = (__u64)aq->curr * 100 / aq->max;
+    if (fill > 0xFFFFFFFF)
+        fill = 0xFFFFFFFF;
+    return fill < threshold ? fill : fill | SPILL_FULL;
+}
+
+SEC("sk_reuseport/selector")
+enum sk_action spillover(struct sk_reuseport_md *reuse)
+{
+    enum sk_action verdict;
+    if (ratelimit_apply(reuse, &verdict))
+        return verdict;
+
+    __u32 k0 = 0;
+    struct spill_cfg *cfg = bpf_map_lookup_elem(&spill_cfg, &k0);
+    __u32 threshold = cfg ? cfg->threshold_pct : 0;
+
+    /* The loop counters are copied for slot_select(): with their address
+     * taken they live on the stack, where the verifier cannot bound them. */
+    __u32 least = 0;
+    __u64 least_fill = SPILL_ABSENT;
+    for (__u32 i = 0; i < SPILL_MAX_SLOTS; i++) {
+        __u32 slot = i;
+        __u64 fill = spill_slot(slot, threshold);
+        if (fill == SPILL_ABSENT)
+            continue;
+        if (!(fill & SPILL_FULL)) {
+            if (slot_select(reuse, &slot) == 0)
+                return SK_PASS;
+            continue; /* gone since */
+        }
+        if (fill < least_fill) {
+            least = i;
+            least_fill = fill;
+        }
+    }
+
+    /* Everyone is past the threshold, or the slots under it are gone. */
+    if (least_fill != SPILL_ABSENT && slot_select(reuse, &least) == 0)
+        return SK_PASS;
+    for (__u32 i = 0; i < SPILL_MAX_SLOTS; i++) {
+        __u32 slot = i;
+        if (spill_slot(slot, threshold) != SPILL_ABSENT &&
+            slot_select(reuse, &slot) == 0)
+            return SK_PASS;
+    }
+
+    sl_trace("spillover: no slot is listening\n");
+    return shadow_verdict(reuse, SK_DROP);
+}
+
+char _license[] SEC("license") = "GPL";
@@ -0,0 +1,40 @@
+//go:build ignore
+
+#include "vmlinux.h"
+#include <bpf/bpf_helpers.h>
+
+/*
+ * Splices proxied connections in the kernel. The front proxy puts the client
+ * socket and the backend socket it dialed for it into splice_socks, keyed by
+ * socket cookie, and records each as the other's peer. Every segment either
+ * receives is then handed to the stream verdict below, which sends it
+ * straight out of the peer socket instead of queueing it for the proxy to
+ * read. Segments that arrived before the sockets were inserted are still in
+ * their receive queues and go through the proxy's userspace copy.
+ */
+struct {
+    __uint(type, BPF_MAP_TYPE_SOCKHASH);
+    __uint(max_entries, 65536);
+    __type(key, __u64);   /* socket cookie */
+    __type(value, __u64); /* userspace writes an fd */
+} splice_socks SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 65536);
+    __type(key, __u64);   /* socket cookie */
+    __type(value, __u64); /* its peer's cookie */
+} splice_peers SEC(".maps");
+
+SEC("sk_skb/stream_verdict")
+int splice_verdict(struct __sk_buff *skb)
+{
+    __u64 cookie = bpf_get_socket_cookie(skb);
+    __u64 *peer = bpf_map_lookup_elem(&splice_peers, &cookie);
+    if (!peer)
+        return SK_PASS;
+    __u64 key = *peer;
+    return bpf_sk_redirect_hash(skb, &splice_socks, &key, 0);
+}
+
+char _license[] SEC("license") = "GPL";
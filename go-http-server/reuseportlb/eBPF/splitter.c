@@ -0,0 +1,157 @@
+//go:build ignore
+
+#include "vmlinux.h"
+#include <bpf/bpf_helpers.h>
+#include "ratelimit.h"
+
+/*
+ * Blue/green splitter. canary_pct percent of new connections go to
+ * canary_slot; the rest rotate over every other registered slot (the main
+ * pool). If either side has no socket the other takes the connection, so a
+ * missing canary never costs traffic. Only the first SPLIT_MAX_SLOTS slots
+ * are part of the main pool.
+ */
+#define SPLIT_MAX_SLOTS 64
+
+struct split_cfg {
+    __u32 canary_slot;
+    __u32 canary_pct; /* 0..100 */
+};
+
+enum split_side {
+    SPLIT_CANARY = 0,
+    SPLIT_MAIN = 1,
+};
+
+struct rr_state {
+    __u64 counter;
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, struct split_cfg);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} split_cfg SEC(".maps");
+
+/* Connections placed on each side (enum split_side), per CPU. */
+struct {
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+    __uint(max_entries, 2);
+    __type(key, __u32);
+    __type(value, __u64);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} split_stats SEC(".maps");
+
+/* External maps shared with other programs */
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 128);
+    __type(key, __u32);
+    __type(value, __u64);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} acceptq_slot_cookies SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, struct rr_state);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} rr SEC(".maps");
+
+static __always_inline enum sk_action split_count(__u32 side)
+{
+    __u64 *n = bpf_map_lookup_elem(&split_stats, &side);
+    if (n)
+        *n += 1;
+    return SK_PASS;
+}
+
+static __always_inline int split_canary(struct sk_reuseport_md *reuse, __u32 slot)
+{
+    return slot_select(reuse, &slot) == 0;
+}
+
+/* Select the k'th of the n members of pool, k taken round robin, trying
+ * the others if it has no listener. A global function: the verifier knows
+ * nothing of pool here, where inlined it would tell apart every set of
+ * slots split_main() could build. */
+__noinline int split_pick(struct sk_reuseport_md *reuse, __u64 pool, __u32 n)
+{
+    if (n == 0)
+        return 0;
+
+    __u32 k0 = 0;
+    struct rr_state *st = bpf_map_lookup_elem(&rr, &k0);
+    __u32 k = st ? __sync_fetch_and_add(&st->counter, 1) % n : 0;
+    __u32 seen = 0, start = 0;
+    for (__u32 i = 0; i < SPLIT_MAX_SLOTS; i++) {
+        if (!(pool & (1ULL << i)))
+            continue;
+        if (seen++ == k) {
+            start = i;
+            break;
+        }
+    }
+    for (__u32 i = 0; i < SPLIT_MAX_SLOTS; i++) {
+        __u32 slot = (start + i) & (SPLIT_MAX_SLOTS - 1);
+        if (!(pool & (1ULL << slot)))
+            continue;
+        if (slot_select(reuse, &slot) == 0)
+            return 1;
+    }
+    return 0;
+}
+
+/* Round robin over the registered slots other than the canary. */
+static __always_inline int split_main(struct sk_reuseport_md *reuse, __u32 canary)
+{
+    __u64 pool = 0;
+    __u32 n = 0;
+    for (__u32 slot = 0; slot < SPLIT_MAX_SLOTS; slot++) {
+        __u64 *cookie = bpf_map_lookup_elem(&acceptq_slot_cookies, &slot);
+        if (cookie && *cookie != 0) {
+            pool |= 1ULL << slot;
+            n++;
+        }
+    }
+    /* Taken out afterwards: comparing each slot with it in the loop has
+     * the verifier follow the loop once for every canary slot. */
+    if (canary < SPLIT_MAX_SLOTS && (pool & (1ULL << canary))) {
+        pool &= ~(1ULL << canary);
+        n--;
+    }
+    return split_pick(reuse, pool, n);
+}
+
+SEC("sk_reuseport/selector")
+enum sk_action splitter(struct sk_reuseport_md *reuse)
+{
+    enum sk_action verdict;
+    if (ratelimit_apply(reuse, &verdict))
+        return verdict;
+
+    __u32 k0 = 0;
+    struct split_cfg *cfg = bpf_map_lookup_elem(&split_cfg, &k0);
+    __u32 canary = cfg ? cfg->canary_slot : 0;
+    __u32 pct = cfg ? cfg->canary_pct : 0;
+
+    if (pct > 0 && bpf_get_prandom_u32() % 100 < pct) {
+        if (split_canary(reuse, canary))
+            return split_count(SPLIT_CANARY);
+        if (split_main(reuse, canary))
+            return split_count(SPLIT_MAIN);
+    } else {
+        if (split_main(reuse, canary))
+            return split_count(SPLIT_MAIN);
+        if (split_canary(reuse, canary))
+            return split_count(SPLIT_CANARY);
+    }
+
+    sl_trace("splitter: neither canary slot %u nor the main pool took the connection\n", canary);
+    return shadow_verdict(reuse, SK_DROP);
+}
+
+char _license[] SEC("license") = "GPL";
@@ -0,0 +1,151 @@
+//go:build ignore
+
+#include "vmlinux.h"
+#include <bpf/bpf_helpers.h>
+#include <bpf/bpf_endian.h>
+#include "ratelimit.h"
+
+/*
+ * Experimental multi-tenant steering. Each tenant owns a contiguous range of
+ * slots. A connection belongs to a tenant by
+ *
+ *   1. the client: steer_clients remembers the tenant of the SNI a client
+ *      last sent (recorded by the server from the TLS ClientHello, since a
+ *      SYN carries no payload), else
+ *   2. the destination port: steer_ports maps ports to tenants, else
+ *   3. tenant 0.
+ *
+ * The reuseport group only sees connections to the port it is bound to, so
+ * steer_lookup (an sk_lookup program attached to the network namespace)
+ * hands connections for every port in steer_ports to one of the group's
+ * listeners in steer_listener (indexed by slot, like tcp_balancing_targets)
+ * first; the kernel then runs steer_selector on the group.
+ */
+#define STEER_MAX_TENANTS 64
+#define STEER_IPPROTO_TCP 6
+#define STEER_ETH_P_IP 0x0800
+
+struct steer_tenant {
+    __u32 first_slot;
+    __u32 nslots; /* 0 while the tenant is not configured */
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 1024);
+    __type(key, __u16); /* destination port, host byte order */
+    __type(value, __u32);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} steer_ports SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, STEER_MAX_TENANTS);
+    __type(key, __u32);
+    __type(value, struct steer_tenant);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} steer_tenants SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __uint(max_entries, 4096);
+    __type(key, __u32); /* IPv4 client address, network byte order */
+    __type(value, __u32);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} steer_clients SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_SOCKMAP);
+    __uint(max_entries, 128);
+    __type(key, __u32);
+    __type(value, __u64);
+    __uint(pinning, LIBBPF_PIN_BY_NAME);
+} steer_listener SEC(".maps");
+
+SEC("sk_lookup")
+int steer_lookup(struct bpf_sk_lookup *ctx)
+{
+    if (ctx->protocol != STEER_IPPROTO_TCP)
+        return SK_PASS;
+
+    __u16 port = ctx->local_port;
+    if (!bpf_map_lookup_elem(&steer_ports, &port))
+        return SK_PASS;
+
+    /* Any listener will do: reuseport selection runs after the assignment. */
+    for (__u32 slot = 0; slot < 128; slot++) {
+        struct bpf_sock *sk = bpf_map_lookup_elem(&steer_listener, &slot);
+        if (!sk)
+            continue;
+        long err = bpf_sk_assign(ctx, sk, 0);
+        bpf_sk_release(sk);
+        if (err)
+            sl_trace("steer: assigning port %u to the group failed: %ld\n", port, err);
+        return SK_PASS;
+    }
+    return SK_PASS;
+}
+
+/* Tenant of the connection, by client affinity, then destination port. */
+static __always_inline __u32 steer_tenant_of(struct sk_reuseport_md *reuse)
+{
+    if (reuse->eth_protocol != bpf_htons(STEER_ETH_P_IP) || reuse->ip_protocol != STEER_IPPROTO_TCP)
+        return 0;
+
+    __u32 saddr;
+    if (bpf_skb_load_bytes_relative(reuse, 12, &saddr, sizeof(saddr), BPF_HDR_START_NET) == 0) {
+        __u32 *t = bpf_map_lookup_elem(&steer_clients, &saddr);
+        if (t)
+            return *t;
+    }
+
+    __u8 vihl;
+    __be16 dport;
+    if (bpf_skb_load_bytes_relative(reuse, 0, &vihl, sizeof(vihl), BPF_HDR_START_NET))
+        return 0;
+    __u32 off = (vihl & 0xf) * 4 + 2;
+    if (bpf_skb_load_bytes_relative(reuse, off, &dport, sizeof(dport), BPF_HDR_START_NET))
+        return 0;
+    __u16 port = bpf_ntohs(dport);
+    __u32 *t = bpf_map_lookup_elem(&steer_ports, &port);
+    return t ? *t : 0;
+}
+
+/* Spread over the tenant's slots by flow hash, probing the rest on a miss. */
+static __always_inline int steer_select(struct sk_reuseport_md *reuse, __u32 tenant)
+{
+    struct steer_tenant *t = bpf_map_lookup_elem(&steer_tenants, &tenant);
+    if (!t || t->nslots == 0)
+        return 0;
+
+    __u32 n = t->nslots;
+    __u32 start = reuse->hash % n;
+    for (__u32 i = 0; i < 128; i++) {
+        if (i >= n)
+            break;
+        __u32 slot = t->first_slot + (start + i) % n;
+        if (slot_select(reuse, &slot) == 0)
+            return 1;
+    }
+    return 0;
+}
+
+SEC("sk_reuseport/selector")
+enum sk_action steer_selector(struct sk_reuseport_md *reuse)
+{
+    enum sk_action verdict;
+    if (ratelimit_apply(reuse, &verdict))
+        return verdict;
+
+    __u32 tenant = steer_tenant_of(reuse);
+    if (steer_select(reuse, tenant))
+        return SK_PASS;
+    /* The tenant has no live socket; tenant 0 is the catch-all. */
+    if (tenant != 0 && steer_select(reuse, 0))
+        return SK_PASS;
+
+    sl_trace("steer: no socket for tenant %u\n", tenant);
+    return shadow_verdict(reuse, SK_DROP);
+}
+
+char _license[] SEC("license") = "GPL";
@@ -0,0 +1,269 @@
+//go:build ignore
+
+#include "vmlinux.h"
+#include <bpf/bpf_helpers.h>
+#include <bpf/bpf_endian.h>
+
+/*
+ * L4 load balancer for TCP across backend hosts, attached at XDP on the
+ * interface clients reach the virtual address (VIP) through. The first SYN of
+ * a flow picks a backend with the same policies the reuseport selectors use
+ * for slots (round-robin, or the least loaded by the utilization userspace
+ * publishes); xlb_flows remembers the choice so the rest of the flow follows.
+ *
+ * In DNAT mode the destination address is rewritten to the backend's and the
+ * backend's replies, routed back through this host, get the VIP as source
+ * again. In DSR mode (direct server return) only the destination MAC changes:
+ * the backend holds the VIP on a loopback interface, sits on the same L2
+ * segment and replies to the client directly. Next hops come from the
+ * kernel's FIB; a packet whose neighbour is not resolved yet is left to the
+ * kernel, which forwards it (DNAT needs net.ipv4.ip_forward) and resolves it.
+ *
+ * IPv4 without IP options only; everything else passes.
+ */
+#define XLB_MAX_BACKENDS 64
+
+#define ETH_P_IP 0x0800
+#define AF_INET  2
+#define ETH_ALEN 6
+
+enum xlb_mode {
+    XLB_MODE_DNAT = 0,
+    XLB_MODE_DSR = 1,
+};
+
+enum xlb_policy {
+    XLB_POLICY_ROUND_ROBIN = 0,
+    XLB_POLICY_LEAST_LOAD = 1,
+};
+
+enum xlb_stat {
+    XLB_STAT_NEW = 0,     /* SYNs given a backend */
+    XLB_STAT_FORWARDED,   /* packets sent to a backend */
+    XLB_STAT_RETURNED,    /* DNAT replies sent back to clients */
+    XLB_STAT_NO_BACKEND,  /* SYNs with no backend to pick */
+    XLB_STAT_KERNEL,      /* packets left to the kernel to route */
+    XLB_STAT_MAX,
+};
+
+/* vip and port are in network byte order. */
+struct xlb_cfg {
+    __u32 vip;
+    __u16 port;
+    __u16 pad;
+    __u32 mode;
+    __u32 policy;
+    __u32 backends; /* entries of xlb_backends in use */
+    __u32 pad2;
+};
+
+/*
+ * addr is in network byte order, 0 for a removed backend. load is its
+ * utilization * 100 as userspace last published it, conns the flows placed
+ * on it.
+ */
+struct xlb_backend {
+    __u32 addr;
+    __u32 load;
+    __u64 conns;
+};
+
+struct xlb_flow {
+    __u32 saddr;
+    __u16 sport;
+    __u16 pad;
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, struct xlb_cfg);
+} xlb_config SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, XLB_MAX_BACKENDS);
+    __type(key, __u32);
+    __type(value, struct xlb_backend);
+} xlb_backends SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, __u64);
+} xlb_rr SEC(".maps");
+
+/* Client address and port to the backend address the flow went to. */
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __uint(max_entries, 65536);
+    __type(key, struct xlb_flow);
+    __type(value, __u32);
+} xlb_flows SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+    __uint(max_entries, XLB_STAT_MAX);
+    __type(key, __u32);
+    __type(value, __u64);
+} xlb_stats SEC(".maps");
+
+static __always_inline void xlb_count(__u32 stat)
+{
+    __u64 *n = bpf_map_lookup_elem(&xlb_stats, &stat);
+    if (n)
+        *n += 1;
+}
+
+static __always_inline __u16 csum_fold(__u64 csum)
+{
+    csum = (csum & 0xffff) + (csum >> 16);
+    csum = (csum & 0xffff) + (csum >> 16);
+    return ~csum;
+}
+
+/* Updates the checksum at sum for a 4-byte field changing from from to to. */
+static __always_inline void csum_replace4(__u16 *sum, __u32 from, __u32 to)
+{
+    __u32 seed = (__u16)~*sum;
+    *sum = csum_fold(bpf_csum_diff(&from, sizeof(from), &to, sizeof(to), seed));
+}
+
+/* Returns the index of the backend for a new flow, or -1. */
+static __always_inline int xlb_pick(struct xlb_cfg *cfg)
+{
+    __u32 k0 = 0;
+    __u64 *rr = bpf_map_lookup_elem(&xlb_rr, &k0);
+    __u32 n = cfg->backends;
+    if (!rr || n == 0)
+        return -1;
+    if (n > XLB_MAX_BACKENDS)
+        n = XLB_MAX_BACKENDS;
+
+    /* Both policies start at the round-robin position, so least-load
+     * spreads equally loaded backends evenly too. */
+    __u32 start = __sync_fetch_and_add(rr, 1) % n;
+    int best = -1;
+    __u32 best_load = 0xFFFFFFFF;
+    for (__u32 i = 0; i < XLB_MAX_BACKENDS && i < n; i++) {
+        __u32 idx = start + i;
+        if (idx >= n)
+            idx -= n;
+        struct xlb_backend *b = bpf_map_lookup_elem(&xlb_backends, &idx);
+        if (!b || b->addr == 0)
+            continue;
+        if (cfg->policy == XLB_POLICY_ROUND_ROBIN)
+            return idx;
+        if (b->load < best_load) {
+            best = idx;
+            best_load = b->load;
+        }
+    }
+    return best;
+}
+
+/* Sends the packet towards iph->daddr, or to via when it is not 0. */
+static __always_inline int xlb_redirect(struct xdp_md *ctx, struct ethhdr *eth, struct iphdr *iph, __u32 via)
+{
+    struct bpf_fib_lookup fib = {};
+    fib.family = AF_INET;
+    fib.tos = iph->tos;
+    fib.l4_protocol = iph->protocol;
+    fib.tot_len = bpf_ntohs(iph->tot_len);
+    fib.ipv4_src = iph->saddr;
+    fib.ipv4_dst = via ? via : iph->daddr;
+    fib.ifindex = ctx->ingress_ifindex;
+
+    if (bpf_fib_lookup(ctx, &fib, sizeof(fib), 0) != BPF_FIB_LKUP_RET_SUCCESS) {
+        xlb_count(XLB_STAT_KERNEL);
+        return XDP_PASS;
+    }
+    __builtin_memcpy(eth->h_dest, fib.dmac, ETH_ALEN);
+    __builtin_memcpy(eth->h_source, fib.smac, ETH_ALEN);
+    if (fib.ifindex == ctx->ingress_ifindex)
+        return XDP_TX;
+    return bpf_redirect(fib.ifindex, 0);
+}
+
+SEC("xdp")
+int xlb_xdp(struct xdp_md *ctx)
+{
+    void *data = (void *)(long)ctx->data;
+    void *data_end = (void *)(long)ctx->data_end;
+
+    struct ethhdr *eth = data;
+    if ((void *)(eth + 1) > data_end || eth->h_proto != bpf_htons(ETH_P_IP))
+        return XDP_PASS;
+    struct iphdr *iph = (void *)(eth + 1);
+    if ((void *)(iph + 1) > data_end || iph->ihl != 5 || iph->protocol != IPPROTO_TCP)
+        return XDP_PASS;
+    struct tcphdr *th = (void *)(iph + 1);
+    if ((void *)(th + 1) > data_end)
+        return XDP_PASS;
+
+    __u32 k0 = 0;
+    struct xlb_cfg *cfg = bpf_map_lookup_elem(&xlb_config, &k0);
+    if (!cfg || cfg->vip == 0)
+        return XDP_PASS;
+
+    if (iph->daddr == cfg->vip && th->dest == cfg->port) {
+        struct xlb_flow flow = {.saddr = iph->saddr, .sport = th->source};
+        __u32 backend;
+        __u32 *known = bpf_map_lookup_elem(&xlb_flows, &flow);
+        if (known) {
+            backend = *known;
+        } else {
+            /* A flow this host does not know, say from before a restart,
+             * is the kernel's to reset. */
+            if (!th->syn || th->ack)
+                return XDP_PASS;
+            int idx = xlb_pick(cfg);
+            if (idx < 0) {
+                xlb_count(XLB_STAT_NO_BACKEND);
+                return XDP_DROP;
+            }
+            __u32 key = idx;
+            struct xlb_backend *b = bpf_map_lookup_elem(&xlb_backends, &key);
+            if (!b)
+                return XDP_DROP;
+            backend = b->addr;
+            __sync_fetch_and_add(&b->conns, 1);
+            bpf_map_update_elem(&xlb_flows, &flow, &backend, BPF_ANY);
+            xlb_count(XLB_STAT_NEW);
+        }
+        if (th->rst)
+            bpf_map_delete_elem(&xlb_flows, &flow);
+
+        xlb_count(XLB_STAT_FORWARDED);
+        if (cfg->mode == XLB_MODE_DSR)
+            return xlb_redirect(ctx, eth, iph, backend);
+
+        __u32 old = iph->daddr;
+        iph->daddr = backend;
+        csum_replace4(&iph->check, old, backend);
+        csum_replace4(&th->check, old, backend);
+        return xlb_redirect(ctx, eth, iph, 0);
+    }
+
+    /* A DNAT backend's reply, on its way back to the client. */
+    if (cfg->mode == XLB_MODE_DNAT && th->source == cfg->port) {
+        struct xlb_flow flow = {.saddr = iph->daddr, .sport = th->dest};
+        __u32 *backend = bpf_map_lookup_elem(&xlb_flows, &flow);
+        if (!backend || *backend != iph->saddr)
+            return XDP_PASS;
+        if (th->rst)
+            bpf_map_delete_elem(&xlb_flows, &flow);
+
+        __u32 old = iph->saddr, vip = cfg->vip;
+        iph->saddr = vip;
+        csum_replace4(&iph->check, old, vip);
+        csum_replace4(&th->check, old, vip);
+        xlb_count(XLB_STAT_RETURNED);
+        return xlb_redirect(ctx, eth, iph, 0);
+    }
+    return XDP_PASS;
+}
+
+char _license[] SEC("license") = "GPL";
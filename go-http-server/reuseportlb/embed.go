@@ -0,0 +1,306 @@
+package reuseportlb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/cilium/ebpf/rlimit"
+)
+
+// Server is an http.Server whose listener is an instance of a reuseport
+// group, for applications that bring their own server instead of running
+// server_code:
+//
+//	srv := &http.Server{Addr: ":8080", Handler: mux}
+//	lb := reuseportlb.WrapServer(srv, reuseportlb.WithSlot(n), reuseportlb.WithPolicy("jsq"))
+//	err := lb.ListenAndServe()
+//
+// Shutdown takes the instance out of its slot before it drains, so the
+// selector stops sending it connections first.
+type Server struct {
+	*http.Server
+	cfg serverConfig
+
+	mu       sync.Mutex
+	objs     LoadedObjects
+	registry *RegistryClient
+	leave    func() error
+	cookie   uint64
+	joined   bool
+}
+
+type serverConfig struct {
+	group    Group
+	slot     uint32
+	policy   string
+	migrate  bool
+	registry string
+	warmup   time.Duration
+	features Features
+}
+
+// A ServerOption configures how WrapServer joins the group.
+type ServerOption func(*serverConfig)
+
+// WithGroup puts the server in group g rather than DefaultGroup.
+func WithGroup(g Group) ServerOption {
+	return func(c *serverConfig) { c.group = g }
+}
+
+// WithSlot registers the server in slot, 0 unless given. Every instance of
+// a group needs a slot of its own.
+func WithSlot(slot uint32) ServerOption {
+	return func(c *serverConfig) { c.slot = slot }
+}
+
+// WithPolicy has the first instance load policy for the group and later
+// ones attach the selector it pinned (see Group.LoadOrAttachPolicy).
+// "default" leaves balancing to the kernel's hash and needs no privileges.
+// Without WithPolicy or WithRegistry the server attaches the selector lbd
+// pinned for the group.
+func WithPolicy(policy string) ServerOption {
+	return func(c *serverConfig) { c.policy = policy }
+}
+
+// WithMigration loads the policy's selector as a migration-aware program
+// where the kernel has them, and turns on tcp_migrate_req.
+func WithMigration() ServerOption {
+	return func(c *serverConfig) { c.migrate = true }
+}
+
+// WithRegistry hands the listener to lbd over its registry socket at path
+// instead of touching bpffs, so the application needs no BPF privileges.
+func WithRegistry(path string) ServerOption {
+	return func(c *serverConfig) { c.registry = path }
+}
+
+// WithWarmup gives the slot a slow-start window of d after it registers.
+// A policy the server loads gets FeatureWarmup for it.
+func WithWarmup(d time.Duration) ServerOption {
+	return func(c *serverConfig) {
+		c.warmup = d
+		if d > 0 {
+			c.features |= FeatureWarmup
+		}
+	}
+}
+
+// WithFeatures adds f to the features a policy the server loads has, on
+// top of DefaultFeatures. A selector attached rather than loaded keeps its
+// loader's.
+func WithFeatures(f Features) ServerOption {
+	return func(c *serverConfig) { c.features |= f }
+}
+
+// WrapServer returns srv set up to balance in a reuseport group. srv is
+// used as it is; only how it listens changes.
+func WrapServer(srv *http.Server, opts ...ServerOption) *Server {
+	s := &Server{Server: srv, cfg: serverConfig{group: DefaultGroup, features: DefaultFeatures}}
+	for _, opt := range opts {
+		opt(&s.cfg)
+	}
+	return s
+}
+
+// ListenAndServe is http.ListenAndServe for a server balanced in a
+// reuseport group. Like it, it only returns on error; use WrapServer to
+// drain.
+func ListenAndServe(addr string, handler http.Handler, opts ...ServerOption) error {
+	return WrapServer(&http.Server{Addr: addr, Handler: handler}, opts...).ListenAndServe()
+}
+
+// ListenAndServe listens on s.Addr, joins the group and serves. Like
+// http.Server's, it returns http.ErrServerClosed after Shutdown.
+func (s *Server) ListenAndServe() error {
+	ln, err := s.Listen(context.Background())
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// ListenAndServeTLS is ListenAndServe for TLS.
+func (s *Server) ListenAndServeTLS(certFile, keyFile string) error {
+	ln, err := s.Listen(context.Background())
+	if err != nil {
+		return err
+	}
+	return s.ServeTLS(ln, certFile, keyFile)
+}
+
+// Listen opens the server's listener and registers it in its slot, loading
+// or attaching the group's selector first as configured. ctx bounds the
+// setup, not the listener.
+func (s *Server) Listen(ctx context.Context) (net.Listener, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.joined {
+		return nil, errors.New("server already listening")
+	}
+	addr := s.Addr
+	if addr == "" {
+		addr = ":http"
+	}
+	direct := s.cfg.registry == "" && s.cfg.policy != "default"
+	if direct {
+		if err := s.loadSelector(); err != nil {
+			s.release()
+			return nil, err
+		}
+	}
+
+	lc := net.ListenConfig{Control: func(network, address string, c syscall.RawConn) error {
+		var opErr error
+		if err := c.Control(func(fd uintptr) { opErr = setReuseport(int(fd)) }); err != nil {
+			return err
+		}
+		return opErr
+	}}
+	ln, err := lc.Listen(ctx, "tcp", addr)
+	if err != nil {
+		s.release()
+		return nil, err
+	}
+	if err := s.register(ctx, ln, direct); err != nil {
+		ln.Close()
+		s.release()
+		return nil, err
+	}
+	s.joined = true
+	return ln, nil
+}
+
+// loadSelector gets hold of the group's selector: the policy's, loaded or
+// attached, or lbd's.
+func (s *Server) loadSelector() error {
+	g := s.cfg.group
+	if err := EnsureBpffs(); err != nil {
+		return err
+	}
+	if err := EnsureLayout(); err != nil {
+		return err
+	}
+	if s.cfg.migrate {
+		if err := EnableRequestMigration(); err != nil {
+			slog.Warn("Accept queue migration unavailable, queued connections are reset on drain", "err", err)
+		}
+	}
+	// Kernels before 5.11 charge BPF memory to RLIMIT_MEMLOCK.
+	if err := rlimit.RemoveMemlock(); err != nil {
+		slog.Warn("Removing memlock failed", "err", err)
+	}
+	if s.cfg.policy == "" {
+		prog, err := g.LoadPinnedProgram()
+		if err != nil {
+			return err
+		}
+		s.objs = LoadedObjects{Program: prog, Close: prog.Close}
+		return nil
+	}
+	objs, _, err := g.LoadOrAttachPolicy(s.cfg.policy, s.cfg.migrate, s.cfg.features)
+	if err != nil {
+		return err
+	}
+	s.objs = objs
+	// Instances that loaded or attached a policy share its pins; the last
+	// one out removes them. lbd's pins are lbd's.
+	s.leave, err = g.Join()
+	return err
+}
+
+// register attaches the selector to ln and puts it in the server's slot,
+// itself or through lbd.
+func (s *Server) register(ctx context.Context, ln net.Listener, direct bool) error {
+	if s.cfg.policy == "default" && s.cfg.registry == "" {
+		return nil
+	}
+	fd, err := listenerFD(ln)
+	if err != nil {
+		return err
+	}
+	g, slot := s.cfg.group, s.cfg.slot
+	if !direct {
+		if s.registry, err = DialRegistry(ctx, s.cfg.registry); err != nil {
+			return err
+		}
+		s.cookie, err = s.registry.Register(ctx, g, slot, fd, s.cfg.warmup)
+		return err
+	}
+
+	if err := attachSelector(fd, s.objs.Program); err != nil {
+		return fmt.Errorf("attach selector: %w", err)
+	}
+	if s.cookie, err = g.RegisterSocket(ctx, slot, fd, os.Getpid()); err != nil {
+		return err
+	}
+	if err := g.RecordAttach(os.Getpid(), slot, s.cookie, s.objs.Program); err != nil {
+		slog.Warn("Recording attachment failed", "err", err)
+	}
+	if err := g.StartWarmup(slot, s.cfg.warmup); err != nil {
+		return err
+	}
+	slog.Info("Registered socket", "group", g.String(), "slot", slot, CookieAttr(s.cookie))
+	return nil
+}
+
+// Shutdown takes the server out of its slot, then shuts it down as
+// http.Server.Shutdown does, and lets go of the selector.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
+	if s.joined {
+		if s.registry != nil {
+			errs = append(errs, s.registry.Deregister(ctx, s.cfg.group, s.cfg.slot, s.cookie))
+		} else if s.cookie != 0 {
+			errs = append(errs, s.cfg.group.Deregister(ctx, s.cfg.slot, s.cookie))
+		}
+	}
+	errs = append(errs, s.Server.Shutdown(ctx))
+	s.release()
+	return errors.Join(errs...)
+}
+
+// release closes what Listen opened besides the listener.
+func (s *Server) release() {
+	if s.registry != nil {
+		s.registry.Close()
+		s.registry = nil
+	}
+	if s.objs.Close != nil {
+		s.objs.Close()
+		s.objs = LoadedObjects{}
+	}
+	if s.leave != nil {
+		if err := s.leave(); err != nil {
+			slog.Error("Unpinning group failed", "group", s.cfg.group.String(), "err", err)
+		}
+		s.leave = nil
+	}
+	s.joined = false
+}
+
+// listenerFD returns the descriptor of ln, which stays owned by ln.
+func listenerFD(ln net.Listener) (int, error) {
+	sc, ok := ln.(syscall.Conn)
+	if !ok {
+		return -1, fmt.Errorf("%T has no file descriptor", ln)
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return -1, err
+	}
+	fd := -1
+	if err := raw.Control(func(p uintptr) { fd = int(p) }); err != nil {
+		return -1, err
+	}
+	return fd, nil
+}
@@ -0,0 +1,106 @@
+package reuseportlb
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The default policy leaves balancing to the kernel, so these run without
+// privileges.
+
+func TestServerLifecycle(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hello", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello")
+	})
+	s := WrapServer(&http.Server{Addr: "127.0.0.1:0", Handler: mux}, WithPolicy("default"))
+	ln, err := s.Listen(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(ln) }()
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get("http://" + ln.Addr().String() + "/hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(body) != "hello" {
+		t.Errorf("got %q, %v; want hello", body, err)
+	}
+
+	if again, err := s.Listen(context.Background()); err == nil || !strings.Contains(err.Error(), "server already listening") {
+		if err == nil {
+			again.Close()
+		}
+		t.Errorf("second Listen: got %v, want server already listening", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown: %v", err)
+	}
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		t.Errorf("Serve returned %v, want http.ErrServerClosed", err)
+	}
+	if err := s.Shutdown(ctx); err != nil {
+		t.Errorf("second Shutdown: %v", err)
+	}
+}
+
+func TestServerListenFails(t *testing.T) {
+	t.Run("address", func(t *testing.T) {
+		s := WrapServer(&http.Server{Addr: "127.0.0.1:http-alt-nonexistent"}, WithPolicy("default"))
+		if ln, err := s.Listen(context.Background()); err == nil {
+			ln.Close()
+			t.Fatal("Listen on an invalid address succeeded")
+		}
+		// Nothing was left behind: the server can listen once fixed, and
+		// releasing again is harmless.
+		s.release()
+		s.Addr = "127.0.0.1:0"
+		ln, err := s.Listen(context.Background())
+		if err != nil {
+			t.Fatalf("Listen after a failed one: %v", err)
+		}
+		ln.Close()
+		s.release()
+		s.release()
+	})
+
+	t.Run("registry", func(t *testing.T) {
+		addr := freeAddr(t)
+		path := t.TempDir() + "/no-lbd.sock"
+		s := WrapServer(&http.Server{Addr: addr}, WithRegistry(path))
+		if ln, err := s.Listen(context.Background()); err == nil {
+			ln.Close()
+			t.Fatal("Listen through a registry nobody serves succeeded")
+		}
+		if s.registry != nil || s.joined {
+			t.Errorf("failed Listen left registry %v, joined %v", s.registry, s.joined)
+		}
+		// The listener was closed: the address is free again.
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			t.Fatalf("address still taken after the failed Listen: %v", err)
+		}
+		ln.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		for i := 0; i < 2; i++ {
+			if err := s.Shutdown(ctx); err != nil {
+				t.Errorf("Shutdown %d after a failed Listen: %v", i+1, err)
+			}
+		}
+	})
+}
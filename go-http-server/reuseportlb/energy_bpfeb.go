@@ -0,0 +1,197 @@
+// Code generated by bpf2go; DO NOT EDIT.
+//go:build mips || mips64 || ppc64 || s390x
+
+package reuseportlb
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+
+	"github.com/cilium/ebpf"
+)
+
+type energyRatelimitCfg struct {
+	Enabled     uint32
+	Action      uint32
+	PenaltySlot uint32
+	MaxConns    uint32
+	WindowNs    uint64
+}
+
+type energyShadowState struct {
+	Phase     uint32
+	Candidate uint32
+}
+
+type energySlotBucket struct {
+	Rate      uint64
+	Burst     uint64
+	Action    uint32
+	Pad       uint32
+	Tokens    uint64
+	LastNs    uint64
+	Throttled uint64
+}
+
+type energySlotOverride struct {
+	Slot uint32
+	Hits uint32
+}
+
+type energySlotWarmup struct {
+	StartNs  uint64
+	WindowNs uint64
+}
+
+type energySrcRate struct {
+	WindowStart uint64
+	Count       uint32
+	Limited     uint32
+}
+
+// loadEnergy returns the embedded CollectionSpec for energy.
+func loadEnergy() (*ebpf.CollectionSpec, error) {
+	reader := bytes.NewReader(_EnergyBytes)
+	spec, err := ebpf.LoadCollectionSpecFromReader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("can't load energy: %w", err)
+	}
+
+	return spec, err
+}
+
+// loadEnergyObjects loads energy and converts it into a struct.
+//
+// The following types are suitable as obj argument:
+//
+//	*energyObjects
+//	*energyPrograms
+//	*energyMaps
+//
+// See ebpf.CollectionSpec.LoadAndAssign documentation for details.
+func loadEnergyObjects(obj interface{}, opts *ebpf.CollectionOptions) error {
+	spec, err := loadEnergy()
+	if err != nil {
+		return err
+	}
+
+	return spec.LoadAndAssign(obj, opts)
+}
+
+// energySpecs contains maps and programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type energySpecs struct {
+	energyProgramSpecs
+	energyMapSpecs
+}
+
+// energySpecs contains programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type energyProgramSpecs struct {
+	EnergySelector *ebpf.ProgramSpec `ebpf:"energy_selector"`
+}
+
+// energyMapSpecs contains maps before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type energyMapSpecs struct {
+	AcceptqSlotCookies  *ebpf.MapSpec `ebpf:"acceptq_slot_cookies"`
+	PolicyCfg           *ebpf.MapSpec `ebpf:"policy_cfg"`
+	RatelimitCfg        *ebpf.MapSpec `ebpf:"ratelimit_cfg"`
+	ShadowEvents        *ebpf.MapSpec `ebpf:"shadow_events"`
+	ShadowProgs         *ebpf.MapSpec `ebpf:"shadow_progs"`
+	ShadowScratch       *ebpf.MapSpec `ebpf:"shadow_scratch"`
+	SlotBucket          *ebpf.MapSpec `ebpf:"slot_bucket"`
+	SlotDropping        *ebpf.MapSpec `ebpf:"slot_dropping"`
+	SlotOverride        *ebpf.MapSpec `ebpf:"slot_override"`
+	SlotSelected        *ebpf.MapSpec `ebpf:"slot_selected"`
+	SlotUtil            *ebpf.MapSpec `ebpf:"slot_util"`
+	SlotWarmup          *ebpf.MapSpec `ebpf:"slot_warmup"`
+	SrcRate             *ebpf.MapSpec `ebpf:"src_rate"`
+	TcpBalancingTargets *ebpf.MapSpec `ebpf:"tcp_balancing_targets"`
+}
+
+// energyObjects contains all objects after they have been loaded into the kernel.
+//
+// It can be passed to loadEnergyObjects or ebpf.CollectionSpec.LoadAndAssign.
+type energyObjects struct {
+	energyPrograms
+	energyMaps
+}
+
+func (o *energyObjects) Close() error {
+	return _EnergyClose(
+		&o.energyPrograms,
+		&o.energyMaps,
+	)
+}
+
+// energyMaps contains all maps after they have been loaded into the kernel.
+//
+// It can be passed to loadEnergyObjects or ebpf.CollectionSpec.LoadAndAssign.
+type energyMaps struct {
+	AcceptqSlotCookies  *ebpf.Map `ebpf:"acceptq_slot_cookies"`
+	PolicyCfg           *ebpf.Map `ebpf:"policy_cfg"`
+	RatelimitCfg        *ebpf.Map `ebpf:"ratelimit_cfg"`
+	ShadowEvents        *ebpf.Map `ebpf:"shadow_events"`
+	ShadowProgs         *ebpf.Map `ebpf:"shadow_progs"`
+	ShadowScratch       *ebpf.Map `ebpf:"shadow_scratch"`
+	SlotBucket          *ebpf.Map `ebpf:"slot_bucket"`
+	SlotDropping        *ebpf.Map `ebpf:"slot_dropping"`
+	SlotOverride        *ebpf.Map `ebpf:"slot_override"`
+	SlotSelected        *ebpf.Map `ebpf:"slot_selected"`
+	SlotUtil            *ebpf.Map `ebpf:"slot_util"`
+	SlotWarmup          *ebpf.Map `ebpf:"slot_warmup"`
+	SrcRate             *ebpf.Map `ebpf:"src_rate"`
+	TcpBalancingTargets *ebpf.Map `ebpf:"tcp_balancing_targets"`
+}
+
+func (m *energyMaps) Close() error {
+	return _EnergyClose(
+		m.AcceptqSlotCookies,
+		m.PolicyCfg,
+		m.RatelimitCfg,
+		m.ShadowEvents,
+		m.ShadowProgs,
+		m.ShadowScratch,
+		m.SlotBucket,
+		m.SlotDropping,
+		m.SlotOverride,
+		m.SlotSelected,
+		m.SlotUtil,
+		m.SlotWarmup,
+		m.SrcRate,
+		m.TcpBalancingTargets,
+	)
+}
+
+// energyPrograms contains all programs after they have been loaded into the kernel.
+//
+// It can be passed to loadEnergyObjects or ebpf.CollectionSpec.LoadAndAssign.
+type energyPrograms struct {
+	EnergySelector *ebpf.Program `ebpf:"energy_selector"`
+}
+
+func (p *energyPrograms) Close() error {
+	return _EnergyClose(
+		p.EnergySelector,
+	)
+}
+
+func _EnergyClose(closers ...io.Closer) error {
+	for _, closer := range closers {
+		if err := closer.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Do not access this directly.
+//
+//go:embed energy_bpfeb.o
+var _EnergyBytes []byte
@@ -0,0 +1,185 @@
+package reuseportlb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cilium/ebpf"
+)
+
+// DefaultErrorInterval is how often an ErrorCounter publishes.
+const DefaultErrorInterval = time.Second
+
+// errorsStale is how old a slot_errors entry may be before readers ignore
+// it, as they would a server that stopped publishing.
+const errorsStale = 5 * time.Second
+
+// errorRateAlpha smooths the error rate over intervals, so that a single
+// failed request on a quiet server does not read as a failing one.
+const errorRateAlpha = 0.3
+
+// SlotErrors is a value in slot_errors: how a slot's server has been
+// answering, as counted by its ErrorCounter. Requests, Errors and Timeouts
+// count since the server started; Rate is the smoothed share of requests
+// that failed lately, in hundredths of a percent.
+type SlotErrors struct {
+	UpdatedNs uint64
+	Requests  uint64
+	Errors    uint64
+	Timeouts  uint64
+	Rate      uint32
+	Pad       uint32
+}
+
+// ErrorCounter counts the requests a server answers with a 5xx status or
+// too late, and publishes the rate at which they fail in the slot's
+// slot_errors entry: an instance that accepts connections quickly and
+// answers them with errors looks idle to every load signal, and this is
+// the signal that tells it apart. It feeds the "errors" health signal and
+// the outlier detector.
+type ErrorCounter struct {
+	group Group
+	slot  uint32
+	// timeout is how long a request may take before it counts as timed
+	// out, 0 for no limit of its own.
+	timeout time.Duration
+
+	requests, errors, timeouts atomic.Uint64
+
+	mu   sync.Mutex
+	prev [3]uint64 // requests, errors and timeouts at the last publish
+	rate EWMA
+}
+
+// NewErrorCounter returns a counter for slot. A request taking longer than
+// timeout, or whose context deadline passes, counts as timed out; timeout
+// 0 leaves only the deadline.
+func (g Group) NewErrorCounter(slot uint32, timeout time.Duration) *ErrorCounter {
+	return &ErrorCounter{group: g, slot: slot, timeout: timeout, rate: EWMA{Alpha: errorRateAlpha}}
+}
+
+// statusWriter remembers the status a handler answers with.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the connection's writer.
+func (w *statusWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// Wrap counts every request next serves.
+func (c *ErrorCounter) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w}
+		start := time.Now()
+		next.ServeHTTP(sw, r)
+		c.requests.Add(1)
+		switch {
+		case c.timeout > 0 && time.Since(start) > c.timeout,
+			errors.Is(r.Context().Err(), context.DeadlineExceeded):
+			c.timeouts.Add(1)
+		case sw.status >= 500:
+			c.errors.Add(1)
+		}
+	})
+}
+
+// Rate returns the smoothed share of requests that failed, in percent.
+func (c *ErrorCounter) Rate() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rate.Value()
+}
+
+// sample folds the requests since the last call into the rate and returns
+// the entry to publish.
+func (c *ErrorCounter) sample() SlotErrors {
+	cur := [3]uint64{c.requests.Load(), c.errors.Load(), c.timeouts.Load()}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// An interval without requests says nothing new about the server.
+	if served := cur[0] - c.prev[0]; served > 0 {
+		failed := cur[1] - c.prev[1] + cur[2] - c.prev[2]
+		c.rate.Update(float64(failed) * 100 / float64(served))
+	}
+	c.prev = cur
+	return SlotErrors{Requests: cur[0], Errors: cur[1], Timeouts: cur[2], Rate: uint32(c.rate.Value()*100 + 0.5)}
+}
+
+// Run publishes the error rate every interval until ctx is done, then
+// clears the slot's entry.
+func (c *ErrorCounter) Run(ctx context.Context, interval time.Duration) error {
+	if c.slot >= mapLayouts[SlotErrorsMap].MaxEntries {
+		return fmt.Errorf("slot %d out of range for %s", c.slot, SlotErrorsMap)
+	}
+	m, err := c.group.OpenOrCreatePinnedMap(SlotErrorsMap)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	failing := false
+	for {
+		select {
+		case <-ctx.Done():
+			m.Update(c.slot, SlotErrors{}, ebpf.UpdateAny)
+			return nil
+		case <-ticker.C:
+		}
+		v := c.sample()
+		now, err := monotonicNow()
+		if err == nil {
+			v.UpdatedNs = now
+			err = m.Update(c.slot, v, ebpf.UpdateAny)
+		}
+		if err != nil && !failing {
+			slog.Warn("Publishing error rate failed", "err", err)
+		}
+		failing = err != nil
+	}
+}
+
+// SlotErrorRates reads slot_errors: how each slot's server has been
+// answering, leaving out slots that publish nothing or stopped doing so.
+func (g Group) SlotErrorRates() (map[uint32]SlotErrors, error) {
+	m, err := g.OpenPinnedMap(SlotErrorsMap)
+	if err != nil {
+		return nil, err
+	}
+	defer m.Close()
+	now, err := monotonicNow()
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[uint32]SlotErrors)
+	var slot uint32
+	var v SlotErrors
+	iter := m.Iterate()
+	for iter.Next(&slot, &v) {
+		if v.UpdatedNs != 0 && now-v.UpdatedNs < uint64(errorsStale) {
+			out[slot] = v
+		}
+	}
+	return out, iter.Err()
+}
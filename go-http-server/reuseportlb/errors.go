@@ -0,0 +1,49 @@
+package reuseportlb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/cilium/ebpf"
+)
+
+// The kinds of failure callers commonly branch on. Errors returned by the
+// package wrap one of these where they apply, next to the errors of single
+// features (ErrSlotTaken, ErrLoaderRunning, ErrLayoutMismatch), so that
+// errors.Is tells them apart without matching messages.
+var (
+	// ErrPinMissing is returned when a map or program expected under the
+	// group's pin directory is not there: no policy was loaded for the group,
+	// or its last instance unpinned it. Such errors also match
+	// os.ErrNotExist.
+	ErrPinMissing = errors.New("pinned object missing")
+	// ErrPolicyUnsupported is returned for a policy this build has no
+	// selector for.
+	ErrPolicyUnsupported = errors.New("unsupported policy")
+	// ErrKernelFeatureMissing is returned when the running kernel lacks what
+	// a feature needs: a program or attach type, a hook, a sysctl.
+	ErrKernelFeatureMissing = errors.New("kernel feature missing")
+)
+
+// Policies lists the policies LoadPolicy accepts, besides default, which
+// loads nothing.
+var Policies = []string{"pickfirst", "round-robin", "cpuutil", "acceptqueue", "chain", "splitter", "steer", "hot-standby", "spillover", "jsq", "memguard", "gcaware", "healthscore", "psi", "energy"}
+
+// pinMissing marks err, from loading the object pinned at path, as
+// ErrPinMissing if nothing is pinned there.
+func pinMissing(err error, path string) error {
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: %s: %w", ErrPinMissing, path, err)
+	}
+	return err
+}
+
+// kernelFeature marks err as ErrKernelFeatureMissing if the kernel rejected
+// something it does not support.
+func kernelFeature(err error) error {
+	if errors.Is(err, ebpf.ErrNotSupported) && !errors.Is(err, ErrKernelFeatureMissing) {
+		return fmt.Errorf("%w: %w", ErrKernelFeatureMissing, err)
+	}
+	return err
+}
@@ -0,0 +1,77 @@
+package reuseportlb
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"testing"
+
+	"github.com/cilium/ebpf"
+)
+
+func TestLoadUnknownPolicy(t *testing.T) {
+	_, err := loadPolicyObjects("no-such-policy", &ebpf.CollectionOptions{}, false, DefaultFeatures)
+	if !errors.Is(err, ErrPolicyUnsupported) {
+		t.Fatalf("got %v, want ErrPolicyUnsupported", err)
+	}
+}
+
+func TestPinMissing(t *testing.T) {
+	const path = "/sys/fs/bpf/test/acceptq_map"
+	notExist := &fs.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+	other := errors.New("permission denied")
+	for _, tc := range []struct {
+		name        string
+		err         error
+		wantMissing bool
+	}{
+		{"not exist", notExist, true},
+		{"wrapped not exist", fmt.Errorf("load pinned map: %w", notExist), true},
+		{"other", other, false},
+		{"nil", nil, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := pinMissing(tc.err, path)
+			if got := errors.Is(err, ErrPinMissing); got != tc.wantMissing {
+				t.Errorf("errors.Is(%v, ErrPinMissing) = %v, want %v", err, got, tc.wantMissing)
+			}
+			if tc.err != nil && !errors.Is(err, tc.err) {
+				t.Errorf("%v no longer matches %v", err, tc.err)
+			}
+			if tc.err == nil && err != nil {
+				t.Errorf("got %v for nil", err)
+			}
+		})
+	}
+}
+
+func TestKernelFeature(t *testing.T) {
+	unsupported := fmt.Errorf("attach type: %w", ebpf.ErrNotSupported)
+	for _, tc := range []struct {
+		name        string
+		err         error
+		wantMissing bool
+	}{
+		{"not supported", unsupported, true},
+		{"already marked", kernelFeature(unsupported), true},
+		{"other", errors.New("invalid argument"), false},
+		{"nil", nil, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := kernelFeature(tc.err)
+			if got := errors.Is(err, ErrKernelFeatureMissing); got != tc.wantMissing {
+				t.Errorf("errors.Is(%v, ErrKernelFeatureMissing) = %v, want %v", err, got, tc.wantMissing)
+			}
+			if tc.err != nil && !errors.Is(err, tc.err) {
+				t.Errorf("%v no longer matches %v", err, tc.err)
+			}
+		})
+	}
+
+	// Marking twice must not wrap twice.
+	once := kernelFeature(unsupported)
+	if twice := kernelFeature(once); twice != once {
+		t.Errorf("marked again: %v", twice)
+	}
+}
@@ -0,0 +1,55 @@
+package reuseportlb
+
+import "math"
+
+// EWMA is an exponentially weighted moving average. With Adaptive set the
+// smoothing factor follows the Trigg–Leach tracking signal instead of
+// staying at Alpha: the ratio of the smoothed error to the smoothed absolute
+// error is close to 0 while samples scatter around the average (noise, so
+// smooth hard) and close to 1 while they keep landing on one side of it (a
+// real shift, so follow quickly). The result is clamped to [MinAlpha, MaxAlpha].
+type EWMA struct {
+	Alpha    float64
+	Adaptive bool
+	MinAlpha float64
+	MaxAlpha float64
+
+	value   float64
+	err     float64 // smoothed signed error
+	absErr  float64 // smoothed absolute error
+	current float64 // smoothing factor used for the last update
+	primed  bool
+}
+
+// trackingBeta smooths the error terms of the tracking signal. It is kept
+// fixed so the signal itself does not chase the factor it controls.
+const trackingBeta = 0.2
+
+// Update folds x into the average and returns the new value. The first
+// sample seeds the average.
+func (e *EWMA) Update(x float64) float64 {
+	if !e.primed {
+		e.value, e.current, e.primed = x, e.Alpha, true
+		return e.value
+	}
+
+	a := e.Alpha
+	if e.Adaptive {
+		diff := x - e.value
+		e.err = trackingBeta*diff + (1-trackingBeta)*e.err
+		e.absErr = trackingBeta*math.Abs(diff) + (1-trackingBeta)*e.absErr
+		if e.absErr > 0 {
+			a = math.Abs(e.err) / e.absErr
+		}
+		a = math.Max(e.MinAlpha, math.Min(e.MaxAlpha, a))
+	}
+	e.current = a
+	e.value = a*x + (1-a)*e.value
+	return e.value
+}
+
+// Value returns the current average.
+func (e *EWMA) Value() float64 { return e.value }
+
+// CurrentAlpha returns the smoothing factor applied by the last Update.
+func (e *EWMA) CurrentAlpha() float64 { return e.current }
@@ -0,0 +1,120 @@
+package reuseportlb
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Features are the parts of eBPF/ratelimit.h a selector is loaded with.
+// They are fixed when the policy is loaded (sl_features), so the verifier
+// drops the code of those left out and a new connection only pays for the
+// features its group uses. Values match the SL_FEAT_* bits.
+type Features uint32
+
+const (
+	// FeatureOverride places clients pinned with SetOverride.
+	FeatureOverride Features = 1 << iota
+	// FeatureSourceLimit runs the per-source limiter of SetRateLimit.
+	FeatureSourceLimit
+	// FeatureSlotLimits enforces the token buckets of SetSlotLimit.
+	FeatureSlotLimits
+	// FeatureWarmup ramps up slots started with StartWarmup.
+	FeatureWarmup
+	// FeatureShadow lets StartShadow run a candidate next to the selector.
+	FeatureShadow
+	// FeatureCounts counts connections per slot for SelectionCounts, which
+	// the watchdog and federation read.
+	FeatureCounts
+	// FeatureTrace has the selector write what it does to trace_pipe, a
+	// line or more per connection; for debugging only.
+	FeatureTrace
+)
+
+// DefaultFeatures are what a selector is loaded with unless asked for more.
+const DefaultFeatures = FeatureCounts
+
+// AllFeatures is every feature.
+const AllFeatures = FeatureOverride | FeatureSourceLimit | FeatureSlotLimits | FeatureWarmup | FeatureShadow | FeatureCounts | FeatureTrace
+
+// ErrFeatureDisabled is returned when configuring a feature the group's
+// selector was loaded without.
+var ErrFeatureDisabled = errors.New("selector loaded without the feature")
+
+var featureNames = []struct {
+	f    Features
+	name string
+}{
+	{FeatureOverride, "override"},
+	{FeatureSourceLimit, "source-limit"},
+	{FeatureSlotLimits, "slot-limits"},
+	{FeatureWarmup, "warmup"},
+	{FeatureShadow, "shadow"},
+	{FeatureCounts, "counts"},
+	{FeatureTrace, "trace"},
+}
+
+func (f Features) String() string {
+	if f == 0 {
+		return "none"
+	}
+	var names []string
+	for _, n := range featureNames {
+		if f&n.f != 0 {
+			names = append(names, n.name)
+			f &^= n.f
+		}
+	}
+	if f != 0 {
+		names = append(names, fmt.Sprintf("%#x", uint32(f)))
+	}
+	return strings.Join(names, ",")
+}
+
+// ParseFeatures parses a comma-separated list of feature names (override,
+// source-limit, slot-limits, warmup, shadow, counts, trace), "all" or
+// "none".
+func ParseFeatures(s string) (Features, error) {
+	var f Features
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		switch name {
+		case "", "none":
+			continue
+		case "all":
+			f |= AllFeatures
+			continue
+		}
+		found := false
+		for _, n := range featureNames {
+			if n.name == name {
+				f |= n.f
+				found = true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unknown feature %q: must be all, none or of %s", name, AllFeatures)
+		}
+	}
+	return f, nil
+}
+
+// Features returns what the group's selector was loaded with, as its
+// loader recorded it; ok is false when no loader ever did.
+func (g Group) Features() (f Features, ok bool, err error) {
+	info, err := g.loaderInfo()
+	if err != nil || info.Pid == 0 {
+		return 0, false, err
+	}
+	return loaderFeatures(info), true, nil
+}
+
+// requireFeature fails with ErrFeatureDisabled unless the group's selector
+// has f. A group without a recorded loader is not checked.
+func (g Group) requireFeature(f Features) error {
+	have, ok, err := g.Features()
+	if err != nil || !ok || have&f == f {
+		return err
+	}
+	return fmt.Errorf("group %s: %w: %s (load the policy with it)", g, ErrFeatureDisabled, f&^have)
+}
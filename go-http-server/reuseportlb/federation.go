@@ -0,0 +1,320 @@
+package reuseportlb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultFederationInterval is how often a Federation gossips.
+const DefaultFederationInterval = 2 * time.Second
+
+// GroupLoad summarizes the load on one of a host's groups.
+type GroupLoad struct {
+	Group     string `json:"group"`
+	Policy    string `json:"policy"`
+	Listeners int    `json:"listeners"`
+	// ConnRate is the new connections per second the selector placed over
+	// the last gossip interval, from slot_selected; 0 for selectors that
+	// keep no counters.
+	ConnRate float64 `json:"conn_rate"`
+	// QueueFill is the mean fill of the listeners' accept queues, from 0 to
+	// 1, as the collector last saw it.
+	QueueFill float64 `json:"queue_fill"`
+}
+
+// HostLoad is what a host gossips about itself.
+type HostLoad struct {
+	Host string `json:"host"`
+	// Addr is where the host's peers reach its federation endpoint.
+	Addr string `json:"addr"`
+	// Version orders a host's summaries: a peer keeps the highest it has
+	// seen. It is the time the summary was taken on the host's clock, which
+	// only has to move forward.
+	Version int64       `json:"version"`
+	Groups  []GroupLoad `json:"groups"`
+	// Age is how long ago the reporting host last got a newer summary,
+	// filled in when the view is served.
+	Age time.Duration `json:"age_ns"`
+}
+
+// FederatedGroup is a group whose load a Federation reports.
+type FederatedGroup struct {
+	Group  Group
+	Policy string
+}
+
+// FederationConfig sets up a Federation.
+type FederationConfig struct {
+	// Host names this machine; the hostname by default.
+	Host string
+	// Addr is the host:port peers reach this host's endpoint at.
+	Addr string
+	// Peers are host:port addresses to gossip with from the start. Others
+	// are learned from the summaries they pass on.
+	Peers []string
+	// Interval is how often summaries are refreshed and exchanged.
+	Interval time.Duration
+	// Fanout is how many peers each round exchanges with; 2 by default.
+	Fanout int
+	// TTL is how long a host's summary is kept without a newer one; five
+	// intervals by default.
+	TTL    time.Duration
+	Groups []FederatedGroup
+}
+
+// Federation shares each host's load summaries with the others running a
+// balancer, by push-pull gossip over HTTP: every Interval it summarizes its
+// own groups and trades everything it knows with a few peers, so every
+// host learns every other host's load within a few rounds without any of
+// them knowing the whole membership. It only spreads the view; shifting
+// client traffic between hosts (DNS weights, XDP) is left to whoever reads
+// Hosts.
+type Federation struct {
+	cfg    FederationConfig
+	client *http.Client
+	rng    *rand.Rand
+
+	// prev are the selection counters at the last summary, per group.
+	prev   map[Group]map[uint32]uint64
+	prevAt time.Time
+
+	mu    sync.Mutex
+	hosts map[string]*federatedHost
+}
+
+type federatedHost struct {
+	load HostLoad
+	// seen is when this host last received a newer summary, on its own
+	// clock, so that expiry does not depend on the peers' clocks.
+	seen time.Time
+}
+
+// NewFederation returns a Federation for cfg; Run starts the gossip.
+func NewFederation(cfg FederationConfig) (*Federation, error) {
+	if cfg.Addr == "" {
+		return nil, errors.New("federation needs the address peers reach this host at")
+	}
+	if cfg.Host == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("name this host: %w", err)
+		}
+		cfg.Host = host
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultFederationInterval
+	}
+	if cfg.Fanout <= 0 {
+		cfg.Fanout = 2
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = 5 * cfg.Interval
+	}
+	return &Federation{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Interval},
+		rng:    rand.New(rand.NewSource(time.Now().UnixNano())),
+		prev:   make(map[Group]map[uint32]uint64),
+		hosts:  make(map[string]*federatedHost),
+	}, nil
+}
+
+// ParsePeers splits a comma-separated list of host:port peers.
+func ParsePeers(s string) []string {
+	var peers []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			peers = append(peers, p)
+		}
+	}
+	return peers
+}
+
+// Run gossips every Interval until ctx is done.
+func (f *Federation) Run(ctx context.Context) error {
+	ticker := time.NewTicker(f.cfg.Interval)
+	defer ticker.Stop()
+	unreachable := make(map[string]bool)
+	for {
+		f.refresh(time.Now())
+		for _, peer := range f.pickPeers() {
+			err := f.exchange(ctx, peer)
+			if err != nil && !unreachable[peer] && ctx.Err() == nil {
+				slog.Warn("Gossip with peer failed", "peer", peer, "err", err)
+			}
+			unreachable[peer] = err != nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Hosts returns every host with a live summary, this one included, by name.
+func (f *Federation) Hosts() []HostLoad {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.viewLocked(time.Now())
+}
+
+func (f *Federation) viewLocked(now time.Time) []HostLoad {
+	out := make([]HostLoad, 0, len(f.hosts))
+	for name, h := range f.hosts {
+		if name != f.cfg.Host && now.Sub(h.seen) > f.cfg.TTL {
+			delete(f.hosts, name)
+			continue
+		}
+		load := h.load
+		load.Age = now.Sub(h.seen)
+		out = append(out, load)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Host < out[j].Host })
+	return out
+}
+
+// ServeHTTP serves the view on GET, and on POST merges the summaries a
+// peer sends and answers with the view.
+func (f *Federation) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var in []HostLoad
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&in); err != nil {
+			http.Error(w, fmt.Sprintf("decode summaries: %v", err), http.StatusBadRequest)
+			return
+		}
+		f.merge(in, time.Now())
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(f.Hosts())
+}
+
+// merge keeps the newer of each host's summaries. Nobody else speaks for
+// this host.
+func (f *Federation) merge(in []HostLoad, now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, load := range in {
+		if load.Host == "" || load.Host == f.cfg.Host {
+			continue
+		}
+		if h, ok := f.hosts[load.Host]; ok && h.load.Version >= load.Version {
+			continue
+		}
+		load.Age = 0
+		f.hosts[load.Host] = &federatedHost{load: load, seen: now}
+	}
+}
+
+// exchange sends the view to peer and merges the one it answers with.
+func (f *Federation) exchange(ctx context.Context, peer string) error {
+	f.mu.Lock()
+	body, err := json.Marshal(f.viewLocked(time.Now()))
+	f.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+peer+"/federation", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("peer answered %s", resp.Status)
+	}
+	var view []HostLoad
+	if err := json.NewDecoder(resp.Body).Decode(&view); err != nil {
+		return fmt.Errorf("decode peer's view: %w", err)
+	}
+	f.merge(view, time.Now())
+	return nil
+}
+
+// pickPeers chooses up to Fanout peers at random among the configured ones
+// and those learned from gossip.
+func (f *Federation) pickPeers() []string {
+	f.mu.Lock()
+	candidates := make(map[string]bool)
+	for _, p := range f.cfg.Peers {
+		candidates[p] = true
+	}
+	for name, h := range f.hosts {
+		if name != f.cfg.Host && h.load.Addr != "" {
+			candidates[h.load.Addr] = true
+		}
+	}
+	f.mu.Unlock()
+	delete(candidates, f.cfg.Addr)
+
+	peers := make([]string, 0, len(candidates))
+	for p := range candidates {
+		peers = append(peers, p)
+	}
+	sort.Strings(peers)
+	f.rng.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	return peers[:min(f.cfg.Fanout, len(peers))]
+}
+
+// refresh summarizes this host's groups.
+func (f *Federation) refresh(now time.Time) {
+	elapsed := now.Sub(f.prevAt).Seconds()
+	load := HostLoad{Host: f.cfg.Host, Addr: f.cfg.Addr, Version: now.UnixNano()}
+	for _, fg := range f.cfg.Groups {
+		gl := GroupLoad{Group: fg.Group.String(), Policy: fg.Policy}
+		slots, err := fg.Group.listeningSlots()
+		if err != nil {
+			slog.Debug("Summarizing group failed", "group", fg.Group.String(), "err", err)
+		}
+		gl.Listeners = len(slots)
+		var fill float64
+		for _, slot := range slots {
+			if e, err := fg.Group.slotAcceptq(slot); err == nil && e.Max > 0 {
+				fill += float64(e.Curr) / float64(e.Max)
+			}
+		}
+		if len(slots) > 0 {
+			gl.QueueFill = fill / float64(len(slots))
+		}
+		if counts, err := fg.Group.SelectionCounts(); err == nil {
+			if prev, ok := f.prev[fg.Group]; ok && elapsed > 0 {
+				var placed uint64
+				for slot, n := range counts {
+					// A counter that went backwards belongs to a reloaded selector.
+					if p := prev[slot]; p <= n {
+						placed += n - p
+					}
+				}
+				gl.ConnRate = float64(placed) / elapsed
+			}
+			f.prev[fg.Group] = counts
+		}
+		load.Groups = append(load.Groups, gl)
+	}
+	f.prevAt = now
+
+	f.mu.Lock()
+	f.hosts[f.cfg.Host] = &federatedHost{load: load, seen: now}
+	f.mu.Unlock()
+}
@@ -0,0 +1,189 @@
+package reuseportlb
+
+import (
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// The parsers take flag and admin input, so each gets a fuzz target: none
+// may panic, and where the output can be written back it must read the
+// same. The seeds are valid input plus fragments that tend to find edge
+// cases in these formats: separators, numbers at and past the limits of
+// their types, and path components. Run one with
+//
+//	go test -run '^$' -fuzz '^FuzzParseSlotLimits$' ./reuseportlb
+var fuzzFragments = []string{
+	",", "=", ":", "/", "-", " ", "..", "", "\x00", "\xff", "0", "-1",
+	"127", "128", "4294967295", "4294967296", "18446744073709551615",
+	"18446744073709551616", "1e9", "NaN", "+Inf", "0x10", "../..", "/etc",
+}
+
+func addSeeds(f *testing.F, valid ...string) {
+	for _, s := range valid {
+		f.Add(s)
+	}
+	for _, s := range fuzzFragments {
+		f.Add(s)
+	}
+}
+
+func FuzzParseParams(f *testing.F) {
+	addSeeds(f, "group_size=8", "group_size=8,per_cpu=1", "slots=4")
+	f.Fuzz(func(t *testing.T, s string) {
+		values, err := ParseParams(s)
+		if err != nil {
+			return
+		}
+		var pairs []string
+		for name, v := range values {
+			pairs = append(pairs, fmt.Sprintf("%s=%d", name, v))
+		}
+		sort.Strings(pairs)
+		again, err := ParseParams(strings.Join(pairs, ","))
+		if err != nil || !maps.Equal(values, again) {
+			t.Errorf("ParseParams(%q) = %v, which reads back as %v, %v", s, values, again, err)
+		}
+	})
+}
+
+func FuzzParseHealthWeights(f *testing.F) {
+	addSeeds(f, "cpu=2,queue=1,gc=1", "errors=1,net=0.5")
+	f.Fuzz(func(t *testing.T, s string) {
+		ParseHealthWeights(s)
+	})
+}
+
+func FuzzParseSlotLimits(f *testing.F) {
+	addSeeds(f, "3=5/10:drop,4=100", "0=1/1:redistribute")
+	f.Fuzz(func(t *testing.T, s string) {
+		ParseSlotLimits(s)
+	})
+}
+
+func FuzzParsePriorities(f *testing.F) {
+	addSeeds(f, "0=1,1=1,2=2")
+	f.Fuzz(func(t *testing.T, s string) {
+		prios, err := ParsePriorities(s)
+		if err != nil {
+			return
+		}
+		var pairs []string
+		for slot, level := range prios {
+			pairs = append(pairs, fmt.Sprintf("%d=%d", slot, level))
+		}
+		again, err := ParsePriorities(strings.Join(pairs, ","))
+		if err != nil || !maps.Equal(prios, again) {
+			t.Errorf("ParsePriorities(%q) = %v, which reads back as %v, %v", s, prios, again, err)
+		}
+	})
+}
+
+func FuzzParseChain(f *testing.F) {
+	addSeeds(f, "exclude-draining,exclude-overloaded,round-robin", "slow-syn,first")
+	f.Fuzz(func(t *testing.T, s string) {
+		ParseChain(s)
+	})
+}
+
+func FuzzParseCPUList(f *testing.F) {
+	addSeeds(f, "0 1 2 3", "0-7,16-23")
+	f.Fuzz(func(t *testing.T, s string) {
+		cpus, err := ParseCPUList(s)
+		if err != nil {
+			return
+		}
+		seen := make(map[int]bool)
+		for _, c := range cpus {
+			if c < 0 || c >= maxCPUNumber || seen[c] {
+				t.Fatalf("ParseCPUList(%q) = %v: core %d out of range or repeated", s, cpus, c)
+			}
+			seen[c] = true
+		}
+	})
+}
+
+func FuzzParseHousekeepingCPUs(f *testing.F) {
+	addSeeds(f, "auto", "0-1")
+	f.Fuzz(func(t *testing.T, s string) {
+		ParseHousekeepingCPUs(s)
+	})
+}
+
+func FuzzParseTieBreak(f *testing.F) {
+	addSeeds(f, "random", "round-robin")
+	f.Fuzz(func(t *testing.T, s string) {
+		ParseTieBreak(s)
+	})
+}
+
+func FuzzParseRateLimitAction(f *testing.F) {
+	addSeeds(f, "drop", "deprioritize")
+	f.Fuzz(func(t *testing.T, s string) {
+		ParseRateLimitAction(s)
+	})
+}
+
+func FuzzParseSlotLimitAction(f *testing.F) {
+	addSeeds(f, "redistribute", "drop")
+	f.Fuzz(func(t *testing.T, s string) {
+		ParseSlotLimitAction(s)
+	})
+}
+
+func FuzzParseBackends(f *testing.F) {
+	addSeeds(f, "10.0.0.1,10.0.0.2")
+	f.Fuzz(func(t *testing.T, s string) {
+		addrs, err := ParseBackends(s)
+		if err != nil {
+			return
+		}
+		for i, a := range addrs {
+			if !a.Is4() || slices.Contains(addrs[:i], a) {
+				t.Fatalf("ParseBackends(%q) = %v: %s is not IPv4 or repeated", s, addrs, a)
+			}
+		}
+	})
+}
+
+func FuzzParsePeers(f *testing.F) {
+	addSeeds(f, "a:1,b:2")
+	f.Fuzz(func(t *testing.T, s string) {
+		for _, p := range ParsePeers(s) {
+			if p == "" || strings.Contains(p, ",") {
+				t.Fatalf("ParsePeers(%q) has peer %q", s, p)
+			}
+		}
+	})
+}
+
+func FuzzParseFeatures(f *testing.F) {
+	addSeeds(f, "counts,trace", "all", "none", "override, slot-limits")
+	f.Fuzz(func(t *testing.T, s string) {
+		features, err := ParseFeatures(s)
+		if err != nil {
+			return
+		}
+		if again, err := ParseFeatures(features.String()); err != nil || again != features {
+			t.Errorf("ParseFeatures(%q) = %s, which reads back as %s, %v", s, features, again, err)
+		}
+	})
+}
+
+func FuzzParseGroup(f *testing.F) {
+	addSeeds(f, "default", "web-1", "a.b_c")
+	f.Fuzz(func(t *testing.T, s string) {
+		g, err := ParseGroup(s)
+		if err != nil {
+			return
+		}
+		// A group's pins live in a directory named after it under PinPath.
+		if rel, err := filepath.Rel(PinPath, g.PinDir()); err != nil || strings.HasPrefix(rel, "..") {
+			t.Errorf("group %q pins outside %s, at %s", s, PinPath, g.PinDir())
+		}
+	})
+}
@@ -0,0 +1,207 @@
+// Code generated by bpf2go; DO NOT EDIT.
+//go:build 386 || amd64 || arm || arm64 || loong64 || mips64le || mipsle || ppc64le || riscv64
+
+package reuseportlb
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+
+	"github.com/cilium/ebpf"
+)
+
+type gcawareRatelimitCfg struct {
+	Enabled     uint32
+	Action      uint32
+	PenaltySlot uint32
+	MaxConns    uint32
+	WindowNs    uint64
+}
+
+type gcawareShadowState struct {
+	Phase     uint32
+	Candidate uint32
+}
+
+type gcawareSlotBucket struct {
+	Rate      uint64
+	Burst     uint64
+	Action    uint32
+	Pad       uint32
+	Tokens    uint64
+	LastNs    uint64
+	Throttled uint64
+}
+
+type gcawareSlotOverride struct {
+	Slot uint32
+	Hits uint32
+}
+
+type gcawareSlotRuntime struct {
+	UpdatedNs  uint64
+	HeapPct    uint32
+	Goroutines uint32
+	GcCycles   uint32
+	SchedP99Us uint32
+	PauseP99Us uint32
+	Pad        uint32
+}
+
+type gcawareSlotWarmup struct {
+	StartNs  uint64
+	WindowNs uint64
+}
+
+type gcawareSrcRate struct {
+	WindowStart uint64
+	Count       uint32
+	Limited     uint32
+}
+
+// loadGcaware returns the embedded CollectionSpec for gcaware.
+func loadGcaware() (*ebpf.CollectionSpec, error) {
+	reader := bytes.NewReader(_GcawareBytes)
+	spec, err := ebpf.LoadCollectionSpecFromReader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("can't load gcaware: %w", err)
+	}
+
+	return spec, err
+}
+
+// loadGcawareObjects loads gcaware and converts it into a struct.
+//
+// The following types are suitable as obj argument:
+//
+//	*gcawareObjects
+//	*gcawarePrograms
+//	*gcawareMaps
+//
+// See ebpf.CollectionSpec.LoadAndAssign documentation for details.
+func loadGcawareObjects(obj interface{}, opts *ebpf.CollectionOptions) error {
+	spec, err := loadGcaware()
+	if err != nil {
+		return err
+	}
+
+	return spec.LoadAndAssign(obj, opts)
+}
+
+// gcawareSpecs contains maps and programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type gcawareSpecs struct {
+	gcawareProgramSpecs
+	gcawareMapSpecs
+}
+
+// gcawareSpecs contains programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type gcawareProgramSpecs struct {
+	GcawareSelector *ebpf.ProgramSpec `ebpf:"gcaware_selector"`
+}
+
+// gcawareMapSpecs contains maps before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type gcawareMapSpecs struct {
+	AcceptqSlotCookies  *ebpf.MapSpec `ebpf:"acceptq_slot_cookies"`
+	PolicyCfg           *ebpf.MapSpec `ebpf:"policy_cfg"`
+	RatelimitCfg        *ebpf.MapSpec `ebpf:"ratelimit_cfg"`
+	ShadowEvents        *ebpf.MapSpec `ebpf:"shadow_events"`
+	ShadowProgs         *ebpf.MapSpec `ebpf:"shadow_progs"`
+	ShadowScratch       *ebpf.MapSpec `ebpf:"shadow_scratch"`
+	SlotBucket          *ebpf.MapSpec `ebpf:"slot_bucket"`
+	SlotDropping        *ebpf.MapSpec `ebpf:"slot_dropping"`
+	SlotOverride        *ebpf.MapSpec `ebpf:"slot_override"`
+	SlotRuntime         *ebpf.MapSpec `ebpf:"slot_runtime"`
+	SlotSelected        *ebpf.MapSpec `ebpf:"slot_selected"`
+	SlotWarmup          *ebpf.MapSpec `ebpf:"slot_warmup"`
+	SrcRate             *ebpf.MapSpec `ebpf:"src_rate"`
+	TcpBalancingTargets *ebpf.MapSpec `ebpf:"tcp_balancing_targets"`
+}
+
+// gcawareObjects contains all objects after they have been loaded into the kernel.
+//
+// It can be passed to loadGcawareObjects or ebpf.CollectionSpec.LoadAndAssign.
+type gcawareObjects struct {
+	gcawarePrograms
+	gcawareMaps
+}
+
+func (o *gcawareObjects) Close() error {
+	return _GcawareClose(
+		&o.gcawarePrograms,
+		&o.gcawareMaps,
+	)
+}
+
+// gcawareMaps contains all maps after they have been loaded into the kernel.
+//
+// It can be passed to loadGcawareObjects or ebpf.CollectionSpec.LoadAndAssign.
+type gcawareMaps struct {
+	AcceptqSlotCookies  *ebpf.Map `ebpf:"acceptq_slot_cookies"`
+	PolicyCfg           *ebpf.Map `ebpf:"policy_cfg"`
+	RatelimitCfg        *ebpf.Map `ebpf:"ratelimit_cfg"`
+	ShadowEvents        *ebpf.Map `ebpf:"shadow_events"`
+	ShadowProgs         *ebpf.Map `ebpf:"shadow_progs"`
+	ShadowScratch       *ebpf.Map `ebpf:"shadow_scratch"`
+	SlotBucket          *ebpf.Map `ebpf:"slot_bucket"`
+	SlotDropping        *ebpf.Map `ebpf:"slot_dropping"`
+	SlotOverride        *ebpf.Map `ebpf:"slot_override"`
+	SlotRuntime         *ebpf.Map `ebpf:"slot_runtime"`
+	SlotSelected        *ebpf.Map `ebpf:"slot_selected"`
+	SlotWarmup          *ebpf.Map `ebpf:"slot_warmup"`
+	SrcRate             *ebpf.Map `ebpf:"src_rate"`
+	TcpBalancingTargets *ebpf.Map `ebpf:"tcp_balancing_targets"`
+}
+
+func (m *gcawareMaps) Close() error {
+	return _GcawareClose(
+		m.AcceptqSlotCookies,
+		m.PolicyCfg,
+		m.RatelimitCfg,
+		m.ShadowEvents,
+		m.ShadowProgs,
+		m.ShadowScratch,
+		m.SlotBucket,
+		m.SlotDropping,
+		m.SlotOverride,
+		m.SlotRuntime,
+		m.SlotSelected,
+		m.SlotWarmup,
+		m.SrcRate,
+		m.TcpBalancingTargets,
+	)
+}
+
+// gcawarePrograms contains all programs after they have been loaded into the kernel.
+//
+// It can be passed to loadGcawareObjects or ebpf.CollectionSpec.LoadAndAssign.
+type gcawarePrograms struct {
+	GcawareSelector *ebpf.Program `ebpf:"gcaware_selector"`
+}
+
+func (p *gcawarePrograms) Close() error {
+	return _GcawareClose(
+		p.GcawareSelector,
+	)
+}
+
+func _GcawareClose(closers ...io.Closer) error {
+	for _, closer := range closers {
+		if err := closer.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Do not access this directly.
+//
+//go:embed gcaware_bpfel.o
+var _GcawareBytes []byte
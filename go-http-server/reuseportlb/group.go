@@ -0,0 +1,106 @@
+package reuseportlb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// Group names an independent reuseport group: one balanced port or service
+// with its own selector, policy and pinned maps. DefaultGroup keeps its pins
+// directly under PinPath, as before groups existed; every other group pins
+// under PinPath/groups/<name>.
+type Group string
+
+// DefaultGroup is the group used when none is named.
+const DefaultGroup Group = ""
+
+// groupsDir is the directory under PinPath holding the named groups.
+const groupsDir = "groups"
+
+// globalMaps are shared by all groups and always pinned directly under
+// PinPath. acceptq_map is written by the accept queue kprobe, which sees
+// every listener on the host and keys its entries by socket cookie (and
+// publishes acceptq_events), as do the latency probes filling lat_hist, the
+// connection tracker filling conn_stats and the network distress tracker
+// filling net_distress; cpu_util_map describes the host's cores.
+var globalMaps = map[string]bool{
+	AcceptqMap:       true,
+	AcceptqEventsMap: true,
+	ConnStatsMap:     true,
+	CPUUtilMap:       true,
+	LatencyHistMap:   true,
+	LayoutMap:        true,
+	NetDistressMap:   true,
+}
+
+var groupName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// ParseGroup validates a group name as given on a command line. "" and
+// "default" both name DefaultGroup.
+func ParseGroup(name string) (Group, error) {
+	if name == "" || name == "default" {
+		return DefaultGroup, nil
+	}
+	if !groupName.MatchString(name) {
+		return "", fmt.Errorf("invalid group name %q: use letters, digits, '.', '_' and '-'", name)
+	}
+	return Group(name), nil
+}
+
+func (g Group) String() string {
+	if g == DefaultGroup {
+		return "default"
+	}
+	return string(g)
+}
+
+// PinDir returns the bpffs directory the group's maps and selector are
+// pinned in.
+func (g Group) PinDir() string {
+	if g == DefaultGroup {
+		return PinPath
+	}
+	return filepath.Join(PinPath, groupsDir, string(g))
+}
+
+// ensurePinDir creates the group's pin directory.
+func (g Group) ensurePinDir() error {
+	if err := os.MkdirAll(g.PinDir(), 0o700); err != nil {
+		return fmt.Errorf("create pin directory for group %s: %w", g, err)
+	}
+	return nil
+}
+
+// PinnedMapPath returns the bpffs path of the named map in this group.
+func (g Group) PinnedMapPath(name string) string {
+	if globalMaps[name] {
+		return filepath.Join(PinPath, name)
+	}
+	return filepath.Join(g.PinDir(), name)
+}
+
+// ProgramPath returns where lbd pins the group's selector.
+func (g Group) ProgramPath() string {
+	return filepath.Join(g.PinDir(), ProgramPin)
+}
+
+// Groups lists the groups that have pins: DefaultGroup if anything is
+// pinned for it, followed by every named group.
+func Groups() ([]Group, error) {
+	var out []Group
+	if _, err := os.Stat(DefaultGroup.PinnedMapPath(TargetsMap)); err == nil {
+		out = append(out, DefaultGroup)
+	}
+	entries, err := os.ReadDir(filepath.Join(PinPath, groupsDir))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			out = append(out, Group(e.Name()))
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,13 @@
+package reuseportlb
+
+// HardenConfig selects what Harden leaves a server able to do.
+type HardenConfig struct {
+	// User, if set, is the user the process switches to. The process must
+	// have started as root for that.
+	User string
+	// KeepBPF keeps what draining a directly registered server needs:
+	// the bpf syscall and the bpffs file operations behind deregistering
+	// and unpinning. The process keeps its capabilities, so User cannot be
+	// combined with it; servers registered through lbd need neither.
+	KeepBPF bool
+}
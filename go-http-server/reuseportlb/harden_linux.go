@@ -0,0 +1,144 @@
+package reuseportlb
+
+import (
+	"errors"
+	"fmt"
+	"os/user"
+	"runtime"
+	"strconv"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// serveSyscalls are what a Go HTTP(S) server needs once its listener is
+// bound: the runtime (threads, memory, signals, timers, netpoll), socket
+// I/O on accepted connections, reconnecting to lbd after it restarts, and
+// reading files such as /proc for the handlers and log output. Those not
+// numbered on every architecture are in archSyscalls.
+var serveSyscalls = []uintptr{
+	unix.SYS_READ, unix.SYS_WRITE, unix.SYS_READV, unix.SYS_WRITEV,
+	unix.SYS_PREAD64, unix.SYS_PWRITE64, unix.SYS_CLOSE, unix.SYS_LSEEK,
+	unix.SYS_OPENAT, unix.SYS_FSTAT, unix.SYS_STATX, unix.SYS_FCNTL,
+	unix.SYS_READLINKAT, unix.SYS_GETCWD,
+	unix.SYS_ACCEPT4, unix.SYS_RECVFROM, unix.SYS_SENDTO,
+	unix.SYS_RECVMSG, unix.SYS_SENDMSG, unix.SYS_SHUTDOWN, unix.SYS_SOCKET, unix.SYS_CONNECT,
+	unix.SYS_GETSOCKNAME, unix.SYS_GETPEERNAME, unix.SYS_GETSOCKOPT, unix.SYS_SETSOCKOPT,
+	unix.SYS_EPOLL_CREATE1, unix.SYS_EPOLL_CTL, unix.SYS_EPOLL_PWAIT,
+	unix.SYS_PIPE2, unix.SYS_EVENTFD2,
+	unix.SYS_FUTEX, unix.SYS_NANOSLEEP, unix.SYS_CLOCK_NANOSLEEP, unix.SYS_CLOCK_GETTIME,
+	unix.SYS_GETTIMEOFDAY, unix.SYS_SCHED_YIELD, unix.SYS_SCHED_GETAFFINITY,
+	unix.SYS_MUNMAP, unix.SYS_MPROTECT, unix.SYS_MADVISE, unix.SYS_MINCORE, unix.SYS_BRK,
+	unix.SYS_CLONE, unix.SYS_CLONE3, unix.SYS_SET_ROBUST_LIST, unix.SYS_RSEQ,
+	unix.SYS_EXIT, unix.SYS_EXIT_GROUP, unix.SYS_RESTART_SYSCALL,
+	unix.SYS_RT_SIGACTION, unix.SYS_RT_SIGPROCMASK, unix.SYS_RT_SIGRETURN, unix.SYS_SIGALTSTACK,
+	unix.SYS_GETPID, unix.SYS_GETTID, unix.SYS_TGKILL,
+	unix.SYS_SETITIMER, unix.SYS_TIMER_CREATE, unix.SYS_TIMER_SETTIME, unix.SYS_TIMER_DELETE,
+	unix.SYS_GETRANDOM, unix.SYS_UNAME, unix.SYS_PRLIMIT64, unix.SYS_GETRUSAGE,
+	unix.SYS_GETUID, unix.SYS_GETEUID, unix.SYS_GETGID, unix.SYS_GETEGID,
+}
+
+// bpfSyscalls are added with HardenConfig.KeepBPF.
+var bpfSyscalls = []uintptr{
+	unix.SYS_BPF, unix.SYS_FLOCK, unix.SYS_UNLINKAT, unix.SYS_MKDIRAT,
+	unix.SYS_STATFS, unix.SYS_FSTATFS,
+}
+
+// Harden is an opt-in lockdown for servers on shared machines, applied once
+// the eBPF objects are loaded and the listener is bound and registered. It
+// optionally switches to an unprivileged user, then installs a seccomp
+// filter on every thread allowing only the syscalls serving needs. Other
+// syscalls fail with EPERM rather than killing the process, so an
+// unexpected code path shows up as an error in the logs.
+func Harden(cfg HardenConfig) error {
+	if hardenAuditArch == 0 {
+		return fmt.Errorf("hardening is not supported on %s", runtime.GOARCH)
+	}
+	if cfg.User != "" {
+		if cfg.KeepBPF {
+			return errors.New("cannot switch user while keeping BPF access; register through lbd instead")
+		}
+		if err := dropToUser(cfg.User); err != nil {
+			return err
+		}
+	}
+
+	allowed := append(append([]uintptr{}, serveSyscalls...), archSyscalls...)
+	if cfg.KeepBPF {
+		allowed = append(append(allowed, bpfSyscalls...), archBPFSyscalls...)
+	}
+	filter := seccompFilter(allowed)
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("set no_new_privs: %w", err)
+	}
+	// TSYNC applies the filter (and no_new_privs) to all of the runtime's
+	// threads, not just this one.
+	if _, _, errno := unix.Syscall(unix.SYS_SECCOMP, unix.SECCOMP_SET_MODE_FILTER,
+		unix.SECCOMP_FILTER_FLAG_TSYNC, uintptr(unsafe.Pointer(&prog))); errno != 0 {
+		return fmt.Errorf("install seccomp filter: %w", errno)
+	}
+	return nil
+}
+
+// dropToUser switches every thread to name's uid and gid and drops
+// supplementary groups.
+func dropToUser(name string) error {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return err
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return fmt.Errorf("uid of %s: %w", name, err)
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return fmt.Errorf("gid of %s: %w", name, err)
+	}
+	// The syscall package applies these to all threads.
+	if err := syscall.Setgroups(nil); err != nil {
+		return fmt.Errorf("drop supplementary groups: %w", err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("setgid %d: %w", gid, err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("setuid %d: %w", uid, err)
+	}
+	return nil
+}
+
+// seccompFilter builds a classic BPF program allowing exactly the given
+// syscalls of this architecture.
+func seccompFilter(allowed []uintptr) []unix.SockFilter {
+	const (
+		offNr   = 0 // offsetof(struct seccomp_data, nr)
+		offArch = 4 // offsetof(struct seccomp_data, arch)
+	)
+	ld := func(off uint32) unix.SockFilter {
+		return unix.SockFilter{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: off}
+	}
+	ret := func(v uint32) unix.SockFilter {
+		return unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: v}
+	}
+	jeq := func(v uint32, jt, jf uint8) unix.SockFilter {
+		return unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: jt, Jf: jf, K: v}
+	}
+
+	f := []unix.SockFilter{
+		ld(offArch),
+		jeq(hardenAuditArch, 1, 0),
+		ret(unix.SECCOMP_RET_KILL_PROCESS),
+		ld(offNr),
+	}
+	// Each match jumps over the remaining comparisons and the deny.
+	for i, nr := range allowed {
+		f = append(f, jeq(uint32(nr), uint8(len(allowed)-i), 0))
+	}
+	return append(f,
+		ret(unix.SECCOMP_RET_ERRNO|uint32(unix.EPERM)),
+		ret(unix.SECCOMP_RET_ALLOW))
+}
@@ -0,0 +1,15 @@
+package reuseportlb
+
+import "golang.org/x/sys/unix"
+
+const hardenAuditArch = unix.AUDIT_ARCH_X86_64
+
+// archSyscalls are the syscalls whose numbers only some architectures
+// have, and the legacy ones amd64 still has, that Go may use.
+var archSyscalls = []uintptr{
+	unix.SYS_MMAP, unix.SYS_ACCEPT,
+	unix.SYS_EPOLL_WAIT, unix.SYS_NEWFSTATAT, unix.SYS_ARCH_PRCTL,
+	unix.SYS_OPEN, unix.SYS_STAT, unix.SYS_LSTAT, unix.SYS_PIPE,
+}
+
+var archBPFSyscalls = []uintptr{unix.SYS_UNLINK, unix.SYS_RMDIR, unix.SYS_MKDIR}
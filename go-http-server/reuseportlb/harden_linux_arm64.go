@@ -0,0 +1,11 @@
+package reuseportlb
+
+import "golang.org/x/sys/unix"
+
+const hardenAuditArch = unix.AUDIT_ARCH_AARCH64
+
+// archSyscalls are the syscalls whose numbers only some architectures
+// have that Go may use.
+var archSyscalls = []uintptr{unix.SYS_MMAP, unix.SYS_ACCEPT, unix.SYS_FSTATAT}
+
+var archBPFSyscalls []uintptr
@@ -0,0 +1,8 @@
+//go:build linux && !amd64 && !arm64
+
+package reuseportlb
+
+// Harden only knows the syscall tables of amd64 and arm64.
+const hardenAuditArch = 0
+
+var archSyscalls, archBPFSyscalls []uintptr
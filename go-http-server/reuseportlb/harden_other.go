@@ -0,0 +1,9 @@
+//go:build !linux
+
+package reuseportlb
+
+// Harden restricts the process to the syscalls serving needs; it relies on
+// seccomp and is only available on Linux.
+func Harden(cfg HardenConfig) error {
+	return errNotLinux
+}
@@ -0,0 +1,223 @@
+package reuseportlb
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cilium/ebpf"
+)
+
+// DefaultHealthInterval is how often a HealthScorer publishes.
+const DefaultHealthInterval = 100 * time.Millisecond
+
+// HealthSignal is one input to a slot's health score: a reading from 0
+// (unhealthy) to 100 (healthy) and the weight it gets in the average.
+// Score returns ok false when it has nothing to say, which leaves the
+// signal out of the average.
+type HealthSignal struct {
+	Name   string
+	Weight float64
+	Score  func() (score float64, ok bool)
+}
+
+// HealthScorer folds a server's signals into the 0-100 score the
+// healthscore policy picks slots in proportion to, and publishes it in
+// slot_health. Built-in signals come from HealthSignals; applications
+// add their own with Add.
+type HealthScorer struct {
+	group Group
+	slot  uint32
+
+	mu      sync.Mutex
+	signals []HealthSignal
+	last    map[string]float64
+	score   uint32
+}
+
+// NewHealthScorer returns a scorer for slot with no signals. Until one is
+// added it publishes 100.
+func (g Group) NewHealthScorer(slot uint32) *HealthScorer {
+	return &HealthScorer{group: g, slot: slot, last: make(map[string]float64)}
+}
+
+// Add adds a signal. Weights need not sum to anything; a signal with
+// weight 0 is reported but does not count.
+func (h *HealthScorer) Add(s HealthSignal) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.signals = append(h.signals, s)
+}
+
+// Score computes the score from the current readings.
+func (h *HealthScorer) Score() uint32 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var sum, weights float64
+	clear(h.last)
+	for _, s := range h.signals {
+		v, ok := s.Score()
+		if !ok {
+			continue
+		}
+		v = min(max(v, 0), 100)
+		h.last[s.Name] = v
+		sum += v * s.Weight
+		weights += s.Weight
+	}
+	h.score = 100
+	if weights > 0 {
+		h.score = uint32(sum/weights + 0.5)
+	}
+	return h.score
+}
+
+// Snapshot returns the last score and the signal readings it came from.
+func (h *HealthScorer) Snapshot() (uint32, map[string]float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	parts := make(map[string]float64, len(h.last))
+	for k, v := range h.last {
+		parts[k] = v
+	}
+	return h.score, parts
+}
+
+// Run publishes the score every interval until ctx is done, then clears
+// the slot's entry so the policy treats it as unknown.
+func (h *HealthScorer) Run(ctx context.Context, interval time.Duration) error {
+	m, err := h.group.OpenOrCreatePinnedMap(SlotHealthMap)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	failing := false
+	for {
+		v := SlotHealth{Score: h.Score()}
+		now, err := monotonicNow()
+		if err == nil {
+			v.UpdatedNs = now
+			err = m.Update(h.slot, v, ebpf.UpdateAny)
+		}
+		if err != nil && !failing {
+			slog.Warn("Publishing health score failed", "err", err)
+		}
+		failing = err != nil
+
+		select {
+		case <-ctx.Done():
+			m.Update(h.slot, SlotHealth{}, ebpf.UpdateAny)
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// healthSignalNames are the keys of HealthSignals.
+var healthSignalNames = []string{"cpu", "errors", "gc", "net", "psi", "queue"}
+
+// HealthSignals are the built-in signals by name:
+//
+//	cpu    100 minus the slot's utilization in slot_util (needs a collector)
+//	queue  100 minus the fill of the slot's accept queue (needs the tracker)
+//	gc     100 while the heap is under half the GC goal, falling to 0 at it
+//	psi    100 minus the slot's CPU pressure in slot_psi (needs a collector)
+//	errors 100 minus 10 per percent of requests failing, from slot_errors
+//	       (needs an ErrorCounter)
+//	net    the slot's network distress score in slot_net, which falls as
+//	       its retransmits and ECN reductions rise above the group's
+//	       median (needs a collector with the distress tracker)
+func (g Group) HealthSignals(slot uint32) map[string]func() (float64, bool) {
+	rt := newRuntimeReader()
+	return map[string]func() (float64, bool){
+		"cpu": func() (float64, bool) {
+			util, ok := g.slotUtil()[slot]
+			return 100 - float64(util)/100, ok
+		},
+		"queue": func() (float64, bool) {
+			e, err := g.slotAcceptq(slot)
+			if err != nil || e.Max == 0 {
+				return 0, false
+			}
+			return 100 - float64(e.Curr)*100/float64(e.Max), true
+		},
+		"psi": func() (float64, bool) {
+			pressure, err := g.SlotPressure()
+			if err != nil {
+				return 0, false
+			}
+			v, ok := pressure[slot]
+			return 100 - float64(v.Some)/100, ok
+		},
+		"errors": func() (float64, bool) {
+			rates, err := g.SlotErrorRates()
+			if err != nil {
+				return 0, false
+			}
+			v, ok := rates[slot]
+			return 100 - float64(v.Rate)/10, ok
+		},
+		"net": func() (float64, bool) {
+			distress, err := g.SlotNetDistress()
+			if err != nil {
+				return 0, false
+			}
+			v, ok := distress[slot]
+			return float64(v.Score), ok
+		},
+		"gc": func() (float64, bool) {
+			pct := float64(rt.read().HeapPct)
+			return 100 - max(pct-50, 0)*2, true
+		},
+	}
+}
+
+// slotAcceptq reads the accept queue entry of slot's listener.
+func (g Group) slotAcceptq(slot uint32) (AcceptqEntry, error) {
+	cookies, err := g.OpenPinnedMap(SlotCookiesMap)
+	if err != nil {
+		return AcceptqEntry{}, err
+	}
+	defer cookies.Close()
+	var cookie uint64
+	if err := cookies.Lookup(slot, &cookie); err != nil {
+		return AcceptqEntry{}, err
+	}
+	m, err := g.OpenPinnedMap(AcceptqMap)
+	if err != nil {
+		return AcceptqEntry{}, err
+	}
+	defer m.Close()
+	return lookupAcceptq(m, cookie, "max")
+}
+
+// ParseHealthWeights parses comma-separated name=weight pairs naming
+// built-in signals, such as "cpu=2,queue=1,gc=1".
+func ParseHealthWeights(s string) (map[string]float64, error) {
+	out := make(map[string]float64)
+	if s == "" {
+		return out, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		name, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return nil, fmt.Errorf("health signal %q: want name=weight", pair)
+		}
+		if !slices.Contains(healthSignalNames, name) {
+			return nil, fmt.Errorf("unknown health signal %q (have %s)", name, strings.Join(healthSignalNames, ", "))
+		}
+		w, err := strconv.ParseFloat(v, 64)
+		if err != nil || w < 0 {
+			return nil, fmt.Errorf("health signal %q: invalid weight %q", name, v)
+		}
+		out[name] = w
+	}
+	return out, nil
+}
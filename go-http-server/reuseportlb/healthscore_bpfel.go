@@ -0,0 +1,203 @@
+// Code generated by bpf2go; DO NOT EDIT.
+//go:build 386 || amd64 || arm || arm64 || loong64 || mips64le || mipsle || ppc64le || riscv64
+
+package reuseportlb
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+
+	"github.com/cilium/ebpf"
+)
+
+type healthscoreRatelimitCfg struct {
+	Enabled     uint32
+	Action      uint32
+	PenaltySlot uint32
+	MaxConns    uint32
+	WindowNs    uint64
+}
+
+type healthscoreShadowState struct {
+	Phase     uint32
+	Candidate uint32
+}
+
+type healthscoreSlotBucket struct {
+	Rate      uint64
+	Burst     uint64
+	Action    uint32
+	Pad       uint32
+	Tokens    uint64
+	LastNs    uint64
+	Throttled uint64
+}
+
+type healthscoreSlotHealth struct {
+	UpdatedNs uint64
+	Score     uint32
+	Pad       uint32
+}
+
+type healthscoreSlotOverride struct {
+	Slot uint32
+	Hits uint32
+}
+
+type healthscoreSlotWarmup struct {
+	StartNs  uint64
+	WindowNs uint64
+}
+
+type healthscoreSrcRate struct {
+	WindowStart uint64
+	Count       uint32
+	Limited     uint32
+}
+
+// loadHealthscore returns the embedded CollectionSpec for healthscore.
+func loadHealthscore() (*ebpf.CollectionSpec, error) {
+	reader := bytes.NewReader(_HealthscoreBytes)
+	spec, err := ebpf.LoadCollectionSpecFromReader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("can't load healthscore: %w", err)
+	}
+
+	return spec, err
+}
+
+// loadHealthscoreObjects loads healthscore and converts it into a struct.
+//
+// The following types are suitable as obj argument:
+//
+//	*healthscoreObjects
+//	*healthscorePrograms
+//	*healthscoreMaps
+//
+// See ebpf.CollectionSpec.LoadAndAssign documentation for details.
+func loadHealthscoreObjects(obj interface{}, opts *ebpf.CollectionOptions) error {
+	spec, err := loadHealthscore()
+	if err != nil {
+		return err
+	}
+
+	return spec.LoadAndAssign(obj, opts)
+}
+
+// healthscoreSpecs contains maps and programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type healthscoreSpecs struct {
+	healthscoreProgramSpecs
+	healthscoreMapSpecs
+}
+
+// healthscoreSpecs contains programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type healthscoreProgramSpecs struct {
+	HealthscoreSelector *ebpf.ProgramSpec `ebpf:"healthscore_selector"`
+}
+
+// healthscoreMapSpecs contains maps before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type healthscoreMapSpecs struct {
+	AcceptqSlotCookies  *ebpf.MapSpec `ebpf:"acceptq_slot_cookies"`
+	PolicyCfg           *ebpf.MapSpec `ebpf:"policy_cfg"`
+	RatelimitCfg        *ebpf.MapSpec `ebpf:"ratelimit_cfg"`
+	ShadowEvents        *ebpf.MapSpec `ebpf:"shadow_events"`
+	ShadowProgs         *ebpf.MapSpec `ebpf:"shadow_progs"`
+	ShadowScratch       *ebpf.MapSpec `ebpf:"shadow_scratch"`
+	SlotBucket          *ebpf.MapSpec `ebpf:"slot_bucket"`
+	SlotDropping        *ebpf.MapSpec `ebpf:"slot_dropping"`
+	SlotHealth          *ebpf.MapSpec `ebpf:"slot_health"`
+	SlotOverride        *ebpf.MapSpec `ebpf:"slot_override"`
+	SlotSelected        *ebpf.MapSpec `ebpf:"slot_selected"`
+	SlotWarmup          *ebpf.MapSpec `ebpf:"slot_warmup"`
+	SrcRate             *ebpf.MapSpec `ebpf:"src_rate"`
+	TcpBalancingTargets *ebpf.MapSpec `ebpf:"tcp_balancing_targets"`
+}
+
+// healthscoreObjects contains all objects after they have been loaded into the kernel.
+//
+// It can be passed to loadHealthscoreObjects or ebpf.CollectionSpec.LoadAndAssign.
+type healthscoreObjects struct {
+	healthscorePrograms
+	healthscoreMaps
+}
+
+func (o *healthscoreObjects) Close() error {
+	return _HealthscoreClose(
+		&o.healthscorePrograms,
+		&o.healthscoreMaps,
+	)
+}
+
+// healthscoreMaps contains all maps after they have been loaded into the kernel.
+//
+// It can be passed to loadHealthscoreObjects or ebpf.CollectionSpec.LoadAndAssign.
+type healthscoreMaps struct {
+	AcceptqSlotCookies  *ebpf.Map `ebpf:"acceptq_slot_cookies"`
+	PolicyCfg           *ebpf.Map `ebpf:"policy_cfg"`
+	RatelimitCfg        *ebpf.Map `ebpf:"ratelimit_cfg"`
+	ShadowEvents        *ebpf.Map `ebpf:"shadow_events"`
+	ShadowProgs         *ebpf.Map `ebpf:"shadow_progs"`
+	ShadowScratch       *ebpf.Map `ebpf:"shadow_scratch"`
+	SlotBucket          *ebpf.Map `ebpf:"slot_bucket"`
+	SlotDropping        *ebpf.Map `ebpf:"slot_dropping"`
+	SlotHealth          *ebpf.Map `ebpf:"slot_health"`
+	SlotOverride        *ebpf.Map `ebpf:"slot_override"`
+	SlotSelected        *ebpf.Map `ebpf:"slot_selected"`
+	SlotWarmup          *ebpf.Map `ebpf:"slot_warmup"`
+	SrcRate             *ebpf.Map `ebpf:"src_rate"`
+	TcpBalancingTargets *ebpf.Map `ebpf:"tcp_balancing_targets"`
+}
+
+func (m *healthscoreMaps) Close() error {
+	return _HealthscoreClose(
+		m.AcceptqSlotCookies,
+		m.PolicyCfg,
+		m.RatelimitCfg,
+		m.ShadowEvents,
+		m.ShadowProgs,
+		m.ShadowScratch,
+		m.SlotBucket,
+		m.SlotDropping,
+		m.SlotHealth,
+		m.SlotOverride,
+		m.SlotSelected,
+		m.SlotWarmup,
+		m.SrcRate,
+		m.TcpBalancingTargets,
+	)
+}
+
+// healthscorePrograms contains all programs after they have been loaded into the kernel.
+//
+// It can be passed to loadHealthscoreObjects or ebpf.CollectionSpec.LoadAndAssign.
+type healthscorePrograms struct {
+	HealthscoreSelector *ebpf.Program `ebpf:"healthscore_selector"`
+}
+
+func (p *healthscorePrograms) Close() error {
+	return _HealthscoreClose(
+		p.HealthscoreSelector,
+	)
+}
+
+func _HealthscoreClose(closers ...io.Closer) error {
+	for _, closer := range closers {
+		if err := closer.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Do not access this directly.
+//
+//go:embed healthscore_bpfel.o
+var _HealthscoreBytes []byte
@@ -0,0 +1,232 @@
+// Code generated by bpf2go; DO NOT EDIT.
+//go:build 386 || amd64 || arm || arm64 || loong64 || mips64le || mipsle || ppc64le || riscv64
+
+package reuseportlb
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+
+	"github.com/cilium/ebpf"
+)
+
+type hotstandbyAcceptq struct {
+	Curr uint32
+	Max  uint32
+	Cpu  uint32
+}
+
+type hotstandbyRatelimitCfg struct {
+	Enabled     uint32
+	Action      uint32
+	PenaltySlot uint32
+	MaxConns    uint32
+	WindowNs    uint64
+}
+
+type hotstandbyShadowState struct {
+	Phase     uint32
+	Candidate uint32
+}
+
+type hotstandbySlotBucket struct {
+	Rate      uint64
+	Burst     uint64
+	Action    uint32
+	Pad       uint32
+	Tokens    uint64
+	LastNs    uint64
+	Throttled uint64
+}
+
+type hotstandbySlotOverride struct {
+	Slot uint32
+	Hits uint32
+}
+
+type hotstandbySlotWarmup struct {
+	StartNs  uint64
+	WindowNs uint64
+}
+
+type hotstandbySrcRate struct {
+	WindowStart uint64
+	Count       uint32
+	Limited     uint32
+}
+
+type hotstandbyStandbyCfg struct {
+	Primary           uint32
+	Standby           uint32
+	TimeoutNs         uint64
+	SpillThresholdPct uint32
+	SpillPct          uint32
+	Priorities        uint32
+	Pad               uint32
+}
+
+type hotstandbyStandbyState struct {
+	Active          uint32
+	Previous        uint32
+	Level           uint32
+	PreviousLevel   uint32
+	SwitchedNs      uint64
+	LastHeartbeatNs uint64
+	Switches        uint64
+}
+
+// loadHotstandby returns the embedded CollectionSpec for hotstandby.
+func loadHotstandby() (*ebpf.CollectionSpec, error) {
+	reader := bytes.NewReader(_HotstandbyBytes)
+	spec, err := ebpf.LoadCollectionSpecFromReader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("can't load hotstandby: %w", err)
+	}
+
+	return spec, err
+}
+
+// loadHotstandbyObjects loads hotstandby and converts it into a struct.
+//
+// The following types are suitable as obj argument:
+//
+//	*hotstandbyObjects
+//	*hotstandbyPrograms
+//	*hotstandbyMaps
+//
+// See ebpf.CollectionSpec.LoadAndAssign documentation for details.
+func loadHotstandbyObjects(obj interface{}, opts *ebpf.CollectionOptions) error {
+	spec, err := loadHotstandby()
+	if err != nil {
+		return err
+	}
+
+	return spec.LoadAndAssign(obj, opts)
+}
+
+// hotstandbySpecs contains maps and programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type hotstandbySpecs struct {
+	hotstandbyProgramSpecs
+	hotstandbyMapSpecs
+}
+
+// hotstandbySpecs contains programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type hotstandbyProgramSpecs struct {
+	HotStandby *ebpf.ProgramSpec `ebpf:"hot_standby"`
+}
+
+// hotstandbyMapSpecs contains maps before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type hotstandbyMapSpecs struct {
+	AcceptqMap          *ebpf.MapSpec `ebpf:"acceptq_map"`
+	AcceptqSlotCookies  *ebpf.MapSpec `ebpf:"acceptq_slot_cookies"`
+	RatelimitCfg        *ebpf.MapSpec `ebpf:"ratelimit_cfg"`
+	ShadowEvents        *ebpf.MapSpec `ebpf:"shadow_events"`
+	ShadowProgs         *ebpf.MapSpec `ebpf:"shadow_progs"`
+	ShadowScratch       *ebpf.MapSpec `ebpf:"shadow_scratch"`
+	SlotBucket          *ebpf.MapSpec `ebpf:"slot_bucket"`
+	SlotDropping        *ebpf.MapSpec `ebpf:"slot_dropping"`
+	SlotOverride        *ebpf.MapSpec `ebpf:"slot_override"`
+	SlotSelected        *ebpf.MapSpec `ebpf:"slot_selected"`
+	SlotWarmup          *ebpf.MapSpec `ebpf:"slot_warmup"`
+	SrcRate             *ebpf.MapSpec `ebpf:"src_rate"`
+	StandbyCfg          *ebpf.MapSpec `ebpf:"standby_cfg"`
+	StandbyHeartbeat    *ebpf.MapSpec `ebpf:"standby_heartbeat"`
+	StandbyPriority     *ebpf.MapSpec `ebpf:"standby_priority"`
+	StandbyState        *ebpf.MapSpec `ebpf:"standby_state"`
+	TcpBalancingTargets *ebpf.MapSpec `ebpf:"tcp_balancing_targets"`
+}
+
+// hotstandbyObjects contains all objects after they have been loaded into the kernel.
+//
+// It can be passed to loadHotstandbyObjects or ebpf.CollectionSpec.LoadAndAssign.
+type hotstandbyObjects struct {
+	hotstandbyPrograms
+	hotstandbyMaps
+}
+
+func (o *hotstandbyObjects) Close() error {
+	return _HotstandbyClose(
+		&o.hotstandbyPrograms,
+		&o.hotstandbyMaps,
+	)
+}
+
+// hotstandbyMaps contains all maps after they have been loaded into the kernel.
+//
+// It can be passed to loadHotstandbyObjects or ebpf.CollectionSpec.LoadAndAssign.
+type hotstandbyMaps struct {
+	AcceptqMap          *ebpf.Map `ebpf:"acceptq_map"`
+	AcceptqSlotCookies  *ebpf.Map `ebpf:"acceptq_slot_cookies"`
+	RatelimitCfg        *ebpf.Map `ebpf:"ratelimit_cfg"`
+	ShadowEvents        *ebpf.Map `ebpf:"shadow_events"`
+	ShadowProgs         *ebpf.Map `ebpf:"shadow_progs"`
+	ShadowScratch       *ebpf.Map `ebpf:"shadow_scratch"`
+	SlotBucket          *ebpf.Map `ebpf:"slot_bucket"`
+	SlotDropping        *ebpf.Map `ebpf:"slot_dropping"`
+	SlotOverride        *ebpf.Map `ebpf:"slot_override"`
+	SlotSelected        *ebpf.Map `ebpf:"slot_selected"`
+	SlotWarmup          *ebpf.Map `ebpf:"slot_warmup"`
+	SrcRate             *ebpf.Map `ebpf:"src_rate"`
+	StandbyCfg          *ebpf.Map `ebpf:"standby_cfg"`
+	StandbyHeartbeat    *ebpf.Map `ebpf:"standby_heartbeat"`
+	StandbyPriority     *ebpf.Map `ebpf:"standby_priority"`
+	StandbyState        *ebpf.Map `ebpf:"standby_state"`
+	TcpBalancingTargets *ebpf.Map `ebpf:"tcp_balancing_targets"`
+}
+
+func (m *hotstandbyMaps) Close() error {
+	return _HotstandbyClose(
+		m.AcceptqMap,
+		m.AcceptqSlotCookies,
+		m.RatelimitCfg,
+		m.ShadowEvents,
+		m.ShadowProgs,
+		m.ShadowScratch,
+		m.SlotBucket,
+		m.SlotDropping,
+		m.SlotOverride,
+		m.SlotSelected,
+		m.SlotWarmup,
+		m.SrcRate,
+		m.StandbyCfg,
+		m.StandbyHeartbeat,
+		m.StandbyPriority,
+		m.StandbyState,
+		m.TcpBalancingTargets,
+	)
+}
+
+// hotstandbyPrograms contains all programs after they have been loaded into the kernel.
+//
+// It can be passed to loadHotstandbyObjects or ebpf.CollectionSpec.LoadAndAssign.
+type hotstandbyPrograms struct {
+	HotStandby *ebpf.Program `ebpf:"hot_standby"`
+}
+
+func (p *hotstandbyPrograms) Close() error {
+	return _HotstandbyClose(
+		p.HotStandby,
+	)
+}
+
+func _HotstandbyClose(closers ...io.Closer) error {
+	for _, closer := range closers {
+		if err := closer.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Do not access this directly.
+//
+//go:embed hotstandby_bpfel.o
+var _HotstandbyBytes []byte
@@ -0,0 +1,152 @@
+package reuseportlb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/cilium/ebpf"
+)
+
+// Join records this process as a running instance of the group in the
+// pinned instances map (pid -> process start time). The returned leave
+// func takes it out again and, if it was the last live instance, unpins the
+// group's selector, links and maps so the next run starts clean. Entries of
+// processes that died without leaving are pruned there too, so a crashed
+// instance does not keep the pins alive forever.
+func (g Group) Join() (leave func() error, err error) {
+	unlock, err := g.lockPins()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	m, err := g.openOrCreateAudited(InstancesMap)
+	if err != nil {
+		return nil, err
+	}
+	defer m.Close()
+	pid := uint32(os.Getpid())
+	start, err := procStartTime(int(pid))
+	if err != nil {
+		return nil, err
+	}
+	if err := m.Update(&pid, &start, ebpf.UpdateAny); err != nil {
+		return nil, fmt.Errorf("update %s: %w", InstancesMap, err)
+	}
+	return func() error { return g.leave(pid) }, nil
+}
+
+func (g Group) leave(pid uint32) error {
+	unlock, err := g.lockPins()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	m, err := g.openAudited(InstancesMap)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	if err := m.Delete(&pid); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+		return fmt.Errorf("delete from %s: %w", InstancesMap, err)
+	}
+	live, err := liveInstances(m.Map)
+	if err != nil {
+		return err
+	}
+	// A loader that has not joined yet is using the pins too.
+	if loader, err := g.Loader(); err == nil && loader != 0 && loader != int(pid) {
+		live++
+	}
+	if live > 0 {
+		slog.Debug("Leaving pins to the remaining instances", "group", g.String(), "instances", live)
+		return nil
+	}
+	slog.Info("Last instance of the group exiting, unpinning", "group", g.String(), "path", g.PinDir())
+	return g.Unpin()
+}
+
+// liveInstances counts the instances in m whose process still runs,
+// deleting the entries of those that do not.
+func liveInstances(m *ebpf.Map) (int, error) {
+	var (
+		pid, live uint32
+		start     uint64
+		dead      []uint32
+	)
+	iter := m.Iterate()
+	for iter.Next(&pid, &start) {
+		if now, err := procStartTime(int(pid)); err == nil && now == start {
+			live++
+		} else {
+			dead = append(dead, pid)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return 0, fmt.Errorf("iterate %s: %w", InstancesMap, err)
+	}
+	for i := range dead {
+		m.Delete(&dead[i])
+	}
+	return int(live), nil
+}
+
+// procStartTime returns when pid started, in clock ticks after boot. Pids
+// are reused, so an instance is identified by both.
+func procStartTime(pid int) (uint64, error) {
+	b, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+	// comm may contain spaces and parentheses; the fields after it do not.
+	i := bytes.LastIndexByte(b, ')')
+	if i < 0 {
+		return 0, fmt.Errorf("malformed /proc/%d/stat", pid)
+	}
+	fields := strings.Fields(string(b[i+1:]))
+	// starttime is field 22 of stat; fields[0] is field 3 (state).
+	if len(fields) < 20 {
+		return 0, fmt.Errorf("malformed /proc/%d/stat", pid)
+	}
+	return strconv.ParseUint(fields[19], 10, 64)
+}
+
+// pinPaths lists everything that may be pinned for the group.
+func (g Group) pinPaths() []string {
+	paths := []string{g.ProgramPath(), g.steerLinkPath(), g.jsqLinkPath()}
+	paths = append(paths, g.stagePaths()...)
+	for name := range mapLayouts {
+		// The journal outlives the pins so that history covers restarts.
+		if !globalMaps[name] && name != JournalMap {
+			paths = append(paths, g.PinnedMapPath(name))
+		}
+	}
+	return paths
+}
+
+// Unpin removes everything pinned for the group. Selectors stay attached to
+// the sockets using them until those close; pinned sk_lookup and sock_ops
+// links are detached. The host-wide maps and the attachment journal are
+// left alone, and so is anything in the pin directory this package did not
+// put there.
+func (g Group) Unpin() error {
+	g.journal(EventUnpin, nil, "")
+	var errs []error
+	for _, path := range g.pinPaths() {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	if g != DefaultGroup {
+		// Fails, harmlessly, when something unknown is still pinned there.
+		os.Remove(g.PinDir())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,207 @@
+package reuseportlb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+)
+
+// Kinds of attachment events in a group's journal.
+const (
+	// EventLoad: the policy was loaded and its selector pinned.
+	EventLoad = "load"
+	// EventReuse: a new loader took over the selector already pinned.
+	EventReuse = "reuse"
+	// EventAttach: the selector was attached to a listener with
+	// SO_ATTACH_REUSEPORT_EBPF, which makes it the group's selector.
+	EventAttach = "attach"
+	// EventLink: an sk_lookup link was attached or pointed at a new program.
+	EventLink = "link"
+	// EventUnpin: the last instance left and the group's pins were removed.
+	EventUnpin = "unpin"
+)
+
+var eventKinds = []string{"", EventLoad, EventReuse, EventAttach, EventLink, EventUnpin}
+
+// journalRecord is a value in the attach_journal map, which is keyed by the
+// event's time in Unix nanoseconds. The map is an LRU hash: lookups from
+// userspace do not count as uses, so once it is full the oldest records
+// are the ones evicted, and writers need no coordination.
+type journalRecord struct {
+	Pid    uint32
+	Kind   uint32
+	ProgID uint32
+	Slot   uint32
+	Cookie uint64
+	Comm   [16]byte
+	Policy [16]byte
+}
+
+// AttachEvent is one entry of a group's attachment history.
+type AttachEvent struct {
+	Time time.Time `json:"time"`
+	Kind string    `json:"kind"`
+	// PID is the process the event happened for; for sockets registered
+	// through lbd that is the server, not lbd.
+	PID    uint32 `json:"pid"`
+	Comm   string `json:"comm"`
+	ProgID uint32 `json:"prog_id,omitempty"`
+	Slot   uint32 `json:"slot,omitempty"`
+	Cookie uint64 `json:"cookie,omitempty"`
+	Policy string `json:"policy,omitempty"`
+}
+
+// History returns the group's journal, oldest first.
+func (g Group) History() ([]AttachEvent, error) {
+	m, err := g.OpenPinnedMap(JournalMap)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer m.Close()
+
+	var (
+		out []AttachEvent
+		ts  int64
+		rec journalRecord
+	)
+	iter := m.Iterate()
+	for iter.Next(&ts, &rec) {
+		ev := AttachEvent{
+			Time:   time.Unix(0, ts),
+			PID:    rec.Pid,
+			Comm:   cString(rec.Comm[:]),
+			ProgID: rec.ProgID,
+			Slot:   rec.Slot,
+			Cookie: rec.Cookie,
+			Policy: cString(rec.Policy[:]),
+		}
+		if int(rec.Kind) < len(eventKinds) {
+			ev.Kind = eventKinds[rec.Kind]
+		}
+		out = append(out, ev)
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("iterate %s: %w", JournalMap, err)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Time.Before(out[j].Time) })
+	return out, nil
+}
+
+// RecordAttach journals that pid attached prog to the listener in slot.
+func (g Group) RecordAttach(pid int, slot uint32, cookie uint64, prog *ebpf.Program) error {
+	return g.record(EventAttach, pid, progID(prog), slot, cookie, "")
+}
+
+// journal records an event of this process. The journal is a diagnostic
+// aid, so failing to write it is logged rather than returned.
+func (g Group) journal(kind string, prog *ebpf.Program, policy string) {
+	if err := g.record(kind, os.Getpid(), progID(prog), 0, 0, policy); err != nil {
+		slog.Warn("Recording attachment event failed", "group", g.String(), "kind", kind, "err", err)
+	}
+}
+
+// record appends an event to the group's journal.
+func (g Group) record(kind string, pid int, prog, slot uint32, cookie uint64, policy string) error {
+	m, err := g.OpenOrCreatePinnedMap(JournalMap)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	rec := journalRecord{Pid: uint32(pid), ProgID: prog, Slot: slot, Cookie: cookie}
+	for i, k := range eventKinds {
+		if k == kind {
+			rec.Kind = uint32(i)
+		}
+	}
+	if comm, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid)); err == nil {
+		copy(rec.Comm[:], strings.TrimSpace(string(comm)))
+	}
+	copy(rec.Policy[:], policy)
+
+	ts := time.Now().UnixNano()
+	for {
+		err := m.Update(&ts, &rec, ebpf.UpdateNoExist)
+		if !errors.Is(err, ebpf.ErrKeyExist) {
+			if err != nil {
+				return fmt.Errorf("update %s: %w", JournalMap, err)
+			}
+			return nil
+		}
+		ts++
+	}
+}
+
+// progID returns the kernel's ID of prog, or 0 if it cannot be told.
+func progID(prog *ebpf.Program) uint32 {
+	if prog == nil {
+		return 0
+	}
+	info, err := prog.Info()
+	if err != nil {
+		return 0
+	}
+	id, _ := info.ID()
+	return uint32(id)
+}
+
+// PinnedObject describes a program or link currently pinned for a group.
+type PinnedObject struct {
+	Path string `json:"path"`
+	// ID is the program's or link's kernel ID.
+	ID uint32 `json:"id"`
+	// ProgID is the program a link runs.
+	ProgID uint32 `json:"prog_id,omitempty"`
+}
+
+// PinnedObjects lists the selector, chain stages and links pinned for the
+// group, as the kernel currently sees them.
+func (g Group) PinnedObjects() ([]PinnedObject, error) {
+	var out []PinnedObject
+	for _, path := range append([]string{g.ProgramPath()}, g.stagePaths()...) {
+		prog, err := ebpf.LoadPinnedProgram(path, nil)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("load %s: %w", path, err)
+		}
+		out = append(out, PinnedObject{Path: path, ID: progID(prog)})
+		prog.Close()
+	}
+
+	for _, path := range []string{g.steerLinkPath(), g.jsqLinkPath()} {
+		l, err := link.LoadPinnedLink(path, nil)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("load %s: %w", path, err)
+		}
+		info, err := l.Info()
+		l.Close()
+		if err != nil {
+			return nil, fmt.Errorf("link info %s: %w", path, err)
+		}
+		out = append(out, PinnedObject{Path: path, ID: uint32(info.ID), ProgID: uint32(info.Program)})
+	}
+	return out, nil
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
@@ -0,0 +1,180 @@
+package reuseportlb
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+)
+
+// TieBreak is how the jsq policy chooses among slots with equally short
+// queues.
+type TieBreak uint32
+
+// Tie-break rules (enum jsq_tie_break in eBPF/jsq.c).
+const (
+	TieRandom TieBreak = iota
+	TieRoundRobin
+)
+
+// cgroupRoot is the cgroup v2 mount the jsq depth tracker is attached to,
+// so that it sees every TCP connection on the host.
+const cgroupRoot = "/sys/fs/cgroup"
+
+// jsqLinkPin is where the group's sock_ops link is pinned, so queue depths
+// keep being counted after the process that attached it exits.
+const jsqLinkPin = "jsq_sockops_link"
+
+// ParseTieBreak parses "random" or "round-robin".
+func ParseTieBreak(s string) (TieBreak, error) {
+	switch s {
+	case "random":
+		return TieRandom, nil
+	case "round-robin":
+		return TieRoundRobin, nil
+	}
+	return 0, fmt.Errorf("unknown tie-break %q (want random or round-robin)", s)
+}
+
+func (t TieBreak) String() string {
+	switch t {
+	case TieRandom:
+		return "random"
+	case TieRoundRobin:
+		return "round-robin"
+	}
+	return fmt.Sprintf("TieBreak(%d)", uint32(t))
+}
+
+// SetTieBreak sets how the jsq policy breaks ties between the shortest
+// queues. It takes effect for the next connection.
+func (g Group) SetTieBreak(t TieBreak) error {
+	if t != TieRandom && t != TieRoundRobin {
+		return fmt.Errorf("invalid tie-break %v", t)
+	}
+	m, err := g.openAudited(JSQCfgMap)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	k := uint32(0)
+	if err := m.Update(&k, &jsqJsqCfg{TieBreak: uint32(t)}, ebpf.UpdateAny); err != nil {
+		return fmt.Errorf("update %s: %w", JSQCfgMap, err)
+	}
+	return nil
+}
+
+// TieBreak reads the jsq policy's tie-break rule from jsq_cfg.
+func (g Group) TieBreak() (TieBreak, error) {
+	m, err := g.openAudited(JSQCfgMap)
+	if err != nil {
+		return 0, err
+	}
+	defer m.Close()
+	k := uint32(0)
+	var v jsqJsqCfg
+	if err := m.Lookup(&k, &v); err != nil {
+		return 0, fmt.Errorf("read %s: %w", JSQCfgMap, err)
+	}
+	return TieBreak(v.TieBreak), nil
+}
+
+// QueueDepths reads jsq_depth: the open connections of every slot that has
+// any. Connections count from the end of their handshake until they close.
+func (g Group) QueueDepths() (map[uint32]uint64, error) {
+	m, err := g.OpenPinnedMap(JSQDepthMap)
+	if err != nil {
+		return nil, err
+	}
+	defer m.Close()
+
+	depths := make(map[uint32]uint64)
+	var slot uint32
+	var depth uint64
+	it := m.Iterate()
+	for it.Next(&slot, &depth) {
+		if depth > 0 {
+			depths[slot] = depth
+		}
+	}
+	if err := it.Err(); err != nil {
+		return nil, fmt.Errorf("iterate %s: %w", JSQDepthMap, err)
+	}
+	return depths, nil
+}
+
+// ServeJSQ is an admin handler for the jsq policy. GET reports the
+// tie-break rule and each slot's queue depth; POST with a tie_break form
+// value (random or round-robin) changes the rule live.
+func (g Group) ServeJSQ(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		t, err := ParseTieBreak(r.FormValue("tie_break"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := g.SetTieBreak(t); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	t, err := g.TieBreak()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	depths, err := g.QueueDepths()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		TieBreak string            `json:"tie_break"`
+		Depths   map[uint32]uint64 `json:"depths"`
+	}{t.String(), depths})
+}
+
+// jsqLinkPath returns where the group's sock_ops link is pinned.
+func (g Group) jsqLinkPath() string {
+	return filepath.Join(g.PinDir(), jsqLinkPin)
+}
+
+// attachJSQSockops attaches prog to the root cgroup, replacing the program
+// of a link pinned by an earlier load.
+func (g Group) attachJSQSockops(prog *ebpf.Program) error {
+	path := g.jsqLinkPath()
+	if l, err := link.LoadPinnedLink(path, nil); err == nil {
+		defer l.Close()
+		if err := l.Update(prog); err != nil {
+			return fmt.Errorf("update pinned sock_ops link: %w", err)
+		}
+		g.journal(EventLink, prog, "")
+		return nil
+	}
+
+	l, err := link.AttachCgroup(link.CgroupOptions{
+		Path:    cgroupRoot,
+		Attach:  ebpf.AttachCGroupSockOps,
+		Program: prog,
+	})
+	if err != nil {
+		return fmt.Errorf("attach sock_ops to %s: %w", cgroupRoot, err)
+	}
+	defer l.Close()
+	if err := l.Pin(path); err != nil {
+		return fmt.Errorf("pin sock_ops link: %w", err)
+	}
+	g.journal(EventLink, prog, "")
+	return nil
+}
@@ -0,0 +1,217 @@
+// Code generated by bpf2go; DO NOT EDIT.
+//go:build mips || mips64 || ppc64 || s390x
+
+package reuseportlb
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+
+	"github.com/cilium/ebpf"
+)
+
+type jsqJsqCfg struct{ TieBreak uint32 }
+
+type jsqJsqFlow struct {
+	Saddr uint32
+	Sport uint16
+	Pad   uint16
+}
+
+type jsqRatelimitCfg struct {
+	Enabled     uint32
+	Action      uint32
+	PenaltySlot uint32
+	MaxConns    uint32
+	WindowNs    uint64
+}
+
+type jsqShadowState struct {
+	Phase     uint32
+	Candidate uint32
+}
+
+type jsqSlotBucket struct {
+	Rate      uint64
+	Burst     uint64
+	Action    uint32
+	Pad       uint32
+	Tokens    uint64
+	LastNs    uint64
+	Throttled uint64
+}
+
+type jsqSlotOverride struct {
+	Slot uint32
+	Hits uint32
+}
+
+type jsqSlotWarmup struct {
+	StartNs  uint64
+	WindowNs uint64
+}
+
+type jsqSrcRate struct {
+	WindowStart uint64
+	Count       uint32
+	Limited     uint32
+}
+
+// loadJsq returns the embedded CollectionSpec for jsq.
+func loadJsq() (*ebpf.CollectionSpec, error) {
+	reader := bytes.NewReader(_JsqBytes)
+	spec, err := ebpf.LoadCollectionSpecFromReader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("can't load jsq: %w", err)
+	}
+
+	return spec, err
+}
+
+// loadJsqObjects loads jsq and converts it into a struct.
+//
+// The following types are suitable as obj argument:
+//
+//	*jsqObjects
+//	*jsqPrograms
+//	*jsqMaps
+//
+// See ebpf.CollectionSpec.LoadAndAssign documentation for details.
+func loadJsqObjects(obj interface{}, opts *ebpf.CollectionOptions) error {
+	spec, err := loadJsq()
+	if err != nil {
+		return err
+	}
+
+	return spec.LoadAndAssign(obj, opts)
+}
+
+// jsqSpecs contains maps and programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type jsqSpecs struct {
+	jsqProgramSpecs
+	jsqMapSpecs
+}
+
+// jsqSpecs contains programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type jsqProgramSpecs struct {
+	JsqSelector *ebpf.ProgramSpec `ebpf:"jsq_selector"`
+	JsqSockops  *ebpf.ProgramSpec `ebpf:"jsq_sockops"`
+}
+
+// jsqMapSpecs contains maps before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type jsqMapSpecs struct {
+	AcceptqSlotCookies  *ebpf.MapSpec `ebpf:"acceptq_slot_cookies"`
+	JsqCfg              *ebpf.MapSpec `ebpf:"jsq_cfg"`
+	JsqConn             *ebpf.MapSpec `ebpf:"jsq_conn"`
+	JsqDepth            *ebpf.MapSpec `ebpf:"jsq_depth"`
+	JsqPending          *ebpf.MapSpec `ebpf:"jsq_pending"`
+	JsqRr               *ebpf.MapSpec `ebpf:"jsq_rr"`
+	RatelimitCfg        *ebpf.MapSpec `ebpf:"ratelimit_cfg"`
+	ShadowEvents        *ebpf.MapSpec `ebpf:"shadow_events"`
+	ShadowProgs         *ebpf.MapSpec `ebpf:"shadow_progs"`
+	ShadowScratch       *ebpf.MapSpec `ebpf:"shadow_scratch"`
+	SlotBucket          *ebpf.MapSpec `ebpf:"slot_bucket"`
+	SlotDropping        *ebpf.MapSpec `ebpf:"slot_dropping"`
+	SlotOverride        *ebpf.MapSpec `ebpf:"slot_override"`
+	SlotSelected        *ebpf.MapSpec `ebpf:"slot_selected"`
+	SlotWarmup          *ebpf.MapSpec `ebpf:"slot_warmup"`
+	SrcRate             *ebpf.MapSpec `ebpf:"src_rate"`
+	TcpBalancingTargets *ebpf.MapSpec `ebpf:"tcp_balancing_targets"`
+}
+
+// jsqObjects contains all objects after they have been loaded into the kernel.
+//
+// It can be passed to loadJsqObjects or ebpf.CollectionSpec.LoadAndAssign.
+type jsqObjects struct {
+	jsqPrograms
+	jsqMaps
+}
+
+func (o *jsqObjects) Close() error {
+	return _JsqClose(
+		&o.jsqPrograms,
+		&o.jsqMaps,
+	)
+}
+
+// jsqMaps contains all maps after they have been loaded into the kernel.
+//
+// It can be passed to loadJsqObjects or ebpf.CollectionSpec.LoadAndAssign.
+type jsqMaps struct {
+	AcceptqSlotCookies  *ebpf.Map `ebpf:"acceptq_slot_cookies"`
+	JsqCfg              *ebpf.Map `ebpf:"jsq_cfg"`
+	JsqConn             *ebpf.Map `ebpf:"jsq_conn"`
+	JsqDepth            *ebpf.Map `ebpf:"jsq_depth"`
+	JsqPending          *ebpf.Map `ebpf:"jsq_pending"`
+	JsqRr               *ebpf.Map `ebpf:"jsq_rr"`
+	RatelimitCfg        *ebpf.Map `ebpf:"ratelimit_cfg"`
+	ShadowEvents        *ebpf.Map `ebpf:"shadow_events"`
+	ShadowProgs         *ebpf.Map `ebpf:"shadow_progs"`
+	ShadowScratch       *ebpf.Map `ebpf:"shadow_scratch"`
+	SlotBucket          *ebpf.Map `ebpf:"slot_bucket"`
+	SlotDropping        *ebpf.Map `ebpf:"slot_dropping"`
+	SlotOverride        *ebpf.Map `ebpf:"slot_override"`
+	SlotSelected        *ebpf.Map `ebpf:"slot_selected"`
+	SlotWarmup          *ebpf.Map `ebpf:"slot_warmup"`
+	SrcRate             *ebpf.Map `ebpf:"src_rate"`
+	TcpBalancingTargets *ebpf.Map `ebpf:"tcp_balancing_targets"`
+}
+
+func (m *jsqMaps) Close() error {
+	return _JsqClose(
+		m.AcceptqSlotCookies,
+		m.JsqCfg,
+		m.JsqConn,
+		m.JsqDepth,
+		m.JsqPending,
+		m.JsqRr,
+		m.RatelimitCfg,
+		m.ShadowEvents,
+		m.ShadowProgs,
+		m.ShadowScratch,
+		m.SlotBucket,
+		m.SlotDropping,
+		m.SlotOverride,
+		m.SlotSelected,
+		m.SlotWarmup,
+		m.SrcRate,
+		m.TcpBalancingTargets,
+	)
+}
+
+// jsqPrograms contains all programs after they have been loaded into the kernel.
+//
+// It can be passed to loadJsqObjects or ebpf.CollectionSpec.LoadAndAssign.
+type jsqPrograms struct {
+	JsqSelector *ebpf.Program `ebpf:"jsq_selector"`
+	JsqSockops  *ebpf.Program `ebpf:"jsq_sockops"`
+}
+
+func (p *jsqPrograms) Close() error {
+	return _JsqClose(
+		p.JsqSelector,
+		p.JsqSockops,
+	)
+}
+
+func _JsqClose(closers ...io.Closer) error {
+	for _, closer := range closers {
+		if err := closer.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Do not access this directly.
+//
+//go:embed jsq_bpfeb.o
+var _JsqBytes []byte
@@ -0,0 +1,246 @@
+package reuseportlb
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+)
+
+// LatencyBuckets is the number of log2 buckets in a latency histogram
+// (LAT_BUCKETS in eBPF/latency.c).
+const LatencyBuckets = 32
+
+// LatencyHistogram is the accept-to-first-response latency of one listener.
+// Buckets[i] counts latencies in [2^i, 2^(i+1)) microseconds; bucket 0 also
+// holds everything under a microsecond.
+type LatencyHistogram struct {
+	Buckets [LatencyBuckets]uint64
+	Count   uint64
+	Sum     time.Duration
+}
+
+// bucketBounds returns the latency range bucket i covers.
+func bucketBounds(i int) (lo, hi time.Duration) {
+	hi = time.Duration(uint64(1)<<(i+1)) * time.Microsecond
+	if i == 0 {
+		return 0, hi
+	}
+	return hi / 2, hi
+}
+
+// Quantile estimates the q-quantile (0 < q <= 1), interpolating linearly
+// inside the bucket it falls in. It returns 0 for an empty histogram.
+func (h LatencyHistogram) Quantile(q float64) time.Duration {
+	var total uint64
+	for _, n := range h.Buckets {
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+	rank := q * float64(total)
+	var cum uint64
+	for i, n := range h.Buckets {
+		if n == 0 {
+			continue
+		}
+		if float64(cum+n) >= rank {
+			lo, hi := bucketBounds(i)
+			frac := (rank - float64(cum)) / float64(n)
+			return lo + time.Duration(frac*float64(hi-lo))
+		}
+		cum += n
+	}
+	_, hi := bucketBounds(LatencyBuckets - 1)
+	return hi
+}
+
+// Mean returns the exact mean latency.
+func (h LatencyHistogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// Render writes the histogram as text in the style of the bcc tools'
+// log2 histograms.
+func (h LatencyHistogram) Render(w io.Writer) {
+	const width = 40
+	first, last := -1, -1
+	var peak uint64
+	for i, n := range h.Buckets {
+		if n == 0 {
+			continue
+		}
+		if first < 0 {
+			first = i
+		}
+		last = i
+		if n > peak {
+			peak = n
+		}
+	}
+	fmt.Fprintf(w, "%24s : %-8s %s\n", "usecs", "count", "distribution")
+	if first < 0 {
+		return
+	}
+	for i := first; i <= last; i++ {
+		lo, hi := bucketBounds(i)
+		n := h.Buckets[i]
+		bar := strings.Repeat("*", int(n*width/peak))
+		fmt.Fprintf(w, "%10d -> %-10d : %-8d |%-*s|\n",
+			lo/time.Microsecond, hi/time.Microsecond-1, n, width, bar)
+	}
+}
+
+// LatencyHistograms returns the histogram of every registered slot in the
+// group that has accepted at least one connection since the probes started.
+func (g Group) LatencyHistograms() (map[uint32]LatencyHistogram, error) {
+	cookies, err := g.OpenPinnedMap(SlotCookiesMap)
+	if err != nil {
+		return nil, err
+	}
+	defer cookies.Close()
+	hists, err := g.OpenPinnedMap(LatencyHistMap)
+	if err != nil {
+		return nil, err
+	}
+	defer hists.Close()
+
+	out := make(map[uint32]LatencyHistogram)
+	var slot uint32
+	var cookie uint64
+	iter := cookies.Iterate()
+	for iter.Next(&slot, &cookie) {
+		if cookie == 0 {
+			continue
+		}
+		var v latencyLatHist
+		if err := hists.Lookup(&cookie, &v); err != nil {
+			continue
+		}
+		out[slot] = LatencyHistogram{Buckets: v.Buckets, Count: v.Count, Sum: time.Duration(v.SumNs)}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("iterate %s: %w", SlotCookiesMap, err)
+	}
+	return out, nil
+}
+
+// ServeLatency is an admin handler rendering the group's per-slot latency
+// histograms as text, or their quantiles as JSON with ?format=json.
+func (g Group) ServeLatency(w http.ResponseWriter, r *http.Request) {
+	hists, err := g.LatencyHistograms()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	serveHistograms(w, r, hists)
+}
+
+// serveHistograms writes per-slot histograms as text, or their quantiles as
+// JSON with ?format=json.
+func serveHistograms(w http.ResponseWriter, r *http.Request, hists map[uint32]LatencyHistogram) {
+	slots := make([]uint32, 0, len(hists))
+	for slot := range hists {
+		slots = append(slots, slot)
+	}
+	sort.Slice(slots, func(i, j int) bool { return slots[i] < slots[j] })
+
+	if r.URL.Query().Get("format") == "json" {
+		type quantiles struct {
+			Slot  uint32 `json:"slot"`
+			Count uint64 `json:"count"`
+			Mean  string `json:"mean"`
+			P50   string `json:"p50"`
+			P90   string `json:"p90"`
+			P99   string `json:"p99"`
+		}
+		resp := []quantiles{}
+		for _, slot := range slots {
+			h := hists[slot]
+			resp = append(resp, quantiles{slot, h.Count, h.Mean().String(),
+				h.Quantile(0.5).String(), h.Quantile(0.9).String(), h.Quantile(0.99).String()})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, slot := range slots {
+		h := hists[slot]
+		fmt.Fprintf(w, "slot %d: count=%d mean=%s p50=%s p90=%s p99=%s\n",
+			slot, h.Count, h.Mean(), h.Quantile(0.5), h.Quantile(0.9), h.Quantile(0.99))
+		h.Render(w)
+		fmt.Fprintln(w)
+	}
+}
+
+// latencyProbes is shared by everything in the process that wants latency
+// histograms; the probes stay attached while anyone holds a reference.
+var latencyProbes struct {
+	sync.Mutex
+	refs  int
+	close func()
+}
+
+// StartLatencyProbes attaches the accept-to-response latency probes and
+// returns a func that detaches them again. It needs fentry/fexit support
+// and bpf_get_func_ret (Linux 5.17).
+func StartLatencyProbes() (func(), error) {
+	latencyProbes.Lock()
+	defer latencyProbes.Unlock()
+	if latencyProbes.refs == 0 {
+		closeProbes, err := attachLatencyProbes()
+		if err != nil {
+			return nil, err
+		}
+		latencyProbes.close = closeProbes
+	}
+	latencyProbes.refs++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			latencyProbes.Lock()
+			defer latencyProbes.Unlock()
+			if latencyProbes.refs--; latencyProbes.refs == 0 {
+				latencyProbes.close()
+			}
+		})
+	}, nil
+}
+
+func attachLatencyProbes() (func(), error) {
+	var objs latencyObjects
+	opts := &ebpf.CollectionOptions{Maps: ebpf.MapOptions{PinPath: PinPath}}
+	if err := loadLatencyObjects(&objs, opts); err != nil {
+		return nil, kernelFeature(fmt.Errorf("load latency probes: %w", err))
+	}
+	var links []link.Link
+	closeAll := func() {
+		for _, l := range links {
+			l.Close()
+		}
+		objs.Close()
+	}
+	for _, prog := range []*ebpf.Program{objs.LatAccept, objs.LatFirstSend} {
+		l, err := link.AttachTracing(link.TracingOptions{Program: prog})
+		if err != nil {
+			closeAll()
+			return nil, kernelFeature(fmt.Errorf("attach latency probe %s: %w", prog, err))
+		}
+		links = append(links, l)
+	}
+	return closeAll, nil
+}
@@ -0,0 +1,136 @@
+// Code generated by bpf2go; DO NOT EDIT.
+//go:build mips || mips64 || ppc64 || s390x
+
+package reuseportlb
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+
+	"github.com/cilium/ebpf"
+)
+
+type latencyLatHist struct {
+	Buckets [32]uint64
+	Count   uint64
+	SumNs   uint64
+}
+
+type latencyLatStart struct {
+	Ts       uint64
+	Listener uint64
+}
+
+// loadLatency returns the embedded CollectionSpec for latency.
+func loadLatency() (*ebpf.CollectionSpec, error) {
+	reader := bytes.NewReader(_LatencyBytes)
+	spec, err := ebpf.LoadCollectionSpecFromReader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("can't load latency: %w", err)
+	}
+
+	return spec, err
+}
+
+// loadLatencyObjects loads latency and converts it into a struct.
+//
+// The following types are suitable as obj argument:
+//
+//	*latencyObjects
+//	*latencyPrograms
+//	*latencyMaps
+//
+// See ebpf.CollectionSpec.LoadAndAssign documentation for details.
+func loadLatencyObjects(obj interface{}, opts *ebpf.CollectionOptions) error {
+	spec, err := loadLatency()
+	if err != nil {
+		return err
+	}
+
+	return spec.LoadAndAssign(obj, opts)
+}
+
+// latencySpecs contains maps and programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type latencySpecs struct {
+	latencyProgramSpecs
+	latencyMapSpecs
+}
+
+// latencySpecs contains programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type latencyProgramSpecs struct {
+	LatAccept    *ebpf.ProgramSpec `ebpf:"lat_accept"`
+	LatFirstSend *ebpf.ProgramSpec `ebpf:"lat_first_send"`
+}
+
+// latencyMapSpecs contains maps before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type latencyMapSpecs struct {
+	LatHist  *ebpf.MapSpec `ebpf:"lat_hist"`
+	LatStart *ebpf.MapSpec `ebpf:"lat_start"`
+}
+
+// latencyObjects contains all objects after they have been loaded into the kernel.
+//
+// It can be passed to loadLatencyObjects or ebpf.CollectionSpec.LoadAndAssign.
+type latencyObjects struct {
+	latencyPrograms
+	latencyMaps
+}
+
+func (o *latencyObjects) Close() error {
+	return _LatencyClose(
+		&o.latencyPrograms,
+		&o.latencyMaps,
+	)
+}
+
+// latencyMaps contains all maps after they have been loaded into the kernel.
+//
+// It can be passed to loadLatencyObjects or ebpf.CollectionSpec.LoadAndAssign.
+type latencyMaps struct {
+	LatHist  *ebpf.Map `ebpf:"lat_hist"`
+	LatStart *ebpf.Map `ebpf:"lat_start"`
+}
+
+func (m *latencyMaps) Close() error {
+	return _LatencyClose(
+		m.LatHist,
+		m.LatStart,
+	)
+}
+
+// latencyPrograms contains all programs after they have been loaded into the kernel.
+//
+// It can be passed to loadLatencyObjects or ebpf.CollectionSpec.LoadAndAssign.
+type latencyPrograms struct {
+	LatAccept    *ebpf.Program `ebpf:"lat_accept"`
+	LatFirstSend *ebpf.Program `ebpf:"lat_first_send"`
+}
+
+func (p *latencyPrograms) Close() error {
+	return _LatencyClose(
+		p.LatAccept,
+		p.LatFirstSend,
+	)
+}
+
+func _LatencyClose(closers ...io.Closer) error {
+	for _, closer := range closers {
+		if err := closer.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Do not access this directly.
+//
+//go:embed latency_bpfeb.o
+var _LatencyBytes []byte
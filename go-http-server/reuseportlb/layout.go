@@ -0,0 +1,345 @@
+// Package reuseportlb holds the pieces shared between the server instances and
+// the stats collector: where objects are pinned and the layout of the maps
+// they exchange through bpffs.
+package reuseportlb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/cilium/ebpf"
+)
+
+// PinPath is the bpffs directory all shared maps and programs are pinned under.
+const PinPath = "/sys/fs/bpf"
+
+// ProgramPin is the name under PinPath of the selector pinned by lbd for
+// servers to attach.
+const ProgramPin = "lb_prog"
+
+// LayoutVersion identifies the key/value layout of the pinned maps below.
+// Bump it whenever a struct shared with the eBPF programs changes shape, so
+// that binaries built against the old layout refuse to touch the new pins.
+const LayoutVersion = 4
+
+// layoutMagic marks a lb_layout map as ours ("LBLY").
+const layoutMagic = 0x4c424c59
+
+// Names of the maps pinned under PinPath.
+const (
+	TargetsMap       = "tcp_balancing_targets"
+	AcceptqMap       = "acceptq_map"
+	SlotCookiesMap   = "acceptq_slot_cookies"
+	CPUUtilMap       = "cpu_util_map"
+	RRStateMap       = "rr"
+	SlotOwnerMap     = "slot_owner"
+	SlotUtilMap      = "slot_util"
+	SlotMemMap       = "slot_mem"
+	SlotRuntimeMap   = "slot_runtime"
+	SlotHealthMap    = "slot_health"
+	SlotPSIMap       = "slot_psi"
+	RateLimitMap     = "ratelimit_cfg"
+	SrcRateMap       = "src_rate"
+	SlotBucketMap    = "slot_bucket"
+	SlotOverrideMap  = "slot_override"
+	SlotSelectedMap  = "slot_selected"
+	SlotWarmupMap    = "slot_warmup"
+	ShadowProgsMap   = "shadow_progs"
+	ShadowEventsMap  = "shadow_events"
+	PolicyCfgMap     = "policy_cfg"
+	ChainProgsMap    = "chain_progs"
+	ChainCfgMap      = "chain_cfg"
+	SplitCfgMap      = "split_cfg"
+	SplitStatsMap    = "split_stats"
+	SteerPortsMap    = "steer_ports"
+	SteerTenantsMap  = "steer_tenants"
+	SteerClientsMap  = "steer_clients"
+	SteerListenerMap = "steer_listener"
+	StandbyCfgMap    = "standby_cfg"
+	StandbyStateMap  = "standby_state"
+	HeartbeatMap     = "standby_heartbeat"
+	PriorityMap      = "standby_priority"
+	SpillCfgMap      = "spill_cfg"
+	JSQCfgMap        = "jsq_cfg"
+	JSQDepthMap      = "jsq_depth"
+	JSQRRMap         = "jsq_rr"
+	JSQPendingMap    = "jsq_pending"
+	JSQConnMap       = "jsq_conn"
+	LatencyHistMap   = "lat_hist"
+	ConnStatsMap     = "conn_stats"
+	NetDistressMap   = "net_distress"
+	SlotNetMap       = "slot_net"
+	RequestCPUMap    = "req_cpu"
+	AcceptqEventsMap = "acceptq_events"
+	SlotErrorsMap    = "slot_errors"
+	SlotRebalanceMap = "slot_rebalance"
+	InstancesMap     = "instances"
+	LoaderMap        = "loader"
+	JournalMap       = "attach_journal"
+	LayoutMap        = "lb_layout"
+)
+
+// ErrLayoutMismatch is returned when a pinned map was created by a producer
+// with a different idea of its layout than this binary.
+var ErrLayoutMismatch = errors.New("pinned map layout mismatch")
+
+// mapLayouts mirrors the map definitions in eBPF/*.c. Every program that pins
+// a map by name must agree with the entry here.
+var mapLayouts = map[string]ebpf.MapSpec{
+	TargetsMap:       {Type: ebpf.ReusePortSockArray, KeySize: 4, ValueSize: 8, MaxEntries: 128},
+	AcceptqMap:       {Type: ebpf.Hash, KeySize: 8, ValueSize: 12, MaxEntries: 1024},
+	SlotCookiesMap:   {Type: ebpf.Array, KeySize: 4, ValueSize: 8, MaxEntries: 128},
+	CPUUtilMap:       {Type: ebpf.Array, KeySize: 4, ValueSize: 4, MaxEntries: 64},
+	RRStateMap:       {Type: ebpf.Array, KeySize: 4, ValueSize: 8, MaxEntries: 1},
+	SlotOwnerMap:     {Type: ebpf.Array, KeySize: 4, ValueSize: 8 + maxOwnerCPUs/8, MaxEntries: 128},
+	SlotUtilMap:      {Type: ebpf.Array, KeySize: 4, ValueSize: 4, MaxEntries: 128},
+	SlotMemMap:       {Type: ebpf.Array, KeySize: 4, ValueSize: 16, MaxEntries: 128},
+	SlotRuntimeMap:   {Type: ebpf.Array, KeySize: 4, ValueSize: 32, MaxEntries: 128},
+	SlotHealthMap:    {Type: ebpf.Array, KeySize: 4, ValueSize: 16, MaxEntries: 128},
+	SlotPSIMap:       {Type: ebpf.Array, KeySize: 4, ValueSize: 16, MaxEntries: 128},
+	RateLimitMap:     {Type: ebpf.Array, KeySize: 4, ValueSize: 24, MaxEntries: 1},
+	SrcRateMap:       {Type: ebpf.LRUHash, KeySize: 4, ValueSize: 16, MaxEntries: 4096},
+	SlotBucketMap:    {Type: ebpf.Array, KeySize: 4, ValueSize: 48, MaxEntries: 128},
+	SlotOverrideMap:  {Type: ebpf.Hash, KeySize: 16, ValueSize: 8, MaxEntries: 1024},
+	SlotSelectedMap:  {Type: ebpf.PerCPUArray, KeySize: 4, ValueSize: 8, MaxEntries: 128},
+	SlotWarmupMap:    {Type: ebpf.Array, KeySize: 4, ValueSize: 16, MaxEntries: 128},
+	ShadowProgsMap:   {Type: ebpf.ProgramArray, KeySize: 4, ValueSize: 4, MaxEntries: 2},
+	ShadowEventsMap:  {Type: ebpf.RingBuf, MaxEntries: 1 << 16},
+	PolicyCfgMap:     {Type: ebpf.Array, KeySize: 4, ValueSize: 8, MaxEntries: 16},
+	ChainProgsMap:    {Type: ebpf.ProgramArray, KeySize: 4, ValueSize: 4, MaxEntries: 8},
+	ChainCfgMap:      {Type: ebpf.Array, KeySize: 4, ValueSize: 4, MaxEntries: 1},
+	SplitCfgMap:      {Type: ebpf.Array, KeySize: 4, ValueSize: 8, MaxEntries: 1},
+	SplitStatsMap:    {Type: ebpf.PerCPUArray, KeySize: 4, ValueSize: 8, MaxEntries: 2},
+	SteerPortsMap:    {Type: ebpf.Hash, KeySize: 2, ValueSize: 4, MaxEntries: 1024},
+	SteerTenantsMap:  {Type: ebpf.Array, KeySize: 4, ValueSize: 8, MaxEntries: 64},
+	SteerClientsMap:  {Type: ebpf.LRUHash, KeySize: 4, ValueSize: 4, MaxEntries: 4096},
+	SteerListenerMap: {Type: ebpf.SockMap, KeySize: 4, ValueSize: 8, MaxEntries: 128},
+	StandbyCfgMap:    {Type: ebpf.Array, KeySize: 4, ValueSize: 32, MaxEntries: 1},
+	StandbyStateMap:  {Type: ebpf.Array, KeySize: 4, ValueSize: 40, MaxEntries: 1},
+	HeartbeatMap:     {Type: ebpf.Array, KeySize: 4, ValueSize: 8, MaxEntries: 128},
+	PriorityMap:      {Type: ebpf.Array, KeySize: 4, ValueSize: 4, MaxEntries: 128},
+	SpillCfgMap:      {Type: ebpf.Array, KeySize: 4, ValueSize: 4, MaxEntries: 1},
+	JSQCfgMap:        {Type: ebpf.Array, KeySize: 4, ValueSize: 4, MaxEntries: 1},
+	JSQDepthMap:      {Type: ebpf.Array, KeySize: 4, ValueSize: 8, MaxEntries: 128},
+	JSQRRMap:         {Type: ebpf.Array, KeySize: 4, ValueSize: 8, MaxEntries: 1},
+	JSQPendingMap:    {Type: ebpf.LRUHash, KeySize: 8, ValueSize: 4, MaxEntries: 4096},
+	// Flags is BPF_F_NO_PREALLOC, which socket storage requires.
+	JSQConnMap:       {Type: ebpf.SkStorage, KeySize: 4, ValueSize: 4, Flags: 1},
+	LatencyHistMap:   {Type: ebpf.Hash, KeySize: 8, ValueSize: 8 * (LatencyBuckets + 2), MaxEntries: 1024},
+	ConnStatsMap:     {Type: ebpf.Hash, KeySize: 8, ValueSize: 48, MaxEntries: 1024},
+	NetDistressMap:   {Type: ebpf.Hash, KeySize: 8, ValueSize: 16, MaxEntries: 1024},
+	AcceptqEventsMap: {Type: ebpf.RingBuf, MaxEntries: 1 << 18},
+	// req_cpu is written from userspace only, by each server for its own
+	// slot (see RequestCPUTracer).
+	RequestCPUMap: {Type: ebpf.Array, KeySize: 4, ValueSize: 8 * (LatencyBuckets + 2), MaxEntries: 128},
+	// slot_errors is written from userspace only too, by each server for
+	// its own slot (see ErrorCounter).
+	SlotErrorsMap: {Type: ebpf.Array, KeySize: 4, ValueSize: 40, MaxEntries: 128},
+	// slot_net is written by the collector from net_distress (see
+	// Group.SlotNetDistress).
+	SlotNetMap: {Type: ebpf.Array, KeySize: 4, ValueSize: 24, MaxEntries: 128},
+	// slot_rebalance carries requests to shed connections from lbd to the
+	// servers (see Group.RequestRebalance).
+	SlotRebalanceMap: {Type: ebpf.Array, KeySize: 4, ValueSize: 16, MaxEntries: 128},
+	// instances is only used from userspace: pid -> process start time of
+	// every running instance of the group (see Group.Join).
+	InstancesMap: {Type: ebpf.Hash, KeySize: 4, ValueSize: 8, MaxEntries: 1024},
+	// loader is userspace-only too: the process that loaded the group's
+	// policy (see Group.LoadSharedPolicy).
+	LoaderMap: {Type: ebpf.Array, KeySize: 4, ValueSize: 32, MaxEntries: 1},
+	// attach_journal is the group's attachment history (see Group.History).
+	JournalMap: {Type: ebpf.LRUHash, KeySize: 8, ValueSize: 56, MaxEntries: 1024},
+	LayoutMap:  {Type: ebpf.Array, KeySize: 4, ValueSize: 8, MaxEntries: 1},
+}
+
+// layoutInfo is the single value stored in the lb_layout map.
+type layoutInfo struct {
+	Magic   uint32
+	Version uint32
+}
+
+// PinnedMapPath returns the bpffs path of the named map in DefaultGroup.
+func PinnedMapPath(name string) string {
+	return DefaultGroup.PinnedMapPath(name)
+}
+
+// EnsureBpffs mounts bpffs at PinPath if it's not already mounted.
+func EnsureBpffs() error {
+	// Ensure the mount point directory exists
+	if err := os.MkdirAll(PinPath, 0700); err != nil {
+		return fmt.Errorf("create bpffs mountpoint: %w", err)
+	}
+	if bpffsMounted(PinPath) {
+		return nil
+	}
+	// Not mounted as bpffs; try to mount
+	if err := mountBpffs(PinPath); err != nil {
+		return fmt.Errorf("mount bpffs at %s: %w", PinPath, err)
+	}
+	return nil
+}
+
+// LoadPinnedProgram loads the selector lbd pinned for the group.
+func (g Group) LoadPinnedProgram() (*ebpf.Program, error) {
+	prog, err := ebpf.LoadPinnedProgram(g.ProgramPath(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("load pinned selector of group %s (is lbd running?): %w", g, pinMissing(err, g.ProgramPath()))
+	}
+	return prog, nil
+}
+
+// MapSpec returns a copy of the expected spec for the named map.
+func MapSpec(name string) (*ebpf.MapSpec, error) {
+	layout, ok := mapLayouts[name]
+	if !ok {
+		return nil, fmt.Errorf("no layout known for map %q", name)
+	}
+	spec := layout
+	spec.Name = name
+	return &spec, nil
+}
+
+// perCPUFlavor maps a map type to its per-CPU counterpart. A producer may
+// switch a shared map to the per-CPU flavor without changing its value layout;
+// consumers are expected to cope with per-CPU slices (see IsPerCPU).
+var perCPUFlavor = map[ebpf.MapType]ebpf.MapType{
+	ebpf.Hash:  ebpf.PerCPUHash,
+	ebpf.Array: ebpf.PerCPUArray,
+}
+
+// IsPerCPU reports whether lookups in m return one value per possible CPU.
+func IsPerCPU(m *ebpf.Map) bool {
+	switch m.Type() {
+	case ebpf.PerCPUHash, ebpf.PerCPUArray, ebpf.LRUCPUHash:
+		return true
+	}
+	return false
+}
+
+// CheckMap verifies that m matches the layout expected for the named map.
+func CheckMap(name string, m *ebpf.Map) error {
+	spec, err := MapSpec(name)
+	if err != nil {
+		return err
+	}
+	if flavor, ok := perCPUFlavor[spec.Type]; ok && m.Type() == flavor {
+		spec.Type = flavor
+	}
+	if err := spec.Compatible(m); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrLayoutMismatch, name, err)
+	}
+	return nil
+}
+
+// OpenPinnedMap opens the named map of DefaultGroup; see Group.OpenPinnedMap.
+func OpenPinnedMap(name string) (*ebpf.Map, error) {
+	return DefaultGroup.OpenPinnedMap(name)
+}
+
+// OpenPinnedMap loads the group's named map from bpffs and verifies its
+// layout before handing it out.
+func (g Group) OpenPinnedMap(name string) (*ebpf.Map, error) {
+	m, err := ebpf.LoadPinnedMap(g.PinnedMapPath(name), nil)
+	if err != nil {
+		return nil, pinMissing(err, g.PinnedMapPath(name))
+	}
+	if err := CheckMap(name, m); err != nil {
+		m.Close()
+		return nil, fmt.Errorf("%w (at %s)", err, g.PinnedMapPath(name))
+	}
+	return m, nil
+}
+
+// OpenOrCreatePinnedMap opens or creates the named map of DefaultGroup; see
+// Group.OpenOrCreatePinnedMap.
+func OpenOrCreatePinnedMap(name string) (*ebpf.Map, error) {
+	return DefaultGroup.OpenOrCreatePinnedMap(name)
+}
+
+// OpenOrCreatePinnedMap is OpenPinnedMap for maps that userspace may need
+// before any program pinning them has been loaded. If the map is not pinned
+// yet it is created from its expected layout and pinned; a program loaded
+// later picks up the pin.
+func (g Group) OpenOrCreatePinnedMap(name string) (*ebpf.Map, error) {
+	m, err := g.OpenPinnedMap(name)
+	if !errors.Is(err, os.ErrNotExist) {
+		return m, err
+	}
+	if err := g.ensurePinDir(); err != nil {
+		return nil, err
+	}
+
+	spec, err := MapSpec(name)
+	if err != nil {
+		return nil, err
+	}
+	m, err = ebpf.NewMap(spec)
+	if err != nil {
+		return nil, fmt.Errorf("create %s: %w", name, err)
+	}
+	if err := m.Pin(g.PinnedMapPath(name)); err != nil {
+		m.Close()
+		if errors.Is(err, os.ErrExist) {
+			// Lost the race to another process; use theirs.
+			return g.OpenPinnedMap(name)
+		}
+		return nil, fmt.Errorf("pin %s: %w", name, err)
+	}
+	return m, nil
+}
+
+// EnsureLayout checks the pinned lb_layout map against LayoutVersion,
+// creating and pinning it if no producer has done so yet. A version mismatch
+// means the pins were left behind by an incompatible build and must be
+// removed before continuing.
+func EnsureLayout() error {
+	m, err := OpenPinnedMap(LayoutMap)
+	if errors.Is(err, os.ErrNotExist) {
+		m, err = createLayoutMap()
+	}
+	if err != nil {
+		return fmt.Errorf("layout metadata: %w", err)
+	}
+	defer m.Close()
+
+	var key uint32
+	var info layoutInfo
+	if err := m.Lookup(&key, &info); err != nil {
+		return fmt.Errorf("read layout metadata: %w", err)
+	}
+	if info.Magic != layoutMagic {
+		return fmt.Errorf("%w: %s does not carry layout metadata (magic 0x%x)", ErrLayoutMismatch, PinnedMapPath(LayoutMap), info.Magic)
+	}
+	if info.Version != LayoutVersion {
+		return fmt.Errorf("%w: pins under %s use layout v%d, this binary expects v%d; remove the stale pins and restart",
+			ErrLayoutMismatch, PinPath, info.Version, LayoutVersion)
+	}
+	return nil
+}
+
+func createLayoutMap() (*ebpf.Map, error) {
+	spec, err := MapSpec(LayoutMap)
+	if err != nil {
+		return nil, err
+	}
+	m, err := ebpf.NewMap(spec)
+	if err != nil {
+		return nil, fmt.Errorf("create %s: %w", LayoutMap, err)
+	}
+	var key uint32
+	info := layoutInfo{Magic: layoutMagic, Version: LayoutVersion}
+	if err := m.Update(&key, &info, ebpf.UpdateAny); err != nil {
+		m.Close()
+		return nil, fmt.Errorf("write %s: %w", LayoutMap, err)
+	}
+	if err := m.Pin(PinnedMapPath(LayoutMap)); err != nil {
+		m.Close()
+		if errors.Is(err, os.ErrExist) {
+			// Another producer won the race; check theirs instead.
+			return OpenPinnedMap(LayoutMap)
+		}
+		return nil, fmt.Errorf("pin %s: %w", LayoutMap, err)
+	}
+	return m, nil
+}
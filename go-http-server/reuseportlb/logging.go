@@ -0,0 +1,43 @@
+package reuseportlb
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// NewLogger builds the structured logger shared by the server and collector
+// binaries. level is one of debug, info, warn or error; format is text or json.
+func NewLogger(w io.Writer, level, format string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q: %w", level, err)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	switch strings.ToLower(format) {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q: must be text or json", format)
+	}
+}
+
+// Fatal logs msg and its attributes at error level with the default logger
+// and exits, standing in for log.Fatalf in the commands. It skips deferred
+// calls, so commands that hold pins or attachments return errors to main
+// instead of calling it once they have something to release.
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// CookieAttr formats a socket cookie the same way everywhere it is logged, so
+// records from the servers, the collector and bpf_printk output can be joined.
+func CookieAttr(cookie uint64) slog.Attr {
+	return slog.String("cookie", fmt.Sprintf("0x%x", cookie))
+}
@@ -0,0 +1,50 @@
+package reuseportlb
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// readSlotMem measures the memory of the process pid for slot_mem: its
+// cgroup's memory.current and memory.pressure when it has a cgroup of its
+// own (see Group.JoinCgroup), its RSS and no pressure reading otherwise.
+func readSlotMem(pid int) (SlotMem, error) {
+	if path, err := ProcessCgroup(pid); err == nil && path != cgroupRoot {
+		if cur, err := strconv.ParseUint(readTrim(filepath.Join(path, "memory.current")), 10, 64); err == nil {
+			avg10, _ := readPressureAvg10(filepath.Join(path, "memory.pressure"))
+			return SlotMem{Bytes: cur, Pressure: uint32(avg10 * 100)}, nil
+		}
+	}
+	rss, err := readRSS(pid)
+	if err != nil {
+		return SlotMem{}, err
+	}
+	return SlotMem{Bytes: rss}, nil
+}
+
+// readRSS returns the resident set size of pid in bytes.
+func readRSS(pid int) (uint64, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if v, ok := strings.CutPrefix(sc.Text(), "VmRSS:"); ok {
+			kb, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimSpace(v), " kB"), 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("parse VmRSS of %d: %w", pid, err)
+			}
+			return kb << 10, nil
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("no VmRSS for %d", pid)
+}
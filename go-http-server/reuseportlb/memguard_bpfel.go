@@ -0,0 +1,203 @@
+// Code generated by bpf2go; DO NOT EDIT.
+//go:build 386 || amd64 || arm || arm64 || loong64 || mips64le || mipsle || ppc64le || riscv64
+
+package reuseportlb
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+
+	"github.com/cilium/ebpf"
+)
+
+type memguardRatelimitCfg struct {
+	Enabled     uint32
+	Action      uint32
+	PenaltySlot uint32
+	MaxConns    uint32
+	WindowNs    uint64
+}
+
+type memguardShadowState struct {
+	Phase     uint32
+	Candidate uint32
+}
+
+type memguardSlotBucket struct {
+	Rate      uint64
+	Burst     uint64
+	Action    uint32
+	Pad       uint32
+	Tokens    uint64
+	LastNs    uint64
+	Throttled uint64
+}
+
+type memguardSlotMem struct {
+	Bytes    uint64
+	Pressure uint32
+	Pad      uint32
+}
+
+type memguardSlotOverride struct {
+	Slot uint32
+	Hits uint32
+}
+
+type memguardSlotWarmup struct {
+	StartNs  uint64
+	WindowNs uint64
+}
+
+type memguardSrcRate struct {
+	WindowStart uint64
+	Count       uint32
+	Limited     uint32
+}
+
+// loadMemguard returns the embedded CollectionSpec for memguard.
+func loadMemguard() (*ebpf.CollectionSpec, error) {
+	reader := bytes.NewReader(_MemguardBytes)
+	spec, err := ebpf.LoadCollectionSpecFromReader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("can't load memguard: %w", err)
+	}
+
+	return spec, err
+}
+
+// loadMemguardObjects loads memguard and converts it into a struct.
+//
+// The following types are suitable as obj argument:
+//
+//	*memguardObjects
+//	*memguardPrograms
+//	*memguardMaps
+//
+// See ebpf.CollectionSpec.LoadAndAssign documentation for details.
+func loadMemguardObjects(obj interface{}, opts *ebpf.CollectionOptions) error {
+	spec, err := loadMemguard()
+	if err != nil {
+		return err
+	}
+
+	return spec.LoadAndAssign(obj, opts)
+}
+
+// memguardSpecs contains maps and programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type memguardSpecs struct {
+	memguardProgramSpecs
+	memguardMapSpecs
+}
+
+// memguardSpecs contains programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type memguardProgramSpecs struct {
+	MemguardSelector *ebpf.ProgramSpec `ebpf:"memguard_selector"`
+}
+
+// memguardMapSpecs contains maps before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type memguardMapSpecs struct {
+	AcceptqSlotCookies  *ebpf.MapSpec `ebpf:"acceptq_slot_cookies"`
+	PolicyCfg           *ebpf.MapSpec `ebpf:"policy_cfg"`
+	RatelimitCfg        *ebpf.MapSpec `ebpf:"ratelimit_cfg"`
+	ShadowEvents        *ebpf.MapSpec `ebpf:"shadow_events"`
+	ShadowProgs         *ebpf.MapSpec `ebpf:"shadow_progs"`
+	ShadowScratch       *ebpf.MapSpec `ebpf:"shadow_scratch"`
+	SlotBucket          *ebpf.MapSpec `ebpf:"slot_bucket"`
+	SlotDropping        *ebpf.MapSpec `ebpf:"slot_dropping"`
+	SlotMem             *ebpf.MapSpec `ebpf:"slot_mem"`
+	SlotOverride        *ebpf.MapSpec `ebpf:"slot_override"`
+	SlotSelected        *ebpf.MapSpec `ebpf:"slot_selected"`
+	SlotWarmup          *ebpf.MapSpec `ebpf:"slot_warmup"`
+	SrcRate             *ebpf.MapSpec `ebpf:"src_rate"`
+	TcpBalancingTargets *ebpf.MapSpec `ebpf:"tcp_balancing_targets"`
+}
+
+// memguardObjects contains all objects after they have been loaded into the kernel.
+//
+// It can be passed to loadMemguardObjects or ebpf.CollectionSpec.LoadAndAssign.
+type memguardObjects struct {
+	memguardPrograms
+	memguardMaps
+}
+
+func (o *memguardObjects) Close() error {
+	return _MemguardClose(
+		&o.memguardPrograms,
+		&o.memguardMaps,
+	)
+}
+
+// memguardMaps contains all maps after they have been loaded into the kernel.
+//
+// It can be passed to loadMemguardObjects or ebpf.CollectionSpec.LoadAndAssign.
+type memguardMaps struct {
+	AcceptqSlotCookies  *ebpf.Map `ebpf:"acceptq_slot_cookies"`
+	PolicyCfg           *ebpf.Map `ebpf:"policy_cfg"`
+	RatelimitCfg        *ebpf.Map `ebpf:"ratelimit_cfg"`
+	ShadowEvents        *ebpf.Map `ebpf:"shadow_events"`
+	ShadowProgs         *ebpf.Map `ebpf:"shadow_progs"`
+	ShadowScratch       *ebpf.Map `ebpf:"shadow_scratch"`
+	SlotBucket          *ebpf.Map `ebpf:"slot_bucket"`
+	SlotDropping        *ebpf.Map `ebpf:"slot_dropping"`
+	SlotMem             *ebpf.Map `ebpf:"slot_mem"`
+	SlotOverride        *ebpf.Map `ebpf:"slot_override"`
+	SlotSelected        *ebpf.Map `ebpf:"slot_selected"`
+	SlotWarmup          *ebpf.Map `ebpf:"slot_warmup"`
+	SrcRate             *ebpf.Map `ebpf:"src_rate"`
+	TcpBalancingTargets *ebpf.Map `ebpf:"tcp_balancing_targets"`
+}
+
+func (m *memguardMaps) Close() error {
+	return _MemguardClose(
+		m.AcceptqSlotCookies,
+		m.PolicyCfg,
+		m.RatelimitCfg,
+		m.ShadowEvents,
+		m.ShadowProgs,
+		m.ShadowScratch,
+		m.SlotBucket,
+		m.SlotDropping,
+		m.SlotMem,
+		m.SlotOverride,
+		m.SlotSelected,
+		m.SlotWarmup,
+		m.SrcRate,
+		m.TcpBalancingTargets,
+	)
+}
+
+// memguardPrograms contains all programs after they have been loaded into the kernel.
+//
+// It can be passed to loadMemguardObjects or ebpf.CollectionSpec.LoadAndAssign.
+type memguardPrograms struct {
+	MemguardSelector *ebpf.Program `ebpf:"memguard_selector"`
+}
+
+func (p *memguardPrograms) Close() error {
+	return _MemguardClose(
+		p.MemguardSelector,
+	)
+}
+
+func _MemguardClose(closers ...io.Closer) error {
+	for _, closer := range closers {
+		if err := closer.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Do not access this directly.
+//
+//go:embed memguard_bpfel.o
+var _MemguardBytes []byte
@@ -0,0 +1,133 @@
+package reuseportlb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+)
+
+// migrateReqSysctl makes the kernel move requests sitting in a closing
+// listener's accept queue to another listener of the same reuseport group
+// instead of resetting them (Linux 5.14+). Without a migration-aware program
+// attached, the new listener is picked by hash.
+const migrateReqSysctl = "/proc/sys/net/ipv4/tcp_migrate_req"
+
+// ErrMigrationUnsupported is returned when the kernel has no tcp_migrate_req.
+// It matches ErrKernelFeatureMissing.
+var ErrMigrationUnsupported = fmt.Errorf("no reuseport request migration (needs Linux 5.14+): %w", ErrKernelFeatureMissing)
+
+// RequestMigrationEnabled reports whether tcp_migrate_req is on in this
+// network namespace.
+func RequestMigrationEnabled() (bool, error) {
+	b, err := os.ReadFile(migrateReqSysctl)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, ErrMigrationUnsupported
+	}
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(string(b)) == "1", nil
+}
+
+// EnableRequestMigration turns on tcp_migrate_req. The setting is per network
+// namespace, so it affects every reuseport group on the host.
+func EnableRequestMigration() error {
+	on, err := RequestMigrationEnabled()
+	if err != nil || on {
+		return err
+	}
+	if err := os.WriteFile(migrateReqSysctl, []byte("1"), 0o644); err != nil {
+		return fmt.Errorf("enable %s: %w", migrateReqSysctl, err)
+	}
+	return nil
+}
+
+// Deregister removes the socket identified by cookie from slot, so the
+// selector stops steering new connections to it before its listener is
+// closed, and releases the slot in slot_owner if this process holds it.
+// Entries that already belong to another socket (a replacement that
+// registered on the same slot) are left alone, and deregistering twice, or
+// after the group was unpinned, does nothing. Retries stop early when ctx is
+// done.
+func (g Group) Deregister(ctx context.Context, slot uint32, cookie uint64) error {
+	return g.deregister(ctx, slot, cookie, os.Getpid())
+}
+
+// deregister is Deregister on behalf of the slot owner pid.
+func (g Group) deregister(ctx context.Context, slot uint32, cookie uint64, pid int) error {
+	targets, err := g.openAudited(TargetsMap)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer targets.Close()
+
+	// Looking up a sockarray entry yields the socket's cookie.
+	var current uint64
+	if err := targets.Lookup(&slot, &current); err == nil && current == cookie {
+		err := retryTransient(ctx, func() error {
+			if err := targets.Delete(&slot); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+				return fmt.Errorf("remove slot %d from %s: %w", slot, TargetsMap, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	cookies, err := g.openAudited(SlotCookiesMap)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer cookies.Close()
+	if err := cookies.Lookup(&slot, &current); err == nil && current == cookie {
+		var none uint64
+		err := retryTransient(ctx, func() error {
+			if err := cookies.Update(&slot, &none, ebpf.UpdateExist); err != nil {
+				return fmt.Errorf("clear slot %d in %s: %w", slot, SlotCookiesMap, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return g.clearSlotOwner(slot, pid)
+}
+
+// HaveSelectOrMigrate reports whether the kernel accepts sk_reuseport programs
+// of the BPF_SK_REUSEPORT_SELECT_OR_MIGRATE type (Linux 5.14+). Such programs
+// are also run when a listener closes, so the policy rather than the hash
+// decides where each of its queued requests moves.
+var HaveSelectOrMigrate = sync.OnceValues(func() (bool, error) {
+	prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		Type:       ebpf.SkReuseport,
+		AttachType: ebpf.AttachSkReuseportSelectOrMigrate,
+		License:    "GPL",
+		Instructions: asm.Instructions{
+			asm.Mov.Imm(asm.R0, 1), // SK_PASS
+			asm.Return(),
+		},
+	})
+	if errors.Is(err, syscall.EINVAL) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("probe for BPF_SK_REUSEPORT_SELECT_OR_MIGRATE: %w", err)
+	}
+	prog.Close()
+	return true, nil
+})
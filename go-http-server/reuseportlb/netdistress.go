@@ -0,0 +1,257 @@
+package reuseportlb
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+)
+
+// netStale is how old a slot_net entry may be before readers ignore it.
+const netStale = 5 * time.Second
+
+// SlotNet is a value in slot_net: how much trouble a slot's connections
+// have been having on the network lately, as the collector last saw it.
+// Retrans and ECN are smoothed rates in hundredths of an event per second;
+// Score is 100 while the slot is no worse off than the group's median and
+// falls towards 0 the further above it the slot is.
+type SlotNet struct {
+	UpdatedNs uint64
+	Retrans   uint32
+	ECN       uint32
+	Score     uint32
+	Pad       uint32
+}
+
+// netDistress is shared by everything in the process that wants the
+// retransmit and ECN counts; the programs stay attached while anyone holds
+// a reference.
+var netDistress struct {
+	sync.Mutex
+	refs  int
+	close func()
+}
+
+// StartNetDistress attaches the network distress tracker (a
+// tcp_retransmit_skb tracepoint, a tcp_enter_cwr probe and an
+// inet_csk_accept probe telling them which listener a connection belongs
+// to) and returns a func that detaches it again. It needs the same kernel
+// as the latency probes.
+func StartNetDistress() (func(), error) {
+	netDistress.Lock()
+	defer netDistress.Unlock()
+	if netDistress.refs == 0 {
+		closeTracker, err := attachNetDistress()
+		if err != nil {
+			return nil, err
+		}
+		netDistress.close = closeTracker
+	}
+	netDistress.refs++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			netDistress.Lock()
+			defer netDistress.Unlock()
+			if netDistress.refs--; netDistress.refs == 0 {
+				netDistress.close()
+			}
+		})
+	}, nil
+}
+
+func attachNetDistress() (func(), error) {
+	var objs netdistressObjects
+	opts := &ebpf.CollectionOptions{Maps: ebpf.MapOptions{PinPath: PinPath}}
+	if err := loadNetdistressObjects(&objs, opts); err != nil {
+		return nil, kernelFeature(fmt.Errorf("load network distress tracker: %w", err))
+	}
+	var links []link.Link
+	closeAll := func() {
+		for _, l := range links {
+			l.Close()
+		}
+		objs.Close()
+	}
+	for _, prog := range []*ebpf.Program{objs.NdAccept, objs.NdRetransmit, objs.NdCwr} {
+		l, err := link.AttachTracing(link.TracingOptions{Program: prog})
+		if err != nil {
+			closeAll()
+			return nil, kernelFeature(fmt.Errorf("attach network distress probe %s: %w", prog, err))
+		}
+		links = append(links, l)
+	}
+	return closeAll, nil
+}
+
+// netCount is what net_distress holds for the listener of a slot.
+type netCount struct {
+	cookie       uint64
+	retrans, ecn uint64
+}
+
+// netCounts reads the retransmit and ECN counts of every registered slot
+// whose listener has accepted a connection since the tracker started.
+func (g Group) netCounts() (map[uint32]netCount, error) {
+	cookies, err := g.OpenPinnedMap(SlotCookiesMap)
+	if err != nil {
+		return nil, err
+	}
+	defer cookies.Close()
+	m, err := g.OpenPinnedMap(NetDistressMap)
+	if err != nil {
+		return nil, err
+	}
+	defer m.Close()
+
+	out := make(map[uint32]netCount)
+	var slot uint32
+	var cookie uint64
+	iter := cookies.Iterate()
+	for iter.Next(&slot, &cookie) {
+		if cookie == 0 {
+			continue
+		}
+		var v netdistressNetDistress
+		if err := m.Lookup(&cookie, &v); err != nil {
+			continue
+		}
+		out[slot] = netCount{cookie: cookie, retrans: v.Retrans, ecn: v.Ecn}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("iterate %s: %w", SlotCookiesMap, err)
+	}
+	return out, nil
+}
+
+// netSampler turns the tracker's counts into smoothed per-slot rates and
+// scores them against each other, for slot_net. Judging slots against the
+// group rather than against a fixed rate keeps a lossy path that every
+// instance shares from moving connections around for nothing.
+type netSampler struct {
+	cfg     CollectorConfig
+	prev    map[uint32]netCount
+	at      time.Time
+	retrans map[uint32]*EWMA
+	ecn     map[uint32]*EWMA
+	last    map[uint32]SlotNet
+}
+
+func newNetSampler(cfg CollectorConfig) *netSampler {
+	return &netSampler{
+		cfg:     cfg,
+		prev:    make(map[uint32]netCount),
+		retrans: make(map[uint32]*EWMA),
+		ecn:     make(map[uint32]*EWMA),
+		last:    make(map[uint32]SlotNet),
+	}
+}
+
+// sample reads the counts and returns what slot_net gets. A slot is left
+// out until two readings of the same listener are in.
+func (s *netSampler) sample(g Group) (map[uint32]SlotNet, error) {
+	counts, err := g.netCounts()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	secs := now.Sub(s.at).Seconds()
+	s.at = now
+	clear(s.last)
+	rates := make(map[uint32]float64)
+	for slot, c := range counts {
+		p, seen := s.prev[slot]
+		s.prev[slot] = c
+		if !seen || p.cookie != c.cookie || c.retrans < p.retrans || c.ecn < p.ecn {
+			delete(s.retrans, slot)
+			delete(s.ecn, slot)
+			continue
+		}
+		if secs <= 0 {
+			continue
+		}
+		r, ok := s.retrans[slot]
+		if !ok {
+			r, s.ecn[slot] = s.cfg.newEWMA(), s.cfg.newEWMA()
+			s.retrans[slot] = r
+		}
+		e := s.ecn[slot]
+		rv := r.Update(float64(c.retrans-p.retrans) / secs)
+		ev := e.Update(float64(c.ecn-p.ecn) / secs)
+		s.last[slot] = SlotNet{Retrans: uint32(rv*100 + 0.5), ECN: uint32(ev*100 + 0.5)}
+		rates[slot] = rv + ev
+	}
+	for slot := range s.prev {
+		if _, ok := counts[slot]; !ok {
+			delete(s.prev, slot)
+			delete(s.retrans, slot)
+			delete(s.ecn, slot)
+		}
+	}
+	median := medianFloat(rates)
+	for slot, v := range s.last {
+		// One event a second of slack keeps a quiet group from scoring
+		// a slot down over a single retransmit.
+		v.Score = uint32(min((median+1)/(rates[slot]+1), 1)*100 + 0.5)
+		s.last[slot] = v
+	}
+	return s.last, nil
+}
+
+// publishNet writes the slots' network distress into slot_net.
+func publishNet(m *ebpf.Map, values map[uint32]SlotNet) error {
+	now, err := monotonicNow()
+	if err != nil {
+		return err
+	}
+	for slot, v := range values {
+		v.UpdatedNs = now
+		if err := m.Update(slot, v, ebpf.UpdateAny); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SlotNetDistress reads slot_net: the network distress the collector last
+// saw for each slot, leaving out stale entries.
+func (g Group) SlotNetDistress() (map[uint32]SlotNet, error) {
+	m, err := g.OpenPinnedMap(SlotNetMap)
+	if err != nil {
+		return nil, err
+	}
+	defer m.Close()
+	now, err := monotonicNow()
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[uint32]SlotNet)
+	var slot uint32
+	var v SlotNet
+	iter := m.Iterate()
+	for iter.Next(&slot, &v) {
+		if v.UpdatedNs != 0 && now-v.UpdatedNs < uint64(netStale) {
+			out[slot] = v
+		}
+	}
+	return out, iter.Err()
+}
+
+// logNet writes one line of network distress per slot.
+func logNet(logger *log.Logger, ts string, values map[uint32]SlotNet) {
+	slots := make([]uint32, 0, len(values))
+	for slot := range values {
+		slots = append(slots, slot)
+	}
+	sort.Slice(slots, func(i, j int) bool { return slots[i] < slots[j] })
+	for _, slot := range slots {
+		v := values[slot]
+		logger.Printf("ts=%s slot=%d retrans_per_s=%.2f ecn_per_s=%.2f score=%d",
+			ts, slot, float64(v.Retrans)/100, float64(v.ECN)/100, v.Score)
+	}
+}
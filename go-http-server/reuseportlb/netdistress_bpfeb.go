@@ -0,0 +1,135 @@
+// Code generated by bpf2go; DO NOT EDIT.
+//go:build mips || mips64 || ppc64 || s390x
+
+package reuseportlb
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+
+	"github.com/cilium/ebpf"
+)
+
+type netdistressNdOwner struct{ Listener uint64 }
+
+type netdistressNetDistress struct {
+	Retrans uint64
+	Ecn     uint64
+}
+
+// loadNetdistress returns the embedded CollectionSpec for netdistress.
+func loadNetdistress() (*ebpf.CollectionSpec, error) {
+	reader := bytes.NewReader(_NetdistressBytes)
+	spec, err := ebpf.LoadCollectionSpecFromReader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("can't load netdistress: %w", err)
+	}
+
+	return spec, err
+}
+
+// loadNetdistressObjects loads netdistress and converts it into a struct.
+//
+// The following types are suitable as obj argument:
+//
+//	*netdistressObjects
+//	*netdistressPrograms
+//	*netdistressMaps
+//
+// See ebpf.CollectionSpec.LoadAndAssign documentation for details.
+func loadNetdistressObjects(obj interface{}, opts *ebpf.CollectionOptions) error {
+	spec, err := loadNetdistress()
+	if err != nil {
+		return err
+	}
+
+	return spec.LoadAndAssign(obj, opts)
+}
+
+// netdistressSpecs contains maps and programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type netdistressSpecs struct {
+	netdistressProgramSpecs
+	netdistressMapSpecs
+}
+
+// netdistressSpecs contains programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type netdistressProgramSpecs struct {
+	NdAccept     *ebpf.ProgramSpec `ebpf:"nd_accept"`
+	NdCwr        *ebpf.ProgramSpec `ebpf:"nd_cwr"`
+	NdRetransmit *ebpf.ProgramSpec `ebpf:"nd_retransmit"`
+}
+
+// netdistressMapSpecs contains maps before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type netdistressMapSpecs struct {
+	NdOwner     *ebpf.MapSpec `ebpf:"nd_owner"`
+	NetDistress *ebpf.MapSpec `ebpf:"net_distress"`
+}
+
+// netdistressObjects contains all objects after they have been loaded into the kernel.
+//
+// It can be passed to loadNetdistressObjects or ebpf.CollectionSpec.LoadAndAssign.
+type netdistressObjects struct {
+	netdistressPrograms
+	netdistressMaps
+}
+
+func (o *netdistressObjects) Close() error {
+	return _NetdistressClose(
+		&o.netdistressPrograms,
+		&o.netdistressMaps,
+	)
+}
+
+// netdistressMaps contains all maps after they have been loaded into the kernel.
+//
+// It can be passed to loadNetdistressObjects or ebpf.CollectionSpec.LoadAndAssign.
+type netdistressMaps struct {
+	NdOwner     *ebpf.Map `ebpf:"nd_owner"`
+	NetDistress *ebpf.Map `ebpf:"net_distress"`
+}
+
+func (m *netdistressMaps) Close() error {
+	return _NetdistressClose(
+		m.NdOwner,
+		m.NetDistress,
+	)
+}
+
+// netdistressPrograms contains all programs after they have been loaded into the kernel.
+//
+// It can be passed to loadNetdistressObjects or ebpf.CollectionSpec.LoadAndAssign.
+type netdistressPrograms struct {
+	NdAccept     *ebpf.Program `ebpf:"nd_accept"`
+	NdCwr        *ebpf.Program `ebpf:"nd_cwr"`
+	NdRetransmit *ebpf.Program `ebpf:"nd_retransmit"`
+}
+
+func (p *netdistressPrograms) Close() error {
+	return _NetdistressClose(
+		p.NdAccept,
+		p.NdCwr,
+		p.NdRetransmit,
+	)
+}
+
+func _NetdistressClose(closers ...io.Closer) error {
+	for _, closer := range closers {
+		if err := closer.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Do not access this directly.
+//
+//go:embed netdistress_bpfeb.o
+var _NetdistressBytes []byte
@@ -0,0 +1,374 @@
+package reuseportlb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultOutlierInterval is how often an OutlierDetector judges the slots.
+const DefaultOutlierInterval = 5 * time.Second
+
+// minOutlierSlots is the fewest slots an interval needs to have been judged
+// in for an outlier to stand out: with two, neither is the odd one.
+const minOutlierSlots = 3
+
+// OutlierConfig sets when an OutlierDetector takes a slot out of rotation
+// and for how long.
+type OutlierConfig struct {
+	// Interval is how often the slots are judged, each on the requests it
+	// served since the last time.
+	Interval time.Duration
+	// Factor is how many times the median of the slots' mean latency a
+	// slot's own has to reach to count as an outlier in an interval.
+	Factor float64
+	// ErrorPct makes a slot whose error rate (see ErrorCounter) is at least
+	// this many percentage points over the median of the slots' an outlier
+	// too; 0 judges latency alone.
+	ErrorPct float64
+	// MinRequests is the fewest requests a slot needs to serve in an
+	// interval to be judged in it.
+	MinRequests uint64
+	// Consecutive is how many intervals in a row a slot has to be an
+	// outlier before it is quarantined, so one slow interval does not.
+	Consecutive int
+	// Quarantine is how long a slot stays out the first time. Each time it
+	// is quarantined again the time doubles, up to MaxQuarantine; a slot
+	// that stays in rotation for MaxQuarantine starts over.
+	Quarantine    time.Duration
+	MaxQuarantine time.Duration
+	// MaxFraction bounds the share of the group's slots out at once. One
+	// slot may always be.
+	MaxFraction float64
+	// Rampup is the slow-start window a slot gets when it comes back (see
+	// Group.StartWarmup); 0 puts it back at full weight.
+	Rampup time.Duration
+	// Webhook, if set, is POSTed every quarantine and release as JSON.
+	Webhook string
+}
+
+// Validate reports the first setting an OutlierDetector cannot work with.
+func (c OutlierConfig) Validate() error {
+	if c.Factor <= 1 || c.ErrorPct < 0 {
+		return fmt.Errorf("invalid outlier factor %v or error rate %v: want a factor over 1 and a rate not negative", c.Factor, c.ErrorPct)
+	}
+	if c.Interval <= 0 || c.Quarantine <= 0 || c.MaxQuarantine < c.Quarantine {
+		return errors.New("interval and quarantine must be positive, and the longest quarantine at least the first")
+	}
+	if c.Consecutive < 1 || c.MaxFraction < 0 || c.MaxFraction > 1 || c.Rampup < 0 {
+		return errors.New("consecutive must be at least 1, max fraction within [0, 1] and ramp-up not negative")
+	}
+	return nil
+}
+
+// OutlierEvent is what an OutlierDetector logs and posts when it
+// quarantines or releases a slot.
+type OutlierEvent struct {
+	Group string `json:"group"`
+	Slot  uint32 `json:"slot"`
+	State string `json:"state"` // quarantined or released
+	// Mean is the slot's mean latency over the interval that decided it,
+	// Median the median of every judged slot's, and ErrorRate the slot's
+	// error rate in percent; all 0 on release.
+	Mean      time.Duration `json:"mean"`
+	Median    time.Duration `json:"median"`
+	ErrorRate float64       `json:"error_rate"`
+	// Until is when a quarantine ends.
+	Until time.Time `json:"until,omitempty"`
+	// Quarantines is how many times in a row the slot has been put out.
+	Quarantines int `json:"quarantines"`
+}
+
+// OutlierStatus is the detector's state for the control API.
+type OutlierStatus struct {
+	// Means are the mean latencies of the slots judged in the last
+	// interval, Median their median.
+	Means  map[uint32]time.Duration `json:"means"`
+	Median time.Duration            `json:"median"`
+	// Quarantined maps each slot out of rotation to when it comes back.
+	Quarantined map[uint32]time.Time `json:"quarantined"`
+	Checked     time.Time            `json:"checked"`
+}
+
+// outlierSlot is what the detector remembers about one slot.
+type outlierSlot struct {
+	prev LatencyHistogram
+	// strikes counts the intervals in a row the slot was an outlier.
+	strikes int
+	// quarantines counts its quarantines since it last stayed in rotation
+	// for MaxQuarantine; released is when the last one ended.
+	quarantines int
+	released    time.Time
+	// until is when its quarantine ends, zero while in rotation; saved
+	// is the connection limit it had before.
+	until time.Time
+	saved SlotLimit
+}
+
+// OutlierDetector watches the accept-to-response latency of a group's
+// slots, and with ErrorPct their error rate, and takes one that is
+// consistently far slower or failing more than the rest out of rotation
+// for a while, whatever the policy: an instance stuck in GC or
+// waiting on a bad disk stops getting new connections. A quarantined slot
+// gets a token bucket of one connection a second that redistributes the
+// rest, so it keeps listening and drains what it has. It needs the latency
+// probes.
+type OutlierDetector struct {
+	group  Group
+	cfg    OutlierConfig
+	client *http.Client
+
+	slots map[uint32]*outlierSlot
+
+	mu     sync.Mutex
+	status OutlierStatus
+}
+
+// NewOutlierDetector returns an outlier detector for the group.
+func (g Group) NewOutlierDetector(cfg OutlierConfig) *OutlierDetector {
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultOutlierInterval
+	}
+	return &OutlierDetector{
+		group:  g,
+		cfg:    cfg,
+		client: &http.Client{Timeout: 5 * time.Second},
+		slots:  make(map[uint32]*outlierSlot),
+	}
+}
+
+// Status returns the result of the last check.
+func (d *OutlierDetector) Status() OutlierStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.status
+}
+
+// Run judges the group every Interval until ctx is done, and then puts
+// every quarantined slot back. Without latency histograms there is nothing
+// to judge, which is logged once.
+func (d *OutlierDetector) Run(ctx context.Context) error {
+	if err := d.cfg.Validate(); err != nil {
+		return err
+	}
+	defer d.releaseAll()
+	ticker := time.NewTicker(d.cfg.Interval)
+	defer ticker.Stop()
+	failing := false
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		events, err := d.check(time.Now())
+		if errors.Is(err, os.ErrNotExist) {
+			slog.Warn("No latency histograms (is -latency set?), outlier detection stopped", "group", d.group.String())
+			return nil
+		}
+		if err != nil && !failing {
+			slog.Warn("Outlier check failed", "group", d.group.String(), "err", err)
+		}
+		failing = err != nil
+		for _, ev := range events {
+			d.notify(ctx, ev)
+		}
+	}
+}
+
+// check judges the interval since the last one and returns what it changed.
+func (d *OutlierDetector) check(now time.Time) ([]OutlierEvent, error) {
+	hists, err := d.group.LatencyHistograms()
+	if err != nil {
+		return nil, err
+	}
+
+	var events []OutlierEvent
+	means := make(map[uint32]time.Duration)
+	for slot, h := range hists {
+		st, ok := d.slots[slot]
+		if !ok || h.Count < st.prev.Count {
+			// A new listener in the slot starts with a clean slate.
+			if ok && !st.until.IsZero() {
+				events = append(events, d.release(slot, st, now))
+			}
+			st = &outlierSlot{}
+			d.slots[slot] = st
+		}
+		count, sum := h.Count-st.prev.Count, h.Sum-st.prev.Sum
+		st.prev = h
+		if !st.until.IsZero() {
+			if now.Before(st.until) {
+				continue
+			}
+			events = append(events, d.release(slot, st, now))
+		}
+		if st.quarantines > 0 && now.Sub(st.released) >= d.cfg.MaxQuarantine {
+			st.quarantines = 0
+		}
+		if count >= max(d.cfg.MinRequests, 1) {
+			means[slot] = sum / time.Duration(count)
+		}
+	}
+
+	median := medianDuration(means)
+	errRates := make(map[uint32]float64)
+	if d.cfg.ErrorPct > 0 {
+		// Servers without an ErrorCounter publish nothing, and only
+		// latency judges them.
+		published, _ := d.group.SlotErrorRates()
+		for slot := range means {
+			if v, ok := published[slot]; ok {
+				errRates[slot] = float64(v.Rate) / 100
+			}
+		}
+	}
+	errMedian := medianFloat(errRates)
+	quarantined := 0
+	for _, st := range d.slots {
+		if !st.until.IsZero() {
+			quarantined++
+		}
+	}
+	room := max(int(d.cfg.MaxFraction*float64(len(hists))), 1) - quarantined
+
+	// Slowest first, so that with little room the worst goes.
+	judged := make([]uint32, 0, len(means))
+	for slot := range means {
+		judged = append(judged, slot)
+	}
+	sort.Slice(judged, func(i, j int) bool { return means[judged[i]] > means[judged[j]] })
+	for _, slot := range judged {
+		st := d.slots[slot]
+		slow := float64(means[slot]) >= d.cfg.Factor*float64(median)
+		rate, published := errRates[slot]
+		failing := published && len(errRates) >= minOutlierSlots && rate >= errMedian+d.cfg.ErrorPct
+		if len(means) < minOutlierSlots || !slow && !failing {
+			st.strikes = 0
+			continue
+		}
+		st.strikes++
+		if st.strikes < d.cfg.Consecutive || room <= 0 {
+			continue
+		}
+		ev, err := d.quarantine(slot, st, now)
+		if err != nil {
+			slog.Warn("Quarantining outlier failed", "group", d.group.String(), "slot", slot, "err", err)
+			continue
+		}
+		ev.Mean, ev.Median, ev.ErrorRate = means[slot], median, rate
+		events = append(events, ev)
+		room--
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.status = OutlierStatus{Means: means, Median: median, Quarantined: make(map[uint32]time.Time), Checked: now}
+	for slot, st := range d.slots {
+		if !st.until.IsZero() {
+			d.status.Quarantined[slot] = st.until
+		}
+	}
+	return events, nil
+}
+
+// quarantine takes slot out of rotation, remembering its connection limit.
+func (d *OutlierDetector) quarantine(slot uint32, st *outlierSlot, now time.Time) (OutlierEvent, error) {
+	limits, err := d.group.SlotLimits()
+	if err != nil {
+		return OutlierEvent{}, err
+	}
+	saved := limits[slot].SlotLimit
+	if err := d.group.SetSlotLimit(slot, SlotLimit{Rate: 1, Burst: 1, Action: SlotLimitRedistribute}); err != nil {
+		return OutlierEvent{}, err
+	}
+	hold := d.cfg.Quarantine << min(st.quarantines, 16)
+	if hold > d.cfg.MaxQuarantine || hold <= 0 {
+		hold = d.cfg.MaxQuarantine
+	}
+	st.quarantines++
+	st.strikes = 0
+	st.until, st.saved = now.Add(hold), saved
+	return OutlierEvent{Group: d.group.String(), Slot: slot, State: "quarantined", Until: st.until, Quarantines: st.quarantines}, nil
+}
+
+// release puts slot back in rotation with the limit it had, ramping it up
+// over Rampup.
+func (d *OutlierDetector) release(slot uint32, st *outlierSlot, now time.Time) OutlierEvent {
+	if err := d.group.SetSlotLimit(slot, st.saved); err != nil {
+		slog.Warn("Restoring the connection limit of a quarantined slot failed", "group", d.group.String(), "slot", slot, "err", err)
+	}
+	if d.cfg.Rampup > 0 {
+		if err := d.group.StartWarmup(slot, d.cfg.Rampup); err != nil {
+			slog.Warn("Ramping up a released slot failed", "group", d.group.String(), "slot", slot, "err", err)
+		}
+	}
+	st.until, st.released = time.Time{}, now
+	return OutlierEvent{Group: d.group.String(), Slot: slot, State: "released", Quarantines: st.quarantines}
+}
+
+// releaseAll puts every quarantined slot back, so that a detector going
+// away leaves no slot out of rotation for good.
+func (d *OutlierDetector) releaseAll() {
+	now := time.Now()
+	for slot, st := range d.slots {
+		if !st.until.IsZero() {
+			d.notify(context.Background(), d.release(slot, st, now))
+		}
+	}
+}
+
+// notify logs the event and posts it to the webhook.
+func (d *OutlierDetector) notify(ctx context.Context, ev OutlierEvent) {
+	if ev.State == "quarantined" {
+		slog.Warn("Quarantined outlier slot", "group", ev.Group, "slot", ev.Slot, "mean", ev.Mean, "median", ev.Median,
+			"error_rate", fmt.Sprintf("%.2f", ev.ErrorRate),
+			"until", ev.Until.Format(time.RFC3339), "quarantines", ev.Quarantines)
+	} else {
+		slog.Info("Released quarantined slot", "group", ev.Group, "slot", ev.Slot, "quarantines", ev.Quarantines)
+	}
+	if d.cfg.Webhook == "" {
+		return
+	}
+	if err := postJSON(ctx, d.client, d.cfg.Webhook, ev); err != nil {
+		slog.Warn("Outlier webhook failed", "err", err)
+	}
+}
+
+// medianDuration returns the median of the values of m, 0 if it is empty.
+func medianDuration(m map[uint32]time.Duration) time.Duration {
+	if len(m) == 0 {
+		return 0
+	}
+	vs := make([]time.Duration, 0, len(m))
+	for _, v := range m {
+		vs = append(vs, v)
+	}
+	sort.Slice(vs, func(i, j int) bool { return vs[i] < vs[j] })
+	if n := len(vs); n%2 == 0 {
+		return (vs[n/2-1] + vs[n/2]) / 2
+	}
+	return vs[len(vs)/2]
+}
+
+// medianFloat returns the median of the values of m, 0 if it is empty.
+func medianFloat(m map[uint32]float64) float64 {
+	if len(m) == 0 {
+		return 0
+	}
+	vs := make([]float64, 0, len(m))
+	for _, v := range m {
+		vs = append(vs, v)
+	}
+	sort.Float64s(vs)
+	if n := len(vs); n%2 == 0 {
+		return (vs[n/2-1] + vs[n/2]) / 2
+	}
+	return vs[len(vs)/2]
+}
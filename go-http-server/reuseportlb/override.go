@@ -0,0 +1,89 @@
+package reuseportlb
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"sort"
+
+	"github.com/cilium/ebpf"
+)
+
+// Override pins a client address to a slot: every selector places the
+// client's connections there while the slot has a listener, ahead of the
+// per-source limiter, the slot buckets and the policy itself.
+type Override struct {
+	Addr netip.Addr
+	Slot uint32
+	// Hits is how many connections the override placed.
+	Hits uint32
+}
+
+// overrideKey is the slot_override key of addr: IPv6, with IPv4 mapped.
+func overrideKey(addr netip.Addr) [16]byte {
+	return addr.As16()
+}
+
+// SetOverride routes the connections of addr to slot from the next one on.
+func (g Group) SetOverride(addr netip.Addr, slot uint32) error {
+	if !addr.IsValid() {
+		return errors.New("override needs a client address")
+	}
+	if slot >= mapLayouts[TargetsMap].MaxEntries {
+		return fmt.Errorf("slot %d out of range", slot)
+	}
+	if err := g.requireFeature(FeatureOverride); err != nil {
+		return err
+	}
+	m, err := g.openAudited(SlotOverrideMap)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	k := overrideKey(addr)
+	if err := m.Update(&k, &slotOverride{Slot: slot}, ebpf.UpdateAny); err != nil {
+		return fmt.Errorf("write %s: %w", SlotOverrideMap, err)
+	}
+	return nil
+}
+
+// ClearOverride hands addr back to the policy. Clearing an address without
+// an override is not an error.
+func (g Group) ClearOverride(addr netip.Addr) error {
+	m, err := g.openAudited(SlotOverrideMap)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	k := overrideKey(addr)
+	if err := m.Delete(&k); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+		return fmt.Errorf("delete from %s: %w", SlotOverrideMap, err)
+	}
+	return nil
+}
+
+// Overrides lists the group's overrides by address.
+func (g Group) Overrides() ([]Override, error) {
+	m, err := g.openAudited(SlotOverrideMap)
+	if err != nil {
+		return nil, err
+	}
+	defer m.Close()
+
+	var (
+		k   [16]byte
+		v   slotOverride
+		out []Override
+	)
+	iter := m.Iterate()
+	for iter.Next(&k, &v) {
+		out = append(out, Override{Addr: netip.AddrFrom16(k).Unmap(), Slot: v.Slot, Hits: v.Hits})
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("iterate %s: %w", SlotOverrideMap, err)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Addr.Less(out[j].Addr) })
+	return out, nil
+}
@@ -0,0 +1,249 @@
+package reuseportlb
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/cilium/ebpf"
+)
+
+// ErrLoaderRunning is returned when a group's policy is to be loaded while
+// another live process already has.
+var ErrLoaderRunning = errors.New("policy already loaded by another live process")
+
+// loaderInfo is the single value of the loader map: who loaded the group's
+// policy and pinned its selector, and what that selector is.
+type loaderInfo struct {
+	Pid   uint32
+	Flags uint32
+	Start uint64
+	// Policy is the policy name, NUL-padded.
+	Policy [16]byte
+}
+
+// loaderSelectOrMigrate is set in loaderInfo.Flags when the pinned selector
+// was loaded as BPF_SK_REUSEPORT_SELECT_OR_MIGRATE.
+const loaderSelectOrMigrate = 1 << 0
+
+// loaderFeaturesShift is where the pinned selector's Features start in
+// loaderInfo.Flags, above the flag bits.
+const loaderFeaturesShift = 8
+
+// loaderFeatures are the features the loader recorded for its selector.
+func loaderFeatures(info loaderInfo) Features {
+	return Features(info.Flags >> loaderFeaturesShift)
+}
+
+// lockPins takes an exclusive advisory lock on the group's pin directory.
+// Loading, joining and leaving all happen under it, so two loaders cannot
+// both pin objects and a leaving instance cannot unpin what a starting one
+// is about to use.
+func (g Group) lockPins() (unlock func(), err error) {
+	if err := g.ensurePinDir(); err != nil {
+		return nil, err
+	}
+	dir, err := os.Open(g.PinDir())
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(dir); err != nil {
+		dir.Close()
+		return nil, fmt.Errorf("lock %s: %w", g.PinDir(), err)
+	}
+	return func() {
+		unlockFile(dir)
+		dir.Close()
+	}, nil
+}
+
+// Loader returns the pid of the live process that loaded the group's
+// policy, or 0 if there is none.
+func (g Group) Loader() (int, error) {
+	info, err := g.loaderInfo()
+	if err != nil || info.Pid == 0 {
+		return 0, err
+	}
+	if start, err := procStartTime(int(info.Pid)); err != nil || start != info.Start {
+		return 0, nil
+	}
+	return int(info.Pid), nil
+}
+
+// loaderInfo reads the loader map; it is zero if nothing was ever loaded.
+func (g Group) loaderInfo() (loaderInfo, error) {
+	var info loaderInfo
+	m, err := g.OpenPinnedMap(LoaderMap)
+	if errors.Is(err, os.ErrNotExist) {
+		return info, nil
+	}
+	if err != nil {
+		return info, err
+	}
+	defer m.Close()
+	var key uint32
+	if err := m.Lookup(&key, &info); err != nil {
+		return info, fmt.Errorf("read %s: %w", LoaderMap, err)
+	}
+	return info, nil
+}
+
+// setLoader records this process as the loader of policy, whose selector
+// has features.
+func (g Group) setLoader(policy string, selectOrMigrate bool, features Features) error {
+	pid := os.Getpid()
+	start, err := procStartTime(pid)
+	if err != nil {
+		return err
+	}
+	info := loaderInfo{Pid: uint32(pid), Flags: uint32(features) << loaderFeaturesShift, Start: start}
+	copy(info.Policy[:], policy)
+	if selectOrMigrate {
+		info.Flags |= loaderSelectOrMigrate
+	}
+	m, err := g.openOrCreateAudited(LoaderMap)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	var key uint32
+	if err := m.Update(&key, &info, ebpf.UpdateAny); err != nil {
+		return fmt.Errorf("update %s: %w", LoaderMap, err)
+	}
+	return nil
+}
+
+// LoadSharedPolicy loads policy as the group's loader: under the pin lock
+// it removes stale pins, loads the policy (or takes over the selector of a
+// previous loader, see reusePinned), pins the selector at ProgramPath for
+// other processes to attach and records this process as the loader. It
+// fails with ErrLoaderRunning if a live loader is already recorded.
+func (g Group) LoadSharedPolicy(policy string, migrate bool, features Features) (LoadedObjects, error) {
+	unlock, err := g.lockPins()
+	if err != nil {
+		return LoadedObjects{}, err
+	}
+	defer unlock()
+	return g.loadShared(policy, migrate, features)
+}
+
+// loadShared is LoadSharedPolicy with the pin lock already held.
+func (g Group) loadShared(policy string, migrate bool, features Features) (LoadedObjects, error) {
+	if pid, err := g.Loader(); err != nil {
+		return LoadedObjects{}, err
+	} else if pid != 0 && pid != os.Getpid() {
+		return LoadedObjects{}, fmt.Errorf("group %s: %w (pid %d)", g, ErrLoaderRunning, pid)
+	}
+	if err := g.repairPins(); err != nil {
+		return LoadedObjects{}, err
+	}
+	if objs, ok, err := g.reusePinned(policy, features); err != nil || ok {
+		return objs, err
+	}
+	objs, err := g.LoadPolicy(policy, migrate, features)
+	if err != nil {
+		return LoadedObjects{}, err
+	}
+	// Any selector still pinned was left by a loader that is gone.
+	if err := os.Remove(g.ProgramPath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		objs.Close()
+		return LoadedObjects{}, fmt.Errorf("remove stale selector pin: %w", err)
+	}
+	if err := objs.Program.Pin(g.ProgramPath()); err != nil {
+		objs.Close()
+		return LoadedObjects{}, fmt.Errorf("pin selector: %w", err)
+	}
+	if err := g.setLoader(policy, objs.SelectOrMigrate, features); err != nil {
+		objs.Program.Unpin()
+		objs.Close()
+		return LoadedObjects{}, err
+	}
+	g.journal(EventLoad, objs.Program, policy)
+	return objs, nil
+}
+
+// reusePinned takes over the selector a previous loader of the same policy
+// and features pinned, as long as the group is still in use: a loader
+// restarting while other servers keep the group alive then neither loads a
+// second copy nor replaces the maps those servers' selector works on. A
+// group nobody uses is loaded afresh.
+func (g Group) reusePinned(policy string, features Features) (LoadedObjects, bool, error) {
+	info, err := g.loaderInfo()
+	if err != nil || info.Pid == 0 {
+		return LoadedObjects{}, false, err
+	}
+	if pinned := cString(info.Policy[:]); pinned != policy {
+		slog.Info("Pinned selector runs another policy, replacing it", "group", g.String(), "pinned", pinned, "policy", policy)
+		return LoadedObjects{}, false, nil
+	}
+	if pinned := loaderFeatures(info); pinned != features {
+		slog.Info("Pinned selector has other features, replacing it", "group", g.String(), "pinned", pinned.String(), "features", features.String())
+		return LoadedObjects{}, false, nil
+	}
+	sockets, err := g.liveSockets()
+	if err != nil {
+		return LoadedObjects{}, false, err
+	}
+	instances := 0
+	if m, err := g.OpenPinnedMap(InstancesMap); err == nil {
+		instances, err = liveInstances(m)
+		m.Close()
+		if err != nil {
+			return LoadedObjects{}, false, err
+		}
+	}
+	if sockets == 0 && instances == 0 {
+		return LoadedObjects{}, false, nil
+	}
+	prog, err := ebpf.LoadPinnedProgram(g.ProgramPath(), nil)
+	if errors.Is(err, os.ErrNotExist) {
+		return LoadedObjects{}, false, nil
+	}
+	if err != nil {
+		return LoadedObjects{}, false, fmt.Errorf("load pinned selector: %w", err)
+	}
+	selectOrMigrate := info.Flags&loaderSelectOrMigrate != 0
+	if err := g.setLoader(policy, selectOrMigrate, features); err != nil {
+		prog.Close()
+		return LoadedObjects{}, false, err
+	}
+	g.journal(EventReuse, prog, policy)
+	slog.Info("Reusing selector pinned by a previous loader", "group", g.String(), "policy", policy,
+		"sockets", sockets, "instances", instances)
+	return LoadedObjects{Program: prog, Close: prog.Close, SelectOrMigrate: selectOrMigrate, Features: features}, true, nil
+}
+
+// LoadOrAttachPolicy is LoadSharedPolicy for servers that may race each
+// other to load the group's policy: the first becomes the loader, and
+// every later one, rather than loading and pinning its own copy, attaches
+// the selector the loader pinned. attached reports the latter, in which
+// case the policy is already configured. Attaching a selector the loader
+// pinned with another policy or other features fails with
+// ErrLoaderRunning rather than silently serving under it.
+func (g Group) LoadOrAttachPolicy(policy string, migrate bool, features Features) (objs LoadedObjects, attached bool, err error) {
+	unlock, err := g.lockPins()
+	if err != nil {
+		return LoadedObjects{}, false, err
+	}
+	defer unlock()
+	objs, err = g.loadShared(policy, migrate, features)
+	if !errors.Is(err, ErrLoaderRunning) {
+		return objs, false, err
+	}
+	info, infoErr := g.loaderInfo()
+	if infoErr != nil {
+		return LoadedObjects{}, false, infoErr
+	}
+	pinned, pinnedFeatures := cString(info.Policy[:]), loaderFeatures(info)
+	if pinned != policy || pinnedFeatures != features {
+		return LoadedObjects{}, false, fmt.Errorf("%w with policy %s and features %s, not %s and %s",
+			err, pinned, pinnedFeatures, policy, features)
+	}
+	slog.Info("Policy already loaded by another instance, attaching its selector", "group", g.String(), "err", err)
+	prog, err := g.LoadPinnedProgram()
+	if err != nil {
+		return LoadedObjects{}, false, err
+	}
+	return LoadedObjects{Program: prog, Close: prog.Close, SelectOrMigrate: info.Flags&loaderSelectOrMigrate != 0, Features: features}, true, nil
+}
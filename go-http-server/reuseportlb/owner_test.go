@@ -0,0 +1,78 @@
+package reuseportlb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/cilium/ebpf"
+)
+
+// TestLoadOrAttachMismatch has another live process stand in as the
+// group's loader and checks that only a server asking for the policy and
+// features it pinned attaches.
+func TestLoadOrAttachMismatch(t *testing.T) {
+	requireBPF(t)
+	g, err := ParseGroup(fmt.Sprintf("owner-test-%d", os.Getpid()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.ensurePinDir(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(g.PinDir()) })
+
+	objs, attached, err := g.LoadOrAttachPolicy("round-robin", false, DefaultFeatures)
+	if err != nil || attached {
+		t.Fatalf("first LoadOrAttachPolicy: attached %v, %v", attached, err)
+	}
+	defer objs.Close()
+
+	// Hand the loader record to our parent, which outlives the test.
+	info, err := g.loaderInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	info.Pid = uint32(os.Getppid())
+	if info.Start, err = procStartTime(os.Getppid()); err != nil {
+		t.Fatal(err)
+	}
+	m, err := g.OpenPinnedMap(LoaderMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var key uint32
+	err = m.Update(&key, &info, ebpf.UpdateAny)
+	m.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		policy   string
+		features Features
+		attach   bool
+	}{
+		{"round-robin", DefaultFeatures, true},
+		{"jsq", DefaultFeatures, false},
+		{"round-robin", DefaultFeatures | FeatureOverride, false},
+		{"round-robin", 0, false},
+	} {
+		objs, attached, err := g.LoadOrAttachPolicy(tc.policy, false, tc.features)
+		if tc.attach {
+			if err != nil || !attached {
+				t.Errorf("%s features=%s: attached %v, %v; want attached", tc.policy, tc.features, attached, err)
+			} else {
+				objs.Close()
+			}
+			continue
+		}
+		if err == nil {
+			objs.Close()
+		}
+		if !errors.Is(err, ErrLoaderRunning) || attached {
+			t.Errorf("%s features=%s: attached %v, %v; want ErrLoaderRunning", tc.policy, tc.features, attached, err)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package reuseportlb
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cilium/ebpf"
+)
+
+// PolicyParam is a tunable a selector reads from policy_cfg (see
+// eBPF/policycfg.h) instead of having it compiled in.
+type PolicyParam struct {
+	Name string
+	// Index is the parameter's key in policy_cfg, its value in the
+	// policy's param enum in eBPF/*.c.
+	Index   uint32
+	Default uint64
+	Min     uint64
+	Max     uint64
+	Usage   string
+}
+
+// policyParams lists the parameters of each policy that has any.
+var policyParams = map[string][]PolicyParam{
+	"round-robin": {
+		{Name: "group_size", Index: 0, Default: 4, Min: 1, Max: 128, Usage: "slots the counter rotates through, from slot 0"},
+		{Name: "per_cpu", Index: 1, Default: 0, Min: 0, Max: 1, Usage: "1 rotates a counter per CPU, each starting at a different slot, instead of one shared by all CPUs"},
+	},
+	"acceptqueue": {
+		{Name: "slots", Index: 0, Default: 4, Min: 1, Max: 128, Usage: "slots whose accept queues are compared, from slot 0"},
+	},
+	"memguard": {
+		{Name: "mem_limit_mb", Index: 0, Default: 1024, Min: 1, Max: 1 << 30, Usage: "memory use, in MiB, at which a slot stops taking connections"},
+		{Name: "pressure_pct", Index: 1, Default: 10, Min: 1, Max: 100, Usage: "memory.pressure some avg10 at which a slot stops taking connections"},
+	},
+	"gcaware": {
+		{Name: "heap_pct", Index: 0, Default: 90, Min: 1, Max: 1000, Usage: "heap size, in percent of the GC goal, at which a slot counts as in or near a GC cycle"},
+		{Name: "sched_p99_us", Index: 1, Default: 10000, Min: 1, Max: 10000000, Usage: "scheduling latency p99, in microseconds, at which a slot stops taking connections"},
+	},
+	"healthscore": {
+		{Name: "unknown_score", Index: 0, Default: 50, Min: 1, Max: 100, Usage: "health score of slots that publish none, or none in the last second"},
+	},
+	"psi": {
+		{Name: "stall_pct", Index: 0, Default: 20, Min: 1, Max: 100, Usage: "share of the time, in percent, a slot's cgroup may stall waiting for a CPU before it stops taking connections"},
+	},
+	"energy": {
+		{Name: "pack_pct", Index: 0, Default: 60, Min: 1, Max: 100, Usage: "utilization, in percent, up to which connections are packed onto the lowest slot before the next one takes any"},
+	},
+}
+
+// PolicyParams returns the parameters policy reads from policy_cfg, sorted
+// by name. Policies without any return nil.
+func PolicyParams(policy string) []PolicyParam {
+	params := append([]PolicyParam(nil), policyParams[policy]...)
+	sort.Slice(params, func(i, j int) bool { return params[i].Name < params[j].Name })
+	return params
+}
+
+func lookupParam(policy, name string) (PolicyParam, error) {
+	for _, p := range policyParams[policy] {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	var names []string
+	for _, p := range PolicyParams(policy) {
+		names = append(names, p.Name)
+	}
+	if len(names) == 0 {
+		return PolicyParam{}, fmt.Errorf("policy %s has no parameters", policy)
+	}
+	return PolicyParam{}, fmt.Errorf("policy %s has no parameter %q (have %s)", policy, name, strings.Join(names, ", "))
+}
+
+// ParseParams parses comma-separated name=value pairs, such as
+// "group_size=8". Names are checked against a policy by SetParams.
+func ParseParams(s string) (map[string]uint64, error) {
+	values := make(map[string]uint64)
+	if s == "" {
+		return values, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		name, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("parameter %q: want name=value", pair)
+		}
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %q: %v", pair, err)
+		}
+		values[name] = n
+	}
+	return values, nil
+}
+
+// SetParams replaces the group's policy_cfg: the parameters in values are
+// set and every other one goes back to its default. It applies to the next
+// connection. It does nothing for a policy without parameters.
+func (g Group) SetParams(policy string, values map[string]uint64) error {
+	if len(policyParams[policy]) == 0 && len(values) == 0 {
+		return nil
+	}
+	cfg := make([]uint64, mapLayouts[PolicyCfgMap].MaxEntries)
+	for name, v := range values {
+		p, err := lookupParam(policy, name)
+		if err != nil {
+			return err
+		}
+		if v < p.Min || v > p.Max {
+			return fmt.Errorf("%s %d out of range [%d, %d]", name, v, p.Min, p.Max)
+		}
+		cfg[p.Index] = v
+	}
+	m, err := g.openAudited(PolicyCfgMap)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	for i, v := range cfg {
+		k := uint32(i)
+		if err := m.Update(&k, &v, ebpf.UpdateAny); err != nil {
+			return fmt.Errorf("write %s: %w", PolicyCfgMap, err)
+		}
+	}
+	return nil
+}
+
+// SetParam changes one parameter of the group's policy, leaving the others.
+func (g Group) SetParam(policy, name string, v uint64) error {
+	p, err := lookupParam(policy, name)
+	if err != nil {
+		return err
+	}
+	if v < p.Min || v > p.Max {
+		return fmt.Errorf("%s %d out of range [%d, %d]", name, v, p.Min, p.Max)
+	}
+	m, err := g.openAudited(PolicyCfgMap)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	if err := m.Update(&p.Index, &v, ebpf.UpdateAny); err != nil {
+		return fmt.Errorf("write %s: %w", PolicyCfgMap, err)
+	}
+	return nil
+}
+
+// Params reads the value in effect of every parameter of policy: what
+// policy_cfg holds, or the default where it holds 0.
+func (g Group) Params(policy string) (map[string]uint64, error) {
+	params := policyParams[policy]
+	values := make(map[string]uint64, len(params))
+	if len(params) == 0 {
+		return values, nil
+	}
+	m, err := g.openAudited(PolicyCfgMap)
+	if err != nil {
+		return nil, err
+	}
+	defer m.Close()
+	for _, p := range params {
+		var v uint64
+		if err := m.Lookup(&p.Index, &v); err != nil {
+			return nil, fmt.Errorf("read %s: %w", PolicyCfgMap, err)
+		}
+		if v == 0 {
+			v = p.Default
+		}
+		values[p.Name] = v
+	}
+	return values, nil
+}
+
+// ParamsHandler returns an admin handler for the parameters of the group's
+// policy. GET reports their values; POST with name=value form values
+// changes them live.
+func (g Group) ParamsHandler(policy string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			if err := r.ParseForm(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			for name := range r.PostForm {
+				v, err := strconv.ParseUint(r.PostForm.Get(name), 10, 64)
+				if err != nil {
+					http.Error(w, fmt.Sprintf("invalid %s: %v", name, err), http.StatusBadRequest)
+					return
+				}
+				if err := g.SetParam(policy, name, v); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		values, err := g.Params(policy)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(values)
+	}
+}
@@ -1,7 +1,7 @@
 // Code generated by bpf2go; DO NOT EDIT.
 //go:build mips || mips64 || ppc64 || s390x
 
-package main
+package reuseportlb
 
 import (
 	"bytes"
@@ -12,6 +12,45 @@ import (
 	"github.com/cilium/ebpf"
 )
 
+type pickfirstRatelimitCfg struct {
+	Enabled     uint32
+	Action      uint32
+	PenaltySlot uint32
+	MaxConns    uint32
+	WindowNs    uint64
+}
+
+type pickfirstShadowState struct {
+	Phase     uint32
+	Candidate uint32
+}
+
+type pickfirstSlotBucket struct {
+	Rate      uint64
+	Burst     uint64
+	Action    uint32
+	Pad       uint32
+	Tokens    uint64
+	LastNs    uint64
+	Throttled uint64
+}
+
+type pickfirstSlotOverride struct {
+	Slot uint32
+	Hits uint32
+}
+
+type pickfirstSlotWarmup struct {
+	StartNs  uint64
+	WindowNs uint64
+}
+
+type pickfirstSrcRate struct {
+	WindowStart uint64
+	Count       uint32
+	Limited     uint32
+}
+
 // loadPickfirst returns the embedded CollectionSpec for pickfirst.
 func loadPickfirst() (*ebpf.CollectionSpec, error) {
 	reader := bytes.NewReader(_PickfirstBytes)
@@ -60,6 +99,16 @@ type pickfirstProgramSpecs struct {
 //
 // It can be passed ebpf.CollectionSpec.Assign.
 type pickfirstMapSpecs struct {
+	RatelimitCfg        *ebpf.MapSpec `ebpf:"ratelimit_cfg"`
+	ShadowEvents        *ebpf.MapSpec `ebpf:"shadow_events"`
+	ShadowProgs         *ebpf.MapSpec `ebpf:"shadow_progs"`
+	ShadowScratch       *ebpf.MapSpec `ebpf:"shadow_scratch"`
+	SlotBucket          *ebpf.MapSpec `ebpf:"slot_bucket"`
+	SlotDropping        *ebpf.MapSpec `ebpf:"slot_dropping"`
+	SlotOverride        *ebpf.MapSpec `ebpf:"slot_override"`
+	SlotSelected        *ebpf.MapSpec `ebpf:"slot_selected"`
+	SlotWarmup          *ebpf.MapSpec `ebpf:"slot_warmup"`
+	SrcRate             *ebpf.MapSpec `ebpf:"src_rate"`
 	TcpBalancingTargets *ebpf.MapSpec `ebpf:"tcp_balancing_targets"`
 }
 
@@ -82,11 +131,31 @@ func (o *pickfirstObjects) Close() error {
 //
 // It can be passed to loadPickfirstObjects or ebpf.CollectionSpec.LoadAndAssign.
 type pickfirstMaps struct {
+	RatelimitCfg        *ebpf.Map `ebpf:"ratelimit_cfg"`
+	ShadowEvents        *ebpf.Map `ebpf:"shadow_events"`
+	ShadowProgs         *ebpf.Map `ebpf:"shadow_progs"`
+	ShadowScratch       *ebpf.Map `ebpf:"shadow_scratch"`
+	SlotBucket          *ebpf.Map `ebpf:"slot_bucket"`
+	SlotDropping        *ebpf.Map `ebpf:"slot_dropping"`
+	SlotOverride        *ebpf.Map `ebpf:"slot_override"`
+	SlotSelected        *ebpf.Map `ebpf:"slot_selected"`
+	SlotWarmup          *ebpf.Map `ebpf:"slot_warmup"`
+	SrcRate             *ebpf.Map `ebpf:"src_rate"`
 	TcpBalancingTargets *ebpf.Map `ebpf:"tcp_balancing_targets"`
 }
 
 func (m *pickfirstMaps) Close() error {
 	return _PickfirstClose(
+		m.RatelimitCfg,
+		m.ShadowEvents,
+		m.ShadowProgs,
+		m.ShadowScratch,
+		m.SlotBucket,
+		m.SlotDropping,
+		m.SlotOverride,
+		m.SlotSelected,
+		m.SlotWarmup,
+		m.SrcRate,
 		m.TcpBalancingTargets,
 	)
 }
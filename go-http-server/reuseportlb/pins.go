@@ -0,0 +1,157 @@
+package reuseportlb
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/cilium/ebpf"
+)
+
+// PinProblem is a pin CheckPins found unusable or abandoned.
+type PinProblem struct {
+	Group  Group  `json:"group"`
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+	// Idle marks a group that is intact but has neither a live socket, a
+	// live instance nor a live loader. Collectors may legitimately set maps
+	// up before any server starts, so only an explicit gc removes idle
+	// groups.
+	Idle bool `json:"idle,omitempty"`
+}
+
+// CheckPins looks for leftovers of crashed or incompatible runs in the
+// group's pin directory: maps whose spec differs from the one this build
+// expects (which programs would otherwise fail to reuse with an unhelpful
+// EINVAL), pins that are not what their name says, and groups nobody uses
+// any more. DefaultGroup also checks the host-wide maps.
+func (g Group) CheckPins() ([]PinProblem, error) {
+	var problems []PinProblem
+	for name := range mapLayouts {
+		if globalMaps[name] && g != DefaultGroup {
+			continue
+		}
+		path := g.PinnedMapPath(name)
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		m, err := ebpf.LoadPinnedMap(path, nil)
+		if err != nil {
+			problems = append(problems, PinProblem{Group: g, Path: path, Reason: fmt.Sprintf("not a loadable map: %v", err)})
+			continue
+		}
+		if err := CheckMap(name, m); err != nil {
+			problems = append(problems, PinProblem{Group: g, Path: path, Reason: err.Error()})
+		}
+		m.Close()
+	}
+	for _, path := range append([]string{g.ProgramPath()}, g.stagePaths()...) {
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		prog, err := ebpf.LoadPinnedProgram(path, nil)
+		if err != nil {
+			problems = append(problems, PinProblem{Group: g, Path: path, Reason: fmt.Sprintf("not a loadable program: %v", err)})
+			continue
+		}
+		prog.Close()
+	}
+	if len(problems) > 0 {
+		return problems, nil
+	}
+
+	sockets, err := g.liveSockets()
+	if err != nil || sockets > 0 {
+		return nil, err
+	}
+	instances := 0
+	if m, err := g.OpenPinnedMap(InstancesMap); err == nil {
+		instances, err = liveInstances(m)
+		m.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	loader, err := g.Loader()
+	if err != nil {
+		return nil, err
+	}
+	if instances == 0 && loader == 0 {
+		problems = append(problems, PinProblem{Group: g, Path: g.PinDir(), Reason: "no live sockets or instances", Idle: true})
+	}
+	return problems, nil
+}
+
+// liveSockets counts the sockets in the group's tcp_balancing_targets. The
+// kernel drops a socket from the map when it is closed, so unlike
+// acceptq_slot_cookies this is never stale. A group without the map has
+// none.
+func (g Group) liveSockets() (int, error) {
+	m, err := g.OpenPinnedMap(TargetsMap)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer m.Close()
+	n := 0
+	for slot := uint32(0); slot < m.MaxEntries(); slot++ {
+		var cookie uint64
+		if err := m.Lookup(&slot, &cookie); err == nil {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// GC removes the group's stale pins, as reported by CheckPins, under the
+// pin lock. Idle groups are only unpinned with idle set; with dryRun
+// nothing is removed. It returns the problems found.
+func (g Group) GC(idle, dryRun bool) ([]PinProblem, error) {
+	unlock, err := g.lockPins()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	problems, err := g.CheckPins()
+	if err != nil || dryRun {
+		return problems, err
+	}
+	return problems, removeStalePins(problems, idle)
+}
+
+// removeStalePins removes what CheckPins reported: the broken pins one by
+// one and, with idle set, idle groups as a whole.
+func removeStalePins(problems []PinProblem, idle bool) error {
+	var errs []error
+	for _, p := range problems {
+		if p.Idle {
+			if idle {
+				errs = append(errs, p.Group.Unpin())
+			}
+			continue
+		}
+		if err := os.Remove(p.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// repairPins is the startup check run before a policy is loaded: it removes
+// the group's broken pins so they are recreated, logging each, and leaves
+// idle groups alone. The caller holds the pin lock.
+func (g Group) repairPins() error {
+	problems, err := g.CheckPins()
+	if err != nil {
+		return err
+	}
+	for _, p := range problems {
+		if !p.Idle {
+			slog.Warn("Removing stale pin", "group", g.String(), "path", p.Path, "reason", p.Reason)
+		}
+	}
+	return removeStalePins(problems, false)
+}
@@ -0,0 +1,417 @@
+package reuseportlb
+
+// The selectors and fentry programs never read pt_regs, so bpf2go's default
+// bpfel and bpfeb objects serve every architecture: arm64 and x86_64 both
+// load the bpfel ones. Only the acceptq kprobe is built per architecture;
+// see the Makefile.
+//go:generate go run github.com/cilium/ebpf/cmd/bpf2go reuseportlb eBPF/reuseportlb.c
+//go:generate go run github.com/cilium/ebpf/cmd/bpf2go pickfirst eBPF/pickfirst.c
+//go:generate go run github.com/cilium/ebpf/cmd/bpf2go -cflags "-mcpu=v3" -type rr_state roundrobin eBPF/roundrobin.c
+//go:generate go run github.com/cilium/ebpf/cmd/bpf2go cpuutil eBPF/cpuutil.c
+//go:generate go run github.com/cilium/ebpf/cmd/bpf2go -type acceptq acceptqueue eBPF/acceptqueue.c
+//go:generate go run github.com/cilium/ebpf/cmd/bpf2go -cflags "-mcpu=v3" chain eBPF/chain.c
+//go:generate go run github.com/cilium/ebpf/cmd/bpf2go -cflags "-mcpu=v3" splitter eBPF/splitter.c
+//go:generate go run github.com/cilium/ebpf/cmd/bpf2go steer eBPF/steer.c
+//go:generate go run github.com/cilium/ebpf/cmd/bpf2go hotstandby eBPF/hotstandby.c
+//go:generate go run github.com/cilium/ebpf/cmd/bpf2go spillover eBPF/spillover.c
+//go:generate go run github.com/cilium/ebpf/cmd/bpf2go jsq eBPF/jsq.c
+//go:generate go run github.com/cilium/ebpf/cmd/bpf2go latency eBPF/latency.c
+//go:generate go run github.com/cilium/ebpf/cmd/bpf2go drops eBPF/drops.c
+//go:generate go run github.com/cilium/ebpf/cmd/bpf2go slottag eBPF/slottag.c
+//go:generate go run github.com/cilium/ebpf/cmd/bpf2go splice eBPF/splice.c
+//go:generate go run github.com/cilium/ebpf/cmd/bpf2go -cflags "-mcpu=v3" -type xlb_cfg -type xlb_backend -type xlb_flow xdplb eBPF/xdplb.c
+//go:generate go run github.com/cilium/ebpf/cmd/bpf2go -type reqcpu_task reqcpu eBPF/reqcpu.c
+//go:generate go run github.com/cilium/ebpf/cmd/bpf2go memguard eBPF/memguard.c
+//go:generate go run github.com/cilium/ebpf/cmd/bpf2go gcaware eBPF/gcaware.c
+//go:generate go run github.com/cilium/ebpf/cmd/bpf2go healthscore eBPF/healthscore.c
+//go:generate go run github.com/cilium/ebpf/cmd/bpf2go psi eBPF/psi.c
+//go:generate go run github.com/cilium/ebpf/cmd/bpf2go energy eBPF/energy.c
+//go:generate go run github.com/cilium/ebpf/cmd/bpf2go connstats eBPF/connstats.c
+//go:generate go run github.com/cilium/ebpf/cmd/bpf2go netdistress eBPF/netdistress.c
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/cilium/ebpf"
+)
+
+// Map values shared with the eBPF programs. These are generated by bpf2go from
+// the C definitions, so the Go side cannot drift from the kernel layout.
+type (
+	// AcceptqEntry is a value in acceptq_map (struct acceptq).
+	AcceptqEntry = acceptqueueAcceptq
+	// RRState is the single value in the round-robin rr map (struct rr_state).
+	RRState = roundrobinRrState
+	// SlotOwner is a value in slot_owner (struct slot_owner).
+	SlotOwner = cpuutilSlotOwner
+	// SlotMem is a value in slot_mem (struct slot_mem).
+	SlotMem = memguardSlotMem
+	// SlotRuntime is a value in slot_runtime (struct slot_runtime).
+	SlotRuntime = gcawareSlotRuntime
+	// SlotHealth is a value in slot_health (struct slot_health).
+	SlotHealth = healthscoreSlotHealth
+	// SlotPSI is a value in slot_psi (struct slot_psi).
+	SlotPSI = psiSlotPsi
+	// rateLimitCfg, srcRate, slotBucket, slotOverride and slotWarmup come from
+	// eBPF/ratelimit.h, which every selector includes; any object's copy will do.
+	rateLimitCfg = pickfirstRatelimitCfg
+	srcRate      = pickfirstSrcRate
+	slotBucket   = pickfirstSlotBucket
+	slotOverride = pickfirstSlotOverride
+	slotWarmup   = pickfirstSlotWarmup
+)
+
+// LoadedObjects is the policy-independent view of a loaded selector program
+// and the sockarray it selects from.
+type LoadedObjects struct {
+	Program *ebpf.Program
+	Map     *ebpf.Map
+	Close   func() error
+	// SelectOrMigrate is set when Program was loaded as
+	// BPF_SK_REUSEPORT_SELECT_OR_MIGRATE and so also places requests
+	// migrated off a closing listener.
+	SelectOrMigrate bool
+	// Features are what Program was loaded with, when this process knows.
+	Features Features
+
+	// stages are the chain policy's stage programs by stage name.
+	stages map[string]*ebpf.Program
+	// lookup is the steer policy's sk_lookup program.
+	lookup *ebpf.Program
+	// sockops is the jsq policy's queue depth tracker.
+	sockops *ebpf.Program
+}
+
+// LoadPolicy loads the eBPF objects for the named policy, pinning its maps
+// in the group's pin directory so that later instances can register their
+// sockets. With migrate set, the selector is loaded as a migration-aware
+// program when the kernel supports it. The selector only has the features
+// given (see Features).
+func (g Group) LoadPolicy(policy string, migrate bool, features Features) (LoadedObjects, error) {
+	selectOrMigrate := false
+	if migrate {
+		ok, err := HaveSelectOrMigrate()
+		if err != nil {
+			slog.Warn("Migration-aware selector unavailable", "err", err)
+		}
+		selectOrMigrate = ok
+	}
+
+	opts, closeGlobals, err := g.collectionOptions()
+	if err != nil {
+		return LoadedObjects{}, err
+	}
+	defer closeGlobals()
+
+	objs, err := loadPolicyObjects(policy, opts, selectOrMigrate, features)
+	objs.SelectOrMigrate = selectOrMigrate && err == nil
+	if err == nil {
+		objs.Features = features
+	}
+	if errors.Is(err, ebpf.ErrMapIncompatible) {
+		return LoadedObjects{}, fmt.Errorf("%w: policy %q does not match the maps pinned under %s (left over from another build?): %v",
+			ErrLayoutMismatch, policy, g.PinDir(), err)
+	}
+	if err == nil && objs.stages != nil {
+		if err := g.installChain(objs.stages); err != nil {
+			objs.Close()
+			return LoadedObjects{}, err
+		}
+	}
+	if err == nil && objs.lookup != nil {
+		if err := g.attachSteerLookup(objs.lookup); err != nil {
+			objs.Close()
+			return LoadedObjects{}, err
+		}
+	}
+	if err == nil && objs.sockops != nil {
+		if err := g.attachJSQSockops(objs.sockops); err != nil {
+			objs.Close()
+			return LoadedObjects{}, err
+		}
+	}
+	return objs, err
+}
+
+// collectionOptions pins the group's maps in its own directory and hands
+// the programs the host-wide maps from PinPath instead. The returned func
+// releases this process's references to the host-wide maps once loaded.
+func (g Group) collectionOptions() (*ebpf.CollectionOptions, func(), error) {
+	if err := g.ensurePinDir(); err != nil {
+		return nil, nil, err
+	}
+	opts := &ebpf.CollectionOptions{Maps: ebpf.MapOptions{PinPath: g.PinDir()}}
+	if g == DefaultGroup {
+		return opts, func() {}, nil
+	}
+
+	opts.MapReplacements = make(map[string]*ebpf.Map)
+	for name := range globalMaps {
+		if name == LayoutMap {
+			continue
+		}
+		m, err := g.OpenOrCreatePinnedMap(name)
+		if err != nil {
+			for _, m := range opts.MapReplacements {
+				m.Close()
+			}
+			return nil, nil, err
+		}
+		opts.MapReplacements[name] = m
+	}
+	return opts, func() {
+		for _, m := range opts.MapReplacements {
+			m.Close()
+		}
+	}, nil
+}
+
+// loadObjects loads a bpf2go selector collection into obj with features,
+// switching its sk_reuseport programs to the select-or-migrate attach type
+// when asked to.
+func loadObjects(load func() (*ebpf.CollectionSpec, error), obj any, opts *ebpf.CollectionOptions, selectOrMigrate bool, features Features) error {
+	spec, err := load()
+	if err != nil {
+		return err
+	}
+	if err := spec.RewriteConstants(map[string]interface{}{"sl_features": uint32(features)}); err != nil {
+		return fmt.Errorf("set selector features: %w", err)
+	}
+	if selectOrMigrate {
+		for _, p := range spec.Programs {
+			if p.Type == ebpf.SkReuseport {
+				p.AttachType = ebpf.AttachSkReuseportSelectOrMigrate
+			}
+		}
+	}
+	if len(opts.MapReplacements) > 0 {
+		// Replacements must name maps the object actually has.
+		o := *opts
+		o.MapReplacements = make(map[string]*ebpf.Map)
+		for name, m := range opts.MapReplacements {
+			if _, ok := spec.Maps[name]; ok {
+				o.MapReplacements[name] = m
+			}
+		}
+		opts = &o
+	}
+	return kernelFeature(spec.LoadAndAssign(obj, opts))
+}
+
+func loadPolicyObjects(policy string, opts *ebpf.CollectionOptions, selectOrMigrate bool, features Features) (LoadedObjects, error) {
+	switch policy {
+
+	case "cpuutil":
+		var objs cpuutilObjects
+		if err := loadObjects(loadCpuutil, &objs, opts, selectOrMigrate, features); err != nil {
+			return LoadedObjects{}, err
+		}
+		return LoadedObjects{
+			Program: objs.cpuutilPrograms.CpuutilSelector,
+			Map:     objs.cpuutilMaps.TcpBalancingTargets,
+			Close:   objs.Close,
+		}, nil
+
+	case "acceptqueue":
+		var objs acceptqueueObjects
+		if err := loadObjects(loadAcceptqueue, &objs, opts, selectOrMigrate, features); err != nil {
+			return LoadedObjects{}, err
+		}
+		return LoadedObjects{
+			Program: objs.acceptqueuePrograms.AcceptqSelector,
+			Map:     objs.acceptqueueMaps.TcpBalancingTargets,
+			Close:   objs.Close,
+		}, nil
+
+	case "round-robin":
+		var objs roundrobinObjects
+		if err := loadObjects(loadRoundrobin, &objs, opts, selectOrMigrate, features); err != nil {
+			return LoadedObjects{}, err
+		}
+
+		k := uint32(0)
+		s := RRState{Counter: 0}
+		if err := objs.roundrobinMaps.Rr.Update(&k, &s, ebpf.UpdateAny); err != nil {
+			objs.Close()
+			return LoadedObjects{}, fmt.Errorf("reset %s: %w", RRStateMap, err)
+		}
+
+		slog.Info("Reset round robin state", "key", k, "counter", s.Counter)
+
+		return LoadedObjects{
+			Program: objs.roundrobinPrograms.RrSelector,
+			Map:     objs.roundrobinMaps.TcpBalancingTargets, // sockarray to be filled per-instance
+			Close:   objs.Close,
+		}, nil
+
+	case "pickfirst":
+		var objs pickfirstObjects
+		if err := loadObjects(loadPickfirst, &objs, opts, selectOrMigrate, features); err != nil {
+			return LoadedObjects{}, err
+		}
+		return LoadedObjects{
+			Program: objs.pickfirstPrograms.Pickfirst,
+			Map:     objs.pickfirstMaps.TcpBalancingTargets,
+			Close:   objs.Close,
+		}, nil
+
+	case "chain":
+		var objs chainObjects
+		if err := loadObjects(loadChain, &objs, opts, selectOrMigrate, features); err != nil {
+			return LoadedObjects{}, err
+		}
+		p := objs.chainPrograms
+		return LoadedObjects{
+			Program: p.ChainEntry,
+			Map:     objs.chainMaps.TcpBalancingTargets,
+			Close:   objs.Close,
+			stages: map[string]*ebpf.Program{
+				StageExcludeDraining:   p.ChainExcludeDraining,
+				StageExcludeOverloaded: p.ChainExcludeOverloaded,
+				StageSlowSYN:           p.ChainSlowSyn,
+				StageRoundRobin:        p.ChainRoundRobin,
+				StageFirst:             p.ChainFirst,
+			},
+		}, nil
+
+	case "splitter":
+		var objs splitterObjects
+		if err := loadObjects(loadSplitter, &objs, opts, selectOrMigrate, features); err != nil {
+			return LoadedObjects{}, err
+		}
+		return LoadedObjects{
+			Program: objs.splitterPrograms.Splitter,
+			Map:     objs.splitterMaps.TcpBalancingTargets,
+			Close:   objs.Close,
+		}, nil
+
+	case "steer":
+		var objs steerObjects
+		if err := loadObjects(loadSteer, &objs, opts, selectOrMigrate, features); err != nil {
+			return LoadedObjects{}, err
+		}
+		return LoadedObjects{
+			Program: objs.steerPrograms.SteerSelector,
+			Map:     objs.steerMaps.TcpBalancingTargets,
+			Close:   objs.Close,
+			lookup:  objs.steerPrograms.SteerLookup,
+		}, nil
+
+	case "hot-standby":
+		var objs hotstandbyObjects
+		if err := loadObjects(loadHotstandby, &objs, opts, selectOrMigrate, features); err != nil {
+			return LoadedObjects{}, err
+		}
+		return LoadedObjects{
+			Program: objs.hotstandbyPrograms.HotStandby,
+			Map:     objs.hotstandbyMaps.TcpBalancingTargets,
+			Close:   objs.Close,
+		}, nil
+
+	case "spillover":
+		var objs spilloverObjects
+		if err := loadObjects(loadSpillover, &objs, opts, selectOrMigrate, features); err != nil {
+			return LoadedObjects{}, err
+		}
+		return LoadedObjects{
+			Program: objs.spilloverPrograms.Spillover,
+			Map:     objs.spilloverMaps.TcpBalancingTargets,
+			Close:   objs.Close,
+		}, nil
+
+	case "jsq":
+		var objs jsqObjects
+		if err := loadObjects(loadJsq, &objs, opts, selectOrMigrate, features); err != nil {
+			return LoadedObjects{}, err
+		}
+		return LoadedObjects{
+			Program: objs.jsqPrograms.JsqSelector,
+			Map:     objs.jsqMaps.TcpBalancingTargets,
+			Close:   objs.Close,
+			sockops: objs.jsqPrograms.JsqSockops,
+		}, nil
+
+	case "memguard":
+		var objs memguardObjects
+		if err := loadObjects(loadMemguard, &objs, opts, selectOrMigrate, features); err != nil {
+			return LoadedObjects{}, err
+		}
+		return LoadedObjects{
+			Program: objs.memguardPrograms.MemguardSelector,
+			Map:     objs.memguardMaps.TcpBalancingTargets,
+			Close:   objs.Close,
+		}, nil
+
+	case "gcaware":
+		var objs gcawareObjects
+		if err := loadObjects(loadGcaware, &objs, opts, selectOrMigrate, features); err != nil {
+			return LoadedObjects{}, err
+		}
+		return LoadedObjects{
+			Program: objs.gcawarePrograms.GcawareSelector,
+			Map:     objs.gcawareMaps.TcpBalancingTargets,
+			Close:   objs.Close,
+		}, nil
+
+	case "healthscore":
+		var objs healthscoreObjects
+		if err := loadObjects(loadHealthscore, &objs, opts, selectOrMigrate, features); err != nil {
+			return LoadedObjects{}, err
+		}
+		return LoadedObjects{
+			Program: objs.healthscorePrograms.HealthscoreSelector,
+			Map:     objs.healthscoreMaps.TcpBalancingTargets,
+			Close:   objs.Close,
+		}, nil
+
+	case "psi":
+		var objs psiObjects
+		if err := loadObjects(loadPsi, &objs, opts, selectOrMigrate, features); err != nil {
+			return LoadedObjects{}, err
+		}
+		return LoadedObjects{
+			Program: objs.psiPrograms.PsiSelector,
+			Map:     objs.psiMaps.TcpBalancingTargets,
+			Close:   objs.Close,
+		}, nil
+
+	case "energy":
+		var objs energyObjects
+		if err := loadObjects(loadEnergy, &objs, opts, selectOrMigrate, features); err != nil {
+			return LoadedObjects{}, err
+		}
+		return LoadedObjects{
+			Program: objs.energyPrograms.EnergySelector,
+			Map:     objs.energyMaps.TcpBalancingTargets,
+			Close:   objs.Close,
+		}, nil
+
+	case "agent":
+		// Placeholder for agent policy, implement as needed
+		return LoadedObjects{}, fmt.Errorf("%w: agent is not implemented", ErrPolicyUnsupported)
+	}
+	return LoadedObjects{}, fmt.Errorf("%w %q (have %s)", ErrPolicyUnsupported, policy, strings.Join(Policies, ", "))
+}
+
+// RoundRobinPosition returns the number of selections the round-robin policy
+// has made so far, read from the pinned rr map. The slot that will be tried
+// first for the next connection is the position modulo the group size.
+// With per_cpu set the CPUs keep counters of their own and the position
+// stays where it was.
+func (g Group) RoundRobinPosition() (uint64, error) {
+	m, err := g.OpenPinnedMap(RRStateMap)
+	if err != nil {
+		return 0, err
+	}
+	defer m.Close()
+
+	var k uint32
+	var s RRState
+	if err := m.Lookup(&k, &s); err != nil {
+		return 0, fmt.Errorf("read round robin state: %w", err)
+	}
+	return s.Counter, nil
+}
@@ -0,0 +1,90 @@
+package reuseportlb
+
+import (
+	"os"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/rlimit"
+)
+
+// requireBPF skips t unless it can load BPF programs, and returns a scratch
+// directory on bpffs for their pins.
+func requireBPF(t *testing.T) string {
+	t.Helper()
+	if os.Geteuid() != 0 {
+		t.Skip("loading BPF programs needs root")
+	}
+	if err := EnsureBpffs(); err != nil {
+		t.Skip(err)
+	}
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := os.MkdirTemp(PinPath, "reuseportlb-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// TestPoliciesLoad has the verifier check every selector with the least
+// and the most of ratelimit.h compiled in, for both attach types.
+func TestPoliciesLoad(t *testing.T) {
+	dir := requireBPF(t)
+	for _, policy := range Policies {
+		for _, features := range []Features{0, AllFeatures} {
+			for _, som := range []bool{false, true} {
+				sub, err := os.MkdirTemp(dir, policy)
+				if err != nil {
+					t.Fatal(err)
+				}
+				objs, err := loadPolicyObjects(policy, &ebpf.CollectionOptions{Maps: ebpf.MapOptions{PinPath: sub}}, som, features)
+				if err != nil {
+					t.Errorf("%s features=%s select-or-migrate=%v: %v", policy, features, som, err)
+					continue
+				}
+				objs.Close()
+			}
+		}
+	}
+}
+
+// TestObjectsLoad loads the programs that are not selectors. Tracing
+// programs are left out: the kernel checks their attach target against
+// ftrace on load, which not every test machine's kernel is built with.
+func TestObjectsLoad(t *testing.T) {
+	dir := requireBPF(t)
+	for name, load := range map[string]func() (*ebpf.CollectionSpec, error){
+		"connstats":   loadConnstats,
+		"drops":       loadDrops,
+		"latency":     loadLatency,
+		"netdistress": loadNetdistress,
+		"reqcpu":      loadReqcpu,
+		"reuseportlb": loadReuseportlb,
+		"slottag":     loadSlottag,
+		"splice":      loadSplice,
+		"xdplb":       loadXdplb,
+	} {
+		spec, err := load()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		for prog, ps := range spec.Programs {
+			if ps.Type == ebpf.Tracing {
+				delete(spec.Programs, prog)
+			}
+		}
+		sub, err := os.MkdirTemp(dir, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		coll, err := ebpf.NewCollectionWithOptions(spec, ebpf.CollectionOptions{Maps: ebpf.MapOptions{PinPath: sub}})
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		coll.Close()
+	}
+}
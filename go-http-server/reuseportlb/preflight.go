@@ -0,0 +1,12 @@
+package reuseportlb
+
+import "strings"
+
+// PreflightError lists every privilege Preflight found missing.
+type PreflightError struct {
+	Problems []string
+}
+
+func (e *PreflightError) Error() string {
+	return "preflight failed:\n  " + strings.Join(e.Problems, "\n  ")
+}
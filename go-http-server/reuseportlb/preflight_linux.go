@@ -0,0 +1,82 @@
+package reuseportlb
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// Preflight checks up front that this process may do what running policy
+// needs, so that a missing privilege is reported by name instead of as an
+// EPERM from deep inside a map load or setsockopt. policy "default" needs
+// nothing; "lbd" (attaching a selector lbd pinned) and "" (collectors) only
+// need the pinned maps; every other policy also loads networking programs.
+// probes adds what the tracing programs (accept queue tracker, latency
+// probes, connection and network distress trackers) need.
+func Preflight(policy string, probes bool) error {
+	if policy == "default" && !probes {
+		return nil
+	}
+	caps, err := effectiveCaps()
+	if err != nil {
+		return fmt.Errorf("read capabilities: %w", err)
+	}
+	has := func(c int) bool { return caps&(1<<c) != 0 || caps&(1<<unix.CAP_SYS_ADMIN) != 0 }
+	fix := fmt.Sprintf("run as root, or grant it with setcap to %s", os.Args[0])
+
+	var problems []string
+	var statfs unix.Statfs_t
+	// Type is an int32 on 32-bit architectures, where the magic is negative.
+	if err := unix.Statfs(PinPath, &statfs); err != nil || uint32(statfs.Type) != unix.BPF_FS_MAGIC {
+		if !has(unix.CAP_SYS_ADMIN) {
+			problems = append(problems, fmt.Sprintf("bpffs is not mounted at %s and mounting it needs CAP_SYS_ADMIN: mount -t bpf bpffs %s, or run as root", PinPath, PinPath))
+		}
+	} else if err := unix.Access(PinPath, unix.W_OK); err != nil {
+		problems = append(problems, fmt.Sprintf("cannot pin under %s (%v): run as root or chown the bpffs mount", PinPath, err))
+	}
+
+	if !has(unix.CAP_BPF) {
+		msg := "missing CAP_BPF, needed for the pinned maps: " + fix
+		if v, err := os.ReadFile("/proc/sys/kernel/unprivileged_bpf_disabled"); err == nil && strings.TrimSpace(string(v)) != "0" {
+			msg += fmt.Sprintf(" (unprivileged BPF is disabled, kernel.unprivileged_bpf_disabled=%s)", strings.TrimSpace(string(v)))
+		}
+		if policy == "lbd" {
+			msg += "; servers can also register through lbd with -registry and need no privileges"
+		}
+		problems = append(problems, msg)
+	}
+	if policy != "" && policy != "lbd" && policy != "default" && !has(unix.CAP_NET_ADMIN) {
+		problems = append(problems, fmt.Sprintf("missing CAP_NET_ADMIN, needed to load the %s policy's sk_reuseport programs: %s", policy, fix))
+	}
+	if probes && !has(unix.CAP_PERFMON) {
+		problems = append(problems, "missing CAP_PERFMON, needed to attach the tracing programs: "+fix)
+	}
+
+	if len(problems) > 0 {
+		return &PreflightError{Problems: problems}
+	}
+	return nil
+}
+
+// effectiveCaps returns this process's effective capability set.
+func effectiveCaps() (uint64, error) {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if v, ok := strings.CutPrefix(scanner.Text(), "CapEff:"); ok {
+			return strconv.ParseUint(strings.TrimSpace(v), 16, 64)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("no CapEff in /proc/self/status")
+}
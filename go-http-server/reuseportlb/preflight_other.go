@@ -0,0 +1,12 @@
+//go:build !linux
+
+package reuseportlb
+
+// Preflight checks up front that this process may do what running policy
+// needs. Off Linux that is only the default policy without probes.
+func Preflight(policy string, probes bool) error {
+	if policy == "default" && !probes {
+		return nil
+	}
+	return &PreflightError{Problems: []string{errNotLinux.Error() + "; only the default policy runs here"}}
+}
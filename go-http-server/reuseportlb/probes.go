@@ -0,0 +1,132 @@
+package reuseportlb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cilium/ebpf"
+)
+
+// ServeHealthz is the liveness probe: it answers 200 for as long as the
+// process serves HTTP at all, and touches nothing else, so a supervisor
+// polling it often costs next to nothing.
+func ServeHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+// Readiness is the readiness probe of one instance. Each check reports what
+// the instance still lacks before connections steered to it are served, nil
+// once it has it; the probe runs them all on every request. Once Drain is
+// called the instance stays unready, so a supervisor stops routing to it
+// before its listener closes.
+type Readiness struct {
+	mu       sync.Mutex
+	names    []string
+	checks   map[string]func() error
+	draining atomic.Bool
+}
+
+// NewReadiness returns a probe without checks, which is ready until it
+// drains.
+func NewReadiness() *Readiness {
+	return &Readiness{checks: make(map[string]func() error)}
+}
+
+// Add registers check under name, replacing an earlier one of that name.
+func (rd *Readiness) Add(name string, check func() error) {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	if _, ok := rd.checks[name]; !ok {
+		rd.names = append(rd.names, name)
+	}
+	rd.checks[name] = check
+}
+
+// Drain marks the instance as shutting down.
+func (rd *Readiness) Drain() {
+	rd.draining.Store(true)
+}
+
+// Check runs every check and returns the failures by name.
+func (rd *Readiness) Check() map[string]error {
+	rd.mu.Lock()
+	names := append([]string(nil), rd.names...)
+	checks := make([]func() error, len(names))
+	for i, name := range names {
+		checks[i] = rd.checks[name]
+	}
+	rd.mu.Unlock()
+
+	failed := make(map[string]error)
+	if rd.draining.Load() {
+		failed["draining"] = errors.New("instance is draining")
+	}
+	for i, check := range checks {
+		if err := check(); err != nil {
+			failed[names[i]] = err
+		}
+	}
+	return failed
+}
+
+// ServeReadyz is an admin handler answering 200 when every check passes and
+// 503 otherwise, with each check's outcome as JSON.
+func (rd *Readiness) ServeReadyz(w http.ResponseWriter, r *http.Request) {
+	failed := rd.Check()
+	resp := struct {
+		Ready  bool              `json:"ready"`
+		Checks map[string]string `json:"checks"`
+	}{Ready: len(failed) == 0, Checks: make(map[string]string)}
+	rd.mu.Lock()
+	for _, name := range rd.names {
+		resp.Checks[name] = "ok"
+	}
+	rd.mu.Unlock()
+	for name, err := range failed {
+		resp.Checks[name] = err.Error()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !resp.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// CheckSelector reports whether the group's selector is loaded and pinned.
+func (g Group) CheckSelector() error {
+	prog, err := g.LoadPinnedProgram()
+	if err != nil {
+		return err
+	}
+	return prog.Close()
+}
+
+// CheckRegistered reports whether the socket cookie is the target of slot,
+// both in tcp_balancing_targets, where the selector picks it, and in the
+// slot cookie map. A listener that closed has left the sockarray.
+func (g Group) CheckRegistered(slot uint32, cookie uint64) error {
+	for _, name := range []string{TargetsMap, SlotCookiesMap} {
+		m, err := g.OpenPinnedMap(name)
+		if err != nil {
+			return err
+		}
+		// Looking up a sockarray entry yields the socket's cookie.
+		var current uint64
+		err = m.Lookup(&slot, &current)
+		m.Close()
+		switch {
+		case errors.Is(err, ebpf.ErrKeyNotExist) || err == nil && current == 0:
+			return fmt.Errorf("slot %d is empty in %s", slot, name)
+		case err != nil:
+			return fmt.Errorf("look up slot %d in %s: %w", slot, name, err)
+		case current != cookie:
+			return fmt.Errorf("slot %d holds cookie 0x%x in %s, not 0x%x", slot, current, name, cookie)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,246 @@
+package reuseportlb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cilium/ebpf"
+)
+
+// HostCPUPressure is the host's CPU pressure file.
+const HostCPUPressure = "/proc/pressure/cpu"
+
+// PSI is a pressure stall information file, such as /proc/pressure/cpu or
+// a cgroup's cpu.pressure, memory.pressure or io.pressure.
+type PSI struct {
+	// Some is the time at least one task was stalled on the resource.
+	Some PSILine
+	// Full is the time every task was; the kernel reports it for CPU only
+	// per cgroup, and from Linux 5.13 on for the host.
+	Full PSILine
+}
+
+// PSILine is one line of a PSI file.
+type PSILine struct {
+	// Avg10, Avg60 and Avg300 are the percentages of the last 10, 60 and
+	// 300 seconds spent stalled.
+	Avg10, Avg60, Avg300 float64
+	// Total is the time spent stalled since boot, or since the cgroup was
+	// made.
+	Total time.Duration
+}
+
+// ReadPSI parses the PSI file at path.
+func ReadPSI(path string) (PSI, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return PSI{}, err
+	}
+	var p PSI
+	var some bool
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		var l *PSILine
+		switch fields[0] {
+		case "some":
+			l, some = &p.Some, true
+		case "full":
+			l = &p.Full
+		default:
+			continue
+		}
+		for _, f := range fields[1:] {
+			k, v, _ := strings.Cut(f, "=")
+			var err error
+			switch k {
+			case "avg10":
+				l.Avg10, err = strconv.ParseFloat(v, 64)
+			case "avg60":
+				l.Avg60, err = strconv.ParseFloat(v, 64)
+			case "avg300":
+				l.Avg300, err = strconv.ParseFloat(v, 64)
+			case "total":
+				var us uint64
+				us, err = strconv.ParseUint(v, 10, 64)
+				l.Total = time.Duration(us) * time.Microsecond
+			}
+			if err != nil {
+				return PSI{}, fmt.Errorf("%s: %s: %w", path, k, err)
+			}
+		}
+	}
+	if !some {
+		return PSI{}, fmt.Errorf("%s: no some line", path)
+	}
+	return p, nil
+}
+
+// readPressureAvg10 returns the "some avg10" percentage of a PSI file such
+// as memory.pressure or /proc/pressure/cpu.
+func readPressureAvg10(path string) (float64, error) {
+	p, err := ReadPSI(path)
+	return p.Some.Avg10, err
+}
+
+// stallMeter turns successive readings of a PSI file's "some" total into
+// the share of the time between them spent stalled. The kernel's own
+// averages are over 10 seconds at the least, far too slow to steer
+// connections by; the totals give the share over any interval.
+type stallMeter struct {
+	path  string
+	total time.Duration
+	at    time.Time
+}
+
+// read returns the stalled share since the last read, in percent. ok is
+// false on the first read and when the file could not be read.
+func (m *stallMeter) read() (pct float64, ok bool) {
+	p, err := ReadPSI(m.path)
+	if err != nil {
+		m.at = time.Time{}
+		return 0, false
+	}
+	now := time.Now()
+	prev, prevAt := m.total, m.at
+	m.total, m.at = p.Some.Total, now
+	if prevAt.IsZero() || !now.After(prevAt) || p.Some.Total < prev {
+		return 0, false
+	}
+	return min(float64(p.Some.Total-prev)/float64(now.Sub(prevAt))*100, 100), true
+}
+
+// pressureSampler follows the CPU pressure of each slot owner's cgroup,
+// and of the host for owners without one, smoothed like utilization.
+type pressureSampler struct {
+	cfg    CollectorConfig
+	meters map[uint32]*stallMeter
+	pids   map[uint32]uint32
+	avg    map[uint32]*EWMA
+
+	host    stallMeter
+	hostAvg *EWMA
+	hostOK  bool
+}
+
+func newPressureSampler(cfg CollectorConfig) *pressureSampler {
+	return &pressureSampler{
+		cfg:     cfg,
+		meters:  make(map[uint32]*stallMeter),
+		pids:    make(map[uint32]uint32),
+		avg:     make(map[uint32]*EWMA),
+		host:    stallMeter{path: HostCPUPressure},
+		hostAvg: cfg.newEWMA(),
+	}
+}
+
+func (cfg CollectorConfig) newEWMA() *EWMA {
+	return &EWMA{Alpha: cfg.Alpha, Adaptive: cfg.Adaptive, MinAlpha: cfg.AlphaMin, MaxAlpha: cfg.AlphaMax}
+}
+
+// sampleHost reads the host's CPU pressure; call it once per interval,
+// before util.
+func (s *pressureSampler) sampleHost() {
+	pct, ok := s.host.read()
+	if ok {
+		s.hostAvg.Update(pct)
+	}
+	s.hostOK = ok
+}
+
+// hostPressure returns the host's smoothed CPU pressure, in percent.
+func (s *pressureSampler) hostPressure() (float64, bool) {
+	return s.hostAvg.Value(), s.hostOK
+}
+
+// util samples the pressure of slot's owner's cgroup and returns the
+// smoothed share of the time it stalled on CPU, in percent. Like
+// cgroupSampler.util, ok is false until two samples of the same owner are
+// in, and for owners in the root cgroup.
+func (s *pressureSampler) util(slot uint32, owner SlotOwner) (util float64, ok bool) {
+	m, seen := s.meters[slot]
+	if !seen || s.pids[slot] != owner.Pid {
+		delete(s.avg, slot)
+		delete(s.meters, slot)
+		path, err := ProcessCgroup(int(owner.Pid))
+		if err != nil || path == cgroupRoot {
+			return 0, false
+		}
+		m = &stallMeter{path: filepath.Join(path, "cpu.pressure")}
+		s.meters[slot], s.pids[slot] = m, owner.Pid
+	}
+	pct, ok := m.read()
+	if !ok {
+		return 0, false
+	}
+	avg, found := s.avg[slot]
+	if !found {
+		avg = s.cfg.newEWMA()
+		s.avg[slot] = avg
+	}
+	return avg.Update(pct), true
+}
+
+// pressure returns what slot_psi gets for slot: its owner's cgroup CPU
+// pressure, or the host's for an owner without a cgroup of its own.
+func (s *pressureSampler) pressure(slot uint32, owner SlotOwner) (SlotPSI, bool) {
+	if pct, ok := s.util(slot, owner); ok {
+		return SlotPSI{Some: uint32(pct * 100)}, true
+	}
+	if _, cgroup := s.meters[slot]; cgroup {
+		return SlotPSI{}, false
+	}
+	if pct, ok := s.hostPressure(); ok {
+		return SlotPSI{Some: uint32(pct * 100), Host: 1}, true
+	}
+	return SlotPSI{}, false
+}
+
+// SlotPressure reads slot_psi: the CPU pressure the collector last saw for
+// each slot, in hundredths of a percent, leaving out stale entries.
+func (g Group) SlotPressure() (map[uint32]SlotPSI, error) {
+	m, err := g.OpenPinnedMap(SlotPSIMap)
+	if err != nil {
+		return nil, err
+	}
+	defer m.Close()
+	now, err := monotonicNow()
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[uint32]SlotPSI)
+	var slot uint32
+	var v SlotPSI
+	iter := m.Iterate()
+	for iter.Next(&slot, &v) {
+		if v.UpdatedNs != 0 && now-v.UpdatedNs < uint64(psiStale) {
+			out[slot] = v
+		}
+	}
+	return out, iter.Err()
+}
+
+// psiStale is how old a slot_psi entry may be before the psi policy
+// ignores it (PSI_STALE_NS).
+const psiStale = time.Second
+
+// publishPressure writes the slots' pressure into slot_psi.
+func publishPressure(m *ebpf.Map, values map[uint32]SlotPSI) error {
+	now, err := monotonicNow()
+	if err != nil {
+		return err
+	}
+	for slot, v := range values {
+		v.UpdatedNs = now
+		if err := m.Update(slot, v, ebpf.UpdateAny); err != nil {
+			return err
+		}
+	}
+	return nil
+}
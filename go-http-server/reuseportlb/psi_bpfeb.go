@@ -0,0 +1,203 @@
+// Code generated by bpf2go; DO NOT EDIT.
+//go:build mips || mips64 || ppc64 || s390x
+
+package reuseportlb
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+
+	"github.com/cilium/ebpf"
+)
+
+type psiRatelimitCfg struct {
+	Enabled     uint32
+	Action      uint32
+	PenaltySlot uint32
+	MaxConns    uint32
+	WindowNs    uint64
+}
+
+type psiShadowState struct {
+	Phase     uint32
+	Candidate uint32
+}
+
+type psiSlotBucket struct {
+	Rate      uint64
+	Burst     uint64
+	Action    uint32
+	Pad       uint32
+	Tokens    uint64
+	LastNs    uint64
+	Throttled uint64
+}
+
+type psiSlotOverride struct {
+	Slot uint32
+	Hits uint32
+}
+
+type psiSlotPsi struct {
+	UpdatedNs uint64
+	Some      uint32
+	Host      uint32
+}
+
+type psiSlotWarmup struct {
+	StartNs  uint64
+	WindowNs uint64
+}
+
+type psiSrcRate struct {
+	WindowStart uint64
+	Count       uint32
+	Limited     uint32
+}
+
+// loadPsi returns the embedded CollectionSpec for psi.
+func loadPsi() (*ebpf.CollectionSpec, error) {
+	reader := bytes.NewReader(_PsiBytes)
+	spec, err := ebpf.LoadCollectionSpecFromReader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("can't load psi: %w", err)
+	}
+
+	return spec, err
+}
+
+// loadPsiObjects loads psi and converts it into a struct.
+//
+// The following types are suitable as obj argument:
+//
+//	*psiObjects
+//	*psiPrograms
+//	*psiMaps
+//
+// See ebpf.CollectionSpec.LoadAndAssign documentation for details.
+func loadPsiObjects(obj interface{}, opts *ebpf.CollectionOptions) error {
+	spec, err := loadPsi()
+	if err != nil {
+		return err
+	}
+
+	return spec.LoadAndAssign(obj, opts)
+}
+
+// psiSpecs contains maps and programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type psiSpecs struct {
+	psiProgramSpecs
+	psiMapSpecs
+}
+
+// psiSpecs contains programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type psiProgramSpecs struct {
+	PsiSelector *ebpf.ProgramSpec `ebpf:"psi_selector"`
+}
+
+// psiMapSpecs contains maps before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type psiMapSpecs struct {
+	AcceptqSlotCookies  *ebpf.MapSpec `ebpf:"acceptq_slot_cookies"`
+	PolicyCfg           *ebpf.MapSpec `ebpf:"policy_cfg"`
+	RatelimitCfg        *ebpf.MapSpec `ebpf:"ratelimit_cfg"`
+	ShadowEvents        *ebpf.MapSpec `ebpf:"shadow_events"`
+	ShadowProgs         *ebpf.MapSpec `ebpf:"shadow_progs"`
+	ShadowScratch       *ebpf.MapSpec `ebpf:"shadow_scratch"`
+	SlotBucket          *ebpf.MapSpec `ebpf:"slot_bucket"`
+	SlotDropping        *ebpf.MapSpec `ebpf:"slot_dropping"`
+	SlotOverride        *ebpf.MapSpec `ebpf:"slot_override"`
+	SlotPsi             *ebpf.MapSpec `ebpf:"slot_psi"`
+	SlotSelected        *ebpf.MapSpec `ebpf:"slot_selected"`
+	SlotWarmup          *ebpf.MapSpec `ebpf:"slot_warmup"`
+	SrcRate             *ebpf.MapSpec `ebpf:"src_rate"`
+	TcpBalancingTargets *ebpf.MapSpec `ebpf:"tcp_balancing_targets"`
+}
+
+// psiObjects contains all objects after they have been loaded into the kernel.
+//
+// It can be passed to loadPsiObjects or ebpf.CollectionSpec.LoadAndAssign.
+type psiObjects struct {
+	psiPrograms
+	psiMaps
+}
+
+func (o *psiObjects) Close() error {
+	return _PsiClose(
+		&o.psiPrograms,
+		&o.psiMaps,
+	)
+}
+
+// psiMaps contains all maps after they have been loaded into the kernel.
+//
+// It can be passed to loadPsiObjects or ebpf.CollectionSpec.LoadAndAssign.
+type psiMaps struct {
+	AcceptqSlotCookies  *ebpf.Map `ebpf:"acceptq_slot_cookies"`
+	PolicyCfg           *ebpf.Map `ebpf:"policy_cfg"`
+	RatelimitCfg        *ebpf.Map `ebpf:"ratelimit_cfg"`
+	ShadowEvents        *ebpf.Map `ebpf:"shadow_events"`
+	ShadowProgs         *ebpf.Map `ebpf:"shadow_progs"`
+	ShadowScratch       *ebpf.Map `ebpf:"shadow_scratch"`
+	SlotBucket          *ebpf.Map `ebpf:"slot_bucket"`
+	SlotDropping        *ebpf.Map `ebpf:"slot_dropping"`
+	SlotOverride        *ebpf.Map `ebpf:"slot_override"`
+	SlotPsi             *ebpf.Map `ebpf:"slot_psi"`
+	SlotSelected        *ebpf.Map `ebpf:"slot_selected"`
+	SlotWarmup          *ebpf.Map `ebpf:"slot_warmup"`
+	SrcRate             *ebpf.Map `ebpf:"src_rate"`
+	TcpBalancingTargets *ebpf.Map `ebpf:"tcp_balancing_targets"`
+}
+
+func (m *psiMaps) Close() error {
+	return _PsiClose(
+		m.AcceptqSlotCookies,
+		m.PolicyCfg,
+		m.RatelimitCfg,
+		m.ShadowEvents,
+		m.ShadowProgs,
+		m.ShadowScratch,
+		m.SlotBucket,
+		m.SlotDropping,
+		m.SlotOverride,
+		m.SlotPsi,
+		m.SlotSelected,
+		m.SlotWarmup,
+		m.SrcRate,
+		m.TcpBalancingTargets,
+	)
+}
+
+// psiPrograms contains all programs after they have been loaded into the kernel.
+//
+// It can be passed to loadPsiObjects or ebpf.CollectionSpec.LoadAndAssign.
+type psiPrograms struct {
+	PsiSelector *ebpf.Program `ebpf:"psi_selector"`
+}
+
+func (p *psiPrograms) Close() error {
+	return _PsiClose(
+		p.PsiSelector,
+	)
+}
+
+func _PsiClose(closers ...io.Closer) error {
+	for _, closer := range closers {
+		if err := closer.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Do not access this directly.
+//
+//go:embed psi_bpfeb.o
+var _PsiBytes []byte
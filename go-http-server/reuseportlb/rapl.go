@@ -0,0 +1,132 @@
+package reuseportlb
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PowercapPath is where the kernel's powercap framework exposes RAPL, the
+// energy counters Intel and AMD processors keep per package.
+const PowercapPath = "/sys/class/powercap"
+
+// PackagePower is how much power one CPU package drew over a sampling
+// interval.
+type PackagePower struct {
+	// Zone is the powercap zone, such as intel-rapl:0.
+	Zone string `json:"zone"`
+	// Name is what the kernel calls it, such as package-0.
+	Name  string  `json:"name"`
+	Watts float64 `json:"watts"`
+}
+
+// raplZone is the package-level powercap zone of one CPU package.
+type raplZone struct {
+	zone, name string
+	dir        string
+	// maxRange is where energy_uj wraps around.
+	maxRange uint64
+}
+
+// powerSampler turns successive readings of each package's energy counter
+// into the power drawn in between.
+type powerSampler struct {
+	zones  []raplZone
+	prev   map[string]uint64
+	prevAt time.Time
+}
+
+// newPowerSampler finds the host's RAPL package zones. It fails if there
+// are none, or if their counters cannot be read: since Linux 5.10 energy_uj
+// is readable by root only.
+func newPowerSampler() (*powerSampler, error) {
+	dirs, err := filepath.Glob(filepath.Join(PowercapPath, "intel-rapl:*"))
+	if err != nil {
+		return nil, err
+	}
+	s := &powerSampler{prev: make(map[string]uint64)}
+	for _, dir := range dirs {
+		zone := filepath.Base(dir)
+		// intel-rapl:0 is a package; intel-rapl:0:0 one of its domains
+		// (cores, uncore, dram), already counted in the package.
+		if strings.Count(zone, ":") != 1 {
+			continue
+		}
+		maxRange, err := strconv.ParseUint(readTrim(filepath.Join(dir, "max_energy_range_uj")), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("read %s energy range: %w", zone, err)
+		}
+		s.zones = append(s.zones, raplZone{zone: zone, name: readTrim(filepath.Join(dir, "name")), dir: dir, maxRange: maxRange})
+	}
+	if len(s.zones) == 0 {
+		return nil, errors.New("no RAPL package zones under " + PowercapPath)
+	}
+	sort.Slice(s.zones, func(i, j int) bool { return s.zones[i].zone < s.zones[j].zone })
+	if _, err := s.sample(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// sample returns the power each package drew since the last call. The first
+// call only takes the baseline and returns nil.
+func (s *powerSampler) sample() ([]PackagePower, error) {
+	now := time.Now()
+	elapsed := now.Sub(s.prevAt)
+	first := s.prevAt.IsZero()
+	s.prevAt = now
+	var out []PackagePower
+	for _, z := range s.zones {
+		data, err := os.ReadFile(filepath.Join(z.dir, "energy_uj"))
+		if err != nil {
+			return nil, fmt.Errorf("read %s energy: %w", z.zone, err)
+		}
+		uj, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s energy: %w", z.zone, err)
+		}
+		prev, seen := s.prev[z.zone]
+		s.prev[z.zone] = uj
+		if first || !seen || elapsed <= 0 {
+			continue
+		}
+		used := uj - prev
+		if uj < prev {
+			used = z.maxRange - prev + uj
+		}
+		out = append(out, PackagePower{Zone: z.zone, Name: z.name, Watts: float64(used) / 1e6 / elapsed.Seconds()})
+	}
+	return out, nil
+}
+
+// packagePower holds the latest readings of the collectors running in this
+// process, published as the package_power expvar.
+var packagePower = struct {
+	sync.Mutex
+	once     sync.Once
+	readings []PackagePower
+}{}
+
+// PackagePowerDraw returns the power each CPU package drew over the last
+// period of a collector running in this process with Power set.
+func PackagePowerDraw() []PackagePower {
+	packagePower.Lock()
+	defer packagePower.Unlock()
+	return append([]PackagePower(nil), packagePower.readings...)
+}
+
+func publishPackagePower(readings []PackagePower) {
+	packagePower.once.Do(func() {
+		expvar.Publish("package_power", expvar.Func(func() any { return PackagePowerDraw() }))
+	})
+	packagePower.Lock()
+	packagePower.readings = readings
+	packagePower.Unlock()
+}
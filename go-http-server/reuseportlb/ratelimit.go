@@ -0,0 +1,192 @@
+package reuseportlb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"sort"
+	"time"
+
+	"github.com/cilium/ebpf"
+)
+
+// RateLimitAction is what the selectors do with a connection from a source
+// that is over its limit. Values match enum ratelimit_action in
+// eBPF/ratelimit.h.
+type RateLimitAction uint32
+
+const (
+	// RateLimitDrop drops the connection (SK_DROP), which the client sees
+	// as a reset.
+	RateLimitDrop RateLimitAction = iota
+	// RateLimitDeprioritize steers the connection to the penalty slot, so
+	// the noisy source only competes with itself.
+	RateLimitDeprioritize
+)
+
+func (a RateLimitAction) String() string {
+	switch a {
+	case RateLimitDrop:
+		return "drop"
+	case RateLimitDeprioritize:
+		return "deprioritize"
+	}
+	return fmt.Sprintf("RateLimitAction(%d)", uint32(a))
+}
+
+// ParseRateLimitAction parses "drop" or "deprioritize".
+func ParseRateLimitAction(s string) (RateLimitAction, error) {
+	switch s {
+	case "drop":
+		return RateLimitDrop, nil
+	case "deprioritize":
+		return RateLimitDeprioritize, nil
+	}
+	return 0, fmt.Errorf("unknown rate limit action %q: must be drop or deprioritize", s)
+}
+
+// RateLimitConfig limits how many new connections a single IPv4 source may
+// open per Window. It is enforced by every selector before its own policy
+// runs; IPv6 sources are not limited.
+type RateLimitConfig struct {
+	Enabled     bool
+	MaxConns    uint32
+	Window      time.Duration
+	Action      RateLimitAction
+	PenaltySlot uint32
+}
+
+// Offender is a source the limiter has acted on.
+type Offender struct {
+	Addr netip.Addr
+	// Count is the number of connections seen in the source's current window.
+	Count uint32
+	// Limited is the number of connections dropped or deprioritized so far.
+	Limited uint32
+}
+
+// SetRateLimit writes cfg to the group's pinned ratelimit_cfg map. The
+// change applies to the next connection; per-source counters are kept.
+func (g Group) SetRateLimit(cfg RateLimitConfig) error {
+	if cfg.Enabled && (cfg.MaxConns == 0 || cfg.Window <= 0) {
+		return errors.New("rate limit needs a positive connection limit and window")
+	}
+	if cfg.Enabled {
+		if err := g.requireFeature(FeatureSourceLimit); err != nil {
+			return err
+		}
+	}
+	m, err := g.openAudited(RateLimitMap)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	v := rateLimitCfg{
+		Action:      uint32(cfg.Action),
+		PenaltySlot: cfg.PenaltySlot,
+		MaxConns:    cfg.MaxConns,
+		WindowNs:    uint64(cfg.Window),
+	}
+	if cfg.Enabled {
+		v.Enabled = 1
+	}
+	var k uint32
+	if err := m.Update(&k, &v, ebpf.UpdateAny); err != nil {
+		return fmt.Errorf("write %s: %w", RateLimitMap, err)
+	}
+	return nil
+}
+
+// RateLimit reads the current config from the pinned ratelimit_cfg map.
+func (g Group) RateLimit() (RateLimitConfig, error) {
+	m, err := g.openAudited(RateLimitMap)
+	if err != nil {
+		return RateLimitConfig{}, err
+	}
+	defer m.Close()
+
+	var k uint32
+	var v rateLimitCfg
+	if err := m.Lookup(&k, &v); err != nil {
+		return RateLimitConfig{}, fmt.Errorf("read %s: %w", RateLimitMap, err)
+	}
+	return RateLimitConfig{
+		Enabled:     v.Enabled != 0,
+		MaxConns:    v.MaxConns,
+		Window:      time.Duration(v.WindowNs),
+		Action:      RateLimitAction(v.Action),
+		PenaltySlot: v.PenaltySlot,
+	}, nil
+}
+
+// Offenders lists the sources that have been limited at least once, most
+// limited first. The per-source map is an LRU, so sources that went quiet
+// long ago may have been evicted.
+func (g Group) Offenders() ([]Offender, error) {
+	m, err := g.OpenPinnedMap(SrcRateMap)
+	if err != nil {
+		return nil, err
+	}
+	defer m.Close()
+
+	var (
+		key [4]byte // IPv4 address, network byte order
+		val srcRate
+		out []Offender
+	)
+	iter := m.Iterate()
+	for iter.Next(&key, &val) {
+		if val.Limited == 0 {
+			continue
+		}
+		out = append(out, Offender{Addr: netip.AddrFrom4(key), Count: val.Count, Limited: val.Limited})
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("iterate %s: %w", SrcRateMap, err)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Limited > out[j].Limited })
+	return out, nil
+}
+
+// ServeRateLimit is an admin handler reporting the group's limiter config
+// and its current offenders as JSON.
+func (g Group) ServeRateLimit(w http.ResponseWriter, r *http.Request) {
+	cfg, err := g.RateLimit()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	offenders, err := g.Offenders()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	type offender struct {
+		Addr    string `json:"addr"`
+		Count   uint32 `json:"count"`
+		Limited uint32 `json:"limited"`
+	}
+	resp := struct {
+		Enabled     bool       `json:"enabled"`
+		MaxConns    uint32     `json:"max_conns"`
+		Window      string     `json:"window"`
+		Action      string     `json:"action"`
+		PenaltySlot uint32     `json:"penalty_slot"`
+		Offenders   []offender `json:"offenders"`
+	}{
+		Enabled:     cfg.Enabled,
+		MaxConns:    cfg.MaxConns,
+		Window:      cfg.Window.String(),
+		Action:      cfg.Action.String(),
+		PenaltySlot: cfg.PenaltySlot,
+		Offenders:   []offender{},
+	}
+	for _, o := range offenders {
+		resp.Offenders = append(resp.Offenders, offender{o.Addr.String(), o.Count, o.Limited})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,204 @@
+package reuseportlb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cilium/ebpf"
+)
+
+// DefaultRebalanceInterval is how often a Rebalancer looks for requests
+// in slot_rebalance.
+const DefaultRebalanceInterval = time.Second
+
+// slotRebalance is a value in slot_rebalance: the latest request for the
+// slot's server to shed a share of its connections. Seq goes up with every
+// request, so a server acts on each one once.
+type slotRebalance struct {
+	Seq      uint64
+	Permille uint32
+	Pad      uint32
+}
+
+// RequestRebalance asks the server of slot to shed fraction (0 to 1) of
+// its open connections, through slot_rebalance. The server has to run a
+// Rebalancer watching the slot; it acts within its interval.
+func (g Group) RequestRebalance(slot uint32, fraction float64) error {
+	if slot >= mapLayouts[SlotRebalanceMap].MaxEntries {
+		return fmt.Errorf("slot %d out of range", slot)
+	}
+	if fraction <= 0 || fraction > 1 {
+		return fmt.Errorf("invalid fraction %v: must be in (0, 1]", fraction)
+	}
+	m, err := g.openAudited(SlotRebalanceMap)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	var v slotRebalance
+	m.Lookup(&slot, &v)
+	v = slotRebalance{Seq: v.Seq + 1, Permille: uint32(math.Round(fraction * 1000))}
+	if err := m.Update(&slot, &v, ebpf.UpdateAny); err != nil {
+		return fmt.Errorf("write %s: %w", SlotRebalanceMap, err)
+	}
+	return nil
+}
+
+// rebalanceConn is a connection a Rebalancer follows.
+type rebalanceConn struct {
+	conn net.Conn
+	idle bool
+	shed bool // to be closed after its next response
+}
+
+// Rebalancer lets a server with long-lived connections give some of them
+// back to the selector: shed connections get Connection: close on their
+// next response (a GOAWAY over HTTP/2), or are closed at once if idle, and
+// their clients reconnect wherever the policy places them now. Without it
+// keep-alive and HTTP/2 clients stay with the instance that accepted them,
+// however the load has moved since.
+type Rebalancer struct {
+	mu    sync.Mutex
+	conns map[string]*rebalanceConn // keyed by remote address, like connStats
+	shed  uint64                    // connections shed, ever
+}
+
+// NewRebalancer returns a Rebalancer following no connections. Install
+// TrackConns as the server's ConnState and Wrap its handler.
+func NewRebalancer() *Rebalancer {
+	return &Rebalancer{conns: make(map[string]*rebalanceConn)}
+}
+
+// TrackConns returns a ConnState hook that follows the server's
+// connections before calling next, which may be nil.
+func (rb *Rebalancer) TrackConns(next func(net.Conn, http.ConnState)) func(net.Conn, http.ConnState) {
+	return func(c net.Conn, state http.ConnState) {
+		key := c.RemoteAddr().String()
+		rb.mu.Lock()
+		switch state {
+		case http.StateNew:
+			rb.conns[key] = &rebalanceConn{conn: c}
+		case http.StateActive, http.StateIdle:
+			if rc, ok := rb.conns[key]; ok {
+				rc.idle = state == http.StateIdle
+			}
+		case http.StateClosed, http.StateHijacked:
+			delete(rb.conns, key)
+		}
+		rb.mu.Unlock()
+		if next != nil {
+			next(c, state)
+		}
+	}
+}
+
+// Wrap closes the connections marked for shedding after their next
+// response.
+func (rb *Rebalancer) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rb.mu.Lock()
+		rc, ok := rb.conns[r.RemoteAddr]
+		shed := ok && rc.shed
+		rb.mu.Unlock()
+		if shed {
+			// net/http turns this into a GOAWAY on HTTP/2 connections.
+			w.Header().Set("Connection", "close")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Shed marks fraction (0 to 1) of the open connections, rounded up, for
+// closing and returns how many it marked. Idle ones are closed right away.
+func (rb *Rebalancer) Shed(fraction float64) int {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	var open []*rebalanceConn
+	for _, rc := range rb.conns {
+		if !rc.shed {
+			open = append(open, rc)
+		}
+	}
+	n := min(int(math.Ceil(fraction*float64(len(open)))), len(open))
+	// Map order is random enough to spread the choice over clients.
+	for _, rc := range open[:n] {
+		rc.shed = true
+		if rc.idle {
+			rc.conn.Close()
+		}
+	}
+	rb.shed += uint64(n)
+	return n
+}
+
+// Watch sheds connections whenever slot_rebalance asks slot's server to,
+// checking every interval until ctx is done. Requests made before it
+// started are not acted on.
+func (rb *Rebalancer) Watch(ctx context.Context, g Group, slot uint32, interval time.Duration) error {
+	m, err := g.OpenOrCreatePinnedMap(SlotRebalanceMap)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	var last slotRebalance
+	m.Lookup(&slot, &last)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		var v slotRebalance
+		if err := m.Lookup(&slot, &v); err != nil || v.Seq == last.Seq {
+			continue
+		}
+		last = v
+		n := rb.Shed(float64(v.Permille) / 1000)
+		slog.Info("Shedding connections for rebalancing", "fraction", float64(v.Permille)/1000, "conns", n)
+	}
+}
+
+// ServeRebalance is an admin handler for the Rebalancer. GET reports the
+// open connections and how many were shed; POST with a fraction form value
+// sheds that share of them now.
+func (rb *Rebalancer) ServeRebalance(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		fraction, err := strconv.ParseFloat(r.FormValue("fraction"), 64)
+		if err != nil || fraction <= 0 || fraction > 1 {
+			http.Error(w, "invalid fraction: must be in (0, 1]", http.StatusBadRequest)
+			return
+		}
+		n := rb.Shed(fraction)
+		slog.Info("Shedding connections for rebalancing", "fraction", fraction, "conns", n)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rb.mu.Lock()
+	resp := struct {
+		Open    int    `json:"open"`
+		Pending int    `json:"pending"`
+		Shed    uint64 `json:"shed"`
+	}{Open: len(rb.conns), Shed: rb.shed}
+	for _, rc := range rb.conns {
+		if rc.shed {
+			resp.Pending++
+		}
+	}
+	rb.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,196 @@
+package reuseportlb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+	"time"
+
+	"github.com/cilium/ebpf"
+)
+
+// ErrSlotTaken is returned when a socket is registered on a slot that
+// another live socket holds. A socket leaves the sockarray when it closes,
+// so a slot is only taken while its previous listener is still open.
+var ErrSlotTaken = errors.New("slot held by another live socket")
+
+// SlotTakenError describes which socket holds the slot. It matches
+// ErrSlotTaken with errors.Is.
+type SlotTakenError struct {
+	Group  Group
+	Slot   uint32
+	Cookie uint64
+	// Pid is the slot's owner in slot_owner, 0 if none was recorded.
+	Pid int
+}
+
+func (e *SlotTakenError) Error() string {
+	return fmt.Sprintf("group %s slot %d: %v (cookie 0x%x, pid %d)", e.Group, e.Slot, ErrSlotTaken, e.Cookie, e.Pid)
+}
+
+func (e *SlotTakenError) Unwrap() error { return ErrSlotTaken }
+
+// registerAttempts bounds how often a map update that failed with a
+// transient error is tried, and registerBackoff is the wait before the
+// first retry, doubled after each.
+const (
+	registerAttempts = 5
+	registerBackoff  = 10 * time.Millisecond
+)
+
+// RegisterSocket makes the listening socket fd the target of slot in the
+// group: it is stored in tcp_balancing_targets, its cookie in the slot
+// cookie map, pid and its CPU affinity in slot_owner, and an empty entry is
+// seeded in acceptq_map. Under the steer policy it is also offered to the
+// sk_lookup program. It returns the socket cookie. fd may be a duplicate
+// received from another process; the maps refer to the socket, not the
+// descriptor.
+//
+// Registering a socket again on the slot it already holds rewrites the other
+// maps and succeeds, so a replay after a restart of lbd is harmless. If
+// another open socket holds the slot it fails with a *SlotTakenError; the
+// slot is claimed with BPF_NOEXIST, so of two instances starting on the same
+// slot at once exactly one wins. Retries stop early when ctx is done.
+func (g Group) RegisterSocket(ctx context.Context, slot uint32, fd int, pid int) (uint64, error) {
+	cookie, err := SocketCookie(fd)
+	if err != nil {
+		return 0, err
+	}
+	if err := g.claimSlot(ctx, slot, fd, cookie); err != nil {
+		return 0, err
+	}
+	if err := retryTransient(ctx, func() error { return g.updatePinned(SlotCookiesMap, &slot, &cookie) }); err != nil {
+		return 0, err
+	}
+	if err := retryTransient(ctx, func() error { return g.RecordSlotOwner(slot, pid) }); err != nil {
+		return 0, err
+	}
+	if err := retryTransient(ctx, func() error { return g.seedAcceptq(fd, cookie) }); err != nil {
+		return 0, err
+	}
+	if err := retryTransient(ctx, func() error { return g.addSteerListener(slot, fd) }); err != nil {
+		return 0, err
+	}
+	return cookie, nil
+}
+
+// claimSlot stores fd in slot of tcp_balancing_targets unless the slot
+// already holds it. Looking up a sockarray entry yields the socket's cookie.
+func (g Group) claimSlot(ctx context.Context, slot uint32, fd int, cookie uint64) error {
+	m, err := g.openAudited(TargetsMap)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", TargetsMap, err)
+	}
+	defer m.Close()
+
+	// NOTE: Each process has its own file descriptor table; the kernel
+	// resolves fd in the caller's table when the sockarray is updated.
+	v := uint64(fd)
+	return retryTransient(ctx, func() error {
+		var current uint64
+		err := m.Lookup(&slot, &current)
+		switch {
+		case err == nil && current == cookie:
+			// The kernel refuses to add a socket to a sockarray twice.
+			return nil
+		case err == nil:
+			return g.slotTaken(slot, current)
+		case !errors.Is(err, ebpf.ErrKeyNotExist):
+			return fmt.Errorf("look up slot %d in %s: %w", slot, TargetsMap, err)
+		}
+		err = m.Update(&slot, &v, ebpf.UpdateNoExist)
+		if errors.Is(err, ebpf.ErrKeyExist) {
+			// Another socket claimed the slot since the lookup; the next
+			// attempt finds out whose it is.
+			return syscall.EAGAIN
+		}
+		if err != nil {
+			return fmt.Errorf("update %s: %w", TargetsMap, err)
+		}
+		return nil
+	})
+}
+
+// slotTaken builds the error for slot being held by the socket cookie.
+func (g Group) slotTaken(slot uint32, cookie uint64) error {
+	e := &SlotTakenError{Group: g, Slot: slot, Cookie: cookie}
+	if m, err := g.OpenPinnedMap(SlotOwnerMap); err == nil {
+		var owner SlotOwner
+		if m.Lookup(&slot, &owner) == nil {
+			e.Pid = int(owner.Pid)
+		}
+		m.Close()
+	}
+	return e
+}
+
+// retryTransient calls fn until it succeeds, fails with an error other than
+// EAGAIN, EBUSY or EINTR, registerAttempts calls have been made or ctx is
+// done.
+func retryTransient(ctx context.Context, fn func() error) error {
+	backoff := registerBackoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt == registerAttempts || !isTransient(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w (gave up retrying: %w)", err, ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func isTransient(err error) bool {
+	return errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EBUSY) || errors.Is(err, syscall.EINTR)
+}
+
+func (g Group) updatePinned(name string, key, value any) error {
+	m, err := g.openAudited(name)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", name, err)
+	}
+	defer m.Close()
+	if err := m.Update(key, value, ebpf.UpdateAny); err != nil {
+		return fmt.Errorf("update %s: %w", name, err)
+	}
+	return nil
+}
+
+// seedAcceptq writes the initial accept queue entry for the listener fd
+// under its cookie, so selectors and the collector see the socket, and the
+// length of its queue, before the kprobe has reported on it. acceptq_map is
+// one of the globalMaps, so every group seeds the same map.
+func (g Group) seedAcceptq(fd int, cookie uint64) error {
+	curr, backlog, err := listenQueue(fd)
+	if err != nil {
+		return fmt.Errorf("read listener backlog: %w", err)
+	}
+	m, err := g.openAudited(AcceptqMap)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", AcceptqMap, err)
+	}
+	defer m.Close()
+
+	initial := AcceptqEntry{Curr: curr, Max: backlog, Cpu: 0}
+	var value any = &initial
+	if IsPerCPU(m.Map) {
+		// Per-CPU maps take one value per possible CPU.
+		nCPU, err := ebpf.PossibleCPU()
+		if err != nil {
+			return fmt.Errorf("determine possible CPUs: %w", err)
+		}
+		perCPU := make([]AcceptqEntry, nCPU)
+		for i := range perCPU {
+			perCPU[i] = initial
+		}
+		value = perCPU
+	}
+	if err := m.Update(&cookie, value, ebpf.UpdateAny); err != nil {
+		return fmt.Errorf("update %s: %w", AcceptqMap, err)
+	}
+	return nil
+}
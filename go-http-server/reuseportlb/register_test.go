@@ -0,0 +1,81 @@
+package reuseportlb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+)
+
+func TestSlotTakenError(t *testing.T) {
+	var err error = &SlotTakenError{Group: DefaultGroup, Slot: 3, Cookie: 0x2a, Pid: 1234}
+	err = fmt.Errorf("register: %w", err)
+	if !errors.Is(err, ErrSlotTaken) {
+		t.Errorf("errors.Is(%v, ErrSlotTaken) = false", err)
+	}
+	var taken *SlotTakenError
+	if !errors.As(err, &taken) {
+		t.Fatalf("errors.As(%v, *SlotTakenError) = false", err)
+	}
+	if taken.Slot != 3 || taken.Cookie != 0x2a || taken.Pid != 1234 {
+		t.Errorf("got %+v", taken)
+	}
+}
+
+func TestRetryTransient(t *testing.T) {
+	permanent := errors.New("invalid argument")
+	for _, tc := range []struct {
+		name      string
+		err       error
+		wantCalls int
+	}{
+		{"EAGAIN", syscall.EAGAIN, registerAttempts},
+		{"EBUSY", syscall.EBUSY, registerAttempts},
+		{"EINTR", fmt.Errorf("update: %w", syscall.EINTR), registerAttempts},
+		{"permanent", permanent, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := 0
+			err := retryTransient(context.Background(), func() error {
+				calls++
+				return tc.err
+			})
+			if !errors.Is(err, tc.err) {
+				t.Errorf("got %v, want %v", err, tc.err)
+			}
+			if calls != tc.wantCalls {
+				t.Errorf("called %d times, want %d", calls, tc.wantCalls)
+			}
+		})
+	}
+
+	t.Run("recovers", func(t *testing.T) {
+		calls := 0
+		err := retryTransient(context.Background(), func() error {
+			if calls++; calls < 3 {
+				return syscall.EAGAIN
+			}
+			return nil
+		})
+		if err != nil || calls != 3 {
+			t.Errorf("got %v after %d calls, want nil after 3", err, calls)
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		calls := 0
+		err := retryTransient(ctx, func() error {
+			calls++
+			return syscall.EBUSY
+		})
+		if !errors.Is(err, syscall.EBUSY) || !errors.Is(err, context.Canceled) {
+			t.Errorf("got %v, want EBUSY and context.Canceled", err)
+		}
+		if calls != 1 {
+			t.Errorf("called %d times after cancel, want 1", calls)
+		}
+	})
+}
@@ -0,0 +1,414 @@
+package reuseportlb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cilium/ebpf"
+)
+
+// DefaultRegistrySocket is where lbd accepts registrations.
+const DefaultRegistrySocket = "/run/lbd.sock"
+
+// Registry protocol. A server connects to the registry socket (SOCK_SEQPACKET,
+// so every message arrives whole) and keeps the connection for its lifetime.
+// Each request is one JSON registryRequest; "register" carries the listening
+// socket as SCM_RIGHTS. lbd answers every request with one registryReply.
+// The server's pid is taken from SO_PEERCRED, not from the request.
+//
+// Registering is idempotent, which is what makes restarts cheap: when the
+// connection drops, the client redials and replays its registrations, and
+// the new daemon re-attaches its selector and rewrites the maps.
+type registryRequest struct {
+	Op     string `json:"op"`              // "register" or "deregister"
+	Group  string `json:"group,omitempty"` // empty for the default group
+	Slot   uint32 `json:"slot"`
+	Cookie uint64 `json:"cookie,omitempty"` // deregister only
+	// WarmupNs is the slow-start window of a newly registered socket (see
+	// Group.StartWarmup). A replayed registration leaves the slot's ramp
+	// alone whatever it says.
+	WarmupNs int64 `json:"warmup_ns,omitempty"`
+}
+
+type registryReply struct {
+	Cookie uint64 `json:"cookie,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// Taken is set when Error is a SlotTakenError, so the client can
+	// return one that matches ErrSlotTaken.
+	Taken bool `json:"taken,omitempty"`
+}
+
+const registryMsgSize = 4096
+
+// Registry performs map updates for servers that cannot: they pass their
+// listening socket over a Unix socket and the privileged daemon attaches the
+// selector to it and registers it. Application servers then need neither
+// CAP_BPF nor access to bpffs.
+type Registry struct {
+	// Programs holds the selector of every group the daemon manages. It is
+	// attached to each socket registered in that group; registrations for
+	// any other group are refused.
+	Programs map[Group]*ebpf.Program
+
+	clients atomic.Int64
+}
+
+// Clients returns the number of connected servers.
+func (r *Registry) Clients() int64 {
+	return r.clients.Load()
+}
+
+// Serve accepts registry connections on ln until ctx is done, which also
+// hangs up on every connected server.
+func (r *Registry) Serve(ctx context.Context, ln *net.UnixListener) error {
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	for {
+		conn, err := ln.AcceptUnix()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go r.handle(ctx, conn)
+	}
+}
+
+// ListenRegistry creates the registry socket at path, replacing a stale one.
+// mode sets who may connect, e.g. 0o660 to admit only the socket's group.
+func ListenRegistry(path string, mode os.FileMode) (*net.UnixListener, error) {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("remove stale registry socket: %w", err)
+	}
+	ln, err := net.ListenUnix("unixpacket", &net.UnixAddr{Name: path, Net: "unixpacket"})
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("chmod registry socket: %w", err)
+	}
+	return ln, nil
+}
+
+func (r *Registry) handle(ctx context.Context, conn *net.UnixConn) {
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	pid, err := peerPID(conn)
+	if err != nil {
+		slog.Error("Registry peer credentials unavailable", "err", err)
+		return
+	}
+	log := slog.With("pid", pid)
+	r.clients.Add(1)
+	defer r.clients.Add(-1)
+	defer log.Info("Registry client disconnected")
+
+	buf := make([]byte, registryMsgSize)
+	oob := make([]byte, rightsSpace)
+	for {
+		n, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+		if err != nil || n == 0 {
+			return
+		}
+		fds, err := parseRights(oob[:oobn])
+		if err != nil {
+			log.Error("Malformed registry message", "err", err)
+			return
+		}
+
+		var req registryRequest
+		var reply registryReply
+		if err := json.Unmarshal(buf[:n], &req); err != nil {
+			reply.Error = fmt.Sprintf("malformed request: %v", err)
+		} else {
+			reply = r.do(ctx, req, fds, pid, log)
+		}
+		for _, fd := range fds {
+			// The sockarray references the socket itself. Keeping a
+			// descriptor would keep the listener alive after its server
+			// exits, with nobody accepting on it.
+			closeFD(fd)
+		}
+
+		b, _ := json.Marshal(reply)
+		if _, err := conn.Write(b); err != nil {
+			return
+		}
+	}
+}
+
+func (r *Registry) do(ctx context.Context, req registryRequest, fds []int, pid int, log *slog.Logger) registryReply {
+	g, err := ParseGroup(req.Group)
+	if err != nil {
+		return registryReply{Error: err.Error()}
+	}
+	prog, ok := r.Programs[g]
+	if !ok {
+		return registryReply{Error: fmt.Sprintf("group %s is not managed by this daemon", g)}
+	}
+	log = log.With("group", g.String())
+	switch req.Op {
+	case "register":
+		if len(fds) != 1 {
+			return registryReply{Error: fmt.Sprintf("register needs exactly one socket, got %d", len(fds))}
+		}
+		if prog != nil {
+			if err := attachSelector(fds[0], prog); err != nil {
+				return registryReply{Error: fmt.Sprintf("attach selector: %v", err)}
+			}
+		}
+		// A socket already in the slot is being replayed after a restart
+		// of lbd and is not new: it keeps serving at full weight.
+		var prev uint64
+		if m, err := g.OpenPinnedMap(SlotCookiesMap); err == nil {
+			m.Lookup(&req.Slot, &prev)
+			m.Close()
+		}
+		cookie, err := g.RegisterSocket(ctx, req.Slot, fds[0], pid)
+		if err != nil {
+			return registryReply{Error: err.Error(), Taken: errors.Is(err, ErrSlotTaken)}
+		}
+		if cookie != prev {
+			if err := g.StartWarmup(req.Slot, time.Duration(req.WarmupNs)); err != nil {
+				log.Warn("Starting warm-up failed", "slot", req.Slot, "err", err)
+			}
+		}
+		if prog != nil {
+			if err := g.RecordAttach(pid, req.Slot, cookie, prog); err != nil {
+				log.Warn("Recording attachment failed", "err", err)
+			}
+		}
+		log.Info("Registered socket via registry", "slot", req.Slot, CookieAttr(cookie))
+		return registryReply{Cookie: cookie}
+	case "deregister":
+		if err := g.deregister(ctx, req.Slot, req.Cookie, pid); err != nil {
+			return registryReply{Error: err.Error()}
+		}
+		log.Info("Deregistered socket via registry", "slot", req.Slot, CookieAttr(req.Cookie))
+		return registryReply{}
+	}
+	return registryReply{Error: fmt.Sprintf("unknown op %q", req.Op)}
+}
+
+// RegistryClient is the server side of the registry protocol. It remembers
+// what it registered and, if lbd goes away, keeps redialing and replays those
+// registrations once a daemon is back, so a restarted lbd rebuilds
+// tcp_balancing_targets (and re-attaches its selector) without restarting
+// the servers.
+type RegistryClient struct {
+	path string
+	// ctx bounds reconnecting and replaying.
+	ctx context.Context
+
+	mu         sync.Mutex // serializes requests, replays and reconnects
+	conn       *registryConn
+	registered map[registration]int // listener fds, replayed on reconnect
+	closed     bool
+}
+
+type registration struct {
+	group Group
+	slot  uint32
+}
+
+// registryConn is one connection to lbd. Its reader delivers replies on
+// replies and closes done when the daemon hangs up.
+type registryConn struct {
+	*net.UnixConn
+	replies chan []byte
+	done    chan struct{}
+}
+
+// Reconnect backoff bounds while lbd is away.
+const (
+	registryRetryMin = 100 * time.Millisecond
+	registryRetryMax = 5 * time.Second
+)
+
+// DialRegistry connects to the registry socket at path. Once ctx is done the
+// client no longer reconnects when lbd goes away.
+func DialRegistry(ctx context.Context, path string) (*RegistryClient, error) {
+	conn, err := dialRegistryConn(path)
+	if err != nil {
+		return nil, fmt.Errorf("connect to registry at %s (is lbd running?): %w", path, err)
+	}
+	c := &RegistryClient{path: path, ctx: ctx, conn: conn, registered: make(map[registration]int)}
+	go c.watch(conn)
+	return c, nil
+}
+
+func dialRegistryConn(path string) (*registryConn, error) {
+	conn, err := net.DialUnix("unixpacket", nil, &net.UnixAddr{Name: path, Net: "unixpacket"})
+	if err != nil {
+		return nil, err
+	}
+	// One reply of room lets the reader get past the reply to an abandoned
+	// request and notice the connection was closed.
+	rc := &registryConn{UnixConn: conn, replies: make(chan []byte, 1), done: make(chan struct{})}
+	go func() {
+		defer close(rc.done)
+		for {
+			buf := make([]byte, registryMsgSize)
+			n, err := conn.Read(buf)
+			if err != nil || n == 0 {
+				return
+			}
+			rc.replies <- buf[:n] // lbd only ever answers requests
+
+		}
+	}()
+	return rc, nil
+}
+
+// watch waits for conn to drop and then reconnects until it succeeds, the
+// client is closed or its context is done.
+func (c *RegistryClient) watch(conn *registryConn) {
+	<-conn.done
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.conn = nil
+	conn.Close()
+	c.mu.Unlock()
+	slog.Warn("Lost connection to lbd registry, reconnecting", "path", c.path)
+
+	delay := registryRetryMin
+	for {
+		select {
+		case <-c.ctx.Done():
+			slog.Info("Stopped reconnecting to lbd registry", "path", c.path, "err", c.ctx.Err())
+			return
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > registryRetryMax {
+			delay = registryRetryMax
+		}
+
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			return
+		}
+		next, err := dialRegistryConn(c.path)
+		if err != nil {
+			c.mu.Unlock()
+			continue
+		}
+		c.conn = next
+		replayed, err := c.replayLocked()
+		if err != nil {
+			// The new daemon is not ready for us yet; try again later.
+			c.conn = nil
+			next.Close()
+			c.mu.Unlock()
+			slog.Warn("Re-registering with lbd failed", "err", err)
+			continue
+		}
+		c.mu.Unlock()
+		slog.Info("Reconnected to lbd registry", "path", c.path, "reregistered", replayed)
+		go c.watch(next)
+		return
+	}
+}
+
+// replayLocked re-sends every remembered registration on the current
+// connection.
+func (c *RegistryClient) replayLocked() (int, error) {
+	for reg, fd := range c.registered {
+		req := registryRequest{Op: "register", Group: string(reg.group), Slot: reg.slot}
+		if _, err := c.callLocked(c.ctx, req, unixRights(fd)); err != nil {
+			return 0, fmt.Errorf("group %s slot %d: %w", reg.group, reg.slot, err)
+		}
+	}
+	return len(c.registered), nil
+}
+
+// Register hands the listening socket fd to the daemon for slot of group g
+// and returns the socket cookie it registered. fd must stay open for as long
+// as the registration should survive daemon restarts. warmup is the slot's
+// slow-start window, 0 for none.
+func (c *RegistryClient) Register(ctx context.Context, g Group, slot uint32, fd int, warmup time.Duration) (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	req := registryRequest{Op: "register", Group: string(g), Slot: slot, WarmupNs: int64(warmup)}
+	reply, err := c.callLocked(ctx, req, unixRights(fd))
+	if err != nil {
+		return 0, err
+	}
+	c.registered[registration{g, slot}] = fd
+	return reply.Cookie, nil
+}
+
+// Deregister asks the daemon to remove the socket identified by cookie from
+// slot of group g, with the same rules as Group.Deregister.
+func (c *RegistryClient) Deregister(ctx context.Context, g Group, slot uint32, cookie uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.registered, registration{g, slot})
+	req := registryRequest{Op: "deregister", Group: string(g), Slot: slot, Cookie: cookie}
+	_, err := c.callLocked(ctx, req, nil)
+	return err
+}
+
+// callLocked sends req and waits for its reply. A request abandoned because
+// ctx is done drops the connection, as its late reply would otherwise answer
+// the next request; watch then reconnects and replays.
+func (c *RegistryClient) callLocked(ctx context.Context, req registryRequest, oob []byte) (registryReply, error) {
+	if c.conn == nil {
+		return registryReply{}, errors.New("registry: not connected to lbd")
+	}
+	b, err := json.Marshal(req)
+	if err != nil {
+		return registryReply{}, err
+	}
+	if _, _, err := c.conn.WriteMsgUnix(b, oob, nil); err != nil {
+		return registryReply{}, fmt.Errorf("send %s request: %w", req.Op, err)
+	}
+	var msg []byte
+	select {
+	case msg = <-c.conn.replies:
+	case <-c.conn.done:
+		return registryReply{}, fmt.Errorf("read %s reply: lbd hung up", req.Op)
+	case <-ctx.Done():
+		c.conn.Close()
+		return registryReply{}, fmt.Errorf("wait for %s reply: %w", req.Op, ctx.Err())
+	}
+	var reply registryReply
+	if err := json.Unmarshal(msg, &reply); err != nil {
+		return registryReply{}, fmt.Errorf("malformed %s reply: %w", req.Op, err)
+	}
+	if reply.Taken {
+		return reply, fmt.Errorf("registry: %s: %w", reply.Error, ErrSlotTaken)
+	}
+	if reply.Error != "" {
+		return reply, fmt.Errorf("registry: %s", reply.Error)
+	}
+	return reply, nil
+}
+
+// Close ends the session and stops reconnecting.
+func (c *RegistryClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.Close()
+}
@@ -0,0 +1,186 @@
+package reuseportlb
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/bits"
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+)
+
+// reqCPUValue is a req_cpu entry: the CPU time histogram of one slot's
+// requests, laid out like a lat_hist entry.
+type reqCPUValue struct {
+	Buckets [LatencyBuckets]uint64
+	Count   uint64
+	SumNs   uint64
+}
+
+// RequestCPUTracer measures the CPU time each request takes from the
+// scheduler's point of view, as a ground truth for how much a policy's
+// placement decisions actually cost, and keeps a histogram of it in the
+// slot's req_cpu entry. A request's CPU time is the time its handler's
+// thread spent on a CPU between Begin and the end of it; work it hands to
+// other goroutines is not counted.
+type RequestCPUTracer struct {
+	objs  reqcpuObjects
+	link  link.Link
+	slots *ebpf.Map
+	slot  uint32
+
+	mu   sync.Mutex
+	hist LatencyHistogram
+}
+
+// StartRequestCPUTracer attaches the sched_switch tracer for this process
+// serving slot (Linux 5.5). Close detaches it; the slot's histogram stays
+// pinned for readers.
+func (g Group) StartRequestCPUTracer(slot uint32) (*RequestCPUTracer, error) {
+	if slot >= mapLayouts[RequestCPUMap].MaxEntries {
+		return nil, fmt.Errorf("slot %d out of range for %s", slot, RequestCPUMap)
+	}
+	slots, err := g.OpenOrCreatePinnedMap(RequestCPUMap)
+	if err != nil {
+		return nil, err
+	}
+	t := &RequestCPUTracer{slots: slots, slot: slot}
+	// A restarted server starts its slot's histogram over.
+	if err := slots.Update(slot, reqCPUValue{}, ebpf.UpdateAny); err != nil {
+		slots.Close()
+		return nil, fmt.Errorf("reset %s: %w", RequestCPUMap, err)
+	}
+	if err := loadReqcpuObjects(&t.objs, nil); err != nil {
+		slots.Close()
+		return nil, kernelFeature(fmt.Errorf("load request CPU tracer: %w", err))
+	}
+	t.link, err = link.AttachTracing(link.TracingOptions{Program: t.objs.ReqcpuSwitch})
+	if err != nil {
+		t.objs.Close()
+		slots.Close()
+		return nil, kernelFeature(fmt.Errorf("attach request CPU tracer: %w", err))
+	}
+	return t, nil
+}
+
+// Begin starts measuring a request on the calling goroutine and returns the
+// func that ends the measurement and reports the request's CPU time. The
+// goroutine stays locked to its thread until then. If the thread cannot be
+// listed, the returned func reports 0 and records nothing.
+func (t *RequestCPUTracer) Begin() func() time.Duration {
+	runtime.LockOSThread()
+	tid := uint32(gettid())
+	now, err := monotonicNow()
+	if err == nil {
+		err = t.objs.ReqcpuTasks.Update(tid, reqcpuReqcpuTask{Since: now}, ebpf.UpdateNoExist)
+	}
+	if err != nil {
+		runtime.UnlockOSThread()
+		slog.Debug("Not tracing request CPU", "tid", tid, "err", err)
+		return func() time.Duration { return 0 }
+	}
+	return func() time.Duration {
+		defer runtime.UnlockOSThread()
+		var task reqcpuReqcpuTask
+		err := t.objs.ReqcpuTasks.LookupAndDelete(tid, &task)
+		if err != nil {
+			// Hash maps gained LookupAndDelete in 5.14.
+			err = t.objs.ReqcpuTasks.Lookup(tid, &task)
+			t.objs.ReqcpuTasks.Delete(tid)
+		}
+		end, _ := monotonicNow()
+		if err != nil {
+			return 0
+		}
+		// The thread is running this, so its interval is still open.
+		cpu := task.CpuNs
+		if task.Since != 0 && end > task.Since {
+			cpu += end - task.Since
+		}
+		d := time.Duration(cpu)
+		t.record(d)
+		return d
+	}
+}
+
+func (t *RequestCPUTracer) record(d time.Duration) {
+	i := bits.Len64(uint64(d/time.Microsecond)) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= LatencyBuckets {
+		i = LatencyBuckets - 1
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.hist.Buckets[i]++
+	t.hist.Count++
+	t.hist.Sum += d
+	v := reqCPUValue{Buckets: t.hist.Buckets, Count: t.hist.Count, SumNs: uint64(t.hist.Sum)}
+	if err := t.slots.Update(t.slot, v, ebpf.UpdateAny); err != nil {
+		slog.Debug("Publishing request CPU failed", "err", err)
+	}
+}
+
+// Wrap measures every request next serves.
+func (t *RequestCPUTracer) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		end := t.Begin()
+		defer end()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Histogram returns the CPU time histogram of the requests measured so far.
+func (t *RequestCPUTracer) Histogram() LatencyHistogram {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.hist
+}
+
+// Close detaches the tracer. Requests in progress report 0.
+func (t *RequestCPUTracer) Close() error {
+	return errors.Join(t.link.Close(), t.objs.Close(), t.slots.Close())
+}
+
+// RequestCPUHistograms returns the per-request CPU time histogram of every
+// slot in the group whose server traces request CPU. The buckets count
+// microseconds of CPU time rather than of latency.
+func (g Group) RequestCPUHistograms() (map[uint32]LatencyHistogram, error) {
+	m, err := g.OpenPinnedMap(RequestCPUMap)
+	if err != nil {
+		return nil, err
+	}
+	defer m.Close()
+
+	out := make(map[uint32]LatencyHistogram)
+	var slot uint32
+	var v reqCPUValue
+	iter := m.Iterate()
+	for iter.Next(&slot, &v) {
+		if v.Count == 0 {
+			continue
+		}
+		out[slot] = LatencyHistogram{Buckets: v.Buckets, Count: v.Count, Sum: time.Duration(v.SumNs)}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("iterate %s: %w", RequestCPUMap, err)
+	}
+	return out, nil
+}
+
+// ServeRequestCPU is an admin handler rendering the group's per-slot
+// request CPU time histograms like ServeLatency does latencies.
+func (g Group) ServeRequestCPU(w http.ResponseWriter, r *http.Request) {
+	hists, err := g.RequestCPUHistograms()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	serveHistograms(w, r, hists)
+}
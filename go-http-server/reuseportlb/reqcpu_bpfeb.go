@@ -0,0 +1,124 @@
+// Code generated by bpf2go; DO NOT EDIT.
+//go:build mips || mips64 || ppc64 || s390x
+
+package reuseportlb
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+
+	"github.com/cilium/ebpf"
+)
+
+type reqcpuReqcpuTask struct {
+	CpuNs uint64
+	Since uint64
+}
+
+// loadReqcpu returns the embedded CollectionSpec for reqcpu.
+func loadReqcpu() (*ebpf.CollectionSpec, error) {
+	reader := bytes.NewReader(_ReqcpuBytes)
+	spec, err := ebpf.LoadCollectionSpecFromReader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("can't load reqcpu: %w", err)
+	}
+
+	return spec, err
+}
+
+// loadReqcpuObjects loads reqcpu and converts it into a struct.
+//
+// The following types are suitable as obj argument:
+//
+//	*reqcpuObjects
+//	*reqcpuPrograms
+//	*reqcpuMaps
+//
+// See ebpf.CollectionSpec.LoadAndAssign documentation for details.
+func loadReqcpuObjects(obj interface{}, opts *ebpf.CollectionOptions) error {
+	spec, err := loadReqcpu()
+	if err != nil {
+		return err
+	}
+
+	return spec.LoadAndAssign(obj, opts)
+}
+
+// reqcpuSpecs contains maps and programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type reqcpuSpecs struct {
+	reqcpuProgramSpecs
+	reqcpuMapSpecs
+}
+
+// reqcpuSpecs contains programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type reqcpuProgramSpecs struct {
+	ReqcpuSwitch *ebpf.ProgramSpec `ebpf:"reqcpu_switch"`
+}
+
+// reqcpuMapSpecs contains maps before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type reqcpuMapSpecs struct {
+	ReqcpuTasks *ebpf.MapSpec `ebpf:"reqcpu_tasks"`
+}
+
+// reqcpuObjects contains all objects after they have been loaded into the kernel.
+//
+// It can be passed to loadReqcpuObjects or ebpf.CollectionSpec.LoadAndAssign.
+type reqcpuObjects struct {
+	reqcpuPrograms
+	reqcpuMaps
+}
+
+func (o *reqcpuObjects) Close() error {
+	return _ReqcpuClose(
+		&o.reqcpuPrograms,
+		&o.reqcpuMaps,
+	)
+}
+
+// reqcpuMaps contains all maps after they have been loaded into the kernel.
+//
+// It can be passed to loadReqcpuObjects or ebpf.CollectionSpec.LoadAndAssign.
+type reqcpuMaps struct {
+	ReqcpuTasks *ebpf.Map `ebpf:"reqcpu_tasks"`
+}
+
+func (m *reqcpuMaps) Close() error {
+	return _ReqcpuClose(
+		m.ReqcpuTasks,
+	)
+}
+
+// reqcpuPrograms contains all programs after they have been loaded into the kernel.
+//
+// It can be passed to loadReqcpuObjects or ebpf.CollectionSpec.LoadAndAssign.
+type reqcpuPrograms struct {
+	ReqcpuSwitch *ebpf.Program `ebpf:"reqcpu_switch"`
+}
+
+func (p *reqcpuPrograms) Close() error {
+	return _ReqcpuClose(
+		p.ReqcpuSwitch,
+	)
+}
+
+func _ReqcpuClose(closers ...io.Closer) error {
+	for _, closer := range closers {
+		if err := closer.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Do not access this directly.
+//
+//go:embed reqcpu_bpfeb.o
+var _ReqcpuBytes []byte
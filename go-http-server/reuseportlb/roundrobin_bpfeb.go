@@ -1,7 +1,7 @@
 // Code generated by bpf2go; DO NOT EDIT.
 //go:build mips || mips64 || ppc64 || s390x
 
-package main
+package reuseportlb
 
 import (
 	"bytes"
@@ -12,9 +12,45 @@ import (
 	"github.com/cilium/ebpf"
 )
 
-type roundrobinRrState struct {
-	Lock    struct{ Val uint32 }
-	Counter uint32
+type roundrobinRatelimitCfg struct {
+	Enabled     uint32
+	Action      uint32
+	PenaltySlot uint32
+	MaxConns    uint32
+	WindowNs    uint64
+}
+
+type roundrobinRrState struct{ Counter uint64 }
+
+type roundrobinShadowState struct {
+	Phase     uint32
+	Candidate uint32
+}
+
+type roundrobinSlotBucket struct {
+	Rate      uint64
+	Burst     uint64
+	Action    uint32
+	Pad       uint32
+	Tokens    uint64
+	LastNs    uint64
+	Throttled uint64
+}
+
+type roundrobinSlotOverride struct {
+	Slot uint32
+	Hits uint32
+}
+
+type roundrobinSlotWarmup struct {
+	StartNs  uint64
+	WindowNs uint64
+}
+
+type roundrobinSrcRate struct {
+	WindowStart uint64
+	Count       uint32
+	Limited     uint32
 }
 
 // loadRoundrobin returns the embedded CollectionSpec for roundrobin.
@@ -65,7 +101,19 @@ type roundrobinProgramSpecs struct {
 //
 // It can be passed ebpf.CollectionSpec.Assign.
 type roundrobinMapSpecs struct {
+	PolicyCfg           *ebpf.MapSpec `ebpf:"policy_cfg"`
+	RatelimitCfg        *ebpf.MapSpec `ebpf:"ratelimit_cfg"`
 	Rr                  *ebpf.MapSpec `ebpf:"rr"`
+	RrPercpu            *ebpf.MapSpec `ebpf:"rr_percpu"`
+	ShadowEvents        *ebpf.MapSpec `ebpf:"shadow_events"`
+	ShadowProgs         *ebpf.MapSpec `ebpf:"shadow_progs"`
+	ShadowScratch       *ebpf.MapSpec `ebpf:"shadow_scratch"`
+	SlotBucket          *ebpf.MapSpec `ebpf:"slot_bucket"`
+	SlotDropping        *ebpf.MapSpec `ebpf:"slot_dropping"`
+	SlotOverride        *ebpf.MapSpec `ebpf:"slot_override"`
+	SlotSelected        *ebpf.MapSpec `ebpf:"slot_selected"`
+	SlotWarmup          *ebpf.MapSpec `ebpf:"slot_warmup"`
+	SrcRate             *ebpf.MapSpec `ebpf:"src_rate"`
 	TcpBalancingTargets *ebpf.MapSpec `ebpf:"tcp_balancing_targets"`
 }
 
@@ -88,13 +136,37 @@ func (o *roundrobinObjects) Close() error {
 //
 // It can be passed to loadRoundrobinObjects or ebpf.CollectionSpec.LoadAndAssign.
 type roundrobinMaps struct {
+	PolicyCfg           *ebpf.Map `ebpf:"policy_cfg"`
+	RatelimitCfg        *ebpf.Map `ebpf:"ratelimit_cfg"`
 	Rr                  *ebpf.Map `ebpf:"rr"`
+	RrPercpu            *ebpf.Map `ebpf:"rr_percpu"`
+	ShadowEvents        *ebpf.Map `ebpf:"shadow_events"`
+	ShadowProgs         *ebpf.Map `ebpf:"shadow_progs"`
+	ShadowScratch       *ebpf.Map `ebpf:"shadow_scratch"`
+	SlotBucket          *ebpf.Map `ebpf:"slot_bucket"`
+	SlotDropping        *ebpf.Map `ebpf:"slot_dropping"`
+	SlotOverride        *ebpf.Map `ebpf:"slot_override"`
+	SlotSelected        *ebpf.Map `ebpf:"slot_selected"`
+	SlotWarmup          *ebpf.Map `ebpf:"slot_warmup"`
+	SrcRate             *ebpf.Map `ebpf:"src_rate"`
 	TcpBalancingTargets *ebpf.Map `ebpf:"tcp_balancing_targets"`
 }
 
 func (m *roundrobinMaps) Close() error {
 	return _RoundrobinClose(
+		m.PolicyCfg,
+		m.RatelimitCfg,
 		m.Rr,
+		m.RrPercpu,
+		m.ShadowEvents,
+		m.ShadowProgs,
+		m.ShadowScratch,
+		m.SlotBucket,
+		m.SlotDropping,
+		m.SlotOverride,
+		m.SlotSelected,
+		m.SlotWarmup,
+		m.SrcRate,
 		m.TcpBalancingTargets,
 	)
 }
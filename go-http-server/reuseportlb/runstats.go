@@ -0,0 +1,67 @@
+package reuseportlb
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/cilium/ebpf"
+)
+
+// RunStats is what the kernel has accounted to a group's selector: how
+// often it ran and the time it spent doing so. It only counts while
+// run-time statistics are enabled (EnableRunStats or the
+// kernel.bpf_stats_enabled sysctl).
+type RunStats struct {
+	Runs    uint64
+	Runtime time.Duration
+}
+
+// Sub returns the runs and time between an earlier reading and s.
+func (s RunStats) Sub(earlier RunStats) RunStats {
+	return RunStats{Runs: s.Runs - earlier.Runs, Runtime: s.Runtime - earlier.Runtime}
+}
+
+// PerRun returns the mean time a run took, which is what the selector adds
+// to every SYN.
+func (s RunStats) PerRun() time.Duration {
+	if s.Runs == 0 {
+		return 0
+	}
+	return s.Runtime / time.Duration(s.Runs)
+}
+
+// bpfStatsRunTime is BPF_STATS_RUN_TIME, spelled out so the package still
+// builds where x/sys/unix does not define it.
+const bpfStatsRunTime = 0
+
+// EnableRunStats has the kernel account the run time of every BPF program
+// until the returned Closer is closed. The accounting itself costs a few
+// nanoseconds a run, so it is off by default.
+func EnableRunStats() (io.Closer, error) {
+	c, err := ebpf.EnableStats(bpfStatsRunTime)
+	if err != nil {
+		return nil, fmt.Errorf("enable BPF run-time stats: %w", err)
+	}
+	return c, nil
+}
+
+// SelectorRunStats reads the run statistics of the selector pinned for the
+// group. Chain stages run as tail calls and count towards it.
+func (g Group) SelectorRunStats() (RunStats, error) {
+	prog, err := g.LoadPinnedProgram()
+	if err != nil {
+		return RunStats{}, err
+	}
+	defer prog.Close()
+	info, err := prog.Info()
+	if err != nil {
+		return RunStats{}, fmt.Errorf("selector info: %w", err)
+	}
+	runs, ok := info.RunCount()
+	runtime, ok2 := info.Runtime()
+	if !ok || !ok2 {
+		return RunStats{}, fmt.Errorf("selector run stats: %w", ebpf.ErrNotSupported)
+	}
+	return RunStats{Runs: runs, Runtime: runtime}, nil
+}
@@ -0,0 +1,138 @@
+package reuseportlb
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"runtime/metrics"
+	"time"
+
+	"github.com/cilium/ebpf"
+)
+
+// DefaultRuntimeInterval is how often servers publish their runtime
+// metrics for the gcaware policy. GC cycles of a busy server come every few
+// tens of milliseconds, so the readings have to be fresher than that.
+const DefaultRuntimeInterval = 10 * time.Millisecond
+
+// The runtime/metrics a slot_runtime entry is built from.
+var runtimeSamples = []string{
+	"/gc/heap/goal:bytes",
+	"/memory/classes/heap/objects:bytes",
+	"/sched/goroutines:goroutines",
+	"/gc/cycles/total:gc-cycles",
+	"/sched/latencies:seconds",
+	"/gc/pauses:seconds",
+}
+
+// runtimeReader turns successive runtime/metrics readings into slot_runtime
+// entries. Latency quantiles cover the time since the previous reading
+// rather than the whole life of the process.
+type runtimeReader struct {
+	samples    []metrics.Sample
+	prevSched  []uint64
+	prevPauses []uint64
+}
+
+func newRuntimeReader() *runtimeReader {
+	r := &runtimeReader{samples: make([]metrics.Sample, len(runtimeSamples))}
+	for i, name := range runtimeSamples {
+		r.samples[i].Name = name
+	}
+	return r
+}
+
+func (r *runtimeReader) read() SlotRuntime {
+	metrics.Read(r.samples)
+	u64 := func(i int) uint64 {
+		if r.samples[i].Value.Kind() != metrics.KindUint64 {
+			return 0
+		}
+		return r.samples[i].Value.Uint64()
+	}
+	var rt SlotRuntime
+	if goal := u64(0); goal > 0 {
+		rt.HeapPct = uint32(u64(1) * 100 / goal)
+	}
+	rt.Goroutines = uint32(u64(2))
+	rt.GcCycles = uint32(u64(3))
+	rt.SchedP99Us = histP99Us(r.samples[4].Value, &r.prevSched)
+	rt.PauseP99Us = histP99Us(r.samples[5].Value, &r.prevPauses)
+	return rt
+}
+
+// histP99Us returns the p99, in microseconds, of the observations a
+// cumulative runtime histogram gained since prev, and advances prev. It
+// takes the upper bound of the bucket the quantile falls in.
+func histP99Us(v metrics.Value, prev *[]uint64) uint32 {
+	if v.Kind() != metrics.KindFloat64Histogram {
+		return 0
+	}
+	h := v.Float64Histogram()
+	delta := make([]uint64, len(h.Counts))
+	var total uint64
+	for i, n := range h.Counts {
+		if i < len(*prev) {
+			n -= (*prev)[i]
+		}
+		delta[i] = n
+		total += n
+	}
+	*prev = append((*prev)[:0], h.Counts...)
+	if total == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(float64(total) * 0.99))
+	var cum uint64
+	for i, n := range delta {
+		cum += n
+		if cum < rank {
+			continue
+		}
+		bound := h.Buckets[i+1]
+		if math.IsInf(bound, 1) {
+			bound = h.Buckets[i]
+		}
+		return uint32(min(bound*1e6, math.MaxUint32))
+	}
+	return 0
+}
+
+// PublishRuntimeMetrics writes this process's Go runtime readings to the
+// slot's slot_runtime entry every interval until ctx is done: heap size
+// against the GC goal, goroutines, GC cycles, and the scheduling latency
+// and GC pause p99 since the previous reading. The gcaware policy steers
+// connections away from slots in or near a GC cycle with them.
+func (g Group) PublishRuntimeMetrics(ctx context.Context, slot uint32, interval time.Duration) error {
+	m, err := g.OpenOrCreatePinnedMap(SlotRuntimeMap)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	r := newRuntimeReader()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	failing := false
+	for {
+		rt := r.read()
+		now, err := monotonicNow()
+		if err == nil {
+			rt.UpdatedNs = now
+			err = m.Update(slot, rt, ebpf.UpdateAny)
+		}
+		if err != nil && !failing {
+			slog.Warn("Publishing runtime metrics failed", "err", err)
+		}
+		failing = err != nil
+
+		select {
+		case <-ctx.Done():
+			// A zero entry reads as "no information" rather than as the
+			// last state of a server that is gone.
+			m.Update(slot, SlotRuntime{}, ebpf.UpdateAny)
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
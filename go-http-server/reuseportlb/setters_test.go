@@ -0,0 +1,205 @@
+package reuseportlb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// mapSlots is the number of slots every per-slot map has.
+const mapSlots = 128
+
+// randSlot returns a slot that is mostly valid, sometimes just past the
+// maps and sometimes far past them.
+func randSlot(rng *rand.Rand) uint32 {
+	switch rng.Intn(8) {
+	case 0:
+		return mapSlots + uint32(rng.Intn(4))
+	case 1:
+		return ^uint32(0) - uint32(rng.Intn(4))
+	}
+	return uint32(rng.Intn(mapSlots))
+}
+
+// A setter is one map-writing API called with random input. It returns the
+// slot it wrote, whether the call was rejected and the input, for failure
+// messages.
+type setter func(g Group, rng *rand.Rand, policy string) (slot uint32, rejected bool, input string)
+
+var setters = map[string]setter{
+	"SetParams": func(g Group, rng *rand.Rand, policy string) (uint32, bool, string) {
+		values := make(map[string]uint64)
+		for _, p := range PolicyParams(policy) {
+			if rng.Intn(2) == 0 {
+				values[p.Name] = p.Min + uint64(rng.Int63n(int64(min(p.Max-p.Min, 1<<40)+1)))
+			}
+		}
+		return 0, g.SetParams(policy, values) != nil, fmt.Sprint(values)
+	},
+	"SetSlotLimit": func(g Group, rng *rand.Rand, _ string) (uint32, bool, string) {
+		slot := randSlot(rng)
+		// Only redistributing limits generous enough for the client: a
+		// dropping or exhausted one turns connections away by design.
+		rate := uint64(1000 + rng.Intn(maxSlotRate))
+		l := SlotLimit{Rate: rate, Burst: rate}
+		return slot, g.SetSlotLimit(slot, l) != nil, fmt.Sprintf("slot %d %+v", slot, l)
+	},
+	"SetOverride": func(g Group, rng *rand.Rand, _ string) (uint32, bool, string) {
+		addr := netip.AddrFrom4([4]byte{127, 0, 0, 1})
+		if rng.Intn(2) == 0 {
+			return 0, g.ClearOverride(addr) != nil, "clear"
+		}
+		slot := randSlot(rng)
+		return slot, g.SetOverride(addr, slot) != nil, fmt.Sprintf("slot %d", slot)
+	},
+	"StartWarmup": func(g Group, rng *rand.Rand, _ string) (uint32, bool, string) {
+		slot := randSlot(rng)
+		window := time.Duration(rng.Int63n(int64(time.Second)))
+		return slot, g.StartWarmup(slot, window) != nil, fmt.Sprintf("slot %d window %s", slot, window)
+	},
+	"RequestRebalance": func(g Group, rng *rand.Rand, _ string) (uint32, bool, string) {
+		slot := randSlot(rng)
+		fraction := rng.Float64()*1.2 - 0.1
+		return slot, g.RequestRebalance(slot, fraction) != nil, fmt.Sprintf("slot %d fraction %.3f", slot, fraction)
+	},
+}
+
+// TestConcurrentSetters starts a group of servers and has goroutines race
+// each other writing random parameters, slot limits, overrides, warm-ups
+// and rebalance requests, slots past the end of the maps included, while a
+// client keeps connecting. No setter may panic or accept an out-of-range
+// slot, and every connection must land on a server.
+func TestConcurrentSetters(t *testing.T) {
+	requireBPF(t)
+	const (
+		policy    = "round-robin"
+		instances = 3
+		workers   = 4
+		duration  = 3 * time.Second
+	)
+	g, err := ParseGroup(fmt.Sprintf("setters-test-%d", os.Getpid()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Stand in for collect_stats, which creates the maps servers register
+	// their sockets in.
+	if err := g.ensurePinDir(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(g.PinDir()) })
+	for _, name := range []string{SlotCookiesMap, AcceptqMap} {
+		m, err := g.OpenOrCreatePinnedMap(name)
+		if err != nil {
+			t.Fatalf("create %s: %v", name, err)
+		}
+		m.Close()
+	}
+
+	addr := freeAddr(t)
+	for slot := uint32(0); slot < instances; slot++ {
+		slot := slot
+		mux := http.NewServeMux()
+		mux.HandleFunc("/whoami", func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(map[string]uint32{"slot": slot})
+		})
+		srv := WrapServer(&http.Server{Addr: addr, Handler: mux},
+			WithGroup(g), WithSlot(slot), WithPolicy(policy), WithFeatures(AllFeatures&^FeatureTrace))
+		ln, err := srv.Listen(context.Background())
+		if err != nil {
+			t.Fatalf("slot %d: %v", slot, err)
+		}
+		go srv.Serve(ln)
+		t.Cleanup(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			srv.Shutdown(ctx)
+		})
+	}
+
+	names := make([]string, 0, len(setters))
+	for name := range setters {
+		names = append(names, name)
+	}
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	var writes atomic.Int64
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(rng *rand.Rand) {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				name := names[rng.Intn(len(names))]
+				func() {
+					defer func() {
+						if r := recover(); r != nil {
+							t.Errorf("%s panicked: %v", name, r)
+						}
+					}()
+					slot, rejected, input := setters[name](g, rng, policy)
+					if slot >= mapSlots && !rejected {
+						t.Errorf("%s accepted out-of-range input: %s", name, input)
+					}
+				}()
+				writes.Add(1)
+			}
+		}(rand.New(rand.NewSource(int64(w))))
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second, Transport: &http.Transport{DisableKeepAlives: true}}
+	var conns, bad int
+	for deadline := time.Now().Add(duration); time.Now().Before(deadline) && bad < 10; {
+		conns++
+		slot, err := whoami(client, addr)
+		switch {
+		case err != nil:
+			bad++
+			t.Errorf("connection %d failed: %v", conns, err)
+		case slot >= instances:
+			bad++
+			t.Errorf("connection %d served by slot %d, want one of 0-%d", conns, slot, instances-1)
+		}
+	}
+	close(stop)
+	wg.Wait()
+	t.Logf("%d map writes by %d workers, %d connections", writes.Load(), workers, conns)
+}
+
+// freeAddr returns a loopback address with a port nothing listens on.
+func freeAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+// whoami opens a connection and asks which slot is serving it.
+func whoami(client *http.Client, addr string) (uint32, error) {
+	resp, err := client.Get("http://" + addr + "/whoami")
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	var id struct {
+		Slot uint32 `json:"slot"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&id); err != nil {
+		return 0, fmt.Errorf("decode /whoami: %w", err)
+	}
+	return id.Slot, nil
+}
@@ -0,0 +1,254 @@
+package reuseportlb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/ringbuf"
+)
+
+// Entries of shadow_progs, as in enum shadow_prog in eBPF/shadow.h.
+const (
+	shadowProgCandidate uint32 = 0
+	shadowProgActive    uint32 = 1
+)
+
+// shadowNone is the candidate slot of a connection the candidate would not
+// have placed itself (SHADOW_NONE).
+const shadowNone = 0xFFFFFFFF
+
+// shadowEvent mirrors struct shadow_event in eBPF/shadow.h.
+type shadowEvent struct {
+	TsNs      uint64
+	Candidate uint32
+	Active    uint32
+	Hash      uint32
+	_         uint32
+}
+
+// shadowPolicies are the policies that can run as a candidate. steer is not
+// among them: its sk_lookup program would steer live traffic.
+var shadowPolicies = []string{"pickfirst", "round-robin", "cpuutil", "acceptqueue", "chain", "splitter", "hot-standby", "spillover", "jsq", "memguard", "gcaware", "healthscore", "psi", "energy"}
+
+// Shadow is a candidate policy running in shadow mode in a group: it sees
+// every new connection and its choice is recorded, but the active policy's
+// choice is the one used.
+type Shadow struct {
+	group  Group
+	policy string
+	objs   LoadedObjects
+}
+
+// StartShadow loads candidate next to the group's active selector, which
+// runs policy, and makes every connection run through both. The candidate's
+// maps are pinned in the group like any policy's, so it must be a different
+// policy than the active one; state the two share by map name anyway, such
+// as the rr counter of round-robin and chain, advances for both. Stop the
+// shadow to take it out again.
+func (g Group) StartShadow(policy, candidate string, active LoadedObjects) (*Shadow, error) {
+	if !slices.Contains(shadowPolicies, candidate) {
+		return nil, fmt.Errorf("policy %q cannot run in shadow mode (can: %v)", candidate, shadowPolicies)
+	}
+	if candidate == policy {
+		return nil, fmt.Errorf("group %s already runs %s", g, policy)
+	}
+	if active.Program == nil {
+		return nil, errors.New("shadow mode needs the group's active selector")
+	}
+	if active.Features&FeatureShadow == 0 {
+		return nil, fmt.Errorf("group %s: %w: %s", g, ErrFeatureDisabled, FeatureShadow)
+	}
+
+	// A tail call only reaches a program of the same attach type. The
+	// candidate neither limits nor counts, so it needs nothing else.
+	objs, err := g.LoadPolicy(candidate, active.SelectOrMigrate, FeatureShadow)
+	if err != nil {
+		return nil, fmt.Errorf("load candidate %s: %w", candidate, err)
+	}
+	if objs.SelectOrMigrate != active.SelectOrMigrate {
+		objs.Close()
+		return nil, fmt.Errorf("candidate %s could not be loaded with the active selector's attach type", candidate)
+	}
+
+	m, err := g.openAudited(ShadowProgsMap)
+	if err != nil {
+		objs.Close()
+		return nil, err
+	}
+	defer m.Close()
+	// The active entry goes first: with only the candidate installed, its
+	// choice would be final.
+	if err := m.Update(shadowProgActive, active.Program, ebpf.UpdateAny); err != nil {
+		objs.Close()
+		return nil, fmt.Errorf("install active selector in %s: %w", ShadowProgsMap, err)
+	}
+	if err := m.Update(shadowProgCandidate, objs.Program, ebpf.UpdateAny); err != nil {
+		objs.Close()
+		return nil, fmt.Errorf("install candidate in %s: %w", ShadowProgsMap, err)
+	}
+	return &Shadow{group: g, policy: candidate, objs: objs}, nil
+}
+
+// Policy is the candidate's policy.
+func (s *Shadow) Policy() string { return s.policy }
+
+// Stop takes the candidate out of the group's selections and unloads it.
+func (s *Shadow) Stop() error {
+	m, err := s.group.openAudited(ShadowProgsMap)
+	if err != nil {
+		s.objs.Close()
+		return err
+	}
+	defer m.Close()
+	// The candidate goes first, for the same reason it went in last.
+	for _, k := range []uint32{shadowProgCandidate, shadowProgActive} {
+		if err := m.Delete(k); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			s.objs.Close()
+			return fmt.Errorf("delete from %s: %w", ShadowProgsMap, err)
+		}
+	}
+	return s.objs.Close()
+}
+
+// ShadowSlot is how many of the compared connections one slot received from
+// the active policy and would have received from the candidate.
+type ShadowSlot struct {
+	Slot      uint32 `json:"slot"`
+	Active    uint64 `json:"active"`
+	Candidate uint64 `json:"candidate"`
+}
+
+// ShadowReport compares the candidate's choices with the active policy's
+// over the connections seen by CompareShadow.
+type ShadowReport struct {
+	Duration    time.Duration `json:"duration"`
+	Connections uint64        `json:"connections"`
+	// Differ counts connections the candidate would have placed on another
+	// slot, including those it would not have placed at all.
+	Differ uint64 `json:"differ"`
+	// CandidateNone counts connections the candidate would have dropped or
+	// left to the kernel's hash.
+	CandidateNone uint64       `json:"candidate_none"`
+	Slots         []ShadowSlot `json:"slots"`
+	// ActiveUtil and CandidateUtil are the mean utilization * 100 of the
+	// slot each connection went to, or would have gone to, as published by
+	// the collector when the connection arrived: the predicted effect of
+	// switching. Both are 0 without a collector running.
+	ActiveUtil    float64 `json:"active_util"`
+	CandidateUtil float64 `json:"candidate_util"`
+}
+
+// DifferPct is the percentage of connections the candidate would have
+// placed differently.
+func (r ShadowReport) DifferPct() float64 {
+	if r.Connections == 0 {
+		return 0
+	}
+	return 100 * float64(r.Differ) / float64(r.Connections)
+}
+
+// shadowUtilRefresh is how often CompareShadow rereads slot utilization.
+const shadowUtilRefresh = time.Second
+
+// CompareShadow reads the group's shadow_events for d and reports how the
+// candidate's choices compare with the active policy's. The ring buffer
+// hands each event to one reader, so only one comparison per group should
+// run at a time.
+func (g Group) CompareShadow(d time.Duration) (ShadowReport, error) {
+	m, err := g.OpenPinnedMap(ShadowEventsMap)
+	if err != nil {
+		return ShadowReport{}, err
+	}
+	rd, err := ringbuf.NewReader(m)
+	m.Close()
+	if err != nil {
+		return ShadowReport{}, fmt.Errorf("read %s: %w", ShadowEventsMap, err)
+	}
+	defer rd.Close()
+
+	var (
+		report       = ShadowReport{Duration: d}
+		slots        = make(map[uint32]*ShadowSlot)
+		util         map[uint32]uint32
+		utilRead     time.Time
+		activeUtil   uint64
+		candUtil     uint64
+		candUtilConn uint64
+		ev           shadowEvent
+	)
+	slot := func(s uint32) *ShadowSlot {
+		if slots[s] == nil {
+			slots[s] = &ShadowSlot{Slot: s}
+		}
+		return slots[s]
+	}
+	deadline := time.Now().Add(d)
+	rd.SetDeadline(deadline)
+	for {
+		rec, err := rd.Read()
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			break
+		}
+		if err != nil {
+			return ShadowReport{}, fmt.Errorf("read %s: %w", ShadowEventsMap, err)
+		}
+		if err := binary.Read(bytes.NewReader(rec.RawSample), binary.NativeEndian, &ev); err != nil {
+			continue
+		}
+		if now := time.Now(); now.Sub(utilRead) >= shadowUtilRefresh {
+			util, utilRead = g.slotUtil(), now
+		}
+
+		report.Connections++
+		slot(ev.Active).Active++
+		activeUtil += uint64(util[ev.Active])
+		if ev.Candidate == shadowNone {
+			report.CandidateNone++
+		} else {
+			slot(ev.Candidate).Candidate++
+			candUtil += uint64(util[ev.Candidate])
+			candUtilConn++
+		}
+		if ev.Candidate != ev.Active {
+			report.Differ++
+		}
+	}
+
+	for _, s := range slots {
+		report.Slots = append(report.Slots, *s)
+	}
+	sort.Slice(report.Slots, func(i, j int) bool { return report.Slots[i].Slot < report.Slots[j].Slot })
+	if report.Connections > 0 {
+		report.ActiveUtil = float64(activeUtil) / float64(report.Connections)
+	}
+	if candUtilConn > 0 {
+		report.CandidateUtil = float64(candUtil) / float64(candUtilConn)
+	}
+	return report, nil
+}
+
+// slotUtil reads the utilization the collector last published per slot.
+// Slots without a reading are missing.
+func (g Group) slotUtil() map[uint32]uint32 {
+	out := make(map[uint32]uint32)
+	m, err := g.OpenPinnedMap(SlotUtilMap)
+	if err != nil {
+		return out
+	}
+	defer m.Close()
+	var slot, util uint32
+	iter := m.Iterate()
+	for iter.Next(&slot, &util) {
+		if util != 0 {
+			out[slot] = util
+		}
+	}
+	return out
+}
@@ -0,0 +1,230 @@
+package reuseportlb
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cilium/ebpf"
+)
+
+// SlotLimitAction is what happens to a connection placed on a slot whose
+// token bucket is empty. Values match enum slot_limit_action in
+// eBPF/ratelimit.h.
+type SlotLimitAction uint32
+
+const (
+	// SlotLimitRedistribute hands the connection to the next slot that is
+	// listening and has tokens left.
+	SlotLimitRedistribute SlotLimitAction = iota
+	// SlotLimitDrop drops the connection.
+	SlotLimitDrop
+)
+
+func (a SlotLimitAction) String() string {
+	switch a {
+	case SlotLimitRedistribute:
+		return "redistribute"
+	case SlotLimitDrop:
+		return "drop"
+	}
+	return fmt.Sprintf("SlotLimitAction(%d)", uint32(a))
+}
+
+// ParseSlotLimitAction parses "redistribute" or "drop".
+func ParseSlotLimitAction(s string) (SlotLimitAction, error) {
+	switch s {
+	case "redistribute":
+		return SlotLimitRedistribute, nil
+	case "drop":
+		return SlotLimitDrop, nil
+	}
+	return 0, fmt.Errorf("unknown slot limit action %q: must be redistribute or drop", s)
+}
+
+// SlotLimit caps the rate of new connections one slot receives with a
+// token bucket refilled at Rate per second and holding up to Burst. It is
+// enforced whatever the policy, on top of the per-source limiter. Rate 0
+// lifts the cap.
+type SlotLimit struct {
+	Rate   uint64
+	Burst  uint64
+	Action SlotLimitAction
+}
+
+// maxSlotRate bounds Rate and Burst so that a full bucket, kept in
+// billionths of a connection, fits in 64 bits.
+const maxSlotRate = 1_000_000
+
+// SlotLimitStatus is a slot's limit and how many connections it turned
+// away.
+type SlotLimitStatus struct {
+	SlotLimit
+	Throttled uint64
+}
+
+// ParseSlotLimits parses comma-separated slot=rate[/burst][:action] entries,
+// such as "3=5/10:drop,4=100". Burst defaults to the rate and action to
+// redistribute.
+func ParseSlotLimits(s string) (map[uint32]SlotLimit, error) {
+	limits := make(map[uint32]SlotLimit)
+	if s == "" {
+		return limits, nil
+	}
+	for _, entry := range strings.Split(s, ",") {
+		slotStr, spec, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			return nil, fmt.Errorf("slot limit %q: want slot=rate[/burst][:action]", entry)
+		}
+		slot, err := strconv.ParseUint(slotStr, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("slot limit %q: bad slot: %v", entry, err)
+		}
+		var l SlotLimit
+		spec, action, ok := strings.Cut(spec, ":")
+		if ok {
+			if l.Action, err = ParseSlotLimitAction(action); err != nil {
+				return nil, fmt.Errorf("slot limit %q: %v", entry, err)
+			}
+		}
+		rate, burst, ok := strings.Cut(spec, "/")
+		if l.Rate, err = strconv.ParseUint(rate, 10, 64); err != nil {
+			return nil, fmt.Errorf("slot limit %q: bad rate: %v", entry, err)
+		}
+		l.Burst = l.Rate
+		if ok {
+			if l.Burst, err = strconv.ParseUint(burst, 10, 64); err != nil {
+				return nil, fmt.Errorf("slot limit %q: bad burst: %v", entry, err)
+			}
+		}
+		limits[uint32(slot)] = l
+	}
+	return limits, nil
+}
+
+// SetSlotLimit writes the token bucket of slot to the group's pinned
+// slot_bucket map, starting it full. It applies to the next connection.
+func (g Group) SetSlotLimit(slot uint32, l SlotLimit) error {
+	if slot >= mapLayouts[SlotBucketMap].MaxEntries {
+		return fmt.Errorf("slot %d out of range", slot)
+	}
+	if l.Rate > maxSlotRate || l.Burst > maxSlotRate {
+		return fmt.Errorf("slot %d: rate and burst must not exceed %d", slot, maxSlotRate)
+	}
+	if l.Burst == 0 {
+		l.Burst = 1
+	}
+	if l.Rate > 0 {
+		if err := g.requireFeature(FeatureSlotLimits); err != nil {
+			return err
+		}
+	}
+	m, err := g.openAudited(SlotBucketMap)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	// last_ns 0 makes the selector refill the bucket on first use.
+	v := slotBucket{Rate: l.Rate, Burst: l.Burst, Action: uint32(l.Action)}
+	if err := m.Update(&slot, &v, ebpf.UpdateAny); err != nil {
+		return fmt.Errorf("write %s: %w", SlotBucketMap, err)
+	}
+	return nil
+}
+
+// SlotLimits reads the token bucket of every limited slot.
+func (g Group) SlotLimits() (map[uint32]SlotLimitStatus, error) {
+	m, err := g.openAudited(SlotBucketMap)
+	if err != nil {
+		return nil, err
+	}
+	defer m.Close()
+
+	out := make(map[uint32]SlotLimitStatus)
+	var (
+		slot uint32
+		v    slotBucket
+	)
+	iter := m.Iterate()
+	for iter.Next(&slot, &v) {
+		if v.Rate == 0 && v.Throttled == 0 {
+			continue
+		}
+		out[slot] = SlotLimitStatus{
+			SlotLimit: SlotLimit{Rate: v.Rate, Burst: v.Burst, Action: SlotLimitAction(v.Action)},
+			Throttled: v.Throttled,
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("iterate %s: %w", SlotBucketMap, err)
+	}
+	return out, nil
+}
+
+// ServeSlotLimits is an admin handler for the per-slot token buckets. GET
+// reports every limited slot; POST with slot and rate, and optionally burst
+// and action, form values sets one slot's bucket live (rate 0 lifts it).
+func (g Group) ServeSlotLimits(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var (
+			slot uint64
+			l    SlotLimit
+			err  error
+		)
+		if slot, err = strconv.ParseUint(r.FormValue("slot"), 10, 32); err != nil {
+			http.Error(w, fmt.Sprintf("invalid slot: %v", err), http.StatusBadRequest)
+			return
+		}
+		if l.Rate, err = strconv.ParseUint(r.FormValue("rate"), 10, 64); err != nil {
+			http.Error(w, fmt.Sprintf("invalid rate: %v", err), http.StatusBadRequest)
+			return
+		}
+		l.Burst = l.Rate
+		if s := r.FormValue("burst"); s != "" {
+			if l.Burst, err = strconv.ParseUint(s, 10, 64); err != nil {
+				http.Error(w, fmt.Sprintf("invalid burst: %v", err), http.StatusBadRequest)
+				return
+			}
+		}
+		if s := r.FormValue("action"); s != "" {
+			if l.Action, err = ParseSlotLimitAction(s); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if err := g.SetSlotLimit(uint32(slot), l); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limits, err := g.SlotLimits()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	type limit struct {
+		Slot      uint32 `json:"slot"`
+		Rate      uint64 `json:"rate"`
+		Burst     uint64 `json:"burst"`
+		Action    string `json:"action"`
+		Throttled uint64 `json:"throttled"`
+	}
+	resp := []limit{}
+	for slot, l := range limits {
+		resp = append(resp, limit{slot, l.Rate, l.Burst, l.Action.String(), l.Throttled})
+	}
+	sort.Slice(resp, func(i, j int) bool { return resp[i].Slot < resp[j].Slot })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,175 @@
+package reuseportlb
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"os"
+	"sort"
+
+	"github.com/cilium/ebpf"
+)
+
+// RecordSlotOwner writes pid and its CPU affinity into slot_owner, so the
+// collector can turn per-core utilization into per-slot utilization for this
+// slot.
+func (g Group) RecordSlotOwner(slot uint32, pid int) error {
+	cpus, err := cpuAffinity(pid)
+	if err != nil {
+		return fmt.Errorf("read CPU affinity of pid %d: %w", pid, err)
+	}
+	owner := SlotOwner{Pid: uint32(pid)}
+	owner.setCPUs(cpus)
+
+	m, err := g.openOrCreateAudited(SlotOwnerMap)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	if err := m.Update(&slot, &owner, ebpf.UpdateAny); err != nil {
+		return fmt.Errorf("write slot %d in %s: %w", slot, SlotOwnerMap, err)
+	}
+	return nil
+}
+
+// clearSlotOwner frees slot in slot_owner if it still belongs to pid.
+func (g Group) clearSlotOwner(slot uint32, pid int) error {
+	m, err := g.openAudited(SlotOwnerMap)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	var owner SlotOwner
+	if err := m.Lookup(&slot, &owner); err != nil || owner.Pid != uint32(pid) {
+		return nil
+	}
+	var none SlotOwner
+	if err := m.Update(&slot, &none, ebpf.UpdateExist); err != nil {
+		return fmt.Errorf("clear slot %d in %s: %w", slot, SlotOwnerMap, err)
+	}
+	return nil
+}
+
+// SlotOwners returns the occupied entries of slot_owner keyed by slot.
+func SlotOwners(m *ebpf.Map) (map[uint32]SlotOwner, error) {
+	out := make(map[uint32]SlotOwner)
+	var (
+		slot  uint32
+		owner SlotOwner
+	)
+	iter := m.Iterate()
+	for iter.Next(&slot, &owner) {
+		if owner.Pid != 0 {
+			out[slot] = owner
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("iterate %s: %w", SlotOwnerMap, err)
+	}
+	return out, nil
+}
+
+// CPUs lists the CPUs in the owner's affinity mask.
+func (o SlotOwner) CPUs() []int {
+	cpus := make([]int, 0, o.Ncpus)
+	for i, word := range o.Cpus {
+		for mask := word; mask != 0; mask &= mask - 1 {
+			cpus = append(cpus, i*64+bits.TrailingZeros64(mask))
+		}
+	}
+	return cpus
+}
+
+// setCPUs sets the owner's affinity mask to cpus, leaving out those past
+// maxOwnerCPUs.
+func (o *SlotOwner) setCPUs(cpus []int) {
+	o.Cpus = [maxOwnerCPUs / 64]uint64{}
+	o.Ncpus = 0
+	for _, cpu := range cpus {
+		if cpu < 0 || cpu >= maxOwnerCPUs || o.Cpus[cpu/64]&(1<<(cpu%64)) != 0 {
+			continue
+		}
+		o.Cpus[cpu/64] |= 1 << (cpu % 64)
+		o.Ncpus++
+	}
+}
+
+// SlotInfo is what the pinned maps say about one registered slot.
+type SlotInfo struct {
+	Slot   uint32 `json:"slot"`
+	Cookie uint64 `json:"cookie"`
+	PID    uint32 `json:"pid"`
+	CPUs   []int  `json:"cpus"`
+	// Util is the slot's utilization * 100 as last published by the
+	// collector.
+	Util uint32 `json:"util"`
+}
+
+// Slots lists every slot of the group that has a socket cookie or an owner
+// recorded, ordered by slot.
+func (g Group) Slots() ([]SlotInfo, error) {
+	bySlot := make(map[uint32]*SlotInfo)
+	get := func(slot uint32) *SlotInfo {
+		if bySlot[slot] == nil {
+			bySlot[slot] = &SlotInfo{Slot: slot}
+		}
+		return bySlot[slot]
+	}
+
+	if m, err := g.OpenPinnedMap(SlotCookiesMap); err == nil {
+		var (
+			slot   uint32
+			cookie uint64
+		)
+		iter := m.Iterate()
+		for iter.Next(&slot, &cookie) {
+			if cookie != 0 {
+				get(slot).Cookie = cookie
+			}
+		}
+		m.Close()
+		if err := iter.Err(); err != nil {
+			return nil, fmt.Errorf("iterate %s: %w", SlotCookiesMap, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	if m, err := g.OpenPinnedMap(SlotOwnerMap); err == nil {
+		owners, err := SlotOwners(m)
+		m.Close()
+		if err != nil {
+			return nil, err
+		}
+		for slot, owner := range owners {
+			s := get(slot)
+			s.PID = owner.Pid
+			s.CPUs = owner.CPUs()
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	if m, err := g.OpenPinnedMap(SlotUtilMap); err == nil {
+		for slot, s := range bySlot {
+			var util uint32
+			if err := m.Lookup(&slot, &util); err == nil {
+				s.Util = util
+			}
+		}
+		m.Close()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	out := make([]SlotInfo, 0, len(bySlot))
+	for _, s := range bySlot {
+		out = append(out, *s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Slot < out[j].Slot })
+	return out, nil
+}
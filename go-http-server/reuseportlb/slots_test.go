@@ -0,0 +1,26 @@
+package reuseportlb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSlotOwnerCPUs(t *testing.T) {
+	var o SlotOwner
+	o.setCPUs([]int{1, 0, 63, 64, 200, 1023, 1024, -1, 64})
+	if want := []int{0, 1, 63, 64, 200, 1023}; !reflect.DeepEqual(o.CPUs(), want) || o.Ncpus != uint32(len(want)) {
+		t.Errorf("got CPUs %v, ncpus %d; want %v", o.CPUs(), o.Ncpus, want)
+	}
+	o.setCPUs([]int{5})
+	if want := []int{5}; !reflect.DeepEqual(o.CPUs(), want) || o.Ncpus != 1 {
+		t.Errorf("after reset got CPUs %v, ncpus %d; want %v", o.CPUs(), o.Ncpus, want)
+	}
+}
+
+func TestSetMaxCPUsBounds(t *testing.T) {
+	for _, n := range []int{0, -1, maxOwnerCPUs + 1} {
+		if err := SetMaxCPUs(n); err == nil {
+			t.Errorf("SetMaxCPUs(%d) succeeded", n)
+		}
+	}
+}
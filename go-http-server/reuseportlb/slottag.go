@@ -0,0 +1,107 @@
+package reuseportlb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+)
+
+// Slot tagging modes.
+const (
+	// SlotTagTOS writes slot + 1 into the DSCP bits of IPv4 responses and
+	// the flow label of IPv6 ones, from a tc egress program.
+	SlotTagTOS = "tos"
+	// SlotTagTCPOption adds an experimental TCP option to every segment
+	// instead: kind SlotTagOptionKind, length 6, experiment ID SlotTagExID
+	// and the slot, all in network byte order.
+	SlotTagTCPOption = "tcp-option"
+)
+
+// What a capture tool looks for in SlotTagTCPOption mode (RFC 6994).
+const (
+	SlotTagOptionKind = 253
+	SlotTagExID       = 0x5354
+)
+
+// SlotTagger marks the packets a group's servers send with the slot serving
+// the connection, so a capture taken outside the host attributes them to an
+// instance without the servers' cooperation.
+type SlotTagger struct {
+	objs  slottagObjects
+	links []link.Link
+}
+
+// StartSlotTagger tags the responses of the group's servers on port. In
+// SlotTagTOS mode iface is the interface the responses leave through ("lo"
+// for a local client); it needs tcx (Linux 6.6). SlotTagTCPOption mode
+// tags on every interface and needs sock_ops header options (5.10). Both
+// need fexit and bpf_get_func_ret (5.17). Close stops tagging.
+func (g Group) StartSlotTagger(port uint16, mode, iface string) (*SlotTagger, error) {
+	if mode != SlotTagTOS && mode != SlotTagTCPOption {
+		return nil, fmt.Errorf("invalid slot tag mode %q: must be %s or %s", mode, SlotTagTOS, SlotTagTCPOption)
+	}
+	// Registration fills it in for every policy, whether or not the
+	// policy's own programs use it.
+	cookies, err := g.OpenOrCreatePinnedMap(SlotCookiesMap)
+	if err != nil {
+		return nil, err
+	}
+	defer cookies.Close()
+
+	spec, err := loadSlottag()
+	if err != nil {
+		return nil, err
+	}
+	// The programs compare against the TCP header as it is on the wire.
+	var wire [2]byte
+	binary.BigEndian.PutUint16(wire[:], port)
+	if err := spec.RewriteConstants(map[string]interface{}{"slottag_port": binary.NativeEndian.Uint16(wire[:])}); err != nil {
+		return nil, fmt.Errorf("set slot tagger port: %w", err)
+	}
+	t := &SlotTagger{}
+	opts := &ebpf.CollectionOptions{MapReplacements: map[string]*ebpf.Map{SlotCookiesMap: cookies}}
+	if err := spec.LoadAndAssign(&t.objs, opts); err != nil {
+		return nil, kernelFeature(fmt.Errorf("load slot tagger: %w", err))
+	}
+
+	l, err := link.AttachTracing(link.TracingOptions{Program: t.objs.SlottagAccept})
+	if err != nil {
+		t.Close()
+		return nil, kernelFeature(fmt.Errorf("attach slot tagger accept probe: %w", err))
+	}
+	t.links = append(t.links, l)
+
+	if mode == SlotTagTOS {
+		ifc, err := net.InterfaceByName(iface)
+		if err != nil {
+			t.Close()
+			return nil, err
+		}
+		l, err = link.AttachTCX(link.TCXOptions{Interface: ifc.Index, Program: t.objs.SlottagEgress, Attach: ebpf.AttachTCXEgress})
+		if err != nil {
+			t.Close()
+			return nil, fmt.Errorf("attach slot tagger to %s egress: %w", iface, err)
+		}
+	} else {
+		l, err = link.AttachCgroup(link.CgroupOptions{Path: cgroupRoot, Attach: ebpf.AttachCGroupSockOps, Program: t.objs.SlottagSockops})
+		if err != nil {
+			t.Close()
+			return nil, fmt.Errorf("attach slot tagger sock_ops to %s: %w", cgroupRoot, err)
+		}
+	}
+	t.links = append(t.links, l)
+	return t, nil
+}
+
+// Close stops tagging. Connections accepted since keep no tag.
+func (t *SlotTagger) Close() error {
+	var errs []error
+	for _, l := range t.links {
+		errs = append(errs, l.Close())
+	}
+	return errors.Join(append(errs, t.objs.Close())...)
+}
@@ -0,0 +1,134 @@
+// Code generated by bpf2go; DO NOT EDIT.
+//go:build 386 || amd64 || arm || arm64 || loong64 || mips64le || mipsle || ppc64le || riscv64
+
+package reuseportlb
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+
+	"github.com/cilium/ebpf"
+)
+
+type slottagSlottagFlow struct {
+	Raddr [4]uint32
+	Rport uint16
+	Lport uint16
+}
+
+// loadSlottag returns the embedded CollectionSpec for slottag.
+func loadSlottag() (*ebpf.CollectionSpec, error) {
+	reader := bytes.NewReader(_SlottagBytes)
+	spec, err := ebpf.LoadCollectionSpecFromReader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("can't load slottag: %w", err)
+	}
+
+	return spec, err
+}
+
+// loadSlottagObjects loads slottag and converts it into a struct.
+//
+// The following types are suitable as obj argument:
+//
+//	*slottagObjects
+//	*slottagPrograms
+//	*slottagMaps
+//
+// See ebpf.CollectionSpec.LoadAndAssign documentation for details.
+func loadSlottagObjects(obj interface{}, opts *ebpf.CollectionOptions) error {
+	spec, err := loadSlottag()
+	if err != nil {
+		return err
+	}
+
+	return spec.LoadAndAssign(obj, opts)
+}
+
+// slottagSpecs contains maps and programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type slottagSpecs struct {
+	slottagProgramSpecs
+	slottagMapSpecs
+}
+
+// slottagSpecs contains programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type slottagProgramSpecs struct {
+	SlottagAccept  *ebpf.ProgramSpec `ebpf:"slottag_accept"`
+	SlottagEgress  *ebpf.ProgramSpec `ebpf:"slottag_egress"`
+	SlottagSockops *ebpf.ProgramSpec `ebpf:"slottag_sockops"`
+}
+
+// slottagMapSpecs contains maps before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type slottagMapSpecs struct {
+	AcceptqSlotCookies *ebpf.MapSpec `ebpf:"acceptq_slot_cookies"`
+	SlottagFlows       *ebpf.MapSpec `ebpf:"slottag_flows"`
+}
+
+// slottagObjects contains all objects after they have been loaded into the kernel.
+//
+// It can be passed to loadSlottagObjects or ebpf.CollectionSpec.LoadAndAssign.
+type slottagObjects struct {
+	slottagPrograms
+	slottagMaps
+}
+
+func (o *slottagObjects) Close() error {
+	return _SlottagClose(
+		&o.slottagPrograms,
+		&o.slottagMaps,
+	)
+}
+
+// slottagMaps contains all maps after they have been loaded into the kernel.
+//
+// It can be passed to loadSlottagObjects or ebpf.CollectionSpec.LoadAndAssign.
+type slottagMaps struct {
+	AcceptqSlotCookies *ebpf.Map `ebpf:"acceptq_slot_cookies"`
+	SlottagFlows       *ebpf.Map `ebpf:"slottag_flows"`
+}
+
+func (m *slottagMaps) Close() error {
+	return _SlottagClose(
+		m.AcceptqSlotCookies,
+		m.SlottagFlows,
+	)
+}
+
+// slottagPrograms contains all programs after they have been loaded into the kernel.
+//
+// It can be passed to loadSlottagObjects or ebpf.CollectionSpec.LoadAndAssign.
+type slottagPrograms struct {
+	SlottagAccept  *ebpf.Program `ebpf:"slottag_accept"`
+	SlottagEgress  *ebpf.Program `ebpf:"slottag_egress"`
+	SlottagSockops *ebpf.Program `ebpf:"slottag_sockops"`
+}
+
+func (p *slottagPrograms) Close() error {
+	return _SlottagClose(
+		p.SlottagAccept,
+		p.SlottagEgress,
+		p.SlottagSockops,
+	)
+}
+
+func _SlottagClose(closers ...io.Closer) error {
+	for _, closer := range closers {
+		if err := closer.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Do not access this directly.
+//
+//go:embed slottag_bpfel.o
+var _SlottagBytes []byte
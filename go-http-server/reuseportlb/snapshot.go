@@ -0,0 +1,277 @@
+package reuseportlb
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/cilium/ebpf"
+)
+
+// Snapshot is the content of a group's pinned maps at one point in time,
+// for backing up the balancer's state before an experiment or attaching it
+// to a bug report. Keys and values are kept as the raw bytes the eBPF
+// programs see, hex encoded, so any map can be captured without knowing
+// its layout beyond mapLayouts.
+type Snapshot struct {
+	Group         string        `json:"group"`
+	LayoutVersion uint32        `json:"layout_version"`
+	Taken         time.Time     `json:"taken"`
+	Maps          []MapSnapshot `json:"maps"`
+}
+
+// MapSnapshot is one map of a Snapshot.
+type MapSnapshot struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	KeySize   uint32 `json:"key_size"`
+	ValueSize uint32 `json:"value_size"`
+	// Global is set for the host-wide maps under PinPath.
+	Global  bool            `json:"global,omitempty"`
+	Entries []SnapshotEntry `json:"entries"`
+}
+
+// SnapshotEntry is one key of a map and its value or, in a per-CPU map,
+// its value on every possible CPU.
+type SnapshotEntry struct {
+	Key    string   `json:"key"`
+	Value  string   `json:"value,omitempty"`
+	PerCPU []string `json:"per_cpu,omitempty"`
+}
+
+// snapshotSkip names the map types a snapshot leaves out: neither can be
+// iterated from userspace.
+var snapshotSkip = map[ebpf.MapType]bool{
+	ebpf.RingBuf:   true,
+	ebpf.SkStorage: true,
+}
+
+// importSkip names the map types Import does not write back. Their values
+// are socket cookies and program IDs, which only mean something to the
+// kernel that handed them out; the instances, loader, journal and layout
+// maps describe the processes and binaries of the host they were taken on.
+var (
+	importSkipTypes = map[ebpf.MapType]bool{
+		ebpf.ReusePortSockArray: true,
+		ebpf.SockMap:            true,
+		ebpf.ProgramArray:       true,
+	}
+	importSkipMaps = map[string]bool{
+		InstancesMap: true,
+		LoaderMap:    true,
+		JournalMap:   true,
+		LayoutMap:    true,
+	}
+)
+
+// Snapshot reads every map pinned for the group, including the host-wide
+// ones. Maps that are not pinned are left out.
+func (g Group) Snapshot() (*Snapshot, error) {
+	snap := &Snapshot{Group: g.String(), LayoutVersion: LayoutVersion, Taken: time.Now()}
+	names := make([]string, 0, len(mapLayouts))
+	for name := range mapLayouts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if snapshotSkip[mapLayouts[name].Type] {
+			continue
+		}
+		m, err := g.OpenPinnedMap(name)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		ms, err := snapshotMap(name, m)
+		m.Close()
+		if err != nil {
+			return nil, err
+		}
+		ms.Global = globalMaps[name]
+		snap.Maps = append(snap.Maps, ms)
+	}
+	return snap, nil
+}
+
+func snapshotMap(name string, m *ebpf.Map) (MapSnapshot, error) {
+	ms := MapSnapshot{
+		Name:      name,
+		Type:      m.Type().String(),
+		KeySize:   m.KeySize(),
+		ValueSize: m.ValueSize(),
+		Entries:   []SnapshotEntry{},
+	}
+	perCPU := IsPerCPU(m)
+	// Per-CPU values come back one 8-byte aligned stride per CPU.
+	stride := (int(m.ValueSize()) + 7) &^ 7
+
+	var key []byte
+	for {
+		next, err := m.NextKeyBytes(key)
+		if err != nil {
+			return MapSnapshot{}, fmt.Errorf("iterate %s: %w", name, err)
+		}
+		if next == nil {
+			return ms, nil
+		}
+		key = next
+
+		v, err := m.LookupBytes(key)
+		if err != nil {
+			return MapSnapshot{}, fmt.Errorf("read %s: %w", name, err)
+		}
+		if v == nil {
+			continue // deleted since NextKey
+		}
+		e := SnapshotEntry{Key: hex.EncodeToString(key)}
+		if perCPU {
+			for off := 0; off+int(m.ValueSize()) <= len(v); off += stride {
+				e.PerCPU = append(e.PerCPU, hex.EncodeToString(v[off:off+int(m.ValueSize())]))
+			}
+		} else {
+			e.Value = hex.EncodeToString(v)
+		}
+		ms.Entries = append(ms.Entries, e)
+	}
+}
+
+// SkippedMap is a map of a Snapshot that Import did not restore.
+type SkippedMap struct {
+	Name   string
+	Reason string
+}
+
+// Import writes snap back into the group's pinned maps, which must have the
+// layout the snapshot was taken with. Each restored map ends up holding
+// exactly the snapshot's entries. Host-wide maps, maps holding sockets or
+// programs, bookkeeping maps and maps the group has not pinned are
+// skipped and reported; snap may have been taken of another group.
+func (g Group) Import(snap *Snapshot) ([]SkippedMap, error) {
+	if snap.LayoutVersion != LayoutVersion {
+		return nil, fmt.Errorf("%w: snapshot has layout version %d, this binary %d",
+			ErrLayoutMismatch, snap.LayoutVersion, LayoutVersion)
+	}
+
+	var skipped []SkippedMap
+	for _, ms := range snap.Maps {
+		spec, err := MapSpec(ms.Name)
+		switch {
+		case err != nil:
+			skipped = append(skipped, SkippedMap{ms.Name, "unknown map"})
+			continue
+		case ms.Global || globalMaps[ms.Name]:
+			skipped = append(skipped, SkippedMap{ms.Name, "host-wide"})
+			continue
+		case importSkipTypes[spec.Type]:
+			skipped = append(skipped, SkippedMap{ms.Name, "holds kernel object references"})
+			continue
+		case importSkipMaps[ms.Name]:
+			skipped = append(skipped, SkippedMap{ms.Name, "host bookkeeping"})
+			continue
+		}
+
+		m, err := g.openAudited(ms.Name)
+		if errors.Is(err, os.ErrNotExist) {
+			skipped = append(skipped, SkippedMap{ms.Name, "not pinned in group " + g.String()})
+			continue
+		}
+		if err != nil {
+			return skipped, err
+		}
+		err = importMap(m, ms)
+		m.Close()
+		if err != nil {
+			return skipped, fmt.Errorf("restore %s: %w", ms.Name, err)
+		}
+	}
+	return skipped, nil
+}
+
+func importMap(m *auditedMap, ms MapSnapshot) error {
+	if ms.KeySize != m.KeySize() || ms.ValueSize != m.ValueSize() {
+		return fmt.Errorf("%w: snapshot has key/value size %d/%d, pinned map %d/%d",
+			ErrLayoutMismatch, ms.KeySize, ms.ValueSize, m.KeySize(), m.ValueSize())
+	}
+	perCPU := IsPerCPU(m.Map)
+	cpus := 1
+	if perCPU {
+		n, err := ebpf.PossibleCPU()
+		if err != nil {
+			return err
+		}
+		cpus = n
+	}
+
+	keep := make(map[string]bool, len(ms.Entries))
+	for _, e := range ms.Entries {
+		key, err := decodeHex(e.Key, int(ms.KeySize))
+		if err != nil {
+			return fmt.Errorf("key %q: %v", e.Key, err)
+		}
+		keep[string(key)] = true
+
+		var value any
+		if perCPU {
+			if len(e.PerCPU) != cpus {
+				return fmt.Errorf("key %s: snapshot has values for %d CPUs, this host %d", e.Key, len(e.PerCPU), cpus)
+			}
+			values := make([][]byte, cpus)
+			for i, s := range e.PerCPU {
+				if values[i], err = decodeHex(s, int(ms.ValueSize)); err != nil {
+					return fmt.Errorf("key %s: %v", e.Key, err)
+				}
+			}
+			value = values
+		} else {
+			if value, err = decodeHex(e.Value, int(ms.ValueSize)); err != nil {
+				return fmt.Errorf("key %s: %v", e.Key, err)
+			}
+		}
+		if err := m.Update(key, value, ebpf.UpdateAny); err != nil {
+			return fmt.Errorf("key %s: %w", e.Key, err)
+		}
+	}
+
+	// Array entries cannot be deleted and were all overwritten above.
+	if t := m.Type(); t == ebpf.Array || t == ebpf.PerCPUArray {
+		return nil
+	}
+	var stale [][]byte
+	var key []byte
+	for {
+		next, err := m.NextKeyBytes(key)
+		if err != nil {
+			return fmt.Errorf("iterate: %w", err)
+		}
+		if next == nil {
+			break
+		}
+		key = next
+		if !keep[string(key)] {
+			stale = append(stale, key)
+		}
+	}
+	for _, k := range stale {
+		if err := m.Delete(k); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			return fmt.Errorf("delete key %x: %w", k, err)
+		}
+	}
+	return nil
+}
+
+func decodeHex(s string, size int) ([]byte, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != size {
+		return nil, fmt.Errorf("%d bytes, want %d", len(b), size)
+	}
+	return b, nil
+}
@@ -0,0 +1,45 @@
+package reuseportlb
+
+import (
+	"fmt"
+
+	"github.com/cilium/ebpf"
+)
+
+// DefaultSpillThresholdPct is the accept queue fill, in percent, past which
+// the spillover policy moves on to the next slot.
+const DefaultSpillThresholdPct = 80
+
+// SetSpillThreshold sets the accept queue fill, in percent, at which the
+// spillover policy stops packing a slot and spills to the next one. 0 makes
+// every slot count as full, so each connection goes to the least full slot.
+func (g Group) SetSpillThreshold(pct uint32) error {
+	if pct > 100 {
+		return fmt.Errorf("spill threshold %d%% out of range", pct)
+	}
+	m, err := g.openAudited(SpillCfgMap)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	k := uint32(0)
+	if err := m.Update(&k, &spilloverSpillCfg{ThresholdPct: pct}, ebpf.UpdateAny); err != nil {
+		return fmt.Errorf("update %s: %w", SpillCfgMap, err)
+	}
+	return nil
+}
+
+// SpillThreshold reads the spillover policy's threshold from spill_cfg.
+func (g Group) SpillThreshold() (uint32, error) {
+	m, err := g.openAudited(SpillCfgMap)
+	if err != nil {
+		return 0, err
+	}
+	defer m.Close()
+	k := uint32(0)
+	var v spilloverSpillCfg
+	if err := m.Lookup(&k, &v); err != nil {
+		return 0, fmt.Errorf("read %s: %w", SpillCfgMap, err)
+	}
+	return v.ThresholdPct, nil
+}
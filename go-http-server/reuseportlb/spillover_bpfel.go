@@ -0,0 +1,205 @@
+// Code generated by bpf2go; DO NOT EDIT.
+//go:build 386 || amd64 || arm || arm64 || loong64 || mips64le || mipsle || ppc64le || riscv64
+
+package reuseportlb
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+
+	"github.com/cilium/ebpf"
+)
+
+type spilloverAcceptq struct {
+	Curr uint32
+	Max  uint32
+	Cpu  uint32
+}
+
+type spilloverRatelimitCfg struct {
+	Enabled     uint32
+	Action      uint32
+	PenaltySlot uint32
+	MaxConns    uint32
+	WindowNs    uint64
+}
+
+type spilloverShadowState struct {
+	Phase     uint32
+	Candidate uint32
+}
+
+type spilloverSlotBucket struct {
+	Rate      uint64
+	Burst     uint64
+	Action    uint32
+	Pad       uint32
+	Tokens    uint64
+	LastNs    uint64
+	Throttled uint64
+}
+
+type spilloverSlotOverride struct {
+	Slot uint32
+	Hits uint32
+}
+
+type spilloverSlotWarmup struct {
+	StartNs  uint64
+	WindowNs uint64
+}
+
+type spilloverSpillCfg struct{ ThresholdPct uint32 }
+
+type spilloverSrcRate struct {
+	WindowStart uint64
+	Count       uint32
+	Limited     uint32
+}
+
+// loadSpillover returns the embedded CollectionSpec for spillover.
+func loadSpillover() (*ebpf.CollectionSpec, error) {
+	reader := bytes.NewReader(_SpilloverBytes)
+	spec, err := ebpf.LoadCollectionSpecFromReader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("can't load spillover: %w", err)
+	}
+
+	return spec, err
+}
+
+// loadSpilloverObjects loads spillover and converts it into a struct.
+//
+// The following types are suitable as obj argument:
+//
+//	*spilloverObjects
+//	*spilloverPrograms
+//	*spilloverMaps
+//
+// See ebpf.CollectionSpec.LoadAndAssign documentation for details.
+func loadSpilloverObjects(obj interface{}, opts *ebpf.CollectionOptions) error {
+	spec, err := loadSpillover()
+	if err != nil {
+		return err
+	}
+
+	return spec.LoadAndAssign(obj, opts)
+}
+
+// spilloverSpecs contains maps and programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type spilloverSpecs struct {
+	spilloverProgramSpecs
+	spilloverMapSpecs
+}
+
+// spilloverSpecs contains programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type spilloverProgramSpecs struct {
+	Spillover *ebpf.ProgramSpec `ebpf:"spillover"`
+}
+
+// spilloverMapSpecs contains maps before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type spilloverMapSpecs struct {
+	AcceptqMap          *ebpf.MapSpec `ebpf:"acceptq_map"`
+	AcceptqSlotCookies  *ebpf.MapSpec `ebpf:"acceptq_slot_cookies"`
+	RatelimitCfg        *ebpf.MapSpec `ebpf:"ratelimit_cfg"`
+	ShadowEvents        *ebpf.MapSpec `ebpf:"shadow_events"`
+	ShadowProgs         *ebpf.MapSpec `ebpf:"shadow_progs"`
+	ShadowScratch       *ebpf.MapSpec `ebpf:"shadow_scratch"`
+	SlotBucket          *ebpf.MapSpec `ebpf:"slot_bucket"`
+	SlotDropping        *ebpf.MapSpec `ebpf:"slot_dropping"`
+	SlotOverride        *ebpf.MapSpec `ebpf:"slot_override"`
+	SlotSelected        *ebpf.MapSpec `ebpf:"slot_selected"`
+	SlotWarmup          *ebpf.MapSpec `ebpf:"slot_warmup"`
+	SpillCfg            *ebpf.MapSpec `ebpf:"spill_cfg"`
+	SrcRate             *ebpf.MapSpec `ebpf:"src_rate"`
+	TcpBalancingTargets *ebpf.MapSpec `ebpf:"tcp_balancing_targets"`
+}
+
+// spilloverObjects contains all objects after they have been loaded into the kernel.
+//
+// It can be passed to loadSpilloverObjects or ebpf.CollectionSpec.LoadAndAssign.
+type spilloverObjects struct {
+	spilloverPrograms
+	spilloverMaps
+}
+
+func (o *spilloverObjects) Close() error {
+	return _SpilloverClose(
+		&o.spilloverPrograms,
+		&o.spilloverMaps,
+	)
+}
+
+// spilloverMaps contains all maps after they have been loaded into the kernel.
+//
+// It can be passed to loadSpilloverObjects or ebpf.CollectionSpec.LoadAndAssign.
+type spilloverMaps struct {
+	AcceptqMap          *ebpf.Map `ebpf:"acceptq_map"`
+	AcceptqSlotCookies  *ebpf.Map `ebpf:"acceptq_slot_cookies"`
+	RatelimitCfg        *ebpf.Map `ebpf:"ratelimit_cfg"`
+	ShadowEvents        *ebpf.Map `ebpf:"shadow_events"`
+	ShadowProgs         *ebpf.Map `ebpf:"shadow_progs"`
+	ShadowScratch       *ebpf.Map `ebpf:"shadow_scratch"`
+	SlotBucket          *ebpf.Map `ebpf:"slot_bucket"`
+	SlotDropping        *ebpf.Map `ebpf:"slot_dropping"`
+	SlotOverride        *ebpf.Map `ebpf:"slot_override"`
+	SlotSelected        *ebpf.Map `ebpf:"slot_selected"`
+	SlotWarmup          *ebpf.Map `ebpf:"slot_warmup"`
+	SpillCfg            *ebpf.Map `ebpf:"spill_cfg"`
+	SrcRate             *ebpf.Map `ebpf:"src_rate"`
+	TcpBalancingTargets *ebpf.Map `ebpf:"tcp_balancing_targets"`
+}
+
+func (m *spilloverMaps) Close() error {
+	return _SpilloverClose(
+		m.AcceptqMap,
+		m.AcceptqSlotCookies,
+		m.RatelimitCfg,
+		m.ShadowEvents,
+		m.ShadowProgs,
+		m.ShadowScratch,
+		m.SlotBucket,
+		m.SlotDropping,
+		m.SlotOverride,
+		m.SlotSelected,
+		m.SlotWarmup,
+		m.SpillCfg,
+		m.SrcRate,
+		m.TcpBalancingTargets,
+	)
+}
+
+// spilloverPrograms contains all programs after they have been loaded into the kernel.
+//
+// It can be passed to loadSpilloverObjects or ebpf.CollectionSpec.LoadAndAssign.
+type spilloverPrograms struct {
+	Spillover *ebpf.Program `ebpf:"spillover"`
+}
+
+func (p *spilloverPrograms) Close() error {
+	return _SpilloverClose(
+		p.Spillover,
+	)
+}
+
+func _SpilloverClose(closers ...io.Closer) error {
+	for _, closer := range closers {
+		if err := closer.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Do not access this directly.
+//
+//go:embed spillover_bpfel.o
+var _SpilloverBytes []byte
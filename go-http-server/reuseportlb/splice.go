@@ -0,0 +1,295 @@
+package reuseportlb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+)
+
+// Splice proxy modes.
+const (
+	// SpliceSockmap splices each client connection to its backend
+	// connection in the kernel with a sockmap stream verdict (Linux 5.15).
+	SpliceSockmap = "sockmap"
+	// SpliceCopy copies between the two in userspace, the baseline the
+	// sockmap mode is measured against.
+	SpliceCopy = "copy"
+)
+
+// SpliceProxy is a front process that accepts connections and passes each
+// to one of a set of backend addresses, picked round-robin, instead of
+// serving it: the proxying alternative to balancing in a reuseport group.
+type SpliceProxy struct {
+	mode     string
+	backends []string
+	next     atomic.Uint64
+	objs     spliceObjects
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	shutdown bool
+	wg       sync.WaitGroup
+
+	stats SpliceStats
+}
+
+// SpliceStats counts what a SpliceProxy did.
+type SpliceStats struct {
+	Accepted   atomic.Uint64
+	Active     atomic.Int64
+	DialFailed atomic.Uint64
+	// Copied is the bytes that went through userspace: all of them in copy
+	// mode, only what arrived before a pair was spliced in sockmap mode.
+	Copied atomic.Uint64
+}
+
+// NewSpliceProxy prepares a proxy to backends in the given mode. Close
+// unloads it.
+func NewSpliceProxy(mode string, backends []string) (*SpliceProxy, error) {
+	if len(backends) == 0 {
+		return nil, errors.New("splice proxy needs at least one backend")
+	}
+	p := &SpliceProxy{mode: mode, backends: backends, conns: make(map[net.Conn]struct{})}
+	switch mode {
+	case SpliceCopy:
+		return p, nil
+	case SpliceSockmap:
+	default:
+		return nil, fmt.Errorf("invalid splice mode %q: must be %s or %s", mode, SpliceSockmap, SpliceCopy)
+	}
+
+	if err := loadSpliceObjects(&p.objs, nil); err != nil {
+		return nil, fmt.Errorf("load splice program: %w", err)
+	}
+	err := link.RawAttachProgram(link.RawAttachProgramOptions{
+		Target:  p.objs.SpliceSocks.FD(),
+		Program: p.objs.SpliceVerdict,
+		Attach:  ebpf.AttachSkSKBStreamVerdict,
+	})
+	if err != nil {
+		p.objs.Close()
+		return nil, fmt.Errorf("attach splice verdict: %w", err)
+	}
+	return p, nil
+}
+
+// Stats returns the proxy's counters.
+func (p *SpliceProxy) Stats() *SpliceStats { return &p.stats }
+
+// Serve accepts connections on ln and proxies them until ln is closed or
+// Shutdown is called, which makes it return nil.
+func (p *SpliceProxy) Serve(ln net.Listener) error {
+	p.mu.Lock()
+	p.listener = ln
+	p.mu.Unlock()
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			p.mu.Lock()
+			down := p.shutdown
+			p.mu.Unlock()
+			if down || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		p.stats.Accepted.Add(1)
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			p.proxy(c)
+		}()
+	}
+}
+
+// Shutdown stops accepting and waits for the connections in progress to
+// end, closing whatever is left when ctx is done.
+func (p *SpliceProxy) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	p.shutdown = true
+	if p.listener != nil {
+		p.listener.Close()
+	}
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+	p.mu.Lock()
+	for c := range p.conns {
+		c.Close()
+	}
+	p.mu.Unlock()
+	<-done
+	return ctx.Err()
+}
+
+// Close detaches and unloads the splice program; connections still spliced
+// fall back to the userspace copy.
+func (p *SpliceProxy) Close() error {
+	if p.mode != SpliceSockmap {
+		return nil
+	}
+	err := link.RawDetachProgram(link.RawDetachProgramOptions{
+		Target:  p.objs.SpliceSocks.FD(),
+		Program: p.objs.SpliceVerdict,
+		Attach:  ebpf.AttachSkSKBStreamVerdict,
+	})
+	return errors.Join(err, p.objs.Close())
+}
+
+// dial connects to the next backend, trying each once.
+func (p *SpliceProxy) dial() (net.Conn, error) {
+	var errs []error
+	for range p.backends {
+		addr := p.backends[p.next.Add(1)%uint64(len(p.backends))]
+		c, err := net.DialTimeout("tcp", addr, time.Second)
+		if err == nil {
+			return c, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+func (p *SpliceProxy) track(c net.Conn, add bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if add {
+		p.conns[c] = struct{}{}
+	} else {
+		delete(p.conns, c)
+	}
+}
+
+func (p *SpliceProxy) proxy(client net.Conn) {
+	defer client.Close()
+	backend, err := p.dial()
+	if err != nil {
+		p.stats.DialFailed.Add(1)
+		slog.Warn("No backend for proxied connection", "client", client.RemoteAddr(), "err", err)
+		return
+	}
+	defer backend.Close()
+	p.track(client, true)
+	p.track(backend, true)
+	defer p.track(client, false)
+	defer p.track(backend, false)
+	p.stats.Active.Add(1)
+	defer p.stats.Active.Add(-1)
+
+	if p.mode == SpliceSockmap {
+		unsplice, err := p.splice(client, backend)
+		if err != nil {
+			slog.Warn("Splicing connection failed, copying it instead", "client", client.RemoteAddr(), "err", err)
+		} else {
+			defer unsplice()
+		}
+	}
+
+	// Once spliced, a read only returns what was queued before the splice,
+	// and then EOF; each direction half-closes its peer when it ends.
+	var wg sync.WaitGroup
+	pipe := func(dst, src net.Conn) {
+		defer wg.Done()
+		n, _ := io.Copy(dst, src)
+		p.stats.Copied.Add(uint64(n))
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		} else {
+			dst.Close()
+		}
+	}
+	wg.Add(2)
+	go pipe(backend, client)
+	go pipe(client, backend)
+	wg.Wait()
+}
+
+// splice puts the pair into splice_socks as each other's peer. The peers
+// are recorded first, so neither socket redirects before the other is in
+// the map.
+func (p *SpliceProxy) splice(a, b net.Conn) (func(), error) {
+	ca, err := connCookie(a)
+	if err != nil {
+		return nil, err
+	}
+	cb, err := connCookie(b)
+	if err != nil {
+		return nil, err
+	}
+	unsplice := func() {
+		p.objs.SplicePeers.Delete(ca)
+		p.objs.SplicePeers.Delete(cb)
+	}
+	if err := p.objs.SplicePeers.Update(ca, cb, ebpf.UpdateAny); err != nil {
+		return nil, fmt.Errorf("record peer: %w", err)
+	}
+	if err := p.objs.SplicePeers.Update(cb, ca, ebpf.UpdateAny); err != nil {
+		unsplice()
+		return nil, fmt.Errorf("record peer: %w", err)
+	}
+	// The sockets leave splice_socks by themselves when they close.
+	for _, c := range []struct {
+		conn   net.Conn
+		cookie uint64
+	}{{b, cb}, {a, ca}} {
+		if err := withConnFD(c.conn, func(fd int) error {
+			return p.objs.SpliceSocks.Update(c.cookie, uint64(fd), ebpf.UpdateAny)
+		}); err != nil {
+			unsplice()
+			return nil, fmt.Errorf("insert into splice_socks: %w", err)
+		}
+	}
+	return unsplice, nil
+}
+
+func connCookie(c net.Conn) (uint64, error) {
+	var cookie uint64
+	err := withConnFD(c, func(fd int) error {
+		var err error
+		cookie, err = SocketCookie(fd)
+		return err
+	})
+	return cookie, err
+}
+
+// withConnFD runs fn with the descriptor of a TCP connection.
+func withConnFD(c net.Conn, fn func(fd int) error) error {
+	sc, ok := c.(syscall.Conn)
+	if !ok {
+		return fmt.Errorf("%T has no file descriptor", c)
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var ferr error
+	if err := raw.Control(func(fd uintptr) { ferr = fn(int(fd)) }); err != nil {
+		return err
+	}
+	return ferr
+}
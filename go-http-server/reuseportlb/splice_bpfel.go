@@ -0,0 +1,122 @@
+// Code generated by bpf2go; DO NOT EDIT.
+//go:build 386 || amd64 || arm || arm64 || loong64 || mips64le || mipsle || ppc64le || riscv64
+
+package reuseportlb
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+
+	"github.com/cilium/ebpf"
+)
+
+// loadSplice returns the embedded CollectionSpec for splice.
+func loadSplice() (*ebpf.CollectionSpec, error) {
+	reader := bytes.NewReader(_SpliceBytes)
+	spec, err := ebpf.LoadCollectionSpecFromReader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("can't load splice: %w", err)
+	}
+
+	return spec, err
+}
+
+// loadSpliceObjects loads splice and converts it into a struct.
+//
+// The following types are suitable as obj argument:
+//
+//	*spliceObjects
+//	*splicePrograms
+//	*spliceMaps
+//
+// See ebpf.CollectionSpec.LoadAndAssign documentation for details.
+func loadSpliceObjects(obj interface{}, opts *ebpf.CollectionOptions) error {
+	spec, err := loadSplice()
+	if err != nil {
+		return err
+	}
+
+	return spec.LoadAndAssign(obj, opts)
+}
+
+// spliceSpecs contains maps and programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type spliceSpecs struct {
+	spliceProgramSpecs
+	spliceMapSpecs
+}
+
+// spliceSpecs contains programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type spliceProgramSpecs struct {
+	SpliceVerdict *ebpf.ProgramSpec `ebpf:"splice_verdict"`
+}
+
+// spliceMapSpecs contains maps before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type spliceMapSpecs struct {
+	SplicePeers *ebpf.MapSpec `ebpf:"splice_peers"`
+	SpliceSocks *ebpf.MapSpec `ebpf:"splice_socks"`
+}
+
+// spliceObjects contains all objects after they have been loaded into the kernel.
+//
+// It can be passed to loadSpliceObjects or ebpf.CollectionSpec.LoadAndAssign.
+type spliceObjects struct {
+	splicePrograms
+	spliceMaps
+}
+
+func (o *spliceObjects) Close() error {
+	return _SpliceClose(
+		&o.splicePrograms,
+		&o.spliceMaps,
+	)
+}
+
+// spliceMaps contains all maps after they have been loaded into the kernel.
+//
+// It can be passed to loadSpliceObjects or ebpf.CollectionSpec.LoadAndAssign.
+type spliceMaps struct {
+	SplicePeers *ebpf.Map `ebpf:"splice_peers"`
+	SpliceSocks *ebpf.Map `ebpf:"splice_socks"`
+}
+
+func (m *spliceMaps) Close() error {
+	return _SpliceClose(
+		m.SplicePeers,
+		m.SpliceSocks,
+	)
+}
+
+// splicePrograms contains all programs after they have been loaded into the kernel.
+//
+// It can be passed to loadSpliceObjects or ebpf.CollectionSpec.LoadAndAssign.
+type splicePrograms struct {
+	SpliceVerdict *ebpf.Program `ebpf:"splice_verdict"`
+}
+
+func (p *splicePrograms) Close() error {
+	return _SpliceClose(
+		p.SpliceVerdict,
+	)
+}
+
+func _SpliceClose(closers ...io.Closer) error {
+	for _, closer := range closers {
+		if err := closer.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Do not access this directly.
+//
+//go:embed splice_bpfel.o
+var _SpliceBytes []byte
@@ -0,0 +1,153 @@
+package reuseportlb
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/cilium/ebpf"
+)
+
+// SplitConfig configures the splitter policy: Percent percent of new
+// connections go to CanarySlot and the rest to the other registered slots.
+type SplitConfig struct {
+	CanarySlot uint32
+	Percent    uint32
+}
+
+// SplitCounts is how many connections the splitter has placed on each side.
+type SplitCounts struct {
+	Canary uint64
+	Main   uint64
+}
+
+// Indices into split_stats (enum split_side in eBPF/splitter.c).
+const (
+	splitCanary uint32 = iota
+	splitMain
+)
+
+// SetSplit writes cfg to the group's pinned split_cfg map. It takes effect
+// for the next connection.
+func (g Group) SetSplit(cfg SplitConfig) error {
+	if cfg.Percent > 100 {
+		return fmt.Errorf("canary percentage %d out of range", cfg.Percent)
+	}
+	if cfg.CanarySlot >= mapLayouts[TargetsMap].MaxEntries {
+		return fmt.Errorf("canary slot %d out of range", cfg.CanarySlot)
+	}
+	m, err := g.openAudited(SplitCfgMap)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	var k uint32
+	v := splitterSplitCfg{CanarySlot: cfg.CanarySlot, CanaryPct: cfg.Percent}
+	if err := m.Update(&k, &v, ebpf.UpdateAny); err != nil {
+		return fmt.Errorf("write %s: %w", SplitCfgMap, err)
+	}
+	return nil
+}
+
+// Split reads the current config from the pinned split_cfg map.
+func (g Group) Split() (SplitConfig, error) {
+	m, err := g.openAudited(SplitCfgMap)
+	if err != nil {
+		return SplitConfig{}, err
+	}
+	defer m.Close()
+
+	var k uint32
+	var v splitterSplitCfg
+	if err := m.Lookup(&k, &v); err != nil {
+		return SplitConfig{}, fmt.Errorf("read %s: %w", SplitCfgMap, err)
+	}
+	return SplitConfig{CanarySlot: v.CanarySlot, Percent: v.CanaryPct}, nil
+}
+
+// SplitCounts sums the per-CPU split_stats counters.
+func (g Group) SplitCounts() (SplitCounts, error) {
+	m, err := g.OpenPinnedMap(SplitStatsMap)
+	if err != nil {
+		return SplitCounts{}, err
+	}
+	defer m.Close()
+
+	sum := func(side uint32) (uint64, error) {
+		var perCPU []uint64
+		if err := m.Lookup(&side, &perCPU); err != nil {
+			return 0, fmt.Errorf("read %s: %w", SplitStatsMap, err)
+		}
+		var n uint64
+		for _, v := range perCPU {
+			n += v
+		}
+		return n, nil
+	}
+	var c SplitCounts
+	if c.Canary, err = sum(splitCanary); err != nil {
+		return SplitCounts{}, err
+	}
+	if c.Main, err = sum(splitMain); err != nil {
+		return SplitCounts{}, err
+	}
+	return c, nil
+}
+
+// ServeSplit is an admin handler for the splitter. GET reports the config
+// and how many connections each side received; POST with percent and/or
+// slot form values changes the split live.
+func (g Group) ServeSplit(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		cfg, err := g.Split()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		for _, f := range []struct {
+			name string
+			dst  *uint32
+		}{{"percent", &cfg.Percent}, {"slot", &cfg.CanarySlot}} {
+			s := r.FormValue(f.name)
+			if s == "" {
+				continue
+			}
+			v, err := strconv.ParseUint(s, 10, 32)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid %s: %v", f.name, err), http.StatusBadRequest)
+				return
+			}
+			*f.dst = uint32(v)
+		}
+		if err := g.SetSplit(cfg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cfg, err := g.Split()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	counts, err := g.SplitCounts()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		CanarySlot uint32 `json:"canary_slot"`
+		Percent    uint32 `json:"percent"`
+		Canary     uint64 `json:"canary_conns"`
+		Main       uint64 `json:"main_conns"`
+	}{cfg.CanarySlot, cfg.Percent, counts.Canary, counts.Main})
+}
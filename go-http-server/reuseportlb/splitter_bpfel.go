@@ -0,0 +1,207 @@
+// Code generated by bpf2go; DO NOT EDIT.
+//go:build 386 || amd64 || arm || arm64 || loong64 || mips64le || mipsle || ppc64le || riscv64
+
+package reuseportlb
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+
+	"github.com/cilium/ebpf"
+)
+
+type splitterRatelimitCfg struct {
+	Enabled     uint32
+	Action      uint32
+	PenaltySlot uint32
+	MaxConns    uint32
+	WindowNs    uint64
+}
+
+type splitterRrState struct{ Counter uint64 }
+
+type splitterShadowState struct {
+	Phase     uint32
+	Candidate uint32
+}
+
+type splitterSlotBucket struct {
+	Rate      uint64
+	Burst     uint64
+	Action    uint32
+	Pad       uint32
+	Tokens    uint64
+	LastNs    uint64
+	Throttled uint64
+}
+
+type splitterSlotOverride struct {
+	Slot uint32
+	Hits uint32
+}
+
+type splitterSlotWarmup struct {
+	StartNs  uint64
+	WindowNs uint64
+}
+
+type splitterSplitCfg struct {
+	CanarySlot uint32
+	CanaryPct  uint32
+}
+
+type splitterSrcRate struct {
+	WindowStart uint64
+	Count       uint32
+	Limited     uint32
+}
+
+// loadSplitter returns the embedded CollectionSpec for splitter.
+func loadSplitter() (*ebpf.CollectionSpec, error) {
+	reader := bytes.NewReader(_SplitterBytes)
+	spec, err := ebpf.LoadCollectionSpecFromReader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("can't load splitter: %w", err)
+	}
+
+	return spec, err
+}
+
+// loadSplitterObjects loads splitter and converts it into a struct.
+//
+// The following types are suitable as obj argument:
+//
+//	*splitterObjects
+//	*splitterPrograms
+//	*splitterMaps
+//
+// See ebpf.CollectionSpec.LoadAndAssign documentation for details.
+func loadSplitterObjects(obj interface{}, opts *ebpf.CollectionOptions) error {
+	spec, err := loadSplitter()
+	if err != nil {
+		return err
+	}
+
+	return spec.LoadAndAssign(obj, opts)
+}
+
+// splitterSpecs contains maps and programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type splitterSpecs struct {
+	splitterProgramSpecs
+	splitterMapSpecs
+}
+
+// splitterSpecs contains programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type splitterProgramSpecs struct {
+	Splitter *ebpf.ProgramSpec `ebpf:"splitter"`
+}
+
+// splitterMapSpecs contains maps before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type splitterMapSpecs struct {
+	AcceptqSlotCookies  *ebpf.MapSpec `ebpf:"acceptq_slot_cookies"`
+	RatelimitCfg        *ebpf.MapSpec `ebpf:"ratelimit_cfg"`
+	Rr                  *ebpf.MapSpec `ebpf:"rr"`
+	ShadowEvents        *ebpf.MapSpec `ebpf:"shadow_events"`
+	ShadowProgs         *ebpf.MapSpec `ebpf:"shadow_progs"`
+	ShadowScratch       *ebpf.MapSpec `ebpf:"shadow_scratch"`
+	SlotBucket          *ebpf.MapSpec `ebpf:"slot_bucket"`
+	SlotDropping        *ebpf.MapSpec `ebpf:"slot_dropping"`
+	SlotOverride        *ebpf.MapSpec `ebpf:"slot_override"`
+	SlotSelected        *ebpf.MapSpec `ebpf:"slot_selected"`
+	SlotWarmup          *ebpf.MapSpec `ebpf:"slot_warmup"`
+	SplitCfg            *ebpf.MapSpec `ebpf:"split_cfg"`
+	SplitStats          *ebpf.MapSpec `ebpf:"split_stats"`
+	SrcRate             *ebpf.MapSpec `ebpf:"src_rate"`
+	TcpBalancingTargets *ebpf.MapSpec `ebpf:"tcp_balancing_targets"`
+}
+
+// splitterObjects contains all objects after they have been loaded into the kernel.
+//
+// It can be passed to loadSplitterObjects or ebpf.CollectionSpec.LoadAndAssign.
+type splitterObjects struct {
+	splitterPrograms
+	splitterMaps
+}
+
+func (o *splitterObjects) Close() error {
+	return _SplitterClose(
+		&o.splitterPrograms,
+		&o.splitterMaps,
+	)
+}
+
+// splitterMaps contains all maps after they have been loaded into the kernel.
+//
+// It can be passed to loadSplitterObjects or ebpf.CollectionSpec.LoadAndAssign.
+type splitterMaps struct {
+	AcceptqSlotCookies  *ebpf.Map `ebpf:"acceptq_slot_cookies"`
+	RatelimitCfg        *ebpf.Map `ebpf:"ratelimit_cfg"`
+	Rr                  *ebpf.Map `ebpf:"rr"`
+	ShadowEvents        *ebpf.Map `ebpf:"shadow_events"`
+	ShadowProgs         *ebpf.Map `ebpf:"shadow_progs"`
+	ShadowScratch       *ebpf.Map `ebpf:"shadow_scratch"`
+	SlotBucket          *ebpf.Map `ebpf:"slot_bucket"`
+	SlotDropping        *ebpf.Map `ebpf:"slot_dropping"`
+	SlotOverride        *ebpf.Map `ebpf:"slot_override"`
+	SlotSelected        *ebpf.Map `ebpf:"slot_selected"`
+	SlotWarmup          *ebpf.Map `ebpf:"slot_warmup"`
+	SplitCfg            *ebpf.Map `ebpf:"split_cfg"`
+	SplitStats          *ebpf.Map `ebpf:"split_stats"`
+	SrcRate             *ebpf.Map `ebpf:"src_rate"`
+	TcpBalancingTargets *ebpf.Map `ebpf:"tcp_balancing_targets"`
+}
+
+func (m *splitterMaps) Close() error {
+	return _SplitterClose(
+		m.AcceptqSlotCookies,
+		m.RatelimitCfg,
+		m.Rr,
+		m.ShadowEvents,
+		m.ShadowProgs,
+		m.ShadowScratch,
+		m.SlotBucket,
+		m.SlotDropping,
+		m.SlotOverride,
+		m.SlotSelected,
+		m.SlotWarmup,
+		m.SplitCfg,
+		m.SplitStats,
+		m.SrcRate,
+		m.TcpBalancingTargets,
+	)
+}
+
+// splitterPrograms contains all programs after they have been loaded into the kernel.
+//
+// It can be passed to loadSplitterObjects or ebpf.CollectionSpec.LoadAndAssign.
+type splitterPrograms struct {
+	Splitter *ebpf.Program `ebpf:"splitter"`
+}
+
+func (p *splitterPrograms) Close() error {
+	return _SplitterClose(
+		p.Splitter,
+	)
+}
+
+func _SplitterClose(closers ...io.Closer) error {
+	for _, closer := range closers {
+		if err := closer.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Do not access this directly.
+//
+//go:embed splitter_bpfel.o
+var _SplitterBytes []byte
@@ -0,0 +1,400 @@
+package reuseportlb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cilium/ebpf"
+)
+
+// DefaultHeartbeatTimeout is how stale a slot's heartbeat may get before the
+// hot-standby policy passes it over.
+const DefaultHeartbeatTimeout = time.Second
+
+// Limits of the hot-standby priority groups (PRIO_MAX_LEVELS and
+// PRIO_MAX_SLOTS in eBPF/hotstandby.c).
+const (
+	MaxPriorityLevels = 8
+	prioritySlots     = 64
+)
+
+// StandbyConfig configures the hot-standby policy: connections go to Primary
+// while it listens and is healthy, and to Standby otherwise.
+type StandbyConfig struct {
+	Primary uint32
+	Standby uint32
+	// HeartbeatTimeout is how stale a slot's heartbeat may get before it
+	// counts as unhealthy. 0 judges slots only by whether they listen.
+	HeartbeatTimeout time.Duration
+
+	// Priorities, if not empty, replaces Primary and Standby with priority
+	// groups: slot -> level, 1 highest. Connections go to a random member
+	// of the highest level with a healthy listening member.
+	Priorities map[uint32]uint32
+	// With priorities, once the active level's accept queues are on average
+	// SpillThresholdPct percent full, SpillPct percent of connections go to
+	// the next level down instead. SpillPct 0 never spills.
+	SpillThresholdPct uint32
+	SpillPct          uint32
+}
+
+// ParsePriorities reads priority groups written as slot=level pairs, e.g.
+// "0=1,1=1,2=2" for slots 0 and 1 in the top level and slot 2 below them.
+func ParsePriorities(s string) (map[uint32]uint32, error) {
+	out := make(map[uint32]uint32)
+	if s == "" {
+		return out, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		slotStr, levelStr, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return nil, fmt.Errorf("priority %q is not slot=level", pair)
+		}
+		slot, err := strconv.ParseUint(slotStr, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("priority %q: invalid slot: %w", pair, err)
+		}
+		level, err := strconv.ParseUint(levelStr, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("priority %q: invalid level: %w", pair, err)
+		}
+		out[uint32(slot)] = uint32(level)
+	}
+	return out, nil
+}
+
+// FormatPriorities writes priorities the way ParsePriorities reads them.
+func FormatPriorities(p map[uint32]uint32) string {
+	slots := make([]uint32, 0, len(p))
+	for slot := range p {
+		slots = append(slots, slot)
+	}
+	sort.Slice(slots, func(i, j int) bool { return slots[i] < slots[j] })
+	parts := make([]string, len(slots))
+	for i, slot := range slots {
+		parts[i] = fmt.Sprintf("%d=%d", slot, p[slot])
+	}
+	return strings.Join(parts, ",")
+}
+
+// StandbyStatus is the hot-standby selector's view of the group.
+type StandbyStatus struct {
+	// Active is the slot that took the last connection; Previous the one
+	// before the last switch.
+	Active   uint32
+	Previous uint32
+	// Level and PreviousLevel are their priority levels, or 0 without
+	// priorities.
+	Level         uint32
+	PreviousLevel uint32
+	Switches      uint64
+	// Latency is how long after Previous last reported healthy the selector
+	// moved traffic off it, or 0 if Previous never sent a heartbeat. For a
+	// crash this is detection plus the wait for the next connection.
+	Latency time.Duration
+	// Since is how long ago the last switch happened.
+	Since time.Duration
+}
+
+// SetStandby writes cfg to the group's pinned standby_cfg and
+// standby_priority maps and resets the selector's state to the slot (or
+// level) cfg prefers, so the first connection to it does not count as a
+// switch. It takes effect for the next connection.
+func (g Group) SetStandby(cfg StandbyConfig) error {
+	slots := mapLayouts[TargetsMap].MaxEntries
+	if cfg.Primary >= slots || cfg.Standby >= slots {
+		return fmt.Errorf("standby slots %d and %d must be below %d", cfg.Primary, cfg.Standby, slots)
+	}
+	if cfg.Primary == cfg.Standby && len(cfg.Priorities) == 0 {
+		return fmt.Errorf("primary and standby are both slot %d", cfg.Primary)
+	}
+	if cfg.HeartbeatTimeout < 0 {
+		return fmt.Errorf("negative heartbeat timeout %s", cfg.HeartbeatTimeout)
+	}
+	if cfg.SpillPct > 100 || cfg.SpillThresholdPct > 100 {
+		return fmt.Errorf("spill percentages %d and %d out of range", cfg.SpillPct, cfg.SpillThresholdPct)
+	}
+	top := uint32(0)
+	for slot, level := range cfg.Priorities {
+		if slot >= prioritySlots {
+			return fmt.Errorf("slot %d cannot have a priority: only slots below %d can", slot, prioritySlots)
+		}
+		if level < 1 || level > MaxPriorityLevels {
+			return fmt.Errorf("slot %d: priority level %d out of range 1-%d", slot, level, MaxPriorityLevels)
+		}
+		if top == 0 || level < top {
+			top = level
+		}
+	}
+	m, err := g.openAudited(StandbyCfgMap)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	st, err := g.openAudited(StandbyStateMap)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+	prio, err := g.openAudited(PriorityMap)
+	if err != nil {
+		return err
+	}
+	defer prio.Close()
+
+	for slot := uint32(0); slot < prioritySlots; slot++ {
+		level := cfg.Priorities[slot]
+		if err := prio.Update(&slot, &level, ebpf.UpdateAny); err != nil {
+			return fmt.Errorf("write %s: %w", PriorityMap, err)
+		}
+	}
+	var k uint32
+	v := hotstandbyStandbyCfg{
+		Primary:           cfg.Primary,
+		Standby:           cfg.Standby,
+		TimeoutNs:         uint64(cfg.HeartbeatTimeout),
+		SpillThresholdPct: cfg.SpillThresholdPct,
+		SpillPct:          cfg.SpillPct,
+		Priorities:        uint32(len(cfg.Priorities)),
+	}
+	if err := m.Update(&k, &v, ebpf.UpdateAny); err != nil {
+		return fmt.Errorf("write %s: %w", StandbyCfgMap, err)
+	}
+	state := hotstandbyStandbyState{Active: cfg.Primary, Previous: cfg.Primary, Level: top, PreviousLevel: top}
+	if err := st.Update(&k, &state, ebpf.UpdateAny); err != nil {
+		return fmt.Errorf("write %s: %w", StandbyStateMap, err)
+	}
+	return nil
+}
+
+// Standby reads the current config from the pinned standby_cfg map.
+func (g Group) Standby() (StandbyConfig, error) {
+	m, err := g.openAudited(StandbyCfgMap)
+	if err != nil {
+		return StandbyConfig{}, err
+	}
+	defer m.Close()
+
+	prio, err := g.openAudited(PriorityMap)
+	if err != nil {
+		return StandbyConfig{}, err
+	}
+	defer prio.Close()
+
+	var k uint32
+	var v hotstandbyStandbyCfg
+	if err := m.Lookup(&k, &v); err != nil {
+		return StandbyConfig{}, fmt.Errorf("read %s: %w", StandbyCfgMap, err)
+	}
+	cfg := StandbyConfig{
+		Primary:           v.Primary,
+		Standby:           v.Standby,
+		HeartbeatTimeout:  time.Duration(v.TimeoutNs),
+		SpillThresholdPct: v.SpillThresholdPct,
+		SpillPct:          v.SpillPct,
+		Priorities:        make(map[uint32]uint32),
+	}
+	for slot := uint32(0); slot < prioritySlots; slot++ {
+		var level uint32
+		if err := prio.Lookup(&slot, &level); err != nil {
+			return StandbyConfig{}, fmt.Errorf("read %s: %w", PriorityMap, err)
+		}
+		if level != 0 {
+			cfg.Priorities[slot] = level
+		}
+	}
+	return cfg, nil
+}
+
+// StandbyStatus reads the pinned standby_state map.
+func (g Group) StandbyStatus() (StandbyStatus, error) {
+	m, err := g.OpenPinnedMap(StandbyStateMap)
+	if err != nil {
+		return StandbyStatus{}, err
+	}
+	defer m.Close()
+
+	var k uint32
+	var v hotstandbyStandbyState
+	if err := m.Lookup(&k, &v); err != nil {
+		return StandbyStatus{}, fmt.Errorf("read %s: %w", StandbyStateMap, err)
+	}
+	s := StandbyStatus{Active: v.Active, Previous: v.Previous, Level: v.Level, PreviousLevel: v.PreviousLevel, Switches: v.Switches}
+	if v.LastHeartbeatNs != 0 && v.SwitchedNs > v.LastHeartbeatNs {
+		s.Latency = time.Duration(v.SwitchedNs - v.LastHeartbeatNs)
+	}
+	if now, err := monotonicNow(); err == nil && v.SwitchedNs != 0 && now > v.SwitchedNs {
+		s.Since = time.Duration(now - v.SwitchedNs)
+	}
+	return s, nil
+}
+
+// Heartbeat marks slot healthy as of now.
+func (g Group) Heartbeat(slot uint32) error {
+	m, err := g.OpenPinnedMap(HeartbeatMap)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	return heartbeat(m, slot)
+}
+
+func heartbeat(m *ebpf.Map, slot uint32) error {
+	now, err := monotonicNow()
+	if err != nil {
+		return err
+	}
+	if err := m.Update(&slot, &now, ebpf.UpdateAny); err != nil {
+		return fmt.Errorf("write %s: %w", HeartbeatMap, err)
+	}
+	return nil
+}
+
+// RunStandby keeps slot's heartbeat fresh for the hot-standby policy until
+// ctx is done: every interval it runs check and, if that passes, writes a
+// heartbeat. A failing check, or a process too wedged to run it, lets the
+// heartbeat go stale and the selector move traffic to the other slot. It
+// also logs every switchover that made slot the active one, with how long
+// traffic kept going to the slot it took over from.
+func (g Group) RunStandby(ctx context.Context, slot uint32, interval time.Duration, check func() error) error {
+	m, err := g.OpenPinnedMap(HeartbeatMap)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	status, err := g.StandbyStatus()
+	if err != nil {
+		return err
+	}
+	seen := status.Switches
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	healthy := true
+	for {
+		switch err := check(); {
+		case err != nil:
+			if healthy {
+				slog.Warn("Health check failed, letting heartbeat go stale", "err", err)
+			}
+			healthy = false
+		default:
+			if !healthy {
+				slog.Info("Health check passing again")
+			}
+			healthy = true
+			if err := heartbeat(m, slot); err != nil {
+				slog.Warn("Writing heartbeat failed", "err", err)
+			}
+		}
+
+		if status, err := g.StandbyStatus(); err == nil && status.Switches != seen {
+			seen = status.Switches
+			if status.Active == slot {
+				slog.Info("Hot standby switchover, now active", "from", status.Previous,
+					"level", status.Level, "from_level", status.PreviousLevel,
+					"latency", status.Latency, "switches", status.Switches)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// ServeStandby is an admin handler for the hot-standby policy. GET reports
+// the config and the selector's state; POST with primary, standby, timeout,
+// priorities (slot=level pairs, "none" to clear), spill_pct and/or
+// spill_threshold_pct form values changes the config live.
+func (g Group) ServeStandby(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		cfg, err := g.Standby()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		for _, f := range []struct {
+			name string
+			dst  *uint32
+		}{{"primary", &cfg.Primary}, {"standby", &cfg.Standby},
+			{"spill_pct", &cfg.SpillPct}, {"spill_threshold_pct", &cfg.SpillThresholdPct}} {
+			s := r.FormValue(f.name)
+			if s == "" {
+				continue
+			}
+			v, err := strconv.ParseUint(s, 10, 32)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid %s: %v", f.name, err), http.StatusBadRequest)
+				return
+			}
+			*f.dst = uint32(v)
+		}
+		if s := r.FormValue("timeout"); s != "" {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid timeout: %v", err), http.StatusBadRequest)
+				return
+			}
+			cfg.HeartbeatTimeout = d
+		}
+		if s := r.FormValue("priorities"); s == "none" {
+			cfg.Priorities = nil
+		} else if s != "" {
+			p, err := ParsePriorities(s)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			cfg.Priorities = p
+		}
+		if err := g.SetStandby(cfg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cfg, err := g.Standby()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	status, err := g.StandbyStatus()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Primary           uint32 `json:"primary"`
+		Standby           uint32 `json:"standby"`
+		HeartbeatTimeout  string `json:"heartbeat_timeout"`
+		Priorities        string `json:"priorities,omitempty"`
+		SpillPct          uint32 `json:"spill_pct"`
+		SpillThresholdPct uint32 `json:"spill_threshold_pct"`
+		Active            uint32 `json:"active"`
+		Previous          uint32 `json:"previous"`
+		Level             uint32 `json:"level,omitempty"`
+		PreviousLevel     uint32 `json:"previous_level,omitempty"`
+		Switches          uint64 `json:"switches"`
+		LastLatency       string `json:"last_switch_latency"`
+		LastSwitchAgo     string `json:"last_switch_ago"`
+	}{cfg.Primary, cfg.Standby, cfg.HeartbeatTimeout.String(), FormatPriorities(cfg.Priorities),
+		cfg.SpillPct, cfg.SpillThresholdPct, status.Active, status.Previous, status.Level,
+		status.PreviousLevel, status.Switches, status.Latency.String(), status.Since.String()})
+}
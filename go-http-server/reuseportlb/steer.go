@@ -0,0 +1,241 @@
+package reuseportlb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+)
+
+// maxSteerTenants is the size of steer_tenants (STEER_MAX_TENANTS).
+const maxSteerTenants = 64
+
+// steerLinkPin is where the group's sk_lookup link is pinned, so steering
+// outlives the process that attached it.
+const steerLinkPin = "steer_lookup_link"
+
+// SteerTenant is one tenant of the steer policy: the slots serving it and
+// the traffic that belongs to it. Tenant 0 also takes everything that
+// matches no other tenant; unless configured, it spans every slot.
+type SteerTenant struct {
+	ID        uint32 `json:"id"`
+	FirstSlot uint32 `json:"first_slot"`
+	Slots     uint32 `json:"slots"`
+	// Ports are destination ports or ranges ("9000" or "9000-9099"). The
+	// group's listeners receive connections for them even though they are
+	// bound to a different port.
+	Ports []string `json:"ports,omitempty"`
+	// SNI are server names. A client whose last TLS ClientHello named one
+	// of them has its later connections steered to this tenant.
+	SNI []string `json:"sni,omitempty"`
+}
+
+// SteerConfig is the tenant table of the steer policy, read from JSON:
+//
+//	{"tenants": [{"id": 1, "first_slot": 2, "slots": 2,
+//	              "ports": ["9000-9099"], "sni": ["b.example"]}]}
+type SteerConfig struct {
+	Tenants []SteerTenant `json:"tenants"`
+}
+
+// LoadSteerConfig reads and validates a steer config file.
+func LoadSteerConfig(path string) (SteerConfig, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return SteerConfig{}, err
+	}
+	var cfg SteerConfig
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return SteerConfig{}, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return SteerConfig{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+func (c SteerConfig) validate() error {
+	seen := make(map[uint32]bool)
+	for _, t := range c.Tenants {
+		if t.ID >= maxSteerTenants {
+			return fmt.Errorf("tenant %d: ids must be below %d", t.ID, maxSteerTenants)
+		}
+		if seen[t.ID] {
+			return fmt.Errorf("tenant %d given twice", t.ID)
+		}
+		seen[t.ID] = true
+		if t.Slots == 0 || t.FirstSlot+t.Slots > mapLayouts[TargetsMap].MaxEntries {
+			return fmt.Errorf("tenant %d: slots %d..%d out of range", t.ID, t.FirstSlot, t.FirstSlot+t.Slots-1)
+		}
+		for _, p := range t.Ports {
+			if _, _, err := parsePortRange(p); err != nil {
+				return fmt.Errorf("tenant %d: %w", t.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+func parsePortRange(s string) (first, last uint16, err error) {
+	lo, hi, isRange := strings.Cut(s, "-")
+	a, err := strconv.ParseUint(lo, 10, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port %q", s)
+	}
+	b := a
+	if isRange {
+		if b, err = strconv.ParseUint(hi, 10, 16); err != nil || b < a {
+			return 0, 0, fmt.Errorf("invalid port range %q", s)
+		}
+	}
+	return uint16(a), uint16(b), nil
+}
+
+// TenantForSNI returns the tenant that serves name.
+func (c SteerConfig) TenantForSNI(name string) (uint32, bool) {
+	for _, t := range c.Tenants {
+		for _, s := range t.SNI {
+			if strings.EqualFold(s, name) {
+				return t.ID, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// ApplySteerConfig replaces the group's tenant table and port mappings.
+func (g Group) ApplySteerConfig(cfg SteerConfig) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	tenants, err := g.openAudited(SteerTenantsMap)
+	if err != nil {
+		return err
+	}
+	defer tenants.Close()
+	ports, err := g.openAudited(SteerPortsMap)
+	if err != nil {
+		return err
+	}
+	defer ports.Close()
+
+	table := make([]steerSteerTenant, maxSteerTenants)
+	table[0] = steerSteerTenant{FirstSlot: 0, Nslots: mapLayouts[TargetsMap].MaxEntries}
+	portTenant := make(map[uint16]uint32)
+	for _, t := range cfg.Tenants {
+		table[t.ID] = steerSteerTenant{FirstSlot: t.FirstSlot, Nslots: t.Slots}
+		for _, p := range t.Ports {
+			first, last, _ := parsePortRange(p)
+			for port := uint32(first); port <= uint32(last); port++ {
+				portTenant[uint16(port)] = t.ID
+			}
+		}
+	}
+	if n := len(portTenant); n > int(mapLayouts[SteerPortsMap].MaxEntries) {
+		return fmt.Errorf("%d steered ports, at most %d are supported", n, mapLayouts[SteerPortsMap].MaxEntries)
+	}
+
+	for id := range table {
+		k := uint32(id)
+		if err := tenants.Update(&k, &table[id], ebpf.UpdateAny); err != nil {
+			return fmt.Errorf("update %s: %w", SteerTenantsMap, err)
+		}
+	}
+	// Drop ports that are no longer steered, then write the current ones.
+	var port uint16
+	var tenant uint32
+	var stale []uint16
+	iter := ports.Iterate()
+	for iter.Next(&port, &tenant) {
+		if _, ok := portTenant[port]; !ok {
+			stale = append(stale, port)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return fmt.Errorf("iterate %s: %w", SteerPortsMap, err)
+	}
+	for _, p := range stale {
+		if err := ports.Delete(&p); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			return fmt.Errorf("delete from %s: %w", SteerPortsMap, err)
+		}
+	}
+	for p, t := range portTenant {
+		if err := ports.Update(&p, &t, ebpf.UpdateAny); err != nil {
+			return fmt.Errorf("update %s: %w", SteerPortsMap, err)
+		}
+	}
+	return nil
+}
+
+// RecordClientTenant steers later connections from addr to tenant. Servers
+// call it with the tenant of the SNI in a client's ClientHello. Only IPv4
+// clients are tracked.
+func (g Group) RecordClientTenant(addr netip.Addr, tenant uint32) error {
+	addr = addr.Unmap()
+	if !addr.Is4() {
+		return nil
+	}
+	m, err := g.openAudited(SteerClientsMap)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	key := addr.As4()
+	if err := m.Update(&key, &tenant, ebpf.UpdateAny); err != nil {
+		return fmt.Errorf("update %s: %w", SteerClientsMap, err)
+	}
+	return nil
+}
+
+// addSteerListener offers the listener in slot to steer_lookup, if the group
+// runs the steer policy. The sockmap drops the socket by itself once it is
+// closed.
+func (g Group) addSteerListener(slot uint32, fd int) error {
+	if _, err := os.Stat(g.PinnedMapPath(SteerListenerMap)); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	v := uint64(fd)
+	return g.updatePinned(SteerListenerMap, &slot, &v)
+}
+
+// steerLinkPath returns where the group's sk_lookup link is pinned.
+func (g Group) steerLinkPath() string {
+	return filepath.Join(g.PinDir(), steerLinkPin)
+}
+
+// attachSteerLookup attaches prog to this network namespace, replacing the
+// program of a link pinned by an earlier load.
+func (g Group) attachSteerLookup(prog *ebpf.Program) error {
+	path := g.steerLinkPath()
+	if l, err := link.LoadPinnedLink(path, nil); err == nil {
+		defer l.Close()
+		if err := l.Update(prog); err != nil {
+			return fmt.Errorf("update pinned sk_lookup link: %w", err)
+		}
+		g.journal(EventLink, prog, "")
+		return nil
+	}
+
+	ns, err := os.Open("/proc/self/ns/net")
+	if err != nil {
+		return err
+	}
+	defer ns.Close()
+	l, err := link.AttachNetNs(int(ns.Fd()), prog)
+	if err != nil {
+		return fmt.Errorf("attach sk_lookup: %w", err)
+	}
+	defer l.Close()
+	if err := l.Pin(path); err != nil {
+		return fmt.Errorf("pin sk_lookup link: %w", err)
+	}
+	g.journal(EventLink, prog, "")
+	return nil
+}
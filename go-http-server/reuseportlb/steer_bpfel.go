@@ -0,0 +1,208 @@
+// Code generated by bpf2go; DO NOT EDIT.
+//go:build 386 || amd64 || arm || arm64 || loong64 || mips64le || mipsle || ppc64le || riscv64
+
+package reuseportlb
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+
+	"github.com/cilium/ebpf"
+)
+
+type steerRatelimitCfg struct {
+	Enabled     uint32
+	Action      uint32
+	PenaltySlot uint32
+	MaxConns    uint32
+	WindowNs    uint64
+}
+
+type steerShadowState struct {
+	Phase     uint32
+	Candidate uint32
+}
+
+type steerSlotBucket struct {
+	Rate      uint64
+	Burst     uint64
+	Action    uint32
+	Pad       uint32
+	Tokens    uint64
+	LastNs    uint64
+	Throttled uint64
+}
+
+type steerSlotOverride struct {
+	Slot uint32
+	Hits uint32
+}
+
+type steerSlotWarmup struct {
+	StartNs  uint64
+	WindowNs uint64
+}
+
+type steerSrcRate struct {
+	WindowStart uint64
+	Count       uint32
+	Limited     uint32
+}
+
+type steerSteerTenant struct {
+	FirstSlot uint32
+	Nslots    uint32
+}
+
+// loadSteer returns the embedded CollectionSpec for steer.
+func loadSteer() (*ebpf.CollectionSpec, error) {
+	reader := bytes.NewReader(_SteerBytes)
+	spec, err := ebpf.LoadCollectionSpecFromReader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("can't load steer: %w", err)
+	}
+
+	return spec, err
+}
+
+// loadSteerObjects loads steer and converts it into a struct.
+//
+// The following types are suitable as obj argument:
+//
+//	*steerObjects
+//	*steerPrograms
+//	*steerMaps
+//
+// See ebpf.CollectionSpec.LoadAndAssign documentation for details.
+func loadSteerObjects(obj interface{}, opts *ebpf.CollectionOptions) error {
+	spec, err := loadSteer()
+	if err != nil {
+		return err
+	}
+
+	return spec.LoadAndAssign(obj, opts)
+}
+
+// steerSpecs contains maps and programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type steerSpecs struct {
+	steerProgramSpecs
+	steerMapSpecs
+}
+
+// steerSpecs contains programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type steerProgramSpecs struct {
+	SteerLookup   *ebpf.ProgramSpec `ebpf:"steer_lookup"`
+	SteerSelector *ebpf.ProgramSpec `ebpf:"steer_selector"`
+}
+
+// steerMapSpecs contains maps before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type steerMapSpecs struct {
+	RatelimitCfg        *ebpf.MapSpec `ebpf:"ratelimit_cfg"`
+	ShadowEvents        *ebpf.MapSpec `ebpf:"shadow_events"`
+	ShadowProgs         *ebpf.MapSpec `ebpf:"shadow_progs"`
+	ShadowScratch       *ebpf.MapSpec `ebpf:"shadow_scratch"`
+	SlotBucket          *ebpf.MapSpec `ebpf:"slot_bucket"`
+	SlotDropping        *ebpf.MapSpec `ebpf:"slot_dropping"`
+	SlotOverride        *ebpf.MapSpec `ebpf:"slot_override"`
+	SlotSelected        *ebpf.MapSpec `ebpf:"slot_selected"`
+	SlotWarmup          *ebpf.MapSpec `ebpf:"slot_warmup"`
+	SrcRate             *ebpf.MapSpec `ebpf:"src_rate"`
+	SteerClients        *ebpf.MapSpec `ebpf:"steer_clients"`
+	SteerListener       *ebpf.MapSpec `ebpf:"steer_listener"`
+	SteerPorts          *ebpf.MapSpec `ebpf:"steer_ports"`
+	SteerTenants        *ebpf.MapSpec `ebpf:"steer_tenants"`
+	TcpBalancingTargets *ebpf.MapSpec `ebpf:"tcp_balancing_targets"`
+}
+
+// steerObjects contains all objects after they have been loaded into the kernel.
+//
+// It can be passed to loadSteerObjects or ebpf.CollectionSpec.LoadAndAssign.
+type steerObjects struct {
+	steerPrograms
+	steerMaps
+}
+
+func (o *steerObjects) Close() error {
+	return _SteerClose(
+		&o.steerPrograms,
+		&o.steerMaps,
+	)
+}
+
+// steerMaps contains all maps after they have been loaded into the kernel.
+//
+// It can be passed to loadSteerObjects or ebpf.CollectionSpec.LoadAndAssign.
+type steerMaps struct {
+	RatelimitCfg        *ebpf.Map `ebpf:"ratelimit_cfg"`
+	ShadowEvents        *ebpf.Map `ebpf:"shadow_events"`
+	ShadowProgs         *ebpf.Map `ebpf:"shadow_progs"`
+	ShadowScratch       *ebpf.Map `ebpf:"shadow_scratch"`
+	SlotBucket          *ebpf.Map `ebpf:"slot_bucket"`
+	SlotDropping        *ebpf.Map `ebpf:"slot_dropping"`
+	SlotOverride        *ebpf.Map `ebpf:"slot_override"`
+	SlotSelected        *ebpf.Map `ebpf:"slot_selected"`
+	SlotWarmup          *ebpf.Map `ebpf:"slot_warmup"`
+	SrcRate             *ebpf.Map `ebpf:"src_rate"`
+	SteerClients        *ebpf.Map `ebpf:"steer_clients"`
+	SteerListener       *ebpf.Map `ebpf:"steer_listener"`
+	SteerPorts          *ebpf.Map `ebpf:"steer_ports"`
+	SteerTenants        *ebpf.Map `ebpf:"steer_tenants"`
+	TcpBalancingTargets *ebpf.Map `ebpf:"tcp_balancing_targets"`
+}
+
+func (m *steerMaps) Close() error {
+	return _SteerClose(
+		m.RatelimitCfg,
+		m.ShadowEvents,
+		m.ShadowProgs,
+		m.ShadowScratch,
+		m.SlotBucket,
+		m.SlotDropping,
+		m.SlotOverride,
+		m.SlotSelected,
+		m.SlotWarmup,
+		m.SrcRate,
+		m.SteerClients,
+		m.SteerListener,
+		m.SteerPorts,
+		m.SteerTenants,
+		m.TcpBalancingTargets,
+	)
+}
+
+// steerPrograms contains all programs after they have been loaded into the kernel.
+//
+// It can be passed to loadSteerObjects or ebpf.CollectionSpec.LoadAndAssign.
+type steerPrograms struct {
+	SteerLookup   *ebpf.Program `ebpf:"steer_lookup"`
+	SteerSelector *ebpf.Program `ebpf:"steer_selector"`
+}
+
+func (p *steerPrograms) Close() error {
+	return _SteerClose(
+		p.SteerLookup,
+		p.SteerSelector,
+	)
+}
+
+func _SteerClose(closers ...io.Closer) error {
+	for _, closer := range closers {
+		if err := closer.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Do not access this directly.
+//
+//go:embed steer_bpfel.o
+var _SteerBytes []byte
@@ -0,0 +1,361 @@
+package reuseportlb
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Packet steering modes for PlanSteering.
+const (
+	// SteerIRQ spreads the NIC's receive queues over the CPUs in hardware
+	// (RSS), one queue IRQ per CPU, and turns RPS off.
+	SteerIRQ = "irq"
+	// SteerRPS spreads packets in software: every receive queue hashes its
+	// flows over the CPUs with RPS. It is the only mode for loopback and for
+	// NICs with fewer queues than CPUs.
+	SteerRPS = "rps"
+	// SteerAuto picks SteerIRQ when the NIC has a queue IRQ per CPU and
+	// SteerRPS otherwise.
+	SteerAuto = "auto"
+)
+
+// SteeringConfig is the packet-to-CPU steering an experiment wants on one
+// interface. Policies that read per-CPU state, like cpuutil, only mean
+// something when the CPU a connection's packets are processed on is under
+// control rather than wherever irqbalance last put the NIC's interrupts.
+type SteeringConfig struct {
+	Iface string
+	// CPUs are the CPUs packets are spread over, usually the ones the
+	// servers are pinned to.
+	CPUs []int
+	Mode string
+	// Queues, if not 0, sets the NIC's combined channel count first with
+	// ethtool -L, for SteerIRQ on NICs that start out with fewer queues.
+	Queues int
+}
+
+// SteeringStep is one setting PlanSteering wants changed: a sysfs or procfs
+// file and the value to write to it, or a command to run.
+type SteeringStep struct {
+	Path string   `json:"path,omitempty"`
+	Old  string   `json:"old,omitempty"`
+	New  string   `json:"new,omitempty"`
+	Cmd  []string `json:"cmd,omitempty"`
+}
+
+func (s SteeringStep) String() string {
+	if s.Cmd != nil {
+		return strings.Join(s.Cmd, " ")
+	}
+	return fmt.Sprintf("%s: %s -> %s", s.Path, s.Old, s.New)
+}
+
+// Where the steering settings live.
+var (
+	sysClassNet   = "/sys/class/net"
+	procIRQ       = "/proc/irq"
+	procInterrupt = "/proc/interrupts"
+)
+
+// QueueSteering is the current steering of one receive or transmit queue.
+type QueueSteering struct {
+	Queue string `json:"queue"`
+	// CPUs is rps_cpus for a receive queue and xps_cpus for a transmit
+	// queue, as a CPU list.
+	CPUs string `json:"cpus"`
+}
+
+// IRQSteering is the current affinity of one of the NIC's interrupts.
+type IRQSteering struct {
+	IRQ  int    `json:"irq"`
+	Name string `json:"name"`
+	CPUs string `json:"cpus"`
+}
+
+// SteeringState is how an interface's packets are steered right now.
+type SteeringState struct {
+	Iface string          `json:"iface"`
+	RX    []QueueSteering `json:"rx"`
+	TX    []QueueSteering `json:"tx"`
+	IRQs  []IRQSteering   `json:"irqs"`
+}
+
+// ReadSteering reports the RPS, XPS and IRQ affinity settings of iface.
+func ReadSteering(iface string) (SteeringState, error) {
+	st := SteeringState{Iface: iface}
+	rx, tx, err := queues(iface)
+	if err != nil {
+		return st, err
+	}
+	for _, q := range rx {
+		st.RX = append(st.RX, QueueSteering{q, maskToList(readTrim(filepath.Join(sysClassNet, iface, "queues", q, "rps_cpus")))})
+	}
+	for _, q := range tx {
+		st.TX = append(st.TX, QueueSteering{q, maskToList(readTrim(filepath.Join(sysClassNet, iface, "queues", q, "xps_cpus")))})
+	}
+	irqs, err := ifaceIRQs(iface)
+	if err != nil {
+		return st, err
+	}
+	for _, irq := range irqs {
+		st.IRQs = append(st.IRQs, IRQSteering{irq.num, irq.name, readTrim(filepath.Join(procIRQ, strconv.Itoa(irq.num), "smp_affinity_list"))})
+	}
+	return st, nil
+}
+
+// PlanSteering works out what has to change for cfg to hold, without
+// changing anything. Settings that already hold are left out.
+func PlanSteering(cfg SteeringConfig) ([]SteeringStep, error) {
+	if len(cfg.CPUs) == 0 {
+		return nil, errors.New("steering needs at least one CPU")
+	}
+	if _, err := os.Stat(filepath.Join(sysClassNet, cfg.Iface)); err != nil {
+		return nil, fmt.Errorf("interface %s: %w", cfg.Iface, err)
+	}
+
+	var steps []SteeringStep
+	if cfg.Queues > 0 {
+		steps = append(steps, SteeringStep{Cmd: []string{"ethtool", "-L", cfg.Iface, "combined", strconv.Itoa(cfg.Queues)}})
+	}
+	rx, tx, err := queues(cfg.Iface)
+	if err != nil {
+		return nil, err
+	}
+	irqs, err := ifaceIRQs(cfg.Iface)
+	if err != nil {
+		return nil, err
+	}
+
+	mode := cfg.Mode
+	switch mode {
+	case SteerAuto, "":
+		// With a channel change pending the IRQs are about to be
+		// renumbered; trust that the caller asked for enough of them.
+		if len(irqs) >= len(cfg.CPUs) || (cfg.Queues >= len(cfg.CPUs) && len(irqs) > 0) {
+			mode = SteerIRQ
+		} else {
+			mode = SteerRPS
+		}
+	case SteerIRQ:
+		if len(irqs) == 0 && cfg.Queues == 0 {
+			return nil, fmt.Errorf("interface %s has no queue interrupts to pin; use %s", cfg.Iface, SteerRPS)
+		}
+	case SteerRPS:
+	default:
+		return nil, fmt.Errorf("unknown steering mode %q (want %s, %s or %s)", cfg.Mode, SteerAuto, SteerIRQ, SteerRPS)
+	}
+
+	set := func(path, value string) {
+		old := readTrim(path)
+		if old == "" || old == value || (strings.HasSuffix(path, "_cpus") && sameMask(old, value)) {
+			return // missing (no RPS support) or already there
+		}
+		steps = append(steps, SteeringStep{Path: path, Old: old, New: value})
+	}
+	all := cpuMask(cfg.CPUs)
+
+	for _, q := range rx {
+		rps := cpuMask(nil)
+		if mode == SteerRPS {
+			rps = all
+		}
+		set(filepath.Join(sysClassNet, cfg.Iface, "queues", q, "rps_cpus"), rps)
+	}
+	// Transmit completions go to the CPU that sent, so a server's writes
+	// do not interrupt its neighbours: queue i to CPU i, round robin.
+	for i, q := range tx {
+		set(filepath.Join(sysClassNet, cfg.Iface, "queues", q, "xps_cpus"), cpuMask([]int{cfg.CPUs[i%len(cfg.CPUs)]}))
+	}
+	if mode == SteerIRQ && cfg.Queues == 0 {
+		for i, irq := range irqs {
+			set(filepath.Join(procIRQ, strconv.Itoa(irq.num), "smp_affinity_list"), strconv.Itoa(cfg.CPUs[i%len(cfg.CPUs)]))
+		}
+	}
+	return steps, nil
+}
+
+// ApplySteering makes the changes PlanSteering planned, in order, and
+// stops at the first that fails. A running irqbalance moves the IRQs back
+// within seconds; stop it for the duration of the experiment.
+func ApplySteering(steps []SteeringStep) error {
+	for _, s := range steps {
+		if s.Cmd != nil {
+			if out, err := exec.Command(s.Cmd[0], s.Cmd[1:]...).CombinedOutput(); err != nil {
+				return fmt.Errorf("%s: %w: %s", s, err, strings.TrimSpace(string(out)))
+			}
+			continue
+		}
+		if err := os.WriteFile(s.Path, []byte(s.New+"\n"), 0); err != nil {
+			return fmt.Errorf("%s: %w", s, err)
+		}
+	}
+	return nil
+}
+
+// RevertSteering undoes steps that were applied, last first. Commands are
+// not undone.
+func RevertSteering(steps []SteeringStep) error {
+	for i := len(steps) - 1; i >= 0; i-- {
+		s := steps[i]
+		if s.Cmd != nil {
+			continue
+		}
+		if err := os.WriteFile(s.Path, []byte(s.Old+"\n"), 0); err != nil {
+			return fmt.Errorf("revert %s: %w", s.Path, err)
+		}
+	}
+	return nil
+}
+
+// IRQBalanceRunning reports whether irqbalance is running, which would
+// undo a SteerIRQ setup.
+func IRQBalanceRunning() bool {
+	dirs, _ := filepath.Glob("/proc/[0-9]*/comm")
+	for _, p := range dirs {
+		if readTrim(p) == "irqbalance" {
+			return true
+		}
+	}
+	return false
+}
+
+// queues lists the receive and transmit queues of iface, in queue order.
+func queues(iface string) (rx, tx []string, err error) {
+	entries, err := os.ReadDir(filepath.Join(sysClassNet, iface, "queues"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("interface %s: %w", iface, err)
+	}
+	for _, e := range entries {
+		switch {
+		case strings.HasPrefix(e.Name(), "rx-"):
+			rx = append(rx, e.Name())
+		case strings.HasPrefix(e.Name(), "tx-"):
+			tx = append(tx, e.Name())
+		}
+	}
+	byIndex := func(qs []string) {
+		sort.Slice(qs, func(i, j int) bool {
+			a, _ := strconv.Atoi(qs[i][3:])
+			b, _ := strconv.Atoi(qs[j][3:])
+			return a < b
+		})
+	}
+	byIndex(rx)
+	byIndex(tx)
+	return rx, tx, nil
+}
+
+type ifaceIRQ struct {
+	num  int
+	name string
+}
+
+// ifaceIRQs finds the queue interrupts of iface in /proc/interrupts, whose
+// last column names them after the interface ("eth0-TxRx-3",
+// "mlx5_comp3@pci:..." does not qualify and needs Queues plus irqbalance
+// off). Virtual interfaces like lo have none.
+func ifaceIRQs(iface string) ([]ifaceIRQ, error) {
+	f, err := os.Open(procInterrupt)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var irqs []ifaceIRQ
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		num, err := strconv.Atoi(strings.TrimSuffix(fields[0], ":"))
+		if err != nil {
+			continue
+		}
+		name := fields[len(fields)-1]
+		// Skip the interface's own non-queue interrupt (link state etc.).
+		if strings.HasPrefix(name, iface+"-") {
+			irqs = append(irqs, ifaceIRQ{num, name})
+		}
+	}
+	return irqs, sc.Err()
+}
+
+// cpuMask renders cpus as a cpumask file wants it: hex, in comma-separated
+// groups of 32 CPUs, most significant first.
+func cpuMask(cpus []int) string {
+	max := 0
+	for _, c := range cpus {
+		if c > max {
+			max = c
+		}
+	}
+	words := make([]uint32, max/32+1)
+	for _, c := range cpus {
+		words[c/32] |= 1 << (c % 32)
+	}
+	parts := make([]string, len(words))
+	for i, w := range words {
+		if i == len(words)-1 {
+			parts[len(words)-1-i] = strconv.FormatUint(uint64(w), 16)
+		} else {
+			parts[len(words)-1-i] = fmt.Sprintf("%08x", w)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// maskBits parses a cpumask file's content into the CPUs it has set.
+func maskBits(mask string) []int {
+	groups := strings.Split(mask, ",")
+	var cpus []int
+	for i := range groups {
+		w, err := strconv.ParseUint(groups[len(groups)-1-i], 16, 32)
+		if err != nil {
+			return nil
+		}
+		for b := 0; b < 32; b++ {
+			if w&(1<<b) != 0 {
+				cpus = append(cpus, i*32+b)
+			}
+		}
+	}
+	return cpus
+}
+
+func sameMask(a, b string) bool {
+	return fmt.Sprint(maskBits(a)) == fmt.Sprint(maskBits(b))
+}
+
+// maskToList renders a cpumask as a CPU list, "" for no CPUs.
+func maskToList(mask string) string {
+	cpus := maskBits(mask)
+	parts := make([]string, 0, len(cpus))
+	for i := 0; i < len(cpus); {
+		j := i
+		for j+1 < len(cpus) && cpus[j+1] == cpus[j]+1 {
+			j++
+		}
+		if i == j {
+			parts = append(parts, strconv.Itoa(cpus[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", cpus[i], cpus[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
+
+func readTrim(path string) string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
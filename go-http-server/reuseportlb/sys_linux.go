@@ -0,0 +1,150 @@
+package reuseportlb
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/cilium/ebpf"
+	"golang.org/x/sys/unix"
+)
+
+// bpffsMounted reports whether path is a bpffs mount.
+func bpffsMounted(path string) bool {
+	var statfs unix.Statfs_t
+	// Type is an int32 on 32-bit architectures, where the magic is negative.
+	return unix.Statfs(path, &statfs) == nil && uint32(statfs.Type) == unix.BPF_FS_MAGIC
+}
+
+func mountBpffs(path string) error {
+	return unix.Mount("bpffs", path, "bpf", 0, "")
+}
+
+// lockFile takes an exclusive flock on f; unlockFile releases it.
+func lockFile(f *os.File) error {
+	return unix.Flock(int(f.Fd()), unix.LOCK_EX)
+}
+
+func unlockFile(f *os.File) {
+	unix.Flock(int(f.Fd()), unix.LOCK_UN)
+}
+
+// SocketCookie returns the kernel's cookie for the socket fd, the ID the
+// maps and the connection log know a listener by.
+func SocketCookie(fd int) (uint64, error) {
+	cookie, err := unix.GetsockoptUint64(fd, unix.SOL_SOCKET, unix.SO_COOKIE)
+	if err != nil {
+		return 0, fmt.Errorf("getsockopt(SO_COOKIE): %w", err)
+	}
+	return cookie, nil
+}
+
+// tcpListen is TCP_LISTEN from include/net/tcp_states.h.
+const tcpListen = 10
+
+// listenQueue returns the accept queue of the listening socket fd: the
+// connections waiting in it and its length, the backlog listen() was given
+// capped at net.core.somaxconn. TCP_INFO reports them for a listener in
+// tcpi_unacked and tcpi_sacked.
+func listenQueue(fd int) (curr, max uint32, err error) {
+	info, err := unix.GetsockoptTCPInfo(fd, unix.IPPROTO_TCP, unix.TCP_INFO)
+	if err != nil {
+		return 0, 0, fmt.Errorf("getsockopt(TCP_INFO): %w", err)
+	}
+	if info.State != tcpListen {
+		return 0, 0, fmt.Errorf("socket is not listening (TCP state %d)", info.State)
+	}
+	return info.Unacked, info.Sacked, nil
+}
+
+// setReuseport lets the socket fd join a reuseport group when it binds.
+func setReuseport(fd int) error {
+	if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); err != nil {
+		return fmt.Errorf("setsockopt(SO_REUSEADDR): %w", err)
+	}
+	if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_REUSEPORT, 1); err != nil {
+		return fmt.Errorf("setsockopt(SO_REUSEPORT): %w", err)
+	}
+	return nil
+}
+
+// attachSelector makes prog the selector of the reuseport group the
+// listening socket fd belongs to.
+func attachSelector(fd int, prog *ebpf.Program) error {
+	return unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_ATTACH_REUSEPORT_EBPF, prog.FD())
+}
+
+// monotonicNow reads CLOCK_MONOTONIC, the clock bpf_ktime_get_ns uses.
+func monotonicNow() (uint64, error) {
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		return 0, err
+	}
+	return uint64(ts.Nano()), nil
+}
+
+// gettid returns the ID of the calling thread.
+func gettid() int { return unix.Gettid() }
+
+// cpuAffinity returns the CPUs pid may run on, of the first maxOwnerCPUs.
+func cpuAffinity(pid int) ([]int, error) {
+	var set unix.CPUSet
+	if err := unix.SchedGetaffinity(pid, &set); err != nil {
+		return nil, err
+	}
+	var cpus []int
+	for cpu := 0; cpu < maxOwnerCPUs; cpu++ {
+		if set.IsSet(cpu) {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
+
+func peerPID(conn *net.UnixConn) (int, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var cred *unix.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	}); err != nil {
+		return 0, err
+	}
+	if credErr != nil {
+		return 0, credErr
+	}
+	return int(cred.Pid), nil
+}
+
+// rightsSpace is the control message space one passed descriptor takes.
+var rightsSpace = unix.CmsgSpace(4)
+
+func unixRights(fd int) []byte {
+	return unix.UnixRights(fd)
+}
+
+func parseRights(oob []byte) ([]int, error) {
+	if len(oob) == 0 {
+		return nil, nil
+	}
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, err
+	}
+	var fds []int
+	for _, m := range msgs {
+		rights, err := unix.ParseUnixRights(&m)
+		if err != nil {
+			continue
+		}
+		fds = append(fds, rights...)
+	}
+	return fds, nil
+}
+
+func closeFD(fd int) {
+	unix.Close(fd)
+}
@@ -0,0 +1,54 @@
+//go:build !linux
+
+package reuseportlb
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+
+	"github.com/cilium/ebpf"
+)
+
+// errNotLinux is what every eBPF and socket operation fails with off Linux.
+// The server still builds and serves with the default policy there, which is
+// enough for working on the HTTP side.
+var errNotLinux = fmt.Errorf("reuseport load balancing needs Linux, not %s: %w", runtime.GOOS, errors.ErrUnsupported)
+
+func bpffsMounted(path string) bool { return false }
+
+func mountBpffs(path string) error { return errNotLinux }
+
+func lockFile(f *os.File) error { return errNotLinux }
+
+func unlockFile(f *os.File) {}
+
+// SocketCookie returns the kernel's cookie for the socket fd. Only Linux has
+// socket cookies.
+func SocketCookie(fd int) (uint64, error) { return 0, errNotLinux }
+
+// setReuseport does nothing: without reuseport groups each socket listens
+// on its own, which is all the default policy asks for.
+func setReuseport(fd int) error { return nil }
+
+func attachSelector(fd int, prog *ebpf.Program) error { return errNotLinux }
+
+func listenQueue(fd int) (curr, max uint32, err error) { return 0, 0, errNotLinux }
+
+func monotonicNow() (uint64, error) { return 0, errNotLinux }
+
+func gettid() int { return 0 }
+
+func cpuAffinity(pid int) ([]int, error) { return nil, errNotLinux }
+
+func peerPID(conn *net.UnixConn) (int, error) { return 0, errNotLinux }
+
+var rightsSpace = 0
+
+func unixRights(fd int) []byte { return nil }
+
+func parseRights(oob []byte) ([]int, error) { return nil, errNotLinux }
+
+func closeFD(fd int) {}
@@ -0,0 +1,347 @@
+package reuseportlb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// UnixPolicies are the policies a UnixBalancer places connections with.
+// They pick among workers as their reuseport namesakes pick among slots;
+// cpuutil reads the group's slot_util, so it needs a collector (lbd, or
+// collect_stats) running for the group.
+var UnixPolicies = []string{"pickfirst", "round-robin", "cpuutil"}
+
+// Unix sockets have neither SO_REUSEPORT groups nor sk_lookup, so a
+// UnixBalancer balances in userspace: it accepts on the public socket and
+// passes each connection to a worker as SCM_RIGHTS over the worker's
+// registration connection. Workers register on a SOCK_SEQPACKET socket next
+// to the public one (UnixWorkerSocket) with one JSON unixHello; the
+// balancer answers with one unixReply, then sends a one-byte message
+// carrying a descriptor per connection.
+type unixHello struct {
+	Slot uint32 `json:"slot"`
+}
+
+type unixReply struct {
+	Error string `json:"error,omitempty"`
+}
+
+// UnixWorkerSocket is where workers of the balancer listening on path
+// register.
+func UnixWorkerSocket(path string) string { return path + ".workers" }
+
+// UnixBalancer spreads the connections to one Unix socket over worker
+// processes, with the policies of the reuseport groups.
+type UnixBalancer struct {
+	group  Group
+	policy string
+
+	mu      sync.Mutex
+	workers map[uint32]*unixWorker
+	rr      uint32
+}
+
+type unixWorker struct {
+	slot   uint32
+	pid    int
+	conn   *net.UnixConn
+	handed uint64
+}
+
+// NewUnixBalancer returns a balancer placing connections by policy. g is
+// the group whose slot_util the cpuutil policy reads and whose slot owners
+// workers are recorded as.
+func NewUnixBalancer(g Group, policy string) (*UnixBalancer, error) {
+	if !slices.Contains(UnixPolicies, policy) {
+		return nil, fmt.Errorf("invalid Unix socket policy %q: must be one of %v", policy, UnixPolicies)
+	}
+	return &UnixBalancer{group: g, policy: policy, workers: make(map[uint32]*unixWorker)}, nil
+}
+
+// ListenUnixBalancer creates the public socket at path and the worker
+// socket next to it, replacing stale ones; mode applies to both.
+func ListenUnixBalancer(path string, mode os.FileMode) (public, workers *net.UnixListener, err error) {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, nil, fmt.Errorf("remove stale socket: %w", err)
+	}
+	public, err = net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		public.Close()
+		return nil, nil, fmt.Errorf("chmod %s: %w", path, err)
+	}
+	workers, err = ListenRegistry(UnixWorkerSocket(path), mode)
+	if err != nil {
+		public.Close()
+		return nil, nil, err
+	}
+	return public, workers, nil
+}
+
+// ServeWorkers accepts worker registrations on ln until ctx is done. A
+// worker leaves the rotation when its registration connection closes.
+func (b *UnixBalancer) ServeWorkers(ctx context.Context, ln *net.UnixListener) error {
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	for {
+		conn, err := ln.AcceptUnix()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go b.handleWorker(conn)
+	}
+}
+
+func (b *UnixBalancer) handleWorker(conn *net.UnixConn) {
+	reply := func(msg string) {
+		out, _ := json.Marshal(unixReply{Error: msg})
+		conn.Write(out)
+	}
+	pid, err := peerPID(conn)
+	if err != nil {
+		slog.Error("Unix worker peer credentials unavailable", "err", err)
+		conn.Close()
+		return
+	}
+	buf := make([]byte, registryMsgSize)
+	n, err := conn.Read(buf)
+	var hello unixHello
+	if err == nil {
+		err = json.Unmarshal(buf[:n], &hello)
+	}
+	if err != nil {
+		reply(fmt.Sprintf("malformed hello: %v", err))
+		conn.Close()
+		return
+	}
+	if b.policy == "cpuutil" {
+		if err := b.group.RecordSlotOwner(hello.Slot, pid); err != nil {
+			slog.Warn("Recording Unix worker as slot owner failed, cpuutil cannot see its load", "slot", hello.Slot, "err", err)
+		}
+	}
+
+	w := &unixWorker{slot: hello.Slot, pid: pid, conn: conn}
+	b.mu.Lock()
+	if old, ok := b.workers[hello.Slot]; ok {
+		b.mu.Unlock()
+		reply(fmt.Sprintf("slot %d is taken by pid %d", hello.Slot, old.pid))
+		conn.Close()
+		return
+	}
+	b.workers[hello.Slot] = w
+	b.mu.Unlock()
+	reply("")
+	log := slog.With("slot", hello.Slot, "pid", pid)
+	log.Info("Unix worker registered")
+
+	// Workers never send again; a read returns when they go away.
+	conn.Read(buf)
+	b.remove(w)
+	log.Info("Unix worker left")
+}
+
+func (b *UnixBalancer) remove(w *unixWorker) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.workers[w.slot] == w {
+		delete(b.workers, w.slot)
+		w.conn.Close()
+	}
+}
+
+// Serve accepts connections on ln and passes each to a worker until ctx is
+// done. Connections that arrive while no worker is registered are closed.
+func (b *UnixBalancer) Serve(ctx context.Context, ln *net.UnixListener) error {
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	for {
+		conn, err := ln.AcceptUnix()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if err := b.handOff(conn); err != nil {
+			slog.Warn("Dropping Unix connection", "err", err)
+		}
+		conn.Close() // the worker holds its own descriptor now
+	}
+}
+
+// handOff passes conn to the worker the policy picks, moving on to the
+// next pick if that worker is gone.
+func (b *UnixBalancer) handOff(conn *net.UnixConn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	for {
+		w := b.pick()
+		if w == nil {
+			return errors.New("no Unix worker registered")
+		}
+		var werr error
+		if err := raw.Control(func(fd uintptr) {
+			_, _, werr = w.conn.WriteMsgUnix([]byte{'c'}, unixRights(int(fd)), nil)
+		}); err != nil {
+			return err
+		}
+		if werr == nil {
+			b.mu.Lock()
+			w.handed++
+			b.mu.Unlock()
+			return nil
+		}
+		slog.Warn("Unix worker unreachable, removing it", "slot", w.slot, "err", werr)
+		b.remove(w)
+	}
+}
+
+func (b *UnixBalancer) pick() *unixWorker {
+	var util map[uint32]uint32
+	if b.policy == "cpuutil" {
+		util = b.group.slotUtil()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.workers) == 0 {
+		return nil
+	}
+	slots := make([]uint32, 0, len(b.workers))
+	for s := range b.workers {
+		slots = append(slots, s)
+	}
+	sort.Slice(slots, func(i, j int) bool { return slots[i] < slots[j] })
+
+	switch b.policy {
+	case "pickfirst":
+		return b.workers[slots[0]]
+	case "cpuutil":
+		// Lowest utilization wins; the rotation breaks ties, so workers
+		// the collector has no reading for share evenly.
+		start := int(b.rr % uint32(len(slots)))
+		b.rr++
+		best := slots[start]
+		for i := 1; i < len(slots); i++ {
+			s := slots[(start+i)%len(slots)]
+			if util[s] < util[best] {
+				best = s
+			}
+		}
+		return b.workers[best]
+	default:
+		s := slots[b.rr%uint32(len(slots))]
+		b.rr++
+		return b.workers[s]
+	}
+}
+
+// UnixWorkerStats is how many connections a worker was handed.
+type UnixWorkerStats struct {
+	Slot   uint32 `json:"slot"`
+	PID    int    `json:"pid"`
+	Handed uint64 `json:"handed"`
+}
+
+// Workers lists the registered workers by slot.
+func (b *UnixBalancer) Workers() []UnixWorkerStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	out := make([]UnixWorkerStats, 0, len(b.workers))
+	for _, w := range b.workers {
+		out = append(out, UnixWorkerStats{Slot: w.slot, PID: w.pid, Handed: w.handed})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Slot < out[j].Slot })
+	return out
+}
+
+// unixWorkerListener is the net.Listener a worker serves on: Accept
+// returns the connections the balancer passes it.
+type unixWorkerListener struct {
+	conn *net.UnixConn
+	addr net.Addr
+}
+
+// ListenUnixWorker registers as slot with the balancer listening on path
+// and returns a listener of the connections it hands this process. Closing
+// the listener takes the worker out of the rotation.
+func ListenUnixWorker(path string, slot uint32) (net.Listener, error) {
+	ctl := UnixWorkerSocket(path)
+	conn, err := net.DialUnix("unixpacket", nil, &net.UnixAddr{Name: ctl, Net: "unixpacket"})
+	if err != nil {
+		return nil, fmt.Errorf("connect to Unix balancer at %s: %w", ctl, err)
+	}
+	hello, _ := json.Marshal(unixHello{Slot: slot})
+	if _, err := conn.Write(hello); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	buf := make([]byte, registryMsgSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("register with Unix balancer: %w", err)
+	}
+	var reply unixReply
+	if err := json.Unmarshal(buf[:n], &reply); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("register with Unix balancer: malformed reply: %w", err)
+	}
+	if reply.Error != "" {
+		conn.Close()
+		return nil, fmt.Errorf("register with Unix balancer: %s", reply.Error)
+	}
+	return &unixWorkerListener{conn: conn, addr: &net.UnixAddr{Name: path, Net: "unix"}}, nil
+}
+
+func (l *unixWorkerListener) Accept() (net.Conn, error) {
+	buf := make([]byte, 1)
+	oob := make([]byte, rightsSpace)
+	for {
+		n, oobn, _, _, err := l.conn.ReadMsgUnix(buf, oob)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil, net.ErrClosed
+			}
+			return nil, err
+		}
+		if n == 0 {
+			return nil, net.ErrClosed // the balancer went away
+		}
+		fds, err := parseRights(oob[:oobn])
+		if err != nil || len(fds) != 1 {
+			for _, fd := range fds {
+				closeFD(fd)
+			}
+			continue
+		}
+		f := os.NewFile(uintptr(fds[0]), "unix-conn")
+		c, err := net.FileConn(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+}
+
+func (l *unixWorkerListener) Close() error   { return l.conn.Close() }
+func (l *unixWorkerListener) Addr() net.Addr { return l.addr }
@@ -0,0 +1,43 @@
+package reuseportlb
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cilium/ebpf"
+)
+
+// StartWarmup starts slot's slow-start: over the next window, whatever the
+// policy, the slot takes a share of the connections placed on it that grows
+// from none to all, the rest going to the next slot that is listening, so a
+// freshly started instance is not handed its full load cold. Window 0 ends
+// any ramp the slot is in. It is meant to be called as an instance
+// registers; a slot with nowhere else to send connections keeps them.
+func (g Group) StartWarmup(slot uint32, window time.Duration) error {
+	if slot >= mapLayouts[SlotWarmupMap].MaxEntries {
+		return fmt.Errorf("slot %d out of range", slot)
+	}
+	if window < 0 {
+		return fmt.Errorf("invalid warm-up window %v", window)
+	}
+	var v slotWarmup
+	if window > 0 {
+		if err := g.requireFeature(FeatureWarmup); err != nil {
+			return err
+		}
+		now, err := monotonicNow()
+		if err != nil {
+			return err
+		}
+		v = slotWarmup{StartNs: now, WindowNs: uint64(window)}
+	}
+	m, err := g.openAudited(SlotWarmupMap)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	if err := m.Update(&slot, &v, ebpf.UpdateAny); err != nil {
+		return fmt.Errorf("write %s: %w", SlotWarmupMap, err)
+	}
+	return nil
+}
@@ -0,0 +1,300 @@
+package reuseportlb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"go-http-server/stats"
+)
+
+// SelectionCounts returns the connections each slot has been given since
+// the group's selector was first loaded, from slot_selected.
+func (g Group) SelectionCounts() (map[uint32]uint64, error) {
+	if err := g.requireFeature(FeatureCounts); err != nil {
+		return nil, err
+	}
+	m, err := g.OpenPinnedMap(SlotSelectedMap)
+	if err != nil {
+		return nil, err
+	}
+	defer m.Close()
+
+	out := make(map[uint32]uint64)
+	var (
+		slot   uint32
+		perCPU []uint64
+	)
+	iter := m.Iterate()
+	for iter.Next(&slot, &perCPU) {
+		var n uint64
+		for _, v := range perCPU {
+			n += v
+		}
+		if n > 0 {
+			out[slot] = n
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("iterate %s: %w", SlotSelectedMap, err)
+	}
+	return out, nil
+}
+
+// listeningSlots returns the slots with a socket in the group's targets map.
+func (g Group) listeningSlots() ([]uint32, error) {
+	m, err := g.OpenPinnedMap(TargetsMap)
+	if err != nil {
+		return nil, err
+	}
+	defer m.Close()
+	var slots []uint32
+	for slot := uint32(0); slot < m.MaxEntries(); slot++ {
+		var cookie uint64
+		if err := m.Lookup(&slot, &cookie); err == nil {
+			slots = append(slots, slot)
+		}
+	}
+	return slots, nil
+}
+
+// DefaultWatchdogInterval is how often a Watchdog reads the counters.
+const DefaultWatchdogInterval = time.Second
+
+// WatchdogConfig sets when a Watchdog alerts.
+type WatchdogConfig struct {
+	// Interval is how often the counters are read; each reading judges the
+	// connections placed since the one before.
+	Interval time.Duration
+	// MaxSkew is how far from an even share any listening slot may get, as
+	// a fraction of the mean: 0.5 allows between half and one and a half
+	// times it. Policies that are meant to be uneven (pickfirst,
+	// hot-standby, splitter, steer) need a bound of their own, or none.
+	MaxSkew float64
+	// For is how long the skew has to stay over MaxSkew before the alert
+	// fires, and under it before the alert resolves.
+	For time.Duration
+	// MinConns is the fewest connections an interval needs to be judged;
+	// quieter intervals leave the state as it is.
+	MinConns uint64
+	// Webhook, if set, is POSTed every alert and resolution as JSON.
+	Webhook string
+}
+
+// WatchdogAlert is what a Watchdog logs and posts when its alert fires or
+// resolves.
+type WatchdogAlert struct {
+	Group   string  `json:"group"`
+	Policy  string  `json:"policy"`
+	State   string  `json:"state"` // firing or resolved
+	Skew    float64 `json:"skew"`
+	MaxSkew float64 `json:"max_skew"`
+	// Since is when the skew crossed the bound.
+	Since time.Time `json:"since"`
+	// Counts are the connections each listening slot got in the last
+	// interval.
+	Counts map[uint32]uint64 `json:"counts"`
+}
+
+// WatchdogStatus is the watchdog's state for the control API.
+type WatchdogStatus struct {
+	Skew    float64           `json:"skew"`
+	MaxSkew float64           `json:"max_skew"`
+	Firing  bool              `json:"firing"`
+	Counts  map[uint32]uint64 `json:"counts"`
+	Checked time.Time         `json:"checked"`
+}
+
+// Watchdog watches how evenly a group's selector spreads connections over
+// its listening slots, and alerts when one slot has been getting far more
+// or far less than its share for a while: the sign of a policy gone quietly
+// wrong, such as a stale map entry steering everything to one instance.
+type Watchdog struct {
+	group  Group
+	policy string
+	cfg    WatchdogConfig
+	client *http.Client
+
+	prev map[uint32]uint64
+	// over is when the skew went over the bound, under when it went back
+	// under while firing; zero otherwise.
+	over, under time.Time
+
+	mu     sync.Mutex
+	status WatchdogStatus
+}
+
+// NewWatchdog returns a watchdog for the group running policy.
+func (g Group) NewWatchdog(policy string, cfg WatchdogConfig) *Watchdog {
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultWatchdogInterval
+	}
+	return &Watchdog{
+		group:  g,
+		policy: policy,
+		cfg:    cfg,
+		client: &http.Client{Timeout: 5 * time.Second},
+		status: WatchdogStatus{MaxSkew: cfg.MaxSkew},
+	}
+}
+
+// Status returns the result of the last check.
+func (w *Watchdog) Status() WatchdogStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.status
+}
+
+// Run checks the group every Interval until ctx is done. A selector
+// without slot_selected leaves it nothing to watch, which is logged once.
+func (w *Watchdog) Run(ctx context.Context) error {
+	ticker := time.NewTicker(w.cfg.Interval)
+	defer ticker.Stop()
+	failing := false
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		alert, err := w.check(time.Now())
+		if errors.Is(err, os.ErrNotExist) {
+			slog.Warn("Selector keeps no selection counters, watchdog stopped", "group", w.group.String(), "policy", w.policy)
+			return nil
+		}
+		if err != nil && !failing {
+			slog.Warn("Watchdog check failed", "group", w.group.String(), "err", err)
+		}
+		failing = err != nil
+		if alert != nil {
+			w.notify(ctx, alert)
+		}
+	}
+}
+
+// check reads the counters and returns an alert if the state changed.
+func (w *Watchdog) check(now time.Time) (*WatchdogAlert, error) {
+	counts, err := w.group.SelectionCounts()
+	if err != nil {
+		return nil, err
+	}
+	listening, err := w.group.listeningSlots()
+	if err != nil {
+		return nil, err
+	}
+	prev := w.prev
+	w.prev = counts
+	if prev == nil {
+		return nil, nil
+	}
+
+	delta := make(map[uint32]uint64, len(listening))
+	shares := make([]float64, 0, len(listening))
+	var total uint64
+	for _, slot := range listening {
+		n := counts[slot]
+		// A counter that went backwards belongs to a reloaded selector.
+		if p := prev[slot]; p <= n {
+			n -= p
+		}
+		delta[slot] = n
+		shares = append(shares, float64(n))
+		total += n
+	}
+	if total < max(w.cfg.MinConns, 1) {
+		return nil, nil
+	}
+	skew := stats.MaxSkew(shares)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.status.Skew, w.status.Counts, w.status.Checked = skew, delta, now
+	alert := func(state string) *WatchdogAlert {
+		return &WatchdogAlert{
+			Group: w.group.String(), Policy: w.policy, State: state,
+			Skew: skew, MaxSkew: w.cfg.MaxSkew, Since: w.over, Counts: delta,
+		}
+	}
+	if skew > w.cfg.MaxSkew {
+		w.under = time.Time{}
+		if w.over.IsZero() {
+			w.over = now
+		}
+		if !w.status.Firing && now.Sub(w.over) >= w.cfg.For {
+			w.status.Firing = true
+			return alert("firing"), nil
+		}
+		return nil, nil
+	}
+	if !w.status.Firing {
+		w.over = time.Time{}
+		return nil, nil
+	}
+	if w.under.IsZero() {
+		w.under = now
+	}
+	if now.Sub(w.under) < w.cfg.For {
+		return nil, nil
+	}
+	w.status.Firing = false
+	a := alert("resolved")
+	w.over, w.under = time.Time{}, time.Time{}
+	return a, nil
+}
+
+// notify logs the alert and posts it to the webhook.
+func (w *Watchdog) notify(ctx context.Context, a *WatchdogAlert) {
+	slots := make([]uint32, 0, len(a.Counts))
+	for slot := range a.Counts {
+		slots = append(slots, slot)
+	}
+	sort.Slice(slots, func(i, j int) bool { return slots[i] < slots[j] })
+	counts := make([]uint64, len(slots))
+	for i, slot := range slots {
+		counts[i] = a.Counts[slot]
+	}
+	args := []any{"group", a.Group, "policy", a.Policy, "skew", fmt.Sprintf("%.2f", a.Skew),
+		"max_skew", a.MaxSkew, "since", a.Since.Format(time.RFC3339), "slots", slots, "conns", counts}
+	if a.State == "firing" {
+		slog.Error("Selection skew over bound", args...)
+	} else {
+		slog.Info("Selection skew back within bound", args...)
+	}
+	if w.cfg.Webhook == "" {
+		return
+	}
+	if err := postJSON(ctx, w.client, w.cfg.Webhook, a); err != nil {
+		slog.Warn("Watchdog webhook failed", "err", err)
+	}
+}
+
+// postJSON POSTs v as JSON to url, for the alerts of the watchdog and the
+// outlier detector.
+func postJSON(ctx context.Context, client *http.Client, url string, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook refused the alert: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,391 @@
+package reuseportlb
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+)
+
+// XDP balancer forwarding modes, as in enum xlb_mode in eBPF/xdplb.c.
+const (
+	// XDPModeDNAT rewrites the destination to the backend, which must route
+	// its replies back through the balancer.
+	XDPModeDNAT = "dnat"
+	// XDPModeDSR forwards on L2 only; backends hold the VIP themselves and
+	// answer clients directly.
+	XDPModeDSR = "dsr"
+)
+
+// XDPPolicies are the policies the XDP balancer places flows with. They
+// pick among backends as their reuseport namesakes pick among slots:
+// round-robin rotates, least-load takes the backend with the lowest load
+// published with SetLoad, on slot_util's utilization * 100 scale.
+var XDPPolicies = []string{"round-robin", "least-load"}
+
+// xdpMaxBackends is XLB_MAX_BACKENDS.
+const xdpMaxBackends = 64
+
+// Entries of xlb_stats, as in enum xlb_stat.
+const (
+	xdpStatNew = iota
+	xdpStatForwarded
+	xdpStatReturned
+	xdpStatNoBackend
+	xdpStatKernel
+)
+
+// XDPConfig is what the XDP balancer balances and how.
+type XDPConfig struct {
+	// Iface is the interface clients reach VIP through.
+	Iface    string
+	VIP      netip.AddrPort
+	Mode     string
+	Policy   string
+	Backends []netip.Addr
+	// Generic attaches in the kernel's generic XDP mode, for drivers
+	// without native XDP support and veth pairs in experiments.
+	Generic bool
+}
+
+// XDPBalancer balances the TCP flows to a VIP across backend hosts from an
+// XDP program, extending the policies of the reuseport groups, which only
+// see the sockets of one machine, to several. Its maps belong to the process
+// and go away with it; flows it placed then fall back to the kernel.
+type XDPBalancer struct {
+	mu   sync.Mutex
+	cfg  XDPConfig
+	objs xdplbObjects
+	link link.Link
+	// backends is xlb_backends by index; a removed backend leaves an
+	// invalid Addr, so the others keep their index and published load.
+	backends []netip.Addr
+}
+
+// StartXDPBalancer loads the XDP balancer and attaches it to cfg.Iface.
+// Close detaches it.
+func StartXDPBalancer(cfg XDPConfig) (*XDPBalancer, error) {
+	if !cfg.VIP.Addr().Is4() {
+		return nil, fmt.Errorf("VIP %s: the XDP balancer is IPv4 only", cfg.VIP)
+	}
+	mode, err := xdpMode(cfg.Mode)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := xdpPolicy(cfg.Policy)
+	if err != nil {
+		return nil, err
+	}
+	iface, err := net.InterfaceByName(cfg.Iface)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &XDPBalancer{cfg: cfg}
+	if err := loadXdplbObjects(&b.objs, nil); err != nil {
+		return nil, fmt.Errorf("load XDP balancer: %w", err)
+	}
+	if err := b.SetBackends(cfg.Backends); err != nil {
+		b.objs.Close()
+		return nil, err
+	}
+	xcfg := xdplbXlbCfg{
+		Vip:    addrWire(cfg.VIP.Addr()),
+		Port:   portWire(cfg.VIP.Port()),
+		Mode:   mode,
+		Policy: policy,
+	}
+	b.mu.Lock()
+	xcfg.Backends = uint32(len(b.backends))
+	b.mu.Unlock()
+	if err := b.objs.XlbConfig.Update(uint32(0), xcfg, ebpf.UpdateAny); err != nil {
+		b.objs.Close()
+		return nil, fmt.Errorf("configure XDP balancer: %w", err)
+	}
+
+	flags := link.XDPDriverMode
+	if cfg.Generic {
+		flags = link.XDPGenericMode
+	}
+	b.link, err = link.AttachXDP(link.XDPOptions{Program: b.objs.XlbXdp, Interface: iface.Index, Flags: flags})
+	if err != nil {
+		b.objs.Close()
+		return nil, fmt.Errorf("attach XDP balancer to %s: %w", cfg.Iface, err)
+	}
+	return b, nil
+}
+
+// Close detaches the balancer and unloads it.
+func (b *XDPBalancer) Close() error {
+	return errors.Join(b.link.Close(), b.objs.Close())
+}
+
+// Config returns the configuration the balancer runs with; Backends is the
+// current backend set.
+func (b *XDPBalancer) Config() XDPConfig {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	cfg := b.cfg
+	cfg.Backends = nil
+	for _, a := range b.backends {
+		if a.IsValid() {
+			cfg.Backends = append(cfg.Backends, a)
+		}
+	}
+	return cfg
+}
+
+// SetBackends replaces the backend set. Backends that stay keep their index
+// and so their load and counters; flows already placed stay on their
+// backend, even a removed one, until they end.
+func (b *XDPBalancer) SetBackends(addrs []netip.Addr) error {
+	for _, a := range addrs {
+		if !a.Is4() {
+			return fmt.Errorf("backend %s: the XDP balancer is IPv4 only", a)
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	next := slices.Clone(b.backends)
+	for i, a := range next {
+		if !slices.Contains(addrs, a) {
+			next[i] = netip.Addr{}
+		}
+	}
+	for _, a := range addrs {
+		if slices.Contains(next, a) {
+			continue
+		}
+		if i := slices.Index(next, netip.Addr{}); i >= 0 {
+			next[i] = a
+		} else {
+			next = append(next, a)
+		}
+	}
+	if len(next) > xdpMaxBackends {
+		return fmt.Errorf("%d backends, the XDP balancer takes at most %d", len(next), xdpMaxBackends)
+	}
+
+	for i, a := range next {
+		if i < len(b.backends) && b.backends[i] == a {
+			continue
+		}
+		v := xdplbXlbBackend{}
+		if a.IsValid() {
+			v.Addr = addrWire(a)
+		}
+		if err := b.objs.XlbBackends.Update(uint32(i), v, ebpf.UpdateAny); err != nil {
+			return fmt.Errorf("set backend %d: %w", i, err)
+		}
+	}
+	// Trailing removed entries need not be probed at all.
+	for len(next) > 0 && !next[len(next)-1].IsValid() {
+		next = next[:len(next)-1]
+	}
+	var xcfg xdplbXlbCfg
+	if err := b.objs.XlbConfig.Lookup(uint32(0), &xcfg); err != nil {
+		return fmt.Errorf("read XDP balancer config: %w", err)
+	}
+	if xcfg.Vip != 0 {
+		xcfg.Backends = uint32(len(next))
+		if err := b.objs.XlbConfig.Update(uint32(0), xcfg, ebpf.UpdateAny); err != nil {
+			return fmt.Errorf("configure XDP balancer: %w", err)
+		}
+	}
+	b.backends = next
+	return nil
+}
+
+// SetLoad publishes the load of a backend for the least-load policy, as its
+// utilization * 100.
+func (b *XDPBalancer) SetLoad(addr netip.Addr, load uint32) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	i := slices.Index(b.backends, addr)
+	if i < 0 || !addr.IsValid() {
+		return fmt.Errorf("%s is not a backend", addr)
+	}
+	var v xdplbXlbBackend
+	if err := b.objs.XlbBackends.Lookup(uint32(i), &v); err != nil {
+		return fmt.Errorf("read backend %d: %w", i, err)
+	}
+	// Conns moves under us; a lost increment here is a lost count, not a
+	// lost flow.
+	v.Load = load
+	if err := b.objs.XlbBackends.Update(uint32(i), v, ebpf.UpdateExist); err != nil {
+		return fmt.Errorf("set backend %d: %w", i, err)
+	}
+	return nil
+}
+
+// XDPBackendStats is one backend as the balancer sees it.
+type XDPBackendStats struct {
+	Addr  netip.Addr `json:"addr"`
+	Load  uint32     `json:"load"`
+	Conns uint64     `json:"conns"`
+}
+
+// XDPStats counts what the balancer did with the packets it saw.
+type XDPStats struct {
+	// New is the flows placed on a backend, NoBackend the SYNs dropped for
+	// want of one.
+	New       uint64 `json:"new"`
+	NoBackend uint64 `json:"no_backend"`
+	// Forwarded and Returned count packets sent to backends and, in DNAT
+	// mode, replies sent back to clients; Kernel those left to the kernel
+	// for want of a resolved next hop.
+	Forwarded uint64            `json:"forwarded"`
+	Returned  uint64            `json:"returned"`
+	Kernel    uint64            `json:"kernel"`
+	Backends  []XDPBackendStats `json:"backends"`
+}
+
+// Stats reads the balancer's counters.
+func (b *XDPBalancer) Stats() (XDPStats, error) {
+	var st XDPStats
+	counters := []*uint64{&st.New, &st.Forwarded, &st.Returned, &st.NoBackend, &st.Kernel}
+	for i, c := range counters {
+		var perCPU []uint64
+		if err := b.objs.XlbStats.Lookup(uint32(i), &perCPU); err != nil {
+			return st, fmt.Errorf("read xlb_stats: %w", err)
+		}
+		for _, n := range perCPU {
+			*c += n
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	st.Backends = []XDPBackendStats{}
+	for i, a := range b.backends {
+		if !a.IsValid() {
+			continue
+		}
+		var v xdplbXlbBackend
+		if err := b.objs.XlbBackends.Lookup(uint32(i), &v); err != nil {
+			return st, fmt.Errorf("read backend %d: %w", i, err)
+		}
+		st.Backends = append(st.Backends, XDPBackendStats{Addr: a, Load: v.Load, Conns: v.Conns})
+	}
+	return st, nil
+}
+
+// ServeHTTP is the balancer's control handler. GET returns its config and
+// stats. POST with backends=a,b,... replaces the backend set, and with
+// load=addr=value,... publishes backend loads for least-load; either or
+// both may be given.
+func (b *XDPBalancer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if s := r.FormValue("backends"); s != "" {
+			addrs, err := ParseBackends(s)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := b.SetBackends(addrs); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if s := r.FormValue("load"); s != "" {
+			for _, pair := range strings.Split(s, ",") {
+				addrStr, loadStr, ok := strings.Cut(pair, "=")
+				addr, aerr := netip.ParseAddr(addrStr)
+				load, lerr := strconv.ParseUint(loadStr, 10, 32)
+				if !ok || aerr != nil || lerr != nil {
+					http.Error(w, fmt.Sprintf("invalid load %q: want addr=value", pair), http.StatusBadRequest)
+					return
+				}
+				if err := b.SetLoad(addr, uint32(load)); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	st, err := b.Stats()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	cfg := b.Config()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Iface  string   `json:"iface"`
+		VIP    string   `json:"vip"`
+		Mode   string   `json:"mode"`
+		Policy string   `json:"policy"`
+		Stats  XDPStats `json:"stats"`
+	}{cfg.Iface, cfg.VIP.String(), cfg.Mode, cfg.Policy, st})
+}
+
+// ParseBackends parses a comma-separated list of backend IPv4 addresses.
+func ParseBackends(s string) ([]netip.Addr, error) {
+	var addrs []netip.Addr
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		a, err := netip.ParseAddr(f)
+		if err != nil {
+			return nil, fmt.Errorf("invalid backend %q: %v", f, err)
+		}
+		if !a.Is4() {
+			return nil, fmt.Errorf("backend %s: the XDP balancer is IPv4 only", a)
+		}
+		if !slices.Contains(addrs, a) {
+			addrs = append(addrs, a)
+		}
+	}
+	return addrs, nil
+}
+
+func xdpMode(s string) (uint32, error) {
+	switch s {
+	case XDPModeDNAT:
+		return 0, nil
+	case XDPModeDSR:
+		return 1, nil
+	}
+	return 0, fmt.Errorf("invalid XDP mode %q: must be %s or %s", s, XDPModeDNAT, XDPModeDSR)
+}
+
+func xdpPolicy(s string) (uint32, error) {
+	i := slices.Index(XDPPolicies, s)
+	if i < 0 {
+		return 0, fmt.Errorf("invalid XDP policy %q: must be one of %v", s, XDPPolicies)
+	}
+	return uint32(i), nil
+}
+
+// addrWire is a's bytes as the program reads them out of a packet.
+func addrWire(a netip.Addr) uint32 {
+	b := a.As4()
+	return binary.NativeEndian.Uint32(b[:])
+}
+
+// portWire is port as the program reads it out of a TCP header.
+func portWire(port uint16) uint16 {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], port)
+	return binary.NativeEndian.Uint16(b[:])
+}
@@ -0,0 +1,153 @@
+// Code generated by bpf2go; DO NOT EDIT.
+//go:build mips || mips64 || ppc64 || s390x
+
+package reuseportlb
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+
+	"github.com/cilium/ebpf"
+)
+
+type xdplbXlbBackend struct {
+	Addr  uint32
+	Load  uint32
+	Conns uint64
+}
+
+type xdplbXlbCfg struct {
+	Vip      uint32
+	Port     uint16
+	Pad      uint16
+	Mode     uint32
+	Policy   uint32
+	Backends uint32
+	Pad2     uint32
+}
+
+type xdplbXlbFlow struct {
+	Saddr uint32
+	Sport uint16
+	Pad   uint16
+}
+
+// loadXdplb returns the embedded CollectionSpec for xdplb.
+func loadXdplb() (*ebpf.CollectionSpec, error) {
+	reader := bytes.NewReader(_XdplbBytes)
+	spec, err := ebpf.LoadCollectionSpecFromReader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("can't load xdplb: %w", err)
+	}
+
+	return spec, err
+}
+
+// loadXdplbObjects loads xdplb and converts it into a struct.
+//
+// The following types are suitable as obj argument:
+//
+//	*xdplbObjects
+//	*xdplbPrograms
+//	*xdplbMaps
+//
+// See ebpf.CollectionSpec.LoadAndAssign documentation for details.
+func loadXdplbObjects(obj interface{}, opts *ebpf.CollectionOptions) error {
+	spec, err := loadXdplb()
+	if err != nil {
+		return err
+	}
+
+	return spec.LoadAndAssign(obj, opts)
+}
+
+// xdplbSpecs contains maps and programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type xdplbSpecs struct {
+	xdplbProgramSpecs
+	xdplbMapSpecs
+}
+
+// xdplbSpecs contains programs before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type xdplbProgramSpecs struct {
+	XlbXdp *ebpf.ProgramSpec `ebpf:"xlb_xdp"`
+}
+
+// xdplbMapSpecs contains maps before they are loaded into the kernel.
+//
+// It can be passed ebpf.CollectionSpec.Assign.
+type xdplbMapSpecs struct {
+	XlbBackends *ebpf.MapSpec `ebpf:"xlb_backends"`
+	XlbConfig   *ebpf.MapSpec `ebpf:"xlb_config"`
+	XlbFlows    *ebpf.MapSpec `ebpf:"xlb_flows"`
+	XlbRr       *ebpf.MapSpec `ebpf:"xlb_rr"`
+	XlbStats    *ebpf.MapSpec `ebpf:"xlb_stats"`
+}
+
+// xdplbObjects contains all objects after they have been loaded into the kernel.
+//
+// It can be passed to loadXdplbObjects or ebpf.CollectionSpec.LoadAndAssign.
+type xdplbObjects struct {
+	xdplbPrograms
+	xdplbMaps
+}
+
+func (o *xdplbObjects) Close() error {
+	return _XdplbClose(
+		&o.xdplbPrograms,
+		&o.xdplbMaps,
+	)
+}
+
+// xdplbMaps contains all maps after they have been loaded into the kernel.
+//
+// It can be passed to loadXdplbObjects or ebpf.CollectionSpec.LoadAndAssign.
+type xdplbMaps struct {
+	XlbBackends *ebpf.Map `ebpf:"xlb_backends"`
+	XlbConfig   *ebpf.Map `ebpf:"xlb_config"`
+	XlbFlows    *ebpf.Map `ebpf:"xlb_flows"`
+	XlbRr       *ebpf.Map `ebpf:"xlb_rr"`
+	XlbStats    *ebpf.Map `ebpf:"xlb_stats"`
+}
+
+func (m *xdplbMaps) Close() error {
+	return _XdplbClose(
+		m.XlbBackends,
+		m.XlbConfig,
+		m.XlbFlows,
+		m.XlbRr,
+		m.XlbStats,
+	)
+}
+
+// xdplbPrograms contains all programs after they have been loaded into the kernel.
+//
+// It can be passed to loadXdplbObjects or ebpf.CollectionSpec.LoadAndAssign.
+type xdplbPrograms struct {
+	XlbXdp *ebpf.Program `ebpf:"xlb_xdp"`
+}
+
+func (p *xdplbPrograms) Close() error {
+	return _XdplbClose(
+		p.XlbXdp,
+	)
+}
+
+func _XdplbClose(closers ...io.Closer) error {
+	for _, closer := range closers {
+		if err := closer.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Do not access this directly.
+//
+//go:embed xdplb_bpfeb.o
+var _XdplbBytes []byte
@@ -0,0 +1,239 @@
+//go:build linux
+
+// Command rrstress measures how evenly round-robin spreads connections when
+// SYNs arrive on many CPUs at once. It starts a group of servers under the
+// round-robin policy, once with the shared counter and once with per_cpu
+// set, and has one client per CPU, each pinned to its CPU, open
+// connections as fast as it can. On loopback a SYN is handled on the CPU
+// that sent it, so the selector runs on every CPU at the same moment.
+//
+//	rrstress -instances 4 -conns 20000
+//	rrstress -modes shared -clients 2 -window 64
+//
+// Every connection asks /whoami, so the counts are what the servers saw.
+// Besides the totals it reports the worst skew over any -window
+// consecutive connections: a counter whose updates get lost or reordered
+// shows up there long before it moves the totals.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"go-http-server/launcher"
+	"go-http-server/reuseportlb"
+	"go-http-server/stats"
+)
+
+// modes are the round-robin variants compared, by their per_cpu setting.
+var modes = map[string]uint64{"shared": 0, "per-cpu": 1}
+
+type result struct {
+	Mode    string        `json:"mode"`
+	Conns   int           `json:"conns"`
+	Failed  int           `json:"failed"`
+	Elapsed time.Duration `json:"elapsed_ns"`
+	Served  []int         `json:"served"`
+	MaxSkew float64       `json:"max_skew"`
+	Jain    float64       `json:"jain_index"`
+	// ChiSquaredP is the p-value of the counts against a uniform split.
+	ChiSquaredP float64 `json:"chi_squared_p"`
+	// WindowSkew is the worst MaxSkew over -window consecutive connections.
+	WindowSkew float64 `json:"window_skew"`
+}
+
+type stress struct {
+	opts      launcher.Options
+	instances int
+	clients   int
+	conns     int
+	window    int
+	timeout   time.Duration
+}
+
+func main() {
+	var s stress
+	flag.StringVar(&s.opts.Path, "server", filepath.Join("bin", runtime.GOARCH, "server_code"), "server binary")
+	flag.StringVar(&s.opts.Addr, "addr", "127.0.0.1:8080", "address the servers share")
+	modeList := flag.String("modes", "shared,per-cpu", "comma-separated round-robin variants to run: shared (one counter) and per-cpu (per_cpu=1)")
+	flag.IntVar(&s.instances, "instances", 4, "number of servers")
+	flag.IntVar(&s.clients, "clients", runtime.NumCPU(), "concurrent clients, each pinned to a CPU of its own in turn")
+	flag.IntVar(&s.conns, "conns", 20000, "connections per variant")
+	flag.IntVar(&s.window, "window", 0, "connections over which the short-term skew is judged (default 16 per instance)")
+	flag.DurationVar(&s.timeout, "ready-timeout", 30*time.Second, "how long a server may take to join the group")
+	verbose := flag.Bool("v", false, "pass the servers' logs through")
+	asJSON := flag.Bool("json", false, "print JSON instead of a table")
+	flag.Parse()
+
+	if *verbose {
+		s.opts.Log = os.Stderr
+	}
+	if s.instances < 1 || s.clients < 1 || s.conns < 1 {
+		reuseportlb.Fatal("-instances, -clients and -conns must be positive")
+	}
+	if s.window <= 0 {
+		s.window = 16 * s.instances
+	}
+	s.opts.Policy = "round-robin"
+
+	var results []result
+	for _, mode := range strings.Split(*modeList, ",") {
+		mode = strings.TrimSpace(mode)
+		perCPU, ok := modes[mode]
+		if !ok {
+			reuseportlb.Fatal("invalid -modes: want shared or per-cpu", "mode", mode)
+		}
+		r, err := s.run(mode, perCPU)
+		if err != nil {
+			reuseportlb.Fatal("stress run failed", "mode", mode, "err", err)
+		}
+		results = append(results, r)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(results)
+		return
+	}
+	fmt.Printf("%d instances, %d clients on %d CPUs, %d connections each run\n", s.instances, s.clients, runtime.NumCPU(), s.conns)
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "mode\tconns/s\tfailed\tmax skew\tJain\tchi2 p\tskew per %d\tserved\n", s.window)
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%.0f\t%d\t%.2f%%\t%.4f\t%.3f\t%.1f%%\t%v\n", r.Mode,
+			float64(r.Conns)/r.Elapsed.Seconds(), r.Failed, 100*r.MaxSkew, r.Jain, r.ChiSquaredP, 100*r.WindowSkew, r.Served)
+	}
+	w.Flush()
+}
+
+// run starts the group with per_cpu set as given, sends the connections and
+// stops the servers again.
+func (s *stress) run(mode string, perCPU uint64) (result, error) {
+	opts := s.opts
+	opts.Args = append(opts.Args[:len(opts.Args):len(opts.Args)],
+		"-params", fmt.Sprintf("group_size=%d,per_cpu=%d", s.instances, perCPU))
+	var servers []*launcher.Server
+	defer func() {
+		for _, srv := range servers {
+			srv.Stop(15 * time.Second)
+		}
+	}()
+	for slot := 0; slot < s.instances; slot++ {
+		srv, err := launcher.Start(opts, slot)
+		if err != nil {
+			return result{}, err
+		}
+		servers = append(servers, srv)
+		if err := srv.WaitReady(s.timeout); err != nil {
+			return result{}, fmt.Errorf("%w (rerun with -v for its log)", err)
+		}
+	}
+
+	// order holds the slot of every connection in the order they were
+	// answered, -1 for a failed one.
+	order := make([]int, s.conns)
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < s.clients; i++ {
+		wg.Add(1)
+		go func(cpu int) {
+			defer wg.Done()
+			// The SYN is sent from, and on loopback handled on, the CPU the
+			// connecting thread runs on.
+			runtime.LockOSThread()
+			defer runtime.UnlockOSThread()
+			var set unix.CPUSet
+			set.Set(cpu)
+			if err := unix.SchedSetaffinity(0, &set); err != nil {
+				slog.Warn("pinning client failed", "cpu", cpu, "err", err)
+			}
+			for {
+				n := int(next.Add(1)) - 1
+				if n >= s.conns {
+					return
+				}
+				slot, err := whoami(s.opts.Addr)
+				if err != nil {
+					slot = -1
+				}
+				order[n] = slot
+			}
+		}(i % runtime.NumCPU())
+	}
+	wg.Wait()
+
+	r := result{Mode: mode, Conns: s.conns, Elapsed: time.Since(start), Served: make([]int, s.instances)}
+	for _, slot := range order {
+		if slot < 0 || slot >= s.instances {
+			r.Failed++
+			continue
+		}
+		r.Served[slot]++
+	}
+	served := stats.Float64s(r.Served)
+	r.MaxSkew = stats.MaxSkew(served)
+	r.Jain = stats.JainIndex(served)
+	_, r.ChiSquaredP = stats.ChiSquaredUniform(served)
+	r.WindowSkew = windowSkew(order, s.instances, s.window)
+	return r, nil
+}
+
+// windowSkew returns the worst MaxSkew over consecutive, non-overlapping
+// windows of n connections.
+func windowSkew(order []int, instances, n int) float64 {
+	worst := 0.0
+	counts := make([]float64, instances)
+	for lo := 0; lo+n <= len(order); lo += n {
+		clear(counts)
+		for _, slot := range order[lo : lo+n] {
+			if slot >= 0 && slot < instances {
+				counts[slot]++
+			}
+		}
+		worst = max(worst, stats.MaxSkew(counts))
+	}
+	return worst
+}
+
+// whoami opens a connection, asks which slot is serving it and closes it.
+// It dials on the calling thread, so the SYN leaves from the CPU the
+// client is pinned to.
+func whoami(addr string) (int, error) {
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := fmt.Fprintf(conn, "GET /whoami HTTP/1.1\r\nHost: %s\r\nConnection: close\r\n\r\n", addr); err != nil {
+		return 0, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	var id struct {
+		Slot int `json:"slot"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&id); err != nil {
+		return 0, fmt.Errorf("decode /whoami: %w", err)
+	}
+	return id.Slot, nil
+}
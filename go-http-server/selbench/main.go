@@ -0,0 +1,186 @@
+//go:build linux
+
+// Command selbench measures what each selector costs per SYN. For every
+// policy it starts a group of servers, has the kernel account the run time
+// of the group's selector (BPF_ENABLE_STATS), opens -conns connections and
+// divides the time the selector ran by the number of times it did.
+//
+//	selbench -instances 4 -conns 20000
+//	selbench -policies pickfirst,jsq,healthscore -clients 2
+//
+// The figure is the selector's own time, the accounting overhead of a few
+// nanoseconds included; what a smarter policy costs is its difference from
+// pickfirst, which does next to nothing. The kernel cannot run sk_reuseport
+// programs through BPF_PROG_TEST_RUN, so the SYNs are real: connections are
+// opened and closed without a request, since the selector only runs on the
+// SYN.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"go-http-server/launcher"
+	"go-http-server/reuseportlb"
+)
+
+// sizeParams are the parameters telling a policy how many slots the group
+// has; left at their default, those policies would spread connections over
+// slots that do not exist.
+var sizeParams = map[string]string{"round-robin": "group_size", "acceptqueue": "slots"}
+
+type result struct {
+	Policy  string        `json:"policy"`
+	Conns   int           `json:"conns"`
+	Failed  int           `json:"failed"`
+	Elapsed time.Duration `json:"elapsed_ns"`
+	// Runs is how often the selector ran; a SYN retransmitted or sent to
+	// a full queue runs it again.
+	Runs    uint64        `json:"runs"`
+	Runtime time.Duration `json:"runtime_ns"`
+	PerRun  time.Duration `json:"per_run_ns"`
+}
+
+type bench struct {
+	opts      launcher.Options
+	group     reuseportlb.Group
+	instances int
+	clients   int
+	conns     int
+	timeout   time.Duration
+}
+
+func main() {
+	var b bench
+	flag.StringVar(&b.opts.Path, "server", filepath.Join("bin", runtime.GOARCH, "server_code"), "server binary")
+	flag.StringVar(&b.opts.Addr, "addr", "127.0.0.1:8080", "address the servers share")
+	policyList := flag.String("policies", "pickfirst,round-robin,cpuutil,acceptqueue,jsq,healthscore,psi", "comma-separated policies to measure")
+	flag.IntVar(&b.instances, "instances", 4, "number of servers")
+	flag.IntVar(&b.clients, "clients", runtime.NumCPU(), "concurrent clients")
+	flag.IntVar(&b.conns, "conns", 20000, "connections per policy")
+	flag.DurationVar(&b.timeout, "ready-timeout", 30*time.Second, "how long a server may take to join the group")
+	verbose := flag.Bool("v", false, "pass the servers' logs through")
+	asJSON := flag.Bool("json", false, "print JSON instead of a table")
+	flag.Parse()
+
+	if *verbose {
+		b.opts.Log = os.Stderr
+	}
+	if b.instances < 1 || b.clients < 1 || b.conns < 1 {
+		reuseportlb.Fatal("-instances, -clients and -conns must be positive")
+	}
+	b.group = reuseportlb.DefaultGroup
+
+	stats, err := reuseportlb.EnableRunStats()
+	if err != nil {
+		reuseportlb.Fatal("cannot account selector run time (needs CAP_SYS_ADMIN)", "err", err)
+	}
+	defer stats.Close()
+
+	var results []result
+	for _, policy := range strings.Split(*policyList, ",") {
+		policy = strings.TrimSpace(policy)
+		if policy == "" || policy == "default" {
+			reuseportlb.Fatal("invalid -policies: the default policy runs no selector", "policy", policy)
+		}
+		r, err := b.run(policy)
+		if err != nil {
+			reuseportlb.Fatal("benchmark run failed", "policy", policy, "err", err)
+		}
+		results = append(results, r)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(results)
+		return
+	}
+	fmt.Printf("%d instances, %d clients on %d CPUs, %d connections each run\n", b.instances, b.clients, runtime.NumCPU(), b.conns)
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "policy\tconns/s\tfailed\truns\tns/SYN\tvs pickfirst\n")
+	var base time.Duration
+	for _, r := range results {
+		if r.Policy == "pickfirst" {
+			base = r.PerRun
+		}
+	}
+	for _, r := range results {
+		delta := "-"
+		if base > 0 && r.Policy != "pickfirst" {
+			delta = fmt.Sprintf("%+d", (r.PerRun - base).Nanoseconds())
+		}
+		fmt.Fprintf(w, "%s\t%.0f\t%d\t%d\t%d\t%s\n", r.Policy,
+			float64(r.Conns)/r.Elapsed.Seconds(), r.Failed, r.Runs, r.PerRun.Nanoseconds(), delta)
+	}
+	w.Flush()
+}
+
+// run starts the group under policy, opens the connections while the
+// selector's run time is accounted, and stops the servers again.
+func (b *bench) run(policy string) (result, error) {
+	opts := b.opts
+	opts.Policy = policy
+	if name, ok := sizeParams[policy]; ok {
+		opts.Args = append(opts.Args[:len(opts.Args):len(opts.Args)],
+			"-params", fmt.Sprintf("%s=%d", name, b.instances))
+	}
+	var servers []*launcher.Server
+	defer func() {
+		for _, srv := range servers {
+			srv.Stop(15 * time.Second)
+		}
+	}()
+	for slot := 0; slot < b.instances; slot++ {
+		srv, err := launcher.Start(opts, slot)
+		if err != nil {
+			return result{}, err
+		}
+		servers = append(servers, srv)
+		if err := srv.WaitReady(b.timeout); err != nil {
+			return result{}, fmt.Errorf("%w (rerun with -v for its log)", err)
+		}
+	}
+
+	before, err := b.group.SelectorRunStats()
+	if err != nil {
+		return result{}, err
+	}
+	var next, failed atomic.Int64
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < b.clients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for next.Add(1) <= int64(b.conns) {
+				conn, err := net.DialTimeout("tcp", b.opts.Addr, 5*time.Second)
+				if err != nil {
+					failed.Add(1)
+					continue
+				}
+				conn.Close()
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	after, err := b.group.SelectorRunStats()
+	if err != nil {
+		return result{}, err
+	}
+
+	ran := after.Sub(before)
+	return result{Policy: policy, Conns: b.conns, Failed: int(failed.Load()), Elapsed: elapsed,
+		Runs: ran.Runs, Runtime: ran.Runtime, PerRun: ran.PerRun()}, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// connLog writes one line per accepted and per closed connection, recording
+// which instance the selector handed the client to. Lines use the same
+// key=value format as the collector's logs:
+//
+//	ts=<RFC3339Nano> event=open client=<ip:port> slot=<n> cookie=0x<cookie>
+//
+// workloads/connlog-query.py reads these files back.
+type connLog struct {
+	f      *os.File
+	l      *log.Logger
+	slot   int
+	cookie uint64
+}
+
+func openConnLog(path string, slot int, cookie uint64) (*connLog, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open connection log: %w", err)
+	}
+	return &connLog{f: f, l: log.New(f, "", 0), slot: slot, cookie: cookie}, nil
+}
+
+// wrap returns a ConnState hook that logs opens and closes before calling next.
+func (cl *connLog) wrap(next func(net.Conn, http.ConnState)) func(net.Conn, http.ConnState) {
+	return func(c net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			cl.record("open", c.RemoteAddr())
+		case http.StateClosed, http.StateHijacked:
+			cl.record("close", c.RemoteAddr())
+		}
+		if next != nil {
+			next(c, state)
+		}
+	}
+}
+
+func (cl *connLog) record(event string, client net.Addr) {
+	cl.l.Printf("ts=%s event=%s client=%s slot=%d cookie=0x%x",
+		time.Now().Format(time.RFC3339Nano), event, client, cl.slot, cl.cookie)
+}
+
+func (cl *connLog) Close() error {
+	return cl.f.Close()
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"expvar"
+	"math/bits"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// connReqBuckets is the number of log2 buckets in the requests-per-connection
+// histogram; the last bucket collects everything from 2^(n-1) up.
+const connReqBuckets = 12
+
+// connStats tracks how many requests each connection carried, which is what
+// separates connection-level balancing from request-level load when
+// keep-alives or HTTP/2 multiplexing are on.
+type connStats struct {
+	mu     sync.Mutex
+	active map[string]*atomic.Int64 // keyed by remote address
+
+	opened   int64
+	closed   int64
+	requests int64
+	maxReqs  int64
+	hist     [connReqBuckets]int64
+}
+
+func newConnStats() *connStats {
+	return &connStats{active: make(map[string]*atomic.Int64)}
+}
+
+// connState is an http.Server.ConnState hook. Connections are keyed by
+// remote address rather than net.Conn identity because h2c hands the server
+// a wrapped conn after the upgrade.
+func (cs *connStats) connState(c net.Conn, state http.ConnState) {
+	key := c.RemoteAddr().String()
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	switch state {
+	case http.StateNew:
+		if _, ok := cs.active[key]; !ok {
+			cs.active[key] = new(atomic.Int64)
+			cs.opened++
+		}
+	case http.StateClosed:
+		n, ok := cs.active[key]
+		if !ok {
+			return
+		}
+		delete(cs.active, key)
+		cs.record(n.Load())
+	}
+}
+
+func (cs *connStats) record(reqs int64) {
+	cs.closed++
+	if reqs > cs.maxReqs {
+		cs.maxReqs = reqs
+	}
+	b := 0
+	if reqs > 0 {
+		b = bits.Len64(uint64(reqs))
+	}
+	if b >= connReqBuckets {
+		b = connReqBuckets - 1
+	}
+	cs.hist[b]++
+}
+
+// middleware counts each request against the connection it arrived on.
+func (cs *connStats) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cs.mu.Lock()
+		n := cs.active[r.RemoteAddr]
+		cs.requests++
+		cs.mu.Unlock()
+		if n != nil {
+			n.Add(1)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// snapshot renders the counters for expvar. hist[i] counts closed
+// connections that served [2^(i-1), 2^i) requests; hist[0] counts idle ones.
+func (cs *connStats) snapshot() any {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	mean := 0.0
+	if cs.opened > 0 {
+		mean = float64(cs.requests) / float64(cs.opened)
+	}
+	return map[string]any{
+		"opened":            cs.opened,
+		"closed":            cs.closed,
+		"open":              len(cs.active),
+		"requests":          cs.requests,
+		"max_per_conn":      cs.maxReqs,
+		"mean_per_conn":     mean,
+		"per_conn_log2hist": cs.hist,
+	}
+}
+
+func (cs *connStats) publish() {
+	expvar.Publish("conn_requests", expvar.Func(cs.snapshot))
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// ServerIdentity describes this instance as the balancer sees it. Cookie is
+// only known once the listener exists, so it is filled in after Listen; the
+// handlers reading it run only after Serve starts.
+type ServerIdentity struct {
+	Group  string
+	Slot   int
+	Cookie uint64
+	PID    int
+	Policy string
+	// Addr is the address the balanced listener is bound to.
+	Addr string
+}
+
+func (id *ServerIdentity) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Group  string `json:"group"`
+		Slot   int    `json:"slot"`
+		Cookie string `json:"cookie"`
+		PID    int    `json:"pid"`
+		Policy string `json:"policy"`
+		Addr   string `json:"addr"`
+	}{id.Group, id.Slot, fmt.Sprintf("0x%x", id.Cookie), id.PID, id.Policy, id.Addr})
+}
+
+// handleWhoami reports the identity of the instance that served the request.
+func handleWhoami(id *ServerIdentity) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(id)
+	}
+}
+
+// servedBy sets X-Served-By on every response, so a load test can count
+// which instances it reached without correlating server logs.
+func servedBy(id *ServerIdentity, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Served-By", fmt.Sprintf("slot=%d cookie=%x policy=%s", id.Slot, id.Cookie, id.Policy))
+		next.ServeHTTP(w, r)
+	})
+}
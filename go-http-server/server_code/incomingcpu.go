@@ -0,0 +1,106 @@
+package main
+
+import (
+	"expvar"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// incomingCPUs records which CPU each accepted connection arrived on
+// (SO_INCOMING_CPU: where the kernel processed its packets, after RSS or
+// RPS) next to the CPU this process first handled it on, so CPU-steering
+// policies can be checked against how the NIC actually spreads the load.
+type incomingCPUs struct {
+	mu sync.Mutex
+	// byCPU counts connections by incoming CPU.
+	byCPU map[int]int64
+	// pairs counts connections by incoming CPU and the CPU that ran the
+	// accepting goroutine.
+	pairs map[[2]int]int64
+	// sameCPU counts connections handled on the CPU they arrived on;
+	// inAffinity those that arrived on a CPU this process may run on.
+	sameCPU    int64
+	inAffinity int64
+	unknown    int64
+	affinity   map[int]bool
+}
+
+func newIncomingCPUs() *incomingCPUs {
+	return &incomingCPUs{
+		byCPU:    make(map[int]int64),
+		pairs:    make(map[[2]int]int64),
+		affinity: cpuAffinity(),
+	}
+}
+
+// wrap returns a ConnState hook that records new connections before
+// calling next.
+func (ic *incomingCPUs) wrap(next func(net.Conn, http.ConnState)) func(net.Conn, http.ConnState) {
+	return func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			ic.record(c)
+		}
+		if next != nil {
+			next(c, state)
+		}
+	}
+}
+
+func (ic *incomingCPUs) record(c net.Conn) {
+	in, ok := incomingCPU(c)
+	here, hereOK := currentCPU()
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	if !ok {
+		ic.unknown++
+		return
+	}
+	ic.byCPU[in]++
+	if ic.affinity[in] {
+		ic.inAffinity++
+	}
+	if hereOK {
+		ic.pairs[[2]int{in, here}]++
+		if in == here {
+			ic.sameCPU++
+		}
+	}
+}
+
+// snapshot renders the counters for expvar. by_cpu maps incoming CPU to
+// connections; pairs maps "incoming->handled" CPU pairs to connections.
+func (ic *incomingCPUs) snapshot() any {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+
+	var total int64
+	byCPU := make(map[string]int64, len(ic.byCPU))
+	for cpu, n := range ic.byCPU {
+		byCPU[strconv.Itoa(cpu)] = n
+		total += n
+	}
+	pairs := make(map[string]int64, len(ic.pairs))
+	for p, n := range ic.pairs {
+		pairs[strconv.Itoa(p[0])+"->"+strconv.Itoa(p[1])] = n
+	}
+	ratio := func(n int64) float64 {
+		if total == 0 {
+			return 0
+		}
+		return float64(n) / float64(total)
+	}
+	return map[string]any{
+		"connections":       total,
+		"unknown":           ic.unknown,
+		"by_cpu":            byCPU,
+		"pairs":             pairs,
+		"same_cpu_ratio":    ratio(ic.sameCPU),
+		"in_affinity_ratio": ratio(ic.inAffinity),
+	}
+}
+
+func (ic *incomingCPUs) publish() {
+	expvar.Publish("incoming_cpu", expvar.Func(ic.snapshot))
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// incomingCPU reads SO_INCOMING_CPU off the connection's socket.
+func incomingCPU(c net.Conn) (int, bool) {
+	if tc, ok := c.(*tls.Conn); ok {
+		c = tc.NetConn()
+	}
+	sc, ok := c.(syscall.Conn)
+	if !ok {
+		return 0, false
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return 0, false
+	}
+	cpu, serr := -1, error(nil)
+	err = raw.Control(func(fd uintptr) {
+		cpu, serr = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_INCOMING_CPU)
+	})
+	if err != nil || serr != nil || cpu < 0 {
+		return 0, false
+	}
+	return cpu, true
+}
+
+// currentCPU is the CPU the calling goroutine is running on right now.
+func currentCPU() (int, bool) {
+	var cpu uint32
+	if _, _, errno := unix.RawSyscall(unix.SYS_GETCPU, uintptr(unsafe.Pointer(&cpu)), 0, 0); errno != 0 {
+		return 0, false
+	}
+	return int(cpu), true
+}
+
+// cpuAffinity is the set of CPUs this process may run on.
+func cpuAffinity() map[int]bool {
+	var set unix.CPUSet
+	out := make(map[int]bool)
+	if err := unix.SchedGetaffinity(0, &set); err != nil {
+		return out
+	}
+	for cpu := 0; cpu < len(set)*64; cpu++ {
+		if set.IsSet(cpu) {
+			out[cpu] = true
+		}
+	}
+	return out
+}
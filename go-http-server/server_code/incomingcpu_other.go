@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import "net"
+
+// SO_INCOMING_CPU is Linux only; off Linux every connection counts as
+// unknown.
+func incomingCPU(net.Conn) (int, bool) { return 0, false }
+
+func currentCPU() (int, bool) { return 0, false }
+
+func cpuAffinity() map[int]bool { return map[int]bool{} }
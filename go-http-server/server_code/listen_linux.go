@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"golang.org/x/sys/unix"
+)
+
+// Inspired by src/net/dial.go
+// attached is set once prog has been attached to the listener.
+func getListenConfig(prog *ebpf.Program, installProgram bool, attached *bool) net.ListenConfig {
+	lc := net.ListenConfig{Control: func(network, address string, c syscall.RawConn) error {
+		var opErr error
+		// If Control is not nil, it is called after creating the network
+		// connection but before binding it to the operating system.
+		err := c.Control(func(fd uintptr) {
+
+			// Set SO_REUSEADDR on the socket to allow reuse of local addresses.
+			if err := unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); err != nil {
+				slog.Error("setsockopt(SO_REUSEADDR) failed", "err", err)
+				return
+			}
+
+			// Set SO_REUSEPORT on the socket for both instances (because eBPF program works on socket with SO_REUSEPORT configured)
+			if err := unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1); err != nil {
+				slog.Error("setsockopt(SO_REUSEPORT) failed", "err", err)
+				return
+			}
+			// Set eBPF program to be invoked for socket selection
+			if prog != nil && installProgram {
+				// SO_ATTACH_REUSEPORT_EBPF program defines how packets are assigned to the sockets in the reuseport group
+				// That is, all sockets which have SO_REUSEPORT set and are using the same local address to receive packets.
+				// In "function" words, for fd on the SOL_SOCKET lever, set the unix.SO_ATTACH_REUSEPORT_EBPF option to eBPF program file descriptor.
+				err := unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_ATTACH_REUSEPORT_EBPF, prog.FD())
+				if err != nil {
+					slog.Error("setsockopt(SO_ATTACH_REUSEPORT_EBPF) failed", "err", err)
+				} else {
+					*attached = true
+					slog.Info("eBPF program attached to the SO_REUSEPORT socket group")
+				}
+			}
+		})
+		if err != nil {
+			return err
+		}
+		return opErr
+	}}
+	return lc
+}
+
+// listenTuning are the knobs on the kernel's side of accept that decide when
+// a client is made to wait, for experiments on backpressure.
+type listenTuning struct {
+	// Backlog is the listener's accept queue length; 0 keeps Go's default,
+	// net.core.somaxconn. The kernel caps it at somaxconn either way.
+	Backlog int
+	// DeferAccept, if set, keeps a connection out of the accept queue until
+	// the client sends data, for at most this long (TCP_DEFER_ACCEPT). The
+	// SYN-ACK is retransmitted meanwhile.
+	DeferAccept time.Duration
+	// Somaxconn and Syncookies, if not negative, are written to the network
+	// namespace's net.core.somaxconn and net.ipv4.tcp_syncookies before the
+	// listener is created.
+	Somaxconn  int
+	Syncookies int
+}
+
+// applySysctls writes the namespace-wide settings.
+func (t listenTuning) applySysctls() error {
+	if t.Somaxconn >= 0 {
+		if err := writeSysctl("net/core/somaxconn", t.Somaxconn); err != nil {
+			return err
+		}
+	}
+	if t.Syncookies >= 0 {
+		if err := writeSysctl("net/ipv4/tcp_syncookies", t.Syncookies); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply tunes the listening socket fd.
+func (t listenTuning) apply(fd int) error {
+	if t.DeferAccept > 0 {
+		secs := int((t.DeferAccept + time.Second - 1) / time.Second)
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_DEFER_ACCEPT, secs); err != nil {
+			return fmt.Errorf("setsockopt(TCP_DEFER_ACCEPT): %w", err)
+		}
+	}
+	if t.Backlog > 0 {
+		// listen() on a listening socket only changes its backlog.
+		if err := unix.Listen(fd, t.Backlog); err != nil {
+			return fmt.Errorf("listen with backlog %d: %w", t.Backlog, err)
+		}
+	}
+	return nil
+}
+
+func writeSysctl(name string, v int) error {
+	path := filepath.Join("/proc/sys", name)
+	if err := os.WriteFile(path, []byte(strconv.Itoa(v)), 0o644); err != nil {
+		return fmt.Errorf("set %s: %w", strings.ReplaceAll(name, "/", "."), err)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/cilium/ebpf"
+)
+
+// getListenConfig falls back to a plain listener off Linux: there is no
+// reuseport group to balance in, so only one server per address and the
+// default policy can run, which is enough for working on the HTTP side.
+func getListenConfig(prog *ebpf.Program, installProgram bool, attached *bool) net.ListenConfig {
+	if installProgram {
+		slog.Warn("Reuseport selectors need Linux, listening without one")
+	}
+	return net.ListenConfig{}
+}
+
+// listenTuning are the Linux accept path settings; see listen_linux.go.
+type listenTuning struct {
+	Backlog     int
+	DeferAccept time.Duration
+	Somaxconn   int
+	Syncookies  int
+}
+
+func (t listenTuning) applySysctls() error {
+	if t.Somaxconn >= 0 || t.Syncookies >= 0 {
+		return errors.New("somaxconn and syncookies can only be set on Linux")
+	}
+	return nil
+}
+
+func (t listenTuning) apply(fd int) error {
+	if t.Backlog > 0 || t.DeferAccept > 0 {
+		slog.Warn("Listener tuning needs Linux, ignoring it", "backlog", t.Backlog, "defer_accept", t.DeferAccept)
+	}
+	return nil
+}
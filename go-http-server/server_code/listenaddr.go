@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// defaultListenAddr is where the server listens unless told otherwise.
+const defaultListenAddr = "127.0.0.1:8080"
+
+// listenAddrs resolves the addresses to listen on. The -listen flag wins
+// when given; then LISTEN_ADDR; then PORT, which as under most orchestrators
+// means that port on every interface; then defaultListenAddr. Any of them
+// may list several addresses separated by commas. Port 0 asks the kernel
+// for a free port. Both are for the default policy; see checkListenAddrs.
+func listenAddrs(flagValue string, flagSet bool) ([]string, error) {
+	value, from := defaultListenAddr, "default"
+	switch {
+	case flagSet:
+		value, from = flagValue, "-listen"
+	case os.Getenv("LISTEN_ADDR") != "":
+		value, from = os.Getenv("LISTEN_ADDR"), "LISTEN_ADDR"
+	case os.Getenv("PORT") != "":
+		value, from = ":"+os.Getenv("PORT"), "PORT"
+	}
+	var addrs []string
+	for _, addr := range strings.Split(value, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+		_, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", from, err)
+		}
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return nil, fmt.Errorf("%s: invalid port %q in %s", from, port, addr)
+		}
+		addrs = append(addrs, addr)
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("%s: no address", from)
+	}
+	return addrs, nil
+}
+
+// checkListenAddrs rejects what a policy's selector cannot balance. It
+// balances one address, which every server of the group listens on: the
+// others would only be spread by the kernel's hash, and with port 0 each
+// server would listen on a port of its own. The default policy leaves all
+// of it to the kernel, so it takes anything.
+func checkListenAddrs(addrs []string, policy string) error {
+	if policy == "default" {
+		return nil
+	}
+	if len(addrs) > 1 {
+		return fmt.Errorf("policy %s balances a single address, not %s: run a group per address, or use the default policy", policy, strings.Join(addrs, ","))
+	}
+	if listenPort(addrs[0]) == 0 {
+		return fmt.Errorf("policy %s needs a fixed port, not %s: with port 0 every server listens on a port of its own", policy, addrs[0])
+	}
+	return nil
+}
+
+// listenPort returns the port of addr, which listenAddrs has checked.
+func listenPort(addr string) uint16 {
+	_, port, _ := net.SplitHostPort(addr)
+	n, _ := strconv.ParseUint(port, 10, 16)
+	return uint16(n)
+}
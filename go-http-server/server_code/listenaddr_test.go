@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestCheckListenAddrs(t *testing.T) {
+	for _, tc := range []struct {
+		addrs   []string
+		policy  string
+		wantErr bool
+	}{
+		{[]string{"127.0.0.1:8080"}, "round-robin", false},
+		{[]string{"127.0.0.1:8080"}, "default", false},
+		{[]string{"127.0.0.1:8080", ":8443"}, "default", false},
+		{[]string{":0"}, "default", false},
+		{[]string{"127.0.0.1:8080", ":8443"}, "round-robin", true},
+		{[]string{"127.0.0.1:8080", ":8443"}, "lbd", true},
+		{[]string{":0"}, "pickfirst", true},
+		{[]string{"[::1]:0"}, "lbd", true},
+	} {
+		err := checkListenAddrs(tc.addrs, tc.policy)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("checkListenAddrs(%q, %s) = %v, want error %v", tc.addrs, tc.policy, err, tc.wantErr)
+		}
+	}
+}
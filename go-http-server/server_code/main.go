@@ -1,84 +1,51 @@
 package main
 
-//go:generate go run github.com/cilium/ebpf/cmd/bpf2go reuseportlb eBPF/reuseportlb.c
-//go:generate go run github.com/cilium/ebpf/cmd/bpf2go pickfirst eBPF/pickfirst.c
-//go:generate go run github.com/cilium/ebpf/cmd/bpf2go roundrobin eBPF/roundrobin.c
-//go:generate go run github.com/cilium/ebpf/cmd/bpf2go cpuutil eBPF/cpuutil.c
-//go:generate go run github.com/cilium/ebpf/cmd/bpf2go acceptqueue eBPF/acceptqueue.c
-
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"expvar"
+	"flag"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"reflect"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
-	"github.com/cilium/ebpf"
 	"github.com/cilium/ebpf/rlimit"
-	"golang.org/x/sys/unix"
-)
-
-func handleHello(w http.ResponseWriter, r *http.Request) {
-	io.WriteString(w, fmt.Sprintf("Hello from the %s server!\n", os.Args[1]))
-}
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 
-func handleCpu(w http.ResponseWriter, r *http.Request) {
+	"go-http-server/reuseportlb"
+)
 
-	// Simulate CPU intensive work
-	const n = 50000
-	result := 0
-	for i := 0; i < n; i++ {
-		result += i % 7
+func handleHello(id *ServerIdentity) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, fmt.Sprintf("Hello from the %d server!\n", id.Slot))
 	}
-	// Use result to prevent compiler optimization
-	io.WriteString(w, fmt.Sprintf("CPU intensive result: %d\n", result))
-	io.WriteString(w, fmt.Sprintf("Hello from the %s target!\n", os.Args[1]))
 }
 
-// Inspired by src/net/dial.go
-func getListenConfig(prog *ebpf.Program, installProgram bool) net.ListenConfig {
-	lc := net.ListenConfig{Control: func(network, address string, c syscall.RawConn) error {
-		var opErr error
-		// If Control is not nil, it is called after creating the network
-		// connection but before binding it to the operating system.
-		err := c.Control(func(fd uintptr) {
-
-			// Set SO_REUSEADDR on the socket to allow reuse of local addresses.
-			if err := unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); err != nil {
-				log.Printf("setsockopt(SO_REUSEADDR) failed: %v\n", err)
-				return
-			}
+func handleCpu(id *ServerIdentity) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
 
-			// Set SO_REUSEPORT on the socket for both instances (because eBPF program works on socket with SO_REUSEPORT configured)
-			if err := unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1); err != nil {
-				log.Printf("setsockopt(SO_REUSEPORT) failed: %v\n", err)
-				return
-			}
-			// Set eBPF program to be invoked for socket selection
-			if prog != nil && installProgram {
-				// SO_ATTACH_REUSEPORT_EBPF program defines how packets are assigned to the sockets in the reuseport group
-				// That is, all sockets which have SO_REUSEPORT set and are using the same local address to receive packets.
-				// In "function" words, for fd on the SOL_SOCKET lever, set the unix.SO_ATTACH_REUSEPORT_EBPF option to eBPF program file descriptor.
-				err := unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_ATTACH_REUSEPORT_EBPF, prog.FD())
-				if err != nil {
-					fmt.Printf("setsockopt(SO_ATTACH_REUSEPORT_EBPF) failed: %v\n", err)
-				} else {
-					log.Println("eBPF program attached to the SO_REUSEPORT socket group!")
-				}
-			}
-		})
-		if err != nil {
-			return err
+		// Simulate CPU intensive work
+		const n = 50000
+		result := 0
+		for i := 0; i < n; i++ {
+			result += i % 7
 		}
-		return opErr
-	}}
-	return lc
+		// Use result to prevent compiler optimization
+		io.WriteString(w, fmt.Sprintf("CPU intensive result: %d\n", result))
+		io.WriteString(w, fmt.Sprintf("Hello from the %d target!\n", id.Slot))
+	}
 }
 
 type slowListener struct {
@@ -121,215 +88,771 @@ func ListenerFD(l net.Listener) (int, error) {
 	return fd, opErr
 }
 
-// ensureBpffsMounted mounts bpffs at the given path if it's not already mounted.
-func ensureBpffsMounted(path string) error {
-	// Ensure the mount point directory exists
-	if err := os.MkdirAll(path, 0700); err != nil {
-		return fmt.Errorf("create bpffs mountpoint: %w", err)
+func main() {
+	if err := run(); err != nil {
+		reuseportlb.Fatal("Server failed", "err", err)
 	}
-	var statfs unix.Statfs_t
-	if err := unix.Statfs(path, &statfs); err == nil {
-		// 0xCAFE4A11 is BPF_FS_MAGIC from linux/magic.h
-		const bpfFsMagic = 0xCAFE4A11
-		if int64(statfs.Type) == int64(bpfFsMagic) {
-			return nil // already mounted as bpffs
-		}
+}
+
+// run serves until SIGTERM. Failures are returned rather than fatal, so the
+// deferred calls release what the server holds: its selector, attachments
+// and its place in the group.
+func run() error {
+	logLevel := flag.String("log-level", "info", "minimum log level: debug, info, warn or error")
+	logFormat := flag.String("log-format", "text", "log output format: text or json")
+	auditLog := flag.String("audit-log", reuseportlb.DefaultAuditLog, "file every change this server makes to the pinned maps is appended to, as JSON lines; empty disables it")
+	adminAddr := flag.String("admin-addr", "", "address for the admin server (pprof, expvar); empty disables it")
+	tlsCert := flag.String("tls-cert", "", "PEM certificate file; enables TLS together with -tls-key")
+	tlsKey := flag.String("tls-key", "", "PEM private key file for -tls-cert")
+	tlsSelfSigned := flag.String("tls-self-signed", "", "serve TLS with a generated self-signed certificate of this key type (ecdsa or rsa)")
+	keepAlives := flag.Bool("keepalive", true, "allow HTTP keep-alive connections")
+	enableHTTP2 := flag.Bool("http2", false, "serve HTTP/2 (ALPN with TLS, h2c prior knowledge without)")
+	migrate := flag.Bool("migrate", true, "migrate queued connections to another instance when this one drains (tcp_migrate_req, plus a migration-aware selector where supported)")
+	spliceTo := flag.String("splice-to", "", "instead of serving, proxy every accepted connection to one of these comma-separated backend addresses, round-robin, to compare proxying with balancing in the group")
+	spliceMode := flag.String("splice-mode", reuseportlb.SpliceSockmap, "how -splice-to moves the bytes: sockmap (spliced in the kernel) or copy (in userspace)")
+	drainTimeout := flag.Duration("drain-timeout", 10*time.Second, "how long to wait for in-flight requests when draining on SIGTERM")
+	keepPins := flag.Bool("keep-pins", false, "leave the group's pinned maps and selector behind when the last instance exits")
+	connLogPath := flag.String("conn-log", "", "append a line per client connection (client, slot, cookie) to this file")
+	rlMax := flag.Uint("ratelimit-max", 0, "max new connections per IPv4 source per -ratelimit-window; 0 disables the limiter (set by server 0)")
+	rlWindow := flag.Duration("ratelimit-window", time.Second, "rate limit window")
+	rlAction := flag.String("ratelimit-action", "drop", "what to do with connections over the limit: drop or deprioritize")
+	rlPenaltySlot := flag.Uint("ratelimit-penalty-slot", 0, "slot that receives deprioritized connections")
+	connRate := flag.Uint64("conn-rate", 0, "most new connections per second this server's slot receives, whatever the policy; 0 is unlimited (each server sets its own slot; behind -registry use lbd -slot-limits)")
+	connBurst := flag.Uint64("conn-burst", 0, "connections -conn-rate lets through at once after a quiet spell (default -conn-rate)")
+	connRateAction := flag.String("conn-rate-action", "redistribute", "what to do with connections over -conn-rate: redistribute to the next slot with room, or drop")
+	warmup := flag.Duration("warmup", 0, "slow-start window: this server's slot takes a share of its connections that ramps from none to all over it after registering, whatever the policy; 0 gives it its full share at once")
+	primeRequests := flag.Int("prime", 0, "serve this many synthetic requests in-process before registering, so the first clients do not pay for cold caches and a small heap")
+	primePath := flag.String("prime-path", "/hello", "path the -prime requests ask for")
+	paramsStr := flag.String("params", "", "policy parameters as name=value pairs, e.g. group_size=8 for round-robin or slots=8 for acceptqueue; adjustable at runtime via /params (set by server 0)")
+	chain := flag.String("chain", strings.Join(reuseportlb.DefaultChain, ","), "comma-separated stages run by the chain policy: filters exclude-draining, exclude-overloaded, slow-syn, then a selector round-robin or first (set by server 0)")
+	overloadPct := flag.Uint("chain-overload-pct", reuseportlb.DefaultOverloadPct, "accept queue fill, in percent, at which the chain policy's exclude-overloaded skips a slot; 0 disables it")
+	slowSYNPct := flag.Uint("chain-slow-syn-pct", 0, "percentage of SYNs the chain policy's slow-syn stage drops while every slot is past -chain-overload-pct, so clients retry later (set by server 0)")
+	var tuning listenTuning
+	flag.IntVar(&tuning.Backlog, "backlog", 0, "accept queue length of this server's listener; 0 is net.core.somaxconn")
+	flag.DurationVar(&tuning.DeferAccept, "defer-accept", 0, "keep connections out of the accept queue until the client sends data, for at most this long (TCP_DEFER_ACCEPT); 0 disables it")
+	flag.IntVar(&tuning.Somaxconn, "somaxconn", -1, "set net.core.somaxconn, the cap on every listener's backlog in the network namespace, before listening; -1 leaves it")
+	flag.IntVar(&tuning.Syncookies, "syncookies", -1, "set net.ipv4.tcp_syncookies (0 off, 1 when the SYN queue overflows, 2 always) before listening; -1 leaves it")
+	canarySlot := flag.Uint("canary-slot", 0, "slot that receives the canary share under the splitter policy (set by server 0)")
+	canaryPct := flag.Uint("canary-pct", 0, "percentage of new connections the splitter policy sends to -canary-slot (set by server 0)")
+	primarySlot := flag.Uint("primary-slot", 0, "slot that gets every connection under the hot-standby policy while it is healthy (set by server 0)")
+	standbySlot := flag.Uint("standby-slot", 1, "slot that takes over under the hot-standby policy (set by server 0)")
+	heartbeatTimeout := flag.Duration("heartbeat-timeout", reuseportlb.DefaultHeartbeatTimeout, "under the hot-standby policy, how stale a slot's heartbeat may get before traffic moves off it (set by server 0; every server heartbeats at a quarter of it); 0 only checks that the slot listens")
+	priorities := flag.String("priorities", "", "hot-standby priority groups as slot=level pairs, level 1 highest (e.g. 0=1,1=1,2=2); replaces -primary-slot and -standby-slot (set by server 0)")
+	spillPct := flag.Uint("spill-pct", 0, "with -priorities, percentage of connections that spill to the next level once the active level's accept queues reach -spill-threshold-pct (set by server 0)")
+	spillThreshold := flag.Uint("spill-threshold-pct", reuseportlb.DefaultSpillThresholdPct, "accept queue fill, in percent, at which the spillover policy moves on to the next slot, and at which hot-standby -priorities start spilling -spill-pct (an average over the level) (set by server 0)")
+	tieBreak := flag.String("tie-break", "random", "how the jsq policy chooses among equally short queues: random or round-robin (set by server 0)")
+	steerPath := flag.String("steer-config", "", "JSON tenant table for the steer policy (set by server 0); with TLS, every server also records each client's SNI tenant")
+	slotTag := flag.String("slot-tag", "", "tag every response with the slot that served it, for packet captures: tos (DSCP/IPv6 flow label on -slot-tag-iface) or tcp-option (an experimental TCP option) (set by server 0)")
+	slotTagIface := flag.String("slot-tag-iface", "lo", "interface -slot-tag tos tags responses leaving through (set by server 0)")
+	runtimeInterval := flag.Duration("runtime-interval", reuseportlb.DefaultRuntimeInterval, "how often to publish Go runtime metrics (heap against the GC goal, scheduling latency) for the gcaware policy; 0 disables it")
+	healthWeights := flag.String("health", "cpu=1,queue=1,gc=1", "built-in signals (cpu, queue, gc, psi, errors, net), and their weights, folded into the health score the healthscore policy picks by")
+	healthInterval := flag.Duration("health-interval", reuseportlb.DefaultHealthInterval, "how often to publish the health score")
+	errorInterval := flag.Duration("error-interval", reuseportlb.DefaultErrorInterval, "how often to publish the share of requests answered with a 5xx status or too late, for the errors health signal and lbd's outlier detection; 0 disables it")
+	errorTimeout := flag.Duration("error-timeout", 0, "a request taking longer than this counts as failed in the published error rate; 0 counts only 5xx responses and passed deadlines")
+	rebalanceInterval := flag.Duration("rebalance-interval", reuseportlb.DefaultRebalanceInterval, "how often to check whether lbd asked this server to shed a share of its connections (lbd /rebalance) so the selector can place their clients again; 0 only sheds on the admin /rebalance")
+	servedByHeader := flag.Bool("served-by", false, "add an X-Served-By: slot=<n> cookie=<hex> policy=<name> header to every response")
+	joinCgroup := flag.Bool("cgroup", false, "move this instance into a cgroup of its own under /sys/fs/cgroup/reuseportlb, so collectors with -cgroup-util measure it apart from everything else on its cores")
+	traceReqCPU := flag.Bool("trace-request-cpu", false, "measure the CPU time of every request with a sched_switch tracer and publish this slot's histogram (admin /reqcpu)")
+	shadowPolicy := flag.String("shadow", "", "candidate policy to run in shadow mode: it sees every connection and its choices are recorded for lbctl shadow, but <policy> places them (set by server 0)")
+	featuresStr := flag.String("features", reuseportlb.DefaultFeatures.String(), "comma-separated parts of the selector to load, all or none: override (lbctl override), source-limit, slot-limits, warmup, shadow, counts (lbd's watchdog), trace (to trace_pipe); -ratelimit-max, -conn-rate, -warmup and -shadow add their own (set by server 0)")
+	useLbd := flag.Bool("lbd", false, "attach the selector pinned by lbd instead of loading a policy; <policy> is then omitted")
+	groupName := flag.String("group", "", "reuseport group this server balances in; each group has its own selector and maps (default group if empty)")
+	var listen string
+	flag.StringVar(&listen, "listen", defaultListenAddr, "address to listen on, which the group's servers share and its selector balances; under the default policy also several, comma-separated, or port 0 for one the kernel picks (logged, and in /whoami); defaults to $LISTEN_ADDR, else :$PORT")
+	flag.StringVar(&listen, "addr", defaultListenAddr, "alias of -listen")
+	harden := flag.Bool("harden", false, "once the listener is registered, restrict the process to the syscalls serving needs with a seccomp filter; with -registry it keeps no BPF access at all")
+	hardenUser := flag.String("harden-user", "", "with -harden, also switch to this user; needs -registry or the default policy, since direct servers need their privileges to drain")
+	registryPath := flag.String("registry", "", "hand the listener to lbd over this unix socket (e.g. "+reuseportlb.DefaultRegistrySocket+") instead of touching bpffs; implies -lbd and needs no BPF privileges")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <server number> <policy>\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s -lbd [flags] <server number>\n", os.Args[0])
+		flag.PrintDefaults()
 	}
-	// Not mounted as bpffs; try to mount
-	if err := unix.Mount("bpffs", path, "bpf", 0, ""); err != nil {
-		return fmt.Errorf("mount bpffs at %s: %w", path, err)
+	flag.Parse()
+	listenSet := false
+	flag.Visit(func(f *flag.Flag) { listenSet = listenSet || f.Name == "listen" || f.Name == "addr" })
+	if *registryPath != "" {
+		*useLbd = true
 	}
-	return nil
-}
 
-type LoadedObjects struct {
-	Program *ebpf.Program
-	Map     *ebpf.Map
-	Close   func() error
-}
+	reuseportlb.SetAuditLog(*auditLog)
+	logger, err := reuseportlb.NewLogger(os.Stderr, *logLevel, *logFormat)
+	if err != nil {
+		return fmt.Errorf("invalid logging flags: %w", err)
+	}
+	slog.SetDefault(logger)
 
-func loadPolicy(policy string) (LoadedObjects, error) {
-	mapOptions := ebpf.CollectionOptions{Maps: ebpf.MapOptions{PinPath: "/sys/fs/bpf"}}
+	wantArgs := 2
+	if *useLbd {
+		wantArgs = 1
+	}
+	if flag.NArg() < wantArgs {
+		flag.Usage()
+		os.Exit(2)
+	}
+	serverNum, err := strconv.Atoi(flag.Arg(0))
+	if err != nil {
+		return fmt.Errorf("server number should be a number: %w", err)
+	}
+	// With -lbd the daemon owns the policy; this process only registers.
+	policy := "lbd"
+	if !*useLbd {
+		policy = flag.Arg(1)
+	}
+	group, err := reuseportlb.ParseGroup(*groupName)
+	if err != nil {
+		return fmt.Errorf("invalid -group: %w", err)
+	}
+	slog.SetDefault(logger.With("group", group.String(), "slot", serverNum, "policy", policy))
+	addrs, err := listenAddrs(listen, listenSet)
+	if err != nil {
+		return fmt.Errorf("invalid listen address: %w", err)
+	}
+	if err := checkListenAddrs(addrs, policy); err != nil {
+		return fmt.Errorf("invalid listen address: %w", err)
+	}
 
-	switch policy {
+	// Cancelled on SIGTERM: setup stops waiting, background loops return and
+	// the server drains instead of dying with its slot still registered.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	case "cpuutil":
-		var objs cpuutilObjects
-		if err := loadCpuutilObjects(&objs, &mapOptions); err != nil {
-			return LoadedObjects{}, err
+	rlAct, err := reuseportlb.ParseRateLimitAction(*rlAction)
+	if err != nil {
+		return fmt.Errorf("invalid rate limit flags: %w", err)
+	}
+	chainStages, err := reuseportlb.ParseChain(*chain)
+	if err != nil {
+		return fmt.Errorf("invalid -chain: %w", err)
+	}
+	prioGroups, err := reuseportlb.ParsePriorities(*priorities)
+	if err != nil {
+		return fmt.Errorf("invalid -priorities: %w", err)
+	}
+	jsqTieBreak, err := reuseportlb.ParseTieBreak(*tieBreak)
+	if err != nil {
+		return fmt.Errorf("invalid -tie-break: %w", err)
+	}
+	params, err := reuseportlb.ParseParams(*paramsStr)
+	if err != nil {
+		return fmt.Errorf("invalid -params: %w", err)
+	}
+	features, err := reuseportlb.ParseFeatures(*featuresStr)
+	if err != nil {
+		return fmt.Errorf("invalid -features: %w", err)
+	}
+	if *rlMax > 0 {
+		features |= reuseportlb.FeatureSourceLimit
+	}
+	if *connRate > 0 {
+		features |= reuseportlb.FeatureSlotLimits
+	}
+	if *warmup > 0 {
+		features |= reuseportlb.FeatureWarmup
+	}
+	if *shadowPolicy != "" {
+		features |= reuseportlb.FeatureShadow
+	}
+	slotLimit := reuseportlb.SlotLimit{Rate: *connRate, Burst: *connBurst}
+	if slotLimit.Burst == 0 {
+		slotLimit.Burst = slotLimit.Rate
+	}
+	if slotLimit.Action, err = reuseportlb.ParseSlotLimitAction(*connRateAction); err != nil {
+		return fmt.Errorf("invalid -conn-rate-action: %w", err)
+	}
+
+	health, err := reuseportlb.ParseHealthWeights(*healthWeights)
+	if err != nil {
+		return fmt.Errorf("invalid -health: %w", err)
+	}
+
+	tlsCfg, err := tlsConfig(*tlsCert, *tlsKey, *tlsSelfSigned)
+	if err != nil {
+		return fmt.Errorf("invalid TLS configuration: %w", err)
+	}
+	var steer reuseportlb.SteerConfig
+	if *steerPath != "" {
+		if steer, err = reuseportlb.LoadSteerConfig(*steerPath); err != nil {
+			return fmt.Errorf("invalid steer config: %w", err)
 		}
-		return LoadedObjects{
-			Program: objs.cpuutilPrograms.CpuutilSelector,
-			Map:     objs.cpuutilMaps.TcpBalancingTargets,
-			Close:   objs.Close,
-		}, nil
+	}
 
-	case "acceptqueue":
-		var objs acceptqueueObjects
-		if err := loadAcceptqueueObjects(&objs, &mapOptions); err != nil {
-			return LoadedObjects{}, err
+	if *joinCgroup {
+		path, err := group.JoinCgroup(uint32(serverNum))
+		if err != nil {
+			return fmt.Errorf("join instance cgroup: %w", err)
 		}
-		return LoadedObjects{
-			Program: objs.acceptqueuePrograms.AcceptqSelector,
-			Map:     objs.acceptqueueMaps.TcpBalancingTargets,
-			Close:   objs.Close,
-		}, nil
+		slog.Info("Joined instance cgroup", "path", path)
+	}
 
-	case "round-robin":
-		var objs roundrobinObjects
-		if err := loadRoundrobinObjects(&objs, &mapOptions); err != nil {
-			return LoadedObjects{}, err
+	// Registering through lbd leaves every bpffs and map operation to the
+	// daemon; otherwise this process does them itself and needs CAP_BPF.
+	direct := *registryPath == ""
+	if direct {
+		if err := reuseportlb.Preflight(policy, false); err != nil {
+			return fmt.Errorf("missing privileges: %w", err)
+		}
+	}
+	// The default policy leaves balancing to the kernel and never touches
+	// bpffs, which is also what lets the server run off Linux.
+	if direct && policy != "default" {
+		// Ensure bpffs is mounted and pin directory exists
+		if err := reuseportlb.EnsureBpffs(); err != nil {
+			return fmt.Errorf("set up bpffs: %w", err)
+		}
+		if err := os.MkdirAll(reuseportlb.PinPath, 0700); err != nil {
+			return fmt.Errorf("create pin directory: %w", err)
+		}
+		// Refuse to go near pins written by a build with a different map layout.
+		if err := reuseportlb.EnsureLayout(); err != nil {
+			return fmt.Errorf("check map layout: %w", err)
 		}
 
-		type rrState struct {
-			Counter       uint32
-			ActiveSockets uint32
+		if *migrate {
+			if err := reuseportlb.EnableRequestMigration(); err != nil {
+				slog.Warn("Accept queue migration unavailable, queued connections are reset on drain", "err", err)
+			}
 		}
-		k := uint32(0)
-		s := rrState{Counter: 0, ActiveSockets: 4}
-		objs.roundrobinMaps.Rr.Update(&k, &s, ebpf.UpdateAny)
 
-		log.Printf("Added round robin state: key=%d, value={Counter: %d, ActiveSockets: %d} (only works with 4 servers)", k, s.Counter, s.ActiveSockets)
+		// Remove resource limits for kernels <5.11.
+		if err := rlimit.RemoveMemlock(); err != nil {
+			slog.Warn("Removing memlock failed", "err", err)
+		}
+	}
 
-		return LoadedObjects{
-			Program: objs.roundrobinPrograms.RrSelector,
-			Map:     objs.roundrobinMaps.TcpBalancingTargets, // sockarray to be filled per-instance
-			Close:   objs.Close,
-		}, nil
+	// Load the compiled eBPF ELF and load it into the kernel.
+	// Map needs to be pinned, such that in case the primary target is shutdown, the standby target can still see the map
+	var objs reuseportlb.LoadedObjects
+	if *useLbd && direct {
+		prog, err := group.LoadPinnedProgram()
+		if err != nil {
+			return fmt.Errorf("load pinned selector: %w", err)
+		}
+		objs = reuseportlb.LoadedObjects{Program: prog, Close: prog.Close}
+		slog.Info("Using selector pinned by lbd")
+	} else if !*useLbd && serverNum == 0 && policy != "default" {
+		var err error
+		var attached bool
+		slog.Info("Loading eBPF policy")
+		objs, attached, err = group.LoadOrAttachPolicy(policy, *migrate, features)
+		switch {
+		case errors.Is(err, reuseportlb.ErrPolicyUnsupported):
+			return fmt.Errorf("invalid policy %q, valid: %s", policy, strings.Join(append([]string{"default"}, reuseportlb.Policies...), ", "))
+		case errors.Is(err, reuseportlb.ErrKernelFeatureMissing):
+			return fmt.Errorf("kernel too old for policy %s: %w", policy, err)
+		case err != nil:
+			return fmt.Errorf("load eBPF objects: %w", err)
+		}
+		if attached {
+			slog.Info("Attached selector pinned by another instance")
+		} else {
+			slog.Info("Loaded eBPF policy", "select_or_migrate", objs.SelectOrMigrate, "features", features.String())
+
+			rl := reuseportlb.RateLimitConfig{
+				Enabled:     *rlMax > 0,
+				MaxConns:    uint32(*rlMax),
+				Window:      *rlWindow,
+				Action:      rlAct,
+				PenaltySlot: uint32(*rlPenaltySlot),
+			}
+			if err := group.SetRateLimit(rl); err != nil {
+				return fmt.Errorf("configure rate limit: %w", err)
+			}
+			if rl.Enabled {
+				slog.Info("Per-source rate limit enabled", "max_conns", rl.MaxConns, "window", rl.Window, "action", rl.Action)
+			}
+			if err := group.SetParams(policy, params); err != nil {
+				return fmt.Errorf("configure policy parameters: %w", err)
+			}
+			if len(params) > 0 {
+				slog.Info("Set policy parameters", "params", *paramsStr)
+			}
 
-	case "pickfirst":
-		var objs pickfirstObjects
-		if err := loadPickfirstObjects(&objs, &mapOptions); err != nil {
-			return LoadedObjects{}, err
+			if policy == "chain" {
+				if err := group.SetOverloadThreshold(uint32(*overloadPct)); err != nil {
+					return fmt.Errorf("configure chain policy: %w", err)
+				}
+				if err := group.SetSlowSYN(uint32(*slowSYNPct)); err != nil {
+					return fmt.Errorf("configure chain policy: %w", err)
+				}
+				if err := group.SetChain(chainStages); err != nil {
+					return fmt.Errorf("configure chain policy: %w", err)
+				}
+				slog.Info("Installed policy chain", "stages", chainStages, "overload_pct", *overloadPct, "slow_syn_pct", *slowSYNPct)
+			}
+			if policy == "steer" {
+				if err := group.ApplySteerConfig(steer); err != nil {
+					return fmt.Errorf("configure steering: %w", err)
+				}
+				slog.Info("Configured steering tenants", "tenants", len(steer.Tenants))
+			}
+			if policy == "splitter" {
+				split := reuseportlb.SplitConfig{CanarySlot: uint32(*canarySlot), Percent: uint32(*canaryPct)}
+				if err := group.SetSplit(split); err != nil {
+					return fmt.Errorf("configure splitter: %w", err)
+				}
+				slog.Info("Configured canary split", "canary_slot", split.CanarySlot, "percent", split.Percent)
+			}
+			if policy == "spillover" {
+				if err := group.SetSpillThreshold(uint32(*spillThreshold)); err != nil {
+					return fmt.Errorf("configure spillover: %w", err)
+				}
+				slog.Info("Configured spillover", "threshold_pct", *spillThreshold)
+			}
+			if policy == "jsq" {
+				if err := group.SetTieBreak(jsqTieBreak); err != nil {
+					return fmt.Errorf("configure jsq: %w", err)
+				}
+				slog.Info("Configured shortest queue tie-break", "tie_break", jsqTieBreak)
+			}
+			if policy == "hot-standby" {
+				standby := reuseportlb.StandbyConfig{
+					Primary:           uint32(*primarySlot),
+					Standby:           uint32(*standbySlot),
+					HeartbeatTimeout:  *heartbeatTimeout,
+					Priorities:        prioGroups,
+					SpillPct:          uint32(*spillPct),
+					SpillThresholdPct: uint32(*spillThreshold),
+				}
+				if err := group.SetStandby(standby); err != nil {
+					return fmt.Errorf("configure hot standby: %w", err)
+				}
+				slog.Info("Configured hot standby", "primary", standby.Primary, "standby", standby.Standby, "heartbeat_timeout", standby.HeartbeatTimeout,
+					"priorities", reuseportlb.FormatPriorities(standby.Priorities), "spill_pct", standby.SpillPct)
+			}
+			if *shadowPolicy != "" {
+				shadow, err := group.StartShadow(policy, *shadowPolicy, objs)
+				if err != nil {
+					return fmt.Errorf("start shadow policy: %w", err)
+				}
+				defer shadow.Stop()
+				slog.Info("Running candidate policy in shadow mode", "candidate", shadow.Policy())
+			}
+			if *slotTag != "" {
+				tagger, err := group.StartSlotTagger(listenPort(addrs[0]), *slotTag, *slotTagIface)
+				if err != nil {
+					return fmt.Errorf("start slot tagger: %w", err)
+				}
+				defer tagger.Close()
+				slog.Info("Tagging responses with their slot", "mode", *slotTag, "iface", *slotTagIface)
+			}
 		}
-		return LoadedObjects{
-			Program: objs.pickfirstPrograms.Pickfirst,
-			Map:     objs.pickfirstMaps.TcpBalancingTargets,
-			Close:   objs.Close,
-		}, nil
+	}
 
-	case "agent":
-		// Placeholder for agent policy, implement as needed
-		return LoadedObjects{}, fmt.Errorf("agent policy is not implemented")
+	if objs.Close != nil {
+		defer objs.Close() // This only unloads the eBPF program (if it is not attached to kernel) and map, but doesn't remove the pin
+	}
 
-	default:
-		validPolicies := []string{"default", "pickfirst", "round-robin", "cpuutil", "acceptqueue", "agent"}
-		log.Fatalf("Invalid policy: %q. Valid policies are: %v", policy, validPolicies)
+	// Setup HTTP Server instance
+	// We can't directly use http.ListenAndServe because it hides the socket implementation (which is what we are interested in with SetsockoptInt)
+	// The balanced port gets its own mux so the admin endpoints registered on
+	// http.DefaultServeMux by net/http/pprof and expvar are never exposed on it.
+	mux := http.NewServeMux()
+	id := &ServerIdentity{Group: group.String(), Slot: serverNum, PID: os.Getpid(), Policy: policy}
+	mux.HandleFunc("/hello", handleHello(id))
+	mux.HandleFunc("/cpu", handleCpu(id))
+	mux.HandleFunc("/whoami", handleWhoami(id))
+
+	if tlsCfg != nil && *steerPath != "" && direct {
+		steerBySNI(tlsCfg, group, steer)
 	}
-	return LoadedObjects{}, nil
-}
 
-func main() {
-	if len(os.Args) < 3 {
-		log.Fatalf("Usage: %s <server number> <policy>", os.Args[0])
+	conns := newConnStats()
+	conns.publish()
+	var handler http.Handler = conns.middleware(mux)
+	rebalancer := reuseportlb.NewRebalancer()
+	handler = rebalancer.Wrap(handler)
+	// Servers registered through lbd cannot write the error rate.
+	var errCounter *reuseportlb.ErrorCounter
+	if direct && policy != "default" && *errorInterval > 0 {
+		errCounter = group.NewErrorCounter(uint32(serverNum), *errorTimeout)
+		handler = errCounter.Wrap(handler)
+	}
+	if *servedByHeader {
+		handler = servedBy(id, handler)
+	}
+	if *traceReqCPU {
+		tracer, err := group.StartRequestCPUTracer(uint32(serverNum))
+		if err != nil {
+			return fmt.Errorf("trace request CPU: %w", err)
+		}
+		defer tracer.Close()
+		handler = tracer.Wrap(handler)
+		slog.Info("Tracing request CPU time")
+	}
+	if *enableHTTP2 && tlsCfg == nil {
+		handler = h2c.NewHandler(handler, &http2.Server{})
+	}
+	incoming := newIncomingCPUs()
+	incoming.publish()
+	server := http.Server{Addr: addrs[0], Handler: handler, TLSConfig: tlsCfg, ConnState: rebalancer.TrackConns(incoming.wrap(conns.connState))}
+	if !*enableHTTP2 {
+		// A non-nil, empty map keeps ServeTLS from negotiating h2.
+		server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
 	}
-	serverNum, err := strconv.Atoi(os.Args[1])
+	server.SetKeepAlivesEnabled(*keepAlives)
+	slog.Info("HTTP settings", "keepalive", *keepAlives, "http2", *enableHTTP2, "tls", tlsCfg != nil)
+
+	// Every -lbd server attaches the same pinned selector, so whichever
+	// socket ends up first in the group carries it.
+	installProgram := direct && policy != "default" && (serverNum == 0 || *useLbd)
+	var selectorAttached bool
+	if err := tuning.applySysctls(); err != nil {
+		return fmt.Errorf("tune the network namespace: %w", err)
+	}
+	lc := getListenConfig(objs.Program, installProgram, &selectorAttached)
+	ln, err := lc.Listen(ctx, "tcp", server.Addr)
 	if err != nil {
-		log.Fatalf("Server number should be a number: %v", err)
+		return fmt.Errorf("listen on %s: %w", server.Addr, err)
 	}
-	policy := os.Args[2]
+	// With port 0 the kernel picked one; report what it is.
+	id.Addr = ln.Addr().String()
+	slog.Info("Started listening", "addr", id.Addr)
 
-	// Ensure bpffs is mounted and pin directory exists
-	if err := ensureBpffsMounted("/sys/fs/bpf"); err != nil {
-		log.Fatalf("bpffs mount/setup failed: %v", err)
+	fd, err := ListenerFD(ln)
+	if err != nil {
+		return fmt.Errorf("get listener fd: %w", err)
 	}
-	if err := os.MkdirAll("/sys/fs/bpf", 0700); err != nil {
-		log.Fatalf("create pin directory failed: %v", err)
+	if err := tuning.apply(fd); err != nil {
+		return fmt.Errorf("tune the listener: %w", err)
 	}
-
-	// Remove resource limits for kernels <5.11.
-	if err := rlimit.RemoveMemlock(); err != nil {
-		log.Print("Removing memlock:", err)
+	if tuning != (listenTuning{Somaxconn: -1, Syncookies: -1}) {
+		slog.Info("Tuned accept path", "backlog", tuning.Backlog, "defer_accept", tuning.DeferAccept,
+			"somaxconn", tuning.Somaxconn, "syncookies", tuning.Syncookies)
 	}
-
-	// Load the compiled eBPF ELF and load it into the kernel.
-	// Map needs to be pinned, such that in case the primary target is shutdown, the standby target can still see the map
-	var objs LoadedObjects
-	if serverNum == 0 && policy != "default" {
-		var err error
-		log.Printf("Loading eBPF policy: %s", policy)
-		objs, err = loadPolicy(policy)
+	cookie, err := reuseportlb.SocketCookie(fd)
+	if errors.Is(err, errors.ErrUnsupported) {
+		slog.Warn("Listener has no socket cookie on this platform", "err", err)
+	} else if err != nil {
+		return fmt.Errorf("read listener cookie: %w", err)
+	}
+	id.Cookie = cookie
+	if selectorAttached {
+		if err := group.RecordAttach(os.Getpid(), uint32(serverNum), cookie, objs.Program); err != nil {
+			slog.Warn("Recording attachment failed", "err", err)
+		}
+	}
+	slog.SetDefault(slog.Default().With(reuseportlb.CookieAttr(cookie)))
+	slog.Info("Listener socket cookie obtained")
+
+	// Only the default policy takes several addresses: the kernel's hash
+	// spreads each of them, the first included.
+	var extra []net.Listener
+	plain := getListenConfig(nil, false, nil)
+	for _, addr := range addrs[1:] {
+		eln, err := plain.Listen(ctx, "tcp", addr)
 		if err != nil {
-			log.Fatalf("Loading eBPF objects failed: %v", err)
+			return fmt.Errorf("listen on %s: %w", addr, err)
+		}
+		defer eln.Close()
+		if efd, err := ListenerFD(eln); err != nil {
+			return fmt.Errorf("get listener fd of %s: %w", addr, err)
+		} else if err := tuning.apply(efd); err != nil {
+			return fmt.Errorf("tune the listener on %s: %w", addr, err)
 		}
+		extra = append(extra, eln)
+		slog.Info("Started listening on additional address", "addr", eln.Addr().String())
 	}
 
-	defer objs.Close() // This only unloads the eBPF program (if it is not attached to kernel) and map, but doesn't remove the pin
-
-	// Setup HTTP Server instance
-	// We can't directly use http.ListenAndServe because it hides the socket implementation (which is what we are interested in with SetsockoptInt)
-	http.HandleFunc("/hello", handleHello)
-	http.HandleFunc("/cpu", handleCpu)
-	server := http.Server{Addr: "127.0.0.1:8080", Handler: nil}
-
-	installProgram := serverNum == 0 && policy != "default"
-	lc := getListenConfig(objs.Program, installProgram)
-	ln, err := lc.Listen(context.Background(), "tcp", server.Addr)
-	if err != nil {
-		log.Fatalf("Unable to listen of specified addr: %v", err)
-	} else {
-		log.Printf("Started listening in 127.0.0.1:8080 successfully! (serverNum = %d, policy = %s)", serverNum, policy)
+	if *connLogPath != "" {
+		cl, err := openConnLog(*connLogPath, serverNum, cookie)
+		if err != nil {
+			return fmt.Errorf("open connection log: %w", err)
+		}
+		defer cl.Close()
+		server.ConnState = cl.wrap(server.ConnState)
+		slog.Info("Logging connections", "path", *connLogPath)
 	}
 
-	fd, err := ListenerFD(ln)
-	if err != nil {
-		log.Fatalf("get listener fd: %v", err)
+	// Ready once the selector this instance relies on is loaded (and, if it
+	// installs it, attached), the listener is in its slot and it serves.
+	ready := reuseportlb.NewReadiness()
+	var registered, serving atomic.Bool
+	ready.Add("serving", func() error {
+		if !serving.Load() {
+			return errors.New("not serving yet")
+		}
+		return nil
+	})
+	if direct && policy != "default" {
+		ready.Add("selector", func() error {
+			if installProgram && !selectorAttached {
+				return errors.New("selector not attached to the listener")
+			}
+			return group.CheckSelector()
+		})
+		ready.Add("registered", func() error {
+			if !registered.Load() {
+				return errors.New("not registered yet")
+			}
+			return group.CheckRegistered(uint32(serverNum), cookie)
+		})
+	} else if !direct {
+		// Without BPF access the maps cannot be read back; lbd's answer is
+		// what there is.
+		ready.Add("registered", func() error {
+			if !registered.Load() {
+				return errors.New("not registered with lbd yet")
+			}
+			return nil
+		})
 	}
-	cookie, err := unix.GetsockoptUint64(fd, unix.SOL_SOCKET, unix.SO_COOKIE)
-	if err != nil {
-		log.Fatalf("getsockopt(SO_COOKIE) failed: %v", err)
+
+	if *adminAddr != "" {
+		// Tag the expvar output with this instance's identity so profiles and
+		// runtime metrics can be lined up with the balancer's view of it.
+		expvar.Publish("lb", expvar.Func(func() any { return id }))
+
+		adminMux := reuseportlb.NewAdminMux()
+		if policy != "default" {
+			adminMux.HandleFunc("/ratelimit", group.ServeRateLimit)
+			adminMux.HandleFunc("/split", group.ServeSplit)
+			adminMux.HandleFunc("/latency", group.ServeLatency)
+			adminMux.HandleFunc("/connstats", group.ServeConnStats)
+			adminMux.HandleFunc("/standby", group.ServeStandby)
+			adminMux.HandleFunc("/jsq", group.ServeJSQ)
+			adminMux.HandleFunc("/slotlimit", group.ServeSlotLimits)
+			adminMux.HandleFunc("/params", group.ParamsHandler(policy))
+			adminMux.HandleFunc("/audit", group.ServeAudit)
+		}
+		if *traceReqCPU {
+			adminMux.HandleFunc("/reqcpu", group.ServeRequestCPU)
+		}
+		adminMux.HandleFunc("/rebalance", rebalancer.ServeRebalance)
+		adminMux.HandleFunc("/readyz", ready.ServeReadyz)
+		if _, err := reuseportlb.ServeAdmin(*adminAddr, adminMux); err != nil {
+			return fmt.Errorf("start admin server on %s: %w", *adminAddr, err)
+		}
 	}
-	log.Printf("Listener socket cookie: %d (0x%x)", cookie, cookie)
 
-	if policy != "default" {
-		// NOTE: Each process has its own file descriptor table, so don't get confused if the FDs are the same for both processes
-		//v := uint64(GetFdFromListener(ln))
-		v := uint64(fd)
-		var k uint32 = uint32(serverNum)
+	if *primeRequests > 0 {
+		took, err := prime(ctx, mux, *primePath, *primeRequests)
+		switch {
+		case ctx.Err() != nil:
+			slog.Info("Priming interrupted", "path", *primePath)
+		case err != nil:
+			return fmt.Errorf("prime %s: %w", *primePath, err)
+		default:
+			slog.Info("Primed", "path", *primePath, "requests", *primeRequests, "took", took)
+		}
+	}
 
-		log.Printf("Updating with (key = %d , value = %d)", k, v)
-		m, err := ebpf.LoadPinnedMap("/sys/fs/bpf/tcp_balancing_targets", nil)
+	slot := uint32(serverNum)
+	var registry *reuseportlb.RegistryClient
+	switch {
+	case !direct:
+		registry, err = reuseportlb.DialRegistry(ctx, *registryPath)
 		if err != nil {
-			log.Fatalf("Unable to load map: %v", err)
+			return fmt.Errorf("reach registry at %s: %w", *registryPath, err)
 		}
-
-		if err := m.Update(&k, &v, ebpf.UpdateAny); err != nil {
-			m.Close()
-			log.Fatalf("Unable to update the map: %v", err)
+		defer registry.Close()
+		if _, err := registry.Register(ctx, group, slot, fd, *warmup); errors.Is(err, reuseportlb.ErrSlotTaken) {
+			return fmt.Errorf("slot %d is held by another running instance, use another server number: %w", slot, err)
+		} else if ctx.Err() != nil {
+			slog.Info("Interrupted before registering")
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("register via lbd at %s: %w", *registryPath, err)
 		}
-		m.Close()
-		log.Printf("Map update succeeded")
+		registered.Store(true)
+		slog.Info("Registered socket via lbd", "path", *registryPath)
+	case policy != "default":
+		slog.Debug("Updating balancing targets", "key", slot, "fd", fd)
+		if _, err := group.RegisterSocket(ctx, slot, fd, os.Getpid()); errors.Is(err, reuseportlb.ErrSlotTaken) {
+			return fmt.Errorf("slot %d is held by another running instance, use another server number: %w", slot, err)
+		} else if ctx.Err() != nil {
+			slog.Info("Interrupted before registering")
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("register socket: %w", err)
+		}
+		registered.Store(true)
+		slog.Info("Registered socket in balancing targets")
+		// Always written, so a restart without -conn-rate lifts an old cap.
+		if err := group.SetSlotLimit(slot, slotLimit); err != nil {
+			return fmt.Errorf("configure connection rate: %w", err)
+		}
+		if slotLimit.Rate > 0 {
+			slog.Info("Capped connection rate", "rate", slotLimit.Rate, "burst", slotLimit.Burst, "action", slotLimit.Action)
+		}
+		// Written even without -warmup, so a slot reused mid-ramp starts
+		// at full weight.
+		if err := group.StartWarmup(slot, *warmup); err != nil {
+			return fmt.Errorf("start warm-up: %w", err)
+		}
+		if *warmup > 0 {
+			slog.Info("Warming up", "window", *warmup)
+		}
+	}
 
-		slotMap, err := ebpf.LoadPinnedMap("/sys/fs/bpf/acceptq_slot_cookies", nil)
+	// Direct instances share the group's pins; the last one out removes them.
+	if direct && policy != "default" && !*keepPins {
+		leaveGroup, err := group.Join()
 		if err != nil {
-			log.Fatalf("Unable to load acceptq slot map: %v", err)
+			return fmt.Errorf("record instance: %w", err)
 		}
-		if err := slotMap.Update(&k, &cookie, ebpf.UpdateAny); err != nil {
-			slotMap.Close()
-			log.Fatalf("Unable to update acceptq slot map: %v", err)
+		defer func() {
+			if err := leaveGroup(); err != nil {
+				slog.Error("Unpinning group failed", "err", err)
+			}
+		}()
+	}
+
+	if *harden {
+		// Direct servers still deregister and unpin through bpffs when they
+		// drain; servers registered through lbd only talk to its socket.
+		cfg := reuseportlb.HardenConfig{User: *hardenUser, KeepBPF: direct && policy != "default"}
+		if err := reuseportlb.Harden(cfg); err != nil {
+			return fmt.Errorf("harden: %w", err)
 		}
-		slotMap.Close()
-		log.Printf("Updated slot %d with cookie 0x%x", k, cookie)
+		slog.Info("Hardened", "user", *hardenUser, "keep_bpf", cfg.KeepBPF)
+	}
+
+	// gcaware (or whatever lbd runs) reads every instance's runtime
+	// metrics; servers registered through lbd cannot write them.
+	if direct && (policy == "gcaware" || policy == "lbd") && *runtimeInterval > 0 {
+		go func() {
+			if err := group.PublishRuntimeMetrics(ctx, slot, *runtimeInterval); err != nil {
+				slog.Error("Publishing runtime metrics stopped", "err", err)
+			}
+		}()
+	}
+
+	// Servers registered through lbd cannot read the requests; lbd's
+	// /rebalance does not reach them.
+	if direct && policy != "default" && *rebalanceInterval > 0 {
+		go func() {
+			if err := rebalancer.Watch(ctx, group, slot, *rebalanceInterval); err != nil {
+				slog.Error("Watching for rebalancing requests stopped", "err", err)
+			}
+		}()
+	}
 
-		acceptqMap, err := ebpf.LoadPinnedMap("/sys/fs/bpf/acceptq_map", nil)
+	if errCounter != nil {
+		go func() {
+			if err := errCounter.Run(ctx, *errorInterval); err != nil {
+				slog.Error("Publishing error rate stopped", "err", err)
+			}
+		}()
+	}
+
+	if direct && (policy == "healthscore" || policy == "lbd") {
+		scorer := group.NewHealthScorer(slot)
+		signals := group.HealthSignals(slot)
+		for name, weight := range health {
+			scorer.Add(reuseportlb.HealthSignal{Name: name, Weight: weight, Score: signals[name]})
+		}
+		expvar.Publish("health", expvar.Func(func() any {
+			score, parts := scorer.Snapshot()
+			return map[string]any{"score": score, "signals": parts}
+		}))
+		go func() {
+			if err := scorer.Run(ctx, *healthInterval); err != nil {
+				slog.Error("Publishing health score stopped", "err", err)
+			}
+		}()
+	}
+
+	sl := &slowListener{Listener: ln, delay: 50 * time.Millisecond}
+	serveErr := make(chan error, 1+len(extra))
+	var proxy *reuseportlb.SpliceProxy
+	if *spliceTo != "" {
+		if tlsCfg != nil {
+			return errors.New("-splice-to proxies TCP and cannot terminate TLS")
+		}
+		if len(extra) > 0 {
+			return fmt.Errorf("-splice-to proxies a single listen address, not %s", strings.Join(addrs, ","))
+		}
+		proxy, err = reuseportlb.NewSpliceProxy(*spliceMode, strings.Split(*spliceTo, ","))
 		if err != nil {
-			log.Fatalf("Unable to load acceptq map: %v", err)
+			return fmt.Errorf("start splice proxy: %w", err)
 		}
-		initialAcceptq := acceptqueueAcceptq{
-			Curr: 0,
-			Max:  1,
-			Cpu:  0,
+		defer proxy.Close()
+		expvar.Publish("splice", expvar.Func(func() any {
+			st := proxy.Stats()
+			return map[string]any{
+				"accepted":    st.Accepted.Load(),
+				"active":      st.Active.Load(),
+				"dial_failed": st.DialFailed.Load(),
+				"copied":      st.Copied.Load(),
+			}
+		}))
+		slog.Info("Proxying connections", "backends", *spliceTo, "mode", *spliceMode)
+	}
+	serving.Store(true)
+	go func() {
+		if proxy != nil {
+			serveErr <- proxy.Serve(sl)
+		} else if tlsCfg != nil {
+			slog.Info("Serving TLS")
+			serveErr <- server.ServeTLS(sl, "", "")
+		} else {
+			serveErr <- server.Serve(sl)
 		}
-		if err := acceptqMap.Update(&cookie, &initialAcceptq, ebpf.UpdateAny); err != nil {
-			acceptqMap.Close()
-			log.Fatalf("Unable to initialize acceptq map for cookie: %v", err)
+	}()
+	for _, eln := range extra {
+		go func(eln net.Listener) {
+			if tlsCfg != nil {
+				serveErr <- server.ServeTLS(eln, "", "")
+			} else {
+				serveErr <- server.Serve(eln)
+			}
+		}(eln)
+	}
+
+	// Under hot-standby the selector passes over a slot whose heartbeat goes
+	// stale; heartbeat for as long as this instance is ready, which it stops
+	// being when it drains. Servers registered through lbd cannot write it
+	// and are judged by their listener alone.
+	if direct && policy == "hot-standby" {
+		interval := *heartbeatTimeout / 4
+		if interval <= 0 {
+			interval = reuseportlb.DefaultHeartbeatTimeout / 4
 		}
-		acceptqMap.Close()
-		log.Printf("Initialized accept queue entry for cookie 0x%x", cookie)
+		check := func() error {
+			for name, err := range ready.Check() {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			return nil
+		}
+		go func() {
+			if err := group.RunStandby(ctx, slot, interval, check); err != nil {
+				slog.Error("Hot standby heartbeat stopped", "err", err)
+			}
+		}()
 	}
 
-	err = server.Serve(&slowListener{Listener: ln, delay: 50 * time.Millisecond})
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("serve: %w", err)
+	case <-ctx.Done():
+	}
+
+	// Drain: stop new connections from being steered here, then close the
+	// listener. With tcp_migrate_req on, the kernel hands whatever is still
+	// in our accept queue to a surviving listener instead of resetting it.
+	slog.Info("Draining")
+	ready.Drain()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *drainTimeout)
+	defer cancel()
+	switch {
+	case registry != nil:
+		if err := registry.Deregister(shutdownCtx, group, slot, cookie); err != nil {
+			slog.Error("Deregistering slot via lbd failed", "err", err)
+		}
+	case policy != "default":
+		if err := group.Deregister(shutdownCtx, slot, cookie); err != nil {
+			slog.Error("Deregistering slot failed", "err", err)
+		}
+	}
+	if proxy != nil {
+		err = proxy.Shutdown(shutdownCtx)
+	} else {
+		err = server.Shutdown(shutdownCtx)
+	}
 	if err != nil {
-		log.Fatalf("Unable to start HTTP server: %v", err)
+		slog.Error("Drain did not complete", "err", err)
 	}
+	slog.Info("Drained")
+	return nil
 }
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/netip"
+	"time"
+
+	"go-http-server/reuseportlb"
+)
+
+// tlsConfig builds the server TLS config from either a cert/key pair on disk
+// or, when selfSigned names a key type, a freshly generated certificate.
+// It returns nil when TLS is not requested.
+func tlsConfig(certFile, keyFile, selfSigned string) (*tls.Config, error) {
+	switch {
+	case certFile != "" || keyFile != "":
+		if certFile == "" || keyFile == "" {
+			return nil, fmt.Errorf("both -tls-cert and -tls-key are required")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load TLS key pair: %w", err)
+		}
+		return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+	case selfSigned != "":
+		cert, err := selfSignedCert(selfSigned, []string{"localhost", "127.0.0.1"})
+		if err != nil {
+			return nil, err
+		}
+		return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+	}
+	return nil, nil
+}
+
+// selfSignedCert generates a short-lived certificate for hosts. keyType is
+// "ecdsa" (P-256) or "rsa" (2048 bit); the two differ noticeably in handshake
+// CPU cost, which is what TLS experiments are usually after.
+func selfSignedCert(keyType string, hosts []string) (tls.Certificate, error) {
+	var priv crypto.Signer
+	var err error
+	switch keyType {
+	case "ecdsa":
+		priv, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "rsa":
+		priv, err = rsa.GenerateKey(rand.Reader, 2048)
+	default:
+		return tls.Certificate{}, fmt.Errorf("unknown key type %q: must be ecdsa or rsa", keyType)
+	}
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("generate %s key: %w", keyType, err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("generate serial number: %w", err)
+	}
+	tmpl := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"go-http-server"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, priv.Public(), priv)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("create certificate: %w", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: priv}, nil
+}
+
+// steerBySNI records, for every ClientHello, which tenant of steer serves the
+// requested server name, so the steer policy sends the client's later
+// connections to that tenant's slots.
+func steerBySNI(cfg *tls.Config, group reuseportlb.Group, steer reuseportlb.SteerConfig) {
+	cfg.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		tenant, ok := steer.TenantForSNI(hello.ServerName)
+		if !ok {
+			return nil, nil
+		}
+		addr, err := netip.ParseAddrPort(hello.Conn.RemoteAddr().String())
+		if err != nil {
+			return nil, nil
+		}
+		if err := group.RecordClientTenant(addr.Addr(), tenant); err != nil {
+			slog.Warn("Recording client tenant failed", "sni", hello.ServerName, "err", err)
+		}
+		return nil, nil
+	}
+}
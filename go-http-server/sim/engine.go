@@ -0,0 +1,278 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+
+	"go-http-server/reuseportlb"
+)
+
+// config describes one simulated experiment; every policy is run against
+// the same arrivals.
+type config struct {
+	Slots    int
+	Workers  int // concurrent requests a slot serves, i.e. its CPUs
+	Backlog  int // accept queue length; arrivals beyond it are dropped
+	Duration time.Duration
+	Rate     float64 // new connections per second
+	Arrival  string  // poisson or constant
+	Service  string  // exp, constant or lognormal
+	Mean     time.Duration
+	// Slow makes slot i's service times Slow[i] times longer, standing in
+	// for a busier or slower machine.
+	Slow []float64
+	// Interval and Alpha are the collector's update interval and EWMA
+	// smoothing factor for slot_util.
+	Interval time.Duration
+	Alpha    float64
+	// SpillPct is the spillover policy's accept queue threshold in percent.
+	SpillPct uint
+	Seed     int64
+}
+
+type eventKind int
+
+const (
+	evArrival eventKind = iota
+	evDone
+	evTick
+)
+
+type event struct {
+	at   time.Duration
+	kind eventKind
+	slot int
+	conn *conn
+}
+
+// eventQueue is a min-heap of events by time.
+type eventQueue []event
+
+func (q eventQueue) Len() int           { return len(q) }
+func (q eventQueue) Less(i, j int) bool { return q[i].at < q[j].at }
+func (q eventQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *eventQueue) Push(x any)        { *q = append(*q, x.(event)) }
+func (q *eventQueue) Pop() any {
+	e := (*q)[len(*q)-1]
+	*q = (*q)[:len(*q)-1]
+	return e
+}
+
+func (q *eventQueue) push(e event) { heap.Push(q, e) }
+func (q *eventQueue) pop() event   { return heap.Pop(q).(event) }
+func (q eventQueue) empty() bool   { return len(q) == 0 }
+
+type conn struct {
+	id      int
+	arrived time.Duration
+	service time.Duration
+}
+
+// slotState is what the maps would say about one listener.
+type slotState struct {
+	up    bool
+	queue []*conn // accepted by the kernel, not yet by a worker
+	busy  int
+
+	busyTime     time.Duration // worker time spent so far
+	lastBusyTime time.Duration // at the previous collector tick
+	avg          reuseportlb.EWMA
+	utilMap      uint32 // slot_util as the selector reads it
+
+	served int
+}
+
+type sim struct {
+	cfg    config
+	rng    *rand.Rand
+	slots  []*slotState
+	events eventQueue
+	now    time.Duration
+	rr     uint64
+
+	// trace, if set, gets connection log lines for slot i in trace(i).
+	trace func(slot int) *log.Logger
+	// acceptq, if set, gets the collector's accept queue log lines.
+	acceptq *log.Logger
+	start   time.Time
+
+	latencies []time.Duration
+	dropped   int
+}
+
+// result summarizes one policy's run.
+type result struct {
+	Policy    string        `json:"policy"`
+	Served    []int         `json:"served"`
+	Dropped   int           `json:"dropped"`
+	P50       time.Duration `json:"p50_ns"`
+	P99       time.Duration `json:"p99_ns"`
+	Mean      time.Duration `json:"mean_ns"`
+	Jain      float64       `json:"jain"`
+	MaxSkew   float64       `json:"max_skew"`
+	UtilJain  float64       `json:"util_jain"`
+	SlotUtils []float64     `json:"slot_utils"`
+}
+
+// newSim prepares a run. Arrivals come from their own generator seeded with
+// cfg.Seed, so every policy sees the same clients.
+func newSim(cfg config) *sim {
+	s := &sim{cfg: cfg, rng: policyRand(cfg.Seed), start: time.Unix(0, 0).UTC()}
+	for i := 0; i < cfg.Slots; i++ {
+		s.slots = append(s.slots, &slotState{up: true, avg: reuseportlb.EWMA{Alpha: cfg.Alpha}})
+	}
+	arrivals := rand.New(rand.NewSource(cfg.Seed))
+	var at time.Duration
+	for id := 0; ; id++ {
+		at += s.interarrival(arrivals)
+		if at >= cfg.Duration {
+			break
+		}
+		s.events.push(event{at: at, kind: evArrival, conn: &conn{id: id, arrived: at, service: s.serviceTime(arrivals)}})
+	}
+	if cfg.Interval > 0 {
+		s.events.push(event{at: cfg.Interval, kind: evTick})
+	}
+	return s
+}
+
+// policyRand returns the generator the policies draw from, apart from the
+// arrivals' so that a policy's draws do not change what the others see.
+func policyRand(seed int64) *rand.Rand { return rand.New(rand.NewSource(seed + 1)) }
+
+func (s *sim) interarrival(r *rand.Rand) time.Duration {
+	mean := float64(time.Second) / s.cfg.Rate
+	if s.cfg.Arrival == "constant" {
+		return time.Duration(mean)
+	}
+	return time.Duration(r.ExpFloat64() * mean)
+}
+
+func (s *sim) serviceTime(r *rand.Rand) time.Duration {
+	mean := float64(s.cfg.Mean)
+	switch s.cfg.Service {
+	case "constant":
+		return time.Duration(mean)
+	case "lognormal":
+		// sigma 1: a heavy tail, with the median at mean/e^0.5.
+		const sigma = 1.0
+		return time.Duration(mean * math.Exp(sigma*r.NormFloat64()-sigma*sigma/2))
+	}
+	return time.Duration(r.ExpFloat64() * mean)
+}
+
+// run plays the events until the last connection is served.
+func (s *sim) run(p policy) {
+	for !s.events.empty() {
+		ev := s.events.pop()
+		s.now = ev.at
+		switch ev.kind {
+		case evArrival:
+			slot := p(s)
+			if slot < 0 || slot >= len(s.slots) || !s.slots[slot].up {
+				s.dropped++
+				continue
+			}
+			sl := s.slots[slot]
+			switch {
+			case sl.busy < s.cfg.Workers:
+				s.startService(slot, ev.conn)
+			case len(sl.queue) < s.cfg.Backlog:
+				sl.queue = append(sl.queue, ev.conn)
+			default:
+				s.dropped++
+				continue
+			}
+			s.record(slot, ev.conn, "open")
+		case evDone:
+			sl := s.slots[ev.slot]
+			sl.busy--
+			sl.served++
+			s.latencies = append(s.latencies, s.now-ev.conn.arrived)
+			s.record(ev.slot, ev.conn, "close")
+			if len(sl.queue) > 0 {
+				next := sl.queue[0]
+				sl.queue = sl.queue[1:]
+				s.startService(ev.slot, next)
+			}
+		case evTick:
+			s.tick()
+			// Keep ticking only while there is something left to happen.
+			if !s.events.empty() {
+				s.events.push(event{at: s.now + s.cfg.Interval, kind: evTick})
+			}
+		}
+	}
+}
+
+func (s *sim) startService(slot int, c *conn) {
+	sl := s.slots[slot]
+	d := c.service
+	if slot < len(s.cfg.Slow) && s.cfg.Slow[slot] > 0 {
+		d = time.Duration(float64(d) * s.cfg.Slow[slot])
+	}
+	sl.busy++
+	sl.busyTime += d
+	s.events.push(event{at: s.now + d, kind: evDone, slot: slot, conn: c})
+}
+
+// tick is the collector's update: each slot's utilization over the last
+// interval is folded into its EWMA and written to slot_util as a percentage.
+// Service time is booked when a request starts, which at this granularity
+// is close enough to when the CPUs are busy.
+func (s *sim) tick() {
+	capacity := float64(s.cfg.Interval) * float64(s.cfg.Workers)
+	ts := s.start.Add(s.now).Format(time.RFC3339)
+	for i, sl := range s.slots {
+		util := math.Min(1, float64(sl.busyTime-sl.lastBusyTime)/capacity)
+		sl.lastBusyTime = sl.busyTime
+		sl.utilMap = uint32(sl.avg.Update(util) * 100)
+		if s.acceptq != nil {
+			pct := float64(len(sl.queue)) / float64(s.cfg.Backlog) * 100
+			s.acceptq.Printf("ts=%s slot=%d cookie=0x%x curr=%d max=%d cpu=%d util=%.2f",
+				ts, i, simCookie(i), len(sl.queue), s.cfg.Backlog, i, pct)
+		}
+	}
+}
+
+// record writes a line in the servers' -conn-log format, so
+// workloads/connlog-query.py reads simulated runs like real ones.
+func (s *sim) record(slot int, c *conn, event string) {
+	if s.trace == nil {
+		return
+	}
+	client := fmt.Sprintf("10.%d.%d.%d:%d", c.id>>16&0xff, c.id>>8&0xff, c.id&0xff, 32768+c.id%28232)
+	s.trace(slot).Printf("ts=%s event=%s client=%s slot=%d cookie=0x%x",
+		s.start.Add(s.now).Format(time.RFC3339Nano), event, client, slot, simCookie(slot))
+}
+
+// simCookie stands in for a listener's socket cookie.
+func simCookie(slot int) uint64 { return 0x5111 + uint64(slot) }
+
+func (s *sim) result(name string) result {
+	r := result{Policy: name, Dropped: s.dropped}
+	for _, sl := range s.slots {
+		r.Served = append(r.Served, sl.served)
+		util := 0.0
+		if s.now > 0 {
+			util = float64(sl.busyTime) / (float64(s.now) * float64(s.cfg.Workers))
+		}
+		r.SlotUtils = append(r.SlotUtils, util)
+	}
+	if n := len(s.latencies); n > 0 {
+		sort.Slice(s.latencies, func(i, j int) bool { return s.latencies[i] < s.latencies[j] })
+		r.P50 = s.latencies[n/2]
+		r.P99 = s.latencies[n*99/100]
+		var sum time.Duration
+		for _, l := range s.latencies {
+			sum += l
+		}
+		r.Mean = sum / time.Duration(n)
+	}
+	return r
+}
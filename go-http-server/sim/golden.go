@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// A goldenTrace is every decision one policy made in a reference run, with
+// the state of the slots as it saw them. Replaying it hands the same states
+// to the policy's port in the same order and checks it still decides the
+// same way, so a change to a port that moves connections shows up even when
+// the summary table barely moves.
+//
+// The kernel's own traces (shadow_events) record which slot was picked but
+// not what the maps held at the time, so they cannot be replayed; golden
+// traces come from simulated runs.
+type goldenTrace struct {
+	Policy string `json:"policy"`
+	// Seed, Backlog and SpillPct are what the policy reads besides the
+	// slots: its random numbers, and the thresholds spillover compares with.
+	Seed      int64            `json:"seed"`
+	Backlog   int              `json:"backlog"`
+	SpillPct  uint             `json:"spill_pct"`
+	Decisions []goldenDecision `json:"decisions"`
+}
+
+// goldenDecision is one connection: per slot its accept queue length,
+// requests being served, slot_util and whether it was listening (1 or 0),
+// and the slot the policy picked, -1 for none.
+type goldenDecision struct {
+	State [][4]int `json:"s"`
+	Slot  int      `json:"slot"`
+}
+
+// recordGolden wraps p so that every decision it makes is added to t.
+func recordGolden(t *goldenTrace, p policy) policy {
+	return func(s *sim) int {
+		state := make([][4]int, len(s.slots))
+		for i, sl := range s.slots {
+			up := 0
+			if sl.up {
+				up = 1
+			}
+			state[i] = [4]int{len(sl.queue), sl.busy, int(sl.utilMap), up}
+		}
+		slot := p(s)
+		t.Decisions = append(t.Decisions, goldenDecision{State: state, Slot: slot})
+		return slot
+	}
+}
+
+// goldenPath is where the trace of policy lives under dir.
+func goldenPath(dir, policy string) string {
+	return filepath.Join(dir, policy+".json")
+}
+
+func writeGolden(dir string, t *goldenTrace) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(goldenPath(dir, t.Policy), append(data, '\n'), 0o644)
+}
+
+// A goldenDiff is a recorded decision the current port makes differently.
+type goldenDiff struct {
+	Index int // into the trace's decisions
+	Slot  int // what the port picks now
+}
+
+// replayGolden feeds the recorded states to the policy's current port and
+// returns the decisions that came out differently.
+func replayGolden(t *goldenTrace) ([]goldenDiff, error) {
+	p, ok := policies[t.Policy]
+	if !ok {
+		return nil, fmt.Errorf("no simulated policy %q", t.Policy)
+	}
+	if len(t.Decisions) == 0 {
+		return nil, errors.New("trace has no decisions")
+	}
+	slots := len(t.Decisions[0].State)
+	s := &sim{cfg: config{Slots: slots, Backlog: t.Backlog, SpillPct: t.SpillPct, Seed: t.Seed}, rng: policyRand(t.Seed)}
+	for i := 0; i < slots; i++ {
+		s.slots = append(s.slots, &slotState{})
+	}
+	var differ []goldenDiff
+	for i, d := range t.Decisions {
+		if len(d.State) != slots {
+			return nil, fmt.Errorf("decision %d: %d slots, want %d", i, len(d.State), slots)
+		}
+		for j, st := range d.State {
+			sl := s.slots[j]
+			sl.queue = make([]*conn, st[0])
+			sl.busy, sl.utilMap, sl.up = st[1], uint32(st[2]), st[3] != 0
+		}
+		if slot := p(s); slot != d.Slot {
+			differ = append(differ, goldenDiff{Index: i, Slot: slot})
+		}
+	}
+	return differ, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"go-http-server/reuseportlb"
+)
+
+var update = flag.Bool("update", false, "record the golden traces again instead of checking them")
+
+const goldenDir = "golden"
+
+// goldenConfig is the run the traces under golden were recorded from: short
+// enough to keep them small, loaded enough that queues build up, with one
+// slow slot so the load-aware policies have something to avoid.
+var goldenConfig = config{
+	Slots:    4,
+	Workers:  1,
+	Backlog:  16,
+	Duration: 500 * time.Millisecond,
+	Rate:     1500,
+	Arrival:  "poisson",
+	Service:  "exp",
+	Mean:     2 * time.Millisecond,
+	Slow:     []float64{1, 1, 1, 3},
+	Interval: 100 * time.Millisecond,
+	Alpha:    0.3,
+	SpillPct: reuseportlb.DefaultSpillThresholdPct,
+	Seed:     1,
+}
+
+// TestGolden replays each policy's trace under golden against its current
+// port. After a deliberate change to a port, run go test ./sim -update.
+func TestGolden(t *testing.T) {
+	if *update {
+		for name, p := range policies {
+			trace := &goldenTrace{Policy: name, Seed: goldenConfig.Seed, Backlog: goldenConfig.Backlog, SpillPct: goldenConfig.SpillPct}
+			newSim(goldenConfig).run(recordGolden(trace, p))
+			if err := writeGolden(goldenDir, trace); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	paths, err := filepath.Glob(filepath.Join(goldenDir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatalf("no traces in %s", goldenDir)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		t.Run(strings.TrimSuffix(filepath.Base(path), ".json"), func(t *testing.T) {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var trace goldenTrace
+			if err := json.Unmarshal(data, &trace); err != nil {
+				t.Fatalf("%s: %v", path, err)
+			}
+			differ, err := replayGolden(&trace)
+			if err != nil {
+				t.Fatalf("%s: %v", path, err)
+			}
+			const shown = 5
+			for _, diff := range differ[:min(len(differ), shown)] {
+				d := trace.Decisions[diff.Index]
+				t.Errorf("decision %d on %v: recorded slot %d, now %d", diff.Index, d.State, d.Slot, diff.Slot)
+			}
+			if len(differ) > shown {
+				t.Errorf("%d of %d decisions differ in all", len(differ), len(trace.Decisions))
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+// Command sim compares balancing policies offline. It is a discrete-event
+// simulation of one reuseport group: N listeners, each serving -workers
+// requests at a time with an accept queue of -backlog, new connections
+// arriving at -rate, and each policy's selector ported to Go deciding where
+// every connection goes. All policies see the same arrivals, so differences
+// in the table are the policies'.
+//
+//	sim -policy all -slots 4 -rate 2000 -service-mean 1ms -slow 1,1,1,3
+//
+// With -logdir it also writes what a real run would have logged: one
+// conn<slot>.log per slot in the servers' -conn-log format and the
+// collector's acceptq_stats log, under <logdir>/<policy>/, so
+// workloads/connlog-query.py and anything else reading those logs work on
+// simulated runs too.
+//
+// With -record it also writes every decision each policy made, with the
+// slot states it made it on, as a golden trace per policy. sim/golden holds
+// the reference traces: go test ./sim feeds them back to the current ports
+// and fails if any decides differently, and go test ./sim -update records
+// them again after a deliberate change to a port.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"go-http-server/reuseportlb"
+	"go-http-server/stats"
+)
+
+func main() {
+	var cfg config
+	policyList := flag.String("policy", "all", "comma-separated policies to simulate, or all")
+	flag.IntVar(&cfg.Slots, "slots", 4, "number of listeners in the group")
+	flag.IntVar(&cfg.Workers, "workers", 1, "requests each listener serves at once (its CPUs)")
+	flag.IntVar(&cfg.Backlog, "backlog", 128, "accept queue length of each listener")
+	flag.DurationVar(&cfg.Duration, "duration", time.Minute, "simulated time over which connections arrive")
+	flag.Float64Var(&cfg.Rate, "rate", 1000, "new connections per second")
+	flag.StringVar(&cfg.Arrival, "arrival", "poisson", "arrival process: poisson or constant")
+	flag.StringVar(&cfg.Service, "service", "exp", "service time distribution: exp, constant or lognormal")
+	flag.DurationVar(&cfg.Mean, "service-mean", 2*time.Millisecond, "mean service time")
+	slow := flag.String("slow", "", "comma-separated service time multipliers per slot, e.g. 1,1,2 makes slot 2 half as fast")
+	flag.DurationVar(&cfg.Interval, "interval", 100*time.Millisecond, "collector update interval for slot_util")
+	flag.Float64Var(&cfg.Alpha, "alpha", 0.3, "EWMA smoothing factor for slot_util")
+	flag.UintVar(&cfg.SpillPct, "spill-threshold-pct", reuseportlb.DefaultSpillThresholdPct, "accept queue fill, in percent, at which the spillover policy moves on to the next slot")
+	flag.Int64Var(&cfg.Seed, "seed", 1, "random seed")
+	logDir := flag.String("logdir", "", "write simulated connection and accept queue logs under this directory")
+	record := flag.String("record", "", "write each policy's decisions as a golden trace under this directory")
+	asJSON := flag.Bool("json", false, "print JSON instead of a table")
+	flag.Parse()
+
+	names, err := parsePolicies(*policyList)
+	if err != nil {
+		reuseportlb.Fatal("invalid -policy", "err", err)
+	}
+	if cfg.Slots < 1 || cfg.Workers < 1 || cfg.Backlog < 0 || cfg.Rate <= 0 || cfg.Mean <= 0 {
+		reuseportlb.Fatal("-slots, -workers, -rate and -service-mean must be positive")
+	}
+	if cfg.Alpha <= 0 || cfg.Alpha > 1 {
+		reuseportlb.Fatal("-alpha must be in (0, 1]")
+	}
+	if *slow != "" {
+		for _, f := range strings.Split(*slow, ",") {
+			v, err := strconv.ParseFloat(strings.TrimSpace(f), 64)
+			if err != nil || v <= 0 {
+				reuseportlb.Fatal("invalid -slow", "value", f)
+			}
+			cfg.Slow = append(cfg.Slow, v)
+		}
+	}
+
+	var results []result
+	for _, name := range names {
+		s := newSim(cfg)
+		if *logDir != "" {
+			closeLogs, err := s.openLogs(filepath.Join(*logDir, name))
+			if err != nil {
+				reuseportlb.Fatal("opening logs failed", "err", err)
+			}
+			defer closeLogs()
+		}
+		p := policies[name]
+		var trace *goldenTrace
+		if *record != "" {
+			trace = &goldenTrace{Policy: name, Seed: cfg.Seed, Backlog: cfg.Backlog, SpillPct: cfg.SpillPct}
+			p = recordGolden(trace, p)
+		}
+		s.run(p)
+		if trace != nil {
+			if err := writeGolden(*record, trace); err != nil {
+				reuseportlb.Fatal("writing golden trace failed", "err", err)
+			}
+		}
+
+		r := s.result(name)
+		r.Jain = stats.JainIndex(stats.Float64s(r.Served))
+		r.MaxSkew = stats.MaxSkew(stats.Float64s(r.Served))
+		r.UtilJain = stats.JainIndex(r.SlotUtils)
+		results = append(results, r)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(results)
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "policy\tserved\tdropped\tp50\tp99\tmean\tjain\tskew\tutil\tutil_jain")
+	for _, r := range results {
+		utils := make([]string, len(r.SlotUtils))
+		for i, u := range r.SlotUtils {
+			utils[i] = fmt.Sprintf("%.0f%%", 100*u)
+		}
+		fmt.Fprintf(w, "%s\t%v\t%d\t%s\t%s\t%s\t%.3f\t%.1f%%\t%s\t%.3f\n",
+			r.Policy, r.Served, r.Dropped, r.P50.Round(time.Microsecond), r.P99.Round(time.Microsecond),
+			r.Mean.Round(time.Microsecond), r.Jain, 100*r.MaxSkew, strings.Join(utils, ","), r.UtilJain)
+	}
+	w.Flush()
+}
+
+// openLogs points the run's traces at files under dir.
+func (s *sim) openLogs(dir string) (func(), error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	var files []*os.File
+	closeAll := func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+	open := func(name string) (*log.Logger, error) {
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+		return log.New(f, "", 0), nil
+	}
+
+	conns := make([]*log.Logger, len(s.slots))
+	for i := range conns {
+		l, err := open(fmt.Sprintf("conn%d.log", i))
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		conns[i] = l
+	}
+	s.trace = func(slot int) *log.Logger { return conns[slot] }
+
+	acceptq, err := open("acceptq_stats_sim.log")
+	if err != nil {
+		closeAll()
+		return nil, err
+	}
+	s.acceptq = acceptq
+	return closeAll, nil
+}
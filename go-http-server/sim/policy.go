@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A policy is the Go port of one selector's decision: given the group's
+// state as the selector would see it in its maps, pick the slot for a new
+// connection, or -1 to drop it. Keep these in step with eBPF/*.c.
+type policy func(s *sim) int
+
+// policies maps the names LoadPolicy knows to their ports.
+var policies = map[string]policy{
+	// default is the kernel's own choice, a hash of the 4-tuple, which for
+	// many clients is as good as uniform.
+	"default": func(s *sim) int {
+		return s.rng.Intn(len(s.slots))
+	},
+	// hotstandby.c with its defaults: slot 0 while it is listening, slot 1
+	// otherwise. Simulated slots never miss a heartbeat.
+	"hot-standby": func(s *sim) int {
+		for _, slot := range []int{0, 1} {
+			if slot < len(s.slots) && s.slots[slot].up {
+				return slot
+			}
+		}
+		return -1
+	},
+	// pickfirst.c: always slot 0.
+	"pickfirst": func(s *sim) int {
+		if s.slots[0].up {
+			return 0
+		}
+		return -1
+	},
+	// roundrobin.c: the next slot after the shared counter that is listening.
+	"round-robin": func(s *sim) int {
+		n := len(s.slots)
+		start := int(s.rr % uint64(n))
+		s.rr++
+		for i := 0; i < n; i++ {
+			if slot := (start + i) % n; s.slots[slot].up {
+				return slot
+			}
+		}
+		return -1
+	},
+	// acceptqueue.c: the lowest accept queue depth, first slot on ties.
+	"acceptqueue": func(s *sim) int {
+		best, lowest := -1, int(^uint(0)>>1)
+		for i, sl := range s.slots {
+			if sl.up && len(sl.queue) < lowest {
+				best, lowest = i, len(sl.queue)
+			}
+		}
+		return best
+	},
+	// jsq.c with random tie-break: the fewest open connections, queued or
+	// being served.
+	"jsq": func(s *sim) int {
+		var tied []int
+		shortest := int(^uint(0) >> 1)
+		for i, sl := range s.slots {
+			if !sl.up {
+				continue
+			}
+			if d := len(sl.queue) + sl.busy; d < shortest {
+				shortest, tied = d, []int{i}
+			} else if d == shortest {
+				tied = append(tied, i)
+			}
+		}
+		if len(tied) == 0 {
+			return -1
+		}
+		return tied[s.rng.Intn(len(tied))]
+	},
+	// spillover.c: the first slot whose accept queue is under the threshold,
+	// else the least full one.
+	"spillover": func(s *sim) int {
+		least, leastFill := -1, 0.0
+		for i, sl := range s.slots {
+			if !sl.up {
+				continue
+			}
+			fill := 0.0
+			if s.cfg.Backlog > 0 {
+				fill = float64(len(sl.queue)) * 100 / float64(s.cfg.Backlog)
+			}
+			if fill < float64(s.cfg.SpillPct) {
+				return i
+			}
+			if least < 0 || fill < leastFill {
+				least, leastFill = i, fill
+			}
+		}
+		return least
+	},
+	// cpuutil.c: the lowest smoothed utilization the collector last wrote
+	// into slot_util, first slot on ties.
+	"cpuutil": func(s *sim) int {
+		best, lowest := -1, ^uint32(0)
+		for i, sl := range s.slots {
+			if sl.up && sl.utilMap < lowest {
+				best, lowest = i, sl.utilMap
+			}
+		}
+		return best
+	},
+}
+
+// parsePolicies resolves a comma-separated list, or "all".
+func parsePolicies(s string) ([]string, error) {
+	var names []string
+	if s == "all" {
+		for name := range policies {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names, nil
+	}
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if _, ok := policies[name]; !ok {
+			known := make([]string, 0, len(policies))
+			for k := range policies {
+				known = append(known, k)
+			}
+			sort.Strings(known)
+			return nil, fmt.Errorf("no simulated policy %q (have %s)", name, strings.Join(known, ", "))
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
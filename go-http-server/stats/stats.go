@@ -0,0 +1,162 @@
+// Package stats checks how evenly a policy spread load: how many connections
+// each slot accepted, or how busy each slot's CPUs were. Experiment drivers
+// and smoke tests use the Assert functions to turn "round-robin looked fair"
+// into a pass, or a failure with the numbers in it.
+package stats
+
+import (
+	"fmt"
+	"math"
+)
+
+// ChiSquaredUniform tests counts against a uniform distribution over the
+// slots. It returns Pearson's statistic and the p-value: the probability of
+// a spread at least this uneven if every slot were equally likely. A small
+// p-value means the policy is not uniform.
+func ChiSquaredUniform(counts []float64) (stat, p float64) {
+	if len(counts) < 2 {
+		return 0, 1
+	}
+	total := sum(counts)
+	if total == 0 {
+		return 0, 1
+	}
+	expected := total / float64(len(counts))
+	for _, c := range counts {
+		d := c - expected
+		stat += d * d / expected
+	}
+	df := float64(len(counts) - 1)
+	return stat, gammaQ(df/2, stat/2)
+}
+
+// MaxSkew is the largest relative deviation of any slot from the mean:
+// 0.1 means some slot got 10% more or less than an even share.
+func MaxSkew(counts []float64) float64 {
+	if len(counts) == 0 {
+		return 0
+	}
+	mean := sum(counts) / float64(len(counts))
+	if mean == 0 {
+		return 0
+	}
+	var skew float64
+	for _, c := range counts {
+		skew = math.Max(skew, math.Abs(c-mean)/mean)
+	}
+	return skew
+}
+
+// JainIndex is Jain's fairness index, (Σx)² / (n·Σx²): 1 when every slot got
+// the same, 1/n when one slot got everything.
+func JainIndex(xs []float64) float64 {
+	var s, sq float64
+	for _, x := range xs {
+		s += x
+		sq += x * x
+	}
+	if sq == 0 {
+		return 1
+	}
+	return s * s / (float64(len(xs)) * sq)
+}
+
+// AssertUniform fails if the chi-squared test rejects a uniform spread at
+// significance alpha (0.01 is a reasonable default: a fair policy fails one
+// run in a hundred).
+func AssertUniform(counts []float64, alpha float64) error {
+	stat, p := ChiSquaredUniform(counts)
+	if p < alpha {
+		return fmt.Errorf("counts %v are not uniform: chi-squared %.2f with %d degrees of freedom, p=%.3g < %g",
+			counts, stat, len(counts)-1, p, alpha)
+	}
+	return nil
+}
+
+// AssertMaxSkew fails if some slot deviates from the mean by more than max,
+// relative to the mean.
+func AssertMaxSkew(counts []float64, max float64) error {
+	if skew := MaxSkew(counts); skew > max {
+		return fmt.Errorf("counts %v skew %.1f%% from the mean, more than %.1f%%", counts, 100*skew, 100*max)
+	}
+	return nil
+}
+
+// AssertFair fails if Jain's fairness index of xs is below min.
+func AssertFair(xs []float64, min float64) error {
+	if j := JainIndex(xs); j < min {
+		return fmt.Errorf("values %v have fairness index %.3f, below %.3f", xs, j, min)
+	}
+	return nil
+}
+
+// Float64s converts per-slot integer counts for the functions above.
+func Float64s[T ~int | ~int64 | ~uint32 | ~uint64](xs []T) []float64 {
+	out := make([]float64, len(xs))
+	for i, x := range xs {
+		out[i] = float64(x)
+	}
+	return out
+}
+
+func sum(xs []float64) float64 {
+	var s float64
+	for _, x := range xs {
+		s += x
+	}
+	return s
+}
+
+// gammaQ is the regularized upper incomplete gamma function Q(a, x), which
+// gives the chi-squared survival function as Q(df/2, stat/2). It uses the
+// series for P below a+1 and Lentz's continued fraction above, as in
+// Numerical Recipes.
+func gammaQ(a, x float64) float64 {
+	const (
+		maxIter = 500
+		eps     = 1e-14
+		tiny    = 1e-300
+	)
+	if x <= 0 {
+		return 1
+	}
+	lg, _ := math.Lgamma(a)
+	prefix := math.Exp(a*math.Log(x) - x - lg)
+
+	if x < a+1 {
+		term := 1 / a
+		s := term
+		for n := 1; n < maxIter; n++ {
+			term *= x / (a + float64(n))
+			s += term
+			if math.Abs(term) < math.Abs(s)*eps {
+				break
+			}
+		}
+		return math.Max(0, 1-s*prefix)
+	}
+
+	b := x + 1 - a
+	c := 1 / tiny
+	d := 1 / b
+	h := d
+	for i := 1; i < maxIter; i++ {
+		an := -float64(i) * (float64(i) - a)
+		b += 2
+		d = an*d + b
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = b + an/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		delta := d * c
+		h *= delta
+		if math.Abs(delta-1) < eps {
+			break
+		}
+	}
+	return prefix * h
+}
@@ -0,0 +1,117 @@
+package stats
+
+import (
+	"math"
+	"testing"
+)
+
+// approx reports whether got is within a relative 1e-9 of want, so that
+// tiny p-values are compared as closely as large ones.
+func approx(got, want float64) bool {
+	return math.Abs(got-want) <= 1e-9*math.Max(math.Abs(want), math.SmallestNonzeroFloat64)
+}
+
+// Critical values from a chi-squared table, and df=4 whose survival function
+// has the closed form e^(-x/2)(1 + x/2).
+func TestChiSquaredSurvival(t *testing.T) {
+	for _, tc := range []struct {
+		df, stat, want float64
+	}{
+		{1, 3.841, 0.05},
+		{1, 6.635, 0.01},
+		{10, 18.307, 0.05},
+		{10, 23.209, 0.01},
+		{4, 4, 3 * math.Exp(-2)},
+		{4, 0, 1},
+	} {
+		if p := gammaQ(tc.df/2, tc.stat/2); math.Abs(p-tc.want) > 1e-4 {
+			t.Errorf("df=%g chi-squared=%g: p=%.5f, want %.5f", tc.df, tc.stat, p, tc.want)
+		}
+	}
+}
+
+func TestChiSquaredUniform(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		counts         []float64
+		wantStat, want float64
+	}{
+		{"empty", nil, 0, 1},
+		{"single slot", []float64{42}, 0, 1},
+		{"all zero", []float64{0, 0, 0}, 0, 1},
+		{"uniform", []float64{100, 100, 100, 100}, 0, 1},
+		// (10² + 10²) / 50 = 4, and P(χ²₁ ≥ 4) = erfc(√2).
+		{"uneven", []float64{60, 40}, 4, math.Erfc(math.Sqrt2)},
+		{"one slot", []float64{0, 0, 30}, 60, math.Exp(-30)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			stat, p := ChiSquaredUniform(tc.counts)
+			if !approx(stat, tc.wantStat) || !approx(p, tc.want) {
+				t.Errorf("got chi-squared %g, p=%g; want %g, p=%g", stat, p, tc.wantStat, tc.want)
+			}
+		})
+	}
+}
+
+func TestMaxSkew(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		counts []float64
+		want   float64
+	}{
+		{"empty", nil, 0},
+		{"single slot", []float64{42}, 0},
+		{"all zero", []float64{0, 0, 0}, 0},
+		{"uniform", []float64{7, 7, 7}, 0},
+		{"uneven", []float64{150, 50, 100}, 0.5},
+		{"one slot", []float64{0, 0, 0, 8}, 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := MaxSkew(tc.counts); !approx(got, tc.want) {
+				t.Errorf("got %g, want %g", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestJainIndex(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		xs   []float64
+		want float64
+	}{
+		{"empty", nil, 1},
+		{"single slot", []float64{42}, 1},
+		{"all zero", []float64{0, 0, 0}, 1},
+		{"uniform", []float64{7, 7, 7, 7}, 1},
+		{"uneven", []float64{1, 2, 3}, 36.0 / 42},
+		{"one slot", []float64{0, 0, 0, 8}, 0.25},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := JainIndex(tc.xs); !approx(got, tc.want) {
+				t.Errorf("got %g, want %g", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAssert(t *testing.T) {
+	uniform := []float64{100, 100, 100, 100}
+	skewed := []float64{150, 50, 100, 100}
+	for _, tc := range []struct {
+		name    string
+		err     error
+		wantErr bool
+	}{
+		{"uniform", AssertUniform(uniform, 0.01), false},
+		{"not uniform", AssertUniform(skewed, 0.01), true},
+		{"skew within", AssertMaxSkew(skewed, 0.5), false},
+		{"skew beyond", AssertMaxSkew(skewed, 0.4), true},
+		{"fair", AssertFair(uniform, 0.99), false},
+		{"unfair", AssertFair(skewed, 0.99), true},
+	} {
+		if (tc.err != nil) != tc.wantErr {
+			t.Errorf("%s: got %v, want error %v", tc.name, tc.err, tc.wantErr)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+// Command udsdemo balances an RPC service on a Unix socket across worker
+// processes with reuseportlb.UnixBalancer, the local IPC counterpart of a
+// reuseport group. Run a balancer, a few workers and a client:
+//
+//	udsdemo -role balancer -socket /run/udsdemo.sock -policy round-robin
+//	udsdemo -role worker -socket /run/udsdemo.sock -slot 0
+//	udsdemo -role worker -socket /run/udsdemo.sock -slot 1
+//	udsdemo -role client -socket /run/udsdemo.sock -conns 20
+//
+// Workers serve a unary gRPC method, /udsdemo.Echo/Say, with a grpc.Server
+// on the listener ListenUnixWorker returns, using a JSON codec (content
+// type application/grpc+json) so no generated code is needed. The client
+// dials unix:// -conns times, calls once on each connection and prints
+// which slot answered. With -policy cpuutil the balancer records workers
+// as the slot owners of -group, and lbd or collect_stats has to run for
+// that group to fill in their utilization.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding"
+
+	"go-http-server/reuseportlb"
+)
+
+const sayMethod = "/udsdemo.Echo/Say"
+
+type sayRequest struct {
+	Msg string `json:"msg"`
+}
+
+type sayReply struct {
+	Msg  string `json:"msg"`
+	Slot uint32 `json:"slot"`
+	PID  int    `json:"pid"`
+}
+
+// jsonCodec carries the messages as JSON, content type
+// application/grpc+json.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Name() string                       { return "json" }
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// echoServiceDesc is what protoc-gen-go-grpc would generate for
+//
+//	service Echo { rpc Say(SayRequest) returns (SayReply); }
+var echoServiceDesc = grpc.ServiceDesc{
+	ServiceName: "udsdemo.Echo",
+	HandlerType: (*echoServer)(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "Say",
+		Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+			req := new(sayRequest)
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			say := func(ctx context.Context, req any) (any, error) { return srv.(echoServer).Say(ctx, req.(*sayRequest)) }
+			if interceptor == nil {
+				return say(ctx, req)
+			}
+			return interceptor(ctx, req, &grpc.UnaryServerInfo{Server: srv, FullMethod: sayMethod}, say)
+		},
+	}},
+}
+
+type echoServer interface {
+	Say(context.Context, *sayRequest) (*sayReply, error)
+}
+
+// echo answers with the worker that served the call.
+type echo struct{ slot uint32 }
+
+func (e echo) Say(_ context.Context, req *sayRequest) (*sayReply, error) {
+	return &sayReply{Msg: req.Msg, Slot: e.slot, PID: os.Getpid()}, nil
+}
+
+func main() {
+	role := flag.String("role", "", "balancer, worker or client")
+	socket := flag.String("socket", "/run/udsdemo.sock", "Unix socket the service is reached at; workers register next to it")
+	policy := flag.String("policy", "round-robin", fmt.Sprintf("balancer: how connections pick a worker, one of %v", reuseportlb.UnixPolicies))
+	groupName := flag.String("group", "", "balancer: reuseport group whose slot_util the cpuutil policy reads (default group if empty)")
+	mode := flag.Uint("mode", 0o660, "balancer: permissions of the sockets")
+	slot := flag.Uint("slot", 0, "worker: slot to register as")
+	conns := flag.Int("conns", 10, "client: connections to open, one call each")
+	logLevel := flag.String("log-level", "info", "minimum log level: debug, info, warn or error")
+	logFormat := flag.String("log-format", "text", "log output format: text or json")
+	flag.Parse()
+
+	logger, err := reuseportlb.NewLogger(os.Stderr, *logLevel, *logFormat)
+	if err != nil {
+		reuseportlb.Fatal("invalid logging flags", "err", err)
+	}
+	slog.SetDefault(logger)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// The roles return their failures, so the balancer's deferred calls
+	// remove its sockets first.
+	switch *role {
+	case "balancer":
+		var g reuseportlb.Group
+		if g, err = reuseportlb.ParseGroup(*groupName); err != nil {
+			reuseportlb.Fatal("invalid -group", "err", err)
+		}
+		err = runBalancer(ctx, g, *socket, *policy, os.FileMode(*mode))
+	case "worker":
+		err = runWorker(ctx, *socket, uint32(*slot))
+	case "client":
+		err = runClient(*socket, *conns)
+	default:
+		reuseportlb.Fatal("-role must be balancer, worker or client", "role", *role)
+	}
+	if err != nil {
+		reuseportlb.Fatal(*role+" failed", "err", err)
+	}
+}
+
+func runBalancer(ctx context.Context, g reuseportlb.Group, socket, policy string, mode os.FileMode) error {
+	b, err := reuseportlb.NewUnixBalancer(g, policy)
+	if err != nil {
+		return fmt.Errorf("invalid -policy: %w", err)
+	}
+	public, workers, err := reuseportlb.ListenUnixBalancer(socket, mode)
+	if err != nil {
+		return fmt.Errorf("listen on %s: %w", socket, err)
+	}
+	defer os.Remove(socket)
+	defer os.Remove(reuseportlb.UnixWorkerSocket(socket))
+	slog.Info("balancing", "socket", socket, "workers", reuseportlb.UnixWorkerSocket(socket), "policy", policy)
+
+	errc := make(chan error, 2)
+	go func() { errc <- b.ServeWorkers(ctx, workers) }()
+	go func() { errc <- b.Serve(ctx, public) }()
+	for i := 0; i < 2; i++ {
+		if err := <-errc; err != nil {
+			return err
+		}
+	}
+	slog.Info("shutting down", "workers", b.Workers())
+	return nil
+}
+
+func runWorker(ctx context.Context, socket string, slot uint32) error {
+	ln, err := reuseportlb.ListenUnixWorker(socket, slot)
+	if err != nil {
+		return fmt.Errorf("register: %w", err)
+	}
+	server := grpc.NewServer()
+	server.RegisterService(&echoServiceDesc, echo{slot: slot})
+	go func() {
+		<-ctx.Done()
+		server.GracefulStop()
+	}()
+	slog.Info("serving", "socket", socket, "slot", slot)
+	if err := server.Serve(ln); err != nil {
+		return fmt.Errorf("serve: %w", err)
+	}
+	return nil
+}
+
+func runClient(socket string, conns int) error {
+	// unix:///abs/path or unix:rel/path, as gRPC's resolver wants them.
+	target := "unix:" + socket
+	if filepath.IsAbs(socket) {
+		target = "unix://" + socket
+	}
+	bySlot := make(map[uint32]int)
+	for i := 0; i < conns; i++ {
+		// A channel per call, so each call opens its own connection and is
+		// balanced separately.
+		cc, err := grpc.NewClient(target,
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithDefaultCallOptions(grpc.CallContentSubtype(jsonCodec{}.Name())))
+		if err != nil {
+			return fmt.Errorf("invalid -socket: %w", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		var reply sayReply
+		err = cc.Invoke(ctx, sayMethod, &sayRequest{Msg: fmt.Sprintf("call %d", i)}, &reply)
+		cancel()
+		cc.Close()
+		if err != nil {
+			return fmt.Errorf("call %d: %w", i, err)
+		}
+		bySlot[reply.Slot]++
+	}
+	slots := make([]uint32, 0, len(bySlot))
+	for s := range bySlot {
+		slots = append(slots, s)
+	}
+	sort.Slice(slots, func(i, j int) bool { return slots[i] < slots[j] })
+	for _, s := range slots {
+		fmt.Printf("slot %d: %d\n", s, bySlot[s])
+	}
+	return nil
+}
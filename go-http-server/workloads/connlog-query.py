@@ -0,0 +1,140 @@
+#!/usr/bin/env python3
+
+"""Query the per-connection logs written by the servers' -conn-log flag."""
+
+from __future__ import annotations
+
+import argparse
+import csv
+import re
+import sys
+from collections import Counter
+from dataclasses import dataclass
+from datetime import datetime
+from pathlib import Path
+from typing import Iterable, Iterator
+
+
+@dataclass
+class ConnRecord:
+    ts: datetime
+    event: str
+    client: str
+    slot: int
+    cookie: str
+
+    @property
+    def client_ip(self) -> str:
+        host, _, _ = self.client.rpartition(":")
+        return host.strip("[]")
+
+    def as_row(self) -> dict[str, str]:
+        return {
+            "ts": self.ts.isoformat(),
+            "event": self.event,
+            "client": self.client,
+            "slot": str(self.slot),
+            "cookie": self.cookie,
+        }
+
+
+def parse_timestamp(value: str) -> datetime:
+    # Go's RFC3339Nano carries up to nine fractional digits and a "Z" suffix;
+    # fromisoformat only takes six before Python 3.11.
+    value = value.replace("Z", "+00:00")
+    value = re.sub(r"\.(\d+)", lambda m: "." + m.group(1)[:6].ljust(6, "0"), value)
+    return datetime.fromisoformat(value)
+
+
+def parse_log(path: Path) -> Iterator[ConnRecord]:
+    with path.open("r", encoding="utf-8") as handle:
+        for lineno, raw_line in enumerate(handle, 1):
+            fields = dict(
+                part.split("=", 1) for part in raw_line.split() if "=" in part
+            )
+            try:
+                yield ConnRecord(
+                    ts=parse_timestamp(fields["ts"]),
+                    event=fields["event"],
+                    client=fields["client"],
+                    slot=int(fields["slot"]),
+                    cookie=fields["cookie"],
+                )
+            except (KeyError, ValueError) as exc:
+                print(f"{path}:{lineno}: skipping malformed line ({exc})", file=sys.stderr)
+
+
+def matches(rec: ConnRecord, args: argparse.Namespace) -> bool:
+    if args.client and args.client not in (rec.client, rec.client_ip):
+        return False
+    if args.slot is not None and rec.slot != args.slot:
+        return False
+    if args.since and rec.ts < args.since:
+        return False
+    if args.until and rec.ts > args.until:
+        return False
+    return True
+
+
+def write_records(records: Iterable[ConnRecord], out) -> None:
+    fieldnames = ["ts", "event", "client", "slot", "cookie"]
+    writer = csv.DictWriter(out, fieldnames=fieldnames)
+    writer.writeheader()
+    for rec in records:
+        writer.writerow(rec.as_row())
+
+
+def print_distribution(records: list[ConnRecord]) -> None:
+    opens = [rec for rec in records if rec.event == "open"]
+    per_slot = Counter(rec.slot for rec in opens)
+    cookies = {rec.slot: rec.cookie for rec in opens}
+    total = len(opens)
+    print(f"{'slot':>4}  {'cookie':>18}  {'conns':>8}  {'share':>7}")
+    for slot in sorted(per_slot):
+        share = 100 * per_slot[slot] / total if total else 0.0
+        print(f"{slot:>4}  {cookies[slot]:>18}  {per_slot[slot]:>8}  {share:>6.2f}%")
+    print(f"total connections: {total}, distinct clients: {len({r.client_ip for r in opens})}")
+
+
+def main(argv: list[str]) -> int:
+    parser = argparse.ArgumentParser(
+        description="Show which server instance served which client connection."
+    )
+    parser.add_argument(
+        "--logs-dir",
+        default="log",
+        help="Directory containing conn*.log files (default: %(default)s).",
+    )
+    parser.add_argument("--client", help="Only connections from this ip or ip:port.")
+    parser.add_argument("--slot", type=int, help="Only connections served by this slot.")
+    parser.add_argument("--since", type=parse_timestamp, help="Only records at or after this RFC 3339 time.")
+    parser.add_argument("--until", type=parse_timestamp, help="Only records at or before this RFC 3339 time.")
+    parser.add_argument(
+        "--records",
+        action="store_true",
+        help="Print the matching records as CSV instead of the per-slot distribution.",
+    )
+    args = parser.parse_args(argv)
+
+    logs_dir = Path(args.logs_dir)
+    if not logs_dir.is_dir():
+        print(f"Logs directory not found: {logs_dir}", file=sys.stderr)
+        return 1
+    log_files = sorted(logs_dir.glob("conn*.log"))
+    if not log_files:
+        print(f"No connection logs found in {logs_dir}", file=sys.stderr)
+        return 1
+
+    records = sorted(
+        (rec for path in log_files for rec in parse_log(path) if matches(rec, args)),
+        key=lambda rec: rec.ts,
+    )
+    if args.records:
+        write_records(records, sys.stdout)
+    else:
+        print_distribution(records)
+    return 0
+
+
+if __name__ == "__main__":
+    raise SystemExit(main(sys.argv[1:]))
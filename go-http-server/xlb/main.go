@@ -0,0 +1,104 @@
+// Command xlb balances the TCP flows to a virtual address across backend
+// hosts from an XDP program on the interface clients reach it through, with
+// the round-robin and least-load policies the reuseport groups use for
+// slots on one machine. Each backend can run its own servers and lbd behind
+// it.
+//
+//	xlb -iface eth0 -vip 10.0.0.100:8080 -backends 10.0.0.11,10.0.0.12
+//	xlb -iface eth0 -vip 10.0.0.100:8080 -backends 10.0.0.11,10.0.0.12 -mode dsr -policy least-load
+//
+// In dnat mode backends must route their replies back through this host; in
+// dsr mode they sit on the same L2 segment and hold the VIP on a loopback
+// interface (ip addr add 10.0.0.100/32 dev lo), and the balancer only sees
+// the client's half of each flow. The control API serves the stats at
+// /backends and takes a new backend set or least-load loads there:
+//
+//	curl -d backends=10.0.0.11,10.0.0.13 localhost:9090/backends
+//	curl -d load=10.0.0.11=8000,10.0.0.13=2500 localhost:9090/backends
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/cilium/ebpf/rlimit"
+
+	"go-http-server/reuseportlb"
+)
+
+func main() {
+	if err := run(); err != nil {
+		reuseportlb.Fatal("xlb failed", "err", err)
+	}
+}
+
+// run balances until SIGTERM. Failures are returned rather than fatal, so
+// the deferred calls detach the XDP program.
+func run() error {
+	iface := flag.String("iface", "", "interface clients reach the VIP through")
+	vip := flag.String("vip", "", "virtual address and port to balance, e.g. 10.0.0.100:8080")
+	backends := flag.String("backends", "", "comma-separated backend IPv4 addresses; adjustable at runtime via /backends")
+	mode := flag.String("mode", reuseportlb.XDPModeDNAT, "how flows reach backends: dnat (rewrite the destination, replies return through xlb) or dsr (L2 forward, backends answer directly)")
+	policy := flag.String("policy", "round-robin", "how new flows pick a backend: round-robin or least-load (by the loads posted to /backends)")
+	generic := flag.Bool("generic", false, "attach in generic XDP mode, for drivers without native XDP and veth pairs")
+	controlAddr := flag.String("control-addr", "127.0.0.1:9090", "address for the control API (backends, pprof, expvar)")
+	logLevel := flag.String("log-level", "info", "minimum log level: debug, info, warn or error")
+	logFormat := flag.String("log-format", "text", "log output format: text or json")
+	flag.Parse()
+
+	logger, err := reuseportlb.NewLogger(os.Stderr, *logLevel, *logFormat)
+	if err != nil {
+		return fmt.Errorf("invalid logging flags: %w", err)
+	}
+	slog.SetDefault(logger)
+
+	if *iface == "" {
+		return errors.New("-iface is required")
+	}
+	addr, err := netip.ParseAddrPort(*vip)
+	if err != nil {
+		return fmt.Errorf("invalid -vip: %w", err)
+	}
+	addrs, err := reuseportlb.ParseBackends(*backends)
+	if err != nil {
+		return fmt.Errorf("invalid -backends: %w", err)
+	}
+	if err := rlimit.RemoveMemlock(); err != nil {
+		slog.Warn("removing memlock failed", "err", err)
+	}
+
+	lb, err := reuseportlb.StartXDPBalancer(reuseportlb.XDPConfig{
+		Iface:    *iface,
+		VIP:      addr,
+		Mode:     *mode,
+		Policy:   *policy,
+		Backends: addrs,
+		Generic:  *generic,
+	})
+	if err != nil {
+		return fmt.Errorf("start XDP balancer: %w", err)
+	}
+	defer lb.Close()
+	slog.Info("balancing", "iface", *iface, "vip", addr, "mode", *mode, "policy", *policy, "backends", addrs)
+
+	mux := reuseportlb.NewAdminMux()
+	mux.Handle("/backends", lb)
+	control, err := reuseportlb.ServeAdmin(*controlAddr, mux)
+	if err != nil {
+		return fmt.Errorf("start control API on %s: %w", *controlAddr, err)
+	}
+	defer control.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	slog.Info("shutting down; flows in progress fall back to the kernel")
+	return nil
+}